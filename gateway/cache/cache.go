@@ -0,0 +1,131 @@
+// Package cache stores cached AI responses behind the Cache interface, with
+// Redis, in-memory and no-op backends. It has no dependency on the gateway's
+// HTTP server and can be embedded by other Go services.
+package cache
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"time"
+)
+
+// Backend names, as used by CACHE_BACKEND.
+const (
+	BackendRedis  = "redis"
+	BackendMemory = "memory"
+	BackendNone   = "none"
+)
+
+// ErrMiss is returned by Cache.Get for keys that are not cached.
+var ErrMiss = errors.New("cache miss")
+
+// Cache stores cached AI responses by key. Implementations must be safe for
+// concurrent use. A new backend (memcached, DynamoDB) implements Cache and is
+// selected by the gateway for its CACHE_BACKEND value.
+type Cache interface {
+	// Get returns the value stored under key, or ErrMiss.
+	Get(ctx context.Context, key string) ([]byte, error)
+	// Set stores value under key for ttl.
+	Set(ctx context.Context, key string, value []byte, ttl time.Duration) error
+	// Delete removes key, reporting whether it was cached.
+	Delete(ctx context.Context, key string) (bool, error)
+	// DeletePrefix removes every key starting with prefix and returns how
+	// many were removed, including those removed before an error.
+	DeletePrefix(ctx context.Context, prefix string) (int64, error)
+	// Generation returns the number of times BumpGeneration has been
+	// called, shared by every replica using the backend.
+	Generation(ctx context.Context) (int64, error)
+	// BumpGeneration increments the generation and returns the new value.
+	BumpGeneration(ctx context.Context) (int64, error)
+	// Stats returns the backend's counters since startup.
+	Stats() Stats
+}
+
+// Stats counts a cache backend's operations. Errors are failed operations
+// other than misses. Entries is only known for the in-memory backend.
+type Stats struct {
+	Backend string `json:"backend"`
+	Hits    int64  `json:"hits"`
+	Misses  int64  `json:"misses"`
+	Sets    int64  `json:"sets"`
+	Deletes int64  `json:"deletes"`
+	Errors  int64  `json:"errors"`
+	Entries int    `json:"entries,omitempty"`
+}
+
+// counters implements the counting shared by the backends.
+type counters struct {
+	hits, misses, sets, deletes, errors atomic.Int64
+}
+
+// countGet records the outcome of a Get.
+func (c *counters) countGet(err error) {
+	switch {
+	case err == nil:
+		c.hits.Add(1)
+	case errors.Is(err, ErrMiss):
+		c.misses.Add(1)
+	default:
+		c.errors.Add(1)
+	}
+}
+
+// count records the outcome of a Set or Delete in ok.
+func (c *counters) count(ok *atomic.Int64, err error) {
+	if err != nil {
+		c.errors.Add(1)
+		return
+	}
+	ok.Add(1)
+}
+
+func (c *counters) stats(backend string) Stats {
+	return Stats{
+		Backend: backend,
+		Hits:    c.hits.Load(),
+		Misses:  c.misses.Load(),
+		Sets:    c.sets.Load(),
+		Deletes: c.deletes.Load(),
+		Errors:  c.errors.Load(),
+	}
+}
+
+// Batch is implemented by backends that can read or write many keys in one
+// round trip.
+type Batch interface {
+	// GetMany returns the value of each key, nil for misses.
+	GetMany(ctx context.Context, keys []string) ([][]byte, error)
+	// SetMany stores values[i] under keys[i] for ttl.
+	SetMany(ctx context.Context, keys []string, values [][]byte, ttl time.Duration) error
+}
+
+// GetMany is Cache.Get for many keys, batched when c supports it. Misses and
+// failed reads are nil.
+func GetMany(ctx context.Context, c Cache, keys []string) ([][]byte, error) {
+	if batch, ok := c.(Batch); ok {
+		return batch.GetMany(ctx, keys)
+	}
+	values := make([][]byte, len(keys))
+	for i, key := range keys {
+		val, err := c.Get(ctx, key)
+		if err != nil && !errors.Is(err, ErrMiss) {
+			return values, err
+		}
+		values[i] = val
+	}
+	return values, nil
+}
+
+// SetMany is Cache.Set for many keys, batched when c supports it.
+func SetMany(ctx context.Context, c Cache, keys []string, values [][]byte, ttl time.Duration) error {
+	if batch, ok := c.(Batch); ok {
+		return batch.SetMany(ctx, keys, values, ttl)
+	}
+	for i, key := range keys {
+		if err := c.Set(ctx, key, values[i], ttl); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,125 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+)
+
+func TestMemoryCache(t *testing.T) {
+	ctx := context.Background()
+	c := NewMemory(10)
+
+	if _, err := c.Get(ctx, "missing"); !errors.Is(err, ErrMiss) {
+		t.Fatalf("expected a miss, got %v", err)
+	}
+	if err := c.Set(ctx, "k", []byte("v"), time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	if val, err := c.Get(ctx, "k"); err != nil || string(val) != "v" {
+		t.Fatalf("expected v, got %q, %v", val, err)
+	}
+
+	c.Set(ctx, "short", []byte("v"), time.Millisecond)
+	time.Sleep(5 * time.Millisecond)
+	if _, err := c.Get(ctx, "short"); !errors.Is(err, ErrMiss) {
+		t.Errorf("expected an expired entry to miss, got %v", err)
+	}
+
+	if deleted, _ := c.Delete(ctx, "k"); !deleted {
+		t.Error("expected the cached key to be deleted")
+	}
+	if deleted, _ := c.Delete(ctx, "k"); deleted {
+		t.Error("expected a second delete to find nothing")
+	}
+
+	stats := c.Stats()
+	if stats.Backend != BackendMemory || stats.Hits != 1 || stats.Misses != 2 || stats.Sets != 2 || stats.Deletes != 2 {
+		t.Errorf("unexpected stats %+v", stats)
+	}
+}
+
+func TestMemoryCache_EvictsWhenFull(t *testing.T) {
+	ctx := context.Background()
+	c := NewMemory(2)
+	for _, key := range []string{"a", "b", "c"} {
+		c.Set(ctx, key, []byte(key), time.Hour)
+	}
+	if n := c.Stats().Entries; n != 2 {
+		t.Errorf("expected the cache to stay at 2 entries, got %d", n)
+	}
+	if _, err := c.Get(ctx, "c"); err != nil {
+		t.Errorf("expected the newest entry to be kept, got %v", err)
+	}
+}
+
+func TestMemoryCache_PrefixAndGeneration(t *testing.T) {
+	ctx := context.Background()
+	c := NewMemory(10)
+	for _, key := range []string{"ai:summary:a", "ai:summary:b", "ai:embedding:c"} {
+		c.Set(ctx, key, []byte("v"), time.Hour)
+	}
+	if n, err := c.DeletePrefix(ctx, "ai:summary:"); err != nil || n != 2 {
+		t.Errorf("expected 2 keys purged, got %d, %v", n, err)
+	}
+	if _, err := c.Get(ctx, "ai:embedding:c"); err != nil {
+		t.Errorf("expected keys outside the prefix to be kept, got %v", err)
+	}
+
+	if n, _ := c.Generation(ctx); n != 0 {
+		t.Errorf("expected generation 0, got %d", n)
+	}
+	if n, _ := c.BumpGeneration(ctx); n != 1 {
+		t.Errorf("expected generation 1 after a bump, got %d", n)
+	}
+}
+
+func TestGetMany_FallsBackToGet(t *testing.T) {
+	ctx := context.Background()
+	c := NewMemory(10)
+	if err := SetMany(ctx, c, []string{"a", "b"}, [][]byte{[]byte("1"), []byte("2")}, time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	values, err := GetMany(ctx, c, []string{"a", "missing", "b"})
+	if err != nil || string(values[0]) != "1" || values[1] != nil || string(values[2]) != "2" {
+		t.Errorf("unexpected values %q, %v", values, err)
+	}
+}
+
+func TestGlobEscape(t *testing.T) {
+	if got := globEscape(`ai:sum*ary?[x]\`); got != `ai:sum\*ary\?\[x\]\\` {
+		t.Errorf("unexpected escaped pattern %q", got)
+	}
+}
+
+func TestRedis_PrefixAndGeneration(t *testing.T) {
+	ctx := context.Background()
+	client := redis.NewClient(&redis.Options{Addr: miniredis.RunT(t).Addr()})
+	c := NewRedis(func() redis.UniversalClient { return client }, "cache:generation")
+
+	for _, key := range []string{"ai:summary:a", "ai:summary:b", "ai:embedding:c"} {
+		if err := c.Set(ctx, key, []byte("v"), time.Hour); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n, err := c.DeletePrefix(ctx, "ai:summary:"); err != nil || n != 2 {
+		t.Errorf("expected 2 keys purged, got %d, %v", n, err)
+	}
+	if _, err := c.Get(ctx, "ai:summary:a"); !errors.Is(err, ErrMiss) {
+		t.Errorf("expected a purged key to miss, got %v", err)
+	}
+	if _, err := c.Get(ctx, "ai:embedding:c"); err != nil {
+		t.Errorf("expected keys outside the prefix to be kept, got %v", err)
+	}
+
+	if n, err := c.Generation(ctx); err != nil || n != 0 {
+		t.Errorf("expected generation 0 before any bump, got %d, %v", n, err)
+	}
+	if n, err := c.BumpGeneration(ctx); err != nil || n != 1 {
+		t.Errorf("expected generation 1 after a bump, got %d, %v", n, err)
+	}
+}
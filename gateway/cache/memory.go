@@ -0,0 +1,141 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Memory stores responses in process memory, holding at most
+// maxEntries. It is not shared between replicas.
+type Memory struct {
+	counters
+	mu         sync.Mutex
+	entries    map[string]memoryEntry
+	maxEntries int
+	generation atomic.Int64
+}
+
+type memoryEntry struct {
+	value   []byte
+	expires time.Time
+}
+
+// NewMemory returns a Memory backend holding at most maxEntries.
+func NewMemory(maxEntries int) *Memory {
+	return &Memory{entries: make(map[string]memoryEntry), maxEntries: maxEntries}
+}
+
+func (m *Memory) Get(ctx context.Context, key string) ([]byte, error) {
+	m.mu.Lock()
+	entry, ok := m.entries[key]
+	if ok && time.Now().After(entry.expires) {
+		delete(m.entries, key)
+		ok = false
+	}
+	m.mu.Unlock()
+	if !ok {
+		m.countGet(ErrMiss)
+		return nil, ErrMiss
+	}
+	m.countGet(nil)
+	return entry.value, nil
+}
+
+// Set makes room when the cache is full by dropping expired entries, then
+// an arbitrary one.
+func (m *Memory) Set(ctx context.Context, key string, value []byte, ttl time.Duration) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.entries[key]; !ok && len(m.entries) >= m.maxEntries {
+		now := time.Now()
+		for k, entry := range m.entries {
+			if now.After(entry.expires) {
+				delete(m.entries, k)
+			}
+		}
+		for k := range m.entries {
+			if len(m.entries) < m.maxEntries {
+				break
+			}
+			delete(m.entries, k)
+		}
+	}
+	m.entries[key] = memoryEntry{value: value, expires: time.Now().Add(ttl)}
+	m.sets.Add(1)
+	return nil
+}
+
+func (m *Memory) Delete(ctx context.Context, key string) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	entry, ok := m.entries[key]
+	delete(m.entries, key)
+	m.deletes.Add(1)
+	return ok && time.Now().Before(entry.expires), nil
+}
+
+func (m *Memory) DeletePrefix(ctx context.Context, prefix string) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var deleted int64
+	for key := range m.entries {
+		if strings.HasPrefix(key, prefix) {
+			delete(m.entries, key)
+			deleted++
+		}
+	}
+	m.deletes.Add(deleted)
+	return deleted, nil
+}
+
+func (m *Memory) Generation(ctx context.Context) (int64, error) {
+	return m.generation.Load(), nil
+}
+
+func (m *Memory) BumpGeneration(ctx context.Context) (int64, error) {
+	return m.generation.Add(1), nil
+}
+
+func (m *Memory) Stats() Stats {
+	stats := m.stats(BackendMemory)
+	m.mu.Lock()
+	stats.Entries = len(m.entries)
+	m.mu.Unlock()
+	return stats
+}
+
+// Noop caches nothing; every Get misses.
+type Noop struct {
+	counters
+}
+
+func (n *Noop) Get(ctx context.Context, key string) ([]byte, error) {
+	n.countGet(ErrMiss)
+	return nil, ErrMiss
+}
+
+func (n *Noop) Set(ctx context.Context, key string, value []byte, ttl time.Duration) error {
+	return nil
+}
+
+func (n *Noop) Delete(ctx context.Context, key string) (bool, error) {
+	return false, nil
+}
+
+func (n *Noop) DeletePrefix(ctx context.Context, prefix string) (int64, error) {
+	return 0, nil
+}
+
+func (n *Noop) Generation(ctx context.Context) (int64, error) {
+	return 0, nil
+}
+
+func (n *Noop) BumpGeneration(ctx context.Context) (int64, error) {
+	return 0, errors.New("no response cache is configured")
+}
+
+func (n *Noop) Stats() Stats { return n.stats(BackendNone) }
@@ -0,0 +1,166 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// Redis stores responses in a Redis connection shared with the rest of the
+// service.
+type Redis struct {
+	counters
+	client        func() redis.UniversalClient
+	generationKey string
+}
+
+// NewRedis returns a Redis backend. client is called for every operation so
+// the connection can be replaced at runtime; generationKey holds the
+// generation shared by every replica.
+func NewRedis(client func() redis.UniversalClient, generationKey string) *Redis {
+	return &Redis{client: client, generationKey: generationKey}
+}
+
+func (r *Redis) Get(ctx context.Context, key string) ([]byte, error) {
+	val, err := r.client().Get(ctx, key).Bytes()
+	if errors.Is(err, redis.Nil) {
+		err = ErrMiss
+	}
+	r.countGet(err)
+	return val, err
+}
+
+func (r *Redis) Set(ctx context.Context, key string, value []byte, ttl time.Duration) error {
+	err := r.client().Set(ctx, key, value, ttl).Err()
+	r.count(&r.sets, err)
+	return err
+}
+
+// Delete uses UNLINK so a large value is reclaimed in the background.
+func (r *Redis) Delete(ctx context.Context, key string) (bool, error) {
+	n, err := r.client().Unlink(ctx, key).Result()
+	r.count(&r.deletes, err)
+	return n > 0, err
+}
+
+// DeletePrefix uses SCAN so Redis is never blocked and UNLINK so memory is
+// reclaimed in the background. In a cluster every master is scanned.
+func (r *Redis) DeletePrefix(ctx context.Context, prefix string) (int64, error) {
+	client := r.client()
+	cluster, ok := client.(*redis.ClusterClient)
+	if !ok {
+		n, err := purgeNodePrefix(ctx, client, prefix)
+		r.deletes.Add(n)
+		return n, err
+	}
+	var deleted atomic.Int64
+	err := cluster.ForEachMaster(ctx, func(ctx context.Context, node *redis.Client) error {
+		n, err := purgeNodePrefix(ctx, node, prefix)
+		deleted.Add(n)
+		return err
+	})
+	r.deletes.Add(deleted.Load())
+	return deleted.Load(), err
+}
+
+// purgeNodePrefix deletes the keys starting with prefix on the node client
+// talks to. Keys are unlinked one by one in a pipeline, since a cluster
+// node refuses multi-key commands across slots.
+func purgeNodePrefix(ctx context.Context, client redis.UniversalClient, prefix string) (int64, error) {
+	match := globEscape(prefix) + "*"
+	var cursor uint64
+	var deleted int64
+	for {
+		keys, next, err := client.Scan(ctx, cursor, match, 500).Result()
+		if err != nil {
+			return deleted, err
+		}
+		if len(keys) > 0 {
+			pipe := client.Pipeline()
+			unlinks := make([]*redis.IntCmd, len(keys))
+			for i, key := range keys {
+				unlinks[i] = pipe.Unlink(ctx, key)
+			}
+			if _, err := pipe.Exec(ctx); err != nil {
+				return deleted, err
+			}
+			for _, unlink := range unlinks {
+				deleted += unlink.Val()
+			}
+		}
+		if cursor = next; cursor == 0 {
+			return deleted, nil
+		}
+	}
+}
+
+// globEscape escapes the Redis MATCH metacharacters in s.
+func globEscape(s string) string {
+	var b strings.Builder
+	for _, r := range s {
+		if strings.ContainsRune(`*?[]\`, r) {
+			b.WriteByte('\\')
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+// Generation reads the generation key; a missing key is generation 0.
+func (r *Redis) Generation(ctx context.Context) (int64, error) {
+	n, err := r.client().Get(ctx, r.generationKey).Int64()
+	if errors.Is(err, redis.Nil) {
+		return 0, nil
+	}
+	return n, err
+}
+
+func (r *Redis) BumpGeneration(ctx context.Context) (int64, error) {
+	return r.client().Incr(ctx, r.generationKey).Result()
+}
+
+// GetMany pipelines GETs rather than using MGET, since in a cluster the keys
+// span slots.
+func (r *Redis) GetMany(ctx context.Context, keys []string) ([][]byte, error) {
+	pipe := r.client().Pipeline()
+	gets := make([]*redis.StringCmd, len(keys))
+	for i, key := range keys {
+		gets[i] = pipe.Get(ctx, key)
+	}
+	values := make([][]byte, len(keys))
+	if _, err := pipe.Exec(ctx); err != nil && !errors.Is(err, redis.Nil) {
+		r.errors.Add(1)
+		return values, err
+	}
+	for i, get := range gets {
+		val, err := get.Bytes()
+		if errors.Is(err, redis.Nil) {
+			err = ErrMiss
+		}
+		r.countGet(err)
+		if err == nil {
+			values[i] = val
+		}
+	}
+	return values, nil
+}
+
+func (r *Redis) SetMany(ctx context.Context, keys []string, values [][]byte, ttl time.Duration) error {
+	pipe := r.client().Pipeline()
+	for i, key := range keys {
+		pipe.Set(ctx, key, values[i], ttl)
+	}
+	_, err := pipe.Exec(ctx)
+	if err != nil {
+		r.errors.Add(1)
+		return err
+	}
+	r.sets.Add(int64(len(keys)))
+	return nil
+}
+
+func (r *Redis) Stats() Stats { return r.stats(BackendRedis) }
@@ -0,0 +1,328 @@
+// Package client calls a MicroAI Paygate deployment from Go. It performs the
+// x402 exchange for the caller: it sends the request, signs the payment
+// context from the 402 challenge with a local key, retries with the payment
+// headers and verifies the receipt that comes back.
+package client
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"gateway/payments"
+	"gateway/receipts"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+// Client pays for and calls gateway endpoints.
+type Client struct {
+	// BaseURL is the gateway root, e.g. https://api.example.com.
+	BaseURL string
+	// Key signs payment contexts. Its address is the payer.
+	Key *ecdsa.PrivateKey
+	// HTTPClient defaults to http.DefaultClient when nil.
+	HTTPClient *http.Client
+	// TrustedServerKey, if set, pins the key receipts must be signed with.
+	// Without it receipts are only checked for integrity.
+	TrustedServerKey *ecdsa.PublicKey
+	// MaxAmount, if set, is the highest price (in token units, e.g. "0.01")
+	// the client will sign for. Dearer quotes fail with ErrPriceTooHigh.
+	MaxAmount string
+	// ChainID, if non-zero, is the only chain the client will sign for. It
+	// is picked from the chains the gateway offers.
+	ChainID int
+	// SponsorGrant, if set, has its sponsor pay instead of Key's address,
+	// which the grant must cover. SponsorSignature is the sponsor's
+	// signature of it, from payments.SignSponsorGrant.
+	SponsorGrant     *payments.SponsorGrant
+	SponsorSignature string
+}
+
+// New returns a client for the gateway at baseURL that pays with key.
+func New(baseURL string, key *ecdsa.PrivateKey) *Client {
+	return &Client{BaseURL: strings.TrimSuffix(baseURL, "/"), Key: key}
+}
+
+// Response is a paid response together with its verified receipt.
+type Response struct {
+	StatusCode int
+	Header     http.Header
+	Body       []byte
+	Payment    payments.Context
+	Receipt    *receipts.SignedReceipt
+}
+
+// APIError is returned for non-2xx responses other than the initial 402.
+// Code is the gateway's stable error code, such as "SIGNATURE_INVALID" or
+// "RATE_LIMITED", and is what callers should branch on; Title is the
+// human-readable "error" field. Reason is set when a payment signature was
+// refused, e.g. payments.ReasonWrongChain.
+type APIError struct {
+	StatusCode    int
+	Code          string `json:"code"`
+	Title         string `json:"error"`
+	Message       string `json:"message"`
+	Reason        string `json:"reason,omitempty"`
+	CorrelationID string `json:"correlation_id"`
+	DocsURL       string `json:"docs_url"`
+}
+
+func (e *APIError) Error() string {
+	if e.Message != "" {
+		return fmt.Sprintf("paygate: %d %s: %s", e.StatusCode, e.Title, e.Message)
+	}
+	return fmt.Sprintf("paygate: %d %s", e.StatusCode, e.Title)
+}
+
+// ErrPriceTooHigh is returned when the quoted amount exceeds MaxAmount.
+var ErrPriceTooHigh = errors.New("paygate: quoted price exceeds MaxAmount")
+
+// Address returns the payer address derived from Key.
+func (c *Client) Address() string {
+	return crypto.PubkeyToAddress(c.Key.PublicKey).Hex()
+}
+
+// Post sends body to path, paying for it if the gateway asks, and returns the
+// response once its receipt has been verified. The unsigned request carries
+// the body too, so the gateway can quote a price that depends on it.
+func (c *Client) Post(ctx context.Context, path string, body []byte) (*Response, error) {
+	resp, err := c.send(ctx, path, body, nil)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		// Free endpoint: nothing to pay and no receipt.
+		return resp, nil
+	}
+	if resp.StatusCode != http.StatusPaymentRequired {
+		return nil, apiError(resp)
+	}
+
+	var challenge struct {
+		PaymentContext payments.Context   `json:"paymentContext"`
+		Accepts        []payments.Context `json:"accepts"`
+	}
+	if err := json.Unmarshal(resp.Body, &challenge); err != nil {
+		return nil, fmt.Errorf("paygate: decode 402 challenge: %w", err)
+	}
+	payment := c.pickChain(challenge.PaymentContext, challenge.Accepts)
+	if err := c.checkQuote(payment); err != nil {
+		return nil, err
+	}
+
+	signature, err := payments.Sign(payment, c.Key)
+	if err != nil {
+		return nil, fmt.Errorf("paygate: %w", err)
+	}
+	headers := map[string]string{
+		"X-402-Signature": signature,
+		"X-402-Nonce":     payment.Nonce,
+		"X-402-Chain-Id":  strconv.Itoa(payment.ChainID),
+	}
+	if payment.QuoteSignature != "" {
+		// Echo the signed quote so the gateway charges the quoted price.
+		headers["X-402-Quote-Signature"] = payment.QuoteSignature
+		headers["X-402-Quote-Expiry"] = strconv.FormatInt(payment.Expiry, 10)
+	}
+	if c.SponsorGrant != nil {
+		grant, err := json.Marshal(c.SponsorGrant)
+		if err != nil {
+			return nil, fmt.Errorf("paygate: encode sponsor grant: %w", err)
+		}
+		headers["X-402-Sponsor-Grant"] = base64.StdEncoding.EncodeToString(grant)
+		headers["X-402-Sponsor-Signature"] = c.SponsorSignature
+	}
+	resp, err = c.send(ctx, path, body, headers)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return nil, apiError(resp)
+	}
+
+	resp.Payment = payment
+	if resp.Receipt, err = c.verifyReceipt(resp, payment); err != nil {
+		return nil, err
+	}
+	if err := c.verifyResponseSignature(resp); err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
+// Summarize calls /api/ai/summarize and returns the summary and its receipt.
+func (c *Client) Summarize(ctx context.Context, text string) (string, *receipts.SignedReceipt, error) {
+	body, err := json.Marshal(map[string]string{"text": text})
+	if err != nil {
+		return "", nil, err
+	}
+	resp, err := c.Post(ctx, "/api/ai/summarize", body)
+	if err != nil {
+		return "", nil, err
+	}
+	var out struct {
+		Result string `json:"result"`
+	}
+	if err := json.Unmarshal(resp.Body, &out); err != nil {
+		return "", nil, fmt.Errorf("paygate: decode response: %w", err)
+	}
+	return out.Result, resp.Receipt, nil
+}
+
+// pickChain returns the offered context for ChainID, or the gateway's
+// primary context when ChainID is unset or not offered.
+func (c *Client) pickChain(primary payments.Context, accepts []payments.Context) payments.Context {
+	if c.ChainID == 0 {
+		return primary
+	}
+	for _, offer := range accepts {
+		if offer.ChainID == c.ChainID {
+			return offer
+		}
+	}
+	return primary
+}
+
+// checkQuote refuses to sign for a chain or price the caller did not allow,
+// an amount payments.Context.ParseAmount rejects, or a signed quote that
+// TrustedServerKey did not sign.
+func (c *Client) checkQuote(payment payments.Context) error {
+	if c.ChainID != 0 && payment.ChainID != c.ChainID {
+		return fmt.Errorf("paygate: challenge is for chain %d, expected %d", payment.ChainID, c.ChainID)
+	}
+	if c.TrustedServerKey != nil && payment.QuoteSignature != "" {
+		signer, err := payments.RecoverQuoteSigner(payment)
+		if err != nil || signer != crypto.PubkeyToAddress(*c.TrustedServerKey) {
+			return fmt.Errorf("paygate: quote was not signed by the trusted server key")
+		}
+	}
+	quoted, err := payment.ParseAmount()
+	if err != nil {
+		return fmt.Errorf("paygate: invalid quoted amount %q: %w", payment.Amount, err)
+	}
+	if c.MaxAmount == "" {
+		return nil
+	}
+	limit, err := payments.ParseAmount(c.MaxAmount, quoted.Decimals)
+	if err != nil {
+		return fmt.Errorf("paygate: invalid MaxAmount %q: %w", c.MaxAmount, err)
+	}
+	if quoted.Units > limit.Units {
+		return fmt.Errorf("%w: %s > %s", ErrPriceTooHigh, payment.Amount, c.MaxAmount)
+	}
+	return nil
+}
+
+// verifyReceipt decodes the X-402-Receipt header and checks that it is
+// correctly signed and describes this payment and response body.
+func (c *Client) verifyReceipt(resp *Response, payment payments.Context) (*receipts.SignedReceipt, error) {
+	header := resp.Header.Get("X-402-Receipt")
+	if header == "" {
+		return nil, fmt.Errorf("paygate: response has no X-402-Receipt header")
+	}
+	raw, err := base64.StdEncoding.DecodeString(header)
+	if err != nil {
+		return nil, fmt.Errorf("paygate: decode receipt: %w", err)
+	}
+	var signed receipts.SignedReceipt
+	if err := json.Unmarshal(raw, &signed); err != nil {
+		return nil, fmt.Errorf("paygate: decode receipt: %w", err)
+	}
+	if err := receipts.Verify(&signed, c.TrustedServerKey); err != nil {
+		return nil, fmt.Errorf("paygate: %w", err)
+	}
+
+	r := signed.Receipt
+	switch {
+	case r.Payment.Nonce != payment.Nonce:
+		return nil, fmt.Errorf("paygate: receipt nonce %q does not match payment", r.Payment.Nonce)
+	case !sameAmount(r.Payment.Amount, payment):
+		return nil, fmt.Errorf("paygate: receipt amount %q does not match payment", r.Payment.Amount)
+	case !strings.EqualFold(r.Payment.Payer, c.Address()):
+		return nil, fmt.Errorf("paygate: receipt payer %s is not %s", r.Payment.Payer, c.Address())
+	case r.Service.ResponseHash != receipts.HashData(resp.Body):
+		return nil, fmt.Errorf("paygate: receipt response hash does not match body")
+	}
+	return &signed, nil
+}
+
+// verifyResponseSignature checks the X-402-Response-Signature header, when
+// the gateway sent one, against TrustedServerKey or else the key that signed
+// the receipt.
+func (c *Client) verifyResponseSignature(resp *Response) error {
+	sig := resp.Header.Get(receipts.ResponseSignatureHeader)
+	if sig == "" {
+		return nil
+	}
+	var server common.Address
+	if c.TrustedServerKey != nil {
+		server = crypto.PubkeyToAddress(*c.TrustedServerKey)
+	} else {
+		pub, err := crypto.UnmarshalPubkey(common.FromHex(resp.Receipt.ServerPublicKey))
+		if err != nil {
+			return fmt.Errorf("paygate: invalid receipt server key: %w", err)
+		}
+		server = crypto.PubkeyToAddress(*pub)
+	}
+	if err := receipts.VerifyResponse(resp.Body, resp.Header.Get("X-Correlation-ID"), sig, server); err != nil {
+		return fmt.Errorf("paygate: %w", err)
+	}
+	return nil
+}
+
+func (c *Client) send(ctx context.Context, path string, body []byte, headers map[string]string) (*Response, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.BaseURL+path, bytes.NewReader(body))
+	if err != nil {
+		return nil, fmt.Errorf("paygate: create request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+
+	httpClient := c.HTTPClient
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("paygate: %w", err)
+	}
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("paygate: read response: %w", err)
+	}
+	return &Response{StatusCode: resp.StatusCode, Header: resp.Header, Body: data}, nil
+}
+
+func apiError(resp *Response) error {
+	apiErr := &APIError{StatusCode: resp.StatusCode}
+	_ = json.Unmarshal(resp.Body, apiErr)
+	if apiErr.Title == "" {
+		apiErr.Title = http.StatusText(resp.StatusCode)
+	}
+	return apiErr
+}
+
+// sameAmount reports whether amount is the amount payment asks for,
+// however either is spelled.
+func sameAmount(amount string, payment payments.Context) bool {
+	want, err := payment.ParseAmount()
+	if err != nil {
+		return false
+	}
+	got, err := payments.ParseAmount(amount, want.Decimals)
+	return err == nil && got.Units == want.Units
+}
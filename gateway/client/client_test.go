@@ -0,0 +1,269 @@
+package client
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/http/httputil"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"gateway/payments"
+	"gateway/receipts"
+	"gateway/signing"
+
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+// fakeGateway answers like the gateway: a 402 challenge for unsigned
+// requests and a receipted summary once the payment signature checks out.
+func fakeGateway(t *testing.T, amount string, tamper func(*receipts.SignedReceipt)) (*httptest.Server, *receipts.SignedReceipt) {
+	t.Helper()
+	serverKey, err := crypto.GenerateKey()
+	if err != nil {
+		t.Fatalf("GenerateKey failed: %v", err)
+	}
+	payment := payments.Context{
+		Recipient: "0x2cAF48b4BA1C58721a85dFADa5aC01C2DFa62219",
+		Token:     "USDC",
+		Amount:    amount,
+		Nonce:     "nonce-1",
+		ChainID:   8453,
+		Expiry:    time.Now().Add(time.Minute).Unix(),
+	}
+	if payment.QuoteSignature, err = payments.SignQuote(payment, signing.FromKey(serverKey)); err != nil {
+		t.Fatalf("SignQuote failed: %v", err)
+	}
+	issued := &receipts.SignedReceipt{}
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		reqBody, _ := io.ReadAll(r.Body)
+		sig := r.Header.Get("X-402-Signature")
+		if sig == "" {
+			w.WriteHeader(http.StatusPaymentRequired)
+			json.NewEncoder(w).Encode(map[string]interface{}{"error": "Payment Required", "paymentContext": payment})
+			return
+		}
+		if r.Header.Get("X-402-Quote-Signature") != payment.QuoteSignature || r.Header.Get("X-402-Quote-Expiry") != strconv.FormatInt(payment.Expiry, 10) {
+			w.WriteHeader(http.StatusPaymentRequired)
+			json.NewEncoder(w).Encode(map[string]string{"error": "Quote Mismatch"})
+			return
+		}
+		payer, err := payments.RecoverSigner(payment, sig)
+		if err != nil || r.Header.Get("X-402-Nonce") != payment.Nonce {
+			w.WriteHeader(http.StatusForbidden)
+			json.NewEncoder(w).Encode(map[string]string{"error": "Invalid Signature"})
+			return
+		}
+
+		respBody, _ := json.Marshal(map[string]string{"result": "summary"})
+		signed, err := receipts.Generate(signing.FromKey(serverKey), payment, payer.Hex(), r.URL.Path, reqBody, respBody)
+		if err != nil {
+			t.Errorf("Generate failed: %v", err)
+		}
+		if tamper != nil {
+			tamper(signed)
+		}
+		*issued = *signed
+		raw, _ := json.Marshal(signed)
+		w.Header().Set("X-402-Receipt", base64.StdEncoding.EncodeToString(raw))
+		w.Header().Set("X-Correlation-ID", "corr-1")
+		respSig, _ := receipts.SignResponse(respBody, "corr-1", signing.FromKey(serverKey))
+		w.Header().Set(receipts.ResponseSignatureHeader, respSig)
+		w.Write(respBody)
+	}))
+	t.Cleanup(srv.Close)
+	return srv, issued
+}
+
+func newTestClient(t *testing.T, baseURL string) *Client {
+	t.Helper()
+	key, err := crypto.GenerateKey()
+	if err != nil {
+		t.Fatalf("GenerateKey failed: %v", err)
+	}
+	return New(baseURL, key)
+}
+
+func TestSummarize_PaysAndVerifiesReceipt(t *testing.T) {
+	srv, issued := fakeGateway(t, "0.001", nil)
+	c := newTestClient(t, srv.URL)
+
+	summary, receipt, err := c.Summarize(context.Background(), "hello")
+	if err != nil {
+		t.Fatalf("Summarize failed: %v", err)
+	}
+	if summary != "summary" {
+		t.Errorf("expected summary, got %q", summary)
+	}
+	if receipt.Receipt.ID != issued.Receipt.ID || !strings.EqualFold(receipt.Receipt.Payment.Payer, c.Address()) {
+		t.Errorf("unexpected receipt: %+v", receipt.Receipt)
+	}
+}
+
+func TestPost_RefusesQuoteAboveMaxAmount(t *testing.T) {
+	srv, _ := fakeGateway(t, "0.05", nil)
+	c := newTestClient(t, srv.URL)
+	c.MaxAmount = "0.01"
+
+	if _, _, err := c.Summarize(context.Background(), "hello"); !errors.Is(err, ErrPriceTooHigh) {
+		t.Errorf("expected ErrPriceTooHigh, got %v", err)
+	}
+}
+
+func TestPost_RefusesInvalidQuotedAmount(t *testing.T) {
+	for _, amount := range []string{"-0.001", "0", "0.0000001"} {
+		srv, _ := fakeGateway(t, amount, nil)
+		_, _, err := newTestClient(t, srv.URL).Summarize(context.Background(), "hello")
+		if err == nil || !strings.Contains(err.Error(), "invalid quoted amount") {
+			t.Errorf("expected quoted amount %q to be refused, got %v", amount, err)
+		}
+	}
+}
+
+func TestPost_RejectsBadReceipts(t *testing.T) {
+	cases := map[string]func(*receipts.SignedReceipt){
+		"altered after signing": func(s *receipts.SignedReceipt) { s.Receipt.Payment.Amount = "0" },
+		"wrong response hash": func(s *receipts.SignedReceipt) {
+			s.Receipt.Service.ResponseHash = receipts.HashData([]byte("other"))
+		},
+	}
+	for name, tamper := range cases {
+		t.Run(name, func(t *testing.T) {
+			srv, _ := fakeGateway(t, "0.001", tamper)
+			if _, _, err := newTestClient(t, srv.URL).Summarize(context.Background(), "hello"); err == nil {
+				t.Error("expected receipt verification to fail")
+			}
+		})
+	}
+
+	srv, _ := fakeGateway(t, "0.001", nil)
+	c := newTestClient(t, srv.URL)
+	other, _ := crypto.GenerateKey()
+	c.TrustedServerKey = &other.PublicKey
+	if _, _, err := c.Summarize(context.Background(), "hello"); err == nil {
+		t.Error("expected receipt from an untrusted server key to be rejected")
+	}
+}
+
+func TestPost_VerifiesResponseSignature(t *testing.T) {
+	srv, _ := fakeGateway(t, "0.001", nil)
+	other, _ := crypto.GenerateKey()
+	// A proxy that re-signs responses with its own key.
+	target, _ := url.Parse(srv.URL)
+	proxy := httputil.NewSingleHostReverseProxy(target)
+	proxy.ModifyResponse = func(resp *http.Response) error {
+		if resp.StatusCode == http.StatusOK {
+			sig, _ := receipts.SignResponse([]byte(`{"result":"summary"}`), "corr-1", signing.FromKey(other))
+			resp.Header.Set(receipts.ResponseSignatureHeader, sig)
+		}
+		return nil
+	}
+	proxied := httptest.NewServer(proxy)
+	t.Cleanup(proxied.Close)
+
+	if _, _, err := newTestClient(t, proxied.URL).Summarize(context.Background(), "hello"); err == nil || !strings.Contains(err.Error(), "response was signed by") {
+		t.Errorf("expected a response signed by another key to be rejected, got %v", err)
+	}
+}
+
+func TestPost_RejectsQuoteFromUntrustedKey(t *testing.T) {
+	srv, _ := fakeGateway(t, "0.001", nil)
+	c := newTestClient(t, srv.URL)
+	other, _ := crypto.GenerateKey()
+	c.TrustedServerKey = &other.PublicKey
+
+	_, _, err := c.Summarize(context.Background(), "hello")
+	if err == nil || !strings.Contains(err.Error(), "quote was not signed") {
+		t.Errorf("expected the quote signature to be checked before paying, got %v", err)
+	}
+}
+
+func TestPost_ReturnsAPIError(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusTooManyRequests)
+		w.Write([]byte(`{"code":"RATE_LIMITED","error":"Too Many Requests","message":"slow down","correlation_id":"cid-1"}`))
+	}))
+	defer srv.Close()
+
+	_, err := newTestClient(t, srv.URL).Post(context.Background(), "/api/ai/summarize", []byte(`{}`))
+	var apiErr *APIError
+	if !errors.As(err, &apiErr) || apiErr.StatusCode != 429 || apiErr.Code != "RATE_LIMITED" || apiErr.Message != "slow down" || apiErr.CorrelationID != "cid-1" {
+		t.Errorf("expected APIError 429, got %v", err)
+	}
+}
+
+func TestPost_PaysOnConfiguredChain(t *testing.T) {
+	offer := func(chainID int, recipient string) payments.Context {
+		return payments.Context{Recipient: recipient, Token: "USDC", Amount: "0.001", Nonce: "nonce-1", ChainID: chainID}
+	}
+	accepts := []payments.Context{
+		offer(8453, "0x2cAF48b4BA1C58721a85dFADa5aC01C2DFa62219"),
+		offer(10, "0x1111111111111111111111111111111111111111"),
+	}
+	var chainHeader, sig string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if sig = r.Header.Get("X-402-Signature"); sig == "" {
+			w.WriteHeader(http.StatusPaymentRequired)
+			json.NewEncoder(w).Encode(map[string]interface{}{"paymentContext": accepts[0], "accepts": accepts})
+			return
+		}
+		chainHeader = r.Header.Get("X-402-Chain-Id")
+		w.WriteHeader(http.StatusForbidden)
+		w.Write([]byte(`{"error":"Invalid Signature"}`))
+	}))
+	defer srv.Close()
+
+	c := newTestClient(t, srv.URL)
+	c.ChainID = 10
+	c.Summarize(context.Background(), "hello")
+
+	if chainHeader != "10" {
+		t.Fatalf("expected X-402-Chain-Id 10, got %q", chainHeader)
+	}
+	payer, err := payments.RecoverSigner(accepts[1], sig)
+	if err != nil || payer.Hex() != c.Address() {
+		t.Errorf("expected the Optimism context to be signed, recovered %v (%v)", payer, err)
+	}
+}
+
+func TestPost_SendsSponsorGrant(t *testing.T) {
+	var grantHeader, sponsorSig string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-402-Signature") == "" {
+			w.WriteHeader(http.StatusPaymentRequired)
+			json.NewEncoder(w).Encode(map[string]interface{}{"paymentContext": payments.Context{
+				Recipient: "0x2cAF48b4BA1C58721a85dFADa5aC01C2DFa62219", Token: "USDC", Amount: "0.001", Nonce: "nonce-1", ChainID: 8453,
+			}})
+			return
+		}
+		grantHeader, sponsorSig = r.Header.Get("X-402-Sponsor-Grant"), r.Header.Get("X-402-Sponsor-Signature")
+		w.WriteHeader(http.StatusForbidden)
+		w.Write([]byte(`{"error":"Invalid Sponsor Grant"}`))
+	}))
+	defer srv.Close()
+
+	c := newTestClient(t, srv.URL)
+	c.SponsorGrant = &payments.SponsorGrant{ID: "grant-1", Sponsor: "0x1111111111111111111111111111111111111111", Users: []string{c.Address()}, MaxAmount: "0.01", ChainID: 8453, Expiry: 1900000000}
+	c.SponsorSignature = "0xsponsor"
+	c.Summarize(context.Background(), "hello")
+
+	data, err := base64.StdEncoding.DecodeString(grantHeader)
+	if err != nil {
+		t.Fatalf("X-402-Sponsor-Grant is not base64: %q", grantHeader)
+	}
+	var sent payments.SponsorGrant
+	if err := json.Unmarshal(data, &sent); err != nil || sent.ID != "grant-1" || !sent.Covers(c.Address()) {
+		t.Errorf("expected the grant to be sent, got %s (%v)", data, err)
+	}
+	if sponsorSig != "0xsponsor" {
+		t.Errorf("expected the sponsor signature, got %q", sponsorSig)
+	}
+}
@@ -0,0 +1,8 @@
+// Command gateway runs the MicroAI Paygate HTTP gateway.
+package main
+
+import "gateway/server"
+
+func main() {
+	server.Main()
+}
@@ -0,0 +1,5 @@
+// Package testsupport provides in-process fakes of the gateway's external
+// dependencies (the Rust verifier and the OpenRouter API) plus a harness that
+// wires them into a full gateway router, so the payment flow can be tested
+// end to end with plain `go test` and no running services.
+package testsupport
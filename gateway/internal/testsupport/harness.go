@@ -0,0 +1,83 @@
+package testsupport
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// TestPrivateKey is a throwaway server wallet key for signing receipts in
+// tests. Never use it outside tests.
+const TestPrivateKey = "0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef"
+
+// Harness runs a gateway router against a MockVerifier and FakeOpenRouter.
+type Harness struct {
+	Verifier *MockVerifier
+	AI       *FakeOpenRouter
+	Server   *httptest.Server
+}
+
+// NewHarness starts the fakes, points the gateway environment at them and
+// serves the router returned by newRouter. newRouter is called after the
+// environment is set so it sees the fake URLs. Everything is torn down when
+// the test ends.
+func NewHarness(t *testing.T, newRouter func() http.Handler) *Harness {
+	t.Helper()
+	h := &Harness{
+		Verifier: NewMockVerifier(t),
+		AI:       NewFakeOpenRouter(t),
+	}
+
+	t.Setenv("VERIFIER_URL", h.Verifier.URL)
+	t.Setenv("OPENROUTER_URL", h.AI.URL)
+	t.Setenv("OPENROUTER_EMBEDDINGS_URL", h.AI.URL+"/api/v1/embeddings")
+	t.Setenv("OPENROUTER_MODELS_URL", h.AI.URL+"/api/v1/models")
+	t.Setenv("OPENROUTER_API_KEY", "test-key")
+	t.Setenv("SERVER_WALLET_PRIVATE_KEY", TestPrivateKey)
+
+	h.Server = httptest.NewServer(newRouter())
+	t.Cleanup(h.Server.Close)
+	return h
+}
+
+// Post sends a JSON POST to the gateway with optional x402 payment headers.
+// Empty signature or nonce values are omitted.
+func (h *Harness) Post(t *testing.T, path, body, signature, nonce string) *http.Response {
+	t.Helper()
+	return h.PostContent(t, path, "application/json", body, signature, nonce)
+}
+
+// PostContent is Post with a body of any content type.
+func (h *Harness) PostContent(t *testing.T, path, contentType, body, signature, nonce string) *http.Response {
+	t.Helper()
+	req, err := http.NewRequest(http.MethodPost, h.Server.URL+path, bytes.NewBufferString(body))
+	if err != nil {
+		t.Fatalf("build request: %v", err)
+	}
+	req.Header.Set("Content-Type", contentType)
+	if signature != "" {
+		req.Header.Set("X-402-Signature", signature)
+	}
+	if nonce != "" {
+		req.Header.Set("X-402-Nonce", nonce)
+	}
+
+	resp, err := h.Server.Client().Do(req)
+	if err != nil {
+		t.Fatalf("POST %s: %v", path, err)
+	}
+	t.Cleanup(func() { resp.Body.Close() })
+	return resp
+}
+
+// Get sends a GET request to the gateway.
+func (h *Harness) Get(t *testing.T, path string) *http.Response {
+	t.Helper()
+	resp, err := h.Server.Client().Get(h.Server.URL + path)
+	if err != nil {
+		t.Fatalf("GET %s: %v", path, err)
+	}
+	t.Cleanup(func() { resp.Body.Close() })
+	return resp
+}
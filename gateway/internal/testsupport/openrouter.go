@@ -0,0 +1,240 @@
+package testsupport
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// FakeOpenRouter is an httptest server that answers chat completion requests
+// with a canned reply and POST .../embeddings with deterministic vectors
+// derived from each input. GET /api/v1/models succeeds so readiness checks
+// pass, listing the models set with SetCatalog.
+type FakeOpenRouter struct {
+	*httptest.Server
+
+	mu        sync.Mutex
+	reply     string
+	cost      float64
+	tokens    [2]int
+	status    int
+	delay     time.Duration
+	models    []string
+	catalog   []CatalogModel
+	requests  []map[string]interface{}
+	callCount atomic.Int32
+
+	dimensions  int
+	embedInputs []string
+	embedCalls  atomic.Int32
+}
+
+// NewFakeOpenRouter starts a fake OpenRouter that is closed when the test ends.
+func NewFakeOpenRouter(t testing.TB) *FakeOpenRouter {
+	t.Helper()
+	f := &FakeOpenRouter{reply: "This is a fake summary.", status: http.StatusOK, dimensions: 4}
+	f.Server = httptest.NewServer(http.HandlerFunc(f.serveHTTP))
+	t.Cleanup(f.Close)
+	return f
+}
+
+// SetReply changes the completion content returned to the gateway.
+func (f *FakeOpenRouter) SetReply(reply string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.reply = reply
+}
+
+// SetCost sets the USD cost reported in the usage block of each reply.
+func (f *FakeOpenRouter) SetCost(cost float64) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.cost = cost
+}
+
+// SetUsage sets the prompt and completion token counts reported in the usage
+// block of each reply; embeddings report only the prompt tokens.
+func (f *FakeOpenRouter) SetUsage(promptTokens, completionTokens int) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.tokens = [2]int{promptTokens, completionTokens}
+}
+
+// SetStatus forces the HTTP status of completion responses.
+func (f *FakeOpenRouter) SetStatus(status int) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.status = status
+}
+
+// SetDelay delays every completion response, for timeout tests.
+func (f *FakeOpenRouter) SetDelay(d time.Duration) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.delay = d
+}
+
+// CatalogModel is an entry of the fake model list. Prices are USD per token.
+type CatalogModel struct {
+	ID              string
+	ContextLength   int
+	PromptPrice     string
+	CompletionPrice string
+}
+
+// SetCatalog replaces the models listed by GET /api/v1/models.
+func (f *FakeOpenRouter) SetCatalog(models ...CatalogModel) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.catalog = models
+}
+
+// SetDimensions changes the length of returned embedding vectors.
+func (f *FakeOpenRouter) SetDimensions(n int) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.dimensions = n
+}
+
+// EmbedCalls returns the number of embeddings requests received.
+func (f *FakeOpenRouter) EmbedCalls() int { return int(f.embedCalls.Load()) }
+
+// EmbedInputs returns every input sent to the embeddings API, in order.
+func (f *FakeOpenRouter) EmbedInputs() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]string(nil), f.embedInputs...)
+}
+
+// FakeEmbedding is the vector FakeOpenRouter returns for input.
+func FakeEmbedding(input string, dimensions int) []float64 {
+	sum := sha256.Sum256([]byte(input))
+	v := make([]float64, dimensions)
+	for i := range v {
+		v[i] = float64(sum[i%len(sum)]) / 255
+	}
+	return v
+}
+
+// Calls returns the number of completion requests received.
+func (f *FakeOpenRouter) Calls() int { return int(f.callCount.Load()) }
+
+// Requests returns the decoded body of each completion request, in order.
+func (f *FakeOpenRouter) Requests() []map[string]interface{} {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]map[string]interface{}(nil), f.requests...)
+}
+
+// Models returns the model named in each completion request, in order.
+func (f *FakeOpenRouter) Models() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]string(nil), f.models...)
+}
+
+func (f *FakeOpenRouter) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if r.Method == http.MethodGet && r.URL.Path == "/api/v1/models" {
+		f.serveModels(w)
+		return
+	}
+	if r.Method != http.MethodPost {
+		http.NotFound(w, r)
+		return
+	}
+	if strings.HasSuffix(r.URL.Path, "/embeddings") {
+		f.serveEmbeddings(w, r)
+		return
+	}
+
+	f.callCount.Add(1)
+	var body map[string]interface{}
+	_ = json.NewDecoder(r.Body).Decode(&body)
+	model, _ := body["model"].(string)
+
+	f.mu.Lock()
+	f.models = append(f.models, model)
+	f.requests = append(f.requests, body)
+	reply, cost, tokens, status, delay := f.reply, f.cost, f.tokens, f.status, f.delay
+	f.mu.Unlock()
+
+	if delay > 0 {
+		select {
+		case <-time.After(delay):
+		case <-r.Context().Done():
+			return
+		}
+	}
+
+	w.WriteHeader(status)
+	if status != http.StatusOK {
+		_, _ = w.Write([]byte(`{"error":{"message":"fake provider error"}}`))
+		return
+	}
+	_ = json.NewEncoder(w).Encode(map[string]interface{}{
+		"model": model,
+		"choices": []map[string]interface{}{
+			{"message": map[string]string{"role": "assistant", "content": reply}},
+		},
+		"usage": map[string]float64{"cost": cost, "prompt_tokens": float64(tokens[0]), "completion_tokens": float64(tokens[1])},
+	})
+}
+
+func (f *FakeOpenRouter) serveModels(w http.ResponseWriter) {
+	f.mu.Lock()
+	data := make([]map[string]interface{}, len(f.catalog))
+	for i, m := range f.catalog {
+		data[i] = map[string]interface{}{
+			"id":             m.ID,
+			"context_length": m.ContextLength,
+			"pricing":        map[string]string{"prompt": m.PromptPrice, "completion": m.CompletionPrice},
+		}
+	}
+	f.mu.Unlock()
+	_ = json.NewEncoder(w).Encode(map[string]interface{}{"data": data})
+}
+
+func (f *FakeOpenRouter) serveEmbeddings(w http.ResponseWriter, r *http.Request) {
+	f.embedCalls.Add(1)
+	var req struct {
+		Model string   `json:"model"`
+		Input []string `json:"input"`
+	}
+	_ = json.NewDecoder(r.Body).Decode(&req)
+
+	f.mu.Lock()
+	f.models = append(f.models, req.Model)
+	f.embedInputs = append(f.embedInputs, req.Input...)
+	cost, tokens, status, delay, dims := f.cost, f.tokens, f.status, f.delay, f.dimensions
+	f.mu.Unlock()
+
+	if delay > 0 {
+		select {
+		case <-time.After(delay):
+		case <-r.Context().Done():
+			return
+		}
+	}
+
+	w.WriteHeader(status)
+	if status != http.StatusOK {
+		_, _ = w.Write([]byte(`{"error":{"message":"fake provider error"}}`))
+		return
+	}
+	data := make([]map[string]interface{}, len(req.Input))
+	for i, input := range req.Input {
+		data[i] = map[string]interface{}{"index": i, "embedding": FakeEmbedding(input, dims)}
+	}
+	_ = json.NewEncoder(w).Encode(map[string]interface{}{
+		"model": req.Model,
+		"data":  data,
+		"usage": map[string]float64{"cost": cost, "prompt_tokens": float64(tokens[0])},
+	})
+}
@@ -0,0 +1,170 @@
+package testsupport
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"gateway/payments"
+)
+
+// DefaultPayer is the address the mock verifier reports as the signer of
+// valid payments unless overridden with SetValid.
+const DefaultPayer = "0x742d35Cc6634C0532925a3b844Bc9e7595f8fE21"
+
+// VerifyRequest mirrors the body the gateway sends to POST /verify.
+type VerifyRequest struct {
+	Context struct {
+		Recipient string `json:"recipient"`
+		Token     string `json:"token"`
+		Amount    string `json:"amount"`
+		Nonce     string `json:"nonce"`
+		ChainID   int    `json:"chainId"`
+	} `json:"context"`
+	Signature string `json:"signature"`
+}
+
+// MockVerifier is an httptest server that speaks the verifier protocol.
+// By default every signature is accepted; use SetInvalid, SetStatus and
+// SetDelay to simulate rejections, outages and slow responses.
+type MockVerifier struct {
+	*httptest.Server
+
+	mu        sync.Mutex
+	valid     bool
+	payer     string
+	reason    string
+	status    int
+	delay     time.Duration
+	requests  []VerifyRequest
+	secret    []byte
+	seenIDs   map[string]bool
+	callCount atomic.Int32
+}
+
+// NewMockVerifier starts a mock verifier that is closed when the test ends.
+func NewMockVerifier(t testing.TB) *MockVerifier {
+	t.Helper()
+	m := &MockVerifier{valid: true, payer: DefaultPayer, status: http.StatusOK}
+	m.Server = httptest.NewServer(http.HandlerFunc(m.serveHTTP))
+	t.Cleanup(m.Close)
+	return m
+}
+
+// SetValid makes the verifier accept signatures and report payer as signer.
+func (m *MockVerifier) SetValid(payer string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.valid, m.payer, m.reason = true, payer, ""
+}
+
+// SetInvalid makes the verifier reject signatures with the given reason.
+func (m *MockVerifier) SetInvalid(reason string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.valid, m.reason = false, reason
+}
+
+// SetStatus forces the HTTP status of /verify responses (e.g. 500).
+func (m *MockVerifier) SetStatus(status int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.status = status
+}
+
+// SetDelay delays every /verify response, for timeout tests.
+func (m *MockVerifier) SetDelay(d time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.delay = d
+}
+
+// RequireSignature makes the verifier answer 401 to requests not signed with
+// secret (see payments.SignRequest) or replaying a request ID.
+func (m *MockVerifier) RequireSignature(secret string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.secret, m.seenIDs = []byte(secret), make(map[string]bool)
+}
+
+// Calls returns the number of /verify requests received.
+func (m *MockVerifier) Calls() int { return int(m.callCount.Load()) }
+
+// Requests returns a copy of every decoded /verify request received.
+func (m *MockVerifier) Requests() []VerifyRequest {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return append([]VerifyRequest(nil), m.requests...)
+}
+
+func (m *MockVerifier) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	switch r.URL.Path {
+	case "/health":
+		w.WriteHeader(http.StatusOK)
+		return
+	case "/verify":
+	default:
+		http.NotFound(w, r)
+		return
+	}
+
+	m.callCount.Add(1)
+	body, _ := io.ReadAll(r.Body)
+	if !m.authenticate(r, body) {
+		http.Error(w, "unauthenticated", http.StatusUnauthorized)
+		return
+	}
+	var req VerifyRequest
+	if err := json.NewDecoder(bytes.NewReader(body)).Decode(&req); err != nil {
+		http.Error(w, "invalid verification request", http.StatusBadRequest)
+		return
+	}
+
+	m.mu.Lock()
+	m.requests = append(m.requests, req)
+	valid, payer, reason, status, delay := m.valid, m.payer, m.reason, m.status, m.delay
+	m.mu.Unlock()
+
+	if delay > 0 {
+		select {
+		case <-time.After(delay):
+		case <-r.Context().Done():
+			return
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if status != http.StatusOK {
+		return
+	}
+	resp := map[string]interface{}{"is_valid": valid, "recovered_address": "", "error": reason}
+	if valid {
+		resp["recovered_address"] = payer
+	}
+	_ = json.NewEncoder(w).Encode(resp)
+}
+
+// authenticate checks the request signature once RequireSignature is set.
+func (m *MockVerifier) authenticate(r *http.Request, body []byte) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.secret == nil {
+		return true
+	}
+	if payments.CheckRequestSignature(r.Header, r.Method, r.URL.Path, body, m.secret, 30*time.Second, time.Now()) != nil {
+		return false
+	}
+	id := r.Header.Get(payments.HeaderRequestID)
+	if m.seenIDs[id] {
+		return false
+	}
+	m.seenIDs[id] = true
+	return true
+}
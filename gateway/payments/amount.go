@@ -0,0 +1,121 @@
+package payments
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// USDCDecimals is the number of decimal places of USDC.
+const USDCDecimals = 6
+
+// tokenDecimals maps the symbols of the tokens payments are accepted in to
+// their number of decimal places.
+var tokenDecimals = map[string]int{"USDC": USDCDecimals}
+
+// TokenDecimals returns the number of decimal places of token.
+func TokenDecimals(token string) (int, bool) {
+	d, ok := tokenDecimals[token]
+	return d, ok
+}
+
+// MaxAmount is the largest amount, in whole tokens, a payment context may
+// ask for. Larger amounts are almost certainly a misconfigured price.
+const MaxAmount = 1_000_000
+
+// Amount is a non-negative token amount held as an integer number of the
+// token's smallest unit (micro-USDC for USDC), so arithmetic on it is exact.
+type Amount struct {
+	Units    int64
+	Decimals int
+}
+
+// ParseAmount parses a decimal amount such as "0.001" for a token with
+// decimals places. It rejects signs, exponents, more precision than the
+// token supports and values that overflow int64 units.
+func ParseAmount(s string, decimals int) (Amount, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return Amount{}, fmt.Errorf("amount is empty")
+	}
+	whole, frac, _ := strings.Cut(s, ".")
+	if whole == "" {
+		whole = "0"
+	}
+	for _, part := range []string{whole, frac} {
+		for _, r := range part {
+			if r < '0' || r > '9' {
+				return Amount{}, fmt.Errorf("amount %q is not a non-negative decimal number", s)
+			}
+		}
+	}
+	if len(frac) > decimals {
+		return Amount{}, fmt.Errorf("amount %q has more than %d decimal places", s, decimals)
+	}
+	units, err := strconv.ParseInt(whole+frac+strings.Repeat("0", decimals-len(frac)), 10, 64)
+	if err != nil {
+		return Amount{}, fmt.Errorf("amount %q is out of range", s)
+	}
+	return Amount{Units: units, Decimals: decimals}, nil
+}
+
+// String renders the amount in canonical form: no leading zeros in the
+// whole part and no trailing zeros in the fraction, e.g. "0.0015" or "2".
+func (a Amount) String() string {
+	units, sign := a.Units, ""
+	if units < 0 {
+		sign, units = "-", -units
+	}
+	if a.Decimals <= 0 {
+		return sign + strconv.FormatInt(units, 10)
+	}
+	s := fmt.Sprintf("%0*d", a.Decimals+1, units)
+	whole, frac := s[:len(s)-a.Decimals], strings.TrimRight(s[len(s)-a.Decimals:], "0")
+	if frac == "" {
+		return sign + whole
+	}
+	return sign + whole + "." + frac
+}
+
+// CanonicalAmount returns amount of token in canonical form, or amount
+// unchanged when it does not parse.
+func CanonicalAmount(amount, token string) string {
+	decimals, ok := TokenDecimals(token)
+	if !ok {
+		return amount
+	}
+	a, err := ParseAmount(amount, decimals)
+	if err != nil {
+		return amount
+	}
+	return a.String()
+}
+
+// ParseAmount validates the amount the context asks for against its token:
+// it must be a supported token, a positive decimal within the token's
+// precision and at most MaxAmount whole tokens.
+func (p Context) ParseAmount() (Amount, error) {
+	decimals, ok := TokenDecimals(p.Token)
+	if !ok {
+		return Amount{}, fmt.Errorf("unsupported token %q", p.Token)
+	}
+	a, err := ParseAmount(p.Amount, decimals)
+	if err != nil {
+		return Amount{}, err
+	}
+	if a.Units <= 0 {
+		return Amount{}, fmt.Errorf("amount %q must be positive", p.Amount)
+	}
+	if a.Units > MaxAmount*pow10(decimals) {
+		return Amount{}, fmt.Errorf("amount %q exceeds the maximum of %d %s", p.Amount, MaxAmount, p.Token)
+	}
+	return a, nil
+}
+
+func pow10(n int) int64 {
+	p := int64(1)
+	for range n {
+		p *= 10
+	}
+	return p
+}
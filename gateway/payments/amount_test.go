@@ -0,0 +1,80 @@
+package payments
+
+import "testing"
+
+func TestParseAmount(t *testing.T) {
+	cases := []struct {
+		in      string
+		want    int64
+		wantErr bool
+	}{
+		{"0.001", 1000, false},
+		{"1", 1000000, false},
+		{"001.500", 1500000, false},
+		{".25", 250000, false},
+		{"0.000001", 1, false},
+		{"0.0000001", 0, true},
+		{"-1", 0, true},
+		{"+1", 0, true},
+		{"1e-3", 0, true},
+		{"1.2.3", 0, true},
+		{"99999999999999999999", 0, true},
+		{"", 0, true},
+	}
+	for _, tc := range cases {
+		got, err := ParseAmount(tc.in, USDCDecimals)
+		if (err != nil) != tc.wantErr {
+			t.Errorf("ParseAmount(%q) error = %v, wantErr %v", tc.in, err, tc.wantErr)
+			continue
+		}
+		if got.Units != tc.want {
+			t.Errorf("ParseAmount(%q) = %d, want %d", tc.in, got.Units, tc.want)
+		}
+	}
+}
+
+func TestAmountString(t *testing.T) {
+	for in, want := range map[string]string{
+		"0.001":    "0.001",
+		"0.00100":  "0.001",
+		"001.5":    "1.5",
+		"2.000000": "2",
+		".25":      "0.25",
+		"0":        "0",
+	} {
+		a, err := ParseAmount(in, USDCDecimals)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := a.String(); got != want {
+			t.Errorf("ParseAmount(%q).String() = %q, want %q", in, got, want)
+		}
+	}
+	if got := (Amount{Units: -1500, Decimals: USDCDecimals}).String(); got != "-0.0015" {
+		t.Errorf("negative amount rendered as %q", got)
+	}
+	if got := CanonicalAmount("0.0010", "USDC"); got != "0.001" {
+		t.Errorf("CanonicalAmount = %q, want 0.001", got)
+	}
+	if got := CanonicalAmount("0.0010", "DAI"); got != "0.0010" {
+		t.Errorf("expected an unknown token's amount unchanged, got %q", got)
+	}
+}
+
+func TestContextParseAmount(t *testing.T) {
+	ok := []string{"0.001", "0.000001", "1000000"}
+	bad := []string{"0", "0.000", "-0.001", "0.0000001", "1000000.000001", "abc"}
+	for _, amount := range ok {
+		if _, err := (Context{Token: "USDC", Amount: amount}).ParseAmount(); err != nil {
+			t.Errorf("expected %q to be accepted, got %v", amount, err)
+		}
+	}
+	for _, amount := range bad {
+		if _, err := (Context{Token: "USDC", Amount: amount}).ParseAmount(); err == nil {
+			t.Errorf("expected %q to be rejected", amount)
+		}
+	}
+	if _, err := (Context{Token: "DAI", Amount: "1"}).ParseAmount(); err == nil {
+		t.Error("expected an unsupported token to be rejected")
+	}
+}
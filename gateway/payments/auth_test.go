@@ -0,0 +1,74 @@
+package payments
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestSignAndCheckRequest(t *testing.T) {
+	secret := []byte("shared-secret")
+	body := []byte(`{"signature":"0xsig"}`)
+	now := time.Unix(1900000000, 0)
+	req := httptest.NewRequest(http.MethodPost, "http://verifier/verify", bytes.NewReader(body))
+	if err := SignRequest(req, body, secret, now); err != nil {
+		t.Fatalf("SignRequest failed: %v", err)
+	}
+
+	if err := CheckRequestSignature(req.Header, "POST", "/verify", body, secret, 30*time.Second, now.Add(10*time.Second)); err != nil {
+		t.Fatalf("expected a valid signature, got %v", err)
+	}
+
+	cases := map[string]func() error{
+		"wrong secret": func() error {
+			return CheckRequestSignature(req.Header, "POST", "/verify", body, []byte("other"), 30*time.Second, now)
+		},
+		"tampered body": func() error {
+			return CheckRequestSignature(req.Header, "POST", "/verify", []byte(`{"signature":"0xother"}`), secret, 30*time.Second, now)
+		},
+		"other path": func() error {
+			return CheckRequestSignature(req.Header, "POST", "/health", body, secret, 30*time.Second, now)
+		},
+		"stale": func() error {
+			return CheckRequestSignature(req.Header, "POST", "/verify", body, secret, 30*time.Second, now.Add(time.Minute))
+		},
+		"unsigned": func() error {
+			return CheckRequestSignature(http.Header{}, "POST", "/verify", body, secret, 30*time.Second, now)
+		},
+	}
+	for name, check := range cases {
+		if err := check(); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}
+
+func TestVerifierClient_SignsWithSharedSecret(t *testing.T) {
+	secret := []byte("shared-secret")
+	var checkErr error
+	var requestIDs []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		checkErr = CheckRequestSignature(r.Header, r.Method, r.URL.Path, body, secret, 30*time.Second, time.Now())
+		requestIDs = append(requestIDs, r.Header.Get(HeaderRequestID))
+		w.Write([]byte(`{"is_valid":true}`))
+	}))
+	defer server.Close()
+
+	client := &VerifierClient{BaseURL: server.URL, SharedSecret: secret}
+	for range 2 {
+		if _, err := client.Verify(context.Background(), Context{Token: "USDC", Amount: "0.001", Nonce: "n-1"}, "0xsig"); err != nil {
+			t.Fatalf("Verify failed: %v", err)
+		}
+		if checkErr != nil {
+			t.Fatalf("expected the verifier to authenticate the request, got %v", checkErr)
+		}
+	}
+	if requestIDs[0] == requestIDs[1] {
+		t.Error("expected a fresh request ID per request")
+	}
+}
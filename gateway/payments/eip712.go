@@ -0,0 +1,119 @@
+package payments
+
+import (
+	"crypto/ecdsa"
+	"encoding/hex"
+	"fmt"
+	"math/big"
+	"strings"
+
+	"gateway/signing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+// EIP-712 domain the verifier checks payment signatures against.
+const (
+	DomainName    = "MicroAI Paygate"
+	DomainVersion = "1"
+)
+
+var (
+	domainTypeHash  = crypto.Keccak256([]byte("EIP712Domain(string name,string version,uint256 chainId,address verifyingContract)"))
+	paymentTypeHash = crypto.Keccak256([]byte("Payment(address recipient,string token,string amount,string nonce)"))
+	quoteTypeHash   = crypto.Keccak256([]byte("Quote(address recipient,string token,string amount,string nonce,uint256 expiry)"))
+)
+
+// Hash returns the EIP-712 digest of payment, matching what wallets produce
+// for eth_signTypedData_v4 over the Payment type with the gateway's domain
+// (verifyingContract is the zero address).
+func Hash(payment Context) ([]byte, error) {
+	if !common.IsHexAddress(payment.Recipient) {
+		return nil, fmt.Errorf("invalid recipient address %q", payment.Recipient)
+	}
+	if payment.ChainID < 0 {
+		return nil, fmt.Errorf("invalid chain id %d", payment.ChainID)
+	}
+
+	structHash := crypto.Keccak256(
+		paymentTypeHash,
+		common.LeftPadBytes(common.HexToAddress(payment.Recipient).Bytes(), 32),
+		crypto.Keccak256([]byte(payment.Token)),
+		crypto.Keccak256([]byte(payment.Amount)),
+		crypto.Keccak256([]byte(payment.Nonce)),
+	)
+	return crypto.Keccak256([]byte{0x19, 0x01}, domainSeparator(payment.ChainID), structHash), nil
+}
+
+// domainSeparator is the EIP-712 domain hash for chainID.
+func domainSeparator(chainID int) []byte {
+	return crypto.Keccak256(
+		domainTypeHash,
+		crypto.Keccak256([]byte(DomainName)),
+		crypto.Keccak256([]byte(DomainVersion)),
+		common.LeftPadBytes(big.NewInt(int64(chainID)).Bytes(), 32),
+		common.LeftPadBytes(common.Address{}.Bytes(), 32),
+	)
+}
+
+// Sign signs payment with key and returns the 0x-prefixed 65-byte signature
+// (v = 27/28) expected in the X-402-Signature header.
+func Sign(payment Context, key *ecdsa.PrivateKey) (string, error) {
+	hash, err := Hash(payment)
+	if err != nil {
+		return "", err
+	}
+	sig, err := signHash(hash, key)
+	if err != nil {
+		return "", fmt.Errorf("sign payment: %w", err)
+	}
+	return sig, nil
+}
+
+// signHash signs an EIP-712 digest with key, see SignHash.
+func signHash(hash []byte, key *ecdsa.PrivateKey) (string, error) {
+	return SignHash(hash, signing.FromKey(key))
+}
+
+// SignHash signs an EIP-712 or EIP-191 digest with s, returning the
+// 0x-prefixed signature with v = 27/28.
+func SignHash(hash []byte, s signing.Signer) (string, error) {
+	if s == nil {
+		return "", fmt.Errorf("signer is nil")
+	}
+	sig, err := s.SignHash(hash)
+	if err != nil {
+		return "", err
+	}
+	sig[crypto.RecoveryIDOffset] += 27
+	return "0x" + hex.EncodeToString(sig), nil
+}
+
+// RecoverSigner returns the address that produced signature over payment.
+func RecoverSigner(payment Context, signature string) (common.Address, error) {
+	hash, err := Hash(payment)
+	if err != nil {
+		return common.Address{}, err
+	}
+	return recoverHash(hash, signature)
+}
+
+// recoverHash returns the address that signed an EIP-712 digest.
+func recoverHash(hash []byte, signature string) (common.Address, error) {
+	sig, err := hex.DecodeString(strings.TrimPrefix(signature, "0x"))
+	if err != nil {
+		return common.Address{}, fmt.Errorf("invalid signature encoding: %w", err)
+	}
+	if len(sig) != crypto.SignatureLength {
+		return common.Address{}, fmt.Errorf("invalid signature length: got %d bytes, want %d", len(sig), crypto.SignatureLength)
+	}
+	if sig[crypto.RecoveryIDOffset] >= 27 {
+		sig[crypto.RecoveryIDOffset] -= 27
+	}
+	pub, err := crypto.SigToPub(hash, sig)
+	if err != nil {
+		return common.Address{}, fmt.Errorf("recover signer: %w", err)
+	}
+	return crypto.PubkeyToAddress(*pub), nil
+}
@@ -0,0 +1,51 @@
+package payments
+
+import (
+	"encoding/hex"
+	"strings"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+func TestSignAndRecoverSigner(t *testing.T) {
+	key, err := crypto.GenerateKey()
+	if err != nil {
+		t.Fatalf("GenerateKey failed: %v", err)
+	}
+	payment := Context{
+		Recipient: "0x2cAF48b4BA1C58721a85dFADa5aC01C2DFa62219",
+		Token:     "USDC",
+		Amount:    "0.001",
+		Nonce:     "550e8400-e29b-41d4-a716-446655440000",
+		ChainID:   8453,
+	}
+
+	sig, err := Sign(payment, key)
+	if err != nil {
+		t.Fatalf("Sign failed: %v", err)
+	}
+	raw, _ := hex.DecodeString(strings.TrimPrefix(sig, "0x"))
+	if len(raw) != 65 || (raw[64] != 27 && raw[64] != 28) {
+		t.Fatalf("expected 65-byte signature with v of 27/28, got %s", sig)
+	}
+
+	signer, err := RecoverSigner(payment, sig)
+	if err != nil {
+		t.Fatalf("RecoverSigner failed: %v", err)
+	}
+	if signer != crypto.PubkeyToAddress(key.PublicKey) {
+		t.Errorf("recovered %s, want %s", signer.Hex(), crypto.PubkeyToAddress(key.PublicKey).Hex())
+	}
+
+	payment.Amount = "0.002"
+	if other, _ := RecoverSigner(payment, sig); other == signer {
+		t.Error("expected a different amount to change the recovered signer")
+	}
+}
+
+func TestHash_RejectsInvalidRecipient(t *testing.T) {
+	if _, err := Hash(Context{Recipient: "not-an-address", ChainID: 1}); err == nil {
+		t.Error("expected invalid recipient to be rejected")
+	}
+}
@@ -0,0 +1,182 @@
+package payments
+
+import (
+	"crypto/ecdsa"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+var (
+	transferAuthorizationTypeHash = crypto.Keccak256([]byte("TransferWithAuthorization(address from,address to,uint256 value,uint256 validAfter,uint256 validBefore,bytes32 nonce)"))
+	transferWithAuthorizationID   = crypto.Keccak256([]byte("transferWithAuthorization(address,address,uint256,uint256,uint256,bytes32,uint8,bytes32,bytes32)"))[:4]
+	authorizationStateID          = crypto.Keccak256([]byte("authorizationState(address,bytes32)"))[:4]
+)
+
+// TokenDomain is the EIP-712 domain of an ERC-3009 token contract, e.g.
+// name "USD Coin", version "2" for USDC.
+type TokenDomain struct {
+	Name              string `json:"name"`
+	Version           string `json:"version"`
+	ChainID           int    `json:"chainId"`
+	VerifyingContract string `json:"verifyingContract"`
+}
+
+// separator is the EIP-712 hash of d.
+func (d TokenDomain) separator() []byte {
+	return crypto.Keccak256(
+		domainTypeHash,
+		crypto.Keccak256([]byte(d.Name)),
+		crypto.Keccak256([]byte(d.Version)),
+		common.LeftPadBytes(big.NewInt(int64(d.ChainID)).Bytes(), 32),
+		common.LeftPadBytes(common.HexToAddress(d.VerifyingContract).Bytes(), 32),
+	)
+}
+
+// TransferAuthorization is an ERC-3009 TransferWithAuthorization message.
+// Value is in the token's base units, ValidAfter and ValidBefore are unix
+// seconds and Nonce is a 0x-prefixed bytes32. Numbers are JSON strings, as
+// in x402's exact EVM scheme.
+type TransferAuthorization struct {
+	From        string `json:"from"`
+	To          string `json:"to"`
+	Value       string `json:"value"`
+	ValidAfter  int64  `json:"validAfter,string"`
+	ValidBefore int64  `json:"validBefore,string"`
+	Nonce       string `json:"nonce"`
+}
+
+// ERC3009Payload is what an erc3009 client sends as its signature: the
+// authorization and the payer's signature of it.
+type ERC3009Payload struct {
+	Signature     string                `json:"signature"`
+	Authorization TransferAuthorization `json:"authorization"`
+}
+
+// EncodeERC3009Payload returns p as the base64-encoded JSON sent in
+// X-402-Signature.
+func EncodeERC3009Payload(p ERC3009Payload) (string, error) {
+	data, err := json.Marshal(p)
+	if err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(data), nil
+}
+
+// DecodeERC3009Payload parses an erc3009 X-402-Signature value.
+func DecodeERC3009Payload(raw string) (ERC3009Payload, error) {
+	var p ERC3009Payload
+	data, err := base64.StdEncoding.DecodeString(raw)
+	if err != nil {
+		return p, fmt.Errorf("erc3009 signature must be base64-encoded JSON: %w", err)
+	}
+	if err := json.Unmarshal(data, &p); err != nil {
+		return p, fmt.Errorf("erc3009 signature must be base64-encoded JSON: %w", err)
+	}
+	return p, nil
+}
+
+// AuthorizationNonce returns the ERC-3009 nonce that pays for the gateway
+// payment nonce: its keccak256 hash. Binding the two means an authorization
+// pays for exactly one request and cannot be replayed against another.
+func AuthorizationNonce(paymentNonce string) string {
+	return "0x" + common.Bytes2Hex(crypto.Keccak256([]byte(paymentNonce)))
+}
+
+// words returns a's fields as ABI words, in TransferWithAuthorization order.
+func (a TransferAuthorization) words() ([][]byte, error) {
+	if !common.IsHexAddress(a.From) || !common.IsHexAddress(a.To) {
+		return nil, fmt.Errorf("invalid authorization address")
+	}
+	value, ok := new(big.Int).SetString(a.Value, 10)
+	if !ok || value.Sign() < 0 || value.BitLen() > 256 {
+		return nil, fmt.Errorf("invalid authorization value %q", a.Value)
+	}
+	if a.ValidAfter < 0 || a.ValidBefore < 0 {
+		return nil, fmt.Errorf("invalid authorization validity window")
+	}
+	nonce := common.FromHex(a.Nonce)
+	if !strings.HasPrefix(a.Nonce, "0x") || len(nonce) != 32 {
+		return nil, fmt.Errorf("authorization nonce must be a 0x-prefixed bytes32")
+	}
+	return [][]byte{
+		common.LeftPadBytes(common.HexToAddress(a.From).Bytes(), 32),
+		common.LeftPadBytes(common.HexToAddress(a.To).Bytes(), 32),
+		common.LeftPadBytes(value.Bytes(), 32),
+		common.LeftPadBytes(big.NewInt(a.ValidAfter).Bytes(), 32),
+		common.LeftPadBytes(big.NewInt(a.ValidBefore).Bytes(), 32),
+		nonce,
+	}, nil
+}
+
+// TransferAuthorizationHash returns the EIP-712 digest of a under the token
+// domain, as eth_signTypedData_v4 produces it.
+func TransferAuthorizationHash(domain TokenDomain, a TransferAuthorization) ([]byte, error) {
+	words, err := a.words()
+	if err != nil {
+		return nil, err
+	}
+	structHash := crypto.Keccak256(append([][]byte{transferAuthorizationTypeHash}, words...)...)
+	return crypto.Keccak256([]byte{0x19, 0x01}, domain.separator(), structHash), nil
+}
+
+// SignTransferAuthorization signs a as its from address would, returning
+// the 0x-prefixed 65-byte signature.
+func SignTransferAuthorization(domain TokenDomain, a TransferAuthorization, key *ecdsa.PrivateKey) (string, error) {
+	hash, err := TransferAuthorizationHash(domain, a)
+	if err != nil {
+		return "", err
+	}
+	sig, err := signHash(hash, key)
+	if err != nil {
+		return "", fmt.Errorf("sign authorization: %w", err)
+	}
+	return sig, nil
+}
+
+// RecoverTransferAuthorizationSigner returns the address that signed a.
+func RecoverTransferAuthorizationSigner(domain TokenDomain, a TransferAuthorization, signature string) (common.Address, error) {
+	hash, err := TransferAuthorizationHash(domain, a)
+	if err != nil {
+		return common.Address{}, err
+	}
+	return recoverHash(hash, signature)
+}
+
+// TransferWithAuthorizationCalldata encodes the token call that executes p.
+func TransferWithAuthorizationCalldata(p ERC3009Payload) ([]byte, error) {
+	words, err := p.Authorization.words()
+	if err != nil {
+		return nil, err
+	}
+	sig := common.FromHex(p.Signature)
+	if len(sig) != crypto.SignatureLength {
+		return nil, fmt.Errorf("invalid signature length: got %d bytes, want %d", len(sig), crypto.SignatureLength)
+	}
+	v := sig[crypto.RecoveryIDOffset]
+	if v < 27 {
+		v += 27
+	}
+	data := append([]byte{}, transferWithAuthorizationID...)
+	for _, w := range words {
+		data = append(data, w...)
+	}
+	data = append(data, common.LeftPadBytes([]byte{v}, 32)...)
+	// r and s follow v.
+	return append(data, sig[:64]...), nil
+}
+
+// AuthorizationStateCalldata encodes authorizationState(authorizer, nonce),
+// which returns whether the authorization was used or canceled.
+func AuthorizationStateCalldata(a TransferAuthorization) ([]byte, error) {
+	words, err := a.words()
+	if err != nil {
+		return nil, err
+	}
+	return append(append(append([]byte{}, authorizationStateID...), words[0]...), words[5]...), nil
+}
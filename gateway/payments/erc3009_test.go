@@ -0,0 +1,138 @@
+package payments
+
+import (
+	"bytes"
+	"encoding/hex"
+	"math/big"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/math"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/signer/core/apitypes"
+)
+
+func testUSDCDomain() TokenDomain {
+	return TokenDomain{Name: "USD Coin", Version: "2", ChainID: 8453, VerifyingContract: "0x833589fCD6eDb6E08f4c3C32D4f71b54bdA02913"}
+}
+
+func testTransferAuthorization() TransferAuthorization {
+	return TransferAuthorization{
+		From:        "0x2cAF48b4BA1C58721a85dFADa5aC01C2DFa62219",
+		To:          "0x1111111111111111111111111111111111111111",
+		Value:       "1000",
+		ValidAfter:  0,
+		ValidBefore: 1900000000,
+		Nonce:       AuthorizationNonce("nonce-1"),
+	}
+}
+
+func TestTransferAuthorizationHash_MatchesTypedData(t *testing.T) {
+	d, a := testUSDCDomain(), testTransferAuthorization()
+	typed := apitypes.TypedData{
+		Types: apitypes.Types{
+			"EIP712Domain": {
+				{Name: "name", Type: "string"}, {Name: "version", Type: "string"},
+				{Name: "chainId", Type: "uint256"}, {Name: "verifyingContract", Type: "address"},
+			},
+			"TransferWithAuthorization": {
+				{Name: "from", Type: "address"}, {Name: "to", Type: "address"}, {Name: "value", Type: "uint256"},
+				{Name: "validAfter", Type: "uint256"}, {Name: "validBefore", Type: "uint256"}, {Name: "nonce", Type: "bytes32"},
+			},
+		},
+		PrimaryType: "TransferWithAuthorization",
+		Domain: apitypes.TypedDataDomain{
+			Name: d.Name, Version: d.Version,
+			ChainId:           math.NewHexOrDecimal256(int64(d.ChainID)),
+			VerifyingContract: d.VerifyingContract,
+		},
+		Message: apitypes.TypedDataMessage{
+			"from": a.From, "to": a.To, "value": a.Value,
+			"validAfter": big.NewInt(a.ValidAfter), "validBefore": big.NewInt(a.ValidBefore), "nonce": a.Nonce,
+		},
+	}
+	want, _, err := apitypes.TypedDataAndHash(typed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := TransferAuthorizationHash(d, a)
+	if err != nil || !bytes.Equal(got, want) {
+		t.Fatalf("hash %x (%v), want the eth_signTypedData_v4 digest %x", got, err, want)
+	}
+}
+
+func TestSignTransferAuthorizationAndRecover(t *testing.T) {
+	key, err := crypto.GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	d, a := testUSDCDomain(), testTransferAuthorization()
+	sig, err := SignTransferAuthorization(d, a, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if signer, err := RecoverTransferAuthorizationSigner(d, a, sig); err != nil || signer != crypto.PubkeyToAddress(key.PublicKey) {
+		t.Fatalf("recovered %s (%v), want the signing key", signer.Hex(), err)
+	}
+	raised := a
+	raised.Value = "1"
+	if signer, _ := RecoverTransferAuthorizationSigner(d, raised, sig); signer == crypto.PubkeyToAddress(key.PublicKey) {
+		t.Error("a changed value must not verify against the signature")
+	}
+	a.Nonce = "0x1234"
+	if _, err := SignTransferAuthorization(d, a, key); err == nil {
+		t.Error("a nonce that is not bytes32 should be rejected")
+	}
+}
+
+func TestERC3009Payload_RoundTrip(t *testing.T) {
+	p := ERC3009Payload{Signature: "0xabc", Authorization: testTransferAuthorization()}
+	raw, err := EncodeERC3009Payload(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := DecodeERC3009Payload(raw)
+	if err != nil || got != p {
+		t.Fatalf("decoded %+v (%v), want %+v", got, err, p)
+	}
+	if _, err := DecodeERC3009Payload("0xabc"); err == nil {
+		t.Error("a hex signature should not decode as an erc3009 payload")
+	}
+}
+
+func TestTransferWithAuthorizationCalldata(t *testing.T) {
+	key, err := crypto.GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	a := testTransferAuthorization()
+	sig, err := SignTransferAuthorization(testUSDCDomain(), a, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := TransferWithAuthorizationCalldata(ERC3009Payload{Signature: sig, Authorization: a})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := hex.EncodeToString(data[:4]); got != "e3ee160e" {
+		t.Errorf("selector %s, want e3ee160e", got)
+	}
+	if len(data) != 4+9*32 {
+		t.Fatalf("calldata is %d bytes, want %d", len(data), 4+9*32)
+	}
+	rawSig := common.FromHex(sig)
+	if v := data[4+6*32+31]; v != rawSig[64] {
+		t.Errorf("v = %d, want %d", v, rawSig[64])
+	}
+	if !bytes.Equal(data[4+7*32:], rawSig[:64]) {
+		t.Error("r and s do not follow v")
+	}
+
+	state, err := AuthorizationStateCalldata(a)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(state) != 4+2*32 || !bytes.Equal(state[4+32:], common.FromHex(a.Nonce)) {
+		t.Errorf("unexpected authorizationState calldata %x", state)
+	}
+}
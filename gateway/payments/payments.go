@@ -0,0 +1,158 @@
+// Package payments defines the x402 payment context exchanged with clients
+// and a client for the signature verifier service. It has no dependency on
+// the gateway's HTTP server and can be embedded by other Go services.
+package payments
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Context is the payment a client must sign to access a paid endpoint. It is
+// returned in 402 responses and echoed to the verifier for every request.
+type Context struct {
+	Recipient string `json:"recipient"`
+	Token     string `json:"token"`
+	Amount    string `json:"amount"`
+	Nonce     string `json:"nonce"`
+	ChainID   int    `json:"chainId"`
+	// Expiry (unix seconds) and QuoteSignature are set on 402 responses
+	// when the gateway signs its quotes; see SignQuote. They are not part
+	// of the payment signature.
+	Expiry         int64  `json:"expiry,omitempty"`
+	QuoteSignature string `json:"quoteSignature,omitempty"`
+}
+
+// VerifyRequest is the body sent to the verifier's POST /verify endpoint.
+type VerifyRequest struct {
+	Context   Context `json:"context"`
+	Signature string  `json:"signature"`
+}
+
+// VerifyResponse is the verifier's answer for a single signature. Reason
+// categorizes why an invalid signature was refused; see ClassifyVerifyError.
+type VerifyResponse struct {
+	IsValid          bool   `json:"is_valid"`
+	RecoveredAddress string `json:"recovered_address"`
+	Error            string `json:"error"`
+	Reason           string `json:"reason,omitempty"`
+}
+
+// Reasons a payment signature is refused, so clients can tell what to fix.
+const (
+	ReasonMalformedSignature = "malformed_signature"
+	ReasonWrongRecipient     = "wrong_recipient"
+	ReasonWrongAmount        = "wrong_amount"
+	ReasonWrongChain         = "wrong_chain"
+	ReasonExpiredContext     = "expired_context"
+	ReasonSignerMismatch     = "signer_mismatch"
+	ReasonInvalidSignature   = "invalid_signature"
+)
+
+// ClassifyVerifyError maps a verifier error message to a reason, for
+// verifiers that only report a message. Messages that name nothing more
+// specific are ReasonInvalidSignature.
+func ClassifyVerifyError(msg string) string {
+	msg = strings.ToLower(msg)
+	switch {
+	case strings.Contains(msg, "signature format"), strings.Contains(msg, "signature length"),
+		strings.Contains(msg, "invalid hex"), strings.Contains(msg, "malformed"):
+		return ReasonMalformedSignature
+	case strings.Contains(msg, "recipient"):
+		return ReasonWrongRecipient
+	case strings.Contains(msg, "amount"):
+		return ReasonWrongAmount
+	case strings.Contains(msg, "chain"):
+		return ReasonWrongChain
+	case strings.Contains(msg, "expired"):
+		return ReasonExpiredContext
+	}
+	return ReasonInvalidSignature
+}
+
+// VerifierClient calls the verifier service to check payment signatures.
+type VerifierClient struct {
+	// BaseURL is the verifier root, e.g. http://127.0.0.1:3002.
+	BaseURL string
+	// HTTPClient defaults to http.DefaultClient when nil.
+	HTTPClient *http.Client
+	// Timeout bounds each verification call; zero means no extra timeout
+	// beyond the caller's context.
+	Timeout time.Duration
+	// BeforeSend, if set, may decorate each outgoing request, e.g. to
+	// propagate correlation IDs.
+	BeforeSend func(ctx context.Context, req *http.Request)
+	// SharedSecret, if set, signs each request so the verifier can
+	// authenticate the caller; see SignRequest.
+	SharedSecret []byte
+}
+
+// Verify asks the verifier whether signature authorizes payment. A non-nil
+// error means the verifier could not give an answer, or that payment asks
+// for an amount ParseAmount rejects; an invalid signature is reported
+// through VerifyResponse.IsValid instead, with a Reason. The verifier answers
+// malformed requests with 400 and a VerifyResponse body, which is returned
+// like an invalid signature.
+func (v *VerifierClient) Verify(ctx context.Context, payment Context, signature string) (*VerifyResponse, error) {
+	if _, err := payment.ParseAmount(); err != nil {
+		return nil, fmt.Errorf("invalid payment context: %w", err)
+	}
+	body, err := json.Marshal(VerifyRequest{Context: payment, Signature: signature})
+	if err != nil {
+		return nil, fmt.Errorf("marshal verification request: %w", err)
+	}
+
+	if v.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, v.Timeout)
+		defer cancel()
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, v.BaseURL+"/verify", bytes.NewReader(body))
+	if err != nil {
+		return nil, fmt.Errorf("create verifier request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if v.BeforeSend != nil {
+		v.BeforeSend(ctx, req)
+	}
+	if len(v.SharedSecret) > 0 {
+		if err := SignRequest(req, body, v.SharedSecret, time.Now()); err != nil {
+			return nil, err
+		}
+	}
+
+	client := v.HTTPClient
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("verifier request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	var verifyResp VerifyResponse
+	switch resp.StatusCode {
+	case http.StatusOK:
+		if err := json.NewDecoder(resp.Body).Decode(&verifyResp); err != nil {
+			return nil, fmt.Errorf("decode verification response: %w", err)
+		}
+	case http.StatusBadRequest:
+		if err := json.NewDecoder(resp.Body).Decode(&verifyResp); err != nil || verifyResp.Error == "" {
+			return nil, fmt.Errorf("verifier returned status %d", resp.StatusCode)
+		}
+		verifyResp.IsValid = false
+	default:
+		return nil, fmt.Errorf("verifier returned status %d", resp.StatusCode)
+	}
+	if !verifyResp.IsValid && verifyResp.Reason == "" {
+		verifyResp.Reason = ClassifyVerifyError(verifyResp.Error)
+	}
+	return &verifyResp, nil
+}
@@ -0,0 +1,132 @@
+package payments
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestVerifierClient_Verify(t *testing.T) {
+	var got VerifyRequest
+	var gotHeader string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/verify" {
+			t.Errorf("expected /verify, got %s", r.URL.Path)
+		}
+		gotHeader = r.Header.Get("X-Correlation-ID")
+		_ = json.NewDecoder(r.Body).Decode(&got)
+		_ = json.NewEncoder(w).Encode(VerifyResponse{IsValid: true, RecoveredAddress: "0xpayer"})
+	}))
+	defer server.Close()
+
+	client := &VerifierClient{
+		BaseURL: server.URL,
+		BeforeSend: func(ctx context.Context, req *http.Request) {
+			req.Header.Set("X-Correlation-ID", "cid-1")
+		},
+	}
+	payment := Context{Recipient: "0xrecipient", Token: "USDC", Amount: "0.001", Nonce: "n-1", ChainID: 8453}
+
+	resp, err := client.Verify(context.Background(), payment, "0xsig")
+	if err != nil {
+		t.Fatalf("Verify failed: %v", err)
+	}
+	if !resp.IsValid || resp.RecoveredAddress != "0xpayer" {
+		t.Errorf("unexpected response: %+v", resp)
+	}
+	if got.Context != payment || got.Signature != "0xsig" {
+		t.Errorf("verifier received unexpected request: %+v", got)
+	}
+	if gotHeader != "cid-1" {
+		t.Errorf("expected BeforeSend header to be forwarded, got %q", gotHeader)
+	}
+}
+
+func TestVerifierClient_NonOKStatus(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer server.Close()
+
+	client := &VerifierClient{BaseURL: server.URL}
+	if _, err := client.Verify(context.Background(), Context{Token: "USDC", Amount: "0.001"}, "0xsig"); err == nil {
+		t.Fatal("expected error for non-200 verifier response")
+	}
+}
+
+func TestVerifierClient_Timeout(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-time.After(time.Second):
+		case <-r.Context().Done():
+		}
+	}))
+	defer server.Close()
+
+	client := &VerifierClient{BaseURL: server.URL, Timeout: 50 * time.Millisecond}
+	_, err := client.Verify(context.Background(), Context{Token: "USDC", Amount: "0.001"}, "0xsig")
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected context.DeadlineExceeded, got %v", err)
+	}
+}
+
+func TestVerifierClient_RejectsInvalidAmount(t *testing.T) {
+	called := false
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		called = true
+	}))
+	defer server.Close()
+
+	client := &VerifierClient{BaseURL: server.URL}
+	for _, amount := range []string{"-0.001", "0", "0.0000001", "2000000"} {
+		if _, err := client.Verify(context.Background(), Context{Token: "USDC", Amount: amount}, "0xsig"); err == nil {
+			t.Errorf("expected amount %q to be rejected", amount)
+		}
+	}
+	if called {
+		t.Error("expected invalid contexts not to reach the verifier")
+	}
+}
+
+func TestVerifierClient_CategorizesRejections(t *testing.T) {
+	status, body := http.StatusBadRequest, `{"is_valid":false,"recovered_address":null,"error":"Invalid signature format: invalid hex"}`
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(status)
+		_, _ = w.Write([]byte(body))
+	}))
+	defer server.Close()
+	client := &VerifierClient{BaseURL: server.URL}
+	payment := Context{Token: "USDC", Amount: "0.001"}
+
+	resp, err := client.Verify(context.Background(), payment, "0xzz")
+	if err != nil || resp.IsValid || resp.Reason != ReasonMalformedSignature {
+		t.Fatalf("expected a malformed signature rejection, got %+v, %v", resp, err)
+	}
+
+	status, body = http.StatusOK, `{"is_valid":false,"error":"context expired","reason":"wrong_chain"}`
+	if resp, err := client.Verify(context.Background(), payment, "0xsig"); err != nil || resp.Reason != ReasonWrongChain {
+		t.Errorf("expected the verifier's reason to be kept, got %+v, %v", resp, err)
+	}
+
+	status, body = http.StatusBadRequest, `not json`
+	if _, err := client.Verify(context.Background(), payment, "0xsig"); err == nil {
+		t.Error("expected an error for a 400 without a verifier answer")
+	}
+}
+
+func TestClassifyVerifyError(t *testing.T) {
+	for msg, want := range map[string]string{
+		"Invalid signature format: odd length": ReasonMalformedSignature,
+		"recipient does not match":             ReasonWrongRecipient,
+		"Payment context expired":              ReasonExpiredContext,
+		"Verification failed: bad v":           ReasonInvalidSignature,
+	} {
+		if got := ClassifyVerifyError(msg); got != want {
+			t.Errorf("ClassifyVerifyError(%q) = %s, want %s", msg, got, want)
+		}
+	}
+}
@@ -0,0 +1,59 @@
+package payments
+
+import (
+	"fmt"
+	"math/big"
+
+	"gateway/signing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+// QuoteHash returns the EIP-712 digest of the price quote in payment: the
+// Quote type (recipient, token, amount, nonce, expiry) under the same domain
+// as payment signatures.
+func QuoteHash(payment Context) ([]byte, error) {
+	if !common.IsHexAddress(payment.Recipient) {
+		return nil, fmt.Errorf("invalid recipient address %q", payment.Recipient)
+	}
+	if payment.ChainID < 0 {
+		return nil, fmt.Errorf("invalid chain id %d", payment.ChainID)
+	}
+	if payment.Expiry <= 0 {
+		return nil, fmt.Errorf("quote has no expiry")
+	}
+
+	structHash := crypto.Keccak256(
+		quoteTypeHash,
+		common.LeftPadBytes(common.HexToAddress(payment.Recipient).Bytes(), 32),
+		crypto.Keccak256([]byte(payment.Token)),
+		crypto.Keccak256([]byte(payment.Amount)),
+		crypto.Keccak256([]byte(payment.Nonce)),
+		common.LeftPadBytes(big.NewInt(payment.Expiry).Bytes(), 32),
+	)
+	return crypto.Keccak256([]byte{0x19, 0x01}, domainSeparator(payment.ChainID), structHash), nil
+}
+
+// SignQuote signs the quote in payment with the server key, so a client can
+// later prove the price it was offered for that nonce.
+func SignQuote(payment Context, s signing.Signer) (string, error) {
+	hash, err := QuoteHash(payment)
+	if err != nil {
+		return "", err
+	}
+	sig, err := SignHash(hash, s)
+	if err != nil {
+		return "", fmt.Errorf("sign quote: %w", err)
+	}
+	return sig, nil
+}
+
+// RecoverQuoteSigner returns the address that produced payment.QuoteSignature.
+func RecoverQuoteSigner(payment Context) (common.Address, error) {
+	hash, err := QuoteHash(payment)
+	if err != nil {
+		return common.Address{}, err
+	}
+	return recoverHash(hash, payment.QuoteSignature)
+}
@@ -0,0 +1,49 @@
+package payments
+
+import (
+	"testing"
+
+	"gateway/signing"
+
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+func TestSignQuoteAndRecover(t *testing.T) {
+	key, err := crypto.GenerateKey()
+	if err != nil {
+		t.Fatalf("GenerateKey failed: %v", err)
+	}
+	quote := Context{
+		Recipient: "0x2cAF48b4BA1C58721a85dFADa5aC01C2DFa62219",
+		Token:     "USDC",
+		Amount:    "0.001",
+		Nonce:     "550e8400-e29b-41d4-a716-446655440000",
+		ChainID:   8453,
+		Expiry:    1900000000,
+	}
+	quote.QuoteSignature, err = SignQuote(quote, signing.FromKey(key))
+	if err != nil {
+		t.Fatalf("SignQuote failed: %v", err)
+	}
+
+	signer, err := RecoverQuoteSigner(quote)
+	if err != nil || signer != crypto.PubkeyToAddress(key.PublicKey) {
+		t.Fatalf("recovered %s (%v), want the server address", signer.Hex(), err)
+	}
+
+	switched := quote
+	switched.Amount = "0.002"
+	if signer, _ := RecoverQuoteSigner(switched); signer == crypto.PubkeyToAddress(key.PublicKey) {
+		t.Error("a changed amount must not verify against the quote signature")
+	}
+
+	// A quote signature is not a valid payment signature for the same fields.
+	if signer, _ := RecoverSigner(quote, quote.QuoteSignature); signer == crypto.PubkeyToAddress(key.PublicKey) {
+		t.Error("quote and payment digests must differ")
+	}
+
+	quote.Expiry = 0
+	if _, err := SignQuote(quote, signing.FromKey(key)); err == nil {
+		t.Error("a quote without expiry should be rejected")
+	}
+}
@@ -0,0 +1,117 @@
+package payments
+
+import (
+	"crypto/ecdsa"
+	"encoding/hex"
+	"fmt"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+// Signature types a client may name in the X-402-Signature-Type header.
+const (
+	// SignatureTypeEIP712 is eth_signTypedData_v4 over the Payment type; see
+	// Hash. It is the default.
+	SignatureTypeEIP712 = "eip712"
+	// SignatureTypePersonalSign is personal_sign (EIP-191) over
+	// PersonalMessage, for wallets without typed data support.
+	SignatureTypePersonalSign = "personal_sign"
+	// SignatureTypeEIP1271 is a smart-contract wallet signature, checked by
+	// calling isValidSignature on the wallet with the EIP-712 digest.
+	SignatureTypeEIP1271 = "eip1271"
+	// SignatureTypeERC3009 is a signed ERC-3009 transferWithAuthorization
+	// of the payment's token to its recipient; see ERC3009Payload. Unlike
+	// the other types it moves the funds once relayed on-chain.
+	SignatureTypeERC3009 = "erc3009"
+)
+
+// EIP1271MagicValue is what isValidSignature(bytes32,bytes) returns for a
+// valid signature.
+var EIP1271MagicValue = [4]byte{0x16, 0x26, 0xba, 0x7e}
+
+// PersonalMessage is the text signed with personal_sign for payment. It
+// binds the same fields as the EIP-712 Payment type plus the chain ID, which
+// EIP-712 binds through its domain.
+func PersonalMessage(payment Context) string {
+	return fmt.Sprintf("%s payment\nRecipient: %s\nToken: %s\nAmount: %s\nNonce: %s\nChain ID: %d",
+		DomainName, common.HexToAddress(payment.Recipient).Hex(), payment.Token, payment.Amount, payment.Nonce, payment.ChainID)
+}
+
+// PersonalHash returns the EIP-191 digest personal_sign produces for
+// payment's PersonalMessage.
+func PersonalHash(payment Context) ([]byte, error) {
+	if !common.IsHexAddress(payment.Recipient) {
+		return nil, fmt.Errorf("invalid recipient address %q", payment.Recipient)
+	}
+	return MessageHash(PersonalMessage(payment)), nil
+}
+
+// MessageHash returns the EIP-191 digest personal_sign produces for msg.
+func MessageHash(msg string) []byte {
+	return crypto.Keccak256([]byte(fmt.Sprintf("\x19Ethereum Signed Message:\n%d%s", len(msg), msg)))
+}
+
+// SignMessage signs msg as personal_sign would.
+func SignMessage(msg string, key *ecdsa.PrivateKey) (string, error) {
+	sig, err := signHash(MessageHash(msg), key)
+	if err != nil {
+		return "", fmt.Errorf("sign message: %w", err)
+	}
+	return sig, nil
+}
+
+// RecoverMessageSigner returns the address that personal_signed msg.
+func RecoverMessageSigner(msg, signature string) (common.Address, error) {
+	return recoverHash(MessageHash(msg), signature)
+}
+
+// SignPersonal signs payment's PersonalMessage as personal_sign would.
+func SignPersonal(payment Context, key *ecdsa.PrivateKey) (string, error) {
+	hash, err := PersonalHash(payment)
+	if err != nil {
+		return "", err
+	}
+	sig, err := signHash(hash, key)
+	if err != nil {
+		return "", fmt.Errorf("sign payment: %w", err)
+	}
+	return sig, nil
+}
+
+// RecoverPersonalSigner returns the address that personal_signed payment.
+func RecoverPersonalSigner(payment Context, signature string) (common.Address, error) {
+	hash, err := PersonalHash(payment)
+	if err != nil {
+		return common.Address{}, err
+	}
+	return recoverHash(hash, signature)
+}
+
+// IsValidSignatureCalldata ABI-encodes isValidSignature(hash, signature)
+// for an EIP-1271 eth_call.
+func IsValidSignatureCalldata(hash []byte, signature string) ([]byte, error) {
+	sig, err := hex.DecodeString(strings.TrimPrefix(signature, "0x"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid signature encoding: %w", err)
+	}
+	if len(hash) != 32 {
+		return nil, fmt.Errorf("invalid hash length %d", len(hash))
+	}
+	data := append([]byte{}, EIP1271MagicValue[:]...)
+	data = append(data, hash...)
+	data = append(data, common.LeftPadBytes([]byte{0x40}, 32)...)
+	data = append(data, common.LeftPadBytes(bigEndian(len(sig)), 32)...)
+	data = append(data, common.RightPadBytes(sig, (len(sig)+31)/32*32)...)
+	return data, nil
+}
+
+// bigEndian encodes n without leading zero bytes.
+func bigEndian(n int) []byte {
+	var b []byte
+	for ; n > 0; n >>= 8 {
+		b = append([]byte{byte(n)}, b...)
+	}
+	return b
+}
@@ -0,0 +1,94 @@
+package payments
+
+import (
+	"bytes"
+	"encoding/hex"
+	"strings"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+func TestSignPersonalAndRecover(t *testing.T) {
+	key, err := crypto.GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	payment := Context{
+		Recipient: "0x2cAF48b4BA1C58721a85dFADa5aC01C2DFa62219",
+		Token:     "USDC",
+		Amount:    "0.001",
+		Nonce:     "nonce-1",
+		ChainID:   8453,
+	}
+	sig, err := SignPersonal(payment, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer, err := RecoverPersonalSigner(payment, sig)
+	if err != nil || signer != crypto.PubkeyToAddress(key.PublicKey) {
+		t.Fatalf("recovered %s (%v), want the signing key", signer.Hex(), err)
+	}
+
+	// The message binds the chain, so the signature is not valid elsewhere.
+	other := payment
+	other.ChainID = 10
+	if signer, _ := RecoverPersonalSigner(other, sig); signer == crypto.PubkeyToAddress(key.PublicKey) {
+		t.Error("personal_sign signature recovered to the payer on another chain")
+	}
+	// Nor is it an EIP-712 signature of the same payment.
+	if signer, _ := RecoverSigner(payment, sig); signer == crypto.PubkeyToAddress(key.PublicKey) {
+		t.Error("personal_sign signature accepted as EIP-712")
+	}
+	if !strings.Contains(PersonalMessage(payment), "Nonce: nonce-1") {
+		t.Errorf("unexpected message %q", PersonalMessage(payment))
+	}
+}
+
+func TestSignMessageAndRecover(t *testing.T) {
+	// Digest of personal_sign("hello") as wallets compute it.
+	if got := hex.EncodeToString(MessageHash("hello")); got != "50b2c43fd39106bafbba0da34fc430e1f91e3c96ea2acee2bc34119f92b37750" {
+		t.Errorf("unexpected digest %s", got)
+	}
+
+	key, err := crypto.GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	sig, err := SignMessage("usage request", key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if signer, err := RecoverMessageSigner("usage request", sig); err != nil || signer != crypto.PubkeyToAddress(key.PublicKey) {
+		t.Fatalf("recovered %s (%v), want the signing key", signer.Hex(), err)
+	}
+	if signer, _ := RecoverMessageSigner("usage request!", sig); signer == crypto.PubkeyToAddress(key.PublicKey) {
+		t.Error("signature recovered to the signer for a different message")
+	}
+}
+
+func TestIsValidSignatureCalldata(t *testing.T) {
+	hash := bytes.Repeat([]byte{0xab}, 32)
+	sig := "0x" + strings.Repeat("11", 65)
+	data, err := IsValidSignatureCalldata(hash, sig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// selector + hash + offset + length + 65 bytes padded to 96.
+	if len(data) != 4+32+32+32+96 {
+		t.Fatalf("unexpected calldata length %d", len(data))
+	}
+	if !bytes.Equal(data[:4], EIP1271MagicValue[:]) || !bytes.Equal(data[4:36], hash) {
+		t.Errorf("unexpected selector or hash: %s", hex.EncodeToString(data[:36]))
+	}
+	if data[67] != 0x40 || data[99] != 65 || data[100] != 0x11 || data[len(data)-1] != 0 {
+		t.Errorf("unexpected bytes encoding: %s", hex.EncodeToString(data[36:]))
+	}
+
+	if _, err := IsValidSignatureCalldata(hash, "0xzz"); err == nil {
+		t.Error("expected an error for a non-hex signature")
+	}
+	if _, err := IsValidSignatureCalldata(hash[:31], sig); err == nil {
+		t.Error("expected an error for a short hash")
+	}
+}
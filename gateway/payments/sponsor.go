@@ -0,0 +1,100 @@
+package payments
+
+import (
+	"crypto/ecdsa"
+	"fmt"
+	"math/big"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+// SponsorGrant lets Sponsor pay for requests signed by any of Users: each up
+// to MaxAmount and, when TotalCap is set, all of them together up to
+// TotalCap, until Expiry. The sponsor signs it once with SignSponsorGrant;
+// users send it in X-402-Sponsor-Grant with the sponsor's signature in
+// X-402-Sponsor-Signature, next to their own payment signature.
+type SponsorGrant struct {
+	ID        string   `json:"id"`
+	Sponsor   string   `json:"sponsor"`
+	Users     []string `json:"users"`
+	MaxAmount string   `json:"maxAmount"`
+	TotalCap  string   `json:"totalCap,omitempty"`
+	ChainID   int      `json:"chainId"`
+	// Expiry is in unix seconds.
+	Expiry int64 `json:"expiry"`
+}
+
+var sponsorGrantTypeHash = crypto.Keccak256([]byte("SponsorGrant(string id,address sponsor,address[] users,string maxAmount,string totalCap,uint256 expiry)"))
+
+// SponsorGrantHash returns the EIP-712 digest of g under the payment domain
+// for g.ChainID.
+func SponsorGrantHash(g SponsorGrant) ([]byte, error) {
+	if g.ID == "" {
+		return nil, fmt.Errorf("sponsor grant has no id")
+	}
+	if !common.IsHexAddress(g.Sponsor) {
+		return nil, fmt.Errorf("invalid sponsor address %q", g.Sponsor)
+	}
+	if len(g.Users) == 0 {
+		return nil, fmt.Errorf("sponsor grant names no users")
+	}
+	if g.ChainID < 0 {
+		return nil, fmt.Errorf("invalid chain id %d", g.ChainID)
+	}
+	if g.Expiry <= 0 {
+		return nil, fmt.Errorf("sponsor grant has no expiry")
+	}
+
+	// address[] is encoded as the hash of its padded elements.
+	users := make([]byte, 0, 32*len(g.Users))
+	for _, u := range g.Users {
+		if !common.IsHexAddress(u) {
+			return nil, fmt.Errorf("invalid user address %q", u)
+		}
+		users = append(users, common.LeftPadBytes(common.HexToAddress(u).Bytes(), 32)...)
+	}
+	structHash := crypto.Keccak256(
+		sponsorGrantTypeHash,
+		crypto.Keccak256([]byte(g.ID)),
+		common.LeftPadBytes(common.HexToAddress(g.Sponsor).Bytes(), 32),
+		crypto.Keccak256(users),
+		crypto.Keccak256([]byte(g.MaxAmount)),
+		crypto.Keccak256([]byte(g.TotalCap)),
+		common.LeftPadBytes(big.NewInt(g.Expiry).Bytes(), 32),
+	)
+	return crypto.Keccak256([]byte{0x19, 0x01}, domainSeparator(g.ChainID), structHash), nil
+}
+
+// SignSponsorGrant signs g with the sponsor's key.
+func SignSponsorGrant(g SponsorGrant, key *ecdsa.PrivateKey) (string, error) {
+	hash, err := SponsorGrantHash(g)
+	if err != nil {
+		return "", err
+	}
+	sig, err := signHash(hash, key)
+	if err != nil {
+		return "", fmt.Errorf("sign sponsor grant: %w", err)
+	}
+	return sig, nil
+}
+
+// RecoverSponsorGrantSigner returns the address that signed g.
+func RecoverSponsorGrantSigner(g SponsorGrant, signature string) (common.Address, error) {
+	hash, err := SponsorGrantHash(g)
+	if err != nil {
+		return common.Address{}, err
+	}
+	return recoverHash(hash, signature)
+}
+
+// Covers reports whether user is one of the grant's users.
+func (g SponsorGrant) Covers(user string) bool {
+	for _, u := range g.Users {
+		if strings.EqualFold(u, user) {
+			return true
+		}
+	}
+	return false
+}
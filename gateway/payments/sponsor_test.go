@@ -0,0 +1,85 @@
+package payments
+
+import (
+	"bytes"
+	"math/big"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common/math"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/signer/core/apitypes"
+)
+
+func testSponsorGrant() SponsorGrant {
+	return SponsorGrant{
+		ID:        "grant-1",
+		Sponsor:   "0x2cAF48b4BA1C58721a85dFADa5aC01C2DFa62219",
+		Users:     []string{"0x1111111111111111111111111111111111111111", "0x2222222222222222222222222222222222222222"},
+		MaxAmount: "0.01",
+		TotalCap:  "1",
+		ChainID:   8453,
+		Expiry:    1900000000,
+	}
+}
+
+func TestSponsorGrantHash_MatchesTypedData(t *testing.T) {
+	g := testSponsorGrant()
+	typed := apitypes.TypedData{
+		Types: apitypes.Types{
+			"EIP712Domain": {
+				{Name: "name", Type: "string"}, {Name: "version", Type: "string"},
+				{Name: "chainId", Type: "uint256"}, {Name: "verifyingContract", Type: "address"},
+			},
+			"SponsorGrant": {
+				{Name: "id", Type: "string"}, {Name: "sponsor", Type: "address"}, {Name: "users", Type: "address[]"},
+				{Name: "maxAmount", Type: "string"}, {Name: "totalCap", Type: "string"}, {Name: "expiry", Type: "uint256"},
+			},
+		},
+		PrimaryType: "SponsorGrant",
+		Domain: apitypes.TypedDataDomain{
+			Name: DomainName, Version: DomainVersion,
+			ChainId:           math.NewHexOrDecimal256(int64(g.ChainID)),
+			VerifyingContract: "0x0000000000000000000000000000000000000000",
+		},
+		Message: apitypes.TypedDataMessage{
+			"id": g.ID, "sponsor": g.Sponsor, "users": []interface{}{g.Users[0], g.Users[1]},
+			"maxAmount": g.MaxAmount, "totalCap": g.TotalCap, "expiry": big.NewInt(g.Expiry),
+		},
+	}
+	want, _, err := apitypes.TypedDataAndHash(typed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := SponsorGrantHash(g)
+	if err != nil || !bytes.Equal(got, want) {
+		t.Fatalf("hash %x (%v), want the eth_signTypedData_v4 digest %x", got, err, want)
+	}
+}
+
+func TestSignSponsorGrantAndRecover(t *testing.T) {
+	key, err := crypto.GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	g := testSponsorGrant()
+	sig, err := SignSponsorGrant(g, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if signer, err := RecoverSponsorGrantSigner(g, sig); err != nil || signer != crypto.PubkeyToAddress(key.PublicKey) {
+		t.Fatalf("recovered %s (%v), want the sponsor key", signer.Hex(), err)
+	}
+
+	widened := g
+	widened.Users = append(widened.Users, "0x3333333333333333333333333333333333333333")
+	if signer, _ := RecoverSponsorGrantSigner(widened, sig); signer == crypto.PubkeyToAddress(key.PublicKey) {
+		t.Error("an added user must not verify against the grant signature")
+	}
+	if !g.Covers("0x1111111111111111111111111111111111111111") || g.Covers("0x3333333333333333333333333333333333333333") {
+		t.Error("Covers does not match the grant's users")
+	}
+	g.Users = nil
+	if _, err := SignSponsorGrant(g, key); err == nil {
+		t.Error("a grant without users should be rejected")
+	}
+}
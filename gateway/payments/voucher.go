@@ -0,0 +1,74 @@
+package payments
+
+import (
+	"fmt"
+	"math/big"
+
+	"gateway/signing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+// Voucher entitles Payer to one request of up to Amount without a fresh
+// payment. The gateway issues one when a verified request fails upstream; it
+// is bound to the nonce of the payment it refunds and redeemed by presenting
+// that payment's signature again.
+type Voucher struct {
+	ID      string `json:"id"`
+	Payer   string `json:"payer"`
+	Amount  string `json:"amount"`
+	Nonce   string `json:"nonce"`
+	ChainID int    `json:"chainId"`
+	// Expiry is in unix seconds.
+	Expiry    int64  `json:"expiry"`
+	Signature string `json:"signature,omitempty"`
+}
+
+var voucherTypeHash = crypto.Keccak256([]byte("Voucher(string id,address payer,string amount,string nonce,uint256 expiry)"))
+
+// VoucherHash returns the EIP-712 digest of v under the payment domain for
+// v.ChainID.
+func VoucherHash(v Voucher) ([]byte, error) {
+	if !common.IsHexAddress(v.Payer) {
+		return nil, fmt.Errorf("invalid payer address %q", v.Payer)
+	}
+	if v.ChainID < 0 {
+		return nil, fmt.Errorf("invalid chain id %d", v.ChainID)
+	}
+	if v.Expiry <= 0 {
+		return nil, fmt.Errorf("voucher has no expiry")
+	}
+
+	structHash := crypto.Keccak256(
+		voucherTypeHash,
+		crypto.Keccak256([]byte(v.ID)),
+		common.LeftPadBytes(common.HexToAddress(v.Payer).Bytes(), 32),
+		crypto.Keccak256([]byte(v.Amount)),
+		crypto.Keccak256([]byte(v.Nonce)),
+		common.LeftPadBytes(big.NewInt(v.Expiry).Bytes(), 32),
+	)
+	return crypto.Keccak256([]byte{0x19, 0x01}, domainSeparator(v.ChainID), structHash), nil
+}
+
+// SignVoucher signs v with the server key.
+func SignVoucher(v Voucher, s signing.Signer) (string, error) {
+	hash, err := VoucherHash(v)
+	if err != nil {
+		return "", err
+	}
+	sig, err := SignHash(hash, s)
+	if err != nil {
+		return "", fmt.Errorf("sign voucher: %w", err)
+	}
+	return sig, nil
+}
+
+// RecoverVoucherSigner returns the address that produced v.Signature.
+func RecoverVoucherSigner(v Voucher) (common.Address, error) {
+	hash, err := VoucherHash(v)
+	if err != nil {
+		return common.Address{}, err
+	}
+	return recoverHash(hash, v.Signature)
+}
@@ -0,0 +1,44 @@
+package payments
+
+import (
+	"testing"
+
+	"gateway/signing"
+
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+func TestSignVoucherAndRecover(t *testing.T) {
+	key, err := crypto.GenerateKey()
+	if err != nil {
+		t.Fatalf("GenerateKey failed: %v", err)
+	}
+	voucher := Voucher{
+		ID:      "vch_0123456789abcdef",
+		Payer:   "0x2cAF48b4BA1C58721a85dFADa5aC01C2DFa62219",
+		Amount:  "0.001",
+		Nonce:   "550e8400-e29b-41d4-a716-446655440000",
+		ChainID: 8453,
+		Expiry:  1900000000,
+	}
+	voucher.Signature, err = SignVoucher(voucher, signing.FromKey(key))
+	if err != nil {
+		t.Fatalf("SignVoucher failed: %v", err)
+	}
+
+	signer, err := RecoverVoucherSigner(voucher)
+	if err != nil || signer != crypto.PubkeyToAddress(key.PublicKey) {
+		t.Fatalf("recovered %s (%v), want the server address", signer.Hex(), err)
+	}
+
+	rebound := voucher
+	rebound.Nonce = "another-nonce"
+	if signer, _ := RecoverVoucherSigner(rebound); signer == crypto.PubkeyToAddress(key.PublicKey) {
+		t.Error("a changed nonce must not verify against the voucher signature")
+	}
+
+	voucher.Payer = "not-an-address"
+	if _, err := SignVoucher(voucher, signing.FromKey(key)); err == nil {
+		t.Error("a voucher without a valid payer should be rejected")
+	}
+}
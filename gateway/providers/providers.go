@@ -0,0 +1,171 @@
+// Package providers calls the OpenAI-compatible chat completions backends the
+// gateway summarizes with (OpenRouter, OpenAI and Ollama). It has no
+// dependency on the gateway's HTTP server and can be embedded by other Go
+// services.
+package providers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+)
+
+// Provider is one OpenAI-compatible chat completions backend.
+type Provider struct {
+	Name string
+	URL  string
+	// Model overrides the requested model; empty means the provider serves
+	// the model it is asked for (OpenRouter model IDs).
+	Model string
+	// APIKeyEnv names the environment variable holding the API key; the key
+	// itself is read at call time like every other secret.
+	APIKeyEnv string
+}
+
+// Default is the chain used when no providers are configured.
+var Default = []string{"openrouter"}
+
+// getEnv returns the environment variable key, or fallback when it is unset.
+func getEnv(key, fallback string) string {
+	if v := os.Getenv(key); v != "" {
+		return v
+	}
+	return fallback
+}
+
+// Known builds the provider called name from its environment.
+func Known(name string) (Provider, bool) {
+	switch name {
+	case "openrouter":
+		return Provider{
+			Name:      name,
+			URL:       getEnv("OPENROUTER_URL", "https://openrouter.ai/api/v1/chat/completions"),
+			APIKeyEnv: "OPENROUTER_API_KEY",
+		}, true
+	case "openai":
+		return Provider{
+			Name:      name,
+			URL:       getEnv("OPENAI_URL", "https://api.openai.com/v1/chat/completions"),
+			Model:     getEnv("OPENAI_MODEL", "gpt-4o-mini"),
+			APIKeyEnv: "OPENAI_API_KEY",
+		}, true
+	case "ollama":
+		return Provider{
+			Name:  name,
+			URL:   getEnv("OLLAMA_URL", "http://127.0.0.1:11434/v1/chat/completions"),
+			Model: getEnv("OLLAMA_MODEL", "llama3.2"),
+		}, true
+	}
+	return Provider{}, false
+}
+
+// Parse resolves an ordered list of provider names.
+func Parse(names []string) ([]Provider, error) {
+	providers := make([]Provider, 0, len(names))
+	seen := make(map[string]bool, len(names))
+	for _, name := range names {
+		p, ok := Known(name)
+		if !ok {
+			return nil, fmt.Errorf("unknown provider %q (want openrouter, openai or ollama)", name)
+		}
+		if seen[name] {
+			return nil, fmt.Errorf("provider %q listed twice", name)
+		}
+		seen[name] = true
+		providers = append(providers, p)
+	}
+	if len(providers) == 0 {
+		return nil, errors.New("at least one provider is required")
+	}
+	return providers, nil
+}
+
+// Usage is the token usage a provider reported for one call. Cost is in USD
+// and zero when the provider did not report it.
+type Usage struct {
+	PromptTokens     int64   `json:"prompt_tokens"`
+	CompletionTokens int64   `json:"completion_tokens"`
+	Cost             float64 `json:"cost"`
+}
+
+// ParseUsage reads the OpenAI-style usage block of a response.
+func ParseUsage(raw map[string]interface{}) Usage {
+	var u Usage
+	if v, ok := raw["prompt_tokens"].(float64); ok {
+		u.PromptTokens = int64(v)
+	}
+	if v, ok := raw["completion_tokens"].(float64); ok {
+		u.CompletionTokens = int64(v)
+	}
+	u.Cost, _ = raw["cost"].(float64)
+	return u
+}
+
+// Complete posts the chat completions request body to p with client and
+// returns the first choice's content. header is added to the request. A
+// deadline hit while waiting is reported as context.DeadlineExceeded.
+func (p Provider) Complete(ctx context.Context, client *http.Client, body map[string]interface{}, header http.Header) (string, Usage, error) {
+	if p.Name == "openrouter" {
+		// Ask OpenRouter to report the request cost with the token usage.
+		body["usage"] = map[string]bool{"include": true}
+	}
+	reqBody, _ := json.Marshal(body)
+
+	req, err := http.NewRequestWithContext(ctx, "POST", p.URL, bytes.NewBuffer(reqBody))
+	if err != nil {
+		return "", Usage{}, fmt.Errorf("failed to create %s request: %w", p.Name, err)
+	}
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	if p.APIKeyEnv != "" {
+		req.Header.Set("Authorization", "Bearer "+os.Getenv(p.APIKeyEnv))
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := client.Do(req)
+	if err != nil {
+		if errors.Is(err, context.DeadlineExceeded) || ctx.Err() == context.DeadlineExceeded {
+			return "", Usage{}, context.DeadlineExceeded
+		}
+		return "", Usage{}, err
+	}
+	defer resp.Body.Close()
+
+	var result map[string]interface{}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return "", Usage{}, fmt.Errorf("failed to decode AI response: %w", err)
+	}
+
+	choices, ok := result["choices"].([]interface{})
+	if !ok || len(choices) == 0 {
+		log.Printf("%s response: %+v", p.Name, result)
+		return "", Usage{}, fmt.Errorf("invalid response from AI provider: no choices")
+	}
+
+	choice, ok := choices[0].(map[string]interface{})
+	if !ok {
+		return "", Usage{}, fmt.Errorf("invalid response from AI provider: malformed choice")
+	}
+
+	message, ok := choice["message"].(map[string]interface{})
+	if !ok {
+		return "", Usage{}, fmt.Errorf("invalid response from AI provider: malformed message")
+	}
+
+	content, ok := message["content"].(string)
+	if !ok {
+		return "", Usage{}, fmt.Errorf("invalid response from AI provider: missing content")
+	}
+
+	var usage Usage
+	if raw, ok := result["usage"].(map[string]interface{}); ok {
+		usage = ParseUsage(raw)
+	}
+	return content, usage, nil
+}
@@ -0,0 +1,84 @@
+package providers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestParse(t *testing.T) {
+	t.Setenv("OPENAI_MODEL", "gpt-test")
+	chain, err := Parse([]string{"openrouter", "openai", "ollama"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(chain) != 3 || chain[0].Model != "" || chain[1].Model != "gpt-test" || chain[2].APIKeyEnv != "" {
+		t.Errorf("unexpected providers %+v", chain)
+	}
+
+	for _, names := range [][]string{{"anthropic"}, {"openrouter", "openrouter"}, {}} {
+		if _, err := Parse(names); err == nil {
+			t.Errorf("%v: expected an error", names)
+		}
+	}
+}
+
+func TestParseUsage(t *testing.T) {
+	var raw map[string]interface{}
+	json.Unmarshal([]byte(`{"prompt_tokens":120,"completion_tokens":35,"total_tokens":155,"cost":0.00042}`), &raw)
+	if got := ParseUsage(raw); got != (Usage{PromptTokens: 120, CompletionTokens: 35, Cost: 0.00042}) {
+		t.Errorf("unexpected usage %+v", got)
+	}
+	if got := ParseUsage(map[string]interface{}{"prompt_tokens": "many"}); got != (Usage{}) {
+		t.Errorf("expected malformed fields to be ignored, got %+v", got)
+	}
+}
+
+func TestComplete(t *testing.T) {
+	var got struct {
+		auth, correlation string
+		body              map[string]interface{}
+	}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got.auth = r.Header.Get("Authorization")
+		got.correlation = r.Header.Get("X-Correlation-ID")
+		data, _ := io.ReadAll(r.Body)
+		json.Unmarshal(data, &got.body)
+		io.WriteString(w, `{"choices":[{"message":{"content":"a summary"}}],"usage":{"prompt_tokens":12,"completion_tokens":3}}`)
+	}))
+	defer srv.Close()
+	t.Setenv("OPENROUTER_API_KEY", "or-key")
+	t.Setenv("OPENROUTER_URL", srv.URL)
+	p, _ := Known("openrouter")
+
+	header := http.Header{}
+	header.Set("X-Correlation-ID", "cid-1")
+	content, usage, err := p.Complete(context.Background(), srv.Client(), map[string]interface{}{"model": "m"}, header)
+	if err != nil || content != "a summary" || usage != (Usage{PromptTokens: 12, CompletionTokens: 3}) {
+		t.Fatalf("unexpected result %q %+v %v", content, usage, err)
+	}
+	if got.auth != "Bearer or-key" || got.correlation != "cid-1" || got.body["usage"] == nil {
+		t.Errorf("unexpected request: %+v", got)
+	}
+}
+
+func TestComplete_DeadlineExceeded(t *testing.T) {
+	release := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+	defer srv.Close()
+	defer close(release)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	p := Provider{Name: "ollama", URL: srv.URL}
+	if _, _, err := p.Complete(ctx, srv.Client(), map[string]interface{}{}, nil); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected context.DeadlineExceeded, got %v", err)
+	}
+}
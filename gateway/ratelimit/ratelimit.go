@@ -1,4 +1,6 @@
-package main
+// Package ratelimit implements the token bucket rate limiter used by the
+// gateway. It has no gateway dependencies and can be embedded directly.
+package ratelimit
 
 import (
 	"math"
@@ -27,11 +29,13 @@ type bucket struct {
 
 // TokenBucket implements the token bucket rate limiting algorithm
 type TokenBucket struct {
+	paramsMu   sync.RWMutex  // Guards rate and burst; held for writing by SetLimits
 	rate       float64       // Tokens added per second
 	burst      int           // Maximum tokens in bucket
 	buckets    sync.Map      // map[string]*bucket - thread-safe map of user buckets
 	cleanupTTL time.Duration // Time after which inactive buckets are cleaned up
 	stopCh     chan struct{} // Channel to stop cleanup goroutine
+	stopOnce   sync.Once
 }
 
 // NewTokenBucket creates a new TokenBucket rate limiter
@@ -78,6 +82,8 @@ func (tb *TokenBucket) Allow(key string) bool {
 
 // AllowN checks if N requests are allowed and consumes N tokens if available
 func (tb *TokenBucket) AllowN(key string, n int) bool {
+	tb.paramsMu.RLock()
+	defer tb.paramsMu.RUnlock()
 	b := tb.getBucket(key)
 	b.mu.Lock()
 	defer b.mu.Unlock()
@@ -100,6 +106,8 @@ func (tb *TokenBucket) AllowN(key string, n int) bool {
 
 // GetRemaining returns the number of remaining tokens for the given key
 func (tb *TokenBucket) GetRemaining(key string) int {
+	tb.paramsMu.RLock()
+	defer tb.paramsMu.RUnlock()
 	val, ok := tb.buckets.Load(key)
 	if !ok {
 		return tb.burst
@@ -118,6 +126,8 @@ func (tb *TokenBucket) GetRemaining(key string) int {
 
 // GetResetTime returns the Unix timestamp when the bucket will be fully refilled
 func (tb *TokenBucket) GetResetTime(key string) int64 {
+	tb.paramsMu.RLock()
+	defer tb.paramsMu.RUnlock()
 	val, ok := tb.buckets.Load(key)
 	if !ok {
 		return time.Now().Unix()
@@ -142,12 +152,108 @@ func (tb *TokenBucket) GetResetTime(key string) int64 {
 	return resetTime.Unix()
 }
 
-// cleanup runs in a background goroutine to remove stale buckets
-// This prevents memory leaks from inactive users
+// Len returns the number of buckets currently tracked, one per active key.
+// Buckets idle for longer than cleanupTTL are dropped by the cleanup loop.
+func (tb *TokenBucket) Len() int {
+	n := 0
+	tb.buckets.Range(func(_, _ interface{}) bool {
+		n++
+		return true
+	})
+	return n
+}
+
+// Limits returns the current requests per minute and burst size.
+func (tb *TokenBucket) Limits() (rpm int, burst int) {
+	tb.paramsMu.RLock()
+	defer tb.paramsMu.RUnlock()
+	return int(math.Round(tb.rate * 60)), tb.burst
+}
+
+// SetLimits changes the rate and burst in place, keeping every key's bucket.
+// Each bucket is first refilled at the old rate, then its tokens are scaled
+// by newBurst/oldBurst, so a client that had used half its burst still has
+// half of the new one. Non-positive values are treated as 1, as in
+// NewTokenBucket.
+func (tb *TokenBucket) SetLimits(rpm int, burst int) {
+	if rpm <= 0 {
+		rpm = 1
+	}
+	if burst <= 0 {
+		burst = 1
+	}
+
+	tb.paramsMu.Lock()
+	defer tb.paramsMu.Unlock()
+	scale := float64(burst) / float64(tb.burst)
+	now := time.Now()
+	tb.buckets.Range(func(_, value interface{}) bool {
+		b := value.(*bucket)
+		b.mu.Lock()
+		tokens := math.Min(float64(tb.burst), b.tokens+now.Sub(b.lastCheck).Seconds()*tb.rate)
+		b.tokens = tokens * scale
+		b.lastCheck = now
+		b.mu.Unlock()
+		return true
+	})
+	tb.rate = float64(rpm) / 60.0
+	tb.burst = burst
+}
+
+// BucketState is the saved state of one key's bucket: its tokens as of
+// LastCheck. Saved buckets keep refilling from LastCheck once restored, so
+// time spent down counts towards the refill.
+type BucketState struct {
+	Key       string    `json:"key"`
+	Tokens    float64   `json:"tokens"`
+	LastCheck time.Time `json:"last_check"`
+}
+
+// Snapshot returns the state of every bucket that is not full. Full buckets
+// are left out: a new bucket starts full anyway.
+func (tb *TokenBucket) Snapshot() []BucketState {
+	tb.paramsMu.RLock()
+	defer tb.paramsMu.RUnlock()
+	now := time.Now()
+	var states []BucketState
+	tb.buckets.Range(func(key, value interface{}) bool {
+		b := value.(*bucket)
+		b.mu.Lock()
+		tokens := math.Min(float64(tb.burst), b.tokens+now.Sub(b.lastCheck).Seconds()*tb.rate)
+		b.mu.Unlock()
+		if tokens < float64(tb.burst) {
+			states = append(states, BucketState{Key: key.(string), Tokens: tokens, LastCheck: now})
+		}
+		return true
+	})
+	return states
+}
+
+// Restore installs saved bucket states, with tokens capped at the current
+// burst. Keys that already have a bucket keep it, and a LastCheck in the
+// future is taken as now.
+func (tb *TokenBucket) Restore(states []BucketState) {
+	tb.paramsMu.RLock()
+	defer tb.paramsMu.RUnlock()
+	now := time.Now()
+	for _, s := range states {
+		last := s.LastCheck
+		if last.After(now) {
+			last = now
+		}
+		tokens := math.Max(0, math.Min(float64(tb.burst), s.Tokens))
+		tb.buckets.LoadOrStore(s.Key, &bucket{tokens: tokens, lastCheck: last})
+	}
+}
+
+// Stop terminates the background cleanup goroutine. It is safe to call more
+// than once.
 func (tb *TokenBucket) Stop() {
-	close(tb.stopCh)
+	tb.stopOnce.Do(func() { close(tb.stopCh) })
 }
 
+// cleanup runs in a background goroutine to remove stale buckets
+// This prevents memory leaks from inactive users
 func (tb *TokenBucket) cleanup() {
 	ticker := time.NewTicker(tb.cleanupTTL)
 	defer ticker.Stop()
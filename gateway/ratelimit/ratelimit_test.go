@@ -1,4 +1,4 @@
-package main
+package ratelimit
 
 import (
 	"sync"
@@ -223,6 +223,12 @@ func TestTokenBucketCleanup(t *testing.T) {
 	}
 }
 
+func TestTokenBucketStopIdempotent(t *testing.T) {
+	tb := NewTokenBucket(60, 5, time.Minute)
+	tb.Stop()
+	tb.Stop() // a second shutdown must not panic
+}
+
 // stopCleanup stops the cleanup goroutine by deleting all buckets
 // This is a helper to prevent goroutine leaks in tests
 func stopCleanup(tb *TokenBucket) {
@@ -255,3 +261,91 @@ func BenchmarkTokenBucketConcurrent(b *testing.B) {
 		}
 	})
 }
+
+// TestTokenBucketLen counts one bucket per key
+func TestTokenBucketLen(t *testing.T) {
+	tb := NewTokenBucket(60, 5, 5*time.Minute)
+	defer stopCleanup(tb)
+
+	if tb.Len() != 0 {
+		t.Errorf("expected no buckets, got %d", tb.Len())
+	}
+	tb.Allow("a")
+	tb.Allow("a")
+	tb.Allow("b")
+	tb.GetRemaining("c") // lookups do not create buckets
+	if tb.Len() != 2 {
+		t.Errorf("expected 2 buckets, got %d", tb.Len())
+	}
+}
+
+// TestTokenBucketSetLimits tests that retuning keeps buckets and rescales them
+func TestTokenBucketSetLimits(t *testing.T) {
+	tb := NewTokenBucket(1, 10, 5*time.Minute)
+	defer stopCleanup(tb)
+
+	for i := 0; i < 5; i++ {
+		tb.Allow("half")
+	}
+	tb.Allow("fresh-ish")
+
+	tb.SetLimits(120, 20)
+	if rpm, burst := tb.Limits(); rpm != 120 || burst != 20 {
+		t.Errorf("expected limits 120/20, got %d/%d", rpm, burst)
+	}
+	if got := tb.GetRemaining("half"); got != 10 {
+		t.Errorf("expected half of the new burst (10), got %d", got)
+	}
+	if got := tb.GetRemaining("fresh-ish"); got != 18 {
+		t.Errorf("expected 9/10 of the new burst (18), got %d", got)
+	}
+	if got := tb.GetRemaining("unseen"); got != 20 {
+		t.Errorf("expected a new key to get the new burst, got %d", got)
+	}
+	if tb.Len() != 2 {
+		t.Errorf("expected both buckets to be kept, got %d", tb.Len())
+	}
+
+	tb.SetLimits(120, 4)
+	if got := tb.GetRemaining("half"); got != 2 {
+		t.Errorf("expected shrinking to scale tokens down to 2, got %d", got)
+	}
+}
+
+// TestTokenBucketSnapshotRestore tests that saved buckets carry over to a new
+// limiter and keep refilling while it was down
+func TestTokenBucketSnapshotRestore(t *testing.T) {
+	tb := NewTokenBucket(1, 5, 5*time.Minute)
+	defer stopCleanup(tb)
+	for i := 0; i < 5; i++ {
+		tb.Allow("drained")
+	}
+	tb.Allow("partial")
+	tb.GetRemaining("untouched")
+
+	states := tb.Snapshot()
+	if len(states) != 2 {
+		t.Fatalf("expected only the two non-full buckets, got %+v", states)
+	}
+
+	restored := NewTokenBucket(60, 3, 5*time.Minute)
+	defer stopCleanup(restored)
+	restored.Allow("partial")
+	for i := range states {
+		if states[i].Key == "drained" {
+			// Saved two seconds ago: one token at 60 rpm has come back since.
+			states[i].LastCheck = states[i].LastCheck.Add(-2 * time.Second)
+		}
+	}
+	restored.Restore(append(states, BucketState{Key: "future", Tokens: 9, LastCheck: time.Now().Add(time.Hour)}))
+
+	if got := restored.GetRemaining("drained"); got != 2 {
+		t.Errorf("expected the drained bucket to have refilled 2 tokens, got %d", got)
+	}
+	if got := restored.GetRemaining("partial"); got != 2 {
+		t.Errorf("expected the live bucket to be kept, got %d", got)
+	}
+	if got := restored.GetRemaining("future"); got != 3 {
+		t.Errorf("expected tokens capped at the burst, got %d", got)
+	}
+}
@@ -0,0 +1,238 @@
+package receipts
+
+import (
+	"crypto/ecdsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"gateway/signing"
+
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/fxamacker/cbor/v2"
+)
+
+// Receipts can also be issued in standards-based envelopes for systems that
+// do not speak the native Keccak256/recoverable-signature format. Both use
+// ES256K (ECDSA over secp256k1 with SHA-256) and the same server key, and the
+// key ID is the server's Ethereum address.
+
+// jwsHeader is the protected header of a compact JWS receipt.
+type jwsHeader struct {
+	Alg string `json:"alg"`
+	Typ string `json:"typ"`
+	Kid string `json:"kid"`
+}
+
+const (
+	jwsAlgorithm = "ES256K"
+	jwsType      = "x402-receipt+json"
+
+	// coseAlgES256K is the COSE algorithm identifier for ES256K (RFC 8812).
+	coseAlgES256K  = -47
+	coseHeaderAlg  = 1
+	coseHeaderKid  = 4
+	coseSign1Tag   = 18
+	coseSignature1 = "Signature1"
+)
+
+// coseSign1 is the COSE_Sign1 array (RFC 9052 section 4.2).
+type coseSign1 struct {
+	_           struct{} `cbor:",toarray"`
+	Protected   []byte
+	Unprotected map[int]interface{}
+	Payload     []byte
+	Signature   []byte
+}
+
+// cborEncMode is deterministic (RFC 8949 core rules) and writes timestamps in
+// the same RFC 3339 form as the JSON encoding.
+var cborEncMode = func() cbor.EncMode {
+	opts := cbor.CoreDetEncOptions()
+	opts.Time = cbor.TimeRFC3339Nano
+	mode, err := opts.EncMode()
+	if err != nil {
+		panic(err)
+	}
+	return mode
+}()
+
+// EncodeJWS returns receipt as a compact JWS (RFC 7515) whose payload is the
+// receipt's JSON encoding.
+func EncodeJWS(receipt Receipt, signer signing.Signer) (string, error) {
+	if signer == nil {
+		return "", fmt.Errorf("signer is nil")
+	}
+	header, err := json.Marshal(jwsHeader{Alg: jwsAlgorithm, Typ: jwsType, Kid: keyID(signer.Public())})
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal JWS header: %w", err)
+	}
+	payload, err := json.Marshal(receipt)
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal receipt: %w", err)
+	}
+
+	signingInput := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	sig, err := signES256K([]byte(signingInput), signer)
+	if err != nil {
+		return "", err
+	}
+	return signingInput + "." + base64.RawURLEncoding.EncodeToString(sig), nil
+}
+
+// VerifyJWS checks a compact JWS receipt against trusted and returns the
+// receipt it carries.
+func VerifyJWS(token string, trusted *ecdsa.PublicKey) (*Receipt, error) {
+	if trusted == nil {
+		return nil, fmt.Errorf("trusted key is required")
+	}
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, fmt.Errorf("malformed JWS: expected 3 parts, got %d", len(parts))
+	}
+
+	headerBytes, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return nil, fmt.Errorf("invalid JWS header encoding: %w", err)
+	}
+	var header jwsHeader
+	if err := json.Unmarshal(headerBytes, &header); err != nil {
+		return nil, fmt.Errorf("invalid JWS header: %w", err)
+	}
+	if header.Alg != jwsAlgorithm {
+		return nil, fmt.Errorf("unsupported JWS algorithm %q", header.Alg)
+	}
+
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, fmt.Errorf("invalid JWS signature encoding: %w", err)
+	}
+	if !verifyES256K([]byte(parts[0]+"."+parts[1]), sig, trusted) {
+		return nil, fmt.Errorf("invalid JWS signature")
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return nil, fmt.Errorf("invalid JWS payload encoding: %w", err)
+	}
+	var receipt Receipt
+	if err := json.Unmarshal(payload, &receipt); err != nil {
+		return nil, fmt.Errorf("invalid JWS payload: %w", err)
+	}
+	return &receipt, nil
+}
+
+// EncodeCOSE returns receipt as a tagged COSE_Sign1 message (RFC 9052) whose
+// payload is the receipt's deterministic CBOR encoding.
+func EncodeCOSE(receipt Receipt, signer signing.Signer) ([]byte, error) {
+	if signer == nil {
+		return nil, fmt.Errorf("signer is nil")
+	}
+	protected, err := cborEncMode.Marshal(map[int]interface{}{
+		coseHeaderAlg: coseAlgES256K,
+		coseHeaderKid: []byte(keyID(signer.Public())),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode COSE header: %w", err)
+	}
+	payload, err := cborEncMode.Marshal(receipt)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode receipt: %w", err)
+	}
+
+	toBeSigned, err := coseSigStructure(protected, payload)
+	if err != nil {
+		return nil, err
+	}
+	sig, err := signES256K(toBeSigned, signer)
+	if err != nil {
+		return nil, err
+	}
+
+	return cborEncMode.Marshal(cbor.Tag{
+		Number: coseSign1Tag,
+		Content: coseSign1{
+			Protected:   protected,
+			Unprotected: map[int]interface{}{},
+			Payload:     payload,
+			Signature:   sig,
+		},
+	})
+}
+
+// VerifyCOSE checks a COSE_Sign1 receipt against trusted and returns the
+// receipt it carries.
+func VerifyCOSE(data []byte, trusted *ecdsa.PublicKey) (*Receipt, error) {
+	if trusted == nil {
+		return nil, fmt.Errorf("trusted key is required")
+	}
+	var tag cbor.RawTag
+	if err := cbor.Unmarshal(data, &tag); err != nil {
+		return nil, fmt.Errorf("invalid COSE message: %w", err)
+	}
+	if tag.Number != coseSign1Tag {
+		return nil, fmt.Errorf("not a COSE_Sign1 message (tag %d)", tag.Number)
+	}
+	var msg coseSign1
+	if err := cbor.Unmarshal(tag.Content, &msg); err != nil {
+		return nil, fmt.Errorf("invalid COSE_Sign1 structure: %w", err)
+	}
+
+	var protected map[int]interface{}
+	if err := cbor.Unmarshal(msg.Protected, &protected); err != nil {
+		return nil, fmt.Errorf("invalid COSE protected header: %w", err)
+	}
+	if alg, _ := protected[coseHeaderAlg].(int64); alg != coseAlgES256K {
+		return nil, fmt.Errorf("unsupported COSE algorithm %v", protected[coseHeaderAlg])
+	}
+
+	toBeSigned, err := coseSigStructure(msg.Protected, msg.Payload)
+	if err != nil {
+		return nil, err
+	}
+	if !verifyES256K(toBeSigned, msg.Signature, trusted) {
+		return nil, fmt.Errorf("invalid COSE signature")
+	}
+
+	var receipt Receipt
+	if err := cbor.Unmarshal(msg.Payload, &receipt); err != nil {
+		return nil, fmt.Errorf("invalid COSE payload: %w", err)
+	}
+	return &receipt, nil
+}
+
+// coseSigStructure builds the Sig_structure signed for COSE_Sign1 with no
+// external AAD.
+func coseSigStructure(protected, payload []byte) ([]byte, error) {
+	b, err := cborEncMode.Marshal([]interface{}{coseSignature1, protected, []byte{}, payload})
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode COSE Sig_structure: %w", err)
+	}
+	return b, nil
+}
+
+// signES256K returns the 64-byte r||s signature over SHA-256(data).
+func signES256K(data []byte, signer signing.Signer) ([]byte, error) {
+	digest := sha256.Sum256(data)
+	sig, err := signer.SignHash(digest[:])
+	if err != nil {
+		return nil, fmt.Errorf("failed to sign receipt: %w", err)
+	}
+	// Drop the recovery byte; JOSE and COSE use plain r||s.
+	return sig[:64], nil
+}
+
+func verifyES256K(data, sig []byte, publicKey *ecdsa.PublicKey) bool {
+	if len(sig) != 64 {
+		return false
+	}
+	digest := sha256.Sum256(data)
+	return crypto.VerifySignature(crypto.FromECDSAPub(publicKey), digest[:], sig)
+}
+
+// keyID identifies the signing key by its Ethereum address.
+func keyID(publicKey *ecdsa.PublicKey) string {
+	return crypto.PubkeyToAddress(*publicKey).Hex()
+}
@@ -0,0 +1,92 @@
+package receipts
+
+import (
+	"crypto/ecdsa"
+	"encoding/base64"
+	"strings"
+	"testing"
+
+	"gateway/payments"
+	"gateway/signing"
+
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+func newSignedTestReceipt(t *testing.T) (*SignedReceipt, *ecdsa.PrivateKey) {
+	t.Helper()
+	key, err := crypto.GenerateKey()
+	if err != nil {
+		t.Fatalf("GenerateKey failed: %v", err)
+	}
+	signed, err := Generate(signing.FromKey(key), payments.Context{Amount: "0.001", Token: "USDC", Nonce: "fmt-nonce", ChainID: 8453},
+		"0xpayer", "/api/ai/summarize", []byte("req"), []byte("resp"), WithSequence(3), WithModel("m", ""))
+	if err != nil {
+		t.Fatalf("Generate failed: %v", err)
+	}
+	return signed, key
+}
+
+func TestJWS_RoundTrip(t *testing.T) {
+	signed, key := newSignedTestReceipt(t)
+
+	token, err := EncodeJWS(signed.Receipt, signing.FromKey(key))
+	if err != nil {
+		t.Fatalf("EncodeJWS failed: %v", err)
+	}
+	if strings.Count(token, ".") != 2 {
+		t.Fatalf("expected compact serialization, got %s", token)
+	}
+	header, _ := base64.RawURLEncoding.DecodeString(strings.Split(token, ".")[0])
+	if !strings.Contains(string(header), `"alg":"ES256K"`) || !strings.Contains(string(header), crypto.PubkeyToAddress(key.PublicKey).Hex()) {
+		t.Errorf("unexpected JWS header: %s", header)
+	}
+
+	got, err := VerifyJWS(token, &key.PublicKey)
+	if err != nil {
+		t.Fatalf("VerifyJWS failed: %v", err)
+	}
+	if got.ID != signed.Receipt.ID || got.Payment.Sequence != 3 || !got.Timestamp.Equal(signed.Receipt.Timestamp) {
+		t.Errorf("round-tripped receipt differs: %+v", got)
+	}
+
+	other, _ := crypto.GenerateKey()
+	if _, err := VerifyJWS(token, &other.PublicKey); err == nil {
+		t.Error("expected verification with a different key to fail")
+	}
+	parts := strings.Split(token, ".")
+	tampered := parts[0] + "." + base64.RawURLEncoding.EncodeToString([]byte(`{"id":"rcpt_forged"}`)) + "." + parts[2]
+	if _, err := VerifyJWS(tampered, &key.PublicKey); err == nil {
+		t.Error("expected tampered payload to fail verification")
+	}
+}
+
+func TestCOSE_RoundTrip(t *testing.T) {
+	signed, key := newSignedTestReceipt(t)
+
+	msg, err := EncodeCOSE(signed.Receipt, signing.FromKey(key))
+	if err != nil {
+		t.Fatalf("EncodeCOSE failed: %v", err)
+	}
+	// Tag 18 (COSE_Sign1) followed by a 4-element array.
+	if msg[0] != 0xd2 || msg[1] != 0x84 {
+		t.Fatalf("expected tagged COSE_Sign1, got prefix %x", msg[:2])
+	}
+
+	again, _ := EncodeCOSE(signed.Receipt, signing.FromKey(key))
+	if len(again) != len(msg) {
+		t.Error("expected deterministic CBOR payload encoding")
+	}
+
+	got, err := VerifyCOSE(msg, &key.PublicKey)
+	if err != nil {
+		t.Fatalf("VerifyCOSE failed: %v", err)
+	}
+	if got.ID != signed.Receipt.ID || got.Service.Model != "m" || !got.Timestamp.Equal(signed.Receipt.Timestamp) {
+		t.Errorf("round-tripped receipt differs: %+v", got)
+	}
+
+	other, _ := crypto.GenerateKey()
+	if _, err := VerifyCOSE(msg, &other.PublicKey); err == nil {
+		t.Error("expected verification with a different key to fail")
+	}
+}
@@ -0,0 +1,466 @@
+// Package receipts creates, signs and verifies the cryptographic receipts
+// the gateway issues for every paid request. Receipts are JSON-serialized,
+// hashed with Keccak256 and signed with the server's secp256k1 key, so they
+// can be checked by any Ethereum-compatible tooling.
+package receipts
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"gateway/payments"
+	"gateway/signing"
+
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+// Receipt represents a cryptographic payment receipt
+type Receipt struct {
+	ID      string `json:"id"`
+	Version string `json:"version"`
+	// KeyID is the Ethereum address of the key that issued the receipt, as
+	// in the kid of JWS and COSE receipts; re-signing keeps it. Since 2.0.
+	KeyID     string         `json:"kid,omitempty"`
+	Timestamp time.Time      `json:"timestamp"`
+	Payment   PaymentDetails `json:"payment"`
+	Service   ServiceDetails `json:"service"`
+}
+
+// PaymentDetails contains payment-related information
+type PaymentDetails struct {
+	Payer     string `json:"payer"`
+	Recipient string `json:"recipient"`
+	Amount    string `json:"amount"`
+	Token     string `json:"token"`
+	ChainID   int    `json:"chainId"`
+	Nonce     string `json:"nonce"`
+	// Sequence increases by one for every receipt issued to Payer, so gaps
+	// reveal receipts missing from the payer's records.
+	Sequence int64 `json:"sequence,omitempty"`
+	// Sponsor paid for Payer's request under its grant SponsorGrant.
+	Sponsor      string `json:"sponsor,omitempty"`
+	SponsorGrant string `json:"sponsor_grant,omitempty"`
+}
+
+// ServiceDetails contains service-related information
+type ServiceDetails struct {
+	Endpoint     string `json:"endpoint"`
+	RequestHash  string `json:"request_hash"`
+	ResponseHash string `json:"response_hash"`
+	// Model is the AI model that produced the response.
+	Model string `json:"model,omitempty"`
+	// SubstitutedFor names the preferred model when a backup model was used.
+	SubstitutedFor string `json:"substituted_for,omitempty"`
+	// Provider is the AI provider that served the request.
+	Provider string `json:"provider,omitempty"`
+	// Dimensions is the vector length of an embeddings response.
+	Dimensions int `json:"dimensions,omitempty"`
+	// Parameters are the generation parameters sent to the model, if any
+	// were set by the request.
+	Parameters *GenerationParams `json:"parameters,omitempty"`
+	// Source is the type of document the input text was extracted from
+	// ("pdf", "html" or "text"); empty when the text was sent directly.
+	Source string `json:"source,omitempty"`
+	// SourceURL is the URL the document was fetched from, if any.
+	SourceURL string `json:"source_url,omitempty"`
+	// Tokens is the provider's token usage for the request. Since 2.0.
+	Tokens *TokenUsage `json:"tokens,omitempty"`
+}
+
+// TokenUsage counts the prompt and completion tokens a provider billed.
+type TokenUsage struct {
+	Input  int64 `json:"input"`
+	Output int64 `json:"output"`
+}
+
+// GenerationParams are optional sampling parameters for a completion. Nil
+// fields were left at the provider's default. OutputLanguage is the ISO
+// 639-1 code of the language the answer was asked for in, requested or
+// detected from the input; empty when neither applied. Sentences, Style and
+// MaxWords shape the summary: its length in sentences (or bullet points),
+// "bullet", "abstract" or "tldr", and a word limit.
+type GenerationParams struct {
+	Temperature    *float64 `json:"temperature,omitempty"`
+	MaxTokens      *int     `json:"max_tokens,omitempty"`
+	TopP           *float64 `json:"top_p,omitempty"`
+	OutputLanguage string   `json:"output_language,omitempty"`
+	Sentences      *int     `json:"sentences,omitempty"`
+	Style          string   `json:"style,omitempty"`
+	MaxWords       *int     `json:"max_words,omitempty"`
+}
+
+// IsZero reports whether no parameter is set.
+func (p GenerationParams) IsZero() bool {
+	return p.Temperature == nil && p.MaxTokens == nil && p.TopP == nil && p.OutputLanguage == "" &&
+		p.Sentences == nil && p.Style == "" && p.MaxWords == nil
+}
+
+// SignedReceipt contains the receipt and its cryptographic signature
+type SignedReceipt struct {
+	Receipt         Receipt `json:"receipt"`
+	Signature       string  `json:"signature"`
+	ServerPublicKey string  `json:"server_public_key"`
+	// PreviousSignatures are the signatures the receipt carried before it
+	// was re-signed with a new server key, oldest first. Each still
+	// verifies against Receipt with its own key.
+	PreviousSignatures []PreviousSignature `json:"previous_signatures,omitempty"`
+}
+
+// PreviousSignature is a signature replaced by Resign.
+type PreviousSignature struct {
+	Signature       string    `json:"signature"`
+	ServerPublicKey string    `json:"server_public_key"`
+	ReplacedAt      time.Time `json:"replaced_at"`
+}
+
+// Option adds optional details to a receipt before it is signed.
+type Option func(*Receipt)
+
+// WithID issues the receipt under id, from NewID, instead of a new one, so
+// a response body can name the receipt that hashes it.
+func WithID(id string) Option {
+	return func(r *Receipt) {
+		r.ID = id
+	}
+}
+
+// WithModel records the model that served the request and, if failover was
+// applied, the preferred model it replaced.
+func WithModel(model, substitutedFor string) Option {
+	return func(r *Receipt) {
+		r.Service.Model = model
+		r.Service.SubstitutedFor = substitutedFor
+	}
+}
+
+// WithProvider records the AI provider that served the request.
+func WithProvider(provider string) Option {
+	return func(r *Receipt) {
+		r.Service.Provider = provider
+	}
+}
+
+// WithDimensions records the dimensionality of returned embedding vectors.
+func WithDimensions(n int) Option {
+	return func(r *Receipt) {
+		r.Service.Dimensions = n
+	}
+}
+
+// WithParameters records the generation parameters the model was called
+// with. Nothing is recorded when none were set.
+func WithParameters(p GenerationParams) Option {
+	return func(r *Receipt) {
+		if !p.IsZero() {
+			r.Service.Parameters = &p
+		}
+	}
+}
+
+// WithSource records the type of document the input was extracted from
+// and, if it was fetched, its URL.
+func WithSource(source, url string) Option {
+	return func(r *Receipt) {
+		r.Service.Source = source
+		r.Service.SourceURL = url
+	}
+}
+
+// WithTokens records the provider's token usage. Nothing is recorded when
+// both counts are zero, e.g. for cached responses.
+func WithTokens(input, output int64) Option {
+	return func(r *Receipt) {
+		if input != 0 || output != 0 {
+			r.Service.Tokens = &TokenUsage{Input: input, Output: output}
+		}
+	}
+}
+
+// WithSequence records the payer's receipt sequence number.
+func WithSequence(seq int64) Option {
+	return func(r *Receipt) {
+		r.Payment.Sequence = seq
+	}
+}
+
+// WithSponsor records the sponsor that paid for the request and the ID of
+// its grant.
+func WithSponsor(sponsor, grantID string) Option {
+	return func(r *Receipt) {
+		r.Payment.Sponsor = sponsor
+		r.Payment.SponsorGrant = grantID
+	}
+}
+
+// Generate creates and signs a new receipt for a successful payment. The
+// payment amount is validated and recorded in canonical form.
+func Generate(signer signing.Signer, payment payments.Context, payer string, endpoint string, reqBody, respBody []byte, opts ...Option) (*SignedReceipt, error) {
+	if signer == nil {
+		return nil, fmt.Errorf("signer is nil")
+	}
+	amount, err := payment.ParseAmount()
+	if err != nil {
+		return nil, fmt.Errorf("invalid payment amount: %w", err)
+	}
+	receiptID, err := NewID()
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate receipt ID: %w", err)
+	}
+
+	receipt := Receipt{
+		ID:        receiptID,
+		Version:   Version,
+		KeyID:     keyID(signer.Public()),
+		Timestamp: time.Now().UTC(),
+		Payment: PaymentDetails{
+			Payer:     payer,
+			Recipient: payment.Recipient,
+			Amount:    amount.String(),
+			Token:     payment.Token,
+			ChainID:   payment.ChainID,
+			Nonce:     payment.Nonce,
+		},
+		Service: ServiceDetails{
+			Endpoint:     endpoint,
+			RequestHash:  HashData(reqBody),
+			ResponseHash: HashData(respBody),
+		},
+	}
+	for _, opt := range opts {
+		opt(&receipt)
+	}
+
+	return Sign(receipt, signer)
+}
+
+// NewID generates a unique receipt ID with "rcpt_" prefix
+// Returns error if random generation fails to prevent predictable IDs
+func NewID() (string, error) {
+	// Generate 6 random bytes (12 hex characters)
+	bytes := make([]byte, 6)
+	if _, err := rand.Read(bytes); err != nil {
+		return "", fmt.Errorf("failed to generate random receipt ID: %w", err)
+	}
+	return "rcpt_" + hex.EncodeToString(bytes), nil
+}
+
+// EncodeResponse encodes a response body as the bytes its ResponseHash
+// covers: encoding/json's field order (struct declaration order, sorted map
+// keys), with <, > and & left as they are (U+2028 and U+2029 stay escaped)
+// and no trailing newline. Callers send exactly these bytes rather than
+// encoding the body again.
+func EncodeResponse(v interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(v); err != nil {
+		return nil, err
+	}
+	return bytes.TrimSuffix(buf.Bytes(), []byte("\n")), nil
+}
+
+// HashData computes SHA-256 hash of data and returns hex-encoded string
+func HashData(data []byte) string {
+	if len(data) == 0 {
+		return "sha256:e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855" // Empty hash
+	}
+	hash := sha256.Sum256(data)
+	return "sha256:" + hex.EncodeToString(hash[:])
+}
+
+// Sign signs a receipt with the server key, over the encoding of its
+// schema version. A 2.0 receipt without a kid gets the signer's.
+// NOTE: Go's json.Marshal is deterministic for structs - fields are always
+// serialized in the order they are defined in the struct, ensuring consistent output.
+// This guarantees consistent signatures across multiple marshaling operations.
+func Sign(receipt Receipt, signer signing.Signer) (*SignedReceipt, error) {
+	if signer == nil {
+		return nil, fmt.Errorf("signer is nil")
+	}
+	receipt, err := forVersion(receipt)
+	if err != nil {
+		return nil, err
+	}
+	if receipt.Version != Version1 && receipt.KeyID == "" {
+		receipt.KeyID = keyID(signer.Public())
+	}
+
+	// Serialize receipt deterministically
+	// json.Marshal outputs struct fields in their declaration order
+	receiptBytes, err := json.Marshal(receipt)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal receipt: %w", err)
+	}
+
+	// Hash the receipt using Keccak256 (Ethereum-compatible)
+	hash := crypto.Keccak256Hash(receiptBytes)
+
+	// Sign the hash using ECDSA
+	signature, err := signer.SignHash(hash.Bytes())
+	if err != nil {
+		return nil, fmt.Errorf("failed to sign receipt: %w", err)
+	}
+
+	// Get server's public key for verification
+	publicKeyBytes := crypto.FromECDSAPub(signer.Public())
+
+	return &SignedReceipt{
+		Receipt:         receipt,
+		Signature:       "0x" + hex.EncodeToString(signature),
+		ServerPublicKey: "0x" + hex.EncodeToString(publicKeyBytes),
+	}, nil
+}
+
+// Verify checks that signed carries a valid signature over its receipt. If
+// trusted is non-nil the receipt must also have been signed by that key;
+// otherwise the embedded ServerPublicKey is used, which only proves the
+// receipt was not altered after signing.
+func Verify(signed *SignedReceipt, trusted *ecdsa.PublicKey) error {
+	if signed == nil {
+		return fmt.Errorf("receipt is nil")
+	}
+
+	pubBytes, err := hex.DecodeString(strings.TrimPrefix(signed.ServerPublicKey, "0x"))
+	if err != nil {
+		return fmt.Errorf("invalid server public key: %w", err)
+	}
+	if trusted != nil {
+		want := crypto.FromECDSAPub(trusted)
+		if hex.EncodeToString(want) != hex.EncodeToString(pubBytes) {
+			return fmt.Errorf("receipt was not signed by the trusted key")
+		}
+	}
+
+	sigBytes, err := hex.DecodeString(strings.TrimPrefix(signed.Signature, "0x"))
+	if err != nil {
+		return fmt.Errorf("invalid signature encoding: %w", err)
+	}
+	if len(sigBytes) != crypto.SignatureLength {
+		return fmt.Errorf("invalid signature length: got %d bytes, want %d", len(sigBytes), crypto.SignatureLength)
+	}
+
+	receiptBytes, err := signingBytes(signed.Receipt)
+	if err != nil {
+		return err
+	}
+	hash := crypto.Keccak256Hash(receiptBytes)
+
+	// Drop the recovery ID; VerifySignature expects the 64-byte [R || S] form.
+	if !crypto.VerifySignature(pubBytes, hash.Bytes(), sigBytes[:64]) {
+		return fmt.Errorf("signature does not match receipt")
+	}
+	if signed.Receipt.Version != Version1 && !issuedBy(signed, pubBytes) {
+		return fmt.Errorf("receipt kid %q does not match the server public key", signed.Receipt.KeyID)
+	}
+	return nil
+}
+
+// Resign signs the receipt of signed with signer, keeping its current
+// signature in PreviousSignatures. The receipt itself is unchanged, so the
+// earlier signatures stay verifiable. A receipt already signed with
+// signer's key is returned as is with changed false; one whose current
+// signature does not verify is refused.
+func Resign(signed *SignedReceipt, signer signing.Signer, now time.Time) (resigned *SignedReceipt, changed bool, err error) {
+	if signer == nil {
+		return nil, false, fmt.Errorf("signer is nil")
+	}
+	if strings.EqualFold(signed.ServerPublicKey, "0x"+hex.EncodeToString(crypto.FromECDSAPub(signer.Public()))) {
+		return signed, false, nil
+	}
+	if err := Verify(signed, nil); err != nil {
+		return nil, false, fmt.Errorf("current signature is invalid: %w", err)
+	}
+	resigned, err = Sign(signed.Receipt, signer)
+	if err != nil {
+		return nil, false, err
+	}
+	resigned.PreviousSignatures = append(append([]PreviousSignature{}, signed.PreviousSignatures...), PreviousSignature{
+		Signature:       signed.Signature,
+		ServerPublicKey: signed.ServerPublicKey,
+		ReplacedAt:      now.UTC(),
+	})
+	return resigned, true, nil
+}
+
+// Validate checks that a receipt has all required fields
+func Validate(receipt *SignedReceipt) error {
+	if receipt == nil {
+		return fmt.Errorf("receipt is nil")
+	}
+
+	// Validate receipt fields
+	if receipt.Receipt.ID == "" {
+		return fmt.Errorf("receipt ID is empty")
+	}
+	if !strings.HasPrefix(receipt.Receipt.ID, "rcpt_") {
+		return fmt.Errorf("receipt ID must start with 'rcpt_'")
+	}
+	if receipt.Receipt.Version == "" {
+		return fmt.Errorf("receipt version is empty")
+	}
+	if !IsSupported(receipt.Receipt.Version) {
+		return fmt.Errorf("unsupported receipt version %q", receipt.Receipt.Version)
+	}
+	if receipt.Receipt.Version != Version1 && receipt.Receipt.KeyID == "" {
+		return fmt.Errorf("receipt kid is empty")
+	}
+	if receipt.Receipt.Timestamp.IsZero() {
+		return fmt.Errorf("receipt timestamp is zero")
+	}
+
+	// Validate payment details
+	if receipt.Receipt.Payment.Payer == "" {
+		return fmt.Errorf("payer address is empty")
+	}
+	if receipt.Receipt.Payment.Recipient == "" {
+		return fmt.Errorf("recipient address is empty")
+	}
+	if receipt.Receipt.Payment.Amount == "" {
+		return fmt.Errorf("payment amount is empty")
+	}
+	if receipt.Receipt.Payment.Token == "" {
+		return fmt.Errorf("token is empty")
+	}
+	if _, err := (payments.Context{Token: receipt.Receipt.Payment.Token, Amount: receipt.Receipt.Payment.Amount}).ParseAmount(); err != nil {
+		return fmt.Errorf("invalid payment amount: %w", err)
+	}
+	if receipt.Receipt.Payment.Nonce == "" {
+		return fmt.Errorf("nonce is empty")
+	}
+
+	// Validate service details
+	if receipt.Receipt.Service.Endpoint == "" {
+		return fmt.Errorf("service endpoint is empty")
+	}
+	if receipt.Receipt.Service.RequestHash == "" {
+		return fmt.Errorf("request hash is empty")
+	}
+	if receipt.Receipt.Service.ResponseHash == "" {
+		return fmt.Errorf("response hash is empty")
+	}
+
+	// Validate signature
+	if receipt.Signature == "" {
+		return fmt.Errorf("signature is empty")
+	}
+	if !strings.HasPrefix(receipt.Signature, "0x") {
+		return fmt.Errorf("signature must start with '0x'")
+	}
+
+	// Validate server public key
+	if receipt.ServerPublicKey == "" {
+		return fmt.Errorf("server public key is empty")
+	}
+	if !strings.HasPrefix(receipt.ServerPublicKey, "0x") {
+		return fmt.Errorf("server public key must start with '0x'")
+	}
+
+	return nil
+}
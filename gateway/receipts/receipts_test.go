@@ -0,0 +1,378 @@
+package receipts
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"gateway/payments"
+	"gateway/signing"
+
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+func TestGenerateReceiptID(t *testing.T) {
+	// Generate multiple IDs and check format
+	ids := make(map[string]bool)
+
+	for i := 0; i < 100; i++ {
+		id, err := NewID()
+		if err != nil {
+			t.Fatalf("NewID() failed: %v", err)
+		}
+
+		// Check format
+		if !strings.HasPrefix(id, "rcpt_") {
+			t.Errorf("Receipt ID should start with 'rcpt_', got: %s", id)
+		}
+
+		// Check length (rcpt_ + 12 hex chars = 17 total)
+		if len(id) != 17 {
+			t.Errorf("Receipt ID should be 17 characters, got %d: %s", len(id), id)
+		}
+
+		// Check uniqueness
+		if ids[id] {
+			t.Errorf("Duplicate receipt ID generated: %s", id)
+		}
+		ids[id] = true
+	}
+}
+
+func TestHashData(t *testing.T) {
+	tests := []struct {
+		name     string
+		data     []byte
+		expected string
+	}{
+		{
+			name:     "Empty data",
+			data:     []byte{},
+			expected: "sha256:e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855",
+		},
+		{
+			name:     "Simple text",
+			data:     []byte("test"),
+			expected: "sha256:" + hashHex([]byte("test")),
+		},
+		{
+			name:     "JSON data",
+			data:     []byte(`{"key":"value"}`),
+			expected: "sha256:" + hashHex([]byte(`{"key":"value"}`)),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result := HashData(tt.data)
+			if result != tt.expected {
+				t.Errorf("HashData() = %v, want %v", result, tt.expected)
+			}
+		})
+	}
+}
+
+func TestReceiptJSONSerialization(t *testing.T) {
+	receipt := Receipt{
+		ID:        "rcpt_abc123def456",
+		Version:   "1.0",
+		Timestamp: time.Date(2024, 1, 15, 10, 30, 0, 0, time.UTC),
+		Payment: PaymentDetails{
+			Payer:     "0x742d35Cc6634C0532925a3b844Bc9e7595f8fE21",
+			Recipient: "0x2cAF48b4BA1C58721a85dFADa5aC01C2DFa62219",
+			Amount:    "0.001",
+			Token:     "USDC",
+			ChainID:   8453,
+			Nonce:     "test-nonce",
+		},
+		Service: ServiceDetails{
+			Endpoint:     "/api/ai/summarize",
+			RequestHash:  "sha256:request",
+			ResponseHash: "sha256:response",
+		},
+	}
+
+	// Serialize twice to check determinism
+	json1, err1 := json.Marshal(receipt)
+	json2, err2 := json.Marshal(receipt)
+
+	if err1 != nil || err2 != nil {
+		t.Fatalf("JSON marshaling failed: %v, %v", err1, err2)
+	}
+
+	if string(json1) != string(json2) {
+		t.Error("JSON serialization is not deterministic")
+	}
+
+	// Verify all fields are present
+	var decoded map[string]interface{}
+	if err := json.Unmarshal(json1, &decoded); err != nil {
+		t.Fatalf("Failed to unmarshal JSON for field verification: %v", err)
+	}
+
+	requiredFields := []string{"id", "version", "timestamp", "payment", "service"}
+	for _, field := range requiredFields {
+		if _, exists := decoded[field]; !exists {
+			t.Errorf("Missing field in JSON: %s", field)
+		}
+	}
+}
+
+func TestHashDataConsistency(t *testing.T) {
+	data := []byte("consistent test data")
+
+	// Hash multiple times
+	hash1 := HashData(data)
+	hash2 := HashData(data)
+	hash3 := HashData(data)
+
+	if hash1 != hash2 || hash2 != hash3 {
+		t.Error("HashData should produce consistent results")
+	}
+}
+
+// Helper function for testing
+func hashHex(data []byte) string {
+	hash := sha256.Sum256(data)
+	return hex.EncodeToString(hash[:])
+}
+
+func TestVerify(t *testing.T) {
+	key, err := crypto.GenerateKey()
+	if err != nil {
+		t.Fatalf("GenerateKey failed: %v", err)
+	}
+	payment := payments.Context{
+		Recipient: "0x2cAF48b4BA1C58721a85dFADa5aC01C2DFa62219",
+		Token:     "USDC",
+		Amount:    "0.001",
+		Nonce:     "verify-nonce",
+		ChainID:   8453,
+	}
+
+	signed, err := Generate(signing.FromKey(key), payment, "0x742d35Cc6634C0532925a3b844Bc9e7595f8fE21", "/api/ai/summarize", []byte("req"), []byte("resp"))
+	if err != nil {
+		t.Fatalf("Generate failed: %v", err)
+	}
+
+	if err := Verify(signed, nil); err != nil {
+		t.Errorf("expected valid receipt, got %v", err)
+	}
+	if err := Verify(signed, &key.PublicKey); err != nil {
+		t.Errorf("expected receipt to verify against trusted key, got %v", err)
+	}
+
+	other, _ := crypto.GenerateKey()
+	if err := Verify(signed, &other.PublicKey); err == nil {
+		t.Error("expected verification against a different trusted key to fail")
+	}
+
+	tampered := *signed
+	tampered.Receipt.Payment.Amount = "100"
+	if err := Verify(&tampered, nil); err == nil {
+		t.Error("expected tampered receipt to fail verification")
+	}
+}
+
+func TestResign(t *testing.T) {
+	oldKey, _ := crypto.GenerateKey()
+	newKey, _ := crypto.GenerateKey()
+	payment := payments.Context{Token: "USDC", Amount: "0.001", Nonce: "resign-nonce"}
+	signed, err := Generate(signing.FromKey(oldKey), payment, "0xpayer", "/api/ai/summarize", []byte("req"), []byte("resp"))
+	if err != nil {
+		t.Fatalf("Generate failed: %v", err)
+	}
+
+	now := time.Date(2026, 10, 15, 12, 0, 0, 0, time.UTC)
+	resigned, changed, err := Resign(signed, signing.FromKey(newKey), now)
+	if err != nil || !changed {
+		t.Fatalf("Resign = %v, %v", changed, err)
+	}
+	if err := Verify(resigned, &newKey.PublicKey); err != nil {
+		t.Errorf("expected the receipt to verify against the new key, got %v", err)
+	}
+	if len(resigned.PreviousSignatures) != 1 || resigned.PreviousSignatures[0].Signature != signed.Signature || !resigned.PreviousSignatures[0].ReplacedAt.Equal(now) {
+		t.Fatalf("expected the original signature kept, got %+v", resigned.PreviousSignatures)
+	}
+	prev := resigned.PreviousSignatures[0]
+	original := &SignedReceipt{Receipt: resigned.Receipt, Signature: prev.Signature, ServerPublicKey: prev.ServerPublicKey}
+	if err := Verify(original, &oldKey.PublicKey); err != nil {
+		t.Errorf("expected the previous signature to still verify, got %v", err)
+	}
+
+	if again, changed, err := Resign(resigned, signing.FromKey(newKey), now); err != nil || changed || again != resigned {
+		t.Errorf("expected a receipt signed with the key to be left alone, got %v, %v", changed, err)
+	}
+
+	tampered := *signed
+	tampered.Receipt.Payment.Amount = "100"
+	if _, _, err := Resign(&tampered, signing.FromKey(newKey), now); err == nil {
+		t.Error("expected a receipt whose signature does not verify to be refused")
+	}
+}
+
+func TestWithSequence_IsSigned(t *testing.T) {
+	key, err := crypto.GenerateKey()
+	if err != nil {
+		t.Fatalf("GenerateKey failed: %v", err)
+	}
+
+	signed, err := Generate(signing.FromKey(key), payments.Context{Token: "USDC", Amount: "0.001", Nonce: "seq-nonce"}, "0xpayer", "/api/ai/summarize", nil, nil, WithSequence(42))
+	if err != nil {
+		t.Fatalf("Generate failed: %v", err)
+	}
+	if signed.Receipt.Payment.Sequence != 42 {
+		t.Fatalf("expected sequence 42, got %d", signed.Receipt.Payment.Sequence)
+	}
+
+	tampered := *signed
+	tampered.Receipt.Payment.Sequence = 41
+	if err := Verify(&tampered, nil); err == nil {
+		t.Error("expected altered sequence to fail verification")
+	}
+}
+
+func TestWithDimensions_IsSigned(t *testing.T) {
+	key, err := crypto.GenerateKey()
+	if err != nil {
+		t.Fatalf("GenerateKey failed: %v", err)
+	}
+
+	signed, err := Generate(signing.FromKey(key), payments.Context{Token: "USDC", Amount: "0.001", Nonce: "dim-nonce"}, "0xpayer", "/api/ai/embed", nil, nil,
+		WithModel("openai/text-embedding-3-small", ""), WithDimensions(1536))
+	if err != nil {
+		t.Fatalf("Generate failed: %v", err)
+	}
+	if signed.Receipt.Service.Dimensions != 1536 {
+		t.Fatalf("expected 1536 dimensions, got %d", signed.Receipt.Service.Dimensions)
+	}
+
+	tampered := *signed
+	tampered.Receipt.Service.Dimensions = 768
+	if err := Verify(&tampered, nil); err == nil {
+		t.Error("expected altered dimensions to fail verification")
+	}
+}
+
+func TestWithSource_IsSigned(t *testing.T) {
+	key, err := crypto.GenerateKey()
+	if err != nil {
+		t.Fatalf("GenerateKey failed: %v", err)
+	}
+
+	signed, err := Generate(signing.FromKey(key), payments.Context{Token: "USDC", Amount: "0.001", Nonce: "source-nonce"}, "0xpayer", "/api/ai/summarize", nil, nil,
+		WithSource("pdf", "https://example.com/paper.pdf"))
+	if err != nil {
+		t.Fatalf("Generate failed: %v", err)
+	}
+	if signed.Receipt.Service.Source != "pdf" || signed.Receipt.Service.SourceURL != "https://example.com/paper.pdf" {
+		t.Fatalf("expected the source to be recorded, got %+v", signed.Receipt.Service)
+	}
+
+	tampered := *signed
+	tampered.Receipt.Service.Source = "html"
+	if err := Verify(&tampered, nil); err == nil {
+		t.Error("expected altered source to fail verification")
+	}
+}
+
+func TestWithParameters_IsSigned(t *testing.T) {
+	key, err := crypto.GenerateKey()
+	if err != nil {
+		t.Fatalf("GenerateKey failed: %v", err)
+	}
+
+	temperature, maxTokens := 0.2, 256
+	signed, err := Generate(signing.FromKey(key), payments.Context{Token: "USDC", Amount: "0.001", Nonce: "param-nonce"}, "0xpayer", "/api/ai/summarize", nil, nil,
+		WithParameters(GenerationParams{Temperature: &temperature, MaxTokens: &maxTokens}))
+	if err != nil {
+		t.Fatalf("Generate failed: %v", err)
+	}
+	params := signed.Receipt.Service.Parameters
+	if params == nil || *params.Temperature != 0.2 || *params.MaxTokens != 256 || params.TopP != nil {
+		t.Fatalf("expected temperature and max_tokens to be recorded, got %+v", params)
+	}
+
+	tampered := *signed
+	hotter := 1.5
+	tampered.Receipt.Service.Parameters = &GenerationParams{Temperature: &hotter, MaxTokens: &maxTokens}
+	if err := Verify(&tampered, nil); err == nil {
+		t.Error("expected altered parameters to fail verification")
+	}
+
+	plain, err := Generate(signing.FromKey(key), payments.Context{Token: "USDC", Amount: "0.001", Nonce: "plain-nonce"}, "0xpayer", "/api/ai/summarize", nil, nil, WithParameters(GenerationParams{}))
+	if err != nil {
+		t.Fatalf("Generate failed: %v", err)
+	}
+	if plain.Receipt.Service.Parameters != nil {
+		t.Errorf("expected no parameters without any set, got %+v", plain.Receipt.Service.Parameters)
+	}
+
+	localized, err := Generate(signing.FromKey(key), payments.Context{Token: "USDC", Amount: "0.001", Nonce: "lang-nonce"}, "0xpayer", "/api/ai/summarize", nil, nil, WithParameters(GenerationParams{OutputLanguage: "es"}))
+	if err != nil {
+		t.Fatalf("Generate failed: %v", err)
+	}
+	if p := localized.Receipt.Service.Parameters; p == nil || p.OutputLanguage != "es" {
+		t.Errorf("expected the output language to be recorded, got %+v", p)
+	}
+}
+
+func TestWithSponsor_IsSigned(t *testing.T) {
+	key, err := crypto.GenerateKey()
+	if err != nil {
+		t.Fatalf("GenerateKey failed: %v", err)
+	}
+
+	signed, err := Generate(signing.FromKey(key), payments.Context{Token: "USDC", Amount: "0.001", Nonce: "sponsor-nonce"}, "0xpayer", "/api/ai/summarize", nil, nil,
+		WithSponsor("0xsponsor", "grant-1"))
+	if err != nil {
+		t.Fatalf("Generate failed: %v", err)
+	}
+	if p := signed.Receipt.Payment; p.Payer != "0xpayer" || p.Sponsor != "0xsponsor" || p.SponsorGrant != "grant-1" {
+		t.Fatalf("expected payer and sponsor in the receipt, got %+v", p)
+	}
+
+	tampered := *signed
+	tampered.Receipt.Payment.Sponsor = "0xother"
+	if err := Verify(&tampered, nil); err == nil {
+		t.Error("expected an altered sponsor to fail verification")
+	}
+}
+
+func TestWithID_IsSigned(t *testing.T) {
+	key, err := crypto.GenerateKey()
+	if err != nil {
+		t.Fatalf("GenerateKey failed: %v", err)
+	}
+	id, _ := NewID()
+	signed, err := Generate(signing.FromKey(key), payments.Context{Token: "USDC", Amount: "0.001", Nonce: "id-nonce"}, "0xpayer", "/api/ai/summarize", nil, nil, WithID(id))
+	if err != nil {
+		t.Fatalf("Generate failed: %v", err)
+	}
+	if signed.Receipt.ID != id {
+		t.Fatalf("expected ID %s, got %s", id, signed.Receipt.ID)
+	}
+	tampered := *signed
+	tampered.Receipt.ID = "rcpt_000000000000"
+	if err := Verify(&tampered, nil); err == nil {
+		t.Error("expected an altered ID to fail verification")
+	}
+}
+
+func TestEncodeResponse(t *testing.T) {
+	body := struct {
+		Result string            `json:"result"`
+		Extra  map[string]string `json:"extra"`
+	}{Result: "<b>R&D</b>\u2028", Extra: map[string]string{"z": "1", "a": "2"}}
+	got, err := EncodeResponse(body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `{"result":"<b>R&D</b>\u2028","extra":{"a":"2","z":"1"}}`
+	if string(got) != want {
+		t.Errorf("expected %s, got %s", want, got)
+	}
+}
@@ -0,0 +1,45 @@
+package receipts
+
+import (
+	"fmt"
+
+	"gateway/payments"
+	"gateway/signing"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+// ResponseSignatureHeader carries the server's signature over a paid
+// response body, see SignResponse.
+const ResponseSignatureHeader = "X-402-Response-Signature"
+
+// ResponseMessage is the text the server personal_signs for a response: the
+// SHA-256 of the body exactly as sent and the request's correlation ID, so
+// a body altered in transit, or moved to another request, fails to verify.
+func ResponseMessage(body []byte, correlationID string) string {
+	return fmt.Sprintf("%s response\nBody-SHA256: %s\nCorrelation-ID: %s", payments.DomainName, HashData(body), correlationID)
+}
+
+// SignResponse returns the ResponseSignatureHeader value for body: the
+// personal_sign signature of ResponseMessage.
+func SignResponse(body []byte, correlationID string, signer signing.Signer) (string, error) {
+	sig, err := payments.SignHash(payments.MessageHash(ResponseMessage(body, correlationID)), signer)
+	if err != nil {
+		return "", fmt.Errorf("sign response: %w", err)
+	}
+	return sig, nil
+}
+
+// VerifyResponse checks that signature was made by server over body and
+// correlationID. server is the published key id from
+// /.well-known/paygate-keys.
+func VerifyResponse(body []byte, correlationID, signature string, server common.Address) error {
+	signer, err := payments.RecoverMessageSigner(ResponseMessage(body, correlationID), signature)
+	if err != nil {
+		return fmt.Errorf("invalid response signature: %w", err)
+	}
+	if signer != server {
+		return fmt.Errorf("response was signed by %s, not %s", signer.Hex(), server.Hex())
+	}
+	return nil
+}
@@ -0,0 +1,36 @@
+package receipts
+
+import (
+	"testing"
+
+	"gateway/signing"
+
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+func TestSignAndVerifyResponse(t *testing.T) {
+	key, _ := crypto.GenerateKey()
+	server := crypto.PubkeyToAddress(key.PublicKey)
+	body := []byte(`{"result":"A summary."}`)
+
+	sig, err := SignResponse(body, "corr-1", signing.FromKey(key))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := VerifyResponse(body, "corr-1", sig, server); err != nil {
+		t.Errorf("expected a valid signature, got %v", err)
+	}
+
+	other, _ := crypto.GenerateKey()
+	cases := map[string]error{
+		"tampered body":        VerifyResponse([]byte(`{"result":"Another."}`), "corr-1", sig, server),
+		"other correlation ID": VerifyResponse(body, "corr-2", sig, server),
+		"other server":         VerifyResponse(body, "corr-1", sig, crypto.PubkeyToAddress(other.PublicKey)),
+		"malformed signature":  VerifyResponse(body, "corr-1", "0x1234", server),
+	}
+	for name, err := range cases {
+		if err == nil {
+			t.Errorf("%s: expected verification to fail", name)
+		}
+	}
+}
@@ -0,0 +1,121 @@
+package receipts
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+// Receipt schema versions. A receipt is signed over the JSON encoding of
+// its own version's schema, so receipts issued before a field was added
+// still verify after the Receipt struct has grown.
+const (
+	// Version1 is the original schema.
+	Version1 = "1.0"
+	// Version2 adds the signing key ID (kid) and the provider's token usage.
+	Version2 = "2.0"
+)
+
+// Version is the receipt format version stamped on every new receipt.
+const Version = Version2
+
+// SupportedVersions lists the schema versions this package reads and
+// verifies, oldest first.
+var SupportedVersions = []string{Version1, Version2}
+
+// IsSupported reports whether v is a known schema version.
+func IsSupported(v string) bool {
+	return slices.Contains(SupportedVersions, v)
+}
+
+// forVersion returns receipt in the shape of its schema version: fields its
+// version does not have are cleared. Those fields are all omitted when
+// empty, so the JSON encoding equals the one the receipt was signed over.
+func forVersion(receipt Receipt) (Receipt, error) {
+	switch receipt.Version {
+	case Version1:
+		receipt.KeyID = ""
+		receipt.Service.Tokens = nil
+	case Version2:
+	default:
+		return receipt, fmt.Errorf("unsupported receipt version %q", receipt.Version)
+	}
+	return receipt, nil
+}
+
+// As returns receipt with only the fields of schema version, for clients
+// that read an older schema. Its version field still names the schema it
+// was issued under, which its signature covers.
+func As(receipt Receipt, version string) (Receipt, error) {
+	issued := receipt.Version
+	receipt.Version = version
+	shaped, err := forVersion(receipt)
+	shaped.Version = issued
+	return shaped, err
+}
+
+// signingBytes is the encoding receipt is signed over, per its version.
+func signingBytes(receipt Receipt) ([]byte, error) {
+	receipt, err := forVersion(receipt)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(receipt)
+}
+
+// Migrate returns signed read up to the current schema, for receipts loaded
+// from storage or sent by clients: fields added since its version are filled
+// in where they can be derived (the kid of a 1.0 receipt, from its server
+// key). The version and signature are kept, so the result still verifies
+// against the encoding it was signed over. Unknown versions are an error.
+func Migrate(signed *SignedReceipt) (*SignedReceipt, error) {
+	if signed == nil {
+		return nil, fmt.Errorf("receipt is nil")
+	}
+	if !IsSupported(signed.Receipt.Version) {
+		return nil, fmt.Errorf("unsupported receipt version %q", signed.Receipt.Version)
+	}
+	migrated := *signed
+	if migrated.Receipt.KeyID == "" {
+		migrated.Receipt.KeyID = addressOf(decodeHex(signed.ServerPublicKey))
+	}
+	return &migrated, nil
+}
+
+// issuedBy reports whether the kid of signed names pubBytes, the key of
+// its signature, or a key it was signed with before being re-signed.
+func issuedBy(signed *SignedReceipt, pubBytes []byte) bool {
+	kid := signed.Receipt.KeyID
+	if strings.EqualFold(kid, addressOf(pubBytes)) {
+		return true
+	}
+	for _, prev := range signed.PreviousSignatures {
+		if strings.EqualFold(kid, addressOf(decodeHex(prev.ServerPublicKey))) {
+			return true
+		}
+	}
+	return false
+}
+
+// addressOf is the kid of an uncompressed public key, or "" if it does not
+// parse.
+func addressOf(pubBytes []byte) string {
+	pub, err := crypto.UnmarshalPubkey(pubBytes)
+	if err != nil {
+		return ""
+	}
+	return keyID(pub)
+}
+
+// decodeHex decodes a 0x-prefixed hex string, or returns nil.
+func decodeHex(s string) []byte {
+	b, err := hex.DecodeString(strings.TrimPrefix(s, "0x"))
+	if err != nil {
+		return nil
+	}
+	return b
+}
@@ -0,0 +1,132 @@
+package receipts
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"gateway/payments"
+	"gateway/signing"
+
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+// legacyV1Receipt is a receipt as the 1.0 gateway issued it: signed over the
+// JSON of the 1.0 structs, which had no kid or tokens.
+func legacyV1Receipt(t *testing.T) *SignedReceipt {
+	t.Helper()
+	key, _ := crypto.GenerateKey()
+	type payment struct {
+		Payer     string `json:"payer"`
+		Recipient string `json:"recipient"`
+		Amount    string `json:"amount"`
+		Token     string `json:"token"`
+		ChainID   int    `json:"chainId"`
+		Nonce     string `json:"nonce"`
+	}
+	type service struct {
+		Endpoint     string `json:"endpoint"`
+		RequestHash  string `json:"request_hash"`
+		ResponseHash string `json:"response_hash"`
+		Model        string `json:"model,omitempty"`
+	}
+	v1 := struct {
+		ID        string    `json:"id"`
+		Version   string    `json:"version"`
+		Timestamp time.Time `json:"timestamp"`
+		Payment   payment   `json:"payment"`
+		Service   service   `json:"service"`
+	}{
+		ID: "rcpt_0123456789ab", Version: Version1, Timestamp: time.Date(2025, 3, 1, 12, 0, 0, 0, time.UTC),
+		Payment: payment{Payer: "0x742d35Cc6634C0532925a3b844Bc9e7595f8fE21", Recipient: "0x2cAF48b4BA1C58721a85dFADa5aC01C2DFa62219",
+			Amount: "0.001", Token: "USDC", ChainID: 8453, Nonce: "legacy-nonce"},
+		Service: service{Endpoint: "/api/ai/summarize", RequestHash: HashData([]byte("req")), ResponseHash: HashData([]byte("resp")), Model: "z-ai/glm-4.5-air:free"},
+	}
+	body, _ := json.Marshal(v1)
+	sig, err := crypto.Sign(crypto.Keccak256Hash(body).Bytes(), key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, _ := json.Marshal(map[string]interface{}{
+		"receipt":           json.RawMessage(body),
+		"signature":         "0x" + hex.EncodeToString(sig),
+		"server_public_key": "0x" + hex.EncodeToString(crypto.FromECDSAPub(&key.PublicKey)),
+	})
+	var signed SignedReceipt
+	if err := json.Unmarshal(data, &signed); err != nil {
+		t.Fatal(err)
+	}
+	return &signed
+}
+
+func TestMigrate_V1ReceiptStillVerifies(t *testing.T) {
+	legacy := legacyV1Receipt(t)
+	if err := Verify(legacy, nil); err != nil {
+		t.Fatalf("expected the 1.0 receipt to verify, got %v", err)
+	}
+	if err := Validate(legacy); err != nil {
+		t.Fatalf("expected the 1.0 receipt to be valid without a kid, got %v", err)
+	}
+
+	migrated, err := Migrate(legacy)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pub, _ := crypto.UnmarshalPubkey(decodeHex(legacy.ServerPublicKey))
+	if migrated.Receipt.Version != Version1 || migrated.Receipt.KeyID != keyID(pub) || legacy.Receipt.KeyID != "" {
+		t.Errorf("expected a copy with the kid derived from the key, got %+v", migrated.Receipt)
+	}
+	if err := Verify(migrated, nil); err != nil {
+		t.Errorf("expected the migrated receipt to verify, got %v", err)
+	}
+	// Fields 1.0 did not have are not covered by its signature.
+	migrated.Receipt.Service.Tokens = &TokenUsage{Input: 1}
+	if err := Verify(migrated, nil); err != nil {
+		t.Errorf("expected fields outside the 1.0 schema to be ignored, got %v", err)
+	}
+}
+
+func TestSchema_CurrentVersionAndUnknownVersions(t *testing.T) {
+	key, _ := crypto.GenerateKey()
+	signer := signing.FromKey(key)
+	payment := payments.Context{Token: "USDC", Amount: "0.001", Nonce: "schema-nonce"}
+	signed, err := Generate(signer, payment, "0xpayer", "/api/ai/summarize", []byte("req"), []byte("resp"), WithTokens(12, 34))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if signed.Receipt.Version != Version || signed.Receipt.KeyID != keyID(&key.PublicKey) {
+		t.Fatalf("expected a %s receipt with the signer's kid, got %+v", Version, signed.Receipt)
+	}
+	tampered := *signed
+	tampered.Receipt.Service.Tokens = &TokenUsage{Input: 1, Output: 34}
+	if err := Verify(&tampered, nil); err == nil {
+		t.Error("expected the 2.0 signature to cover token usage")
+	}
+
+	old, err := As(signed.Receipt, Version1)
+	if err != nil || old.Version != Version || old.KeyID != "" || old.Service.Tokens != nil {
+		t.Errorf("expected the 1.0 view to drop kid and tokens but keep its version, got %+v, %v", old, err)
+	}
+
+	other, _ := crypto.GenerateKey()
+	r := signed.Receipt
+	r.KeyID = keyID(&other.PublicKey)
+	foreign, _ := Sign(r, signer)
+	if err := Verify(foreign, nil); err == nil {
+		t.Error("expected a kid naming another key to be refused")
+	}
+
+	r.Version = "9.9"
+	if _, err := Sign(r, signer); err == nil {
+		t.Error("expected signing an unknown version to fail")
+	}
+	unknown := *signed
+	unknown.Receipt.Version = "9.9"
+	if _, err := Migrate(&unknown); err == nil {
+		t.Error("expected migrating an unknown version to fail")
+	}
+	if err := Validate(&unknown); err == nil {
+		t.Error("expected an unknown version to be invalid")
+	}
+}
@@ -0,0 +1,140 @@
+package receipts
+
+import (
+	"crypto/ecdsa"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"gateway/signing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+// SessionReceipt aggregates the receipts issued to one payer within a
+// session over one period. Consecutive aggregates of a session are chained
+// through Previous.
+type SessionReceipt struct {
+	ID          string    `json:"id"`
+	Version     string    `json:"version"`
+	SessionID   string    `json:"session_id"`
+	Sequence    int64     `json:"sequence"`
+	Previous    string    `json:"previous,omitempty"`
+	Timestamp   time.Time `json:"timestamp"`
+	PeriodStart time.Time `json:"period_start"`
+	PeriodEnd   time.Time `json:"period_end"`
+	Payer       string    `json:"payer"`
+	Recipient   string    `json:"recipient"`
+	Token       string    `json:"token"`
+	ChainID     int       `json:"chainId"`
+	// Count is the number of calls aggregated and TotalAmount the sum of
+	// their payment amounts.
+	Count       int    `json:"count"`
+	TotalAmount string `json:"total_amount"`
+	// MerkleRoot is the MerkleRoot of the Hash of every receipt listed in
+	// ReceiptIDs, in that order.
+	MerkleRoot string   `json:"merkle_root"`
+	ReceiptIDs []string `json:"receipt_ids"`
+}
+
+// SignedSessionReceipt is a SessionReceipt with the server's signature.
+type SignedSessionReceipt struct {
+	Receipt         SessionReceipt `json:"receipt"`
+	Signature       string         `json:"signature"`
+	ServerPublicKey string         `json:"server_public_key"`
+}
+
+// NewSessionReceiptID generates a unique session receipt ID with the
+// "srcpt_" prefix.
+func NewSessionReceiptID() (string, error) {
+	bytes := make([]byte, 6)
+	if _, err := rand.Read(bytes); err != nil {
+		return "", fmt.Errorf("failed to generate random session receipt ID: %w", err)
+	}
+	return "srcpt_" + hex.EncodeToString(bytes), nil
+}
+
+// Hash returns the Keccak256 digest of receipt, the value its signature
+// covers and the leaf it contributes to a session's Merkle tree.
+func Hash(receipt Receipt) (common.Hash, error) {
+	data, err := json.Marshal(receipt)
+	if err != nil {
+		return common.Hash{}, fmt.Errorf("failed to marshal receipt: %w", err)
+	}
+	return crypto.Keccak256Hash(data), nil
+}
+
+// MerkleRoot computes the root of a binary Keccak256 Merkle tree over leaves
+// in order. Each parent is keccak256(left || right); an odd node at the end
+// of a level is carried up unchanged. The root of no leaves is the zero hash.
+func MerkleRoot(leaves []common.Hash) common.Hash {
+	if len(leaves) == 0 {
+		return common.Hash{}
+	}
+	level := append([]common.Hash(nil), leaves...)
+	for len(level) > 1 {
+		next := level[:0:0]
+		for i := 0; i < len(level); i += 2 {
+			if i+1 == len(level) {
+				next = append(next, level[i])
+				continue
+			}
+			next = append(next, crypto.Keccak256Hash(level[i].Bytes(), level[i+1].Bytes()))
+		}
+		level = next
+	}
+	return level[0]
+}
+
+// SignSession signs a session receipt the same way Sign signs a receipt.
+func SignSession(receipt SessionReceipt, signer signing.Signer) (*SignedSessionReceipt, error) {
+	if signer == nil {
+		return nil, fmt.Errorf("signer is nil")
+	}
+	data, err := json.Marshal(receipt)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal session receipt: %w", err)
+	}
+	signature, err := signer.SignHash(crypto.Keccak256Hash(data).Bytes())
+	if err != nil {
+		return nil, fmt.Errorf("failed to sign session receipt: %w", err)
+	}
+	return &SignedSessionReceipt{
+		Receipt:         receipt,
+		Signature:       "0x" + hex.EncodeToString(signature),
+		ServerPublicKey: "0x" + hex.EncodeToString(crypto.FromECDSAPub(signer.Public())),
+	}, nil
+}
+
+// VerifySession checks the signature of a session receipt like Verify.
+func VerifySession(signed *SignedSessionReceipt, trusted *ecdsa.PublicKey) error {
+	if signed == nil {
+		return fmt.Errorf("session receipt is nil")
+	}
+	pubBytes, err := hex.DecodeString(strings.TrimPrefix(signed.ServerPublicKey, "0x"))
+	if err != nil {
+		return fmt.Errorf("invalid server public key: %w", err)
+	}
+	if trusted != nil && hex.EncodeToString(crypto.FromECDSAPub(trusted)) != hex.EncodeToString(pubBytes) {
+		return fmt.Errorf("session receipt was not signed by the trusted key")
+	}
+	sigBytes, err := hex.DecodeString(strings.TrimPrefix(signed.Signature, "0x"))
+	if err != nil {
+		return fmt.Errorf("invalid signature encoding: %w", err)
+	}
+	if len(sigBytes) != crypto.SignatureLength {
+		return fmt.Errorf("invalid signature length: got %d bytes, want %d", len(sigBytes), crypto.SignatureLength)
+	}
+	data, err := json.Marshal(signed.Receipt)
+	if err != nil {
+		return fmt.Errorf("failed to marshal session receipt: %w", err)
+	}
+	if !crypto.VerifySignature(pubBytes, crypto.Keccak256Hash(data).Bytes(), sigBytes[:64]) {
+		return fmt.Errorf("signature does not match session receipt")
+	}
+	return nil
+}
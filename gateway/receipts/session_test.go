@@ -0,0 +1,67 @@
+package receipts
+
+import (
+	"testing"
+	"time"
+
+	"gateway/signing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+func TestMerkleRoot(t *testing.T) {
+	leaf := func(s string) common.Hash { return crypto.Keccak256Hash([]byte(s)) }
+	pair := func(a, b common.Hash) common.Hash { return crypto.Keccak256Hash(a.Bytes(), b.Bytes()) }
+	a, b, c := leaf("a"), leaf("b"), leaf("c")
+
+	if MerkleRoot(nil) != (common.Hash{}) {
+		t.Error("expected the zero hash for no leaves")
+	}
+	if MerkleRoot([]common.Hash{a}) != a {
+		t.Error("a single leaf is its own root")
+	}
+	if MerkleRoot([]common.Hash{a, b}) != pair(a, b) {
+		t.Error("unexpected root for two leaves")
+	}
+	// The odd leaf is carried up unchanged.
+	if MerkleRoot([]common.Hash{a, b, c}) != pair(pair(a, b), c) {
+		t.Error("unexpected root for three leaves")
+	}
+	if MerkleRoot([]common.Hash{b, a}) == MerkleRoot([]common.Hash{a, b}) {
+		t.Error("the root should depend on leaf order")
+	}
+}
+
+func TestSignSession(t *testing.T) {
+	key, _ := crypto.GenerateKey()
+	id, err := NewSessionReceiptID()
+	if err != nil {
+		t.Fatal(err)
+	}
+	signed, err := SignSession(SessionReceipt{
+		ID:          id,
+		Version:     Version,
+		SessionID:   "sess-1",
+		Sequence:    1,
+		Timestamp:   time.Now().UTC(),
+		Count:       2,
+		TotalAmount: "0.002",
+		MerkleRoot:  common.Hash{1}.Hex(),
+	}, signing.FromKey(key))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := VerifySession(signed, &key.PublicKey); err != nil {
+		t.Fatalf("expected a valid session receipt, got %v", err)
+	}
+
+	other, _ := crypto.GenerateKey()
+	if err := VerifySession(signed, &other.PublicKey); err == nil {
+		t.Error("expected a key mismatch")
+	}
+	signed.Receipt.Count = 3
+	if err := VerifySession(signed, nil); err == nil {
+		t.Error("expected tampering to be detected")
+	}
+}
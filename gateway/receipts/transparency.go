@@ -0,0 +1,173 @@
+package receipts
+
+import (
+	"crypto/ecdsa"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"gateway/signing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+// ProofStep is one sibling on the path from a leaf to the root of a
+// MerkleRoot tree. Left is set when the sibling is the left operand.
+type ProofStep struct {
+	Hash string `json:"hash"`
+	Left bool   `json:"left"`
+}
+
+// MerkleProof returns the path proving that leaves[index] is in the
+// MerkleRoot of leaves. Levels where the node is carried up unchanged add
+// no step.
+func MerkleProof(leaves []common.Hash, index int) ([]ProofStep, error) {
+	if index < 0 || index >= len(leaves) {
+		return nil, fmt.Errorf("leaf index %d out of range for %d leaves", index, len(leaves))
+	}
+	var proof []ProofStep
+	level := append([]common.Hash(nil), leaves...)
+	for len(level) > 1 {
+		switch {
+		case index%2 == 1:
+			proof = append(proof, ProofStep{Hash: level[index-1].Hex(), Left: true})
+		case index+1 < len(level):
+			proof = append(proof, ProofStep{Hash: level[index+1].Hex()})
+		}
+		next := level[:0:0]
+		for i := 0; i < len(level); i += 2 {
+			if i+1 == len(level) {
+				next = append(next, level[i])
+				continue
+			}
+			next = append(next, crypto.Keccak256Hash(level[i].Bytes(), level[i+1].Bytes()))
+		}
+		level = next
+		index /= 2
+	}
+	return proof, nil
+}
+
+// VerifyMerkleProof reports whether proof leads from leaf to root.
+func VerifyMerkleProof(leaf common.Hash, proof []ProofStep, root common.Hash) bool {
+	node := leaf
+	for _, step := range proof {
+		sibling := common.HexToHash(step.Hash)
+		if step.Left {
+			node = crypto.Keccak256Hash(sibling.Bytes(), node.Bytes())
+		} else {
+			node = crypto.Keccak256Hash(node.Bytes(), sibling.Bytes())
+		}
+	}
+	return node == root
+}
+
+// TransparencyRoot commits to the receipts issued by a gateway over one
+// period: MerkleRoot is the MerkleRoot of their Hash, in issue order.
+// Sequence numbers increase with each root a gateway publishes.
+type TransparencyRoot struct {
+	Sequence    int64     `json:"sequence"`
+	Version     string    `json:"version"`
+	MerkleRoot  string    `json:"merkle_root"`
+	Count       int       `json:"count"`
+	PeriodStart time.Time `json:"period_start"`
+	PeriodEnd   time.Time `json:"period_end"`
+	Timestamp   time.Time `json:"timestamp"`
+}
+
+// SignedTransparencyRoot is a TransparencyRoot with the server's signature.
+// AnchorTx is the hash of the transaction that published MerkleRoot on
+// chain, if anchored; it is set after signing and not covered by it.
+type SignedTransparencyRoot struct {
+	Root            TransparencyRoot `json:"root"`
+	Signature       string           `json:"signature"`
+	ServerPublicKey string           `json:"server_public_key"`
+	AnchorChainID   int              `json:"anchor_chain_id,omitempty"`
+	AnchorTx        string           `json:"anchor_tx,omitempty"`
+}
+
+// SignRoot signs a transparency root the same way Sign signs a receipt.
+func SignRoot(root TransparencyRoot, signer signing.Signer) (*SignedTransparencyRoot, error) {
+	if signer == nil {
+		return nil, fmt.Errorf("signer is nil")
+	}
+	data, err := json.Marshal(root)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal transparency root: %w", err)
+	}
+	signature, err := signer.SignHash(crypto.Keccak256Hash(data).Bytes())
+	if err != nil {
+		return nil, fmt.Errorf("failed to sign transparency root: %w", err)
+	}
+	return &SignedTransparencyRoot{
+		Root:            root,
+		Signature:       "0x" + hex.EncodeToString(signature),
+		ServerPublicKey: "0x" + hex.EncodeToString(crypto.FromECDSAPub(signer.Public())),
+	}, nil
+}
+
+// VerifyRoot checks the signature of a transparency root like Verify.
+func VerifyRoot(signed *SignedTransparencyRoot, trusted *ecdsa.PublicKey) error {
+	if signed == nil {
+		return fmt.Errorf("transparency root is nil")
+	}
+	pubBytes, err := hex.DecodeString(strings.TrimPrefix(signed.ServerPublicKey, "0x"))
+	if err != nil {
+		return fmt.Errorf("invalid server public key: %w", err)
+	}
+	if trusted != nil && hex.EncodeToString(crypto.FromECDSAPub(trusted)) != hex.EncodeToString(pubBytes) {
+		return fmt.Errorf("transparency root was not signed by the trusted key")
+	}
+	sigBytes, err := hex.DecodeString(strings.TrimPrefix(signed.Signature, "0x"))
+	if err != nil {
+		return fmt.Errorf("invalid signature encoding: %w", err)
+	}
+	if len(sigBytes) != crypto.SignatureLength {
+		return fmt.Errorf("invalid signature length: got %d bytes, want %d", len(sigBytes), crypto.SignatureLength)
+	}
+	data, err := json.Marshal(signed.Root)
+	if err != nil {
+		return fmt.Errorf("failed to marshal transparency root: %w", err)
+	}
+	if !crypto.VerifySignature(pubBytes, crypto.Keccak256Hash(data).Bytes(), sigBytes[:64]) {
+		return fmt.Errorf("signature does not match transparency root")
+	}
+	return nil
+}
+
+// InclusionProof proves that a receipt is in a signed transparency root:
+// Hash of the receipt is Leaf, and Proof leads from Leaf to the root's
+// MerkleRoot.
+type InclusionProof struct {
+	ReceiptID string                  `json:"receipt_id"`
+	Leaf      string                  `json:"leaf"`
+	Index     int                     `json:"index"`
+	Proof     []ProofStep             `json:"proof"`
+	Root      *SignedTransparencyRoot `json:"root"`
+}
+
+// VerifyInclusion checks that receipt is proven by p: the root is signed by
+// trusted (any key when nil) and the proof leads from the receipt's Hash to
+// its MerkleRoot. Checking an anchor transaction is left to the caller.
+func VerifyInclusion(receipt Receipt, p *InclusionProof, trusted *ecdsa.PublicKey) error {
+	if p == nil || p.Root == nil {
+		return fmt.Errorf("inclusion proof has no root")
+	}
+	if err := VerifyRoot(p.Root, trusted); err != nil {
+		return err
+	}
+	leaf, err := Hash(receipt)
+	if err != nil {
+		return err
+	}
+	if !strings.EqualFold(leaf.Hex(), p.Leaf) {
+		return fmt.Errorf("receipt hash %s does not match the proven leaf %s", leaf.Hex(), p.Leaf)
+	}
+	if !VerifyMerkleProof(leaf, p.Proof, common.HexToHash(p.Root.Root.MerkleRoot)) {
+		return fmt.Errorf("proof does not lead to the root's merkle_root")
+	}
+	return nil
+}
@@ -0,0 +1,72 @@
+package receipts
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"gateway/signing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+func TestMerkleProof(t *testing.T) {
+	for n := 1; n <= 9; n++ {
+		leaves := make([]common.Hash, n)
+		for i := range leaves {
+			leaves[i] = crypto.Keccak256Hash([]byte(fmt.Sprint(i)))
+		}
+		root := MerkleRoot(leaves)
+		for i := range leaves {
+			proof, err := MerkleProof(leaves, i)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !VerifyMerkleProof(leaves[i], proof, root) {
+				t.Errorf("%d leaves: proof of leaf %d does not verify", n, i)
+			}
+			if n > 1 && VerifyMerkleProof(leaves[(i+1)%n], proof, root) {
+				t.Errorf("%d leaves: proof of leaf %d verifies another leaf", n, i)
+			}
+		}
+	}
+	if _, err := MerkleProof([]common.Hash{{1}}, 1); err == nil {
+		t.Error("expected an out of range index to be rejected")
+	}
+}
+
+func TestVerifyInclusion(t *testing.T) {
+	key, _ := crypto.GenerateKey()
+	var batch []Receipt
+	var leaves []common.Hash
+	for i := 0; i < 3; i++ {
+		r := Receipt{ID: fmt.Sprintf("rcpt_%d", i), Version: Version, Timestamp: time.Now().UTC()}
+		leaf, err := Hash(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		batch, leaves = append(batch, r), append(leaves, leaf)
+	}
+	signed, err := SignRoot(TransparencyRoot{Sequence: 1, Version: Version, MerkleRoot: MerkleRoot(leaves).Hex(), Count: 3}, signing.FromKey(key))
+	if err != nil {
+		t.Fatal(err)
+	}
+	proof, _ := MerkleProof(leaves, 2)
+	p := &InclusionProof{ReceiptID: batch[2].ID, Leaf: leaves[2].Hex(), Index: 2, Proof: proof, Root: signed}
+
+	if err := VerifyInclusion(batch[2], p, &key.PublicKey); err != nil {
+		t.Fatalf("expected the receipt to be proven, got %v", err)
+	}
+	if err := VerifyInclusion(batch[1], p, &key.PublicKey); err == nil {
+		t.Error("expected another receipt not to be proven")
+	}
+	other, _ := crypto.GenerateKey()
+	if err := VerifyInclusion(batch[2], p, &other.PublicKey); err == nil {
+		t.Error("expected a root signed by another key to be refused")
+	}
+	signed.Root.Count = 4
+	if err := VerifyInclusion(batch[2], p, nil); err == nil {
+		t.Error("expected a tampered root to be refused")
+	}
+}
@@ -0,0 +1,351 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/redis/go-redis/v9"
+)
+
+// bansKey is the Redis hash of temporary bans keyed by client key.
+const bansKey = "abuse:bans"
+
+// errorCodeKey is the context key holding the code of the error response
+// written for a request, if any.
+const errorCodeKey = "error_code"
+
+// AbuseConfig controls automatic temporary bans. A client is banned for
+// BanDuration once, within Window, it sends SignatureFailures payments whose
+// signature does not verify, or at least MinRequests requests of which
+// ClientErrorRate or more get a 4xx other than 402.
+type AbuseConfig struct {
+	Enabled           bool
+	Window            time.Duration
+	SignatureFailures int
+	ClientErrorRate   float64
+	MinRequests       int
+	BanDuration       time.Duration
+}
+
+// validate reports thresholds that would ban everyone or no one.
+func (ac AbuseConfig) validate() error {
+	if !ac.Enabled {
+		return nil
+	}
+	if ac.Window <= 0 || ac.BanDuration <= 0 {
+		return fmt.Errorf("ABUSE_WINDOW_SECONDS and ABUSE_BAN_SECONDS must be positive")
+	}
+	if ac.SignatureFailures <= 0 || ac.MinRequests <= 0 {
+		return fmt.Errorf("ABUSE_MAX_SIGNATURE_FAILURES and ABUSE_MIN_REQUESTS must be positive")
+	}
+	if ac.ClientErrorRate <= 0 || ac.ClientErrorRate > 1 {
+		return fmt.Errorf("ABUSE_MAX_4XX_RATE must be in (0, 1]")
+	}
+	return nil
+}
+
+// Ban keeps a client out until ExpiresAt.
+type Ban struct {
+	Key       string    `json:"key"`
+	Reason    string    `json:"reason"`
+	BannedAt  time.Time `json:"banned_at"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+var (
+	bansMu sync.Mutex
+	bans   = make(map[string]Ban)
+
+	bansTotal       atomic.Int64
+	banRejectsTotal atomic.Int64
+)
+
+// abuseKey identifies the client a request is counted against. It is the
+// client IP rather than the rate limit key, since a client can sign every
+// request with a fresh nonce.
+func abuseKey(c *gin.Context) string {
+	return "ip:" + c.ClientIP()
+}
+
+// banClient stores ban. Bans are kept in Redis when it is connected so every
+// replica enforces them; the in-memory fallback is per process.
+func banClient(ctx context.Context, ban Ban) error {
+	if redisClient != nil {
+		data, err := json.Marshal(ban)
+		if err != nil {
+			return err
+		}
+		if err := redisClient.HSet(ctx, bansKey, ban.Key, data).Err(); err != nil {
+			return fmt.Errorf("failed to store ban: %w", err)
+		}
+		return nil
+	}
+
+	bansMu.Lock()
+	defer bansMu.Unlock()
+	bans[ban.Key] = ban
+	return nil
+}
+
+// getBan returns the active ban of key, if any. Expired bans are removed.
+func getBan(ctx context.Context, key string) (*Ban, error) {
+	var ban Ban
+	if redisClient != nil {
+		data, err := redisClient.HGet(ctx, bansKey, key).Result()
+		if errors.Is(err, redis.Nil) {
+			return nil, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to load ban: %w", err)
+		}
+		if err := json.Unmarshal([]byte(data), &ban); err != nil {
+			return nil, fmt.Errorf("invalid ban for %s: %w", key, err)
+		}
+	} else {
+		bansMu.Lock()
+		b, ok := bans[key]
+		bansMu.Unlock()
+		if !ok {
+			return nil, nil
+		}
+		ban = b
+	}
+	if !time.Now().Before(ban.ExpiresAt) {
+		if _, err := liftBan(ctx, key); err != nil {
+			log.Printf("[WARNING] Failed to remove expired ban of %s: %v", key, err)
+		}
+		return nil, nil
+	}
+	return &ban, nil
+}
+
+// liftBan removes the ban of key, reporting whether there was one.
+func liftBan(ctx context.Context, key string) (bool, error) {
+	abuse.reset(key)
+	if redisClient != nil {
+		n, err := redisClient.HDel(ctx, bansKey, key).Result()
+		if err != nil {
+			return false, fmt.Errorf("failed to lift ban: %w", err)
+		}
+		return n > 0, nil
+	}
+
+	bansMu.Lock()
+	defer bansMu.Unlock()
+	_, ok := bans[key]
+	delete(bans, key)
+	return ok, nil
+}
+
+// listBans returns the active bans, oldest first.
+func listBans(ctx context.Context) ([]Ban, error) {
+	var all []Ban
+	if redisClient != nil {
+		stored, err := redisClient.HGetAll(ctx, bansKey).Result()
+		if err != nil {
+			return nil, fmt.Errorf("failed to load bans: %w", err)
+		}
+		for key, data := range stored {
+			var ban Ban
+			if err := json.Unmarshal([]byte(data), &ban); err != nil {
+				log.Printf("[WARNING] Skipping invalid ban for %s: %v", key, err)
+				continue
+			}
+			all = append(all, ban)
+		}
+	} else {
+		bansMu.Lock()
+		for _, ban := range bans {
+			all = append(all, ban)
+		}
+		bansMu.Unlock()
+	}
+
+	now := time.Now()
+	list := all[:0]
+	for _, ban := range all {
+		if now.Before(ban.ExpiresAt) {
+			list = append(list, ban)
+		} else if _, err := liftBan(ctx, ban.Key); err != nil {
+			log.Printf("[WARNING] Failed to remove expired ban of %s: %v", ban.Key, err)
+		}
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if !list[i].BannedAt.Equal(list[j].BannedAt) {
+			return list[i].BannedAt.Before(list[j].BannedAt)
+		}
+		return list[i].Key < list[j].Key
+	})
+	return list, nil
+}
+
+// abuseWindow counts one client's requests in the current window.
+type abuseWindow struct {
+	start             time.Time
+	requests          int
+	clientErrors      int
+	signatureFailures int
+}
+
+// abuseDetector tracks per-client error counts on this instance.
+type abuseDetector struct {
+	mu      sync.Mutex
+	windows map[string]*abuseWindow
+	swept   time.Time
+}
+
+var abuse = &abuseDetector{windows: make(map[string]*abuseWindow)}
+
+// record counts a response with status and error code for key and returns
+// why key should be banned, or "" while it is within the thresholds.
+func (d *abuseDetector) record(cfg AbuseConfig, key string, status int, code ErrorCode) string {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	now := time.Now()
+	if now.Sub(d.swept) > cfg.Window {
+		for k, w := range d.windows {
+			if now.Sub(w.start) > cfg.Window {
+				delete(d.windows, k)
+			}
+		}
+		d.swept = now
+	}
+
+	w, ok := d.windows[key]
+	if !ok || now.Sub(w.start) > cfg.Window {
+		w = &abuseWindow{start: now}
+		d.windows[key] = w
+	}
+	w.requests++
+	if status >= 400 && status < 500 && status != 402 {
+		w.clientErrors++
+	}
+	if isSignatureFailure(code) {
+		w.signatureFailures++
+	}
+
+	var reason string
+	switch {
+	case w.signatureFailures >= cfg.SignatureFailures:
+		reason = fmt.Sprintf("%d signature failures in %s", w.signatureFailures, cfg.Window)
+	case w.requests >= cfg.MinRequests && float64(w.clientErrors)/float64(w.requests) >= cfg.ClientErrorRate:
+		reason = fmt.Sprintf("%d of %d requests in %s got a 4xx", w.clientErrors, w.requests, cfg.Window)
+	default:
+		return ""
+	}
+	delete(d.windows, key)
+	return reason
+}
+
+// reset forgets key's counts.
+func (d *abuseDetector) reset(key string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	delete(d.windows, key)
+}
+
+// tracked returns the number of clients with counts.
+func (d *abuseDetector) tracked() int {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return len(d.windows)
+}
+
+// abuseMiddleware rejects banned clients with 429 and, after each request,
+// bans clients whose signature failures or 4xx rate pass the thresholds.
+// Counts are per instance; bans are shared through Redis. Redis errors
+// fail open.
+func abuseMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		// Admin requests are never banned, so an operator can always lift
+		// a ban.
+		cfg := getConfig().Abuse
+		if !cfg.Enabled || isAdminRequest(c) {
+			c.Next()
+			return
+		}
+
+		key := abuseKey(c)
+		ban, err := getBan(c.Request.Context(), key)
+		if err != nil {
+			log.Printf("[WARNING] Ban lookup failed, allowing %s: %v", key, err)
+		}
+		if ban != nil {
+			banRejectsTotal.Add(1)
+			retryAfter := max(int(time.Until(ban.ExpiresAt).Seconds()), 1)
+			c.Header("Retry-After", strconv.Itoa(retryAfter))
+			abortWithAPIError(c, newAPIError(CodeTemporarilyBanned, "Too many failed requests; this client is temporarily banned").
+				with(gin.H{"retry_after": retryAfter, "banned_until": ban.ExpiresAt}))
+			return
+		}
+
+		c.Next()
+
+		code, _ := c.Get(errorCodeKey)
+		errorCode, _ := code.(ErrorCode)
+		reason := abuse.record(cfg, key, c.Writer.Status(), errorCode)
+		if reason == "" {
+			return
+		}
+		now := time.Now()
+		ban = &Ban{Key: key, Reason: reason, BannedAt: now, ExpiresAt: now.Add(cfg.BanDuration)}
+		ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+		defer cancel()
+		if err := banClient(ctx, *ban); err != nil {
+			log.Printf("[WARNING] Failed to ban %s: %v", key, err)
+			return
+		}
+		bansTotal.Add(1)
+		log.Printf("[WARNING] Banned %s for %s: %s", key, cfg.BanDuration, reason)
+	}
+}
+
+// abuseStatus is the abuse entry of /readyz.
+func abuseStatus(cfg *Config) gin.H {
+	return gin.H{
+		"enabled":         cfg.Abuse.Enabled,
+		"tracked_clients": abuse.tracked(),
+		"bans_total":      bansTotal.Load(),
+		"rejected_total":  banRejectsTotal.Load(),
+	}
+}
+
+// handleListBans handles GET /api/admin/bans.
+func handleListBans(c *gin.Context) {
+	list, err := listBans(c.Request.Context())
+	if err != nil {
+		log.Printf("[ERROR] %v", err)
+		respondError(c, CodeServiceUnavailable, "The ban list is unavailable")
+		return
+	}
+	if list == nil {
+		list = []Ban{}
+	}
+	c.JSON(200, gin.H{"bans": list})
+}
+
+// handleLiftBan handles DELETE /api/admin/bans/:key, e.g. ip:203.0.113.7.
+func handleLiftBan(c *gin.Context) {
+	key := c.Param("key")
+	lifted, err := liftBan(c.Request.Context(), key)
+	if err != nil {
+		log.Printf("[ERROR] %v", err)
+		respondError(c, CodeServiceUnavailable, "The ban list is unavailable")
+		return
+	}
+	if !lifted {
+		respondError(c, CodeNotFound, "No ban for that key")
+		return
+	}
+	log.Printf("Ban of %s lifted by admin", key)
+	c.JSON(200, gin.H{"key": key, "lifted": true})
+}
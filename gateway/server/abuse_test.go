@@ -0,0 +1,160 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"testing"
+	"time"
+
+	"gateway/internal/testsupport"
+)
+
+// withAbuseDetection enables abuse detection with env and clears bans and
+// counts for one test.
+func withAbuseDetection(t *testing.T, env map[string]string) {
+	t.Helper()
+	t.Setenv("ABUSE_DETECTION_ENABLED", "true")
+	for k, v := range env {
+		t.Setenv(k, v)
+	}
+	reset := func() {
+		bansMu.Lock()
+		clear(bans)
+		bansMu.Unlock()
+		abuse.mu.Lock()
+		clear(abuse.windows)
+		abuse.mu.Unlock()
+	}
+	reset()
+	t.Cleanup(reset)
+}
+
+func TestAbuseDetector_Thresholds(t *testing.T) {
+	cfg := AbuseConfig{Enabled: true, Window: time.Minute, SignatureFailures: 2, ClientErrorRate: 0.5, MinRequests: 4, BanDuration: time.Hour}
+	d := &abuseDetector{windows: make(map[string]*abuseWindow)}
+
+	if reason := d.record(cfg, "ip:a", 403, CodeSignatureInvalid); reason != "" {
+		t.Fatalf("expected one signature failure to be tolerated, got %q", reason)
+	}
+	if reason := d.record(cfg, "ip:a", 403, CodeSignatureInvalid); reason == "" {
+		t.Error("expected the second signature failure to ban")
+	}
+
+	// 402 challenges are the normal payment flow, not client errors.
+	for i := 0; i < 5; i++ {
+		if reason := d.record(cfg, "ip:b", 402, CodePaymentRequired); reason != "" {
+			t.Fatalf("expected 402s not to ban, got %q", reason)
+		}
+	}
+
+	d.record(cfg, "ip:c", 200, "")
+	d.record(cfg, "ip:c", 404, CodeNotFound)
+	d.record(cfg, "ip:c", 200, "")
+	if reason := d.record(cfg, "ip:c", 400, CodeInvalidBody); reason == "" {
+		t.Error("expected a 4xx rate at the threshold to ban once MinRequests is reached")
+	}
+	if d.tracked() != 1 {
+		t.Errorf("expected banned clients' counts to be dropped, got %d tracked", d.tracked())
+	}
+}
+
+func TestAbuseConfig_Validate(t *testing.T) {
+	withAbuseDetection(t, map[string]string{"ABUSE_MAX_4XX_RATE": "1.5"})
+	if err := loadConfig().Validate(); err == nil {
+		t.Error("expected a 4xx rate above 1 to be rejected")
+	}
+}
+
+func TestAbuse_SignatureFailuresBanUntilLifted(t *testing.T) {
+	withAbuseDetection(t, map[string]string{"ABUSE_MAX_SIGNATURE_FAILURES": "2", "ABUSE_BAN_SECONDS": "600", "ADMIN_API_KEY": "s3cret"})
+	h := testsupport.NewHarness(t, newTestRouter)
+	h.Verifier.SetInvalid("bad signature")
+
+	for i, nonce := range []string{"nonce-abuse-1", "nonce-abuse-2"} {
+		if resp := h.Post(t, "/api/ai/summarize", `{"text":"hello"}`, "0xbad", nonce); resp.StatusCode != http.StatusForbidden {
+			t.Fatalf("request %d: expected 403, got %d", i+1, resp.StatusCode)
+		}
+	}
+
+	resp := h.Post(t, "/api/ai/summarize", `{"text":"hello"}`, "0xbad", "nonce-abuse-3")
+	if resp.StatusCode != http.StatusTooManyRequests {
+		t.Fatalf("expected the banned client to get 429, got %d", resp.StatusCode)
+	}
+	if retry, _ := strconv.Atoi(resp.Header.Get("Retry-After")); retry < 590 || retry > 600 {
+		t.Errorf("expected a Retry-After of the ban duration, got %q", resp.Header.Get("Retry-After"))
+	}
+	var body struct {
+		Code string `json:"code"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil || body.Code != string(CodeTemporarilyBanned) {
+		t.Errorf("expected %s, got %+v (%v)", CodeTemporarilyBanned, body, err)
+	}
+	if h.Verifier.Calls() != 2 {
+		t.Errorf("expected the banned request not to reach the verifier, got %d calls", h.Verifier.Calls())
+	}
+
+	router := h.Server.Config.Handler
+	w := adminGet(t, router, "/api/admin/bans", "s3cret")
+	var list struct {
+		Bans []Ban `json:"bans"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &list); err != nil || len(list.Bans) != 1 || list.Bans[0].Key != "ip:127.0.0.1" {
+		t.Fatalf("expected the ban to be listed, got %s", w.Body)
+	}
+	if w := adminDelete(t, router, "/api/admin/bans/ip:127.0.0.1", "s3cret"); w.Code != http.StatusOK {
+		t.Fatalf("expected the ban to be lifted, got %d: %s", w.Code, w.Body)
+	}
+	if w := adminDelete(t, router, "/api/admin/bans/ip:127.0.0.1", "s3cret"); w.Code != http.StatusNotFound {
+		t.Errorf("expected lifting again to find nothing, got %d", w.Code)
+	}
+
+	h.Verifier.SetValid("0xpayer")
+	if resp := h.Post(t, "/api/ai/summarize", `{"text":"hello"}`, "0xsig", "nonce-abuse-4"); resp.StatusCode != http.StatusOK {
+		t.Errorf("expected the client to be served once the ban was lifted, got %d", resp.StatusCode)
+	}
+}
+
+func TestAbuse_ClientErrorRateBans(t *testing.T) {
+	withAbuseDetection(t, map[string]string{"ABUSE_MIN_REQUESTS": "3", "ABUSE_MAX_4XX_RATE": "1"})
+	h := testsupport.NewHarness(t, newTestRouter)
+
+	for i := 0; i < 3; i++ {
+		if resp := h.Post(t, "/api/ai/summarize", `not json`, "0xsig", "nonce-4xx-"+strconv.Itoa(i)); resp.StatusCode != http.StatusBadRequest {
+			t.Fatalf("request %d: expected 400, got %d", i+1, resp.StatusCode)
+		}
+	}
+	if resp := h.Get(t, "/healthz"); resp.StatusCode != http.StatusTooManyRequests {
+		t.Errorf("expected the banned client to get 429 everywhere, got %d", resp.StatusCode)
+	}
+}
+
+func TestAbuse_BansSharedThroughRedis(t *testing.T) {
+	withAbuseDetection(t, nil)
+	startGateway(t, nil)
+	ctx := context.Background()
+
+	ban := Ban{Key: "ip:203.0.113.7", Reason: "test", BannedAt: time.Now(), ExpiresAt: time.Now().Add(time.Hour)}
+	if err := banClient(ctx, ban); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := getBan(ctx, ban.Key); err != nil || got == nil || got.Reason != "test" {
+		t.Fatalf("expected the ban from Redis, got %+v, %v", got, err)
+	}
+	bansMu.Lock()
+	local := len(bans)
+	bansMu.Unlock()
+	if local != 0 {
+		t.Errorf("expected no in-memory bans while Redis is connected, got %d", local)
+	}
+
+	expired := Ban{Key: "ip:203.0.113.8", BannedAt: time.Now().Add(-2 * time.Hour), ExpiresAt: time.Now().Add(-time.Hour)}
+	banClient(ctx, expired)
+	if list, err := listBans(ctx); err != nil || len(list) != 1 {
+		t.Errorf("expected only the active ban to be listed, got %+v, %v", list, err)
+	}
+	if got, _ := getBan(ctx, expired.Key); got != nil {
+		t.Error("expected an expired ban to be ignored")
+	}
+}
@@ -0,0 +1,273 @@
+package server
+
+import (
+	"bufio"
+	"fmt"
+	"log"
+	"net/http"
+	"net/netip"
+	"os"
+	"strings"
+	"sync/atomic"
+
+	"github.com/gin-gonic/gin"
+)
+
+// NetworkACL allows or blocks clients by IP. Deny rules win; when Allow is
+// non-empty a client must also match one of its prefixes.
+type NetworkACL struct {
+	Allow []netip.Prefix
+	Deny  []netip.Prefix
+}
+
+// Enabled reports whether any rule is configured.
+func (a NetworkACL) Enabled() bool {
+	return len(a.Allow) > 0 || len(a.Deny) > 0
+}
+
+// Check returns "" if ip may connect, otherwise why it is blocked
+// ("denied" or "not_allowed").
+func (a NetworkACL) Check(ip netip.Addr) string {
+	ip = ip.Unmap()
+	for _, p := range a.Deny {
+		if p.Contains(ip) {
+			return "denied"
+		}
+	}
+	if len(a.Allow) == 0 {
+		return ""
+	}
+	for _, p := range a.Allow {
+		if p.Contains(ip) {
+			return ""
+		}
+	}
+	return "not_allowed"
+}
+
+// parsePrefix parses a CIDR or a bare IP (as a single-address prefix).
+func parsePrefix(s string) (netip.Prefix, error) {
+	if strings.Contains(s, "/") {
+		p, err := netip.ParsePrefix(s)
+		if err != nil {
+			return netip.Prefix{}, fmt.Errorf("invalid CIDR %q", s)
+		}
+		return p.Masked(), nil
+	}
+	ip, err := netip.ParseAddr(s)
+	if err != nil {
+		return netip.Prefix{}, fmt.Errorf("invalid IP %q", s)
+	}
+	ip = ip.Unmap()
+	return netip.PrefixFrom(ip, ip.BitLen()), nil
+}
+
+// parsePrefixes parses a list of CIDRs and bare IPs.
+func parsePrefixes(entries []string) ([]netip.Prefix, error) {
+	prefixes := make([]netip.Prefix, 0, len(entries))
+	for _, e := range entries {
+		p, err := parsePrefix(e)
+		if err != nil {
+			return nil, err
+		}
+		prefixes = append(prefixes, p)
+	}
+	return prefixes, nil
+}
+
+// loadNetworkACL builds the ACL from IP_ALLOW_CIDRS, IP_DENY_CIDRS and the
+// optional IP_ACL_FILE, whose lines are "allow <cidr>" or "deny <cidr>" with
+// # comments.
+func loadNetworkACL() (NetworkACL, error) {
+	var acl NetworkACL
+	var err error
+	if acl.Allow, err = parsePrefixes(getEnvAsList("IP_ALLOW_CIDRS", nil)); err != nil {
+		return NetworkACL{}, fmt.Errorf("IP_ALLOW_CIDRS: %w", err)
+	}
+	if acl.Deny, err = parsePrefixes(getEnvAsList("IP_DENY_CIDRS", nil)); err != nil {
+		return NetworkACL{}, fmt.Errorf("IP_DENY_CIDRS: %w", err)
+	}
+
+	path := os.Getenv("IP_ACL_FILE")
+	if path == "" {
+		return acl, nil
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return NetworkACL{}, fmt.Errorf("IP_ACL_FILE: %w", err)
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for n := 1; scanner.Scan(); n++ {
+		line, _, _ := strings.Cut(scanner.Text(), "#")
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		if len(fields) != 2 {
+			return NetworkACL{}, fmt.Errorf("IP_ACL_FILE line %d: expected \"allow <cidr>\" or \"deny <cidr>\"", n)
+		}
+		p, err := parsePrefix(fields[1])
+		if err != nil {
+			return NetworkACL{}, fmt.Errorf("IP_ACL_FILE line %d: %w", n, err)
+		}
+		switch strings.ToLower(fields[0]) {
+		case "allow":
+			acl.Allow = append(acl.Allow, p)
+		case "deny":
+			acl.Deny = append(acl.Deny, p)
+		default:
+			return NetworkACL{}, fmt.Errorf("IP_ACL_FILE line %d: unknown action %q", n, fields[0])
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return NetworkACL{}, fmt.Errorf("IP_ACL_FILE: %w", err)
+	}
+	return acl, nil
+}
+
+// prefixesContain reports whether any of prefixes contains ip.
+func prefixesContain(prefixes []netip.Prefix, ip netip.Addr) bool {
+	for _, p := range prefixes {
+		if p.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// loadTrustedProxies parses TRUSTED_PROXIES, the proxies whose client IP
+// header is believed when resolving the client IP. Unset or "none" trusts no
+// proxy and uses the connection's address.
+func loadTrustedProxies() ([]netip.Prefix, error) {
+	raw := strings.TrimSpace(os.Getenv("TRUSTED_PROXIES"))
+	if raw == "" || strings.EqualFold(raw, "none") {
+		return nil, nil
+	}
+	prefixes, err := parsePrefixes(getEnvAsList("TRUSTED_PROXIES", nil))
+	if err != nil {
+		return nil, fmt.Errorf("TRUSTED_PROXIES: %w", err)
+	}
+	return prefixes, nil
+}
+
+// clientIPHeaders are the CLIENT_IP_HEADER values and the headers each reads
+// the client IP from, in order.
+var clientIPHeaders = map[string][]string{
+	"x-forwarded-for":  {"X-Forwarded-For", "X-Real-IP"},
+	"x-real-ip":        {"X-Real-IP"},
+	"cf-connecting-ip": {"CF-Connecting-IP"},
+}
+
+// loadClientIPHeader parses CLIENT_IP_HEADER, the header a trusted proxy puts
+// the client IP in. It defaults to X-Forwarded-For (falling back to
+// X-Real-IP), Gin's default.
+func loadClientIPHeader() (string, error) {
+	raw := strings.ToLower(strings.TrimSpace(os.Getenv("CLIENT_IP_HEADER")))
+	if raw == "" {
+		return "x-forwarded-for", nil
+	}
+	if _, ok := clientIPHeaders[raw]; !ok {
+		return "", fmt.Errorf("CLIENT_IP_HEADER: unsupported header %q (use X-Forwarded-For, X-Real-IP or CF-Connecting-IP)", raw)
+	}
+	return raw, nil
+}
+
+// applyTrustedProxies makes r resolve ClientIP from the active config's
+// TRUSTED_PROXIES and CLIENT_IP_HEADER, so both follow config reloads. Gin
+// itself trusts no proxy; clientIPMiddleware replaces the request's remote
+// address with the resolved client IP, which ClientIP then returns.
+func applyTrustedProxies(r *gin.Engine) {
+	if err := r.SetTrustedProxies(nil); err != nil {
+		log.Printf("[WARNING] Failed to reset trusted proxies: %v", err)
+	}
+	r.Use(clientIPMiddleware())
+}
+
+// clientIPResolvedKey marks a request whose remote address already holds the
+// resolved client IP, so HEAD requests re-entering the router keep it.
+const clientIPResolvedKey = "client_ip_resolved"
+
+// clientIPMiddleware sets the request's remote address to the client IP.
+func clientIPMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if _, done := c.Get(clientIPResolvedKey); !done {
+			c.Set(clientIPResolvedKey, true)
+			if ip := resolveClientIP(c.Request, getConfig()); ip.IsValid() {
+				c.Request.RemoteAddr = netip.AddrPortFrom(ip, 0).String()
+			}
+		}
+		c.Next()
+	}
+}
+
+// resolveClientIP returns the client IP for req. The client IP header is
+// only believed when the connection comes from a trusted proxy; within
+// X-Forwarded-For the rightmost untrusted hop wins, so clients cannot hide
+// behind entries they prepend themselves.
+func resolveClientIP(req *http.Request, cfg *Config) netip.Addr {
+	peer, err := netip.ParseAddrPort(req.RemoteAddr)
+	if err != nil {
+		return netip.Addr{}
+	}
+	remote := peer.Addr().Unmap()
+	if !prefixesContain(cfg.TrustedProxies, remote) {
+		return remote
+	}
+	for _, header := range clientIPHeaders[cfg.ClientIPHeader] {
+		hops := strings.Split(req.Header.Get(header), ",")
+		for i := len(hops) - 1; i >= 0; i-- {
+			ip, err := netip.ParseAddr(strings.TrimSpace(hops[i]))
+			if err != nil {
+				break
+			}
+			if ip = ip.Unmap(); i == 0 || !prefixesContain(cfg.TrustedProxies, ip) {
+				return ip
+			}
+		}
+	}
+	return remote
+}
+
+// networkACLStats counts blocked requests for /readyz.
+var networkACLStats struct {
+	denied     atomic.Int64
+	notAllowed atomic.Int64
+}
+
+// networkACLMiddleware rejects clients the active NetworkACL blocks with 403.
+// It runs before rate limiting so blocked clients consume no tokens.
+func networkACLMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		acl := getConfig().NetworkACL
+		if !acl.Enabled() {
+			c.Next()
+			return
+		}
+		reason := "not_allowed"
+		if ip, err := netip.ParseAddr(c.ClientIP()); err == nil {
+			reason = acl.Check(ip)
+		}
+		if reason == "" {
+			c.Next()
+			return
+		}
+		if reason == "denied" {
+			networkACLStats.denied.Add(1)
+		} else {
+			networkACLStats.notAllowed.Add(1)
+		}
+		abortWithError(c, CodeForbidden, "Access from your network is not allowed")
+	}
+}
+
+// networkACLStatus is the network_acl entry of /readyz.
+func networkACLStatus(cfg *Config) gin.H {
+	return gin.H{
+		"enabled":                   cfg.NetworkACL.Enabled(),
+		"allow_rules":               len(cfg.NetworkACL.Allow),
+		"deny_rules":                len(cfg.NetworkACL.Deny),
+		"blocked_denied_total":      networkACLStats.denied.Load(),
+		"blocked_not_allowed_total": networkACLStats.notAllowed.Load(),
+	}
+}
@@ -0,0 +1,238 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestNetworkACL_Check(t *testing.T) {
+	t.Setenv("IP_ALLOW_CIDRS", "10.0.0.0/8, 2001:db8::/32")
+	t.Setenv("IP_DENY_CIDRS", "10.0.0.13")
+	acl, err := loadNetworkACL()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := map[string]string{
+		"10.1.2.3":        "",
+		"::ffff:10.1.2.3": "", // IPv4-mapped addresses match IPv4 rules
+		"2001:db8::1":     "",
+		"10.0.0.13":       "denied",
+		"192.168.1.1":     "not_allowed",
+	}
+	for ip, want := range tests {
+		if got := acl.Check(netip.MustParseAddr(ip)); got != want {
+			t.Errorf("Check(%s) = %q, want %q", ip, got, want)
+		}
+	}
+}
+
+func TestLoadNetworkACL_File(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "acl.txt")
+	content := "# office\nallow 192.168.0.0/16\ndeny 192.168.5.0/24  # guest wifi\n\n"
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("IP_ACL_FILE", path)
+	t.Setenv("IP_DENY_CIDRS", "203.0.113.7")
+	acl, err := loadNetworkACL()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(acl.Allow) != 1 || len(acl.Deny) != 2 {
+		t.Fatalf("expected env and file rules combined, got %+v", acl)
+	}
+
+	if err := os.WriteFile(path, []byte("block 1.2.3.4\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := loadNetworkACL(); err == nil {
+		t.Error("unknown action should be rejected")
+	}
+}
+
+func TestConfigValidate_RejectsInvalidNetworkACL(t *testing.T) {
+	t.Setenv("IP_DENY_CIDRS", "10.0.0.0/33")
+	if err := loadConfig().Validate(); err == nil {
+		t.Error("invalid deny CIDR should fail validation")
+	}
+	t.Setenv("IP_DENY_CIDRS", "")
+	t.Setenv("TRUSTED_PROXIES", "not-an-ip")
+	if err := loadConfig().Validate(); err == nil {
+		t.Error("invalid trusted proxy should fail validation")
+	}
+}
+
+func TestNetworkACLMiddleware(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	t.Setenv("IP_DENY_CIDRS", "198.51.100.0/24")
+	t.Setenv("TRUSTED_PROXIES", "127.0.0.1")
+	t.Setenv("RATE_LIMIT_ENABLED", "false")
+	r := setupRouter()
+
+	request := func(remote, forwarded string) int {
+		req := httptest.NewRequest(http.MethodGet, "/healthz", nil)
+		req.RemoteAddr = remote + ":1234"
+		if forwarded != "" {
+			req.Header.Set("X-Forwarded-For", forwarded)
+		}
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w.Code
+	}
+
+	before := networkACLStats.denied.Load()
+	if code := request("198.51.100.7", ""); code != http.StatusForbidden {
+		t.Errorf("denied client: expected 403, got %d", code)
+	}
+	if code := request("127.0.0.1", "198.51.100.7"); code != http.StatusForbidden {
+		t.Errorf("denied client behind trusted proxy: expected 403, got %d", code)
+	}
+	if code := request("203.0.113.9", "127.0.0.5"); code != http.StatusOK {
+		t.Errorf("X-Forwarded-For from an untrusted peer must be ignored, got %d", code)
+	}
+	if code := request("198.51.100.7", "203.0.113.9"); code != http.StatusForbidden {
+		t.Errorf("denied client cannot spoof X-Forwarded-For, got %d", code)
+	}
+	if got := networkACLStats.denied.Load() - before; got != 3 {
+		t.Errorf("expected 3 blocked requests counted, got %d", got)
+	}
+}
+
+// clientIPRouter returns a router configured from the environment whose
+// /ip route echoes the resolved client IP, and a helper requesting it.
+func clientIPRouter(t *testing.T) func(remote string, headers map[string]string) string {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+	cfg := loadConfig()
+	if err := cfg.Validate(); err != nil {
+		t.Fatalf("invalid config: %v", err)
+	}
+	r := gin.New()
+	applyTrustedProxies(r)
+	r.GET("/ip", func(c *gin.Context) { c.String(http.StatusOK, c.ClientIP()) })
+	return func(remote string, headers map[string]string) string {
+		req := httptest.NewRequest(http.MethodGet, "/ip", nil)
+		req.RemoteAddr = remote + ":1234"
+		for k, v := range headers {
+			req.Header.Set(k, v)
+		}
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w.Body.String()
+	}
+}
+
+func TestClientIP_ForwardedForSpoofing(t *testing.T) {
+	t.Setenv("TRUSTED_PROXIES", "10.0.0.0/8")
+	clientIP := clientIPRouter(t)
+
+	if got := clientIP("203.0.113.9", map[string]string{"X-Forwarded-For": "198.51.100.1"}); got != "203.0.113.9" {
+		t.Errorf("untrusted peer: expected its own address, got %s", got)
+	}
+	if got := clientIP("10.0.0.2", map[string]string{"X-Forwarded-For": "198.51.100.1"}); got != "198.51.100.1" {
+		t.Errorf("trusted proxy: expected the forwarded address, got %s", got)
+	}
+	// A client prepending its own entry cannot hide behind the proxy chain:
+	// the rightmost untrusted hop wins.
+	if got := clientIP("10.0.0.2", map[string]string{"X-Forwarded-For": "1.2.3.4, 198.51.100.1, 10.0.0.3"}); got != "198.51.100.1" {
+		t.Errorf("spoofed chain: expected the rightmost untrusted hop, got %s", got)
+	}
+	if got := clientIP("10.0.0.2", map[string]string{"X-Real-IP": "198.51.100.7"}); got != "198.51.100.7" {
+		t.Errorf("expected X-Real-IP as the fallback header, got %s", got)
+	}
+
+	t.Setenv("TRUSTED_PROXIES", "none")
+	clientIP = clientIPRouter(t)
+	if got := clientIP("10.0.0.2", map[string]string{"X-Forwarded-For": "198.51.100.1"}); got != "10.0.0.2" {
+		t.Errorf("TRUSTED_PROXIES=none: expected the connection address, got %s", got)
+	}
+}
+
+func TestClientIP_UnsetTrustsNoProxy(t *testing.T) {
+	t.Setenv("TRUSTED_PROXIES", "")
+	clientIP := clientIPRouter(t)
+	if got := clientIP("127.0.0.1", map[string]string{"X-Forwarded-For": "198.51.100.1"}); got != "127.0.0.1" {
+		t.Errorf("unset TRUSTED_PROXIES: expected the connection address, got %s", got)
+	}
+}
+
+func TestClientIP_FollowsConfigReload(t *testing.T) {
+	resetConfigSnapshot(t)
+	t.Setenv("TRUSTED_PROXIES", "none")
+	currentConfig.Store(loadConfig())
+	clientIP := clientIPRouter(t)
+	forwarded := map[string]string{"X-Forwarded-For": "198.51.100.1"}
+	if got := clientIP("10.0.0.2", forwarded); got != "10.0.0.2" {
+		t.Fatalf("before reload: expected the connection address, got %s", got)
+	}
+
+	t.Setenv("TRUSTED_PROXIES", "10.0.0.0/8")
+	if err := reloadConfig(""); err != nil {
+		t.Fatal(err)
+	}
+	if got := clientIP("10.0.0.2", forwarded); got != "198.51.100.1" {
+		t.Errorf("after reload: expected the forwarded address, got %s", got)
+	}
+}
+
+func TestClientIP_CFConnectingIP(t *testing.T) {
+	t.Setenv("TRUSTED_PROXIES", "173.245.48.0/20")
+	t.Setenv("CLIENT_IP_HEADER", "CF-Connecting-IP")
+	clientIP := clientIPRouter(t)
+
+	if got := clientIP("173.245.48.5", map[string]string{"CF-Connecting-IP": "198.51.100.1"}); got != "198.51.100.1" {
+		t.Errorf("trusted edge: expected CF-Connecting-IP, got %s", got)
+	}
+	if got := clientIP("203.0.113.9", map[string]string{"CF-Connecting-IP": "198.51.100.1"}); got != "203.0.113.9" {
+		t.Errorf("request bypassing the edge: expected its own address, got %s", got)
+	}
+	if got := clientIP("173.245.48.5", map[string]string{"X-Forwarded-For": "1.2.3.4"}); got != "173.245.48.5" {
+		t.Errorf("X-Forwarded-For must be ignored when CF-Connecting-IP is selected, got %s", got)
+	}
+
+	t.Setenv("CLIENT_IP_HEADER", "Forwarded")
+	if err := loadConfig().Validate(); err == nil {
+		t.Error("unsupported CLIENT_IP_HEADER should fail validation")
+	}
+}
+
+func TestRateLimit_KeyedOnResolvedClientIP(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	t.Setenv("TRUSTED_PROXIES", "10.0.0.0/8")
+	t.Setenv("RATE_LIMIT_ENABLED", "true")
+	t.Setenv("RATE_LIMIT_ANONYMOUS_RPM", "60")
+	t.Setenv("RATE_LIMIT_ANONYMOUS_BURST", "1")
+	r := gin.New()
+	applyTrustedProxies(r)
+	r.Use(RateLimitMiddleware(initRateLimiters()))
+	r.GET("/test", func(c *gin.Context) { c.Status(http.StatusOK) })
+
+	request := func(remote, forwarded string) int {
+		req := httptest.NewRequest(http.MethodGet, "/test", nil)
+		req.RemoteAddr = remote + ":1234"
+		req.Header.Set("X-Forwarded-For", forwarded)
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w.Code
+	}
+
+	if code := request("203.0.113.9", "198.51.100.1"); code != http.StatusOK {
+		t.Fatalf("first request: expected 200, got %d", code)
+	}
+	if code := request("203.0.113.9", "198.51.100.2"); code != http.StatusTooManyRequests {
+		t.Errorf("rotating X-Forwarded-For from an untrusted peer must not reset the limit, got %d", code)
+	}
+	if code := request("10.0.0.2", "198.51.100.1"); code != http.StatusOK {
+		t.Errorf("a different client behind the trusted proxy: expected 200, got %d", code)
+	}
+	if code := request("10.0.0.3", "198.51.100.1"); code != http.StatusTooManyRequests {
+		t.Errorf("the same client via another proxy: expected 429, got %d", code)
+	}
+}
@@ -0,0 +1,153 @@
+package server
+
+import (
+	"crypto/subtle"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// adminAuthMiddleware guards operator endpoints with the bearer token in
+// ADMIN_API_KEY. When the key is unset the admin API is disabled and every
+// route behind it answers 404.
+func adminAuthMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		key := os.Getenv("ADMIN_API_KEY")
+		if key == "" {
+			abortWithError(c, CodeNotFound, "Admin API is disabled")
+			return
+		}
+		if !isAdminRequest(c) {
+			abortWithError(c, CodeUnauthorized, "Valid admin bearer token required")
+			return
+		}
+		c.Next()
+	}
+}
+
+// isAdminRequest reports whether the request carries the ADMIN_API_KEY
+// bearer token.
+func isAdminRequest(c *gin.Context) bool {
+	key := os.Getenv("ADMIN_API_KEY")
+	token, ok := strings.CutPrefix(c.GetHeader("Authorization"), "Bearer ")
+	return key != "" && ok && subtle.ConstantTimeCompare([]byte(token), []byte(key)) == 1
+}
+
+// marginDimensions are the values accepted by the group_by query parameter.
+var marginDimensions = []string{"endpoint", "model", "tenant"}
+
+// handleMarginReport handles GET /api/admin/margins. Query parameters:
+//
+//	from, to   RFC 3339 bounds (default: the last 7 days)
+//	interval   "hour", "day" or "total" (default: day)
+//	group_by   comma-separated subset of endpoint,model,tenant (default: all)
+//
+// Revenue is the amount charged; cost is what the provider reported for the
+// request, or its tokens at the catalog price when no cost was reported
+// (zero for cache hits). Both are in USDC.
+func handleMarginReport(c *gin.Context) {
+	from, to, ok := parseTimeWindow(c, 7*24*time.Hour)
+	if !ok {
+		return
+	}
+
+	intervalName := c.DefaultQuery("interval", "day")
+	var interval time.Duration
+	switch intervalName {
+	case "hour":
+		interval = time.Hour
+	case "day":
+		interval = 24 * time.Hour
+	case "total":
+		interval = to.Sub(from) + marginBucket
+	default:
+		respondError(c, CodeInvalidRequest, "interval must be hour, day or total")
+		return
+	}
+	if intervalName != "total" {
+		// Align periods to UTC hour/day boundaries.
+		from = from.Truncate(interval)
+	} else {
+		from = from.Truncate(marginBucket)
+	}
+
+	groupBy := marginDimensions
+	if v, ok := c.GetQuery("group_by"); ok {
+		groupBy = []string{}
+		for _, dim := range strings.Split(v, ",") {
+			dim = strings.TrimSpace(dim)
+			if dim == "" {
+				continue
+			}
+			if !slices.Contains(marginDimensions, dim) {
+				respondError(c, CodeInvalidRequest, "group_by accepts endpoint, model and tenant")
+				return
+			}
+			groupBy = append(groupBy, dim)
+		}
+	}
+
+	rows, totals := margins.Report(from, to, interval, groupBy)
+	c.JSON(200, gin.H{
+		"from":     from,
+		"to":       to,
+		"interval": intervalName,
+		"group_by": groupBy,
+		"currency": "USDC",
+		"rows":     rows,
+		"totals":   totals,
+	})
+}
+
+// handleGetReceiptMargin handles GET /api/admin/receipts/:id/margin: the
+// token usage, provider cost and margin of the request a stored receipt was
+// issued for.
+func handleGetReceiptMargin(c *gin.Context) {
+	m, ok := getReceiptMargin(c.Param("id"))
+	if !ok {
+		respondError(c, CodeReceiptNotFound, "No margin is recorded for this receipt")
+		return
+	}
+	c.JSON(200, m)
+}
+
+// parseTimeWindow reads the from and to query parameters as RFC 3339
+// timestamps, defaulting to the span before now. An invalid window answers
+// 400 and returns false.
+func parseTimeWindow(c *gin.Context, span time.Duration) (time.Time, time.Time, bool) {
+	to := time.Now().UTC()
+	from := to.Add(-span)
+	var err error
+	if v := c.Query("to"); v != "" {
+		if to, err = time.Parse(time.RFC3339, v); err != nil {
+			respondError(c, CodeInvalidRequest, "to must be an RFC 3339 timestamp")
+			return time.Time{}, time.Time{}, false
+		}
+	}
+	if v := c.Query("from"); v != "" {
+		if from, err = time.Parse(time.RFC3339, v); err != nil {
+			respondError(c, CodeInvalidRequest, "from must be an RFC 3339 timestamp")
+			return time.Time{}, time.Time{}, false
+		}
+	}
+	from, to = from.UTC(), to.UTC()
+	if !from.Before(to) {
+		respondError(c, CodeInvalidRequest, "from must be before to")
+		return time.Time{}, time.Time{}, false
+	}
+	return from, to, true
+}
+
+// handleDeprecationReport handles GET /api/admin/deprecations, listing which
+// clients still use legacy request formats and how recently.
+func handleDeprecationReport(c *gin.Context) {
+	usage := deprecations.Snapshot()
+	clients := make(map[string]bool)
+	for _, u := range usage {
+		clients[u.Client] = true
+	}
+	c.JSON(200, gin.H{"usage": usage, "clients": len(clients)})
+}
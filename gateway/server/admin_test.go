@@ -0,0 +1,129 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"gateway/internal/testsupport"
+)
+
+// withMargins installs an empty margin ledger for the duration of the test.
+func withMargins(t *testing.T) {
+	t.Helper()
+	prev := margins
+	margins = newMarginLedger(24 * time.Hour)
+	t.Cleanup(func() { margins = prev })
+}
+
+func adminGet(t *testing.T, h http.Handler, path, token string) *httptest.ResponseRecorder {
+	t.Helper()
+	req := httptest.NewRequest(http.MethodGet, path, nil)
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	return w
+}
+
+func TestAdminAuth(t *testing.T) {
+	t.Setenv("ADMIN_API_KEY", "")
+	if w := adminGet(t, newTestRouter(), "/api/admin/margins", "anything"); w.Code != http.StatusNotFound {
+		t.Errorf("expected 404 when admin API is disabled, got %d", w.Code)
+	}
+
+	t.Setenv("ADMIN_API_KEY", "s3cret")
+	if w := adminGet(t, newTestRouter(), "/api/admin/margins", "wrong"); w.Code != http.StatusUnauthorized {
+		t.Errorf("expected 401 for wrong token, got %d", w.Code)
+	}
+	if w := adminGet(t, newTestRouter(), "/api/admin/margins", "s3cret"); w.Code != http.StatusOK {
+		t.Errorf("expected 200 for valid token, got %d", w.Code)
+	}
+}
+
+func TestMarginReport_CombinesProviderCostWithCharge(t *testing.T) {
+	withMargins(t)
+	t.Setenv("ADMIN_API_KEY", "s3cret")
+	t.Setenv("PAYMENT_AMOUNT", "0.001")
+	h := testsupport.NewHarness(t, newTestRouter)
+	h.AI.SetCost(0.00025)
+
+	if resp := h.Post(t, "/api/ai/summarize", `{"text":"hello"}`, "0xsig", "nonce"); resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected 200, got %d", resp.StatusCode)
+	}
+
+	w := adminGet(t, newTestRouter(), "/api/admin/margins?interval=total&group_by=model,tenant", "s3cret")
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+	var report struct {
+		Rows   []MarginRow `json:"rows"`
+		Totals MarginRow   `json:"totals"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &report); err != nil {
+		t.Fatalf("decode report: %v", err)
+	}
+	if len(report.Rows) != 1 {
+		t.Fatalf("expected one row, got %+v", report.Rows)
+	}
+	row := report.Rows[0]
+	if row.Endpoint != "" || row.Model == "" || row.Tenant == "" {
+		t.Errorf("expected rows grouped by model and tenant only, got %+v", row)
+	}
+	if row.Revenue != "0.001" || row.Cost != "0.00025" || row.Margin != "0.00075" || row.MarginPct != 75 {
+		t.Errorf("unexpected margin row: %+v", row)
+	}
+}
+
+func TestReceiptMargin_PricesReportedTokensFromCatalog(t *testing.T) {
+	withMargins(t)
+	withStats(t)
+	withReceiptStore(t)
+	t.Setenv("ADMIN_API_KEY", "s3cret")
+	t.Setenv("PAYMENT_AMOUNT", "0.001")
+	h := testsupport.NewHarness(t, newTestRouter)
+	withModelCatalog(t, h, testsupport.CatalogModel{ID: defaultModel, PromptPrice: "0.0000001", CompletionPrice: "0.0000005"})
+	h.AI.SetUsage(1000, 200)
+
+	resp := h.Post(t, "/api/ai/summarize", `{"text":"hello"}`, "0xsig", "nonce-usage")
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected 200, got %d", resp.StatusCode)
+	}
+	id := decodeReceiptHeader(t, resp.Header.Get("X-402-Receipt")).Receipt.ID
+
+	w := adminGet(t, newTestRouter(), "/api/admin/receipts/"+id+"/margin", "s3cret")
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+	var m ReceiptMargin
+	if err := json.Unmarshal(w.Body.Bytes(), &m); err != nil {
+		t.Fatal(err)
+	}
+	if m.PromptTokens != 1000 || m.CompletionTokens != 200 || m.CostSource != "catalog" {
+		t.Errorf("expected the reported tokens priced from the catalog, got %+v", m)
+	}
+	if m.Revenue != "0.001" || m.Cost != "0.0002" || m.Margin != "0.0008" || m.MarginPct != 80 {
+		t.Errorf("unexpected receipt margin %+v", m)
+	}
+
+	total := gatewayStats.Total()
+	if total.ProviderCost != "0.0002" || total.Margin != "0.0008" || total.PromptTokens != 1000 || total.CompletionTokens != 200 {
+		t.Errorf("expected the usage in the stats, got %+v", total)
+	}
+
+	if w := adminGet(t, newTestRouter(), "/api/admin/receipts/unknown/margin", "s3cret"); w.Code != http.StatusNotFound {
+		t.Errorf("expected 404 for an unknown receipt, got %d", w.Code)
+	}
+}
+
+func TestMarginReport_RejectsBadParams(t *testing.T) {
+	t.Setenv("ADMIN_API_KEY", "s3cret")
+	for _, q := range []string{"interval=week", "group_by=region", "from=yesterday", "from=2026-01-02T00:00:00Z&to=2026-01-01T00:00:00Z"} {
+		if w := adminGet(t, newTestRouter(), "/api/admin/margins?"+q, "s3cret"); w.Code != http.StatusBadRequest {
+			t.Errorf("%s: expected 400, got %d", q, w.Code)
+		}
+	}
+}
@@ -0,0 +1,207 @@
+package server
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// defaultAdmissionWeights gives each rate limit tier its share of capacity
+// under contention.
+var defaultAdmissionWeights = map[string]float64{"anonymous": 1, "standard": 3, "verified": 6}
+
+// AdmissionConfig controls the priority lanes in front of the handlers.
+// Below Threshold of Capacity in-flight requests everything is admitted.
+// Above it each tier with requests in flight is guaranteed a share of
+// Capacity proportional to its weight, and a tier past its share is shed,
+// so low-weight tiers are shed first and verified requests keep a lane.
+type AdmissionConfig struct {
+	Enabled    bool
+	Capacity   int
+	Threshold  float64
+	Weights    map[string]float64
+	RetryAfter time.Duration
+
+	// weightsErr holds an ADMISSION_TIER_WEIGHTS parse error.
+	weightsErr error
+}
+
+// loadAdmissionConfig reads the ADMISSION_* variables.
+func loadAdmissionConfig() AdmissionConfig {
+	ac := AdmissionConfig{
+		Enabled:    getEnvAsBool("ADMISSION_ENABLED", false),
+		Capacity:   getEnvAsInt("ADMISSION_CAPACITY", 200),
+		Threshold:  getEnvAsFloat("ADMISSION_THRESHOLD", 0.8),
+		Weights:    defaultAdmissionWeights,
+		RetryAfter: time.Duration(getEnvAsInt("ADMISSION_RETRY_AFTER_SECONDS", 2)) * time.Second,
+	}
+	if entries := getEnvAsList("ADMISSION_TIER_WEIGHTS", nil); entries != nil {
+		ac.Weights, ac.weightsErr = parseAdmissionWeights(entries)
+	}
+	return ac
+}
+
+// parseAdmissionWeights parses "tier=weight" entries, e.g.
+// "anonymous=1,standard=3,verified=6".
+func parseAdmissionWeights(entries []string) (map[string]float64, error) {
+	weights := make(map[string]float64, len(entries))
+	for _, entry := range entries {
+		tier, value, ok := strings.Cut(entry, "=")
+		tier = strings.TrimSpace(tier)
+		if !ok || tier == "" {
+			return nil, fmt.Errorf("%q is not tier=weight", entry)
+		}
+		w, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+		if err != nil || w < 0 {
+			return nil, fmt.Errorf("weight of tier %q must be a non-negative number", tier)
+		}
+		weights[tier] = w
+	}
+	return weights, nil
+}
+
+// validate reports settings that would admit nothing.
+func (ac AdmissionConfig) validate() error {
+	if ac.weightsErr != nil {
+		return fmt.Errorf("invalid ADMISSION_TIER_WEIGHTS: %w", ac.weightsErr)
+	}
+	if !ac.Enabled {
+		return nil
+	}
+	if ac.Capacity <= 0 {
+		return fmt.Errorf("ADMISSION_CAPACITY must be positive")
+	}
+	if ac.Threshold <= 0 || ac.Threshold > 1 {
+		return fmt.Errorf("ADMISSION_THRESHOLD must be in (0, 1]")
+	}
+	for _, w := range ac.Weights {
+		if w > 0 {
+			return nil
+		}
+	}
+	return fmt.Errorf("ADMISSION_TIER_WEIGHTS needs a tier with a positive weight")
+}
+
+// admissionController counts in-flight requests per tier and decides which
+// to admit.
+type admissionController struct {
+	mu       sync.Mutex
+	inFlight map[string]int
+	total    int
+	shed     map[string]int64
+}
+
+func newAdmissionController() *admissionController {
+	return &admissionController{inFlight: make(map[string]int), shed: make(map[string]int64)}
+}
+
+// admission guards the routes behind the rate limiter.
+var admission = newAdmissionController()
+
+// share returns tier's guaranteed in-flight slots: its weight's part of
+// capacity among the tiers that have requests in flight, itself included,
+// so idle tiers' shares go to the busy ones. Called with a.mu held.
+func (a *admissionController) share(ac AdmissionConfig, tier string) float64 {
+	sum := ac.Weights[tier]
+	for t, n := range a.inFlight {
+		if n > 0 && t != tier {
+			sum += ac.Weights[t]
+		}
+	}
+	if sum == 0 {
+		return 0
+	}
+	return float64(ac.Capacity) * ac.Weights[tier] / sum
+}
+
+// acquire admits a request of tier, or counts it as shed and returns false.
+// An admitted request must be released.
+func (a *admissionController) acquire(ac AdmissionConfig, tier string) bool {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if float64(a.total) >= ac.Threshold*float64(ac.Capacity) && float64(a.inFlight[tier]) >= a.share(ac, tier) {
+		a.shed[tier]++
+		return false
+	}
+	a.inFlight[tier]++
+	a.total++
+	return true
+}
+
+// release ends an admitted request of tier.
+func (a *admissionController) release(tier string) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.inFlight[tier]--
+	a.total--
+}
+
+// Status reports in-flight requests, current shares and shed counts per
+// tier for /readyz.
+func (a *admissionController) Status(ac AdmissionConfig) gin.H {
+	if !ac.Enabled {
+		return gin.H{"state": "disabled"}
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	state := "normal"
+	if float64(a.total) >= ac.Threshold*float64(ac.Capacity) {
+		state = "prioritizing"
+	}
+	tiers := make(gin.H, len(ac.Weights))
+	for tier, w := range ac.Weights {
+		tiers[tier] = gin.H{
+			"weight":    w,
+			"in_flight": a.inFlight[tier],
+			"share":     a.share(ac, tier),
+			"shed":      a.shed[tier],
+		}
+	}
+	return gin.H{
+		"state":     state,
+		"in_flight": a.total,
+		"capacity":  ac.Capacity,
+		"threshold": ac.Threshold,
+		"tiers":     tiers,
+	}
+}
+
+// requestTier returns the rate limit tier of c, or when rate limiting is
+// off whether it carries a payment, as statsMiddleware counts it.
+func requestTier(c *gin.Context) string {
+	if tier := c.GetString("rate_limit_tier"); tier != "" {
+		return tier
+	}
+	if isAnonymousRequest(c) {
+		return "anonymous"
+	}
+	return "standard"
+}
+
+// admissionMiddleware sheds requests with 503 when their tier is past its
+// share of a nearly full gateway. It runs after the rate limiter, which
+// assigns the tier. Health checks and the admin API always pass.
+func admissionMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ac := getConfig().Admission
+		path := c.Request.URL.Path
+		if !ac.Enabled || path == "/healthz" || path == "/readyz" || strings.HasPrefix(path, "/api/admin/") {
+			c.Next()
+			return
+		}
+		tier := requestTier(c)
+		if !admission.acquire(ac, tier) {
+			gatewayStats.RecordAdmissionShed(time.Now(), tier)
+			c.Header("Retry-After", strconv.Itoa(max(int(ac.RetryAfter.Seconds()), 1)))
+			abortWithAPIError(c, newAPIError(CodeOverloaded, "The gateway is prioritizing other requests. Please retry later.").
+				with(gin.H{"tier": tier}))
+			return
+		}
+		defer admission.release(tier)
+		c.Next()
+	}
+}
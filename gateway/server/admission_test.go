@@ -0,0 +1,137 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"gateway/internal/testsupport"
+)
+
+// withAdmission enables the priority lanes with env and installs a fresh
+// controller.
+func withAdmission(t *testing.T, env map[string]string) *admissionController {
+	t.Helper()
+	t.Setenv("ADMISSION_ENABLED", "true")
+	for k, v := range env {
+		t.Setenv(k, v)
+	}
+	prev := admission
+	admission = newAdmissionController()
+	t.Cleanup(func() { admission = prev })
+	return admission
+}
+
+func TestAdmissionController_VerifiedPreemptsAnonymous(t *testing.T) {
+	ac := AdmissionConfig{Enabled: true, Capacity: 10, Threshold: 0.5, Weights: map[string]float64{"anonymous": 1, "verified": 4}}
+	a := newAdmissionController()
+
+	// Alone, anonymous traffic may use the whole capacity.
+	for i := range 10 {
+		if !a.acquire(ac, "anonymous") {
+			t.Fatalf("anonymous request %d shed with spare capacity", i)
+		}
+	}
+	if a.acquire(ac, "anonymous") {
+		t.Fatal("expected anonymous requests past capacity to be shed")
+	}
+	// Verified requests still get their lane: 4/5 of capacity.
+	for i := range 8 {
+		if !a.acquire(ac, "verified") {
+			t.Fatalf("verified request %d shed within its share", i)
+		}
+	}
+	if a.acquire(ac, "verified") {
+		t.Error("expected verified requests past their share to be shed")
+	}
+	// With verified traffic busy, anonymous is held to 1/5 of capacity.
+	for range 8 {
+		a.release("anonymous")
+	}
+	if a.acquire(ac, "anonymous") {
+		t.Error("expected anonymous requests past their share to be shed")
+	}
+	a.release("anonymous")
+	if !a.acquire(ac, "anonymous") {
+		t.Error("expected an anonymous request within its share to be admitted")
+	}
+	if a.shed["anonymous"] != 2 || a.shed["verified"] != 1 {
+		t.Errorf("unexpected shed counts %v", a.shed)
+	}
+}
+
+func TestAdmissionConfig_Validate(t *testing.T) {
+	weights, err := parseAdmissionWeights([]string{"anonymous=0.5", "verified=6"})
+	if err != nil || weights["anonymous"] != 0.5 || weights["verified"] != 6 {
+		t.Fatalf("unexpected weights %v (%v)", weights, err)
+	}
+	for _, entries := range [][]string{{"verified"}, {"=1"}, {"anonymous=-1"}, {"standard=x"}} {
+		if _, err := parseAdmissionWeights(entries); err == nil {
+			t.Errorf("expected %v to be rejected", entries)
+		}
+	}
+
+	base := AdmissionConfig{Enabled: true, Capacity: 10, Threshold: 0.8, Weights: defaultAdmissionWeights}
+	if err := base.validate(); err != nil {
+		t.Fatalf("expected valid config, got %v", err)
+	}
+	for name, mutate := range map[string]func(*AdmissionConfig){
+		"no capacity":     func(ac *AdmissionConfig) { ac.Capacity = 0 },
+		"threshold above": func(ac *AdmissionConfig) { ac.Threshold = 1.5 },
+		"zero weights":    func(ac *AdmissionConfig) { ac.Weights = map[string]float64{"anonymous": 0} },
+	} {
+		ac := base
+		mutate(&ac)
+		if err := ac.validate(); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}
+
+func TestAdmission_ShedsAnonymousFirst(t *testing.T) {
+	a := withAdmission(t, map[string]string{
+		"ADMISSION_CAPACITY":     "4",
+		"ADMISSION_THRESHOLD":    "0.5",
+		"ADMISSION_TIER_WEIGHTS": "anonymous=1,standard=3",
+	})
+	h := testsupport.NewHarness(t, newTestRouter)
+	before := gatewayStats.Total().AdmissionShed["anonymous"]
+
+	// Requests already being served: anonymous is at its share of one slot.
+	a.inFlight["anonymous"], a.inFlight["standard"], a.total = 1, 2, 3
+
+	resp := h.Post(t, "/api/ai/summarize", `{"text":"hello"}`, "", "")
+	if body := decodeErrorBody(t, resp); resp.StatusCode != http.StatusServiceUnavailable || body.Code != CodeOverloaded || resp.Header.Get("Retry-After") != "2" {
+		t.Errorf("expected anonymous request shed with 503 %s, got %d %+v", CodeOverloaded, resp.StatusCode, body)
+	}
+	if resp := h.Post(t, "/api/ai/summarize", `{"text":"hello"}`, "0xsig", "nonce-admission"); resp.StatusCode != http.StatusOK {
+		t.Errorf("expected paid request admitted in its lane, got %d", resp.StatusCode)
+	}
+	if resp := h.Get(t, "/healthz"); resp.StatusCode != http.StatusOK {
+		t.Errorf("expected health checks never to be shed, got %d", resp.StatusCode)
+	}
+	if got := gatewayStats.Total().AdmissionShed["anonymous"]; got != before+1 {
+		t.Errorf("expected the shed request in the stats, got %d after %d", got, before)
+	}
+
+	resp = h.Get(t, "/readyz")
+	var body struct {
+		Checks struct {
+			Admission struct {
+				State string `json:"state"`
+				Tiers map[string]struct {
+					InFlight int     `json:"in_flight"`
+					Shed     int64   `json:"shed"`
+					Share    float64 `json:"share"`
+				} `json:"tiers"`
+			} `json:"admission"`
+		} `json:"checks"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		t.Fatal(err)
+	}
+	status := body.Checks.Admission
+	if status.State != "prioritizing" || status.Tiers["anonymous"].Shed != 1 || status.Tiers["standard"].InFlight != 2 {
+		t.Errorf("unexpected admission status %+v", status)
+	}
+}
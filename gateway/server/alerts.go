@@ -0,0 +1,405 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/mail"
+	"net/smtp"
+	"net/url"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Alert conditions.
+const (
+	alertVerifierDown     = "verifier_unreachable"
+	alertPanicSpike       = "panic_spike"
+	alertCircuitOpen      = "circuit_open"
+	alertReceiptStoreFull = "receipt_store_capacity"
+)
+
+// Alert statuses and severities.
+const (
+	alertStatusFiring     = "firing"
+	alertStatusResolved   = "resolved"
+	alertSeverityCritical = "critical"
+	alertSeverityWarning  = "warning"
+)
+
+// alertHTTPTimeout bounds each Slack or webhook delivery.
+const alertHTTPTimeout = 10 * time.Second
+
+// AlertConfig sends operational alerts to Slack, a generic webhook and/or
+// email. Conditions are checked every Interval; a condition that stays true
+// is re-sent at most once per Cooldown, and its clearing is sent once.
+// Zero thresholds switch their condition off.
+type AlertConfig struct {
+	SlackWebhookURL string
+	WebhookURL      string
+	SMTPAddr        string
+	SMTPUsername    string
+	SMTPPassword    string
+	EmailFrom       string
+	EmailTo         []string
+
+	Interval           time.Duration
+	Cooldown           time.Duration
+	VerifierDownAfter  time.Duration
+	PanicThreshold     int
+	PanicWindow        time.Duration
+	CircuitOpen        bool
+	ReceiptStoreMaxLen int
+}
+
+// loadAlertConfig reads the ALERT_* variables.
+func loadAlertConfig() AlertConfig {
+	return AlertConfig{
+		SlackWebhookURL:    os.Getenv("ALERT_SLACK_WEBHOOK_URL"),
+		WebhookURL:         os.Getenv("ALERT_WEBHOOK_URL"),
+		SMTPAddr:           os.Getenv("ALERT_SMTP_ADDR"),
+		SMTPUsername:       os.Getenv("ALERT_SMTP_USERNAME"),
+		SMTPPassword:       os.Getenv("ALERT_SMTP_PASSWORD"),
+		EmailFrom:          os.Getenv("ALERT_EMAIL_FROM"),
+		EmailTo:            getEnvAsList("ALERT_EMAIL_TO", nil),
+		Interval:           time.Duration(getEnvAsInt("ALERT_CHECK_SECONDS", 30)) * time.Second,
+		Cooldown:           time.Duration(getEnvAsInt("ALERT_COOLDOWN_SECONDS", 1800)) * time.Second,
+		VerifierDownAfter:  time.Duration(getEnvAsInt("ALERT_VERIFIER_DOWN_MINUTES", 5)) * time.Minute,
+		PanicThreshold:     getEnvAsInt("ALERT_PANIC_THRESHOLD", 5),
+		PanicWindow:        time.Duration(getEnvAsInt("ALERT_PANIC_WINDOW_MINUTES", 5)) * time.Minute,
+		CircuitOpen:        getEnvAsBool("ALERT_CIRCUIT_OPEN", true),
+		ReceiptStoreMaxLen: getEnvAsInt("ALERT_RECEIPT_STORE_MAX", 0),
+	}
+}
+
+// Enabled reports whether any sink is configured.
+func (ac AlertConfig) Enabled() bool {
+	return ac.SlackWebhookURL != "" || ac.WebhookURL != "" || ac.SMTPAddr != ""
+}
+
+// validate reports malformed sink settings and non-positive intervals.
+func (ac AlertConfig) validate() error {
+	if !ac.Enabled() {
+		return nil
+	}
+	for name, raw := range map[string]string{"ALERT_SLACK_WEBHOOK_URL": ac.SlackWebhookURL, "ALERT_WEBHOOK_URL": ac.WebhookURL} {
+		if raw == "" {
+			continue
+		}
+		if u, err := url.Parse(raw); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("%s must be an http(s) URL", name)
+		}
+	}
+	if ac.SMTPAddr != "" {
+		if _, _, err := net.SplitHostPort(ac.SMTPAddr); err != nil {
+			return fmt.Errorf("ALERT_SMTP_ADDR must be host:port: %w", err)
+		}
+		if _, err := mail.ParseAddress(ac.EmailFrom); err != nil {
+			return fmt.Errorf("ALERT_EMAIL_FROM must be an email address")
+		}
+		if len(ac.EmailTo) == 0 {
+			return fmt.Errorf("ALERT_EMAIL_TO must list at least one address")
+		}
+		for _, to := range ac.EmailTo {
+			if _, err := mail.ParseAddress(to); err != nil {
+				return fmt.Errorf("invalid ALERT_EMAIL_TO address %q", to)
+			}
+		}
+	}
+	if ac.Interval <= 0 || ac.Cooldown <= 0 {
+		return fmt.Errorf("ALERT_CHECK_SECONDS and ALERT_COOLDOWN_SECONDS must be positive")
+	}
+	if ac.VerifierDownAfter < 0 || ac.PanicThreshold < 0 || ac.PanicWindow <= 0 || ac.ReceiptStoreMaxLen < 0 {
+		return fmt.Errorf("alert thresholds must not be negative")
+	}
+	return nil
+}
+
+// Alert is one notification: a condition starting (firing), still holding
+// after the cooldown, or clearing (resolved).
+type Alert struct {
+	Name     string    `json:"name"`
+	Status   string    `json:"status"`
+	Severity string    `json:"severity"`
+	Message  string    `json:"message"`
+	Instance string    `json:"instance"`
+	Since    time.Time `json:"since"`
+	Time     time.Time `json:"time"`
+}
+
+// AlertSink delivers alerts to one destination.
+type AlertSink interface {
+	Name() string
+	Send(ctx context.Context, a Alert) error
+}
+
+// alertSinks returns the sinks configured in ac.
+func alertSinks(ac AlertConfig) []AlertSink {
+	var sinks []AlertSink
+	if ac.SlackWebhookURL != "" {
+		sinks = append(sinks, slackAlertSink{url: ac.SlackWebhookURL})
+	}
+	if ac.WebhookURL != "" {
+		sinks = append(sinks, webhookAlertSink{url: ac.WebhookURL})
+	}
+	if ac.SMTPAddr != "" {
+		sinks = append(sinks, smtpAlertSink{addr: ac.SMTPAddr, username: ac.SMTPUsername, password: ac.SMTPPassword, from: ac.EmailFrom, to: ac.EmailTo})
+	}
+	return sinks
+}
+
+// postAlertJSON POSTs body as JSON to target and expects a 2xx answer.
+func postAlertJSON(ctx context.Context, target string, body any) error {
+	data, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(ctx, alertHTTPTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, target, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("answered %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// alertSubject is the one-line summary of a, used as the Slack title and
+// email subject.
+func alertSubject(a Alert) string {
+	return fmt.Sprintf("[%s] %s %s on %s", strings.ToUpper(a.Status), a.Severity, a.Name, a.Instance)
+}
+
+// slackAlertSink posts to a Slack incoming webhook.
+type slackAlertSink struct{ url string }
+
+func (slackAlertSink) Name() string { return "slack" }
+
+func (s slackAlertSink) Send(ctx context.Context, a Alert) error {
+	color := "#d0021b"
+	if a.Status == alertStatusResolved {
+		color = "#2eb886"
+	}
+	return postAlertJSON(ctx, s.url, gin.H{
+		"text": alertSubject(a),
+		"attachments": []gin.H{{
+			"color": color,
+			"text":  a.Message,
+			"ts":    a.Time.Unix(),
+		}},
+	})
+}
+
+// webhookAlertSink posts the Alert as JSON.
+type webhookAlertSink struct{ url string }
+
+func (webhookAlertSink) Name() string { return "webhook" }
+
+func (s webhookAlertSink) Send(ctx context.Context, a Alert) error {
+	return postAlertJSON(ctx, s.url, a)
+}
+
+// smtpAlertSink emails alerts, with PLAIN auth when a username is set.
+type smtpAlertSink struct {
+	addr     string
+	username string
+	password string
+	from     string
+	to       []string
+}
+
+func (smtpAlertSink) Name() string { return "email" }
+
+func (s smtpAlertSink) Send(ctx context.Context, a Alert) error {
+	var auth smtp.Auth
+	if s.username != "" {
+		host, _, _ := net.SplitHostPort(s.addr)
+		auth = smtp.PlainAuth("", s.username, s.password, host)
+	}
+	errCh := make(chan error, 1)
+	go func() { errCh <- smtp.SendMail(s.addr, auth, s.from, s.to, alertEmail(s.from, s.to, a)) }()
+	select {
+	case err := <-errCh:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// alertEmail renders a as a plain-text message.
+func alertEmail(from string, to []string, a Alert) []byte {
+	var b strings.Builder
+	fmt.Fprintf(&b, "From: %s\r\n", from)
+	fmt.Fprintf(&b, "To: %s\r\n", strings.Join(to, ", "))
+	fmt.Fprintf(&b, "Subject: %s\r\n", alertSubject(a))
+	fmt.Fprintf(&b, "Date: %s\r\n", a.Time.Format(time.RFC1123Z))
+	b.WriteString("MIME-Version: 1.0\r\nContent-Type: text/plain; charset=utf-8\r\n\r\n")
+	fmt.Fprintf(&b, "%s\r\n\r\nCondition: %s\r\nStatus: %s\r\nSince: %s\r\nInstance: %s\r\n",
+		a.Message, a.Name, a.Status, a.Since.Format(time.RFC3339), a.Instance)
+	return []byte(b.String())
+}
+
+// alertCheck is the outcome of one condition: whether it holds, and what to
+// say about it.
+type alertCheck struct {
+	name     string
+	severity string
+	firing   bool
+	message  string
+}
+
+// alertState tracks one condition between checks.
+type alertState struct {
+	since    time.Time
+	lastSent time.Time
+	message  string
+	severity string
+}
+
+// alerter evaluates the conditions and notifies the sinks, deduplicating
+// alerts per condition.
+type alerter struct {
+	mu     sync.Mutex
+	active map[string]*alertState
+	// verifierDownSince is when the verifier was first seen unhealthy in the
+	// current outage, zero while it is healthy.
+	verifierDownSince time.Time
+}
+
+var alerts = &alerter{active: make(map[string]*alertState)}
+
+// checks evaluates every enabled condition at now.
+func (al *alerter) checks(cfg *Config, now time.Time) []alertCheck {
+	ac := cfg.Alerts
+	var out []alertCheck
+	if ac.VerifierDownAfter > 0 {
+		status := checkVerifierHealth()
+		al.mu.Lock()
+		if status == "ok" {
+			al.verifierDownSince = time.Time{}
+		} else if al.verifierDownSince.IsZero() {
+			al.verifierDownSince = now
+		}
+		down := al.verifierDownSince
+		al.mu.Unlock()
+		check := alertCheck{name: alertVerifierDown, severity: alertSeverityCritical, message: "The payment verifier is healthy again"}
+		if !down.IsZero() {
+			check.firing = now.Sub(down) >= ac.VerifierDownAfter
+			check.message = fmt.Sprintf("The payment verifier has been %s for %s; paid requests are failing", status, now.Sub(down).Round(time.Second))
+		}
+		out = append(out, check)
+	}
+	if ac.PanicThreshold > 0 {
+		panics := gatewayStats.Window(now, ac.PanicWindow).Panics
+		out = append(out, alertCheck{
+			name:     alertPanicSpike,
+			severity: alertSeverityCritical,
+			firing:   panics >= int64(ac.PanicThreshold),
+			message:  fmt.Sprintf("%d handler panics in the last %s (threshold %d)", panics, ac.PanicWindow, ac.PanicThreshold),
+		})
+	}
+	if ac.CircuitOpen {
+		state := providerCircuit.State(cfg)
+		out = append(out, alertCheck{
+			name:     alertCircuitOpen,
+			severity: alertSeverityWarning,
+			firing:   state == circuitOpen,
+			message:  fmt.Sprintf("The AI provider circuit breaker is %s; summaries are refused or served from cache", state),
+		})
+	}
+	if ac.ReceiptStoreMaxLen > 0 {
+		size := receiptStoreSize()
+		out = append(out, alertCheck{
+			name:     alertReceiptStoreFull,
+			severity: alertSeverityWarning,
+			firing:   size >= ac.ReceiptStoreMaxLen,
+			message:  fmt.Sprintf("The receipt store holds %d receipts (limit %d)", size, ac.ReceiptStoreMaxLen),
+		})
+	}
+	return out
+}
+
+// evaluate runs the checks and returns the alerts due: newly firing
+// conditions, conditions still firing once their cooldown has passed, and
+// conditions that cleared.
+func (al *alerter) evaluate(cfg *Config, now time.Time) []Alert {
+	instance, _ := os.Hostname()
+	var due []Alert
+	for _, check := range al.checks(cfg, now) {
+		al.mu.Lock()
+		state, active := al.active[check.name]
+		switch {
+		case check.firing && !active:
+			state = &alertState{since: now}
+			al.active[check.name] = state
+			fallthrough
+		case check.firing && now.Sub(state.lastSent) >= cfg.Alerts.Cooldown:
+			state.lastSent, state.message, state.severity = now, check.message, check.severity
+			due = append(due, Alert{Name: check.name, Status: alertStatusFiring, Severity: check.severity, Message: check.message, Instance: instance, Since: state.since, Time: now})
+		case !check.firing && active:
+			delete(al.active, check.name)
+			due = append(due, Alert{Name: check.name, Status: alertStatusResolved, Severity: check.severity, Message: check.message, Instance: instance, Since: state.since, Time: now})
+		}
+		al.mu.Unlock()
+	}
+	return due
+}
+
+// run is the scheduled alert check. Every sink is tried for every alert;
+// failures are logged and returned together.
+func (al *alerter) run(ctx context.Context) error {
+	cfg := getConfig()
+	if !cfg.Alerts.Enabled() {
+		return nil
+	}
+	sinks := alertSinks(cfg.Alerts)
+	var errs []error
+	for _, a := range al.evaluate(cfg, time.Now().UTC()) {
+		log.Printf("[ALERT] %s", alertSubject(a))
+		for _, sink := range sinks {
+			if err := sink.Send(ctx, a); err != nil {
+				log.Printf("[WARNING] Failed to send %s alert %s: %v", sink.Name(), a.Name, err)
+				errs = append(errs, fmt.Errorf("%s: %w", sink.Name(), err))
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// handleListAlerts handles GET /api/admin/alerts: the configured sinks and
+// the conditions currently firing on this instance.
+func handleListAlerts(c *gin.Context) {
+	cfg := getConfig().Alerts
+	var sinks []string
+	for _, sink := range alertSinks(cfg) {
+		sinks = append(sinks, sink.Name())
+	}
+	alerts.mu.Lock()
+	active := make([]gin.H, 0, len(alerts.active))
+	for name, state := range alerts.active {
+		active = append(active, gin.H{"name": name, "severity": state.severity, "message": state.message, "since": state.since, "last_sent": state.lastSent})
+	}
+	alerts.mu.Unlock()
+	slices.SortFunc(active, func(a, b gin.H) int { return strings.Compare(a["name"].(string), b["name"].(string)) })
+	c.JSON(http.StatusOK, gin.H{"enabled": cfg.Enabled(), "sinks": sinks, "active": active})
+}
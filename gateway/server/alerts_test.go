@@ -0,0 +1,155 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// newTestAlerter returns an alerter with no state, checking only the
+// conditions enabled in the environment.
+func newTestAlerter(t *testing.T) *alerter {
+	t.Helper()
+	t.Setenv("ALERT_VERIFIER_DOWN_MINUTES", "0")
+	t.Setenv("ALERT_PANIC_THRESHOLD", "0")
+	t.Setenv("ALERT_CIRCUIT_OPEN", "false")
+	return &alerter{active: make(map[string]*alertState)}
+}
+
+func TestAlertConfig_Validate(t *testing.T) {
+	ok := AlertConfig{SMTPAddr: "smtp.example.com:587", EmailFrom: "paygate@example.com", EmailTo: []string{"ops@example.com"},
+		Interval: time.Second, Cooldown: time.Minute, PanicWindow: time.Minute}
+	if err := ok.validate(); err != nil {
+		t.Errorf("expected a valid config, got %v", err)
+	}
+	if err := (AlertConfig{}).validate(); err != nil {
+		t.Errorf("expected alerting to be optional, got %v", err)
+	}
+	for name, edit := range map[string]func(*AlertConfig){
+		"no recipients":  func(ac *AlertConfig) { ac.EmailTo = nil },
+		"bad address":    func(ac *AlertConfig) { ac.SMTPAddr = "smtp.example.com" },
+		"bad webhook":    func(ac *AlertConfig) { ac.WebhookURL = "ftp://example.com" },
+		"no cooldown":    func(ac *AlertConfig) { ac.Cooldown = 0 },
+		"negative limit": func(ac *AlertConfig) { ac.ReceiptStoreMaxLen = -1 },
+	} {
+		bad := ok
+		edit(&bad)
+		if bad.validate() == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}
+
+func TestAlerts_VerifierDownDedupedAndResolved(t *testing.T) {
+	al := newTestAlerter(t)
+	t.Setenv("ALERT_VERIFIER_DOWN_MINUTES", "2")
+	t.Setenv("ALERT_COOLDOWN_SECONDS", "1800")
+	orig := checkVerifierHealth
+	t.Cleanup(func() { checkVerifierHealth = orig })
+	checkVerifierHealth = func() string { return "unreachable" }
+	cfg := loadConfig()
+
+	start := time.Now()
+	if due := al.evaluate(cfg, start); len(due) != 0 {
+		t.Fatalf("expected no alert before the verifier was down long enough, got %+v", due)
+	}
+	due := al.evaluate(cfg, start.Add(3*time.Minute))
+	if len(due) != 1 || due[0].Name != alertVerifierDown || due[0].Status != alertStatusFiring || !due[0].Since.Equal(start.Add(3*time.Minute)) {
+		t.Fatalf("expected the verifier alert to fire, got %+v", due)
+	}
+	if due := al.evaluate(cfg, start.Add(10*time.Minute)); len(due) != 0 {
+		t.Errorf("expected the alert to be deduplicated within the cooldown, got %+v", due)
+	}
+	if due := al.evaluate(cfg, start.Add(34*time.Minute)); len(due) != 1 || due[0].Status != alertStatusFiring {
+		t.Errorf("expected a reminder after the cooldown, got %+v", due)
+	}
+
+	checkVerifierHealth = func() string { return "ok" }
+	if due := al.evaluate(cfg, start.Add(35*time.Minute)); len(due) != 1 || due[0].Status != alertStatusResolved {
+		t.Errorf("expected the alert to resolve, got %+v", due)
+	}
+	if due := al.evaluate(cfg, start.Add(36*time.Minute)); len(due) != 0 {
+		t.Errorf("expected nothing once resolved, got %+v", due)
+	}
+}
+
+func TestAlerts_SinksDeliverPanicSpike(t *testing.T) {
+	al := newTestAlerter(t)
+	prevStats := gatewayStats
+	gatewayStats = newStatsAggregator()
+	t.Cleanup(func() { gatewayStats = prevStats })
+
+	var mu sync.Mutex
+	bodies := map[string]map[string]interface{}{}
+	status := http.StatusOK
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body map[string]interface{}
+		json.NewDecoder(r.Body).Decode(&body)
+		mu.Lock()
+		defer mu.Unlock()
+		bodies[r.URL.Path] = body
+		w.WriteHeader(status)
+	}))
+	defer srv.Close()
+	t.Setenv("ALERT_SLACK_WEBHOOK_URL", srv.URL+"/slack")
+	t.Setenv("ALERT_WEBHOOK_URL", srv.URL+"/hook")
+	t.Setenv("ALERT_PANIC_THRESHOLD", "2")
+
+	gatewayStats.RecordPanic(time.Now())
+	if err := al.run(context.Background()); err != nil || len(bodies) != 0 {
+		t.Fatalf("expected no alert below the threshold, got %v %v", err, bodies)
+	}
+	gatewayStats.RecordPanic(time.Now())
+	if err := al.run(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if text, _ := bodies["/slack"]["text"].(string); !strings.Contains(text, alertPanicSpike) || !strings.HasPrefix(text, "[FIRING]") {
+		t.Errorf("unexpected Slack message: %v", bodies["/slack"])
+	}
+	if hook := bodies["/hook"]; hook["name"] != alertPanicSpike || hook["status"] != alertStatusFiring || hook["severity"] != alertSeverityCritical {
+		t.Errorf("unexpected webhook alert: %v", hook)
+	}
+
+	mu.Lock()
+	status = http.StatusBadGateway
+	mu.Unlock()
+	t.Setenv("ALERT_PANIC_THRESHOLD", "3")
+	if err := al.run(context.Background()); err == nil {
+		t.Error("expected failed deliveries to be reported")
+	}
+}
+
+func TestAlerts_EmailAndAdminList(t *testing.T) {
+	a := Alert{Name: alertCircuitOpen, Status: alertStatusFiring, Severity: alertSeverityWarning, Message: "The AI provider circuit breaker is open",
+		Instance: "gw-1", Since: time.Now(), Time: time.Now()}
+	msg := string(alertEmail("paygate@example.com", []string{"ops@example.com", "oncall@example.com"}, a))
+	if !strings.Contains(msg, "Subject: [FIRING] warning circuit_open on gw-1\r\n") || !strings.Contains(msg, "To: ops@example.com, oncall@example.com\r\n") || !strings.Contains(msg, a.Message) {
+		t.Errorf("unexpected email:\n%s", msg)
+	}
+
+	t.Setenv("ADMIN_API_KEY", "s3cret")
+	t.Setenv("ALERT_WEBHOOK_URL", "https://alerts.example.com/hook")
+	alerts.mu.Lock()
+	alerts.active[alertCircuitOpen] = &alertState{since: a.Since, lastSent: a.Time, message: a.Message, severity: a.Severity}
+	alerts.mu.Unlock()
+	t.Cleanup(func() {
+		alerts.mu.Lock()
+		delete(alerts.active, alertCircuitOpen)
+		alerts.mu.Unlock()
+	})
+	w := adminGet(t, newTestRouter(), "/api/admin/alerts", "s3cret")
+	var out struct {
+		Enabled bool                     `json:"enabled"`
+		Sinks   []string                 `json:"sinks"`
+		Active  []map[string]interface{} `json:"active"`
+	}
+	json.Unmarshal(w.Body.Bytes(), &out)
+	if w.Code != http.StatusOK || !out.Enabled || len(out.Sinks) != 1 || out.Sinks[0] != "webhook" || len(out.Active) != 1 || out.Active[0]["name"] != alertCircuitOpen {
+		t.Errorf("unexpected alert listing: %d %s", w.Code, w.Body.String())
+	}
+}
@@ -0,0 +1,21 @@
+package server
+
+import "gateway/payments"
+
+// tokenDecimals is the number of decimal places of the payment token (USDC).
+// Amounts are tracked internally as integer base units to avoid float drift.
+const tokenDecimals = payments.USDCDecimals
+
+// parseTokenAmount converts a decimal amount string such as "0.001" into
+// integer base units (micro-USDC). It rejects negative values, exponents and
+// more precision than the token supports.
+func parseTokenAmount(amount string) (int64, error) {
+	a, err := payments.ParseAmount(amount, tokenDecimals)
+	return a.Units, err
+}
+
+// formatTokenAmount renders base units as a decimal string without trailing
+// zeros, e.g. 1500 -> "0.0015".
+func formatTokenAmount(units int64) string {
+	return payments.Amount{Units: units, Decimals: tokenDecimals}.String()
+}
@@ -0,0 +1,76 @@
+package server
+
+import (
+	"testing"
+
+	"gateway/internal/testsupport"
+)
+
+func TestParseTokenAmount(t *testing.T) {
+	cases := []struct {
+		in      string
+		want    int64
+		wantErr bool
+	}{
+		{"0.001", 1000, false},
+		{"1", 1000000, false},
+		{"1.5", 1500000, false},
+		{".25", 250000, false},
+		{"0.000001", 1, false},
+		{"0.0000001", 0, true},
+		{"-1", 0, true},
+		{"1e-3", 0, true},
+		{"", 0, true},
+	}
+	for _, tc := range cases {
+		got, err := parseTokenAmount(tc.in)
+		if (err != nil) != tc.wantErr {
+			t.Errorf("parseTokenAmount(%q) error = %v, wantErr %v", tc.in, err, tc.wantErr)
+			continue
+		}
+		if got != tc.want {
+			t.Errorf("parseTokenAmount(%q) = %d, want %d", tc.in, got, tc.want)
+		}
+	}
+}
+
+func TestFormatTokenAmount(t *testing.T) {
+	cases := map[int64]string{
+		0:       "0",
+		1:       "0.000001",
+		1000:    "0.001",
+		1500000: "1.5",
+		2000000: "2",
+		-1500:   "-0.0015",
+	}
+	for in, want := range cases {
+		if got := formatTokenAmount(in); got != want {
+			t.Errorf("formatTokenAmount(%d) = %q, want %q", in, got, want)
+		}
+	}
+}
+
+func TestPaymentAmount_Canonical(t *testing.T) {
+	t.Setenv("PAYMENT_AMOUNT", "0.00100")
+	h := testsupport.NewHarness(t, newTestRouter)
+
+	if got := getPaymentAmount(); got != "0.001" {
+		t.Errorf("expected PAYMENT_AMOUNT in canonical form, got %q", got)
+	}
+	receipt := decodeReceiptHeader(t, paidReceipt(t, h, "nonce-canonical-amount"))
+	if receipt.Receipt.Payment.Amount != "0.001" {
+		t.Errorf("expected the canonical amount in the receipt, got %q", receipt.Receipt.Payment.Amount)
+	}
+	if ctx := h.Verifier.Requests()[0].Context; ctx.Amount != "0.001" {
+		t.Errorf("expected the canonical amount in the signed context, got %q", ctx.Amount)
+	}
+}
+
+func TestPaymentAmount_Validated(t *testing.T) {
+	for _, amount := range []string{"-0.001", "0", "0.0000001", "1e-3", "5000000"} {
+		t.Setenv("PAYMENT_AMOUNT", amount)
+		if err := loadConfig().Validate(); err == nil {
+			t.Errorf("expected PAYMENT_AMOUNT=%q to be rejected", amount)
+		}
+	}
+}
@@ -0,0 +1,327 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ErrorCode is the stable, machine-readable identifier of an error
+// response. Codes are never renamed or reused; the human-readable "error"
+// title and message may change, so clients should branch on the code.
+type ErrorCode string
+
+// Error codes. New codes are added to errorCatalog too.
+const (
+	CodeInvalidRequest     ErrorCode = "INVALID_REQUEST"
+	CodeInvalidBody        ErrorCode = "INVALID_BODY"
+	CodePayloadTooLarge    ErrorCode = "PAYLOAD_TOO_LARGE"
+	CodeBodyReadFailed     ErrorCode = "BODY_READ_FAILED"
+	CodeUnsupportedMedia   ErrorCode = "UNSUPPORTED_MEDIA_TYPE"
+	CodeUnauthorized       ErrorCode = "UNAUTHORIZED"
+	CodeForbidden          ErrorCode = "FORBIDDEN"
+	CodeNotFound           ErrorCode = "NOT_FOUND"
+	CodeMethodNotAllowed   ErrorCode = "METHOD_NOT_ALLOWED"
+	CodeRateLimited        ErrorCode = "RATE_LIMITED"
+	CodeRequestTimeout     ErrorCode = "REQUEST_TIMEOUT"
+	CodeServiceUnavailable ErrorCode = "SERVICE_UNAVAILABLE"
+	CodeResponseTooLarge   ErrorCode = "RESPONSE_TOO_LARGE"
+	CodeOverloaded         ErrorCode = "OVERLOADED"
+	CodeMaintenance        ErrorCode = "MAINTENANCE"
+	CodeTemporarilyBanned  ErrorCode = "TEMPORARILY_BANNED"
+	CodeConcurrencyLimited ErrorCode = "CONCURRENCY_LIMITED"
+	CodeInternal           ErrorCode = "INTERNAL_ERROR"
+
+	CodePaymentRequired          ErrorCode = "PAYMENT_REQUIRED"
+	CodeSignatureInvalid         ErrorCode = "SIGNATURE_INVALID"
+	CodeSignatureMalformed       ErrorCode = "SIGNATURE_MALFORMED"
+	CodeSignerMismatch           ErrorCode = "SIGNER_MISMATCH"
+	CodePaymentWrongRecipient    ErrorCode = "PAYMENT_WRONG_RECIPIENT"
+	CodePaymentWrongAmount       ErrorCode = "PAYMENT_WRONG_AMOUNT"
+	CodePaymentWrongChain        ErrorCode = "PAYMENT_WRONG_CHAIN"
+	CodePaymentContextExpired    ErrorCode = "PAYMENT_CONTEXT_EXPIRED"
+	CodeSignatureTypeUnsupported ErrorCode = "SIGNATURE_TYPE_UNSUPPORTED"
+	CodeNonceReplayed            ErrorCode = "NONCE_REPLAYED"
+	CodeChainUnsupported         ErrorCode = "CHAIN_UNSUPPORTED"
+	CodeQuoteRequired            ErrorCode = "QUOTE_REQUIRED"
+	CodeQuoteInvalid             ErrorCode = "QUOTE_INVALID"
+	CodeQuoteExpired             ErrorCode = "QUOTE_EXPIRED"
+	CodeQuoteMismatch            ErrorCode = "QUOTE_MISMATCH"
+	CodeQuoteUnavailable         ErrorCode = "QUOTE_UNAVAILABLE"
+	CodeChallengeUnknown         ErrorCode = "CHALLENGE_UNKNOWN"
+	CodeChallengeMismatch        ErrorCode = "CHALLENGE_MISMATCH"
+	CodeVoucherInvalid           ErrorCode = "VOUCHER_INVALID"
+	CodeVoucherInsufficient      ErrorCode = "VOUCHER_INSUFFICIENT"
+	CodeVoucherLookupFailed      ErrorCode = "VOUCHER_LOOKUP_FAILED"
+	CodeBudgetExceeded           ErrorCode = "BUDGET_EXCEEDED"
+	CodeSponsorGrantInvalid      ErrorCode = "SPONSOR_GRANT_INVALID"
+	CodeSponsorCapExceeded       ErrorCode = "SPONSOR_CAP_EXCEEDED"
+	CodeSessionInvalid           ErrorCode = "SESSION_INVALID"
+	CodeVerifierTimeout          ErrorCode = "VERIFIER_TIMEOUT"
+	CodeVerifierFailed           ErrorCode = "VERIFIER_FAILED"
+
+	CodeContentRejected       ErrorCode = "CONTENT_REJECTED"
+	CodeContextLengthExceeded ErrorCode = "CONTEXT_LENGTH_EXCEEDED"
+	CodeExtractionFailed      ErrorCode = "EXTRACTION_FAILED"
+	CodeSourceFetchFailed     ErrorCode = "SOURCE_FETCH_FAILED"
+	CodeAITimeout             ErrorCode = "AI_TIMEOUT"
+	CodeAIFailed              ErrorCode = "AI_FAILED"
+	CodeAIUnavailable         ErrorCode = "AI_UNAVAILABLE"
+	CodeUpstreamTimeout       ErrorCode = "UPSTREAM_TIMEOUT"
+	CodeUpstreamFailed        ErrorCode = "UPSTREAM_FAILED"
+
+	CodeReceiptNotFound   ErrorCode = "RECEIPT_NOT_FOUND"
+	CodeReceiptFailed     ErrorCode = "RECEIPT_FAILED"
+	CodeSessionNotFound   ErrorCode = "SESSION_NOT_FOUND"
+	CodeProofNotAvailable ErrorCode = "PROOF_NOT_AVAILABLE"
+	CodePayloadsNotFound  ErrorCode = "PAYLOADS_NOT_FOUND"
+
+	CodeReceiptVersionUnsupported ErrorCode = "RECEIPT_VERSION_UNSUPPORTED"
+)
+
+// errorSpec is the registry entry of a code: the status it is sent with,
+// its "error" title and what it means.
+type errorSpec struct {
+	Code        ErrorCode `json:"code"`
+	Status      int       `json:"status"`
+	Title       string    `json:"title"`
+	Description string    `json:"description"`
+}
+
+// errorCatalog registers every code the gateway emits. Titles are the ones
+// the "error" field carried before codes were introduced.
+var errorCatalog = map[ErrorCode]errorSpec{
+	CodeInvalidRequest:     {Status: 400, Title: "Invalid request", Description: "A parameter or field is missing or invalid; the message says which."},
+	CodeInvalidBody:        {Status: 400, Title: "Invalid request body", Description: "The request body is not valid JSON of the expected shape."},
+	CodePayloadTooLarge:    {Status: 413, Title: "Payload too large", Description: "The request body exceeds the 10MB limit."},
+	CodeBodyReadFailed:     {Status: 500, Title: "Failed to read request body", Description: "The request body could not be read."},
+	CodeUnsupportedMedia:   {Status: 415, Title: "Unsupported Media Type", Description: "The body or fetched document is not JSON, plain text, HTML or PDF."},
+	CodeUnauthorized:       {Status: 401, Title: "Unauthorized", Description: "Valid credentials are required."},
+	CodeForbidden:          {Status: 403, Title: "Forbidden", Description: "The client's network is not allowed to use the gateway."},
+	CodeNotFound:           {Status: 404, Title: "Not Found", Description: "The resource does not exist or has expired."},
+	CodeMethodNotAllowed:   {Status: 405, Title: "Method Not Allowed", Description: "The route does not accept this method; the Allow header lists the ones it does."},
+	CodeRateLimited:        {Status: 429, Title: "Too Many Requests", Description: "The rate limit was exceeded; retry after retry_after seconds."},
+	CodeRequestTimeout:     {Status: 504, Title: "Gateway Timeout", Description: "The request exceeded the gateway's maximum request time."},
+	CodeServiceUnavailable: {Status: 503, Title: "Service Unavailable", Description: "A dependency the request needs is unavailable or not configured."},
+	CodeResponseTooLarge:   {Status: 500, Title: "Response Too Large", Description: "The response outgrew the gateway's response buffer and was discarded."},
+	CodeOverloaded:         {Status: 503, Title: "Service Overloaded", Description: "The gateway is shedding load; retry after the Retry-After header."},
+	CodeMaintenance:        {Status: 503, Title: "Maintenance", Description: "The gateway is in maintenance mode; retry after the Retry-After header."},
+	CodeTemporarilyBanned:  {Status: 429, Title: "Temporarily Banned", Description: "The client sent too many failed requests and is banned until banned_until; retry after retry_after seconds."},
+	CodeConcurrencyLimited: {Status: 429, Title: "Too Many Concurrent Requests", Description: "The paying wallet already has the maximum number of requests in flight; retry once one finishes."},
+	CodeInternal:           {Status: 500, Title: "Internal Server Error", Description: "An unexpected error occurred."},
+
+	CodePaymentRequired:          {Status: 402, Title: "Payment Required", Description: "The request must be paid; sign one of the offered payment contexts."},
+	CodeSignatureInvalid:         {Status: 403, Title: "Invalid Signature", Description: "The payment signature does not verify for the payment context."},
+	CodeSignatureMalformed:       {Status: 400, Title: "Malformed Signature", Description: "The payment signature is not a well-formed hex signature."},
+	CodeSignerMismatch:           {Status: 403, Title: "Signer Mismatch", Description: "The payment signature verifies, but not for the X-402-Payer address."},
+	CodePaymentWrongRecipient:    {Status: 403, Title: "Wrong Recipient", Description: "The payment was signed for a recipient other than the payment context's."},
+	CodePaymentWrongAmount:       {Status: 403, Title: "Wrong Amount", Description: "The payment was signed for an amount other than the request's price."},
+	CodePaymentWrongChain:        {Status: 403, Title: "Wrong Chain", Description: "The payment was signed for a chain other than the negotiated one."},
+	CodePaymentContextExpired:    {Status: 402, Title: "Payment Context Expired", Description: "The signed payment context has expired; sign a new one."},
+	CodeSignatureTypeUnsupported: {Status: 400, Title: "Unsupported Signature Type", Description: "X-402-Signature-Type names a scheme the gateway does not accept."},
+	CodeNonceReplayed:            {Status: 409, Title: "Nonce Replayed", Description: "The payment nonce was already used; sign a new payment context."},
+	CodeChainUnsupported:         {Status: 402, Title: "Unsupported Chain", Description: "X-402-Chain-Id names a chain payment is not accepted on."},
+	CodeQuoteRequired:            {Status: 402, Title: "Quote Required", Description: "The signed price quote headers are required."},
+	CodeQuoteInvalid:             {Status: 402, Title: "Invalid Quote", Description: "The quote headers are malformed."},
+	CodeQuoteExpired:             {Status: 402, Title: "Quote Expired", Description: "The price quote has expired; sign the new payment context."},
+	CodeQuoteMismatch:            {Status: 402, Title: "Quote Mismatch", Description: "The signed quote does not match this request's price, nonce, chain or recipient."},
+	CodeQuoteUnavailable:         {Status: 500, Title: "Quote verification unavailable", Description: "The gateway cannot verify quotes because its signing key is not configured."},
+	CodeChallengeUnknown:         {Status: 402, Title: "Unknown Challenge", Description: "The nonce was not issued by the gateway, has expired or was already paid."},
+	CodeChallengeMismatch:        {Status: 402, Title: "Challenge Mismatch", Description: "The challenge was issued for another endpoint or a different price than the request costs."},
+	CodeVoucherInvalid:           {Status: 403, Title: "Invalid Voucher", Description: "The refund voucher is unknown, expired, spent or not signed by its payer."},
+	CodeVoucherInsufficient:      {Status: 402, Title: "Voucher Insufficient", Description: "The refund voucher covers less than the request costs."},
+	CodeVoucherLookupFailed:      {Status: 500, Title: "Voucher Lookup Failed", Description: "The refund voucher could not be loaded or redeemed."},
+	CodeBudgetExceeded:           {Status: 402, Title: "Budget Exceeded", Description: "The wallet's spending cap for the window has been reached."},
+	CodeSponsorGrantInvalid:      {Status: 403, Title: "Invalid Sponsor Grant", Description: "The sponsor grant is malformed, expired, for another chain, not signed by its sponsor or does not cover the payer."},
+	CodeSponsorCapExceeded:       {Status: 402, Title: "Sponsor Cap Exceeded", Description: "The request costs more than the sponsor grant allows per payment, or its total cap has been reached."},
+	CodeSessionInvalid:           {Status: 400, Title: "Invalid Session", Description: "X-402-Session is not a valid session ID."},
+	CodeVerifierTimeout:          {Status: 504, Title: "Gateway Timeout", Description: "The payment verifier did not answer in time."},
+	CodeVerifierFailed:           {Status: 500, Title: "Verification Service Failed", Description: "The payment verifier could not be reached or failed."},
+
+	CodeContentRejected:       {Status: 422, Title: "Content Rejected", Description: "The input violates the content policy; no payment was taken."},
+	CodeContextLengthExceeded: {Status: 413, Title: "Context Length Exceeded", Description: "The input does not fit the model's context window; no payment was taken."},
+	CodeExtractionFailed:      {Status: 422, Title: "Extraction Failed", Description: "No usable text could be extracted from the PDF or HTML document, or it exceeds the extracted text limit; no payment was taken."},
+	CodeSourceFetchFailed:     {Status: 502, Title: "Source Fetch Failed", Description: "The document at url could not be fetched; no payment was taken."},
+	CodeAITimeout:             {Status: 504, Title: "Gateway Timeout", Description: "The AI provider did not answer in time; a refund voucher may be attached."},
+	CodeAIFailed:              {Status: 500, Title: "AI Service Failed", Description: "The AI provider request failed; a refund voucher may be attached."},
+	CodeAIUnavailable:         {Status: 503, Title: "AI Provider Unavailable", Description: "The AI provider circuit is open; retry after the Retry-After header."},
+	CodeUpstreamTimeout:       {Status: 504, Title: "Gateway Timeout", Description: "A registered endpoint's upstream did not answer in time; a refund voucher may be attached."},
+	CodeUpstreamFailed:        {Status: 500, Title: "Service Failed", Description: "A registered endpoint's upstream failed; a refund voucher may be attached."},
+
+	CodeReceiptNotFound:   {Status: 404, Title: "Receipt not found", Description: "The receipt may have expired or never existed."},
+	CodeReceiptFailed:     {Status: 500, Title: "Receipt Failed", Description: "The receipt could not be generated, stored or encoded."},
+	CodeSessionNotFound:   {Status: 404, Title: "Session not found", Description: "The session may have expired or never existed."},
+	CodeProofNotAvailable: {Status: 404, Title: "Proof not available", Description: "The receipt is not in a published transparency root yet, transparency is disabled, or the receipt never existed."},
+	CodePayloadsNotFound:  {Status: 404, Title: "Payloads not found", Description: "No payloads are retained for the receipt: retention is disabled, the policy excluded it, its period has passed or the receipt never existed."},
+
+	CodeReceiptVersionUnsupported: {Status: 406, Title: "Receipt Version Unsupported", Description: "None of the receipt schema versions in X-402-Receipt-Version is supported; supported_versions lists them."},
+}
+
+// APIError is the body of every error response. Fields holds extra
+// top-level members, such as the payment contexts of a 402 or retry_after
+// of a 429, which are written alongside the standard ones.
+type APIError struct {
+	Code          ErrorCode `json:"code"`
+	Title         string    `json:"error"`
+	Message       string    `json:"message,omitempty"`
+	Details       any       `json:"details,omitempty"`
+	CorrelationID string    `json:"correlation_id,omitempty"`
+	DocsURL       string    `json:"docs_url,omitempty"`
+	Fields        gin.H     `json:"-"`
+
+	// format and args are the message's format and operands when built with
+	// newAPIErrorf, so the format can be translated.
+	format string
+	args   []any
+}
+
+// newAPIError returns the error for code with message.
+func newAPIError(code ErrorCode, message string) *APIError {
+	return &APIError{Code: code, Title: errorCatalog[code].Title, Message: message}
+}
+
+// newAPIErrorf returns the error for code with a formatted message.
+func newAPIErrorf(code ErrorCode, format string, args ...any) *APIError {
+	e := newAPIError(code, fmt.Sprintf(format, args...))
+	e.format, e.args = format, args
+	return e
+}
+
+// withDetails sets the error's free-form diagnostic details.
+func (e *APIError) withDetails(details any) *APIError {
+	e.Details = details
+	return e
+}
+
+// with adds fields to the top level of the error body.
+func (e *APIError) with(fields gin.H) *APIError {
+	if e.Fields == nil {
+		e.Fields = make(gin.H, len(fields))
+	}
+	for k, v := range fields {
+		e.Fields[k] = v
+	}
+	return e
+}
+
+// Status returns the HTTP status the error is sent with.
+func (e *APIError) Status() int {
+	if spec, ok := errorCatalog[e.Code]; ok {
+		return spec.Status
+	}
+	return 500
+}
+
+// MarshalJSON writes Fields next to the standard members. The standard
+// members win if a field has the same name.
+func (e *APIError) MarshalJSON() ([]byte, error) {
+	type plain APIError
+	data, err := json.Marshal((*plain)(e))
+	if err != nil || len(e.Fields) == 0 {
+		return data, err
+	}
+	body := make(map[string]any, len(e.Fields)+6)
+	for k, v := range e.Fields {
+		body[k] = v
+	}
+	var standard map[string]any
+	if err := json.Unmarshal(data, &standard); err != nil {
+		return nil, err
+	}
+	for k, v := range standard {
+		body[k] = v
+	}
+	return json.Marshal(body)
+}
+
+// errPayloadTooLarge is the error for a body over the 10MB limit.
+func errPayloadTooLarge() *APIError {
+	return newAPIError(CodePayloadTooLarge, "Request body exceeds the 10MB limit").with(gin.H{"max_size": "10MB"})
+}
+
+// forRequest fills in the request's correlation ID and the code's docs URL,
+// and translates the title and message into the client's Accept-Language.
+func (e *APIError) forRequest(c *gin.Context) *APIError {
+	e.CorrelationID = c.GetString("correlation_id")
+	e.DocsURL = errorDocsURL(c, e.Code)
+	if lang := errorLanguage(c); lang != "" && e.localize(lang) {
+		c.Header("Content-Language", lang)
+	}
+	if c.Request != nil && c.GetHeader("Accept-Language") != "" {
+		c.Writer.Header().Add("Vary", "Accept-Language")
+	}
+	return e
+}
+
+// errorDocsURL links code's documentation: ERROR_DOCS_URL with the code
+// appended when set, otherwise the gateway's own catalog entry.
+func errorDocsURL(c *gin.Context, code ErrorCode) string {
+	if base := os.Getenv("ERROR_DOCS_URL"); base != "" {
+		return base + string(code)
+	}
+	if c.Request == nil {
+		return "/api/errors/" + string(code)
+	}
+	return publicBaseURL(c) + "/api/errors/" + string(code)
+}
+
+// respondError writes an error response for code with message.
+func respondError(c *gin.Context, code ErrorCode, message string) {
+	respondAPIError(c, newAPIError(code, message))
+}
+
+// abortWithError aborts the chain with an error response for code.
+func abortWithError(c *gin.Context, code ErrorCode, message string) {
+	abortWithAPIError(c, newAPIError(code, message))
+}
+
+// respondAPIError writes e with its registered status.
+func respondAPIError(c *gin.Context, e *APIError) {
+	c.Set(errorCodeKey, e.Code)
+	c.JSON(e.Status(), e.forRequest(c))
+}
+
+// abortWithAPIError aborts the chain and writes e with its registered status.
+func abortWithAPIError(c *gin.Context, e *APIError) {
+	c.Set(errorCodeKey, e.Code)
+	c.AbortWithStatusJSON(e.Status(), e.forRequest(c))
+}
+
+// errorSpecs returns the catalog sorted by code, with titles in lang where
+// they are translated.
+func errorSpecs(lang string) []errorSpec {
+	specs := make([]errorSpec, 0, len(errorCatalog))
+	for code, spec := range errorCatalog {
+		spec.Code = code
+		if title, ok := errorTranslations[lang].titles[code]; ok {
+			spec.Title = title
+		}
+		specs = append(specs, spec)
+	}
+	sort.Slice(specs, func(i, j int) bool { return specs[i].Code < specs[j].Code })
+	return specs
+}
+
+// handleListErrors handles GET /api/errors, the error code registry.
+func handleListErrors(c *gin.Context) {
+	c.JSON(200, gin.H{"errors": errorSpecs(errorLanguage(c))})
+}
+
+// handleGetError handles GET /api/errors/:code.
+func handleGetError(c *gin.Context) {
+	code := ErrorCode(strings.ToUpper(c.Param("code")))
+	spec, ok := errorCatalog[code]
+	if !ok {
+		respondError(c, CodeNotFound, "Unknown error code")
+		return
+	}
+	spec.Code = code
+	if title, ok := errorTranslations[errorLanguage(c)].titles[code]; ok {
+		spec.Title = title
+	}
+	c.JSON(200, spec)
+}
@@ -0,0 +1,122 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"regexp"
+	"strings"
+	"testing"
+
+	"gateway/internal/testsupport"
+
+	"github.com/gin-gonic/gin"
+)
+
+// errorBody is the standard part of an error response.
+type errorBody struct {
+	Code          ErrorCode `json:"code"`
+	Error         string    `json:"error"`
+	Message       string    `json:"message"`
+	Details       any       `json:"details"`
+	CorrelationID string    `json:"correlation_id"`
+	DocsURL       string    `json:"docs_url"`
+}
+
+func decodeErrorBody(t *testing.T, resp *http.Response) errorBody {
+	t.Helper()
+	var body errorBody
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		t.Fatal(err)
+	}
+	return body
+}
+
+func TestErrorCatalog_Entries(t *testing.T) {
+	codePattern := regexp.MustCompile(`^[A-Z][A-Z0-9_]+$`)
+	for code, spec := range errorCatalog {
+		if !codePattern.MatchString(string(code)) {
+			t.Errorf("%s: codes must be upper snake case", code)
+		}
+		if spec.Status < 400 || spec.Status > 599 || spec.Title == "" || spec.Description == "" {
+			t.Errorf("%s: incomplete entry %+v", code, spec)
+		}
+	}
+	for _, code := range []ErrorCode{CodePaymentRequired, CodeSignatureInvalid, CodeNonceReplayed, CodeAITimeout} {
+		if _, ok := errorCatalog[code]; !ok {
+			t.Errorf("%s is not registered", code)
+		}
+	}
+}
+
+func TestAPIError_MarshalJSON(t *testing.T) {
+	e := newAPIError(CodeRateLimited, "slow down").with(gin.H{"retry_after": 3, "code": "OVERRIDDEN"})
+	data, err := json.Marshal(e)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var body map[string]any
+	json.Unmarshal(data, &body)
+	if body["code"] != "RATE_LIMITED" || body["error"] != "Too Many Requests" || body["message"] != "slow down" || body["retry_after"] != float64(3) {
+		t.Errorf("unexpected body %s", data)
+	}
+	if _, ok := body["details"]; ok {
+		t.Errorf("empty details should be omitted, got %s", data)
+	}
+}
+
+func TestAPIError_Responses(t *testing.T) {
+	h := testsupport.NewHarness(t, newTestRouter)
+
+	resp := h.Post(t, "/api/ai/summarize", `{"text":"hello"}`, "", "")
+	body := decodeErrorBody(t, resp)
+	if resp.StatusCode != http.StatusPaymentRequired || body.Code != CodePaymentRequired || body.Error != "Payment Required" {
+		t.Errorf("expected PAYMENT_REQUIRED, got %d %+v", resp.StatusCode, body)
+	}
+	if body.CorrelationID == "" || body.CorrelationID != resp.Header.Get("X-Correlation-ID") {
+		t.Errorf("expected the correlation ID %q, got %q", resp.Header.Get("X-Correlation-ID"), body.CorrelationID)
+	}
+	if !strings.HasSuffix(body.DocsURL, "/api/errors/PAYMENT_REQUIRED") {
+		t.Errorf("unexpected docs URL %q", body.DocsURL)
+	}
+
+	h.Verifier.SetInvalid("signature mismatch")
+	resp = h.Post(t, "/api/ai/summarize", `{"text":"hello"}`, "0xsig", "nonce-apierror")
+	if body := decodeErrorBody(t, resp); resp.StatusCode != http.StatusForbidden || body.Code != CodeSignatureInvalid || body.Details != "signature mismatch" {
+		t.Errorf("expected SIGNATURE_INVALID with details, got %d %+v", resp.StatusCode, body)
+	}
+
+	resp = h.Post(t, "/api/ai/summarize", `not json`, "0xsig", "nonce-apierror")
+	if body := decodeErrorBody(t, resp); resp.StatusCode != http.StatusBadRequest || body.Code != CodeInvalidBody {
+		t.Errorf("expected INVALID_BODY, got %d %+v", resp.StatusCode, body)
+	}
+
+	t.Setenv("ERROR_DOCS_URL", "https://docs.example.com/errors#")
+	resp = h.Get(t, "/api/receipts/rcpt_missing")
+	if body := decodeErrorBody(t, resp); body.Code != CodeReceiptNotFound || body.DocsURL != "https://docs.example.com/errors#RECEIPT_NOT_FOUND" {
+		t.Errorf("expected RECEIPT_NOT_FOUND linking ERROR_DOCS_URL, got %+v", body)
+	}
+}
+
+func TestHandleErrors_Registry(t *testing.T) {
+	h := testsupport.NewHarness(t, newTestRouter)
+
+	var list struct {
+		Errors []errorSpec `json:"errors"`
+	}
+	json.NewDecoder(h.Get(t, "/api/errors").Body).Decode(&list)
+	if len(list.Errors) != len(errorCatalog) {
+		t.Errorf("expected %d codes, got %d", len(errorCatalog), len(list.Errors))
+	}
+
+	var spec errorSpec
+	resp := h.Get(t, "/api/errors/ai_timeout")
+	json.NewDecoder(resp.Body).Decode(&spec)
+	if resp.StatusCode != http.StatusOK || spec.Code != CodeAITimeout || spec.Status != http.StatusGatewayTimeout {
+		t.Errorf("expected the AI_TIMEOUT entry, got %d %+v", resp.StatusCode, spec)
+	}
+
+	resp = h.Get(t, "/api/errors/NO_SUCH_CODE")
+	if body := decodeErrorBody(t, resp); resp.StatusCode != http.StatusNotFound || body.Code != CodeNotFound {
+		t.Errorf("expected 404 NOT_FOUND, got %d %+v", resp.StatusCode, body)
+	}
+}
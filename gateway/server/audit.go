@@ -0,0 +1,479 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"hash"
+	"io"
+	"log"
+	"net/http"
+	"net/netip"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// AuditRecord is one audited AI request. Hashes use the receipt format
+// ("sha256:<hex>") over the body as sent and received, so they can be matched
+// against receipts. RequestText and ResponseText are only filled when
+// AUDIT_LOG_INCLUDE_TEXT is set, and are PII-scrubbed.
+type AuditRecord struct {
+	Time          time.Time `json:"time"`
+	CorrelationID string    `json:"correlation_id,omitempty"`
+	Method        string    `json:"method"`
+	Path          string    `json:"path"`
+	Status        int       `json:"status"`
+	LatencyMs     int64     `json:"latency_ms"`
+	ClientIP      string    `json:"client_ip,omitempty"`
+	UserAgent     string    `json:"user_agent,omitempty"`
+	Payer         string    `json:"payer,omitempty"`
+	Amount        string    `json:"amount,omitempty"`
+	Model         string    `json:"model,omitempty"`
+	ReceiptID     string    `json:"receipt_id,omitempty"`
+	RequestHash   string    `json:"request_hash"`
+	ResponseHash  string    `json:"response_hash"`
+	RequestText   string    `json:"request_text,omitempty"`
+	ResponseText  string    `json:"response_text,omitempty"`
+}
+
+// Redaction actions for AUDIT_LOG_REDACT.
+const (
+	redactDrop      = "drop"      // omit the field
+	redactHash      = "hash"      // replace with its SHA-256
+	redactMask      = "mask"      // keep the first 6 and last 4 characters
+	redactAnonymize = "anonymize" // zero the host part of an IP (/24 or /48)
+)
+
+// parseRedactionRules parses "field:action" pairs, e.g.
+// "client_ip:anonymize,payer:mask,user_agent:drop".
+func parseRedactionRules(entries []string) (map[string]string, error) {
+	rules := make(map[string]string, len(entries))
+	for _, entry := range entries {
+		field, action, ok := strings.Cut(entry, ":")
+		field, action = strings.TrimSpace(field), strings.TrimSpace(action)
+		if !ok || field == "" {
+			return nil, fmt.Errorf("redaction rule %q must be field:action", entry)
+		}
+		switch action {
+		case redactDrop, redactHash, redactMask, redactAnonymize:
+			rules[field] = action
+		default:
+			return nil, fmt.Errorf("redaction rule %q: unknown action %q", entry, action)
+		}
+	}
+	return rules, nil
+}
+
+// redactValue applies action to a string field value.
+func redactValue(action, value string) (string, bool) {
+	switch action {
+	case redactDrop:
+		return "", false
+	case redactHash:
+		sum := sha256.Sum256([]byte(value))
+		return "sha256:" + hex.EncodeToString(sum[:]), true
+	case redactMask:
+		if len(value) <= 10 {
+			return strings.Repeat("*", len(value)), true
+		}
+		return value[:6] + "..." + value[len(value)-4:], true
+	case redactAnonymize:
+		ip, err := netip.ParseAddr(value)
+		if err != nil {
+			return "", false
+		}
+		bits := 24
+		if ip.Unmap().Is6() {
+			bits = 48
+		}
+		p, _ := ip.Unmap().Prefix(bits)
+		return p.Addr().String(), true
+	}
+	return value, true
+}
+
+// piiPatterns scrub common personal data from logged text.
+var piiPatterns = []struct {
+	name string
+	re   *regexp.Regexp
+}{
+	{"private_key", regexp.MustCompile(`\b(?:0x)?[0-9a-fA-F]{64}\b`)},
+	{"email", regexp.MustCompile(`[A-Za-z0-9._%+-]+@[A-Za-z0-9.-]+\.[A-Za-z]{2,}`)},
+	{"card", regexp.MustCompile(`\b(?:\d[ -]?){12,18}\d\b`)},
+	{"phone", regexp.MustCompile(`\+?\d[\d ().-]{7,}\d`)},
+}
+
+// scrubPII replaces emails, card numbers, phone numbers and private keys in
+// text with [REDACTED:<kind>] markers.
+func scrubPII(text string) string {
+	for _, p := range piiPatterns {
+		text = p.re.ReplaceAllString(text, "[REDACTED:"+p.name+"]")
+	}
+	return text
+}
+
+// auditSink receives batches of encoded records, one JSON object per line.
+type auditSink interface {
+	Write(lines []byte) error
+	Close() error
+}
+
+// rotatingFile is an append-only file rotated to path.1, path.2, ... once it
+// would grow past maxSize, keeping maxFiles old files.
+type rotatingFile struct {
+	path     string
+	maxSize  int64
+	maxFiles int
+	f        *os.File
+	size     int64
+}
+
+func openRotatingFile(path string, maxSize int64, maxFiles int) (*rotatingFile, error) {
+	r := &rotatingFile{path: path, maxSize: maxSize, maxFiles: maxFiles}
+	if err := r.open(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+func (r *rotatingFile) open() error {
+	f, err := os.OpenFile(r.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o600)
+	if err != nil {
+		return err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	r.f, r.size = f, info.Size()
+	return nil
+}
+
+func (r *rotatingFile) Write(lines []byte) error {
+	if r.size > 0 && r.size+int64(len(lines)) > r.maxSize {
+		if err := r.rotate(); err != nil {
+			return err
+		}
+	}
+	n, err := r.f.Write(lines)
+	r.size += int64(n)
+	return err
+}
+
+func (r *rotatingFile) rotate() error {
+	if err := r.f.Close(); err != nil {
+		return err
+	}
+	for i := r.maxFiles - 1; i >= 1; i-- {
+		_ = os.Rename(fmt.Sprintf("%s.%d", r.path, i), fmt.Sprintf("%s.%d", r.path, i+1))
+	}
+	if r.maxFiles > 0 {
+		_ = os.Rename(r.path, r.path+".1")
+	} else {
+		_ = os.Remove(r.path)
+	}
+	_ = os.Remove(fmt.Sprintf("%s.%d", r.path, r.maxFiles+1))
+	return r.open()
+}
+
+func (r *rotatingFile) Close() error { return r.f.Close() }
+
+// httpAuditSink POSTs each batch as NDJSON to an external collector.
+type httpAuditSink struct {
+	url   string
+	token string
+}
+
+func (s *httpAuditSink) Write(lines []byte) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.url, bytes.NewReader(lines))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-ndjson")
+	if s.token != "" {
+		req.Header.Set("Authorization", "Bearer "+s.token)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("audit sink returned status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+func (s *httpAuditSink) Close() error { return nil }
+
+// auditLogger queues records and writes them to its sinks in batches from a
+// single goroutine, so request latency never depends on the sink. Records
+// are dropped (and counted) when the queue is full.
+type auditLogger struct {
+	sinks       []auditSink
+	rules       map[string]string
+	includeText bool
+	records     chan AuditRecord
+	done        chan struct{}
+	dropped     atomic.Int64
+	closeOnce   sync.Once
+}
+
+// activeAudit is the running audit logger; nil when auditing is disabled.
+var activeAudit atomic.Pointer[auditLogger]
+
+// newAuditLogger builds a logger from AUDIT_LOG_* variables. It returns nil
+// when neither AUDIT_LOG_FILE nor AUDIT_LOG_URL is set.
+func newAuditLogger() (*auditLogger, error) {
+	rules, err := parseRedactionRules(getEnvAsList("AUDIT_LOG_REDACT", []string{"client_ip:anonymize"}))
+	if err != nil {
+		return nil, fmt.Errorf("AUDIT_LOG_REDACT: %w", err)
+	}
+	var sinks []auditSink
+	if path := os.Getenv("AUDIT_LOG_FILE"); path != "" {
+		maxSize := int64(max(getEnvAsInt("AUDIT_LOG_MAX_SIZE_MB", 100), 1)) * 1024 * 1024
+		f, err := openRotatingFile(path, maxSize, max(getEnvAsInt("AUDIT_LOG_MAX_FILES", 5), 0))
+		if err != nil {
+			return nil, fmt.Errorf("AUDIT_LOG_FILE: %w", err)
+		}
+		sinks = append(sinks, f)
+	}
+	if url := os.Getenv("AUDIT_LOG_URL"); url != "" {
+		sinks = append(sinks, &httpAuditSink{url: url, token: os.Getenv("AUDIT_LOG_URL_TOKEN")})
+	}
+	if len(sinks) == 0 {
+		return nil, nil
+	}
+	return &auditLogger{
+		sinks:       sinks,
+		rules:       rules,
+		includeText: getEnvAsBool("AUDIT_LOG_INCLUDE_TEXT", false),
+		records:     make(chan AuditRecord, max(getEnvAsInt("AUDIT_LOG_BUFFER", 1000), 1)),
+		done:        make(chan struct{}),
+	}, nil
+}
+
+// Start launches the writer goroutine.
+func (a *auditLogger) Start() {
+	go a.run()
+}
+
+// Log queues a record without blocking.
+func (a *auditLogger) Log(rec AuditRecord) {
+	select {
+	case a.records <- rec:
+	default:
+		if a.dropped.Add(1)%100 == 1 {
+			log.Printf("[WARNING] Audit log queue full, %d records dropped so far", a.dropped.Load())
+		}
+	}
+}
+
+// Close flushes queued records and closes the sinks, giving up when ctx
+// expires.
+func (a *auditLogger) Close(ctx context.Context) error {
+	a.closeOnce.Do(func() { close(a.records) })
+	select {
+	case <-a.done:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	var firstErr error
+	for _, s := range a.sinks {
+		if err := s.Close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+func (a *auditLogger) run() {
+	defer close(a.done)
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	var batch bytes.Buffer
+	count := 0
+	flush := func() {
+		if count == 0 {
+			return
+		}
+		for _, s := range a.sinks {
+			if err := s.Write(batch.Bytes()); err != nil {
+				log.Printf("[WARNING] Failed to write %d audit records: %v", count, err)
+			}
+		}
+		batch.Reset()
+		count = 0
+	}
+	for {
+		select {
+		case rec, ok := <-a.records:
+			if !ok {
+				flush()
+				return
+			}
+			if line, err := a.encode(rec); err == nil {
+				batch.Write(line)
+				batch.WriteByte('\n')
+				count++
+			}
+			if count >= 100 {
+				flush()
+			}
+		case <-ticker.C:
+			flush()
+		}
+	}
+}
+
+// encode applies the redaction rules and renders rec as one JSON line.
+func (a *auditLogger) encode(rec AuditRecord) ([]byte, error) {
+	if !a.includeText {
+		rec.RequestText, rec.ResponseText = "", ""
+	}
+	if len(a.rules) == 0 {
+		return json.Marshal(rec)
+	}
+	raw, err := json.Marshal(rec)
+	if err != nil {
+		return nil, err
+	}
+	var fields map[string]interface{}
+	if err := json.Unmarshal(raw, &fields); err != nil {
+		return nil, err
+	}
+	for field, action := range a.rules {
+		v, ok := fields[field]
+		if !ok {
+			continue
+		}
+		s, isString := v.(string)
+		if !isString {
+			s = fmt.Sprint(v)
+		}
+		if redacted, keep := redactValue(action, s); keep {
+			fields[field] = redacted
+		} else {
+			delete(fields, field)
+		}
+	}
+	return json.Marshal(fields)
+}
+
+// auditWriter hashes the response body as it is written, keeping a copy only
+// when raw text is logged.
+type auditWriter struct {
+	gin.ResponseWriter
+	hash hash.Hash
+	body *bytes.Buffer
+}
+
+func (w *auditWriter) Write(data []byte) (int, error) {
+	w.hash.Write(data)
+	if w.body != nil {
+		w.body.Write(data)
+	}
+	return w.ResponseWriter.Write(data)
+}
+
+func (w *auditWriter) WriteString(s string) (int, error) {
+	return w.Write([]byte(s))
+}
+
+// auditReader hashes the request body as the handlers read it. It is
+// locked because a handler that outlived its timeout may still be reading.
+type auditReader struct {
+	io.ReadCloser
+	mu   sync.Mutex
+	hash hash.Hash
+	body *bytes.Buffer
+}
+
+func (r *auditReader) Read(p []byte) (int, error) {
+	n, err := r.ReadCloser.Read(p)
+	r.mu.Lock()
+	r.hash.Write(p[:n])
+	if r.body != nil {
+		r.body.Write(p[:n])
+	}
+	r.mu.Unlock()
+	return n, err
+}
+
+// result returns the hash and, if kept, the text read so far.
+func (r *auditReader) result() (string, string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	text := ""
+	if r.body != nil {
+		text = r.body.String()
+	}
+	return "sha256:" + hex.EncodeToString(r.hash.Sum(nil)), text
+}
+
+// auditMiddleware records every request on the AI routes to the active
+// audit logger. Payment details come from the receipt, if one was issued.
+func auditMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		logger := activeAudit.Load()
+		if logger == nil {
+			c.Next()
+			return
+		}
+		start := time.Now()
+		method, path := c.Request.Method, c.Request.URL.Path
+		reader := &auditReader{hash: sha256.New()}
+		writer := &auditWriter{ResponseWriter: c.Writer, hash: sha256.New()}
+		if logger.includeText {
+			reader.body, writer.body = &bytes.Buffer{}, &bytes.Buffer{}
+		}
+		if c.Request.Body != nil {
+			reader.ReadCloser = c.Request.Body
+			c.Request.Body = reader
+		}
+		c.Writer = writer
+
+		c.Next()
+
+		requestHash, requestText := reader.result()
+		rec := AuditRecord{
+			Time:          start.UTC(),
+			CorrelationID: c.GetString("correlation_id"),
+			Method:        method,
+			Path:          path,
+			Status:        writer.Status(),
+			LatencyMs:     time.Since(start).Milliseconds(),
+			ClientIP:      c.ClientIP(),
+			UserAgent:     c.Request.UserAgent(),
+			RequestHash:   requestHash,
+			ResponseHash:  "sha256:" + hex.EncodeToString(writer.hash.Sum(nil)),
+		}
+		if v, ok := c.Get("issued_receipt"); ok {
+			receipt := v.(*SignedReceipt)
+			rec.Payer = receipt.Receipt.Payment.Payer
+			rec.Amount = receipt.Receipt.Payment.Amount
+			rec.Model = receipt.Receipt.Service.Model
+			rec.ReceiptID = receipt.Receipt.ID
+		} else if v, ok := c.Get("model_selection"); ok {
+			rec.Model = v.(ModelSelection).Model
+		}
+		if logger.includeText {
+			rec.RequestText = scrubPII(requestText)
+			rec.ResponseText = scrubPII(writer.body.String())
+		}
+		logger.Log(rec)
+	}
+}
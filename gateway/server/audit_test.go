@@ -0,0 +1,201 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"gateway/internal/testsupport"
+)
+
+// memorySink collects audit lines for assertions.
+type memorySink struct {
+	mu    sync.Mutex
+	lines []map[string]interface{}
+}
+
+func (s *memorySink) Write(lines []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	scanner := bufio.NewScanner(bytes.NewReader(lines))
+	for scanner.Scan() {
+		var rec map[string]interface{}
+		if err := json.Unmarshal(scanner.Bytes(), &rec); err != nil {
+			return err
+		}
+		s.lines = append(s.lines, rec)
+	}
+	return nil
+}
+
+func (s *memorySink) Close() error { return nil }
+
+// withAuditLogger installs an audit logger writing to a memorySink. Call the
+// returned flush before reading the sink.
+func withAuditLogger(t *testing.T, rules map[string]string, includeText bool) (*memorySink, func()) {
+	t.Helper()
+	sink := &memorySink{}
+	logger := &auditLogger{
+		sinks:       []auditSink{sink},
+		rules:       rules,
+		includeText: includeText,
+		records:     make(chan AuditRecord, 10),
+		done:        make(chan struct{}),
+	}
+	logger.Start()
+	activeAudit.Store(logger)
+	flush := func() {
+		activeAudit.CompareAndSwap(logger, nil)
+		if err := logger.Close(context.Background()); err != nil {
+			t.Fatalf("close audit logger: %v", err)
+		}
+	}
+	t.Cleanup(flush)
+	return sink, flush
+}
+
+func TestAuditMiddleware_RecordsHashesNotText(t *testing.T) {
+	sink, flush := withAuditLogger(t, map[string]string{"client_ip": redactAnonymize}, false)
+	h := testsupport.NewHarness(t, newTestRouter)
+
+	body := `{"text":"mail me at jane@example.com"}`
+	resp := h.Post(t, "/api/ai/summarize", body, "0xsig", "nonce")
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected 200, got %d", resp.StatusCode)
+	}
+	receipt := decodeReceiptHeader(t, resp.Header.Get("X-402-Receipt"))
+	flush()
+
+	if len(sink.lines) != 1 {
+		t.Fatalf("expected 1 audit record, got %d", len(sink.lines))
+	}
+	rec := sink.lines[0]
+	if rec["request_hash"] != receipt.Receipt.Service.RequestHash || rec["response_hash"] != receipt.Receipt.Service.ResponseHash {
+		t.Errorf("audit hashes should match the receipt, got %v / %v", rec["request_hash"], rec["response_hash"])
+	}
+	if rec["receipt_id"] != receipt.Receipt.ID || rec["payer"] != receipt.Receipt.Payment.Payer || rec["amount"] != receipt.Receipt.Payment.Amount {
+		t.Errorf("payment details missing from audit record: %v", rec)
+	}
+	if rec["path"] != "/api/ai/summarize" || rec["status"] != float64(200) {
+		t.Errorf("unexpected request metadata: %v", rec)
+	}
+	if _, ok := rec["request_text"]; ok {
+		t.Error("raw text must not be logged by default")
+	}
+	if ip, _ := rec["client_ip"].(string); !strings.HasSuffix(ip, ".0") {
+		t.Errorf("client_ip should be anonymized, got %q", ip)
+	}
+}
+
+func TestAuditMiddleware_IncludeTextScrubsPII(t *testing.T) {
+	sink, flush := withAuditLogger(t, map[string]string{"payer": redactMask, "user_agent": redactDrop}, true)
+	h := testsupport.NewHarness(t, newTestRouter)
+
+	resp := h.Post(t, "/api/ai/summarize", `{"text":"call +1 (555) 123-4567 or jane@example.com"}`, "0xsig", "nonce")
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected 200, got %d", resp.StatusCode)
+	}
+	flush()
+
+	rec := sink.lines[0]
+	text, _ := rec["request_text"].(string)
+	if strings.Contains(text, "jane@example.com") || strings.Contains(text, "555") {
+		t.Errorf("PII should be scrubbed from request_text: %q", text)
+	}
+	if !strings.Contains(text, "[REDACTED:email]") || !strings.Contains(text, "[REDACTED:phone]") {
+		t.Errorf("expected redaction markers, got %q", text)
+	}
+	if payer, _ := rec["payer"].(string); !strings.Contains(payer, "...") {
+		t.Errorf("payer should be masked, got %q", payer)
+	}
+	if _, ok := rec["user_agent"]; ok {
+		t.Error("user_agent should be dropped")
+	}
+}
+
+func TestAuditMiddleware_RecordsFailedPayments(t *testing.T) {
+	sink, flush := withAuditLogger(t, nil, false)
+	h := testsupport.NewHarness(t, newTestRouter)
+
+	resp := h.Post(t, "/api/ai/summarize", `{"text":"unpaid"}`, "", "")
+	if resp.StatusCode != http.StatusPaymentRequired {
+		t.Fatalf("expected 402, got %d", resp.StatusCode)
+	}
+	flush()
+
+	if len(sink.lines) != 1 || sink.lines[0]["status"] != float64(402) || sink.lines[0]["receipt_id"] != nil {
+		t.Errorf("expected an unpaid 402 record, got %v", sink.lines)
+	}
+}
+
+func TestParseRedactionRules(t *testing.T) {
+	rules, err := parseRedactionRules([]string{"client_ip:anonymize", "payer: hash"})
+	if err != nil || rules["payer"] != redactHash || rules["client_ip"] != redactAnonymize {
+		t.Fatalf("unexpected rules %v, err %v", rules, err)
+	}
+	for _, bad := range []string{"payer", ":drop", "payer:encrypt"} {
+		if _, err := parseRedactionRules([]string{bad}); err == nil {
+			t.Errorf("%q should be rejected", bad)
+		}
+	}
+}
+
+func TestRedactValue(t *testing.T) {
+	tests := []struct{ action, in, want string }{
+		{redactAnonymize, "203.0.113.77", "203.0.113.0"},
+		{redactAnonymize, "2001:db8:1:2::5", "2001:db8:1::"},
+		{redactMask, "0x1234567890abcdef", "0x1234...cdef"},
+		{redactMask, "short", "*****"},
+	}
+	for _, tt := range tests {
+		if got, _ := redactValue(tt.action, tt.in); got != tt.want {
+			t.Errorf("redactValue(%s, %q) = %q, want %q", tt.action, tt.in, got, tt.want)
+		}
+	}
+	if got, _ := redactValue(redactHash, "x"); !strings.HasPrefix(got, "sha256:") {
+		t.Errorf("hash should produce a sha256 value, got %q", got)
+	}
+}
+
+func TestRotatingFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.log")
+	f, err := openRotatingFile(path, 10, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, line := range []string{"first\n", "second\n", "third\n", "fourth\n"} {
+		if err := f.Write([]byte(line)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	f.Close()
+
+	for file, want := range map[string]string{path: "fourth\n", path + ".1": "third\n", path + ".2": "second\n"} {
+		got, err := os.ReadFile(file)
+		if err != nil || string(got) != want {
+			t.Errorf("%s = %q (%v), want %q", filepath.Base(file), got, err, want)
+		}
+	}
+	if _, err := os.Stat(path + ".3"); !os.IsNotExist(err) {
+		t.Error("only AUDIT_LOG_MAX_FILES rotated files should be kept")
+	}
+}
+
+func TestNewAuditLogger_DisabledWithoutSink(t *testing.T) {
+	logger, err := newAuditLogger()
+	if err != nil || logger != nil {
+		t.Errorf("expected no logger without AUDIT_LOG_FILE or AUDIT_LOG_URL, got %v, %v", logger, err)
+	}
+	t.Setenv("AUDIT_LOG_FILE", filepath.Join(t.TempDir(), "audit.log"))
+	t.Setenv("AUDIT_LOG_REDACT", "payer:scramble")
+	if _, err := newAuditLogger(); err == nil {
+		t.Error("invalid redaction rule should be rejected")
+	}
+}
@@ -0,0 +1,299 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/redis/go-redis/v9"
+)
+
+// budgetWindow is one spending period (daily or monthly) for a wallet.
+type budgetWindow struct {
+	Name    string
+	Key     string
+	Cap     int64
+	ResetAt time.Time
+}
+
+// budgetWindows returns the capped windows that apply at now. Windows with
+// a zero cap are disabled and omitted. With REDIS_MODE=cluster the wallet
+// is a hash tag, so all of a wallet's counters share a slot and can be
+// reserved together.
+func budgetWindows(cfg *Config, wallet string, now time.Time) []budgetWindow {
+	now = now.UTC()
+	wallet = strings.ToLower(wallet)
+	if cfg.Redis.Mode == redisModeCluster {
+		wallet = "{" + wallet + "}"
+	}
+	var windows []budgetWindow
+	if cfg.SpendCaps.Daily > 0 {
+		windows = append(windows, budgetWindow{
+			Name:    "daily",
+			Key:     "spend:daily:" + wallet + ":" + now.Format("2006-01-02"),
+			Cap:     cfg.SpendCaps.Daily,
+			ResetAt: time.Date(now.Year(), now.Month(), now.Day()+1, 0, 0, 0, 0, time.UTC),
+		})
+	}
+	if cfg.SpendCaps.Monthly > 0 {
+		windows = append(windows, budgetWindow{
+			Name:    "monthly",
+			Key:     "spend:monthly:" + wallet + ":" + now.Format("2006-01"),
+			Cap:     cfg.SpendCaps.Monthly,
+			ResetAt: time.Date(now.Year(), now.Month()+1, 1, 0, 0, 0, 0, time.UTC),
+		})
+	}
+	return windows
+}
+
+// BudgetExceededError reports which window would overflow.
+type BudgetExceededError struct {
+	Window budgetWindow
+	Spent  int64
+}
+
+func (e *BudgetExceededError) Error() string {
+	return fmt.Sprintf("%s spending cap of %s reached", e.Window.Name, formatTokenAmount(e.Window.Cap))
+}
+
+// SpendStore tracks per-wallet spend across budget windows.
+type SpendStore interface {
+	// Reserve atomically adds amount to every window unless that would push
+	// any of them over its cap, in which case nothing is changed and a
+	// *BudgetExceededError is returned. On success it returns the new totals
+	// in window order.
+	Reserve(ctx context.Context, amount int64, windows []budgetWindow) ([]int64, error)
+	// Release undoes a prior Reserve, e.g. when the AI call fails.
+	Release(ctx context.Context, amount int64, windows []budgetWindow) error
+	// Spent returns the current totals in window order without changing
+	// them.
+	Spent(ctx context.Context, windows []budgetWindow) ([]int64, error)
+}
+
+// reserveSpendScript checks every key before incrementing any of them so a
+// reservation is all-or-nothing. Returns {1, totals...} on success or
+// {0, index, spent} for the first window that would overflow.
+var reserveSpendScript = redis.NewScript(`
+local amount = tonumber(ARGV[1])
+local n = #KEYS
+for i = 1, n do
+  local spent = tonumber(redis.call('GET', KEYS[i]) or '0')
+  if spent + amount > tonumber(ARGV[1 + i]) then
+    return {0, i, spent}
+  end
+end
+local out = {1}
+for i = 1, n do
+  out[i + 1] = redis.call('INCRBY', KEYS[i], amount)
+  redis.call('EXPIREAT', KEYS[i], ARGV[1 + n + i])
+end
+return out
+`)
+
+// redisSpendStore keeps spend counters in Redis so caps hold across replicas.
+type redisSpendStore struct {
+	client redis.UniversalClient
+}
+
+func (s *redisSpendStore) Reserve(ctx context.Context, amount int64, windows []budgetWindow) ([]int64, error) {
+	keys := make([]string, len(windows))
+	args := []interface{}{amount}
+	for i, w := range windows {
+		keys[i] = w.Key
+		args = append(args, w.Cap)
+	}
+	for _, w := range windows {
+		// Keep counters a day past reset so late releases still find them.
+		args = append(args, w.ResetAt.Add(24*time.Hour).Unix())
+	}
+
+	res, err := reserveSpendScript.Run(ctx, s.client, keys, args...).Int64Slice()
+	if err != nil {
+		return nil, fmt.Errorf("reserve spend: %w", err)
+	}
+	if res[0] == 0 {
+		return nil, &BudgetExceededError{Window: windows[res[1]-1], Spent: res[2]}
+	}
+	return res[1:], nil
+}
+
+func (s *redisSpendStore) Release(ctx context.Context, amount int64, windows []budgetWindow) error {
+	pipe := s.client.TxPipeline()
+	for _, w := range windows {
+		pipe.DecrBy(ctx, w.Key, amount)
+	}
+	_, err := pipe.Exec(ctx)
+	return err
+}
+
+func (s *redisSpendStore) Spent(ctx context.Context, windows []budgetWindow) ([]int64, error) {
+	keys := make([]string, len(windows))
+	for i, w := range windows {
+		keys[i] = w.Key
+	}
+	values, err := s.client.MGet(ctx, keys...).Result()
+	if err != nil {
+		return nil, fmt.Errorf("read spend: %w", err)
+	}
+	totals := make([]int64, len(windows))
+	for i, v := range values {
+		if v == nil {
+			continue
+		}
+		if totals[i], err = strconv.ParseInt(fmt.Sprint(v), 10, 64); err != nil {
+			return nil, fmt.Errorf("read spend: invalid total for %s: %w", windows[i].Name, err)
+		}
+	}
+	return totals, nil
+}
+
+// memorySpendStore is the single-instance fallback used when Redis is not
+// configured. Counters are lost on restart.
+type memorySpendStore struct {
+	mu      sync.Mutex
+	totals  map[string]int64
+	expires map[string]time.Time
+}
+
+func newMemorySpendStore() *memorySpendStore {
+	return &memorySpendStore{totals: make(map[string]int64), expires: make(map[string]time.Time)}
+}
+
+func (s *memorySpendStore) Reserve(ctx context.Context, amount int64, windows []budgetWindow) ([]int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	for key, exp := range s.expires {
+		if now.After(exp) {
+			delete(s.totals, key)
+			delete(s.expires, key)
+		}
+	}
+
+	for _, w := range windows {
+		if spent := s.totals[w.Key]; spent+amount > w.Cap {
+			return nil, &BudgetExceededError{Window: w, Spent: spent}
+		}
+	}
+	totals := make([]int64, len(windows))
+	for i, w := range windows {
+		s.totals[w.Key] += amount
+		s.expires[w.Key] = w.ResetAt
+		totals[i] = s.totals[w.Key]
+	}
+	return totals, nil
+}
+
+func (s *memorySpendStore) Release(ctx context.Context, amount int64, windows []budgetWindow) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, w := range windows {
+		if _, ok := s.totals[w.Key]; ok {
+			s.totals[w.Key] -= amount
+		}
+	}
+	return nil
+}
+
+func (s *memorySpendStore) Spent(ctx context.Context, windows []budgetWindow) ([]int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	totals := make([]int64, len(windows))
+	for i, w := range windows {
+		if exp, ok := s.expires[w.Key]; ok && now.Before(exp) {
+			totals[i] = s.totals[w.Key]
+		}
+	}
+	return totals, nil
+}
+
+var localSpendStore = newMemorySpendStore()
+
+// getSpendStore returns the Redis-backed store when Redis is connected and
+// the in-memory fallback otherwise.
+func getSpendStore() SpendStore {
+	if redisClient != nil {
+		return &redisSpendStore{client: redisClient}
+	}
+	return localSpendStore
+}
+
+// reserveSpend charges price against payer's spending caps and returns a
+// refund func the caller must invoke if the request fails before the response
+// is delivered. The refund also returns the payment to the request's sponsor
+// grant, which is released straight away when a cap refuses the request.
+func reserveSpend(c *gin.Context, payer, price string) (refund func(), ok bool) {
+	sponsor := requestSponsor(c)
+	refundPayer, ok := reservePayerSpend(c, payer, price)
+	if !ok {
+		sponsor.release()
+		return refundPayer, false
+	}
+	return func() {
+		refundPayer()
+		sponsor.release()
+	}, true
+}
+
+// reservePayerSpend charges price against payer's spending caps.
+// If a cap would be exceeded it responds 402 Budget Exceeded and returns
+// ok=false. On success it sets X-Budget-* headers with the wallet's current
+// spend and returns a func that releases the reservation. Store errors fail
+// open so a Redis outage does not block paid traffic.
+func reservePayerSpend(c *gin.Context, payer, price string) (release func(), ok bool) {
+	noop := func() {}
+	cfg := getConfig()
+	windows := budgetWindows(cfg, payer, time.Now())
+	if len(windows) == 0 {
+		return noop, true
+	}
+
+	amount, err := parseTokenAmount(price)
+	if err != nil {
+		log.Printf("[WARNING] Spend caps skipped, invalid payment amount: %v", err)
+		return noop, true
+	}
+
+	store := getSpendStore()
+	totals, err := store.Reserve(c.Request.Context(), amount, windows)
+	var exceeded *BudgetExceededError
+	if errors.As(err, &exceeded) {
+		w := exceeded.Window
+		c.Header("X-Budget-Reset", strconv.FormatInt(w.ResetAt.Unix(), 10))
+		respondAPIError(c, newAPIErrorf(CodeBudgetExceeded,
+			"The %s spending cap for this wallet has been reached", w.Name,
+		).with(gin.H{
+			"window":   w.Name,
+			"limit":    formatTokenAmount(w.Cap),
+			"spent":    formatTokenAmount(exceeded.Spent),
+			"reset_at": w.ResetAt.Unix(),
+		}))
+		return noop, false
+	}
+	if err != nil {
+		log.Printf("[WARNING] Spend cap check failed, allowing request: %v", err)
+		return noop, true
+	}
+
+	for i, w := range windows {
+		suffix := strings.ToUpper(w.Name[:1]) + w.Name[1:]
+		c.Header("X-Budget-"+suffix+"-Spent", formatTokenAmount(totals[i]))
+		c.Header("X-Budget-"+suffix+"-Limit", formatTokenAmount(w.Cap))
+	}
+
+	return func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+		defer cancel()
+		if err := store.Release(ctx, amount, windows); err != nil {
+			log.Printf("[WARNING] Failed to release reserved spend: %v", err)
+		}
+	}, true
+}
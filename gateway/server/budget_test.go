@@ -0,0 +1,122 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"gateway/internal/testsupport"
+)
+
+func TestBudgetWindows_ResetTimes(t *testing.T) {
+	cfg := &Config{SpendCaps: SpendCapsConfig{Daily: 1000, Monthly: 5000}}
+	now := time.Date(2025, 12, 31, 15, 4, 5, 0, time.UTC)
+
+	windows := budgetWindows(cfg, "0xABC", now)
+	if len(windows) != 2 {
+		t.Fatalf("expected daily and monthly windows, got %d", len(windows))
+	}
+	if want := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC); !windows[0].ResetAt.Equal(want) {
+		t.Errorf("daily reset = %v, want %v", windows[0].ResetAt, want)
+	}
+	if want := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC); !windows[1].ResetAt.Equal(want) {
+		t.Errorf("monthly reset = %v, want %v", windows[1].ResetAt, want)
+	}
+	if windows[0].Key != "spend:daily:0xabc:2025-12-31" {
+		t.Errorf("expected lowercase wallet in key, got %s", windows[0].Key)
+	}
+
+	if got := budgetWindows(&Config{}, "0xabc", now); len(got) != 0 {
+		t.Errorf("expected no windows when caps are disabled, got %d", len(got))
+	}
+
+	cfg.Redis.Mode = redisModeCluster
+	if windows := budgetWindows(cfg, "0xABC", now); windows[0].Key != "spend:daily:{0xabc}:2025-12-31" || windows[1].Key != "spend:monthly:{0xabc}:2025-12" {
+		t.Errorf("expected the wallet as a hash tag in cluster mode, got %s and %s", windows[0].Key, windows[1].Key)
+	}
+}
+
+func TestMemorySpendStore_ReserveAndRelease(t *testing.T) {
+	store := newMemorySpendStore()
+	ctx := context.Background()
+	windows := []budgetWindow{{Name: "daily", Key: "k", Cap: 2000, ResetAt: time.Now().Add(time.Hour)}}
+
+	for i := 1; i <= 2; i++ {
+		totals, err := store.Reserve(ctx, 1000, windows)
+		if err != nil {
+			t.Fatalf("reserve %d failed: %v", i, err)
+		}
+		if totals[0] != int64(i*1000) {
+			t.Errorf("reserve %d: total = %d, want %d", i, totals[0], i*1000)
+		}
+	}
+
+	_, err := store.Reserve(ctx, 1000, windows)
+	var exceeded *BudgetExceededError
+	if !errors.As(err, &exceeded) || exceeded.Spent != 2000 {
+		t.Fatalf("expected BudgetExceededError with spent 2000, got %v", err)
+	}
+
+	if err := store.Release(ctx, 1000, windows); err != nil {
+		t.Fatalf("release failed: %v", err)
+	}
+	if _, err := store.Reserve(ctx, 1000, windows); err != nil {
+		t.Errorf("expected reserve to succeed after release, got %v", err)
+	}
+	if spent, err := store.Spent(ctx, append(windows, budgetWindow{Key: "unused"})); err != nil || spent[0] != 2000 || spent[1] != 0 {
+		t.Errorf("expected spent [2000 0], got %v (%v)", spent, err)
+	}
+}
+
+func TestSummarize_SpendCapExceededReturns402(t *testing.T) {
+	t.Setenv("SPEND_CAP_DAILY", "0.002")
+	t.Setenv("PAYMENT_AMOUNT", "0.001")
+	h := testsupport.NewHarness(t, newTestRouter)
+	h.Verifier.SetValid("0x00000000000000000000000000000000000b0d6e")
+
+	for i := 0; i < 2; i++ {
+		resp := h.Post(t, "/api/ai/summarize", `{"text":"hello"}`, "0xsig", fmt.Sprintf("nonce-cap-%d", i))
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("request %d: expected 200, got %d", i+1, resp.StatusCode)
+		}
+		if resp.Header.Get("X-Budget-Daily-Limit") != "0.002" {
+			t.Errorf("request %d: expected daily limit header, got %q", i+1, resp.Header.Get("X-Budget-Daily-Limit"))
+		}
+	}
+
+	resp := h.Post(t, "/api/ai/summarize", `{"text":"hello"}`, "0xsig", "nonce-cap-2")
+	if resp.StatusCode != http.StatusPaymentRequired {
+		t.Fatalf("expected 402 once cap is reached, got %d", resp.StatusCode)
+	}
+	var body map[string]interface{}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		t.Fatalf("decode body: %v", err)
+	}
+	if body["error"] != "Budget Exceeded" || body["window"] != "daily" || body["reset_at"] == nil {
+		t.Errorf("unexpected budget error body: %v", body)
+	}
+	if h.AI.Calls() != 2 {
+		t.Errorf("expected AI to be skipped once cap is reached, got %d calls", h.AI.Calls())
+	}
+}
+
+func TestSummarize_FailedAICallRefundsSpend(t *testing.T) {
+	t.Setenv("SPEND_CAP_DAILY", "0.001")
+	t.Setenv("PAYMENT_AMOUNT", "0.001")
+	h := testsupport.NewHarness(t, newTestRouter)
+	h.Verifier.SetValid("0x0000000000000000000000000000000000000f0d")
+
+	h.AI.SetStatus(http.StatusInternalServerError)
+	if resp := h.Post(t, "/api/ai/summarize", `{"text":"hello"}`, "0xsig", "nonce"); resp.StatusCode != http.StatusInternalServerError {
+		t.Fatalf("expected 500 from failing AI, got %d", resp.StatusCode)
+	}
+
+	h.AI.SetStatus(http.StatusOK)
+	if resp := h.Post(t, "/api/ai/summarize", `{"text":"hello"}`, "0xsig", "nonce-retry"); resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected refunded budget to allow the retry, got %d", resp.StatusCode)
+	}
+}
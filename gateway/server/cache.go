@@ -1,4 +1,4 @@
-package main
+package server
 
 import (
 	"bytes"
@@ -7,27 +7,28 @@ import (
 	"encoding/hex"
 	"encoding/json"
 	"errors"
-	"fmt"
 	"io"
 	"log"
 	"net/http"
-	"os"
 	"sync"
 	"time"
 
 	"github.com/gin-gonic/gin"
 )
 
-// CachedResponse represents the data stored in Redis
+// CachedResponse represents the data stored in the response cache
 type CachedResponse struct {
 	Result   string `json:"result"`
 	CachedAt int64  `json:"cached_at"`
+	// FreshUntil (unix seconds) ends the entry's TTL; after it the entry is
+	// served stale while it is refreshed, until the cache drops it.
+	FreshUntil int64 `json:"fresh_until,omitempty"`
 }
 
 func CacheMiddleware() gin.HandlerFunc {
 	return func(c *gin.Context) {
-		// Only cache if Redis is available
-		if redisClient == nil {
+		// Only cache if a cache backend is available
+		if !responseCacheEnabled() {
 			c.Next()
 			return
 		}
@@ -37,8 +38,10 @@ func CacheMiddleware() gin.HandlerFunc {
 		nonce := c.GetHeader("X-402-Nonce")
 
 		// If no signature, we can't verify payment, so bypass cache
-		// (Handler will reject it anyway)
-		if signature == "" || nonce == "" {
+		// (Handler will reject it anyway), unless cache hits are being
+		// served degraded: they may be free or cost less than the handler's
+		// challenge asks.
+		if (signature == "" || nonce == "") && !degradedServing(getConfig()) {
 			c.Next()
 			return
 		}
@@ -49,7 +52,7 @@ func CacheMiddleware() gin.HandlerFunc {
 		// ContentLength == -1 means unknown (chunked encoding or no header), proceed to MaxBytesReader
 		if c.Request.ContentLength > maxBodySize {
 			c.Header("Connection", "close")
-			c.JSON(413, gin.H{"error": "Payload too large", "max_size": "10MB"})
+			respondAPIError(c, errPayloadTooLarge())
 			c.Abort()
 			return
 		}
@@ -64,13 +67,13 @@ func CacheMiddleware() gin.HandlerFunc {
 				var maxBytesErr *http.MaxBytesError
 				if errors.As(err, &maxBytesErr) {
 					c.Header("Connection", "close")
-					c.JSON(413, gin.H{"error": "Payload too large", "max_size": "10MB"})
+					respondAPIError(c, errPayloadTooLarge())
 					c.Abort()
 					return
 				}
 				// Other read errors - don't continue to handler since body is corrupted
 				log.Printf("[ERROR] Failed to read request body: %v", err)
-				c.JSON(500, gin.H{"error": "Failed to read request body"})
+				respondError(c, CodeBodyReadFailed, "The request body could not be read")
 				c.Abort()
 				return
 			}
@@ -88,46 +91,87 @@ func CacheMiddleware() gin.HandlerFunc {
 		if err := json.Unmarshal(requestBody, &req); err != nil {
 			// Invalid JSON - reject immediately to prevent cache bypass attacks
 			log.Printf("[DEBUG] Invalid JSON in request: %v", err)
-			c.JSON(400, gin.H{"error": "Invalid request body", "message": "Request must be valid JSON"})
+			respondError(c, CodeInvalidBody, "Request must be valid JSON")
 			c.Abort()
 			return
 		}
 
 		// Validate text is not empty
 		if req.Text == "" {
-			c.JSON(400, gin.H{"error": "Invalid request", "message": "text field cannot be empty"})
+			respondError(c, CodeInvalidRequest, "text field cannot be empty")
 			c.Abort()
 			return
 		}
 
+		if !negotiateOutputFormat(c, req.OutputFormat) {
+			return
+		}
+
+		// Cached answers are only served for inputs that pass screening.
+		if !screenContent(c, req.Text) {
+			return
+		}
+		req.Text = sanitizeInput(c, req.Text)
+
 		// Generate Cache Key (include model to prevent cache collisions)
-		model := os.Getenv("OPENROUTER_MODEL")
-		if model == "" {
-			model = "z-ai/glm-4.5-air:free"
+		selectModelForText(c, req.Text)
+		setGenerationParams(c, req.GenerationParams)
+		sel, ok := shapeSummary(c)
+		if !ok {
+			return
+		}
+		params, ok := localizeSummary(c, req.Text)
+		if !ok {
+			return
+		}
+		if !checkContextWindow(c, sel.Model, req.Text, params) {
+			return
+		}
+		policy := cachePolicyFor("summarize", sel.Model)
+		if policy.TTL == 0 {
+			c.Next()
+			return
+		}
+		cacheKey := getCacheKey(req.Text, sel.Model, params)
+
+		// While the provider circuit is open, cache hits are only served in
+		// cached-only mode, priced by DEGRADED_MODE, and misses are rejected
+		// by the handler.
+		cfg := getConfig()
+		outage := providerCircuit.State(cfg) != circuitClosed
+		if outage && !cfg.ProviderCircuit.CachedOnly {
+			rejectProviderOutage(c, cfg)
+			return
 		}
-		cacheKey := getCacheKey(req.Text, model)
 
 		// Check Cache
-		if cached, err := getFromCache(c.Request.Context(), cacheKey); err == nil {
+		lookupStart := time.Now()
+		cached, err := getFromCache(c.Request.Context(), cacheKey)
+		recordCacheTiming(c, lookupStart, err == nil)
+		if err == nil {
 			log.Printf("Cache HIT: %s", cacheKey)
+			gatewayStats.RecordCache(time.Now(), true)
+			price := sel.Price
+			if outage {
+				markDegraded(c)
+				if price = degradedPrice(cfg, price); price == "" {
+					contentType, body, _ := encodeSummary(SummaryResponse{Result: cached.Result}, outputFormat(c))
+					serveDegradedFree(c, contentType, body)
+					return
+				}
+			}
+			stale := cached.stale(time.Now())
+			if stale {
+				c.Header("X-Cache-Status", "stale")
+			}
 
 			// Cache HIT! -> Verify Payment *BEFORE* serving
-			// verifyPayment creates its own timeout context, so pass request context directly
-			verifyResp, paymentCtx, err := verifyPayment(c.Request.Context(), signature, nonce)
-			if err != nil {
-				log.Printf("Verification error on cache hit: %v", err)
-				if errors.Is(err, context.DeadlineExceeded) {
-					c.JSON(504, gin.H{"error": "Gateway Timeout", "message": "Verifier request timed out"})
-				} else {
-					c.JSON(500, gin.H{"error": "Verification Service Failed", "message": "An internal error occurred"})
-				}
-				c.Abort()
+			if signature == "" || nonce == "" {
+				respondPaymentRequired(c, price, nil)
 				return
 			}
-
-			if !verifyResp.IsValid {
-				c.JSON(403, gin.H{"error": "Invalid Signature", "details": verifyResp.Error})
-				c.Abort()
+			verifyResp, paymentCtx, ok := authorizePayment(c, signature, nonce, price)
+			if !ok {
 				return
 			}
 
@@ -135,14 +179,37 @@ func CacheMiddleware() gin.HandlerFunc {
 			c.Set("payment_verification", verifyResp)
 			c.Set("payment_context", paymentCtx)
 
+			refundSpend, ok := reserveSpend(c, verifyResp.RecoveredAddress, price)
+			if !ok {
+				c.Abort()
+				return
+			}
+
 			// Generate Receipt and Respond
 			// We treat the cached result as the AI result
 			// Generate receipt for cache hit using current request and cached result.
 			// Note: request_hash matches current request, response is from cache,
 			// but both are cryptographically valid since cache key ensures identical text.
 			if err := generateAndSendReceipt(c, *paymentCtx, verifyResp.RecoveredAddress, requestBody, cached.Result); err != nil {
+				refundSpend()
 				log.Printf("Failed to send cached response receipt: %v", err)
 				// generateAndSendReceipt already sent an error response (500)
+			} else {
+				retainReplayInput(c, req.Text)
+				// Cached responses incur no provider cost.
+				recordMargin(c, *paymentCtx, verifyResp.RecoveredAddress, ProviderUsage{})
+				if outage {
+					providerCircuit.outageHits.Add(1)
+				}
+				// A paid stale hit refreshes the entry for the next
+				// request, unless the provider is down.
+				if stale && !outage {
+					model, text := sel.Model, req.Text
+					refreshCacheEntry(cacheKey, policy, func(ctx context.Context) (string, error) {
+						res, err := summarizeShared(ctx, model, text, params)
+						return res.Summary, err
+					})
+				}
 			}
 			c.Abort()
 			return
@@ -150,6 +217,14 @@ func CacheMiddleware() gin.HandlerFunc {
 
 		// Cache MISS
 		log.Printf("Cache MISS: %s", cacheKey)
+		gatewayStats.RecordCache(time.Now(), false)
+
+		// The handler rejects misses while the provider is down, but would
+		// ask an unsigned request to pay first.
+		if outage && (signature == "" || nonce == "") {
+			rejectProviderOutage(c, cfg)
+			return
+		}
 
 		// Prepare to capture response
 		writer := &cachedWriter{
@@ -167,65 +242,61 @@ func CacheMiddleware() gin.HandlerFunc {
 		bodyBytes := writer.body.Bytes()
 		writer.mu.RUnlock()
 
-		if statusCode == 200 {
-			// Response format: {"result": "...", "receipt": ...}
-			var resp map[string]interface{}
-			if err := json.Unmarshal(bodyBytes, &resp); err == nil {
-				if result, ok := resp["result"].(string); ok {
-					// Store asynchronously with a deadline to prevent indefinite goroutines
-					go func(k, v string) {
-						ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-						defer cancel()
-						storeInCache(ctx, k, v)
-					}(cacheKey, result)
-				}
+		// Answers from a fallback provider are not cached under the routed model.
+		if statusCode == 200 && !c.GetBool("provider_fallback") {
+			// The body is the summary in the negotiated output format.
+			if result, ok := decodeSummary(bodyBytes, outputFormat(c)); ok {
+				// Store asynchronously with a deadline to prevent indefinite goroutines
+				go func(k, v string) {
+					ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+					defer cancel()
+					storeInCacheFor(ctx, k, v, policy)
+				}(cacheKey, result)
 			}
 		}
 	}
 }
 
-func getCacheKey(text string, model string) string {
-	// IMPORTANT: This cache key ONLY includes text and model.
-	// Cache version v1 - if parameters change, increment version to invalidate old caches
-	// If callOpenRouter() is modified to accept additional parameters
-	// (temperature, max_tokens, top_p, etc.), those MUST be added to
-	// this cache key to prevent incorrect cache hits.
-	// TODO: Consider accepting a struct with all OpenRouter parameters
-	const cacheVersion = "v1"
-	combined := cacheVersion + ":" + text + ":" + model
+func getCacheKey(text string, model string, params GenerationParams) string {
+	// IMPORTANT: This cache key includes text, model and the clamped
+	// generation parameters. Requests without parameters keep the original
+	// v1 key, so existing entries stay valid.
+	// The version starts at v1 and is bumped at runtime through
+	// POST /api/admin/cache/version to invalidate old caches.
+	// If callAIProviders() is modified to accept additional parameters,
+	// those MUST be added to this cache key to prevent incorrect cache hits.
+	combined := cacheVersion() + ":" + text + ":" + model
+	if p := generationCacheKey(params); p != "" {
+		combined += ":" + p
+	}
 	hash := sha256.Sum256([]byte(combined))
 	return "ai:summary:" + hex.EncodeToString(hash[:])
 }
 
 func getFromCache(ctx context.Context, key string) (*CachedResponse, error) {
-	if redisClient == nil {
-		return nil, fmt.Errorf("redis not available")
-	}
-
-	val, err := redisClient.Get(ctx, key).Result()
+	val, err := responseCache().Get(ctx, key)
 	if err != nil {
 		return nil, err
 	}
 
 	var cached CachedResponse
-	if err := json.Unmarshal([]byte(val), &cached); err != nil {
+	if err := json.Unmarshal(val, &cached); err != nil {
 		return nil, err
 	}
 
 	return &cached, nil
 }
 
-func storeInCache(ctx context.Context, key string, data string) {
-	if redisClient == nil {
-		return
-	}
-
-	ttl := time.Duration(getEnvAsInt("CACHE_TTL_SECONDS", 3600)) * time.Second
-
+// storeInCacheFor caches data under key for policy's TTL plus its stale
+// period.
+func storeInCacheFor(ctx context.Context, key string, data string, policy CachePolicy) {
+	now := time.Now()
 	cached := CachedResponse{
-		Result:   data,
-		CachedAt: time.Now().Unix(),
+		Result:     data,
+		CachedAt:   now.Unix(),
+		FreshUntil: now.Add(policy.TTL).Unix(),
 	}
+	ttl := policy.TTL + policy.Stale
 
 	jsonData, err := json.Marshal(cached)
 	if err != nil {
@@ -234,7 +305,7 @@ func storeInCache(ctx context.Context, key string, data string) {
 	}
 
 	// Use the context provided by caller (already has 5s timeout from async goroutine)
-	if err := redisClient.Set(ctx, key, jsonData, ttl).Err(); err != nil {
+	if err := responseCache().Set(ctx, key, jsonData, ttl); err != nil {
 		log.Printf("[WARNING] Failed to store in cache for key %s: %v", safeKeyPrefix(key), err)
 	}
 }
@@ -0,0 +1,135 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// responseCachePrefix is the namespace of every cached AI response
+// (ai:summary:, ai:embedding: and ai:<endpoint>:). The admin API only
+// deletes keys inside it.
+const responseCachePrefix = "ai:"
+
+// cacheGenerationKey holds the number of times the response cache version
+// has been bumped, shared by every replica.
+const cacheGenerationKey = "cache:generation"
+
+// cacheVersionRefresh is how often an instance re-reads the cache
+// generation, so a bump on one replica reaches the others within it.
+const cacheVersionRefresh = 5 * time.Second
+
+var (
+	cacheGeneration        atomic.Int64
+	cacheGenerationChecked atomic.Int64 // Unix nanoseconds
+)
+
+// cacheVersion returns the version baked into every response cache key:
+// "v1" until the first bump, then v2, v3 and so on.
+func cacheVersion() string {
+	now := time.Now().UnixNano()
+	last := cacheGenerationChecked.Load()
+	if now-last >= int64(cacheVersionRefresh) && cacheGenerationChecked.CompareAndSwap(last, now) {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		n, err := responseCache().Generation(ctx)
+		cancel()
+		if err != nil {
+			log.Printf("[WARNING] Failed to read cache version, keeping v%d: %v", cacheGeneration.Load()+1, err)
+		} else {
+			cacheGeneration.Store(n)
+		}
+	}
+	return "v" + strconv.FormatInt(cacheGeneration.Load()+1, 10)
+}
+
+// bumpCacheVersion moves every replica to a new cache version, orphaning
+// all cached responses; they expire with their TTL.
+func bumpCacheVersion(ctx context.Context) (previous, current string, err error) {
+	previous = cacheVersion()
+	n, err := responseCache().BumpGeneration(ctx)
+	if err != nil {
+		return previous, previous, fmt.Errorf("failed to bump cache version: %w", err)
+	}
+	cacheGeneration.Store(n)
+	cacheGenerationChecked.Store(time.Now().UnixNano())
+	return previous, cacheVersion(), nil
+}
+
+// requireResponseCache responds 503 and returns false when no response
+// cache backend is storing anything.
+func requireResponseCache(c *gin.Context) bool {
+	if !responseCacheEnabled() {
+		respondError(c, CodeServiceUnavailable, "Response caching is not configured")
+		return false
+	}
+	return true
+}
+
+// handleDeleteCacheKey handles DELETE /api/admin/cache/:key, removing one
+// cached response, e.g. a poisoned summary found by its ai:summary: key.
+func handleDeleteCacheKey(c *gin.Context) {
+	key := c.Param("key")
+	if !strings.HasPrefix(key, responseCachePrefix) {
+		respondError(c, CodeInvalidRequest, "key must be a response cache key starting with "+responseCachePrefix)
+		return
+	}
+	if !requireResponseCache(c) {
+		return
+	}
+	deleted, err := responseCache().Delete(c.Request.Context(), key)
+	if err != nil {
+		log.Printf("[ERROR] Failed to delete cache key %s: %v", safeKeyPrefix(key), err)
+		respondError(c, CodeServiceUnavailable, "The cache is unavailable")
+		return
+	}
+	if !deleted {
+		respondError(c, CodeNotFound, "No cached response has that key")
+		return
+	}
+	log.Printf("Cache key %s deleted by admin", safeKeyPrefix(key))
+	c.JSON(200, gin.H{"key": key, "deleted": 1})
+}
+
+// handlePurgeCache handles DELETE /api/admin/cache?prefix=ai:summary:,
+// removing every cached response whose key starts with prefix.
+func handlePurgeCache(c *gin.Context) {
+	prefix := c.Query("prefix")
+	if !strings.HasPrefix(prefix, responseCachePrefix) {
+		respondError(c, CodeInvalidRequest, "prefix is required and must start with "+responseCachePrefix)
+		return
+	}
+	if !requireResponseCache(c) {
+		return
+	}
+	n, err := responseCache().DeletePrefix(c.Request.Context(), prefix)
+	if err != nil {
+		log.Printf("[ERROR] Cache purge of %s stopped after %d keys: %v", prefix, n, err)
+		respondAPIError(c, newAPIError(CodeServiceUnavailable, "The cache purge did not complete").with(gin.H{"deleted": n}))
+		return
+	}
+	log.Printf("Cache purged by admin: %d keys under %s", n, prefix)
+	c.JSON(200, gin.H{"prefix": prefix, "deleted": n})
+}
+
+// handleBumpCacheVersion handles POST /api/admin/cache/version. Every
+// cached response is bypassed at once without scanning the cache; the old
+// entries expire with their TTL.
+func handleBumpCacheVersion(c *gin.Context) {
+	if !requireResponseCache(c) {
+		return
+	}
+	previous, current, err := bumpCacheVersion(c.Request.Context())
+	if err != nil {
+		log.Printf("[ERROR] %v", err)
+		respondError(c, CodeServiceUnavailable, "The cache is unavailable")
+		return
+	}
+	log.Printf("Cache version bumped by admin: %s -> %s", previous, current)
+	c.JSON(200, gin.H{"previous": previous, "version": current})
+}
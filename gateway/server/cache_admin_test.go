@@ -0,0 +1,162 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"gateway/internal/testsupport"
+)
+
+// adminDelete sends an authenticated DELETE to the admin API.
+func adminDelete(t *testing.T, h http.Handler, path, token string) *httptest.ResponseRecorder {
+	t.Helper()
+	req := httptest.NewRequest(http.MethodDelete, path, nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	return w
+}
+
+// withCacheVersion resets the process-wide cache version for one test.
+func withCacheVersion(t *testing.T) {
+	t.Helper()
+	reset := func() {
+		cacheGeneration.Store(0)
+		cacheGenerationChecked.Store(0)
+	}
+	reset()
+	t.Cleanup(reset)
+}
+
+// cacheSummary makes a paid summarize request and waits for its response to
+// be cached, returning the number of summary keys in Redis.
+func cacheSummary(t *testing.T, gw *integrationGateway, text, nonce string) int {
+	t.Helper()
+	before := len(gw.redisKeys("ai:summary:"))
+	if resp := gw.Post(t, "/api/ai/summarize", `{"text":"`+text+`"}`, "0xsig", nonce); resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected 200, got %d", resp.StatusCode)
+	}
+	deadline := time.Now().Add(2 * time.Second)
+	for len(gw.redisKeys("ai:summary:")) == before && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond) // cache writes are asynchronous
+	}
+	return len(gw.redisKeys("ai:summary:"))
+}
+
+func TestCacheAdmin_DeleteKeyAndPrefix(t *testing.T) {
+	withCacheVersion(t)
+	gw := startGateway(t, map[string]string{"ADMIN_API_KEY": "s3cret"})
+	router := gw.Server.Config.Handler
+
+	cacheSummary(t, gw, "first", "nonce-cache-1")
+	if n := cacheSummary(t, gw, "second", "nonce-cache-2"); n != 2 {
+		t.Fatalf("expected two cached summaries, got %d", n)
+	}
+
+	key := gw.redisKeys("ai:summary:")[0]
+	if w := adminDelete(t, router, "/api/admin/cache/"+key, "s3cret"); w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", w.Code, w.Body)
+	}
+	if w := adminDelete(t, router, "/api/admin/cache/"+key, "s3cret"); w.Code != http.StatusNotFound {
+		t.Errorf("expected 404 for a deleted key, got %d", w.Code)
+	}
+	if w := adminDelete(t, router, "/api/admin/cache/receipt:seq:0xabc", "s3cret"); w.Code != http.StatusBadRequest {
+		t.Errorf("expected keys outside the response cache to be refused, got %d", w.Code)
+	}
+
+	calls := gw.AI.Calls()
+	cacheSummary(t, gw, "third", "nonce-cache-3")
+	w := adminDelete(t, router, "/api/admin/cache?prefix=ai:summary:", "s3cret")
+	var body struct {
+		Deleted int64 `json:"deleted"`
+	}
+	if w.Code != http.StatusOK || json.Unmarshal(w.Body.Bytes(), &body) != nil || body.Deleted != 2 {
+		t.Fatalf("expected both remaining summaries purged, got %d: %s", w.Code, w.Body)
+	}
+	if keys := gw.redisKeys("ai:summary:"); len(keys) != 0 {
+		t.Errorf("expected no cached summaries, got %v", keys)
+	}
+
+	gw.Post(t, "/api/ai/summarize", `{"text":"third"}`, "0xsig", "nonce-cache-4")
+	if gw.AI.Calls() != calls+2 {
+		t.Error("expected the purged summary to be regenerated")
+	}
+
+	for _, path := range []string{"/api/admin/cache", "/api/admin/cache?prefix=receipt:"} {
+		if w := adminDelete(t, router, path, "s3cret"); w.Code != http.StatusBadRequest {
+			t.Errorf("%s: expected 400, got %d", path, w.Code)
+		}
+	}
+}
+
+func TestCacheAdmin_BumpVersion(t *testing.T) {
+	withCacheVersion(t)
+	gw := startGateway(t, map[string]string{"ADMIN_API_KEY": "s3cret"})
+	router := gw.Server.Config.Handler
+
+	cacheSummary(t, gw, "versioned", "nonce-version-1")
+	gw.Post(t, "/api/ai/summarize", `{"text":"versioned"}`, "0xsig", "nonce-version-2")
+	calls := gw.AI.Calls()
+
+	w := adminPost(t, router, "/api/admin/cache/version", "s3cret", "")
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `"previous":"v1"`) || !strings.Contains(w.Body.String(), `"version":"v2"`) {
+		t.Fatalf("expected v1 -> v2, got %d: %s", w.Code, w.Body)
+	}
+	if v, _ := gw.Redis.Get(cacheGenerationKey); v != "1" {
+		t.Errorf("expected the generation shared through Redis, got %q", v)
+	}
+
+	gw.Post(t, "/api/ai/summarize", `{"text":"versioned"}`, "0xsig", "nonce-version-3")
+	if gw.AI.Calls() != calls+1 {
+		t.Error("expected the old cache entry to be bypassed after the bump")
+	}
+}
+
+func TestCacheVersion_FollowsRedis(t *testing.T) {
+	withCacheVersion(t)
+	startGateway(t, nil)
+
+	if v := cacheVersion(); v != "v1" {
+		t.Fatalf("expected v1 before any bump, got %s", v)
+	}
+	// Another replica bumps twice.
+	if _, err := redisClient.IncrBy(t.Context(), cacheGenerationKey, 2).Result(); err != nil {
+		t.Fatal(err)
+	}
+	if v := cacheVersion(); v != "v1" {
+		t.Errorf("expected the version to be reused until the refresh, got %s", v)
+	}
+	cacheGenerationChecked.Store(0)
+	if v := cacheVersion(); v != "v3" {
+		t.Errorf("expected v3 after the refresh, got %s", v)
+	}
+}
+
+func TestCacheAdmin_MemoryBackend(t *testing.T) {
+	withCacheVersion(t)
+	withMemoryCache(t)
+	t.Setenv("ADMIN_API_KEY", "s3cret")
+	h := testsupport.NewHarness(t, newTestRouter)
+	router := h.Server.Config.Handler
+
+	if resp := h.Post(t, "/api/ai/summarize", `{"text":"in memory"}`, "0xsig", "nonce-memory-1"); resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected 200, got %d", resp.StatusCode)
+	}
+	deadline := time.Now().Add(2 * time.Second)
+	for responseCache().Stats().Entries == 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond) // cache writes are asynchronous
+	}
+
+	w := adminDelete(t, router, "/api/admin/cache?prefix=ai:summary:", "s3cret")
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `"deleted":1`) {
+		t.Fatalf("expected the memory cache purged, got %d: %s", w.Code, w.Body)
+	}
+	w = adminPost(t, router, "/api/admin/cache/version", "s3cret", "")
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `"version":"v2"`) {
+		t.Errorf("expected the memory cache version bumped, got %d: %s", w.Code, w.Body)
+	}
+}
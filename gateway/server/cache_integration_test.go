@@ -1,4 +1,4 @@
-package main
+package server
 
 import (
 	"bytes"
@@ -87,7 +87,7 @@ func TestCacheIntegration_FullFlow(t *testing.T) {
 	// 5. Test execution
 	textToSummarize := "This is a unique text for cache integration test " + time.Now().String()
 	model := "z-ai/glm-4.5-air:free" // Default model
-	cacheKey := getCacheKey(textToSummarize, model)
+	cacheKey := getCacheKey(textToSummarize, model, GenerationParams{})
 
 	// Helper to make request
 	makeRequest := func(sig string) *httptest.ResponseRecorder {
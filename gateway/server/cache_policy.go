@@ -0,0 +1,149 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// CachePolicy is how long responses of an endpoint, optionally for one
+// model, are cached. A response is fresh for TTL, then served stale for up
+// to Stale more while it is refreshed in the background. A zero TTL
+// disables caching.
+type CachePolicy struct {
+	// Endpoint is the route name (summarize, embed or a paid endpoint), or
+	// "*" for every endpoint.
+	Endpoint string
+	// Model limits the policy to one model; empty matches every model.
+	Model string
+	TTL   time.Duration
+	Stale time.Duration
+}
+
+// CachePolicyConfig holds the CACHE_POLICIES entries and the defaults of
+// endpoints no entry matches.
+type CachePolicyConfig struct {
+	TTL      time.Duration
+	Stale    time.Duration
+	Policies []CachePolicy
+
+	// err holds a CACHE_POLICIES parse error.
+	err error
+}
+
+// loadCachePolicyConfig reads CACHE_TTL_SECONDS, CACHE_STALE_SECONDS and
+// CACHE_POLICIES.
+func loadCachePolicyConfig() CachePolicyConfig {
+	cc := CachePolicyConfig{
+		TTL:   time.Duration(getEnvAsInt("CACHE_TTL_SECONDS", 3600)) * time.Second,
+		Stale: time.Duration(getEnvAsInt("CACHE_STALE_SECONDS", 0)) * time.Second,
+	}
+	cc.Policies, cc.err = parseCachePolicies(getEnvAsList("CACHE_POLICIES", nil))
+	return cc
+}
+
+// parseCachePolicies parses "<endpoint>[@<model>]=<ttl seconds>[:<stale
+// seconds>]" entries, or "=off" to disable caching, e.g.
+// "summarize@openai/gpt-4o=86400:3600,embed=604800,*@openai/o1=off".
+func parseCachePolicies(entries []string) ([]CachePolicy, error) {
+	var policies []CachePolicy
+	for _, entry := range entries {
+		target, value, ok := strings.Cut(entry, "=")
+		if !ok {
+			return nil, fmt.Errorf("cache policy %q must be endpoint[@model]=ttl[:stale]", entry)
+		}
+		endpoint, model, _ := strings.Cut(strings.TrimSpace(target), "@")
+		if endpoint == "" {
+			return nil, fmt.Errorf("cache policy %q names no endpoint", entry)
+		}
+		p := CachePolicy{Endpoint: endpoint, Model: model}
+		if value = strings.TrimSpace(value); value != "off" {
+			ttl, stale, hasStale := strings.Cut(value, ":")
+			n, err := strconv.Atoi(ttl)
+			if err != nil || n <= 0 {
+				return nil, fmt.Errorf("cache policy %q: TTL must be a positive number of seconds or off", entry)
+			}
+			p.TTL = time.Duration(n) * time.Second
+			if hasStale {
+				s, err := strconv.Atoi(stale)
+				if err != nil || s < 0 {
+					return nil, fmt.Errorf("cache policy %q: stale must be a non-negative number of seconds", entry)
+				}
+				p.Stale = time.Duration(s) * time.Second
+			}
+		}
+		for _, existing := range policies {
+			if existing.Endpoint == p.Endpoint && existing.Model == p.Model {
+				return nil, fmt.Errorf("cache policy for %q is listed more than once", strings.TrimSpace(target))
+			}
+		}
+		policies = append(policies, p)
+	}
+	return policies, nil
+}
+
+// validate reports a CACHE_POLICIES parse error or negative defaults.
+func (cc CachePolicyConfig) validate() error {
+	if cc.err != nil {
+		return fmt.Errorf("invalid CACHE_POLICIES: %w", cc.err)
+	}
+	if cc.TTL < 0 || cc.Stale < 0 {
+		return fmt.Errorf("CACHE_TTL_SECONDS and CACHE_STALE_SECONDS must not be negative")
+	}
+	return nil
+}
+
+// For returns the policy of responses from endpoint with model: the most
+// specific entry, trying endpoint@model, *@model, endpoint and * in that
+// order, else ttl (the endpoint's own default) with the default staleness.
+func (cc CachePolicyConfig) For(endpoint, model string, ttl time.Duration) CachePolicy {
+	for _, want := range [][2]string{{endpoint, model}, {"*", model}, {endpoint, ""}, {"*", ""}} {
+		for _, p := range cc.Policies {
+			if p.Endpoint == want[0] && p.Model == want[1] {
+				return p
+			}
+		}
+	}
+	return CachePolicy{Endpoint: endpoint, Model: model, TTL: ttl, Stale: cc.Stale}
+}
+
+// cachePolicyFor is For on the current config with CACHE_TTL_SECONDS as the
+// default.
+func cachePolicyFor(endpoint, model string) CachePolicy {
+	cc := getConfig().Cache
+	return cc.For(endpoint, model, cc.TTL)
+}
+
+// stale reports whether a cached response is past its fresh period. Entries
+// written before policies had no fresh_until and count as fresh.
+func (r *CachedResponse) stale(now time.Time) bool {
+	return r.FreshUntil != 0 && now.Unix() >= r.FreshUntil
+}
+
+// cacheRefreshes holds the keys being refreshed in the background, so a
+// stale entry is refreshed once however many requests are served it.
+var cacheRefreshes sync.Map
+
+// refreshCacheEntry recomputes the stale entry key with fetch in the
+// background and stores it under policy, unless a refresh of key is already
+// running on this instance.
+func refreshCacheEntry(key string, policy CachePolicy, fetch func(ctx context.Context) (string, error)) {
+	if _, running := cacheRefreshes.LoadOrStore(key, struct{}{}); running {
+		return
+	}
+	go func() {
+		defer cacheRefreshes.Delete(key)
+		ctx, cancel := context.WithTimeout(context.Background(), getAITimeout())
+		defer cancel()
+		data, err := fetch(ctx)
+		if err != nil {
+			log.Printf("[WARNING] Failed to refresh stale cache entry %s: %v", safeKeyPrefix(key), err)
+			return
+		}
+		storeInCacheFor(ctx, key, data, policy)
+	}()
+}
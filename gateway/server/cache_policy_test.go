@@ -0,0 +1,139 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	"gateway/internal/testsupport"
+)
+
+func TestParseCachePolicies(t *testing.T) {
+	policies, err := parseCachePolicies([]string{"summarize@openai/gpt-4o=86400:3600", "embed=604800", "*@openai/o1=off"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []CachePolicy{
+		{Endpoint: "summarize", Model: "openai/gpt-4o", TTL: 24 * time.Hour, Stale: time.Hour},
+		{Endpoint: "embed", TTL: 7 * 24 * time.Hour},
+		{Endpoint: "*", Model: "openai/o1"},
+	}
+	for i, p := range policies {
+		if p != want[i] {
+			t.Errorf("entry %d: got %+v, want %+v", i, p, want[i])
+		}
+	}
+	for _, bad := range []string{"summarize", "=60", "summarize=0", "summarize=soon", "summarize=60:-1", "summarize=60:x"} {
+		if _, err := parseCachePolicies([]string{bad}); err == nil {
+			t.Errorf("%q: expected an error", bad)
+		}
+	}
+	if _, err := parseCachePolicies([]string{"embed=60", "embed=120"}); err == nil {
+		t.Error("expected a duplicate entry to be rejected")
+	}
+}
+
+func TestCachePolicyConfig_ForPrefersTheMostSpecificEntry(t *testing.T) {
+	policies, err := parseCachePolicies([]string{"summarize@m1=10", "*@m1=20", "summarize=30", "*=40"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cc := CachePolicyConfig{TTL: time.Hour, Stale: time.Minute, Policies: policies}
+	for _, tc := range []struct {
+		endpoint, model string
+		ttl             time.Duration
+	}{
+		{"summarize", "m1", 10 * time.Second},
+		{"embed", "m1", 20 * time.Second},
+		{"summarize", "m2", 30 * time.Second},
+		{"embed", "m2", 40 * time.Second},
+	} {
+		if got := cc.For(tc.endpoint, tc.model, cc.TTL).TTL; got != tc.ttl {
+			t.Errorf("%s@%s: expected %v, got %v", tc.endpoint, tc.model, tc.ttl, got)
+		}
+	}
+
+	cc.Policies = cc.Policies[:1]
+	if p := cc.For("embed", "m2", 5*time.Second); p.TTL != 5*time.Second || p.Stale != time.Minute {
+		t.Errorf("expected the endpoint default with the default staleness, got %+v", p)
+	}
+}
+
+func TestCachePolicyConfig_Validate(t *testing.T) {
+	t.Setenv("CACHE_POLICIES", "summarize=nope")
+	if err := loadConfig().Validate(); err == nil {
+		t.Error("expected a malformed CACHE_POLICIES to be rejected")
+	}
+	t.Setenv("CACHE_POLICIES", "")
+	t.Setenv("CACHE_STALE_SECONDS", "-1")
+	if err := loadConfig().Validate(); err == nil {
+		t.Error("expected a negative CACHE_STALE_SECONDS to be rejected")
+	}
+}
+
+func TestSummarize_CachePolicyOffSkipsTheCache(t *testing.T) {
+	withMemoryCache(t)
+	t.Setenv("CACHE_POLICIES", "summarize=off")
+	h := testsupport.NewHarness(t, newTestRouter)
+
+	for _, nonce := range []string{"nonce-off-1", "nonce-off-2"} {
+		if resp := h.Post(t, "/api/ai/summarize", `{"text":"do not cache"}`, "0xsig", nonce); resp.StatusCode != http.StatusOK {
+			t.Fatalf("expected 200, got %d", resp.StatusCode)
+		}
+	}
+	if h.AI.Calls() != 2 {
+		t.Errorf("expected both requests to reach the provider, got %d calls", h.AI.Calls())
+	}
+	if stats := responseCache().Stats(); stats.Sets != 0 {
+		t.Errorf("expected nothing cached, got %+v", stats)
+	}
+}
+
+func TestSummarize_StaleEntryIsServedAndRefreshed(t *testing.T) {
+	withMemoryCache(t)
+	t.Setenv("CACHE_STALE_SECONDS", "600")
+	h := testsupport.NewHarness(t, newTestRouter)
+
+	// An entry past its fresh period but within its stale period.
+	key := getCacheKey("stale me", getConfig().Model, GenerationParams{})
+	old, _ := json.Marshal(CachedResponse{Result: "old summary", CachedAt: time.Now().Add(-2 * time.Hour).Unix(), FreshUntil: time.Now().Add(-time.Hour).Unix()})
+	if err := responseCache().Set(context.Background(), key, old, time.Hour); err != nil {
+		t.Fatal(err)
+	}
+
+	resp := h.Post(t, "/api/ai/summarize", `{"text":"stale me"}`, "0xsig", "nonce-stale-1")
+	if resp.StatusCode != http.StatusOK || resp.Header.Get("X-Cache-Status") != "stale" {
+		t.Fatalf("expected a stale hit, got %d %q", resp.StatusCode, resp.Header.Get("X-Cache-Status"))
+	}
+	var body struct {
+		Result string `json:"result"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil || body.Result != "old summary" {
+		t.Fatalf("expected the stale summary, got %q (%v)", body.Result, err)
+	}
+
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		cached, err := getFromCache(context.Background(), key)
+		if err == nil && !cached.stale(time.Now()) {
+			if cached.Result == "old summary" {
+				t.Error("expected the refresh to replace the summary")
+			}
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("expected the stale entry to be refreshed in the background")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if h.AI.Calls() != 1 {
+		t.Errorf("expected one refresh call, got %d", h.AI.Calls())
+	}
+
+	resp = h.Post(t, "/api/ai/summarize", `{"text":"stale me"}`, "0xsig", "nonce-stale-2")
+	if resp.StatusCode != http.StatusOK || resp.Header.Get("X-Cache-Status") != "" {
+		t.Errorf("expected a fresh hit, got %d %q", resp.StatusCode, resp.Header.Get("X-Cache-Status"))
+	}
+}
@@ -1,4 +1,4 @@
-package main
+package server
 
 import (
 	"crypto/sha256"
@@ -20,8 +20,8 @@ func TestCacheKey(t *testing.T) {
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			model := "z-ai/glm-4.5-air:free"
-			key1 := getCacheKey(tt.text, model)
-			key2 := getCacheKey(tt.text, model)
+			key1 := getCacheKey(tt.text, model, GenerationParams{})
+			key2 := getCacheKey(tt.text, model, GenerationParams{})
 
 			// 1. Deterministic
 			if key1 != key2 {
@@ -45,8 +45,8 @@ func TestCacheKey(t *testing.T) {
 func TestCacheKeyUniqueForDifferentInputs(t *testing.T) {
 	// Verify that different inputs produce different cache keys
 	model := "z-ai/glm-4.5-air:free"
-	k1 := getCacheKey("abc", model)
-	k2 := getCacheKey("abd", model)
+	k1 := getCacheKey("abc", model, GenerationParams{})
+	k2 := getCacheKey("abd", model, GenerationParams{})
 	if k1 == k2 {
 		t.Error("Different inputs produced same cache key")
 	}
@@ -60,7 +60,7 @@ func TestCacheKeySpec(t *testing.T) {
 	combined := cacheVersion + ":" + text + ":" + model
 	hash := sha256.Sum256([]byte(combined))
 	expected := "ai:summary:" + hex.EncodeToString(hash[:])
-	actual := getCacheKey(text, model)
+	actual := getCacheKey(text, model, GenerationParams{})
 	if actual != expected {
 		t.Errorf("Spec mismatch: got %s want %s", actual, expected)
 	}
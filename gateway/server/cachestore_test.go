@@ -0,0 +1,58 @@
+package server
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"gateway/internal/testsupport"
+)
+
+// withMemoryCache enables the in-memory response cache for one test.
+func withMemoryCache(t *testing.T) {
+	t.Helper()
+	t.Setenv("CACHE_ENABLED", "true")
+	t.Setenv("CACHE_BACKEND", cacheBackendMemory)
+	memoryResponseCache.Store(nil)
+	t.Cleanup(func() { memoryResponseCache.Store(nil) })
+}
+
+func TestResponseCache_Selection(t *testing.T) {
+	t.Setenv("CACHE_ENABLED", "false")
+	if responseCacheEnabled() {
+		t.Error("expected no cache while caching is disabled")
+	}
+	withMemoryCache(t)
+	if got := responseCache().Stats().Backend; got != cacheBackendMemory {
+		t.Errorf("expected the memory backend, got %s", got)
+	}
+
+	t.Setenv("CACHE_BACKEND", "memcached")
+	if err := loadConfig().Validate(); err == nil {
+		t.Error("expected an unknown CACHE_BACKEND to be rejected")
+	}
+}
+
+func TestSummarize_MemoryCacheServesRepeats(t *testing.T) {
+	withMemoryCache(t)
+	h := testsupport.NewHarness(t, newTestRouter)
+
+	for i, nonce := range []string{"nonce-mem-1", "nonce-mem-2"} {
+		if resp := h.Post(t, "/api/ai/summarize", `{"text":"cache me"}`, "0xsig", nonce); resp.StatusCode != http.StatusOK {
+			t.Fatalf("request %d: expected 200, got %d", i+1, resp.StatusCode)
+		}
+		if i == 0 {
+			// The miss is stored asynchronously.
+			deadline := time.Now().Add(2 * time.Second)
+			for responseCache().Stats().Sets == 0 && time.Now().Before(deadline) {
+				time.Sleep(10 * time.Millisecond)
+			}
+		}
+	}
+	if h.AI.Calls() != 1 {
+		t.Errorf("expected the repeat to be served from memory, got %d provider calls", h.AI.Calls())
+	}
+	if stats := responseCache().Stats(); stats.Hits != 1 {
+		t.Errorf("expected one cache hit, got %+v", stats)
+	}
+}
@@ -0,0 +1,143 @@
+package server
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"gateway/payments"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/gin-gonic/gin"
+)
+
+// ChainOption is one chain the gateway accepts payment on, with the address
+// that receives payments there.
+type ChainOption struct {
+	ChainID   int    `json:"chainId"`
+	Name      string `json:"name,omitempty"`
+	Recipient string `json:"recipient"`
+}
+
+// knownChains maps the chain names accepted in ACCEPTED_CHAINS to IDs.
+var knownChains = map[string]int{
+	"base":             8453,
+	"base-sepolia":     84532,
+	"optimism":         10,
+	"optimism-sepolia": 11155420,
+	"arbitrum":         42161,
+	"arbitrum-sepolia": 421614,
+	"polygon":          137,
+	"polygon-amoy":     80002,
+}
+
+// chainName returns the known name for id, or "".
+func chainName(id int) string {
+	for name, known := range knownChains {
+		if known == id {
+			return name
+		}
+	}
+	return ""
+}
+
+// parseAcceptedChains builds the accepted chains: the primary chain (CHAIN_ID
+// paid to RECIPIENT_ADDRESS) first, then each ACCEPTED_CHAINS entry, written
+// "<name or id>[:<recipient>]". Entries without a recipient use the primary
+// recipient.
+func parseAcceptedChains(raw []string, primaryID int, primaryRecipient string) ([]ChainOption, error) {
+	chains := []ChainOption{{ChainID: primaryID, Name: chainName(primaryID), Recipient: primaryRecipient}}
+	for _, entry := range raw {
+		ref, recipient, _ := strings.Cut(entry, ":")
+		ref, recipient = strings.TrimSpace(ref), strings.TrimSpace(recipient)
+		id, err := parseChainRef(ref)
+		if err != nil {
+			return nil, err
+		}
+		if recipient == "" {
+			recipient = primaryRecipient
+		}
+		if !common.IsHexAddress(recipient) {
+			return nil, fmt.Errorf("chain %q: invalid recipient address %q", ref, recipient)
+		}
+		for _, existing := range chains {
+			if existing.ChainID == id {
+				return nil, fmt.Errorf("chain %d is listed more than once", id)
+			}
+		}
+		chains = append(chains, ChainOption{ChainID: id, Name: chainName(id), Recipient: recipient})
+	}
+	return chains, nil
+}
+
+// parseChainRef resolves a chain given by known name or numeric ID.
+func parseChainRef(ref string) (int, error) {
+	if id, ok := knownChains[strings.ToLower(ref)]; ok {
+		return id, nil
+	}
+	id, err := strconv.Atoi(ref)
+	if err != nil || id <= 0 {
+		return 0, fmt.Errorf("unknown chain %q", ref)
+	}
+	return id, nil
+}
+
+// Chain returns the accepted chain with the given ID.
+func (cfg *Config) Chain(id int) (ChainOption, bool) {
+	for _, chain := range cfg.Chains {
+		if chain.ChainID == id {
+			return chain, true
+		}
+	}
+	return ChainOption{}, false
+}
+
+// PrimaryChain returns the chain used when the client does not pick one.
+func (cfg *Config) PrimaryChain() ChainOption {
+	if len(cfg.Chains) > 0 {
+		return cfg.Chains[0]
+	}
+	return ChainOption{ChainID: cfg.ChainID, Recipient: cfg.RecipientAddress}
+}
+
+// selectPaymentChain returns the chain named by X-402-Chain-Id, or the
+// primary chain when the header is absent. An unaccepted chain aborts with a
+// 402 listing the accepted ones and returns false.
+func selectPaymentChain(c *gin.Context, price string) (ChainOption, bool) {
+	cfg := getConfig()
+	raw := c.GetHeader("X-402-Chain-Id")
+	if raw == "" {
+		return cfg.PrimaryChain(), true
+	}
+	if id, err := strconv.Atoi(raw); err == nil {
+		if chain, ok := cfg.Chain(id); ok {
+			return chain, true
+		}
+	}
+	respondPaymentRequired(c, price, newAPIErrorf(CodeChainUnsupported,
+		"Chain %q is not accepted; pick one of the offered payment contexts", raw))
+	return ChainOption{}, false
+}
+
+// paymentContextFor builds the context to sign for chain, with amount in
+// canonical form so every context and receipt for a price spells it alike.
+func paymentContextFor(chain ChainOption, amount, nonce string) PaymentContext {
+	return PaymentContext{
+		Recipient: chain.Recipient,
+		Token:     "USDC",
+		Amount:    payments.CanonicalAmount(amount, "USDC"),
+		Nonce:     nonce,
+		ChainID:   chain.ChainID,
+	}
+}
+
+// negotiatePayment settles the terms a paid request is verified against: the
+// chain it pays on and, if one was echoed, the signed quote. It aborts with a
+// 402 and returns false when either is not acceptable.
+func negotiatePayment(c *gin.Context, nonce, price string) (ChainOption, bool) {
+	chain, ok := selectPaymentChain(c, price)
+	if !ok || !checkChallenge(c, nonce, price) || !checkQuote(c, chain, nonce, price) {
+		return ChainOption{}, false
+	}
+	return chain, true
+}
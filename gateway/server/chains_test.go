@@ -0,0 +1,140 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"gateway/internal/testsupport"
+	"gateway/payments"
+
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+const (
+	testPrimaryRecipient  = "0x2cAF48b4BA1C58721a85dFADa5aC01C2DFa62219"
+	testOptimismRecipient = "0x1111111111111111111111111111111111111111"
+)
+
+func TestParseAcceptedChains(t *testing.T) {
+	chains, err := parseAcceptedChains([]string{"optimism:" + testOptimismRecipient, "arbitrum", "137"}, 8453, testPrimaryRecipient)
+	if err != nil {
+		t.Fatalf("parseAcceptedChains failed: %v", err)
+	}
+	want := []ChainOption{
+		{ChainID: 8453, Name: "base", Recipient: testPrimaryRecipient},
+		{ChainID: 10, Name: "optimism", Recipient: testOptimismRecipient},
+		{ChainID: 42161, Name: "arbitrum", Recipient: testPrimaryRecipient},
+		{ChainID: 137, Name: "polygon", Recipient: testPrimaryRecipient},
+	}
+	if len(chains) != len(want) {
+		t.Fatalf("expected %d chains, got %+v", len(want), chains)
+	}
+	for i := range want {
+		if chains[i] != want[i] {
+			t.Errorf("chain %d: expected %+v, got %+v", i, want[i], chains[i])
+		}
+	}
+
+	for _, bad := range []string{"solana", "optimism:0xnope", "base", "-1"} {
+		if _, err := parseAcceptedChains([]string{bad}, 8453, testPrimaryRecipient); err == nil {
+			t.Errorf("expected %q to be rejected", bad)
+		}
+	}
+}
+
+// withChains accepts payment on Base and on Optimism to its own recipient.
+func withChains(t *testing.T) {
+	t.Helper()
+	t.Setenv("CHAIN_ID", "8453")
+	t.Setenv("RECIPIENT_ADDRESS", testPrimaryRecipient)
+	t.Setenv("ACCEPTED_CHAINS", "optimism:"+testOptimismRecipient)
+}
+
+// postOnChain sends a paid summarize request naming chainID.
+func postOnChain(t *testing.T, h *testsupport.Harness, nonce, chainID string) (int, paymentChallenge) {
+	t.Helper()
+	req, _ := http.NewRequest(http.MethodPost, h.Server.URL+"/api/ai/summarize", bytes.NewBufferString(`{"text":"hello"}`))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-402-Signature", "0xsig")
+	req.Header.Set("X-402-Nonce", nonce)
+	req.Header.Set("X-402-Chain-Id", chainID)
+	resp, err := h.Server.Client().Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	var body paymentChallenge
+	_ = json.NewDecoder(resp.Body).Decode(&body)
+	return resp.StatusCode, body
+}
+
+// paymentChallenge is the part of a 402 challenge the chain tests read.
+type paymentChallenge struct {
+	Error          string           `json:"error"`
+	PaymentContext PaymentContext   `json:"paymentContext"`
+	Accepts        []PaymentContext `json:"accepts"`
+}
+
+func TestChains_402OffersEveryChain(t *testing.T) {
+	withChains(t)
+	h := testsupport.NewHarness(t, newTestRouter)
+
+	resp := h.Post(t, "/api/ai/summarize", `{"text":"hello"}`, "", "")
+	if resp.StatusCode != http.StatusPaymentRequired {
+		t.Fatalf("expected 402, got %d", resp.StatusCode)
+	}
+	var challenge paymentChallenge
+	if err := json.NewDecoder(resp.Body).Decode(&challenge); err != nil {
+		t.Fatal(err)
+	}
+	if len(challenge.Accepts) != 2 {
+		t.Fatalf("expected 2 offers, got %+v", challenge.Accepts)
+	}
+	base, op := challenge.Accepts[0], challenge.Accepts[1]
+	if base != challenge.PaymentContext || base.ChainID != 8453 || base.Recipient != testPrimaryRecipient {
+		t.Errorf("expected the primary chain first and as paymentContext, got %+v", base)
+	}
+	if op.ChainID != 10 || op.Recipient != testOptimismRecipient || op.Nonce != base.Nonce {
+		t.Errorf("expected an Optimism offer sharing the nonce, got %+v", op)
+	}
+
+	key, _ := getServerPrivateKey()
+	signer, err := payments.RecoverQuoteSigner(op)
+	if err != nil || signer != crypto.PubkeyToAddress(key.PublicKey) {
+		t.Errorf("each offer should carry its own quote signature, got %s (%v)", signer.Hex(), err)
+	}
+}
+
+func TestChains_VerifiesAgainstChosenChain(t *testing.T) {
+	withChains(t)
+	h := testsupport.NewHarness(t, newTestRouter)
+
+	if code, body := postOnChain(t, h, "nonce-op", "10"); code != http.StatusOK {
+		t.Fatalf("expected 200, got %d %s", code, body.Error)
+	}
+	reqs := h.Verifier.Requests()
+	if len(reqs) != 1 {
+		t.Fatalf("expected 1 verifier call, got %d", len(reqs))
+	}
+	if got := reqs[0].Context; got.ChainID != 10 || got.Recipient != testOptimismRecipient {
+		t.Errorf("expected verification on Optimism to its recipient, got %+v", got)
+	}
+}
+
+func TestChains_UnsupportedChainIsRejected(t *testing.T) {
+	withChains(t)
+	h := testsupport.NewHarness(t, newTestRouter)
+
+	code, body := postOnChain(t, h, "nonce-poly", "137")
+	if code != http.StatusPaymentRequired || body.Error != "Unsupported Chain" {
+		t.Fatalf("expected 402 Unsupported Chain, got %d %s", code, body.Error)
+	}
+	if len(body.Accepts) != 2 {
+		t.Errorf("expected the accepted chains to be offered, got %+v", body.Accepts)
+	}
+	if h.Verifier.Calls() != 0 {
+		t.Error("verifier should not be called for an unsupported chain")
+	}
+}
@@ -0,0 +1,341 @@
+package server
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/redis/go-redis/v9"
+)
+
+// Redis key prefixes for issued challenges, by nonce, and for the challenge
+// last offered to a client, by client key.
+const (
+	challengeKeyPrefix       = "payment:challenge:"
+	challengeClientKeyPrefix = "payment:challenge:client:"
+)
+
+// ChallengeConfig controls persisted payment challenges. An issued challenge
+// can be paid for TTL. A positive CacheTTL makes 402 responses offer the
+// same challenge to a client retrying the same endpoint and price. With
+// Required set, paid requests must use a nonce the gateway issued.
+type ChallengeConfig struct {
+	TTL      time.Duration
+	CacheTTL time.Duration
+	Required bool
+}
+
+// PaymentChallenge is an issued nonce with the price it was offered at and
+// the payment contexts to sign, one per accepted chain.
+type PaymentChallenge struct {
+	Nonce          string           `json:"nonce"`
+	Endpoint       string           `json:"endpoint"`
+	Price          string           `json:"price"`
+	ExpiresAt      time.Time        `json:"expires_at"`
+	PaymentContext PaymentContext   `json:"paymentContext"`
+	Accepts        []PaymentContext `json:"accepts"`
+}
+
+// clientChallenge is the in-memory entry of the 402 challenge cache.
+type clientChallenge struct {
+	nonce     string
+	expiresAt time.Time
+}
+
+var (
+	challengesMu     sync.Mutex
+	challenges       = make(map[string]PaymentChallenge)
+	clientChallenges = make(map[string]clientChallenge)
+)
+
+// newPaymentChallenge builds a challenge for price on endpoint with a fresh
+// nonce. Its quotes expire with the challenge.
+func newPaymentChallenge(endpoint, price string, ttl time.Duration) PaymentChallenge {
+	ch := PaymentChallenge{
+		Nonce:     uuid.New().String(),
+		Endpoint:  endpoint,
+		Price:     price,
+		ExpiresAt: time.Now().Add(ttl).UTC().Truncate(time.Second),
+	}
+	cfg := getConfig()
+	chains := cfg.Chains
+	if len(chains) == 0 {
+		chains = []ChainOption{cfg.PrimaryChain()}
+	}
+	for _, chain := range chains {
+		payment := paymentContextFor(chain, price, ch.Nonce)
+		signQuoteUntil(&payment, ch.ExpiresAt)
+		ch.Accepts = append(ch.Accepts, payment)
+	}
+	ch.PaymentContext = ch.Accepts[0]
+	return ch
+}
+
+// storeChallenge keeps ch until it is paid or expires, in Redis when it is
+// connected so any replica accepts it.
+func storeChallenge(ctx context.Context, ch PaymentChallenge) error {
+	ttl := time.Until(ch.ExpiresAt)
+	if redisClient != nil {
+		data, err := json.Marshal(ch)
+		if err != nil {
+			return err
+		}
+		return redisClient.Set(ctx, challengeKeyPrefix+ch.Nonce, data, ttl).Err()
+	}
+
+	challengesMu.Lock()
+	defer challengesMu.Unlock()
+	now := time.Now()
+	for nonce, old := range challenges {
+		if now.After(old.ExpiresAt) {
+			delete(challenges, nonce)
+		}
+	}
+	for key, old := range clientChallenges {
+		if now.After(old.expiresAt) {
+			delete(clientChallenges, key)
+		}
+	}
+	challenges[ch.Nonce] = ch
+	return nil
+}
+
+// loadChallenge returns the unexpired, unpaid challenge for nonce, or nil.
+func loadChallenge(ctx context.Context, nonce string) (*PaymentChallenge, error) {
+	var ch PaymentChallenge
+	if redisClient != nil {
+		data, err := redisClient.Get(ctx, challengeKeyPrefix+nonce).Bytes()
+		if errors.Is(err, redis.Nil) {
+			return nil, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to load challenge: %w", err)
+		}
+		if err := json.Unmarshal(data, &ch); err != nil {
+			return nil, fmt.Errorf("invalid challenge %s: %w", nonce, err)
+		}
+	} else {
+		challengesMu.Lock()
+		stored, ok := challenges[nonce]
+		challengesMu.Unlock()
+		if !ok {
+			return nil, nil
+		}
+		ch = stored
+	}
+	if time.Now().After(ch.ExpiresAt) {
+		return nil, nil
+	}
+	return &ch, nil
+}
+
+// consumeChallenge deletes the challenge for nonce once it has been paid.
+func consumeChallenge(ctx context.Context, nonce string) {
+	if redisClient != nil {
+		if err := redisClient.Del(ctx, challengeKeyPrefix+nonce).Err(); err != nil {
+			log.Printf("[WARNING] Failed to consume payment challenge: %v", err)
+		}
+		return
+	}
+	challengesMu.Lock()
+	delete(challenges, nonce)
+	challengesMu.Unlock()
+}
+
+// challengeRegenerateParam is the query parameter with which a client asks
+// for a new 402 challenge instead of the one cached for it.
+const challengeRegenerateParam = "new_challenge"
+
+// challengeClientKey identifies a client asking for price on the request's
+// path, for the 402 challenge cache: its IP and, when it names one, its
+// X-402-Payer wallet, so wallets behind one NAT get their own challenges.
+func challengeClientKey(c *gin.Context, price string) string {
+	payer := strings.ToLower(c.GetHeader("X-402-Payer"))
+	sum := sha256.Sum256([]byte(c.ClientIP() + "\n" + payer + "\n" + c.Request.URL.Path + "\n" + price))
+	return hex.EncodeToString(sum[:16])
+}
+
+// cachedClientChallenge returns the challenge last offered for key and how
+// much longer it is cached, if it is still cached and unpaid.
+func cachedClientChallenge(ctx context.Context, key string) (*PaymentChallenge, time.Duration) {
+	var nonce string
+	var ttl time.Duration
+	if redisClient != nil {
+		pipe := redisClient.Pipeline()
+		get := pipe.Get(ctx, challengeClientKeyPrefix+key)
+		pttl := pipe.PTTL(ctx, challengeClientKeyPrefix+key)
+		pipe.Exec(ctx)
+		nonce, ttl = get.Val(), pttl.Val()
+	} else {
+		challengesMu.Lock()
+		if entry, ok := clientChallenges[key]; ok && time.Now().Before(entry.expiresAt) {
+			nonce, ttl = entry.nonce, time.Until(entry.expiresAt)
+		}
+		challengesMu.Unlock()
+	}
+	if nonce == "" || ttl <= 0 {
+		return nil, 0
+	}
+	ch, err := loadChallenge(ctx, nonce)
+	if err != nil {
+		log.Printf("[WARNING] Payment challenge cache: %v", err)
+		return nil, 0
+	}
+	if ch == nil {
+		return nil, 0
+	}
+	return ch, min(ttl, time.Until(ch.ExpiresAt))
+}
+
+// cacheClientChallenge offers nonce to key again for ttl.
+func cacheClientChallenge(ctx context.Context, key, nonce string, ttl time.Duration) {
+	if redisClient != nil {
+		if err := redisClient.Set(ctx, challengeClientKeyPrefix+key, nonce, ttl).Err(); err != nil {
+			log.Printf("[WARNING] Failed to cache payment challenge: %v", err)
+		}
+		return
+	}
+	challengesMu.Lock()
+	clientChallenges[key] = clientChallenge{nonce: nonce, expiresAt: time.Now().Add(ttl)}
+	challengesMu.Unlock()
+}
+
+// wantsNewChallenge reports whether the request asks, with
+// ?new_challenge=true, for a new challenge rather than the cached one.
+func wantsNewChallenge(c *gin.Context) bool {
+	regenerate, _ := strconv.ParseBool(c.Query(challengeRegenerateParam))
+	return regenerate
+}
+
+// isUnauthenticated reports whether the request carries no payment at all,
+// so a 402 for it may offer the client's cached challenge.
+func isUnauthenticated(c *gin.Context) bool {
+	return c.GetHeader("X-402-Signature") == "" && c.GetHeader("X-PAYMENT") == ""
+}
+
+// challengeContexts returns the payment contexts a 402 for price offers and
+// sets its Cache-Control. Without challenge caching or
+// PAYMENT_CHALLENGE_REQUIRED every 402 gets a fresh, unrecorded nonce as
+// before; otherwise the challenge is persisted and, with caching, offered
+// again to the same client's unauthenticated requests until it is paid, the
+// cache window ends or the client asks for a new one with ?new_challenge.
+// A cached challenge may be kept by the client for the rest of its window
+// (private, max-age); any other 402 must not be stored.
+func challengeContexts(c *gin.Context, price string) []PaymentContext {
+	cfg := getConfig().Challenges
+	cacheable := cfg.CacheTTL > 0 && isUnauthenticated(c)
+	if cacheable {
+		c.Header("Vary", "X-402-Payer")
+	} else {
+		c.Header("Cache-Control", "no-store")
+	}
+	if cfg.CacheTTL <= 0 && !cfg.Required {
+		return createPaymentContexts(price)
+	}
+	ctx := c.Request.Context()
+	key := challengeClientKey(c, price)
+	if cacheable && !wantsNewChallenge(c) {
+		if ch, ttl := cachedClientChallenge(ctx, key); ch != nil {
+			setChallengeMaxAge(c, ttl)
+			return ch.Accepts
+		}
+	}
+	ch := newPaymentChallenge(c.Request.URL.Path, price, cfg.TTL)
+	if err := storeChallenge(ctx, ch); err != nil {
+		log.Printf("[WARNING] Failed to store payment challenge: %v", err)
+		c.Header("Cache-Control", "no-store")
+		return ch.Accepts
+	}
+	if cacheable {
+		ttl := min(cfg.CacheTTL, cfg.TTL)
+		cacheClientChallenge(ctx, key, ch.Nonce, ttl)
+		setChallengeMaxAge(c, ttl)
+	}
+	return ch.Accepts
+}
+
+// setChallengeMaxAge lets the client reuse a cached 402 for the ttl left.
+func setChallengeMaxAge(c *gin.Context, ttl time.Duration) {
+	c.Header("Cache-Control", "private, max-age="+strconv.Itoa(int(ttl/time.Second)))
+}
+
+// challengeEndpoint names the paid endpoint of a challenge's Endpoint or a
+// request path: "summarize" for both "/api/v2/ai/summarize" and the name
+// POST /api/payment/challenge records. Jobs are summaries, so they are paid
+// with summarize challenges.
+func challengeEndpoint(path string) string {
+	name := strings.TrimPrefix(strings.TrimPrefix(path, "/api/v2/ai/"), "/api/ai/")
+	if name == "jobs" {
+		return "summarize"
+	}
+	return name
+}
+
+// checkChallenge checks a paid request's nonce against the challenge it was
+// issued with, if any: the request must be to the challenge's endpoint and
+// be charged its price.
+// With PAYMENT_CHALLENGE_REQUIRED the nonce must belong to an unexpired,
+// unpaid challenge. On failure it aborts with 402 and a fresh challenge.
+func checkChallenge(c *gin.Context, nonce, price string) bool {
+	ch, err := loadChallenge(c.Request.Context(), nonce)
+	if err != nil {
+		log.Printf("[WARNING] Payment challenge lookup failed: %v", err)
+	}
+	if ch == nil {
+		if !getConfig().Challenges.Required {
+			return true
+		}
+		respondPaymentRequired(c, price, newAPIError(CodeChallengeUnknown,
+			"The nonce was not issued by this gateway, has expired or was already paid; sign a new payment context"))
+		return false
+	}
+	if challengeEndpoint(ch.Endpoint) != challengeEndpoint(c.Request.URL.Path) {
+		respondPaymentRequired(c, price, newAPIErrorf(CodeChallengeMismatch,
+			"The challenge was issued for %s, not %s", ch.Endpoint, c.Request.URL.Path))
+		return false
+	}
+	issued, errIssued := parseTokenAmount(ch.Price)
+	charged, errCharged := parseTokenAmount(price)
+	if errIssued != nil || errCharged != nil || issued != charged {
+		respondPaymentRequired(c, price, newAPIErrorf(CodeChallengeMismatch,
+			"The challenge was issued for %s but this request costs %s", ch.Price, price))
+		return false
+	}
+	c.Set("payment_challenge", ch)
+	return true
+}
+
+// handleCreateChallenge handles POST /api/payment/challenge. It prices the
+// request described by the body, like POST /api/ai/estimate, and issues a
+// persisted challenge for it that can be signed and paid until it expires,
+// so clients need not race a fresh 402 nonce.
+func handleCreateChallenge(c *gin.Context) {
+	req, ok := bindEstimateRequest(c)
+	if !ok {
+		return
+	}
+	cfg := getConfig()
+	est, err := estimateCost(cfg, req)
+	if err != nil {
+		respondError(c, CodeInvalidRequest, err.Error())
+		return
+	}
+	ch := newPaymentChallenge(est.Endpoint, est.Price, cfg.Challenges.TTL)
+	if err := storeChallenge(c.Request.Context(), ch); err != nil {
+		log.Printf("[WARNING] Failed to store payment challenge: %v", err)
+		respondError(c, CodeServiceUnavailable, "Payment challenge could not be stored")
+		return
+	}
+	setPriceHeader(c, ch.Price)
+	c.JSON(201, ch)
+}
@@ -0,0 +1,226 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"gateway/internal/testsupport"
+)
+
+// withChallenges gives the test an empty in-memory challenge store.
+func withChallenges(t *testing.T) {
+	t.Helper()
+	challengesMu.Lock()
+	prev, prevClients := challenges, clientChallenges
+	challenges = make(map[string]PaymentChallenge)
+	clientChallenges = make(map[string]clientChallenge)
+	challengesMu.Unlock()
+	t.Cleanup(func() {
+		challengesMu.Lock()
+		challenges, clientChallenges = prev, prevClients
+		challengesMu.Unlock()
+	})
+}
+
+// issueChallenge calls POST /api/payment/challenge for body.
+func issueChallenge(t *testing.T, h *testsupport.Harness, body string) PaymentChallenge {
+	t.Helper()
+	resp := h.Post(t, "/api/payment/challenge", body, "", "")
+	if resp.StatusCode != http.StatusCreated {
+		t.Fatalf("expected 201, got %d", resp.StatusCode)
+	}
+	var ch PaymentChallenge
+	if err := json.NewDecoder(resp.Body).Decode(&ch); err != nil {
+		t.Fatal(err)
+	}
+	return ch
+}
+
+// challengeNonce returns the nonce and error of a 402 response.
+func challengeNonce(t *testing.T, resp *http.Response) (nonce, reason string) {
+	t.Helper()
+	if resp.StatusCode != http.StatusPaymentRequired {
+		t.Fatalf("expected 402, got %d", resp.StatusCode)
+	}
+	var body struct {
+		Error          string         `json:"error"`
+		PaymentContext PaymentContext `json:"paymentContext"`
+	}
+	json.NewDecoder(resp.Body).Decode(&body)
+	return body.PaymentContext.Nonce, body.Error
+}
+
+func TestPaymentChallenge_IssuedAndPaidOnce(t *testing.T) {
+	withChallenges(t)
+	h := testsupport.NewHarness(t, newTestRouter)
+
+	ch := issueChallenge(t, h, `{"text":"hello"}`)
+	if ch.Nonce == "" || ch.Price != getConfig().PaymentAmount || ch.Endpoint != "summarize" {
+		t.Fatalf("unexpected challenge %+v", ch)
+	}
+	if left := time.Until(ch.ExpiresAt); left < 14*time.Minute || left > 15*time.Minute {
+		t.Errorf("expected the default 15 minute expiry, got %v", left)
+	}
+	if ch.PaymentContext.Nonce != ch.Nonce || ch.PaymentContext.Expiry != ch.ExpiresAt.Unix() || ch.PaymentContext.QuoteSignature == "" {
+		t.Errorf("expected a quote signed until the challenge expires, got %+v", ch.PaymentContext)
+	}
+
+	if resp := h.Post(t, "/api/ai/summarize", `{"text":"hello"}`, "0xsig", ch.Nonce); resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected the challenge to be paid, got %d", resp.StatusCode)
+	}
+	if stored, _ := loadChallenge(t.Context(), ch.Nonce); stored != nil {
+		t.Error("expected a paid challenge to be consumed")
+	}
+
+	t.Setenv("PAYMENT_CHALLENGE_REQUIRED", "true")
+	verifications := h.Verifier.Calls()
+	if _, reason := challengeNonce(t, h.Post(t, "/api/ai/summarize", `{"text":"hello"}`, "0xsig", ch.Nonce)); reason != "Unknown Challenge" {
+		t.Errorf("expected a paid challenge to be rejected, got %q", reason)
+	}
+	if h.Verifier.Calls() != verifications {
+		t.Error("an unknown challenge should be rejected before verification")
+	}
+}
+
+func TestPaymentChallenge_PriceMismatch(t *testing.T) {
+	withChallenges(t)
+	h := testsupport.NewHarness(t, newTestRouter)
+	ch := issueChallenge(t, h, `{"text":"hello"}`)
+
+	t.Setenv("PAYMENT_AMOUNT", "0.002")
+	if _, reason := challengeNonce(t, h.Post(t, "/api/ai/summarize", `{"text":"hello"}`, "0xsig", ch.Nonce)); reason != "Challenge Mismatch" {
+		t.Errorf("expected a price mismatch, got %q", reason)
+	}
+
+	for _, body := range []string{`{"text":""}`, `{"text":"x","endpoint":"unknown"}`, `not json`} {
+		if resp := h.Post(t, "/api/payment/challenge", body, "", ""); resp.StatusCode != http.StatusBadRequest {
+			t.Errorf("%s: expected 400, got %d", body, resp.StatusCode)
+		}
+	}
+}
+
+func TestPaymentChallenge_EndpointMismatch(t *testing.T) {
+	withChallenges(t)
+	h := testsupport.NewHarness(t, newTestRouter)
+	// Price summaries like the embedding so only the endpoint differs.
+	t.Setenv("PAYMENT_AMOUNT", embeddingPrice(getConfig(), estimateTokens([]string{"hello"})))
+	ch := issueChallenge(t, h, `{"text":"hello"}`)
+
+	if _, reason := challengeNonce(t, h.Post(t, "/api/ai/embed", `{"input":"hello"}`, "0xsig", ch.Nonce)); reason != "Challenge Mismatch" {
+		t.Errorf("expected a summarize challenge to be refused on embed, got %q", reason)
+	}
+	if h.Verifier.Calls() != 0 {
+		t.Error("a challenge for another endpoint should be rejected before verification")
+	}
+	if resp := h.Post(t, "/api/v2/ai/summarize", `{"input":"hello"}`, "0xsig", ch.Nonce); resp.StatusCode != http.StatusOK {
+		t.Errorf("expected the challenge to pay for summarize on v2, got %d", resp.StatusCode)
+	}
+}
+
+func TestPaymentChallenge_RequiredAcceptsIssuedNonces(t *testing.T) {
+	withChallenges(t)
+	t.Setenv("PAYMENT_CHALLENGE_REQUIRED", "true")
+	h := testsupport.NewHarness(t, newTestRouter)
+
+	if _, reason := challengeNonce(t, h.Post(t, "/api/ai/summarize", `{"text":"hello"}`, "0xsig", "client-made-nonce")); reason != "Unknown Challenge" {
+		t.Errorf("expected a client-made nonce to be rejected, got %q", reason)
+	}
+	// The nonce of a plain 402 is persisted and can be paid.
+	nonce, _ := challengeNonce(t, h.Post(t, "/api/ai/summarize", `{"text":"hello"}`, "", ""))
+	if resp := h.Post(t, "/api/ai/summarize", `{"text":"hello"}`, "0xsig", nonce); resp.StatusCode != http.StatusOK {
+		t.Errorf("expected the 402 nonce to be accepted, got %d", resp.StatusCode)
+	}
+}
+
+func TestPaymentChallenge_Cached402(t *testing.T) {
+	withChallenges(t)
+	h := testsupport.NewHarness(t, newTestRouter)
+
+	first, _ := challengeNonce(t, h.Post(t, "/api/ai/summarize", `{"text":"hello"}`, "", ""))
+	second, _ := challengeNonce(t, h.Post(t, "/api/ai/summarize", `{"text":"hello"}`, "", ""))
+	if first == second {
+		t.Error("expected a fresh nonce per 402 without caching")
+	}
+
+	t.Setenv("PAYMENT_CHALLENGE_CACHE_SECONDS", "60")
+	first, _ = challengeNonce(t, h.Post(t, "/api/ai/summarize", `{"text":"hello"}`, "", ""))
+	second, _ = challengeNonce(t, h.Post(t, "/api/ai/summarize", `{"text":"hello"}`, "", ""))
+	if first != second {
+		t.Errorf("expected the cached challenge to be offered again, got %s and %s", first, second)
+	}
+
+	// Once paid, the client is offered a new one.
+	h.Post(t, "/api/ai/summarize", `{"text":"hello"}`, "0xsig", first)
+	if third, _ := challengeNonce(t, h.Post(t, "/api/ai/summarize", `{"text":"hello"}`, "", "")); third == first {
+		t.Error("expected a new challenge after the cached one was paid")
+	}
+}
+
+func TestPaymentChallenge_CacheControlAndRegeneration(t *testing.T) {
+	withChallenges(t)
+	h := testsupport.NewHarness(t, newTestRouter)
+
+	resp := h.Post(t, "/api/ai/summarize", `{"text":"hello"}`, "", "")
+	if got := resp.Header.Get("Cache-Control"); got != "no-store" {
+		t.Errorf("expected an uncached 402 to be no-store, got %q", got)
+	}
+
+	t.Setenv("PAYMENT_CHALLENGE_CACHE_SECONDS", "60")
+	resp = h.Post(t, "/api/ai/summarize", `{"text":"hello"}`, "", "")
+	if got := resp.Header.Get("Cache-Control"); got != "private, max-age=60" {
+		t.Errorf("expected a cacheable 402, got Cache-Control %q", got)
+	}
+	first, _ := challengeNonce(t, resp)
+
+	resp = h.Post(t, "/api/ai/summarize", `{"text":"hello"}`, "", "")
+	if got := resp.Header.Get("Cache-Control"); !strings.HasPrefix(got, "private, max-age=") {
+		t.Errorf("expected the reused 402 to be cacheable, got %q", got)
+	}
+	if nonce, _ := challengeNonce(t, resp); nonce != first {
+		t.Errorf("expected the cached challenge, got %s", nonce)
+	}
+
+	regenerated, _ := challengeNonce(t, h.Post(t, "/api/ai/summarize?new_challenge=true", `{"text":"hello"}`, "", ""))
+	if regenerated == first {
+		t.Fatal("expected ?new_challenge to issue a new challenge")
+	}
+	if again, _ := challengeNonce(t, h.Post(t, "/api/ai/summarize", `{"text":"hello"}`, "", "")); again != regenerated {
+		t.Errorf("expected the regenerated challenge to replace the cached one, got %s", again)
+	}
+
+	// Another wallet from the same IP gets its own challenge.
+	req, _ := http.NewRequest(http.MethodPost, h.Server.URL+"/api/ai/summarize", strings.NewReader(`{"text":"hello"}`))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-402-Payer", "0x00000000000000000000000000000000000000aa")
+	resp, err := h.Server.Client().Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if other, _ := challengeNonce(t, resp); other == regenerated {
+		t.Error("expected a separate challenge for another X-402-Payer")
+	}
+	if got := resp.Header.Get("Vary"); got != "X-402-Payer" {
+		t.Errorf("expected Vary: X-402-Payer, got %q", got)
+	}
+
+	// A rejected payment is answered with a fresh, uncacheable challenge.
+	resp = h.Post(t, "/api/ai/summarize", `{"text":"hello"}`, "0xbad", "unknown-nonce")
+	if resp.StatusCode == http.StatusPaymentRequired && resp.Header.Get("Cache-Control") != "no-store" {
+		t.Errorf("expected a paid request's 402 to be no-store, got %q", resp.Header.Get("Cache-Control"))
+	}
+}
+
+func TestChallengeConfig_Validate(t *testing.T) {
+	for env, value := range map[string]string{"PAYMENT_CHALLENGE_TTL_SECONDS": "0", "PAYMENT_CHALLENGE_CACHE_SECONDS": "-1"} {
+		t.Run(env, func(t *testing.T) {
+			t.Setenv(env, value)
+			if err := loadConfig().Validate(); err == nil {
+				t.Errorf("expected %s=%s to be rejected", env, value)
+			}
+		})
+	}
+}
@@ -0,0 +1,198 @@
+package server
+
+import (
+	"log"
+	"math"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Circuit breaker states.
+const (
+	circuitClosed   = "closed"
+	circuitOpen     = "open"
+	circuitHalfOpen = "half-open"
+)
+
+// Degraded modes, DEGRADED_MODE: how cache hits are served while the
+// provider circuit is open. Off rejects every AI request.
+const (
+	degradedOff       = "off"
+	degradedFullPrice = "full-price"
+	degradedDiscount  = "discount"
+	degradedFree      = "free"
+)
+
+// circuitBreaker stops calling the AI provider after a run of consecutive
+// failures. Once the cooldown has passed one probe request is let through
+// (half-open): success closes the circuit, failure opens it again. A probe
+// that never reports back (e.g. its payment was rejected) is replaced after
+// another cooldown.
+type circuitBreaker struct {
+	mu           sync.Mutex
+	failures     int
+	openedAt     time.Time
+	probeStarted time.Time
+
+	// Outage-mode traffic, for readiness reporting.
+	outageHits     atomic.Int64
+	outageRejected atomic.Int64
+}
+
+// providerCircuit guards calls to OpenRouter.
+var providerCircuit = &circuitBreaker{}
+
+// Allow reports whether a request may call the provider now. In the
+// half-open state only one probe is admitted per cooldown.
+func (b *circuitBreaker) Allow(cfg *Config) bool {
+	if cfg.ProviderCircuit.Threshold <= 0 {
+		return true
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	now := time.Now()
+	switch b.stateLocked(cfg, now) {
+	case circuitClosed:
+		return true
+	case circuitHalfOpen:
+		if b.probeStarted.IsZero() || now.Sub(b.probeStarted) >= cfg.ProviderCircuit.Cooldown {
+			b.probeStarted = now
+			return true
+		}
+	}
+	return false
+}
+
+// Record stores the outcome of a provider call.
+func (b *circuitBreaker) Record(cfg *Config, failed bool) {
+	if cfg.ProviderCircuit.Threshold <= 0 {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if !failed {
+		if !b.openedAt.IsZero() {
+			log.Printf("AI provider recovered, closing circuit after %s", time.Since(b.openedAt).Round(time.Second))
+		}
+		b.failures = 0
+		b.openedAt = time.Time{}
+		b.probeStarted = time.Time{}
+		return
+	}
+	b.failures++
+	if b.failures >= cfg.ProviderCircuit.Threshold {
+		if b.openedAt.IsZero() {
+			log.Printf("[WARNING] AI provider failed %d times in a row, opening circuit", b.failures)
+		}
+		b.openedAt = time.Now()
+		b.probeStarted = time.Time{}
+	}
+}
+
+// State returns closed, open or half-open.
+func (b *circuitBreaker) State(cfg *Config) string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.stateLocked(cfg, time.Now())
+}
+
+func (b *circuitBreaker) stateLocked(cfg *Config, now time.Time) string {
+	switch {
+	case cfg.ProviderCircuit.Threshold <= 0 || b.openedAt.IsZero():
+		return circuitClosed
+	case now.Sub(b.openedAt) < cfg.ProviderCircuit.Cooldown:
+		return circuitOpen
+	default:
+		return circuitHalfOpen
+	}
+}
+
+// RetryAfter is how long until the next probe may be attempted.
+func (b *circuitBreaker) RetryAfter(cfg *Config) time.Duration {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	start := b.openedAt
+	if b.probeStarted.After(start) {
+		start = b.probeStarted
+	}
+	if wait := cfg.ProviderCircuit.Cooldown - time.Since(start); wait > 0 {
+		return wait
+	}
+	return 0
+}
+
+// Status summarizes the breaker and outage-mode traffic for /readyz.
+func (b *circuitBreaker) Status(cfg *Config) gin.H {
+	b.mu.Lock()
+	state := b.stateLocked(cfg, time.Now())
+	failures := b.failures
+	b.mu.Unlock()
+	if cfg.ProviderCircuit.Threshold <= 0 {
+		state = "disabled"
+	}
+	return gin.H{
+		"state":                   state,
+		"consecutive_failures":    failures,
+		"cached_only":             cfg.ProviderCircuit.CachedOnly,
+		"degraded_mode":           cfg.ProviderCircuit.DegradedMode,
+		"outage_cache_hits_total": b.outageHits.Load(),
+		"outage_rejected_total":   b.outageRejected.Load(),
+	}
+}
+
+// rejectProviderOutage answers a request that needs the provider while the
+// circuit is open. No payment has been verified or charged at this point.
+func rejectProviderOutage(c *gin.Context, cfg *Config) {
+	providerCircuit.outageRejected.Add(1)
+	message := "The AI provider is unavailable. Please retry later."
+	if cfg.ProviderCircuit.CachedOnly {
+		message = "The AI provider is unavailable; only cached responses are being served. Please retry later."
+	}
+	retryAfter := int(providerCircuit.RetryAfter(cfg).Round(time.Second).Seconds())
+	c.Header("Retry-After", strconv.Itoa(max(retryAfter, 1)))
+	abortWithAPIError(c, newAPIError(CodeAIUnavailable, message).with(gin.H{"cached_only": cfg.ProviderCircuit.CachedOnly}))
+}
+
+// degradedServing reports whether cache hits are being served while the
+// provider circuit is open.
+func degradedServing(cfg *Config) bool {
+	return cfg.ProviderCircuit.CachedOnly && providerCircuit.State(cfg) != circuitClosed
+}
+
+// degradedPrice is what a cache hit served while the circuit is open costs
+// under DEGRADED_MODE: price itself, price scaled by DEGRADED_PRICE_FACTOR
+// (rounded up to a whole token unit), or "" when the hit is free.
+func degradedPrice(cfg *Config, price string) string {
+	switch cfg.ProviderCircuit.DegradedMode {
+	case degradedFree:
+		return ""
+	case degradedDiscount:
+		units, err := parseTokenAmount(price)
+		if err != nil {
+			return price
+		}
+		return formatTokenAmount(max(int64(math.Ceil(float64(units)*cfg.ProviderCircuit.DegradedPriceFactor)), 1))
+	}
+	return price
+}
+
+// markDegraded flags a response as a cache hit served while the provider
+// is down.
+func markDegraded(c *gin.Context) {
+	c.Header("X-Outage-Mode", "cached-only")
+	c.Header("X-Cache-Degraded", "true")
+}
+
+// serveDegradedFree answers with a cached response, encoded as contentType,
+// without charging for it or issuing a receipt, as DEGRADED_MODE=free does
+// while the circuit is open.
+func serveDegradedFree(c *gin.Context, contentType string, body []byte) {
+	providerCircuit.outageHits.Add(1)
+	setPriceHeader(c, "0")
+	c.Data(200, contentType, body)
+	c.Abort()
+}
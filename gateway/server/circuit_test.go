@@ -0,0 +1,249 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"gateway/internal/testsupport"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// withCircuit installs a fresh provider circuit breaker for the test.
+func withCircuit(t *testing.T) {
+	t.Helper()
+	prev := providerCircuit
+	providerCircuit = &circuitBreaker{}
+	t.Cleanup(func() { providerCircuit = prev })
+}
+
+func circuitConfig(threshold int, cooldown time.Duration) *Config {
+	return &Config{ProviderCircuit: CircuitBreakerConfig{Threshold: threshold, Cooldown: cooldown, CachedOnly: true}}
+}
+
+func TestCircuitBreaker_OpensAfterThresholdAndProbes(t *testing.T) {
+	b := &circuitBreaker{}
+	cfg := circuitConfig(2, 50*time.Millisecond)
+
+	b.Record(cfg, true)
+	if b.State(cfg) != circuitClosed || !b.Allow(cfg) {
+		t.Fatal("one failure should not open the circuit")
+	}
+	b.Record(cfg, true)
+	if b.State(cfg) != circuitOpen || b.Allow(cfg) {
+		t.Fatal("circuit should be open after threshold failures")
+	}
+
+	time.Sleep(60 * time.Millisecond)
+	if b.State(cfg) != circuitHalfOpen {
+		t.Fatalf("expected half-open after cooldown, got %s", b.State(cfg))
+	}
+	if !b.Allow(cfg) {
+		t.Fatal("first request after cooldown should be admitted as a probe")
+	}
+	if b.Allow(cfg) {
+		t.Fatal("only one probe should be admitted")
+	}
+
+	b.Record(cfg, true)
+	if b.State(cfg) != circuitOpen {
+		t.Fatal("failed probe should reopen the circuit")
+	}
+
+	time.Sleep(60 * time.Millisecond)
+	b.Allow(cfg)
+	b.Record(cfg, false)
+	if b.State(cfg) != circuitClosed || !b.Allow(cfg) {
+		t.Fatal("successful probe should close the circuit")
+	}
+}
+
+func TestCircuitBreaker_Disabled(t *testing.T) {
+	b := &circuitBreaker{}
+	cfg := circuitConfig(0, time.Minute)
+	for i := 0; i < 10; i++ {
+		b.Record(cfg, true)
+	}
+	if !b.Allow(cfg) || b.Status(cfg)["state"] != "disabled" {
+		t.Error("threshold 0 should disable the breaker")
+	}
+}
+
+func TestCircuit_OpensOnProviderFailuresAndFailsFast(t *testing.T) {
+	withCircuit(t)
+	t.Setenv("PROVIDER_CIRCUIT_THRESHOLD", "2")
+	t.Setenv("PROVIDER_CIRCUIT_COOLDOWN_SECONDS", "60")
+	h := testsupport.NewHarness(t, newTestRouter)
+	h.AI.SetStatus(http.StatusInternalServerError)
+
+	for i := 0; i < 2; i++ {
+		if resp := h.Post(t, "/api/ai/summarize", `{"text":"hello"}`, "0xsig", fmt.Sprintf("nonce-circuit-%d", i)); resp.StatusCode != http.StatusInternalServerError {
+			t.Fatalf("expected 500 from failing provider, got %d", resp.StatusCode)
+		}
+	}
+
+	verifierCalls, aiCalls := h.Verifier.Calls(), h.AI.Calls()
+	resp := h.Post(t, "/api/ai/summarize", `{"text":"hello"}`, "0xsig", "nonce-circuit-open")
+	if resp.StatusCode != http.StatusServiceUnavailable {
+		t.Fatalf("expected 503 while circuit is open, got %d", resp.StatusCode)
+	}
+	if resp.Header.Get("Retry-After") == "" {
+		t.Error("expected Retry-After header")
+	}
+	if h.Verifier.Calls() != verifierCalls || h.AI.Calls() != aiCalls {
+		t.Error("open circuit should reject before verifying payment or calling the provider")
+	}
+
+	var body map[string]any
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		t.Fatal(err)
+	}
+	if body["error"] != "AI Provider Unavailable" || body["cached_only"] != true {
+		t.Errorf("unexpected outage body %v", body)
+	}
+
+	status := providerCircuit.Status(getConfig())
+	if status["state"] != circuitOpen || status["outage_rejected_total"] != int64(1) {
+		t.Errorf("unexpected circuit status %v", status)
+	}
+}
+
+func TestCircuit_CachedOnlyServesHitsDuringOutage(t *testing.T) {
+	rdb := redis.NewClient(&redis.Options{Addr: "127.0.0.1:6379"})
+	if err := rdb.Ping(context.Background()).Err(); err != nil {
+		t.Skipf("Redis unavailable, skipping integration test: %v", err)
+	}
+	rdb.Close()
+
+	withCircuit(t)
+	t.Setenv("CACHE_ENABLED", "true")
+	t.Setenv("REDIS_URL", "127.0.0.1:6379")
+	t.Setenv("PROVIDER_CIRCUIT_THRESHOLD", "1")
+	t.Setenv("PROVIDER_CIRCUIT_COOLDOWN_SECONDS", "60")
+	h := testsupport.NewHarness(t, newTestRouter)
+	initRedis()
+	t.Cleanup(func() {
+		if redisClient != nil {
+			redisClient.Close()
+			redisClient = nil
+		}
+	})
+	router := newTestRouter()
+	post := func(text string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodPost, "/api/ai/summarize", strings.NewReader(`{"text":"`+text+`"}`))
+		req.Header.Set("X-402-Signature", "0xsig")
+		req.Header.Set("X-402-Nonce", "nonce")
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	text := "outage-" + time.Now().Format(time.RFC3339Nano)
+	if w := post(text); w.Code != http.StatusOK {
+		t.Fatalf("expected 200 priming the cache, got %d", w.Code)
+	}
+	time.Sleep(100 * time.Millisecond) // cache writes are asynchronous
+
+	h.AI.SetStatus(http.StatusInternalServerError)
+	if w := post(text + "-miss"); w.Code != http.StatusInternalServerError {
+		t.Fatalf("expected provider failure, got %d", w.Code)
+	}
+
+	if w := post(text); w.Code != http.StatusOK || w.Header().Get("X-Outage-Mode") != "cached-only" {
+		t.Errorf("expected cached-only hit, got %d (mode %q)", w.Code, w.Header().Get("X-Outage-Mode"))
+	}
+	if w := post(text + "-other"); w.Code != http.StatusServiceUnavailable {
+		t.Errorf("expected 503 for cache miss during outage, got %d", w.Code)
+	}
+	if got := providerCircuit.Status(getConfig())["outage_cache_hits_total"]; got != int64(1) {
+		t.Errorf("expected 1 outage cache hit, got %v", got)
+	}
+
+	t.Setenv("OUTAGE_CACHED_ONLY", "false")
+	if w := post(text); w.Code != http.StatusServiceUnavailable {
+		t.Errorf("expected 503 for cache hit when cached-only mode is off, got %d", w.Code)
+	}
+}
+
+func TestDegradedPrice(t *testing.T) {
+	cfg := &Config{ProviderCircuit: CircuitBreakerConfig{DegradedMode: degradedDiscount, DegradedPriceFactor: 0.5}}
+	if got := degradedPrice(cfg, "0.001"); got != "0.0005" {
+		t.Errorf("expected half price, got %s", got)
+	}
+	if got := degradedPrice(cfg, "0.000003"); got != "0.000002" {
+		t.Errorf("expected the discount to round up to a whole unit, got %s", got)
+	}
+	cfg.ProviderCircuit.DegradedMode = degradedFree
+	if got := degradedPrice(cfg, "0.001"); got != "" {
+		t.Errorf("expected free hits, got %s", got)
+	}
+	cfg.ProviderCircuit.DegradedMode = degradedFullPrice
+	if got := degradedPrice(cfg, "0.001"); got != "0.001" {
+		t.Errorf("expected the full price, got %s", got)
+	}
+
+	t.Setenv("OUTAGE_CACHED_ONLY", "false")
+	if cfg := loadConfig(); cfg.ProviderCircuit.DegradedMode != degradedOff || cfg.ProviderCircuit.CachedOnly {
+		t.Errorf("expected OUTAGE_CACHED_ONLY=false to turn degraded mode off, got %q", cfg.ProviderCircuit.DegradedMode)
+	}
+	t.Setenv("DEGRADED_MODE", "bogus")
+	if err := loadConfig().Validate(); err == nil {
+		t.Error("expected an unknown DEGRADED_MODE to be rejected")
+	}
+	t.Setenv("DEGRADED_MODE", degradedDiscount)
+	t.Setenv("DEGRADED_PRICE_FACTOR", "0")
+	if err := loadConfig().Validate(); err == nil {
+		t.Error("expected a zero discount factor to be rejected")
+	}
+}
+
+func TestCircuit_DegradedModeDiscountsAndFreesCacheHits(t *testing.T) {
+	withCircuit(t)
+	withMemoryCache(t)
+	t.Setenv("PROVIDER_CIRCUIT_THRESHOLD", "1")
+	t.Setenv("PROVIDER_CIRCUIT_COOLDOWN_SECONDS", "60")
+	t.Setenv("DEGRADED_MODE", degradedDiscount)
+	t.Setenv("DEGRADED_PRICE_FACTOR", "0.5")
+	t.Setenv("PAYMENT_AMOUNT", "0.001")
+	h := testsupport.NewHarness(t, newTestRouter)
+
+	text := `{"text":"degraded outage"}`
+	if resp := h.Post(t, "/api/ai/summarize", text, "0xsig", "nonce-degraded-1"); resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected 200 priming the cache, got %d", resp.StatusCode)
+	}
+	time.Sleep(100 * time.Millisecond) // cache writes are asynchronous
+	h.AI.SetStatus(http.StatusInternalServerError)
+	h.Post(t, "/api/ai/summarize", `{"text":"degraded miss"}`, "0xsig", "nonce-degraded-2")
+
+	resp := h.Post(t, "/api/ai/summarize", text, "", "")
+	if resp.StatusCode != http.StatusPaymentRequired || resp.Header.Get("X-402-Price") != "0.0005" || resp.Header.Get("X-Cache-Degraded") != "true" {
+		t.Fatalf("expected a 402 for the discounted price, got %d (price %q)", resp.StatusCode, resp.Header.Get("X-402-Price"))
+	}
+	resp = h.Post(t, "/api/ai/summarize", text, "0xsig", "nonce-degraded-3")
+	if resp.StatusCode != http.StatusOK || resp.Header.Get("X-402-Price") != "0.0005" || resp.Header.Get("X-402-Receipt") == "" {
+		t.Errorf("expected a receipted hit at the discounted price, got %d (price %q)", resp.StatusCode, resp.Header.Get("X-402-Price"))
+	}
+
+	t.Setenv("DEGRADED_MODE", degradedFree)
+	resp = h.Post(t, "/api/ai/summarize", text, "", "")
+	var body map[string]interface{}
+	json.NewDecoder(resp.Body).Decode(&body)
+	if resp.StatusCode != http.StatusOK || resp.Header.Get("X-Cache-Degraded") != "true" || resp.Header.Get("X-402-Price") != "0" || body["result"] == "" {
+		t.Errorf("expected a free degraded hit, got %d %v", resp.StatusCode, body)
+	}
+	if resp.Header.Get("X-402-Receipt") != "" {
+		t.Error("expected no receipt for a free hit")
+	}
+	if got := providerCircuit.Status(getConfig())["outage_cache_hits_total"]; got != int64(2) {
+		t.Errorf("expected 2 outage cache hits, got %v", got)
+	}
+	if resp := h.Post(t, "/api/ai/summarize", `{"text":"degraded other"}`, "", ""); resp.StatusCode != http.StatusServiceUnavailable {
+		t.Errorf("expected 503 for a cache miss during the outage, got %d", resp.StatusCode)
+	}
+}
@@ -0,0 +1,271 @@
+package server
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// API versions. v1 is the original unversioned API: payment in the
+// X-402-Signature and X-402-Nonce headers and {"text": ...} bodies. v2 lives
+// under /api/v2, carries the payment in a single X-PAYMENT header (base64
+// JSON {"signature", "nonce"}) and names the body field "input". Either
+// route also takes a standard x402 X-PAYMENT payload; see x402.go.
+//
+// Handlers only understand the v1 shape. paymentHeaderMiddleware and
+// apiCompatMiddleware translate v2 requests into it, so each route accepts
+// either version's format, and apiCompatMiddleware records every v1 route
+// call and every v1 format used on a v2 route.
+const (
+	apiV1 = "v1"
+	apiV2 = "v2"
+
+	// Legacy features tracked for deprecation telemetry.
+	featureV1PaymentHeaders = "v1-payment-headers"
+	featureV1TextField      = "v1-text-field"
+	featureV1Route          = "v1-route"
+)
+
+// paymentHeaderV2 is the decoded X-PAYMENT header.
+type paymentHeaderV2 struct {
+	Signature string `json:"signature"`
+	Nonce     string `json:"nonce"`
+	// Optional signed quote echoed from the 402 paymentContext.
+	QuoteSignature string `json:"quoteSignature,omitempty"`
+	Expiry         int64  `json:"expiry,omitempty"`
+	// Chain the client picked from the 402 "accepts" list.
+	ChainID int `json:"chainId,omitempty"`
+	// Signature scheme and claimed payer; see X-402-Signature-Type.
+	SignatureType string `json:"signatureType,omitempty"`
+	Payer         string `json:"payer,omitempty"`
+	// Optional sponsor grant, encoded as in X-402-Sponsor-Grant, and the
+	// sponsor's signature of it.
+	SponsorGrant     string `json:"sponsorGrant,omitempty"`
+	SponsorSignature string `json:"sponsorSignature,omitempty"`
+}
+
+// paymentHeaderMiddleware translates an X-PAYMENT header, in either the
+// standard x402 or the v2 format, into the v1 X-402 headers. It runs on the
+// engine ahead of load shedding, abuse scoring, rate limiting and admission
+// so they see the payer the handlers will.
+func paymentHeaderMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		raw := c.GetHeader("X-PAYMENT")
+		if raw == "" || !isAIPath(c.Request.URL.Path) {
+			c.Next()
+			return
+		}
+		if standard, ok := decodeX402Payment(raw); ok {
+			if !applyX402Payment(c, standard) {
+				return
+			}
+			c.Next()
+			return
+		}
+		payment, err := decodePaymentHeaderV2(raw)
+		if err != nil {
+			abortWithError(c, CodeInvalidRequest, "X-PAYMENT must be base64-encoded JSON with signature and nonce")
+			return
+		}
+		c.Request.Header.Set("X-402-Signature", payment.Signature)
+		c.Request.Header.Set("X-402-Nonce", payment.Nonce)
+		if payment.QuoteSignature != "" {
+			c.Request.Header.Set("X-402-Quote-Signature", payment.QuoteSignature)
+			c.Request.Header.Set("X-402-Quote-Expiry", strconv.FormatInt(payment.Expiry, 10))
+		}
+		if payment.ChainID != 0 {
+			c.Request.Header.Set("X-402-Chain-Id", strconv.Itoa(payment.ChainID))
+		}
+		if payment.SignatureType != "" {
+			c.Request.Header.Set("X-402-Signature-Type", payment.SignatureType)
+		}
+		if payment.Payer != "" {
+			c.Request.Header.Set("X-402-Payer", payment.Payer)
+		}
+		if payment.SponsorGrant != "" {
+			c.Request.Header.Set("X-402-Sponsor-Grant", payment.SponsorGrant)
+			c.Request.Header.Set("X-402-Sponsor-Signature", payment.SponsorSignature)
+		}
+		c.Next()
+	}
+}
+
+// isAIPath reports whether path is under one of the AI route groups.
+func isAIPath(path string) bool {
+	return strings.HasPrefix(path, "/api/ai/") || strings.HasPrefix(path, "/api/v2/ai/")
+}
+
+// apiCompatMiddleware normalizes request bodies on a route of the given
+// version into the v1 shape the handlers expect, and marks deprecated usage
+// with Deprecation/Link response headers. Payment headers have already been
+// translated by paymentHeaderMiddleware.
+func apiCompatMiddleware(version string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var legacy []string
+
+		if version == apiV2 && c.GetHeader("X-PAYMENT") == "" && c.GetHeader("X-402-Signature") != "" {
+			legacy = append(legacy, featureV1PaymentHeaders)
+		}
+
+		// Body
+		if c.Request.Body != nil && c.Request.Method == http.MethodPost {
+			const maxBodySize = 10 * 1024 * 1024
+			c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, int64(maxBodySize))
+			original, err := io.ReadAll(c.Request.Body)
+			if err != nil {
+				var maxBytesErr *http.MaxBytesError
+				if errors.As(err, &maxBytesErr) {
+					abortWithAPIError(c, errPayloadTooLarge())
+				} else {
+					abortWithError(c, CodeBodyReadFailed, "The request body could not be read")
+				}
+				return
+			}
+			body, usedText := translateBodyToV1(original)
+			if usedText && version == apiV2 {
+				legacy = append(legacy, featureV1TextField)
+			}
+			if !bytes.Equal(body, original) {
+				// Receipts hash what the client actually sent.
+				c.Set("receipt_request_body", original)
+			}
+			c.Request.Body = io.NopCloser(bytes.NewReader(body))
+			c.Request.ContentLength = int64(len(body))
+		}
+
+		if version == apiV1 {
+			legacy = append(legacy, featureV1Route)
+		}
+
+		for _, feature := range legacy {
+			deprecations.Record(feature, apiClientID(c), c.FullPath())
+		}
+		if len(legacy) > 0 && version == apiV2 {
+			c.Header("Deprecation", "true")
+			c.Header("Link", `</docs>; rel="deprecation"`)
+			c.Header("X-API-Deprecated-Features", strings.Join(legacy, ", "))
+		}
+		c.Next()
+	}
+}
+
+// decodePaymentHeaderV2 parses an X-PAYMENT header value.
+func decodePaymentHeaderV2(raw string) (paymentHeaderV2, error) {
+	var p paymentHeaderV2
+	data, err := base64.StdEncoding.DecodeString(raw)
+	if err != nil {
+		return p, err
+	}
+	if err := json.Unmarshal(data, &p); err != nil {
+		return p, err
+	}
+	if p.Signature == "" || p.Nonce == "" {
+		return p, errors.New("signature and nonce are required")
+	}
+	return p, nil
+}
+
+// translateBodyToV1 renames a v2 "input" field to "text". usedText reports
+// whether the body already used the v1 field. Bodies that are not JSON
+// objects are returned unchanged for the handler to reject.
+func translateBodyToV1(body []byte) (out []byte, usedText bool) {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(body, &fields); err != nil {
+		return body, false
+	}
+	if _, ok := fields["text"]; ok {
+		return body, true
+	}
+	input, ok := fields["input"]
+	if !ok {
+		return body, false
+	}
+	fields["text"] = input
+	delete(fields, "input")
+	out, err := json.Marshal(fields)
+	if err != nil {
+		return body, false
+	}
+	return out, false
+}
+
+// apiClientID identifies the calling client for telemetry: X-Client-Name if
+// sent, otherwise the User-Agent.
+func apiClientID(c *gin.Context) string {
+	id := c.GetHeader("X-Client-Name")
+	if id == "" {
+		id = c.GetHeader("User-Agent")
+	}
+	if id == "" {
+		return "unknown"
+	}
+	if len(id) > 100 {
+		id = id[:100]
+	}
+	return id
+}
+
+// DeprecationUsage counts uses of one legacy feature by one client.
+type DeprecationUsage struct {
+	Feature   string    `json:"feature"`
+	Client    string    `json:"client"`
+	Route     string    `json:"route"`
+	Count     int64     `json:"count"`
+	FirstSeen time.Time `json:"first_seen"`
+	LastSeen  time.Time `json:"last_seen"`
+}
+
+// deprecationTracker aggregates legacy-format usage in memory and logs the
+// first use by each client so operators see it without polling.
+type deprecationTracker struct {
+	mu    sync.Mutex
+	usage map[[3]string]*DeprecationUsage
+}
+
+// deprecations is the process-wide tracker fed by apiCompatMiddleware.
+var deprecations = &deprecationTracker{usage: make(map[[3]string]*DeprecationUsage)}
+
+// maxDeprecationEntries bounds memory when clients send many distinct IDs.
+const maxDeprecationEntries = 10000
+
+func (t *deprecationTracker) Record(feature, client, route string) {
+	now := time.Now().UTC()
+	key := [3]string{feature, client, route}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	u, ok := t.usage[key]
+	if !ok {
+		if len(t.usage) >= maxDeprecationEntries {
+			return
+		}
+		u = &DeprecationUsage{Feature: feature, Client: client, Route: route, FirstSeen: now}
+		t.usage[key] = u
+		log.Printf("[DEPRECATION] client %q used %s on %s", client, feature, route)
+	}
+	u.Count++
+	u.LastSeen = now
+}
+
+// Snapshot returns all usage, most recently seen first.
+func (t *deprecationTracker) Snapshot() []DeprecationUsage {
+	t.mu.Lock()
+	out := make([]DeprecationUsage, 0, len(t.usage))
+	for _, u := range t.usage {
+		out = append(out, *u)
+	}
+	t.mu.Unlock()
+	slices.SortFunc(out, func(a, b DeprecationUsage) int { return b.LastSeen.Compare(a.LastSeen) })
+	return out
+}
@@ -0,0 +1,182 @@
+package server
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"net/http"
+	"testing"
+
+	"gateway/internal/testsupport"
+	"gateway/receipts"
+)
+
+// withDeprecations installs an empty deprecation tracker for the test.
+func withDeprecations(t *testing.T) {
+	t.Helper()
+	prev := deprecations
+	deprecations = &deprecationTracker{usage: make(map[[3]string]*DeprecationUsage)}
+	t.Cleanup(func() { deprecations = prev })
+}
+
+// postV2 sends body to path with the payment in a v2 X-PAYMENT header.
+func postV2(t *testing.T, h *testsupport.Harness, path, body, signature, nonce string) *http.Response {
+	t.Helper()
+	req, err := http.NewRequest(http.MethodPost, h.Server.URL+path, bytes.NewBufferString(body))
+	if err != nil {
+		t.Fatalf("build request: %v", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Client-Name", "sdk-v2")
+	payment, _ := json.Marshal(paymentHeaderV2{Signature: signature, Nonce: nonce})
+	req.Header.Set("X-PAYMENT", base64.StdEncoding.EncodeToString(payment))
+	resp, err := h.Server.Client().Do(req)
+	if err != nil {
+		t.Fatalf("POST %s: %v", path, err)
+	}
+	t.Cleanup(func() { resp.Body.Close() })
+	return resp
+}
+
+func TestTranslateBodyToV1(t *testing.T) {
+	out, usedText := translateBodyToV1([]byte(`{"input":"hello","extra":1}`))
+	if usedText {
+		t.Error("v2 body reported as using text")
+	}
+	var fields map[string]any
+	if err := json.Unmarshal(out, &fields); err != nil {
+		t.Fatal(err)
+	}
+	if fields["text"] != "hello" || fields["input"] != nil || fields["extra"] != float64(1) {
+		t.Errorf("unexpected translation: %s", out)
+	}
+
+	v1 := []byte(`{"text":"hello"}`)
+	if out, usedText := translateBodyToV1(v1); !usedText || !bytes.Equal(out, v1) {
+		t.Errorf("v1 body should pass through unchanged, got %s (usedText=%v)", out, usedText)
+	}
+	if out, _ := translateBodyToV1([]byte("not json")); string(out) != "not json" {
+		t.Errorf("invalid body should pass through, got %s", out)
+	}
+}
+
+func TestDecodePaymentHeaderV2(t *testing.T) {
+	raw := base64.StdEncoding.EncodeToString([]byte(`{"signature":"0xsig","nonce":"n1"}`))
+	p, err := decodePaymentHeaderV2(raw)
+	if err != nil || p.Signature != "0xsig" || p.Nonce != "n1" {
+		t.Fatalf("got %+v, %v", p, err)
+	}
+	for _, bad := range []string{"%%%", base64.StdEncoding.EncodeToString([]byte(`{"signature":"0xsig"}`))} {
+		if _, err := decodePaymentHeaderV2(bad); err == nil {
+			t.Errorf("expected error for %q", bad)
+		}
+	}
+}
+
+func TestCompat_V2RequestIsTranslated(t *testing.T) {
+	withDeprecations(t)
+	h := testsupport.NewHarness(t, newTestRouter)
+	body := `{"input":"hello"}`
+
+	resp := postV2(t, h, "/api/v2/ai/summarize", body, "0xsig", "nonce-v2")
+	if resp.StatusCode != http.StatusOK {
+		data, _ := io.ReadAll(resp.Body)
+		t.Fatalf("expected 200, got %d: %s", resp.StatusCode, data)
+	}
+	if got := h.Verifier.Requests()[0]; got.Signature != "0xsig" || got.Context.Nonce != "nonce-v2" {
+		t.Errorf("X-PAYMENT not forwarded to verifier: %+v", got)
+	}
+	if resp.Header.Get("Deprecation") != "" {
+		t.Error("v2 request must not be marked deprecated")
+	}
+
+	raw, _ := base64.StdEncoding.DecodeString(resp.Header.Get("X-402-Receipt"))
+	var receipt SignedReceipt
+	if err := json.Unmarshal(raw, &receipt); err != nil {
+		t.Fatalf("decode receipt: %v", err)
+	}
+	if receipt.Receipt.Service.RequestHash != receipts.HashData([]byte(body)) {
+		t.Error("receipt should hash the body as sent, not the translated body")
+	}
+	if usage := deprecations.Snapshot(); len(usage) != 0 {
+		t.Errorf("expected no deprecation telemetry, got %+v", usage)
+	}
+}
+
+func TestCompat_V1FormatOnV2RouteIsRecorded(t *testing.T) {
+	withDeprecations(t)
+	h := testsupport.NewHarness(t, newTestRouter)
+
+	resp := h.Post(t, "/api/v2/ai/summarize", `{"text":"hello"}`, "0xsig", "nonce")
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected 200, got %d", resp.StatusCode)
+	}
+	if resp.Header.Get("Deprecation") != "true" {
+		t.Error("expected Deprecation header")
+	}
+	if got := resp.Header.Get("X-API-Deprecated-Features"); got != "v1-payment-headers, v1-text-field" {
+		t.Errorf("unexpected deprecated features %q", got)
+	}
+
+	features := map[string]int64{}
+	for _, u := range deprecations.Snapshot() {
+		features[u.Feature] += u.Count
+	}
+	if features[featureV1PaymentHeaders] != 1 || features[featureV1TextField] != 1 {
+		t.Errorf("unexpected telemetry %+v", features)
+	}
+}
+
+func TestCompat_V1RouteAcceptsV2Format(t *testing.T) {
+	withDeprecations(t)
+	h := testsupport.NewHarness(t, newTestRouter)
+
+	resp := postV2(t, h, "/api/ai/summarize", `{"input":"hello"}`, "0xsig", "nonce")
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected 200, got %d", resp.StatusCode)
+	}
+	usage := deprecations.Snapshot()
+	if len(usage) != 1 || usage[0].Feature != featureV1Route || usage[0].Client != "sdk-v2" {
+		t.Errorf("expected one v1-route entry for sdk-v2, got %+v", usage)
+	}
+}
+
+func TestCompat_InvalidPaymentHeaderRejected(t *testing.T) {
+	h := testsupport.NewHarness(t, newTestRouter)
+	req, _ := http.NewRequest(http.MethodPost, h.Server.URL+"/api/v2/ai/summarize", bytes.NewBufferString(`{"input":"hi"}`))
+	req.Header.Set("X-PAYMENT", "not-base64!")
+	resp, err := h.Server.Client().Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("expected 400, got %d", resp.StatusCode)
+	}
+	if h.Verifier.Calls() != 0 {
+		t.Error("verifier should not be called")
+	}
+}
+
+func TestDeprecationReport(t *testing.T) {
+	withDeprecations(t)
+	t.Setenv("ADMIN_API_KEY", "s3cret")
+	deprecations.Record(featureV1Route, "legacy-app", "/api/ai/summarize")
+	deprecations.Record(featureV1Route, "legacy-app", "/api/ai/summarize")
+
+	w := adminGet(t, newTestRouter(), "/api/admin/deprecations", "s3cret")
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", w.Code)
+	}
+	var report struct {
+		Usage   []DeprecationUsage `json:"usage"`
+		Clients int                `json:"clients"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &report); err != nil {
+		t.Fatal(err)
+	}
+	if report.Clients != 1 || len(report.Usage) != 1 || report.Usage[0].Count != 2 {
+		t.Errorf("unexpected report %+v", report)
+	}
+}
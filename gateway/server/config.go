@@ -0,0 +1,671 @@
+package server
+
+import (
+	"fmt"
+	"log"
+	"net/netip"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"gateway/payments"
+	"gateway/providers"
+)
+
+// getPositiveTimeout returns the configured timeout in seconds, but ensures a
+// sensible default if the provided value is non-positive.
+func getPositiveTimeout(envKey string, defaultSeconds int) time.Duration {
+	seconds := getEnvAsInt(envKey, defaultSeconds)
+	if seconds <= 0 {
+		seconds = defaultSeconds
+	}
+	return time.Duration(seconds) * time.Second
+}
+
+// Timeout helpers (configurable via env vars)
+func getRequestTimeout() time.Duration  { return getPositiveTimeout("REQUEST_TIMEOUT_SECONDS", 60) }
+func getAITimeout() time.Duration       { return getPositiveTimeout("AI_REQUEST_TIMEOUT_SECONDS", 30) }
+func getVerifierTimeout() time.Duration { return getPositiveTimeout("VERIFIER_TIMEOUT_SECONDS", 2) }
+func getHealthCheckTimeout() time.Duration {
+	return getPositiveTimeout("HEALTH_CHECK_TIMEOUT_SECONDS", 2)
+}
+func getShutdownTimeout() time.Duration { return getPositiveTimeout("SHUTDOWN_TIMEOUT_SECONDS", 15) }
+
+const (
+	defaultRecipientAddress = "0x2cAF48b4BA1C58721a85dFADa5aC01C2DFa62219"
+	defaultModel            = "z-ai/glm-4.5-air:free"
+	defaultEmbeddingModel   = "openai/text-embedding-3-small"
+)
+
+// RateLimitTier holds the token bucket parameters for a single tier.
+type RateLimitTier struct {
+	RPM   int
+	Burst int
+}
+
+// ModelFailoverConfig controls automatic substitution of a backup model
+// when the preferred model's recent latency or error rate degrades.
+type ModelFailoverConfig struct {
+	BackupModel string
+	Latency     time.Duration
+	ErrorRate   float64
+	MinSamples  int
+	Window      time.Duration
+}
+
+// SpendCapsConfig holds per-wallet spending caps in token base units. Zero
+// disables the corresponding window.
+type SpendCapsConfig struct {
+	Daily   int64
+	Monthly int64
+}
+
+// CircuitBreakerConfig controls the AI provider circuit breaker. A zero
+// Threshold disables it. With CachedOnly set, cache hits are still served
+// while the circuit is open, priced by DegradedMode; otherwise every AI
+// request is rejected. DegradedPriceFactor scales the price of those hits
+// in the discount mode.
+type CircuitBreakerConfig struct {
+	Threshold           int
+	Cooldown            time.Duration
+	CachedOnly          bool
+	DegradedMode        string
+	DegradedPriceFactor float64
+}
+
+// EmbeddingConfig controls POST /api/ai/embed. PricePer1KTokens is in token
+// base units per 1000 estimated input tokens.
+type EmbeddingConfig struct {
+	Model            string
+	PricePer1KTokens int64
+	MaxInputs        int
+}
+
+// Config is an immutable snapshot of the settings that may change while the
+// gateway is running (rate limits, pricing, models, CORS and the IP ACL). Handlers and
+// middleware read it through getConfig so a reload swaps every value at once.
+type Config struct {
+	RateLimits map[string]RateLimitTier
+	// RateLimitRules are the RATE_LIMIT_RULES_FILE exemptions and overrides,
+	// nil without a rules file.
+	RateLimitRules   *RateLimitRules
+	PaymentAmount    string
+	RecipientAddress string
+	// AppEnv is the APP_ENV profile: dev, staging or prod.
+	AppEnv  string
+	ChainID int
+	// Chains lists every chain payment is accepted on; the first is the
+	// CHAIN_ID/RECIPIENT_ADDRESS primary.
+	Chains          []ChainOption
+	Model           string
+	AllowedModels   []string
+	ModelRoutes     []ModelRoute
+	ModelFailover   ModelFailoverConfig
+	SpendCaps       SpendCapsConfig
+	ProviderCircuit CircuitBreakerConfig
+	Embeddings      EmbeddingConfig
+	// GenerationMaxTokens caps the max_tokens a request may ask for.
+	GenerationMaxTokens int
+	// WalletMaxConcurrent caps the paid requests one wallet may have in
+	// flight on this instance; zero disables the cap.
+	WalletMaxConcurrent int
+	// LanguageDetection asks for summaries in the detected input language
+	// when the request names no output_language.
+	LanguageDetection bool
+	// PromptSanitization is how strictly prompt injection is neutralized in
+	// summarize input: off, standard or strict.
+	PromptSanitization string
+	// VerifyParallel is how much of the AI call overlaps payment
+	// verification: off, warm or speculative.
+	VerifyParallel string
+	ContextWindows ContextWindowConfig
+	Quotes         QuoteConfig
+	Challenges     ChallengeConfig
+	Signatures     SignatureConfig
+	Cache          CachePolicyConfig
+	Sentry         SentryConfig
+	Signer         SignerConfig
+	// AIProviders is the ordered summarization failover chain.
+	AIProviders []AIProvider
+	// ProviderAttemptTimeout bounds each attempt but the last; zero splits
+	// the remaining deadline evenly.
+	ProviderAttemptTimeout time.Duration
+	// RefundVoucherTTL is how long a refund voucher stays redeemable; zero
+	// disables vouchers.
+	RefundVoucherTTL time.Duration
+	Moderation       ModerationConfig
+	LoadShed         LoadShedConfig
+	Admission        AdmissionConfig
+	Abuse            AbuseConfig
+	Transparency     TransparencyConfig
+	RouteTimeouts    RouteTimeoutConfig
+	ResponseBuffer   ResponseBufferConfig
+	// Redis is only applied at startup.
+	Redis RedisConfig
+	// CacheBackend stores cached responses: redis or memory.
+	// CacheMemoryMaxEntries bounds the memory backend and is only applied
+	// when it is first used.
+	CacheBackend          string
+	CacheMemoryMaxEntries int
+	Maintenance           MaintenanceConfig
+	Shadow                ShadowConfig
+	Alerts                AlertConfig
+	VerifierHTTP          HTTPClientConfig
+	ProviderHTTP          HTTPClientConfig
+	CORSOrigins           []string
+	NetworkACL            NetworkACL
+	// TrustedProxies are the peers whose client IP header is believed.
+	TrustedProxies []netip.Prefix
+	// ClientIPHeader is the CLIENT_IP_HEADER key into clientIPHeaders.
+	ClientIPHeader string
+
+	// modelRoutesErr holds a MODEL_ROUTES parse error for Validate to report.
+	modelRoutesErr error
+	// contextWindowsErr holds a MODEL_CONTEXT_WINDOWS parse error.
+	contextWindowsErr error
+	// networkErr holds an IP ACL, TRUSTED_PROXIES or CLIENT_IP_HEADER parse
+	// error.
+	networkErr error
+	// moderationErr holds a moderation rule parse error.
+	moderationErr error
+	// httpErr holds an outbound HTTP client setting error.
+	httpErr error
+	// chainsErr holds an ACCEPTED_CHAINS parse error.
+	chainsErr error
+	// signaturesErr holds an EIP1271_RPC_URLS parse error.
+	signaturesErr error
+	// providersErr holds an AI_PROVIDERS parse error.
+	providersErr error
+	// routeTimeoutsErr holds a ROUTE_TIMEOUTS parse error.
+	routeTimeoutsErr error
+	// responseBufferErr holds a response buffer setting error.
+	responseBufferErr error
+	// redisErr holds a Redis deployment setting error.
+	redisErr error
+	// rateLimitRulesErr holds a RATE_LIMIT_RULES_FILE read or parse error.
+	rateLimitRulesErr error
+}
+
+// currentConfig holds the active snapshot. It is nil until main stores the
+// startup config, in which case getConfig reads the environment directly.
+var currentConfig atomic.Pointer[Config]
+
+// getConfig returns the active configuration snapshot. When no snapshot has
+// been installed (e.g. in unit tests) it builds one from the environment on
+// every call so env changes take effect immediately.
+func getConfig() *Config {
+	if cfg := currentConfig.Load(); cfg != nil {
+		return cfg
+	}
+	return loadConfig()
+}
+
+// loadConfig builds a Config from the current environment, applying the same
+// defaults the individual getters have always used.
+func loadConfig() *Config {
+	recipient := os.Getenv("RECIPIENT_ADDRESS")
+	if recipient == "" {
+		log.Println("Warning: RECIPIENT_ADDRESS not set, using default")
+		recipient = defaultRecipientAddress
+	}
+
+	amount := os.Getenv("PAYMENT_AMOUNT")
+	if amount == "" {
+		amount = "0.001"
+	}
+	amount = payments.CanonicalAmount(amount, "USDC")
+
+	// DEGRADED_MODE takes precedence; OUTAGE_CACHED_ONLY picks its default.
+	degradedMode := degradedOff
+	if getEnvAsBool("OUTAGE_CACHED_ONLY", true) {
+		degradedMode = degradedFullPrice
+	}
+	degradedMode = strings.ToLower(getEnv("DEGRADED_MODE", degradedMode))
+
+	chainID := 8453
+	if chainIDStr := os.Getenv("CHAIN_ID"); chainIDStr != "" {
+		if parsed, err := strconv.Atoi(chainIDStr); err == nil {
+			chainID = parsed
+		} else {
+			log.Printf("Warning: Invalid CHAIN_ID '%s', using default 8453", chainIDStr)
+		}
+	}
+
+	model := os.Getenv("OPENROUTER_MODEL")
+	if model == "" {
+		model = defaultModel
+	}
+
+	embeddingPrice := int64(20) // 0.00002 USDC per 1K tokens
+	if os.Getenv("EMBEDDING_PRICE_PER_1K_TOKENS") != "" {
+		embeddingPrice = getEnvAsTokenAmount("EMBEDDING_PRICE_PER_1K_TOKENS")
+	}
+
+	chains, chainsErr := parseAcceptedChains(getEnvAsList("ACCEPTED_CHAINS", nil), chainID, recipient)
+	routes, routesErr := parseModelRoutes(os.Getenv("MODEL_ROUTES"))
+	rpcURLs, signaturesErr := parseRPCURLs(getEnvAsList("EIP1271_RPC_URLS", nil))
+	// The relay falls back to the EIP-1271 nodes when it has none of its own.
+	relayRPCURLs, relayRPCErr := rpcURLs, error(nil)
+	if raw := getEnvAsList("ERC3009_RELAY_RPC_URLS", nil); len(raw) > 0 {
+		relayRPCURLs, relayRPCErr = parseRPCURLs(raw)
+	}
+	erc3009Tokens, erc3009TokensErr := parseERC3009Tokens(getEnvAsList("ERC3009_TOKENS", nil))
+	aiProviders, providersErr := providers.Parse(getEnvAsList("AI_PROVIDERS", providers.Default))
+	windows, windowsErr := parseContextWindows(os.Getenv("MODEL_CONTEXT_WINDOWS"))
+	moderation, moderationErr := loadModerationConfig()
+	routeTimeouts, routeTimeoutsErr := loadRouteTimeouts()
+	responseBuffer, responseBufferErr := loadResponseBufferConfig()
+	redisConfig, redisErr := loadRedisConfig()
+	rateLimitRules, rateLimitRulesErr := loadRateLimitRules(os.Getenv("RATE_LIMIT_RULES_FILE"))
+	verifierHTTP, httpErr := loadHTTPClientConfig("VERIFIER_HTTP", HTTPClientConfig{
+		MaxIdleConnsPerHost: 32,
+		DialTimeout:         2 * time.Second,
+		TLSHandshakeTimeout: 2 * time.Second,
+		IdleConnTimeout:     90 * time.Second,
+	})
+	providerHTTP, providerErr := loadHTTPClientConfig("OPENROUTER_HTTP", HTTPClientConfig{
+		MaxIdleConnsPerHost: 64,
+		DialTimeout:         5 * time.Second,
+		TLSHandshakeTimeout: 5 * time.Second,
+		IdleConnTimeout:     90 * time.Second,
+	})
+	if httpErr == nil {
+		httpErr = providerErr
+	}
+	acl, networkErr := loadNetworkACL()
+	proxies, proxiesErr := loadTrustedProxies()
+	clientIPHeader, headerErr := loadClientIPHeader()
+	if networkErr == nil {
+		networkErr = proxiesErr
+	}
+	if networkErr == nil {
+		networkErr = headerErr
+	}
+
+	return &Config{
+		RateLimits: map[string]RateLimitTier{
+			"anonymous": {
+				RPM:   getEnvAsInt("RATE_LIMIT_ANONYMOUS_RPM", 10),
+				Burst: getEnvAsInt("RATE_LIMIT_ANONYMOUS_BURST", 5),
+			},
+			"standard": {
+				RPM:   getEnvAsInt("RATE_LIMIT_STANDARD_RPM", 60),
+				Burst: getEnvAsInt("RATE_LIMIT_STANDARD_BURST", 20),
+			},
+			"verified": {
+				RPM:   getEnvAsInt("RATE_LIMIT_VERIFIED_RPM", 120),
+				Burst: getEnvAsInt("RATE_LIMIT_VERIFIED_BURST", 50),
+			},
+		},
+		RateLimitRules:   rateLimitRules,
+		PaymentAmount:    amount,
+		RecipientAddress: recipient,
+		AppEnv:           getAppEnv(),
+		ChainID:          chainID,
+		Chains:           chains,
+		Model:            model,
+		AllowedModels:    getEnvAsList("OPENROUTER_ALLOWED_MODELS", nil),
+		ModelRoutes:      routes,
+		ModelFailover: ModelFailoverConfig{
+			BackupModel: os.Getenv("OPENROUTER_BACKUP_MODEL"),
+			Latency:     time.Duration(getEnvAsInt("MODEL_FAILOVER_LATENCY_MS", 10000)) * time.Millisecond,
+			ErrorRate:   getEnvAsFloat("MODEL_FAILOVER_ERROR_RATE", 0.5),
+			MinSamples:  getEnvAsInt("MODEL_FAILOVER_MIN_SAMPLES", 5),
+			Window:      time.Duration(getEnvAsInt("MODEL_HEALTH_WINDOW_SECONDS", 60)) * time.Second,
+		},
+		SpendCaps: SpendCapsConfig{
+			Daily:   getEnvAsTokenAmount("SPEND_CAP_DAILY"),
+			Monthly: getEnvAsTokenAmount("SPEND_CAP_MONTHLY"),
+		},
+		ProviderCircuit: CircuitBreakerConfig{
+			Threshold:           getEnvAsInt("PROVIDER_CIRCUIT_THRESHOLD", 5),
+			Cooldown:            time.Duration(getEnvAsInt("PROVIDER_CIRCUIT_COOLDOWN_SECONDS", 30)) * time.Second,
+			CachedOnly:          degradedMode != degradedOff,
+			DegradedMode:        degradedMode,
+			DegradedPriceFactor: getEnvAsFloat("DEGRADED_PRICE_FACTOR", 0.5),
+		},
+		Embeddings: EmbeddingConfig{
+			Model:            getEnv("EMBEDDING_MODEL", defaultEmbeddingModel),
+			PricePer1KTokens: embeddingPrice,
+			MaxInputs:        getEnvAsInt("EMBEDDING_MAX_INPUTS", 64),
+		},
+		GenerationMaxTokens: getEnvAsInt("GENERATION_MAX_TOKENS", 1024),
+		WalletMaxConcurrent: getEnvAsInt("WALLET_MAX_CONCURRENT", 4),
+		LanguageDetection:   getEnvAsBool("LANGUAGE_DETECTION_ENABLED", true),
+		PromptSanitization:  strings.ToLower(getEnv("PROMPT_SANITIZATION", sanitizeStandard)),
+		VerifyParallel:      strings.ToLower(getEnv("VERIFY_PARALLEL_MODE", verifyParallelOff)),
+		ContextWindows: ContextWindowConfig{
+			Windows: windows,
+			Default: getEnvAsInt("MODEL_CONTEXT_WINDOW_DEFAULT", 0),
+		},
+		Quotes: QuoteConfig{
+			TTL:      time.Duration(getEnvAsInt("QUOTE_TTL_SECONDS", 300)) * time.Second,
+			Required: getEnvAsBool("QUOTE_SIGNATURE_REQUIRED", false),
+		},
+		Challenges: ChallengeConfig{
+			TTL:      time.Duration(getEnvAsInt("PAYMENT_CHALLENGE_TTL_SECONDS", 900)) * time.Second,
+			CacheTTL: time.Duration(getEnvAsInt("PAYMENT_CHALLENGE_CACHE_SECONDS", 0)) * time.Second,
+			Required: getEnvAsBool("PAYMENT_CHALLENGE_REQUIRED", false),
+		},
+		Cache:  loadCachePolicyConfig(),
+		Sentry: loadSentryConfig(),
+		Signer: loadSignerConfig(),
+		Signatures: SignatureConfig{
+			Types:        getEnvAsList("SIGNATURE_TYPES", []string{payments.SignatureTypeEIP712, payments.SignatureTypePersonalSign}),
+			RPCURLs:      rpcURLs,
+			Tokens:       erc3009Tokens,
+			Relay:        getEnvAsBool("ERC3009_RELAY_ENABLED", false),
+			RelayRPCURLs: relayRPCURLs,
+			tokensErr:    erc3009TokensErr,
+			relayRPCErr:  relayRPCErr,
+		},
+		AIProviders:            aiProviders,
+		ProviderAttemptTimeout: time.Duration(getEnvAsInt("AI_PROVIDER_ATTEMPT_TIMEOUT_SECONDS", 0)) * time.Second,
+		RefundVoucherTTL:       time.Duration(getEnvAsInt("REFUND_VOUCHER_TTL_SECONDS", 86400)) * time.Second,
+		Moderation:             moderation,
+		LoadShed: LoadShedConfig{
+			Enabled:        getEnvAsBool("LOAD_SHED_ENABLED", false),
+			MaxGoroutines:  getEnvAsInt("LOAD_SHED_MAX_GOROUTINES", 10000),
+			MaxHeapMB:      getEnvAsInt("LOAD_SHED_MAX_HEAP_MB", 1024),
+			MaxInFlight:    getEnvAsInt("LOAD_SHED_MAX_IN_FLIGHT", 500),
+			CriticalFactor: getEnvAsFloat("LOAD_SHED_CRITICAL_FACTOR", 1.5),
+			RecoverFactor:  getEnvAsFloat("LOAD_SHED_RECOVER_FACTOR", 0.8),
+			RetryAfter:     time.Duration(getEnvAsInt("LOAD_SHED_RETRY_AFTER_SECONDS", 5)) * time.Second,
+		},
+		Admission: loadAdmissionConfig(),
+		Abuse: AbuseConfig{
+			Enabled:           getEnvAsBool("ABUSE_DETECTION_ENABLED", false),
+			Window:            time.Duration(getEnvAsInt("ABUSE_WINDOW_SECONDS", 300)) * time.Second,
+			SignatureFailures: getEnvAsInt("ABUSE_MAX_SIGNATURE_FAILURES", 20),
+			ClientErrorRate:   getEnvAsFloat("ABUSE_MAX_4XX_RATE", 0.9),
+			MinRequests:       getEnvAsInt("ABUSE_MIN_REQUESTS", 50),
+			BanDuration:       time.Duration(getEnvAsInt("ABUSE_BAN_SECONDS", 3600)) * time.Second,
+		},
+		Transparency: TransparencyConfig{
+			Enabled:       getEnvAsBool("TRANSPARENCY_ENABLED", false),
+			Interval:      time.Duration(getEnvAsInt("TRANSPARENCY_INTERVAL_MINUTES", 10)) * time.Minute,
+			AnchorRPCURL:  getEnv("TRANSPARENCY_ANCHOR_RPC_URL", ""),
+			AnchorAddress: getEnv("TRANSPARENCY_ANCHOR_ADDRESS", ""),
+		},
+		RouteTimeouts:         routeTimeouts,
+		ResponseBuffer:        responseBuffer,
+		Redis:                 redisConfig,
+		CacheBackend:          strings.ToLower(getEnv("CACHE_BACKEND", cacheBackendRedis)),
+		CacheMemoryMaxEntries: getEnvAsInt("CACHE_MEMORY_MAX_ENTRIES", 10000),
+		Maintenance: MaintenanceConfig{
+			Enabled:    getEnvAsBool("MAINTENANCE_MODE", false),
+			Message:    getEnv("MAINTENANCE_MESSAGE", defaultMaintenanceMessage),
+			RetryAfter: time.Duration(getEnvAsInt("MAINTENANCE_RETRY_AFTER_SECONDS", 300)) * time.Second,
+		},
+		Shadow: ShadowConfig{
+			Model:     getEnv("SHADOW_MODEL", ""),
+			Percent:   getEnvAsFloat("SHADOW_PERCENT", 0),
+			Timeout:   time.Duration(getEnvAsInt("SHADOW_TIMEOUT_SECONDS", 30)) * time.Second,
+			Retention: time.Duration(getEnvAsInt("SHADOW_RETENTION_SECONDS", 604800)) * time.Second,
+			MaxStored: getEnvAsInt("SHADOW_MAX_COMPARISONS", 1000),
+		},
+		Alerts:            loadAlertConfig(),
+		VerifierHTTP:      verifierHTTP,
+		ProviderHTTP:      providerHTTP,
+		CORSOrigins:       getEnvAsList("CORS_ALLOWED_ORIGINS", []string{"http://localhost:3001"}),
+		NetworkACL:        acl,
+		TrustedProxies:    proxies,
+		ClientIPHeader:    clientIPHeader,
+		modelRoutesErr:    routesErr,
+		contextWindowsErr: windowsErr,
+		networkErr:        networkErr,
+		moderationErr:     moderationErr,
+		httpErr:           httpErr,
+		chainsErr:         chainsErr,
+		signaturesErr:     signaturesErr,
+		providersErr:      providersErr,
+		routeTimeoutsErr:  routeTimeoutsErr,
+		responseBufferErr: responseBufferErr,
+		redisErr:          redisErr,
+		rateLimitRulesErr: rateLimitRulesErr,
+	}
+}
+
+// Validate rejects snapshots that would leave the gateway in a broken state.
+// A reload that fails validation keeps the previous snapshot in place.
+func (cfg *Config) Validate() error {
+	for tier, limits := range cfg.RateLimits {
+		if limits.RPM <= 0 || limits.Burst <= 0 {
+			return fmt.Errorf("rate limit tier %q must have positive rpm and burst", tier)
+		}
+	}
+	if _, err := (PaymentContext{Token: "USDC", Amount: cfg.PaymentAmount}).ParseAmount(); err != nil {
+		return fmt.Errorf("invalid PAYMENT_AMOUNT: %w", err)
+	}
+	if cfg.ChainID <= 0 {
+		return fmt.Errorf("chain id must be positive, got %d", cfg.ChainID)
+	}
+	if cfg.chainsErr != nil {
+		return fmt.Errorf("invalid ACCEPTED_CHAINS: %w", cfg.chainsErr)
+	}
+	if cfg.signaturesErr != nil {
+		return fmt.Errorf("invalid EIP1271_RPC_URLS: %w", cfg.signaturesErr)
+	}
+	if cfg.providersErr != nil {
+		return fmt.Errorf("invalid AI_PROVIDERS: %w", cfg.providersErr)
+	}
+	if cfg.routeTimeoutsErr != nil {
+		return fmt.Errorf("invalid ROUTE_TIMEOUTS: %w", cfg.routeTimeoutsErr)
+	}
+	if cfg.responseBufferErr != nil {
+		return cfg.responseBufferErr
+	}
+	if cfg.redisErr != nil {
+		return cfg.redisErr
+	}
+	if cfg.CacheBackend != cacheBackendRedis && cfg.CacheBackend != cacheBackendMemory {
+		return fmt.Errorf("CACHE_BACKEND must be %s or %s, got %q", cacheBackendRedis, cacheBackendMemory, cfg.CacheBackend)
+	}
+	if cfg.CacheMemoryMaxEntries <= 0 {
+		return fmt.Errorf("CACHE_MEMORY_MAX_ENTRIES must be positive, got %d", cfg.CacheMemoryMaxEntries)
+	}
+	if cfg.rateLimitRulesErr != nil {
+		return fmt.Errorf("invalid RATE_LIMIT_RULES_FILE: %w", cfg.rateLimitRulesErr)
+	}
+	switch cfg.PromptSanitization {
+	case sanitizeOff, sanitizeStandard, sanitizeStrict:
+	default:
+		return fmt.Errorf("PROMPT_SANITIZATION must be %s, %s or %s, got %q", sanitizeOff, sanitizeStandard, sanitizeStrict, cfg.PromptSanitization)
+	}
+	switch cfg.VerifyParallel {
+	case verifyParallelOff, verifyParallelWarm, verifyParallelSpeculative:
+	default:
+		return fmt.Errorf("VERIFY_PARALLEL_MODE must be %s, %s or %s, got %q", verifyParallelOff, verifyParallelWarm, verifyParallelSpeculative, cfg.VerifyParallel)
+	}
+	if cfg.ProviderAttemptTimeout < 0 {
+		return fmt.Errorf("AI_PROVIDER_ATTEMPT_TIMEOUT_SECONDS must not be negative")
+	}
+	if err := cfg.Sentry.validate(); err != nil {
+		return err
+	}
+	if err := cfg.Signer.validate(); err != nil {
+		return err
+	}
+	if err := cfg.Cache.validate(); err != nil {
+		return err
+	}
+	if err := cfg.Signatures.validate(); err != nil {
+		return err
+	}
+	if !cfg.IsModelAllowed(cfg.Model) {
+		return fmt.Errorf("model %q is not in OPENROUTER_ALLOWED_MODELS", cfg.Model)
+	}
+	if cfg.modelRoutesErr != nil {
+		return fmt.Errorf("invalid MODEL_ROUTES: %w", cfg.modelRoutesErr)
+	}
+	if cfg.networkErr != nil {
+		return fmt.Errorf("invalid network ACL: %w", cfg.networkErr)
+	}
+	if cfg.moderationErr != nil {
+		return fmt.Errorf("invalid moderation rules: %w", cfg.moderationErr)
+	}
+	if cfg.httpErr != nil {
+		return fmt.Errorf("invalid HTTP client settings: %w", cfg.httpErr)
+	}
+	for _, route := range cfg.ModelRoutes {
+		if !cfg.IsModelAllowed(route.Model) {
+			return fmt.Errorf("routed model %q is not in OPENROUTER_ALLOWED_MODELS", route.Model)
+		}
+	}
+	if backup := cfg.ModelFailover.BackupModel; backup != "" && !cfg.IsModelAllowed(backup) {
+		return fmt.Errorf("backup model %q is not in OPENROUTER_ALLOWED_MODELS", backup)
+	}
+	if rate := cfg.ModelFailover.ErrorRate; rate <= 0 || rate > 1 {
+		return fmt.Errorf("model failover error rate must be in (0, 1], got %v", rate)
+	}
+	if cfg.ModelFailover.Latency <= 0 || cfg.ModelFailover.Window <= 0 || cfg.ModelFailover.MinSamples <= 0 {
+		return fmt.Errorf("model failover latency, window and min samples must be positive")
+	}
+	if cfg.ProviderCircuit.Threshold < 0 {
+		return fmt.Errorf("provider circuit threshold must not be negative")
+	}
+	if cfg.ProviderCircuit.Threshold > 0 && cfg.ProviderCircuit.Cooldown <= 0 {
+		return fmt.Errorf("provider circuit cooldown must be positive")
+	}
+	switch cfg.ProviderCircuit.DegradedMode {
+	case degradedOff, degradedFullPrice, degradedDiscount, degradedFree:
+	default:
+		return fmt.Errorf("DEGRADED_MODE must be %s, %s, %s or %s, got %q", degradedOff, degradedFullPrice, degradedDiscount, degradedFree, cfg.ProviderCircuit.DegradedMode)
+	}
+	if f := cfg.ProviderCircuit.DegradedPriceFactor; cfg.ProviderCircuit.DegradedMode == degradedDiscount && (f <= 0 || f > 1) {
+		return fmt.Errorf("DEGRADED_PRICE_FACTOR must be in (0, 1], got %v", f)
+	}
+	if cfg.Embeddings.Model == "" {
+		return fmt.Errorf("embedding model is empty")
+	}
+	if cfg.Embeddings.PricePer1KTokens <= 0 {
+		return fmt.Errorf("embedding price per 1K tokens must be positive")
+	}
+	if cfg.Embeddings.MaxInputs <= 0 {
+		return fmt.Errorf("embedding max inputs must be positive")
+	}
+	if cfg.GenerationMaxTokens <= 0 {
+		return fmt.Errorf("GENERATION_MAX_TOKENS must be positive")
+	}
+	if cfg.WalletMaxConcurrent < 0 {
+		return fmt.Errorf("WALLET_MAX_CONCURRENT must not be negative")
+	}
+	if cfg.Maintenance.RetryAfter <= 0 {
+		return fmt.Errorf("MAINTENANCE_RETRY_AFTER_SECONDS must be positive")
+	}
+	if cfg.contextWindowsErr != nil {
+		return fmt.Errorf("invalid MODEL_CONTEXT_WINDOWS: %w", cfg.contextWindowsErr)
+	}
+	if cfg.ContextWindows.Default < 0 {
+		return fmt.Errorf("MODEL_CONTEXT_WINDOW_DEFAULT must not be negative")
+	}
+	if err := cfg.validateProfile(); err != nil {
+		return err
+	}
+	if err := cfg.Abuse.validate(); err != nil {
+		return err
+	}
+	if err := cfg.Transparency.validate(); err != nil {
+		return err
+	}
+	if err := cfg.LoadShed.validate(); err != nil {
+		return err
+	}
+	if err := cfg.Shadow.validate(); err != nil {
+		return err
+	}
+	if err := cfg.Alerts.validate(); err != nil {
+		return err
+	}
+	if err := cfg.Admission.validate(); err != nil {
+		return err
+	}
+	if cfg.Quotes.TTL <= 0 {
+		return fmt.Errorf("quote TTL must be positive")
+	}
+	if cfg.Challenges.TTL <= 0 {
+		return fmt.Errorf("PAYMENT_CHALLENGE_TTL_SECONDS must be positive")
+	}
+	if cfg.Challenges.CacheTTL < 0 {
+		return fmt.Errorf("PAYMENT_CHALLENGE_CACHE_SECONDS must not be negative")
+	}
+	if cfg.RefundVoucherTTL < 0 {
+		return fmt.Errorf("REFUND_VOUCHER_TTL_SECONDS must not be negative")
+	}
+	return nil
+}
+
+// IsModelAllowed reports whether model may be used. An empty allowlist
+// permits every model.
+func (cfg *Config) IsModelAllowed(model string) bool {
+	return len(cfg.AllowedModels) == 0 || slices.Contains(cfg.AllowedModels, model)
+}
+
+// IsOriginAllowed reports whether a browser origin passes the CORS allowlist.
+func (cfg *Config) IsOriginAllowed(origin string) bool {
+	return slices.Contains(cfg.CORSOrigins, "*") || slices.Contains(cfg.CORSOrigins, origin)
+}
+
+// getEnvAsList splits a comma-separated environment variable into trimmed,
+// non-empty entries, returning fallback when the variable is unset.
+func getEnvAsList(key string, fallback []string) []string {
+	valStr, ok := os.LookupEnv(key)
+	if !ok {
+		return fallback
+	}
+	var out []string
+	for _, part := range strings.Split(valStr, ",") {
+		if part = strings.TrimSpace(part); part != "" {
+			out = append(out, part)
+		}
+	}
+	return out
+}
+
+// getEnvAsFloat retrieves an environment variable as a float with a default value
+func getEnvAsFloat(key string, defaultValue float64) float64 {
+	valStr := os.Getenv(key)
+	if valStr == "" {
+		return defaultValue
+	}
+	val, err := strconv.ParseFloat(valStr, 64)
+	if err != nil {
+		log.Printf("Warning: Invalid value for %s: %s, using default %v", key, valStr, defaultValue)
+		return defaultValue
+	}
+	return val
+}
+
+// getEnvAsBool retrieves an environment variable as a boolean ("true"/"1" or
+// "false"/"0", case-insensitive) with a default value
+func getEnvAsBool(key string, defaultValue bool) bool {
+	switch strings.ToLower(os.Getenv(key)) {
+	case "true", "1":
+		return true
+	case "false", "0":
+		return false
+	case "":
+		return defaultValue
+	default:
+		log.Printf("Warning: Invalid value for %s: %s, using default %v", key, os.Getenv(key), defaultValue)
+		return defaultValue
+	}
+}
+
+// getEnvAsTokenAmount retrieves a decimal token amount (e.g. "1.50") as base
+// units, returning 0 when unset or invalid
+func getEnvAsTokenAmount(key string) int64 {
+	valStr := os.Getenv(key)
+	if valStr == "" {
+		return 0
+	}
+	val, err := parseTokenAmount(valStr)
+	if err != nil {
+		log.Printf("Warning: Invalid value for %s: %v, ignoring", key, err)
+		return 0
+	}
+	return val
+}
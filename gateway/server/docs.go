@@ -0,0 +1,50 @@
+package server
+
+import (
+	"embed"
+	"html/template"
+	"log"
+	"net/http"
+
+	"gateway/payments"
+
+	"github.com/gin-gonic/gin"
+)
+
+//go:embed docs.html
+var docsHTML string
+
+// openAPISpec holds openapi.yaml, served at GET /openapi.yaml, so the binary
+// does not depend on its working directory.
+//
+//go:embed openapi.yaml
+var openAPISpec embed.FS
+
+// docsTemplate renders GET /docs: Swagger UI for openapi.yaml under a
+// payment console that walks the 402 flow with a browser wallet.
+var docsTemplate = template.Must(template.New("docs").Parse(docsHTML))
+
+// docsPage is the data docs.html is rendered with.
+type docsPage struct {
+	SpecURL   string
+	Endpoints []pricedEndpoint
+	// Domain is the EIP-712 domain the console signs payments in; the
+	// chain comes from the payment context.
+	DomainName    string
+	DomainVersion string
+}
+
+// handleDocs handles GET /docs.
+func handleDocs(c *gin.Context) {
+	page := docsPage{
+		SpecURL:       "/openapi.yaml",
+		Endpoints:     pricedEndpoints(getConfig()),
+		DomainName:    payments.DomainName,
+		DomainVersion: payments.DomainVersion,
+	}
+	c.Header("Content-Type", "text/html; charset=utf-8")
+	c.Status(http.StatusOK)
+	if err := docsTemplate.Execute(c.Writer, page); err != nil {
+		log.Printf("[WARNING] Failed to render /docs: %v", err)
+	}
+}
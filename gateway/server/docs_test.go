@@ -0,0 +1,37 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestDocs_RendersPaymentConsole(t *testing.T) {
+	t.Setenv("PAYMENT_AMOUNT", "0.002")
+	w := httptest.NewRecorder()
+	newTestRouter().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/docs", nil))
+	if w.Code != http.StatusOK || !strings.HasPrefix(w.Header().Get("Content-Type"), "text/html") {
+		t.Fatalf("expected an HTML page, got %d %q", w.Code, w.Header().Get("Content-Type"))
+	}
+	page := w.Body.String()
+	for _, want := range []string{
+		`url: "/openapi.yaml"`,
+		`var domainName = "MicroAI Paygate"`,
+		`<option value="/api/ai/summarize">POST /api/ai/summarize (0.002 USDC)</option>`,
+		`<option value="/api/ai/embed">`,
+		"eth_signTypedData_v4",
+	} {
+		if !strings.Contains(page, want) {
+			t.Errorf("expected %q in the docs page", want)
+		}
+	}
+}
+
+func TestDocs_ServesEmbeddedSpec(t *testing.T) {
+	w := httptest.NewRecorder()
+	newTestRouter().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/openapi.yaml", nil))
+	if w.Code != http.StatusOK || !strings.HasPrefix(w.Body.String(), "openapi:") {
+		t.Errorf("expected the embedded OpenAPI spec, got %d", w.Code)
+	}
+}
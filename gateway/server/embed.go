@@ -0,0 +1,396 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"sort"
+	"time"
+	"unicode/utf8"
+
+	"gateway/cache"
+	"gateway/receipts"
+
+	"github.com/gin-gonic/gin"
+)
+
+// EmbedRequest is the body of POST /api/ai/embed. Text is a single string or
+// an array of up to EMBEDDING_MAX_INPUTS strings; v2 clients send it as
+// "input" and apiCompatMiddleware renames it.
+type EmbedRequest struct {
+	Text json.RawMessage `json:"text"`
+}
+
+// Embedding is one vector in an EmbedResponse, in input order.
+type Embedding struct {
+	Index     int       `json:"index"`
+	Embedding []float64 `json:"embedding"`
+}
+
+// EmbedUsage reports what the request was charged for.
+type EmbedUsage struct {
+	InputTokens  int `json:"input_tokens"`
+	CachedInputs int `json:"cached_inputs"`
+}
+
+// EmbedResponse is the body of a successful embeddings request. The
+// receipt's response_hash covers it.
+type EmbedResponse struct {
+	Model      string      `json:"model"`
+	Dimensions int         `json:"dimensions"`
+	Data       []Embedding `json:"data"`
+	Usage      EmbedUsage  `json:"usage"`
+}
+
+// EmbedQuote is returned with a 402 so clients know the price of a batch
+// before signing.
+type EmbedQuote struct {
+	Model       string `json:"model"`
+	Price       string `json:"price"`
+	Inputs      int    `json:"inputs"`
+	InputTokens int    `json:"input_tokens"`
+}
+
+// parseEmbedInputs accepts a string or an array of strings.
+func parseEmbedInputs(raw json.RawMessage, maxInputs int) ([]string, error) {
+	var inputs []string
+	var single string
+	if err := json.Unmarshal(raw, &single); err == nil {
+		inputs = []string{single}
+	} else if err := json.Unmarshal(raw, &inputs); err != nil {
+		return nil, fmt.Errorf("text must be a string or an array of strings")
+	}
+	if len(inputs) == 0 {
+		return nil, fmt.Errorf("text field cannot be empty")
+	}
+	if len(inputs) > maxInputs {
+		return nil, fmt.Errorf("at most %d inputs are allowed per request", maxInputs)
+	}
+	for i, input := range inputs {
+		if input == "" {
+			return nil, fmt.Errorf("input %d is empty", i)
+		}
+	}
+	return inputs, nil
+}
+
+// estimateTokens approximates the provider's token count (about four
+// characters per token). The price must be known before the client signs, so
+// the estimate is charged rather than the provider's reported usage.
+func estimateTokens(inputs []string) int {
+	tokens := 0
+	for _, input := range inputs {
+		tokens += max((utf8.RuneCountInString(input)+3)/4, 1)
+	}
+	return tokens
+}
+
+// embeddingPrice returns the price of tokens input tokens in base units,
+// rounded up and never below one base unit.
+func embeddingPrice(cfg *Config, tokens int) string {
+	units := (int64(tokens)*cfg.Embeddings.PricePer1KTokens + 999) / 1000
+	return formatTokenAmount(max(units, 1))
+}
+
+// quoteEmbedding prices an unsigned request from its body. An unreadable or
+// invalid body is quoted as a single one-token input.
+func quoteEmbedding(c *gin.Context) EmbedQuote {
+	const maxBodySize = 10 * 1024 * 1024
+	cfg := getConfig()
+	inputs := []string{""}
+	if c.Request.Body != nil {
+		if body, err := io.ReadAll(io.LimitReader(c.Request.Body, maxBodySize)); err == nil {
+			var req EmbedRequest
+			if json.Unmarshal(body, &req) == nil {
+				if parsed, err := parseEmbedInputs(req.Text, cfg.Embeddings.MaxInputs); err == nil {
+					inputs = parsed
+				}
+			}
+		}
+	}
+	tokens := estimateTokens(inputs)
+	return EmbedQuote{Model: cfg.Embeddings.Model, Price: embeddingPrice(cfg, tokens), Inputs: len(inputs), InputTokens: tokens}
+}
+
+// handleEmbed handles POST /api/ai/embed. Payment works as for
+// /api/ai/summarize, priced per estimated input token. Vectors are cached
+// per input by content hash, so only uncached inputs reach the provider, but
+// every input is charged.
+func handleEmbed(c *gin.Context) {
+	signature := c.GetHeader("X-402-Signature")
+	nonce := c.GetHeader("X-402-Nonce")
+	if signature == "" || nonce == "" {
+		quote := quoteEmbedding(c)
+		respondPaymentRequired(c, quote.Price, newAPIError(CodePaymentRequired, "").with(gin.H{"quote": quote}))
+		return
+	}
+
+	const maxBodySize = 10 * 1024 * 1024
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, int64(maxBodySize))
+	requestBody, err := io.ReadAll(c.Request.Body)
+	if err != nil {
+		var maxBytesErr *http.MaxBytesError
+		if errors.As(err, &maxBytesErr) {
+			respondAPIError(c, errPayloadTooLarge())
+		} else {
+			respondError(c, CodeBodyReadFailed, "The request body could not be read")
+		}
+		return
+	}
+	var req EmbedRequest
+	if err := json.Unmarshal(requestBody, &req); err != nil {
+		respondError(c, CodeInvalidBody, "Request must be valid JSON")
+		return
+	}
+	cfg := getConfig()
+	inputs, err := parseEmbedInputs(req.Text, cfg.Embeddings.MaxInputs)
+	if err != nil {
+		respondError(c, CodeInvalidRequest, err.Error())
+		return
+	}
+
+	if !screenContent(c, inputs...) {
+		return
+	}
+
+	model := cfg.Embeddings.Model
+	tokens := estimateTokens(inputs)
+	price := embeddingPrice(cfg, tokens)
+	c.Set("model_selection", ModelSelection{Model: model, Price: price})
+
+	lookupStart := time.Now()
+	vectors := getCachedEmbeddings(c.Request.Context(), model, inputs)
+	var misses []int
+	for i, v := range vectors {
+		if v == nil {
+			misses = append(misses, i)
+		}
+	}
+	recordCacheTiming(c, lookupStart, len(misses) == 0)
+
+	// Fully cached batches are still served in cached-only outage mode,
+	// priced by DEGRADED_MODE.
+	outage := providerCircuit.State(cfg) != circuitClosed
+	if len(misses) > 0 && !providerCircuit.Allow(cfg) || outage && !cfg.ProviderCircuit.CachedOnly {
+		rejectProviderOutage(c, cfg)
+		return
+	}
+	if outage {
+		markDegraded(c)
+		if price = degradedPrice(cfg, price); price == "" {
+			resp, err := embedResponse(model, vectors, tokens, len(inputs))
+			if err != nil {
+				respondAPIError(c, newAPIError(CodeAIFailed, "The AI provider request failed").withDetails(err.Error()))
+				return
+			}
+			body, err := receipts.EncodeResponse(resp)
+			if err != nil {
+				respondError(c, CodeInternal, "Failed to encode response")
+				return
+			}
+			serveDegradedFree(c, outputContentTypes[outputFormatJSON], body)
+			return
+		}
+	}
+
+	verifyResp, paymentCtx, ok := authorizePayment(c, signature, nonce, price)
+	if !ok {
+		return
+	}
+
+	refundSpend, ok := reserveSpend(c, verifyResp.RecoveredAddress, price)
+	if !ok {
+		return
+	}
+
+	var usage ProviderUsage
+	if len(misses) > 0 {
+		missed := make([]string, len(misses))
+		for i, idx := range misses {
+			missed[i] = inputs[idx]
+		}
+		stopTiming := startTiming(c, timingAI)
+		fresh, providerUsage, err := callEmbeddings(c.Request.Context(), model, missed)
+		stopTiming()
+		if err != nil {
+			refundSpend()
+			if errors.Is(err, context.DeadlineExceeded) || c.Request.Context().Err() == context.DeadlineExceeded {
+				respondWithRefund(c, newAPIError(CodeAITimeout, "AI request timed out"), *paymentCtx, verifyResp.RecoveredAddress)
+				return
+			}
+			respondWithRefund(c, newAPIError(CodeAIFailed, "The AI provider request failed").withDetails(err.Error()), *paymentCtx, verifyResp.RecoveredAddress)
+			return
+		}
+		for i, idx := range misses {
+			vectors[idx] = fresh[i]
+		}
+		usage = providerUsage
+		c.Set("provider_usage", usage)
+		go func() {
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			storeEmbeddings(ctx, model, missed, fresh)
+		}()
+	}
+
+	resp, err := embedResponse(model, vectors, tokens, len(inputs)-len(misses))
+	if err != nil {
+		refundSpend()
+		respondAPIError(c, newAPIError(CodeAIFailed, "The AI provider request failed").withDetails(err.Error()))
+		return
+	}
+
+	if original, ok := c.Get("receipt_request_body"); ok {
+		// Hash the body as sent, not as translated by apiCompatMiddleware.
+		requestBody = original.([]byte)
+	}
+	if err := sendWithReceipt(c, *paymentCtx, verifyResp.RecoveredAddress, requestBody, resp, receipts.WithDimensions(resp.Dimensions)); err != nil {
+		refundSpend()
+		log.Printf("Failed to generate receipt: %v", err)
+		return
+	}
+	recordMargin(c, *paymentCtx, verifyResp.RecoveredAddress, usage)
+}
+
+// embedResponse builds the response for vectors, of which cached came from
+// the cache. It fails if the vectors differ in dimensions.
+func embedResponse(model string, vectors [][]float64, tokens, cached int) (EmbedResponse, error) {
+	resp := EmbedResponse{
+		Model:      model,
+		Dimensions: len(vectors[0]),
+		Data:       make([]Embedding, len(vectors)),
+		Usage:      EmbedUsage{InputTokens: tokens, CachedInputs: cached},
+	}
+	for i, v := range vectors {
+		if len(v) != resp.Dimensions {
+			return resp, errors.New("embedding dimensions do not match")
+		}
+		resp.Data[i] = Embedding{Index: i, Embedding: v}
+	}
+	return resp, nil
+}
+
+// getEmbeddingCacheKey keys a vector by model and input content.
+func getEmbeddingCacheKey(model, input string) string {
+	hash := sha256.Sum256([]byte(cacheVersion() + ":" + model + ":" + input))
+	return "ai:embedding:" + hex.EncodeToString(hash[:])
+}
+
+// getCachedEmbeddings returns the cached vector for each input, or nil for
+// misses. Every input misses when caching is disabled, off for model, or the
+// cache fails.
+func getCachedEmbeddings(ctx context.Context, model string, inputs []string) [][]float64 {
+	vectors := make([][]float64, len(inputs))
+	if !responseCacheEnabled() || cachePolicyFor("embed", model).TTL == 0 {
+		return vectors
+	}
+	keys := make([]string, len(inputs))
+	for i, input := range inputs {
+		keys[i] = getEmbeddingCacheKey(model, input)
+	}
+	values, err := cache.GetMany(ctx, responseCache(), keys)
+	if err != nil {
+		log.Printf("[WARNING] Embedding cache lookup failed: %v", err)
+		return vectors
+	}
+	for i, val := range values {
+		var vec []float64
+		if val != nil && json.Unmarshal(val, &vec) == nil && len(vec) > 0 {
+			vectors[i] = vec
+		}
+	}
+	return vectors
+}
+
+// storeEmbeddings caches vectors for the embed policy's TTL. Embeddings are
+// never served stale.
+func storeEmbeddings(ctx context.Context, model string, inputs []string, vectors [][]float64) {
+	ttl := cachePolicyFor("embed", model).TTL
+	if !responseCacheEnabled() || ttl == 0 {
+		return
+	}
+	keys := make([]string, 0, len(inputs))
+	values := make([][]byte, 0, len(inputs))
+	for i, input := range inputs {
+		data, err := json.Marshal(vectors[i])
+		if err != nil {
+			continue
+		}
+		keys = append(keys, getEmbeddingCacheKey(model, input))
+		values = append(values, data)
+	}
+	if err := cache.SetMany(ctx, responseCache(), keys, values, ttl); err != nil {
+		log.Printf("[WARNING] Failed to store embeddings in cache: %v", err)
+	}
+}
+
+// callEmbeddings sends inputs to the OpenRouter embeddings API and returns
+// one vector per input, in order, with the usage the provider reported. Outcomes
+// feed model health and the provider circuit breaker like callAIProviders.
+func callEmbeddings(ctx context.Context, model string, inputs []string) (vectors [][]float64, usage ProviderUsage, err error) {
+	start := time.Now()
+	defer func() {
+		modelHealth.Record(model, time.Since(start), isModelFailure(err))
+		providerCircuit.Record(getConfig(), isModelFailure(err))
+		gatewayStats.RecordAICall(time.Now(), time.Since(start))
+	}()
+
+	reqBody, _ := json.Marshal(map[string]interface{}{
+		"model": model,
+		"input": inputs,
+		"usage": map[string]bool{"include": true},
+	})
+	embeddingsURL := getEnv("OPENROUTER_EMBEDDINGS_URL", "https://openrouter.ai/api/v1/embeddings")
+	req, err := http.NewRequestWithContext(ctx, "POST", embeddingsURL, bytes.NewBuffer(reqBody))
+	if err != nil {
+		return nil, ProviderUsage{}, fmt.Errorf("failed to create embeddings request: %w", err)
+	}
+	req.Header.Set("Authorization", "Bearer "+os.Getenv("OPENROUTER_API_KEY"))
+	req.Header.Set("Content-Type", "application/json")
+	if cid, ok := ctx.Value(correlationIDKey).(string); ok {
+		req.Header.Set("X-Correlation-ID", cid)
+	}
+
+	resp, err := getConfig().ProviderHTTPClient().Do(req)
+	if err != nil {
+		if errors.Is(err, context.DeadlineExceeded) || ctx.Err() == context.DeadlineExceeded {
+			return nil, ProviderUsage{}, context.DeadlineExceeded
+		}
+		return nil, ProviderUsage{}, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, ProviderUsage{}, fmt.Errorf("embeddings provider returned status %d", resp.StatusCode)
+	}
+
+	var result struct {
+		Data []struct {
+			Index     int       `json:"index"`
+			Embedding []float64 `json:"embedding"`
+		} `json:"data"`
+		Usage ProviderUsage `json:"usage"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, ProviderUsage{}, fmt.Errorf("failed to decode embeddings response: %w", err)
+	}
+	if len(result.Data) != len(inputs) {
+		return nil, ProviderUsage{}, fmt.Errorf("invalid response from AI provider: got %d embeddings for %d inputs", len(result.Data), len(inputs))
+	}
+	sort.Slice(result.Data, func(i, j int) bool { return result.Data[i].Index < result.Data[j].Index })
+	vectors = make([][]float64, len(inputs))
+	for i, d := range result.Data {
+		if len(d.Embedding) == 0 {
+			return nil, ProviderUsage{}, fmt.Errorf("invalid response from AI provider: empty embedding")
+		}
+		vectors[i] = d.Embedding
+	}
+	return vectors, result.Usage, nil
+}
@@ -0,0 +1,155 @@
+package server
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"gateway/internal/testsupport"
+	"gateway/receipts"
+)
+
+func TestParseEmbedInputs(t *testing.T) {
+	tests := []struct {
+		raw     string
+		want    []string
+		wantErr bool
+	}{
+		{`"hello"`, []string{"hello"}, false},
+		{`["a","b"]`, []string{"a", "b"}, false},
+		{`[]`, nil, true},
+		{`["a",""]`, nil, true},
+		{`["a","b","c"]`, nil, true}, // over the limit of 2
+		{`42`, nil, true},
+		{``, nil, true},
+	}
+	for _, tt := range tests {
+		got, err := parseEmbedInputs(json.RawMessage(tt.raw), 2)
+		if (err != nil) != tt.wantErr || !slices.Equal(got, tt.want) {
+			t.Errorf("parseEmbedInputs(%s) = %v, %v", tt.raw, got, err)
+		}
+	}
+}
+
+func TestEmbeddingPrice(t *testing.T) {
+	cfg := &Config{Embeddings: EmbeddingConfig{PricePer1KTokens: 20}}
+	if got := estimateTokens([]string{"abcd", "abcde", "x"}); got != 4 {
+		t.Errorf("expected 4 estimated tokens, got %d", got)
+	}
+	for tokens, want := range map[int]string{1: "0.000001", 1000: "0.00002", 1001: "0.000021", 50000: "0.001"} {
+		if got := embeddingPrice(cfg, tokens); got != want {
+			t.Errorf("price of %d tokens: expected %s, got %s", tokens, want, got)
+		}
+	}
+}
+
+func TestEmbed_QuoteAndReceipt(t *testing.T) {
+	t.Setenv("EMBEDDING_MODEL", "test/embed-small")
+	t.Setenv("EMBEDDING_PRICE_PER_1K_TOKENS", "1")
+	h := testsupport.NewHarness(t, newTestRouter)
+	h.AI.SetDimensions(8)
+	body := `{"text":["` + strings.Repeat("a", 400) + `","` + strings.Repeat("b", 400) + `"]}`
+
+	resp := h.Post(t, "/api/ai/embed", body, "", "")
+	var quoted struct {
+		Quote EmbedQuote `json:"quote"`
+	}
+	_ = json.NewDecoder(resp.Body).Decode(&quoted)
+	if resp.StatusCode != http.StatusPaymentRequired || quoted.Quote.InputTokens != 200 || quoted.Quote.Price != "0.2" || quoted.Quote.Inputs != 2 {
+		t.Fatalf("unexpected quote %d %+v", resp.StatusCode, quoted.Quote)
+	}
+
+	resp = h.Post(t, "/api/ai/embed", body, "0xsig", "nonce")
+	data, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", resp.StatusCode, data)
+	}
+	var out EmbedResponse
+	if err := json.Unmarshal(data, &out); err != nil {
+		t.Fatal(err)
+	}
+	if out.Model != "test/embed-small" || out.Dimensions != 8 || len(out.Data) != 2 || out.Data[1].Index != 1 {
+		t.Fatalf("unexpected response %+v", out)
+	}
+	if !slices.Equal(out.Data[1].Embedding, testsupport.FakeEmbedding(strings.Repeat("b", 400), 8)) {
+		t.Error("vectors should be returned in input order")
+	}
+	if h.AI.EmbedCalls() != 1 {
+		t.Errorf("batch should be one provider call, got %d", h.AI.EmbedCalls())
+	}
+
+	receipt := decodeReceiptHeader(t, resp.Header.Get("X-402-Receipt"))
+	svc := receipt.Receipt.Service
+	if svc.Model != "test/embed-small" || svc.Dimensions != 8 || receipt.Receipt.Payment.Amount != "0.2" {
+		t.Errorf("unexpected receipt %+v %+v", svc, receipt.Receipt.Payment)
+	}
+	if svc.ResponseHash != receipts.HashData(data) {
+		t.Error("receipt response_hash should cover the response body")
+	}
+}
+
+func TestEmbed_Validation(t *testing.T) {
+	t.Setenv("EMBEDDING_MAX_INPUTS", "2")
+	h := testsupport.NewHarness(t, newTestRouter)
+
+	for _, body := range []string{`{"text":["a","b","c"]}`, `{"text":[]}`, `{"text":1}`, `not json`} {
+		if resp := h.Post(t, "/api/ai/embed", body, "0xsig", "nonce"); resp.StatusCode != http.StatusBadRequest {
+			t.Errorf("%s: expected 400, got %d", body, resp.StatusCode)
+		}
+	}
+	if h.Verifier.Calls() != 0 {
+		t.Error("invalid requests should be rejected before verification")
+	}
+}
+
+func TestEmbed_ProviderFailure(t *testing.T) {
+	withCircuit(t)
+	h := testsupport.NewHarness(t, newTestRouter)
+	h.AI.SetStatus(http.StatusInternalServerError)
+
+	if resp := h.Post(t, "/api/ai/embed", `{"text":"hello"}`, "0xsig", "nonce"); resp.StatusCode != http.StatusInternalServerError {
+		t.Errorf("expected 500, got %d", resp.StatusCode)
+	}
+}
+
+func TestEmbed_V2Input(t *testing.T) {
+	h := testsupport.NewHarness(t, newTestRouter)
+	resp := postV2(t, h, "/api/v2/ai/embed", `{"input":["one","two"]}`, "0xsig", "nonce")
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected 200, got %d", resp.StatusCode)
+	}
+	if resp.Header.Get("Deprecation") != "" {
+		t.Error("v2 input field should not be marked deprecated")
+	}
+}
+
+func TestEmbed_CachesVectorsByContent(t *testing.T) {
+	gw := startGateway(t, nil)
+
+	if resp := gw.Post(t, "/api/ai/embed", `{"text":["alpha","beta"]}`, "0xsig", "n1"); resp.StatusCode != http.StatusOK {
+		t.Fatalf("first request: expected 200, got %d", resp.StatusCode)
+	}
+	deadline := time.Now().Add(2 * time.Second)
+	for len(gw.redisKeys("ai:embedding:")) < 2 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond) // cache writes are asynchronous
+	}
+
+	resp := gw.Post(t, "/api/ai/embed", `{"text":["beta","gamma","alpha"]}`, "0xsig", "n2")
+	var out EmbedResponse
+	if err := json.NewDecoder(resp.Body).Decode(&out); err != nil || resp.StatusCode != http.StatusOK {
+		t.Fatalf("second request: %d %v", resp.StatusCode, err)
+	}
+	if got := gw.AI.EmbedInputs(); !slices.Equal(got, []string{"alpha", "beta", "gamma"}) {
+		t.Errorf("only the uncached input should reach the provider, got %v", got)
+	}
+	if out.Usage.CachedInputs != 2 {
+		t.Errorf("expected 2 cached inputs, got %d", out.Usage.CachedInputs)
+	}
+	if !slices.Equal(out.Data[2].Embedding, testsupport.FakeEmbedding("alpha", 4)) {
+		t.Error("cached vector should be returned at its input position")
+	}
+}
@@ -0,0 +1,258 @@
+package server
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"regexp"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// PaidRequest is what a PaidHandler is given for one paid request.
+type PaidRequest struct {
+	// Body is the JSON request body, translated to the v1 format by
+	// apiCompatMiddleware on v2 routes.
+	Body []byte
+	// Payer is the address that paid for the request.
+	Payer string
+}
+
+// PaidHandler serves one paid request. It returns the JSON response body and
+// the provider cost in USD for margin reporting. An error fails the request
+// with 500 (504 when ctx expired) and a refund voucher.
+type PaidHandler func(ctx context.Context, req PaidRequest) (response interface{}, costUSD float64, err error)
+
+// PaidEndpoint describes a paid service mounted at POST /api/ai/<Name> and
+// /api/v2/ai/<Name>. The registry wraps Handler in the same flow as the
+// built-in endpoints: 402 challenge, payment verification or voucher
+// redemption, spend caps, optional caching, receipts and margin recording.
+type PaidEndpoint struct {
+	Name string
+	// Price is charged for every request; empty uses PAYMENT_AMOUNT.
+	Price string
+	// Timeout bounds the request when shorter than AI_REQUEST_TIMEOUT_SECONDS.
+	Timeout time.Duration
+	// CacheTTL caches responses by request body for that long when
+	// CACHE_ENABLED is set. Cache hits are still paid for. Zero disables
+	// caching. A CACHE_POLICIES entry for Name overrides it.
+	CacheTTL time.Duration
+	// Validate, if set, rejects a request body with 400 before payment.
+	Validate func(body []byte) error
+	Handler  PaidHandler
+}
+
+// price returns the amount charged for ep under cfg.
+func (ep PaidEndpoint) price(cfg *Config) string {
+	if ep.Price != "" {
+		return ep.Price
+	}
+	return cfg.PaymentAmount
+}
+
+var endpointNamePattern = regexp.MustCompile(`^[a-z][a-z0-9-]{0,31}$`)
+
+// reservedEndpointNames are the built-in routes under /api/ai.
+var reservedEndpointNames = []string{"summarize", "embed", "estimate", "jobs"}
+
+// EndpointRegistry holds the paid endpoints mounted next to the built-in AI
+// routes. Endpoints must be registered before the router is built, e.g. from
+// an init function.
+type EndpointRegistry struct {
+	mu        sync.RWMutex
+	endpoints []PaidEndpoint
+}
+
+// NewEndpointRegistry returns an empty registry.
+func NewEndpointRegistry() *EndpointRegistry {
+	return &EndpointRegistry{}
+}
+
+// paidEndpoints is the registry setupRouter mounts.
+var paidEndpoints = NewEndpointRegistry()
+
+// Register adds ep. It rejects invalid or reserved names, duplicates, a
+// missing handler and an unparseable or non-positive price.
+func (r *EndpointRegistry) Register(ep PaidEndpoint) error {
+	if !endpointNamePattern.MatchString(ep.Name) {
+		return fmt.Errorf("invalid endpoint name %q: use lowercase letters, digits and dashes", ep.Name)
+	}
+	if slices.Contains(reservedEndpointNames, ep.Name) {
+		return fmt.Errorf("endpoint name %q is reserved", ep.Name)
+	}
+	if ep.Handler == nil {
+		return fmt.Errorf("endpoint %q has no handler", ep.Name)
+	}
+	if ep.Price != "" {
+		if units, err := parseTokenAmount(ep.Price); err != nil || units <= 0 {
+			return fmt.Errorf("endpoint %q: price must be a positive amount", ep.Name)
+		}
+	}
+	if ep.Timeout < 0 || ep.CacheTTL < 0 {
+		return fmt.Errorf("endpoint %q: timeout and cache TTL must not be negative", ep.Name)
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, existing := range r.endpoints {
+		if existing.Name == ep.Name {
+			return fmt.Errorf("endpoint %q is already registered", ep.Name)
+		}
+	}
+	r.endpoints = append(r.endpoints, ep)
+	return nil
+}
+
+// MustRegister is Register for init functions; it panics on error.
+func (r *EndpointRegistry) MustRegister(ep PaidEndpoint) {
+	if err := r.Register(ep); err != nil {
+		panic(err)
+	}
+}
+
+// Endpoints returns the registered endpoints in registration order.
+func (r *EndpointRegistry) Endpoints() []PaidEndpoint {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return slices.Clone(r.endpoints)
+}
+
+// mount registers a route on group for every endpoint.
+func (r *EndpointRegistry) mount(group *gin.RouterGroup) {
+	for _, ep := range r.Endpoints() {
+		var handlers []gin.HandlerFunc
+		if ep.Timeout > 0 {
+			handlers = append(handlers, RequestTimeoutMiddleware(ep.Timeout))
+		}
+		group.POST("/"+ep.Name, append(handlers, servePaidEndpoint(ep))...)
+	}
+}
+
+// paidCacheKey keys a cached response by endpoint and request body.
+func paidCacheKey(name string, body []byte) string {
+	hash := sha256.Sum256(append([]byte(cacheVersion()+":"+name+":"), body...))
+	return "ai:" + name + ":" + hex.EncodeToString(hash[:])
+}
+
+// servePaidEndpoint runs the paid request flow around ep.Handler.
+func servePaidEndpoint(ep PaidEndpoint) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		signature := c.GetHeader("X-402-Signature")
+		nonce := c.GetHeader("X-402-Nonce")
+		price := ep.price(getConfig())
+		if signature == "" || nonce == "" {
+			respondPaymentRequired(c, price, nil)
+			return
+		}
+
+		const maxBodySize = 10 * 1024 * 1024
+		c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, int64(maxBodySize))
+		requestBody, err := io.ReadAll(c.Request.Body)
+		if err != nil {
+			var maxBytesErr *http.MaxBytesError
+			if errors.As(err, &maxBytesErr) {
+				respondAPIError(c, errPayloadTooLarge())
+			} else {
+				respondError(c, CodeBodyReadFailed, "The request body could not be read")
+			}
+			return
+		}
+		if !json.Valid(requestBody) {
+			respondError(c, CodeInvalidBody, "Request must be valid JSON")
+			return
+		}
+		if ep.Validate != nil {
+			if err := ep.Validate(requestBody); err != nil {
+				respondError(c, CodeInvalidRequest, err.Error())
+				return
+			}
+		}
+
+		verifyResp, paymentCtx, ok := authorizePayment(c, signature, nonce, price)
+		if !ok {
+			return
+		}
+		refundSpend, ok := reserveSpend(c, verifyResp.RecoveredAddress, price)
+		if !ok {
+			return
+		}
+
+		cacheKey := ""
+		policy := getConfig().Cache.For(ep.Name, "", ep.CacheTTL)
+		if policy.TTL > 0 && getCacheEnabled() {
+			cacheKey = paidCacheKey(ep.Name, requestBody)
+			lookupStart := time.Now()
+			cached, err := getFromCache(c.Request.Context(), cacheKey)
+			recordCacheTiming(c, lookupStart, err == nil)
+			if err == nil {
+				log.Printf("Cache HIT: %s", cacheKey)
+				stale := cached.stale(time.Now())
+				if stale {
+					c.Header("X-Cache-Status", "stale")
+				}
+				if err := sendWithReceipt(c, *paymentCtx, verifyResp.RecoveredAddress, requestBody, json.RawMessage(cached.Result)); err != nil {
+					refundSpend()
+					log.Printf("Failed to send cached response receipt: %v", err)
+					return
+				}
+				recordMargin(c, *paymentCtx, verifyResp.RecoveredAddress, ProviderUsage{})
+				if stale {
+					req := PaidRequest{Body: requestBody, Payer: verifyResp.RecoveredAddress}
+					refreshCacheEntry(cacheKey, policy, func(ctx context.Context) (string, error) {
+						response, _, err := ep.Handler(ctx, req)
+						if err != nil {
+							return "", err
+						}
+						data, err := json.Marshal(response)
+						return string(data), err
+					})
+				}
+				return
+			}
+		}
+
+		stopTiming := startTiming(c, timingAI)
+		response, cost, err := ep.Handler(c.Request.Context(), PaidRequest{Body: requestBody, Payer: verifyResp.RecoveredAddress})
+		stopTiming()
+		if err != nil {
+			refundSpend()
+			log.Printf("Endpoint %s failed: %v", ep.Name, err)
+			if errors.Is(err, context.DeadlineExceeded) || c.Request.Context().Err() == context.DeadlineExceeded {
+				respondWithRefund(c, newAPIError(CodeUpstreamTimeout, "Request timed out"), *paymentCtx, verifyResp.RecoveredAddress)
+				return
+			}
+			respondWithRefund(c, newAPIError(CodeUpstreamFailed, "The upstream service request failed").withDetails(err.Error()), *paymentCtx, verifyResp.RecoveredAddress)
+			return
+		}
+		responseBody, err := json.Marshal(response)
+		if err != nil {
+			refundSpend()
+			respondError(c, CodeInternal, "Failed to encode response")
+			return
+		}
+
+		if err := sendWithReceipt(c, *paymentCtx, verifyResp.RecoveredAddress, requestBody, json.RawMessage(responseBody)); err != nil {
+			refundSpend()
+			log.Printf("Failed to generate receipt: %v", err)
+			return
+		}
+		recordMargin(c, *paymentCtx, verifyResp.RecoveredAddress, ProviderUsage{Cost: cost})
+
+		if cacheKey != "" {
+			go func() {
+				ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+				defer cancel()
+				storeInCacheFor(ctx, cacheKey, string(responseBody), policy)
+			}()
+		}
+	}
+}
@@ -0,0 +1,183 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"gateway/internal/testsupport"
+)
+
+// withEndpointRegistry installs a fresh registry holding eps for the test.
+func withEndpointRegistry(t *testing.T, eps ...PaidEndpoint) {
+	t.Helper()
+	prev := paidEndpoints
+	paidEndpoints = NewEndpointRegistry()
+	t.Cleanup(func() { paidEndpoints = prev })
+	for _, ep := range eps {
+		if err := paidEndpoints.Register(ep); err != nil {
+			t.Fatalf("register %s: %v", ep.Name, err)
+		}
+	}
+}
+
+// sentimentEndpoint is a test endpoint that counts its calls.
+func sentimentEndpoint(calls *atomic.Int32) PaidEndpoint {
+	return PaidEndpoint{
+		Name:  "sentiment",
+		Price: "0.002",
+		Validate: func(body []byte) error {
+			var req struct{ Text string }
+			if json.Unmarshal(body, &req); req.Text == "" {
+				return errors.New("text field cannot be empty")
+			}
+			return nil
+		},
+		Handler: func(ctx context.Context, req PaidRequest) (interface{}, float64, error) {
+			calls.Add(1)
+			return map[string]string{"label": "positive", "payer": req.Payer}, 0.0001, nil
+		},
+	}
+}
+
+func TestEndpointRegistry_RegisterValidation(t *testing.T) {
+	ok := func(context.Context, PaidRequest) (interface{}, float64, error) { return nil, 0, nil }
+	r := NewEndpointRegistry()
+	if err := r.Register(PaidEndpoint{Name: "classify", Handler: ok}); err != nil {
+		t.Fatalf("expected classify to register, got %v", err)
+	}
+	for name, ep := range map[string]PaidEndpoint{
+		"reserved":   {Name: "summarize", Handler: ok},
+		"duplicate":  {Name: "classify", Handler: ok},
+		"bad name":   {Name: "Classify/v2", Handler: ok},
+		"no handler": {Name: "sentiment"},
+		"zero price": {Name: "sentiment", Price: "0", Handler: ok},
+		"bad price":  {Name: "sentiment", Price: "cheap", Handler: ok},
+		"negative":   {Name: "sentiment", Timeout: -time.Second, Handler: ok},
+	} {
+		if err := r.Register(ep); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+	if got := r.Endpoints(); len(got) != 1 || got[0].Name != "classify" {
+		t.Errorf("expected only classify registered, got %+v", got)
+	}
+}
+
+func TestPaidEndpoint_PaymentFlowAndReceipt(t *testing.T) {
+	var calls atomic.Int32
+	withEndpointRegistry(t, sentimentEndpoint(&calls))
+	h := testsupport.NewHarness(t, newTestRouter)
+
+	resp := h.Post(t, "/api/ai/sentiment", `{"text":"great"}`, "", "")
+	if resp.StatusCode != http.StatusPaymentRequired || resp.Header.Get("X-402-Price") != "0.002" {
+		t.Fatalf("expected 402 at the endpoint price, got %d %q", resp.StatusCode, resp.Header.Get("X-402-Price"))
+	}
+
+	if resp := h.Post(t, "/api/ai/sentiment", `{"text":""}`, "0xsig", "nonce-invalid"); resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("expected 400 from Validate, got %d", resp.StatusCode)
+	}
+	if h.Verifier.Calls() != 0 {
+		t.Error("expected invalid requests to be rejected before verification")
+	}
+
+	resp = h.Post(t, "/api/v2/ai/sentiment", `{"text":"great"}`, "0xsig", "nonce-paid")
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected 200, got %d", resp.StatusCode)
+	}
+	var body map[string]string
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		t.Fatal(err)
+	}
+	if body["label"] != "positive" || body["payer"] != testsupport.DefaultPayer {
+		t.Errorf("unexpected response %v", body)
+	}
+	if got := h.Verifier.Requests()[0].Context.Amount; got != "0.002" {
+		t.Errorf("expected the payment verified at 0.002, got %s", got)
+	}
+	receipt := decodeReceiptHeader(t, resp.Header.Get("X-402-Receipt"))
+	if receipt.Receipt.Service.Endpoint != "/api/v2/ai/sentiment" || receipt.Receipt.Payment.Amount != "0.002" {
+		t.Errorf("unexpected receipt %+v", receipt.Receipt)
+	}
+}
+
+func TestPaidEndpoint_FailureRefundsWithVoucher(t *testing.T) {
+	withVouchers(t)
+	withEndpointRegistry(t, PaidEndpoint{
+		Name: "classify",
+		Handler: func(context.Context, PaidRequest) (interface{}, float64, error) {
+			return nil, 0, errors.New("classifier unavailable")
+		},
+	}, PaidEndpoint{
+		Name:    "slow",
+		Timeout: 50 * time.Millisecond,
+		Handler: func(ctx context.Context, _ PaidRequest) (interface{}, float64, error) {
+			<-ctx.Done()
+			return nil, 0, ctx.Err()
+		},
+	})
+	h := testsupport.NewHarness(t, newTestRouter)
+
+	resp := h.Post(t, "/api/ai/classify", `{}`, "0xsig", "nonce-fail")
+	var body struct {
+		Error         string `json:"error"`
+		RefundVoucher *struct {
+			Nonce string `json:"nonce"`
+		} `json:"refund_voucher"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusInternalServerError || body.RefundVoucher == nil || body.RefundVoucher.Nonce != "nonce-fail" {
+		t.Errorf("expected 500 with a refund voucher, got %d %+v", resp.StatusCode, body)
+	}
+
+	if resp := h.Post(t, "/api/ai/slow", `{}`, "0xsig", "nonce-slow"); resp.StatusCode != http.StatusGatewayTimeout {
+		t.Errorf("expected 504 after the endpoint timeout, got %d", resp.StatusCode)
+	}
+}
+
+func TestPaidEndpoint_CachedResponsesStillPaid(t *testing.T) {
+	var calls atomic.Int32
+	ep := sentimentEndpoint(&calls)
+	ep.CacheTTL = time.Minute
+	withEndpointRegistry(t, ep)
+	gw := startGateway(t, nil)
+
+	for i, nonce := range []string{"nonce-1", "nonce-2"} {
+		resp := gw.Post(t, "/api/ai/sentiment", `{"text":"great"}`, "0xsig", nonce)
+		if resp.StatusCode != http.StatusOK || resp.Header.Get("X-402-Receipt") == "" {
+			t.Fatalf("request %d: expected 200 with a receipt, got %d", i, resp.StatusCode)
+		}
+		if i == 0 {
+			deadline := time.Now().Add(2 * time.Second)
+			for len(gw.redisKeys("ai:sentiment:")) == 0 && time.Now().Before(deadline) {
+				time.Sleep(10 * time.Millisecond)
+			}
+		}
+	}
+	if got := calls.Load(); got != 1 {
+		t.Errorf("expected the handler to run once, got %d", got)
+	}
+	if got := gw.Verifier.Calls(); got != 2 {
+		t.Errorf("expected both requests to be verified, got %d", got)
+	}
+}
+
+func TestPaidEndpoint_ListedInDiscovery(t *testing.T) {
+	var calls atomic.Int32
+	withEndpointRegistry(t, sentimentEndpoint(&calls))
+	var found *pricedEndpoint
+	for _, ep := range pricedEndpoints(getConfig()) {
+		if ep.Path == "/api/ai/sentiment" {
+			found = &ep
+		}
+	}
+	if found == nil || found.Price != "0.002" {
+		t.Errorf("expected sentiment listed at 0.002, got %+v", found)
+	}
+}
@@ -0,0 +1,31 @@
+package server
+
+import (
+	"gateway/receipts"
+
+	"github.com/gin-gonic/gin"
+)
+
+// SummaryResponse is the body of a paid summary. Fields are encoded in
+// declaration order by receipts.EncodeResponse, and the receipt's
+// ResponseHash covers exactly those bytes, so the order is part of the wire
+// format: add fields at the end, never reorder them.
+type SummaryResponse struct {
+	Result string `json:"result"`
+	// ReceiptID names the receipt issued for this body; it is also in the
+	// X-402-Receipt header.
+	ReceiptID     string `json:"receipt_id,omitempty"`
+	CorrelationID string `json:"correlation_id,omitempty"`
+}
+
+// newSummaryResponse returns the body for summary, issued under receipt ID
+// receiptID within the request c.
+func newSummaryResponse(c *gin.Context, summary, receiptID string) SummaryResponse {
+	return SummaryResponse{Result: summary, ReceiptID: receiptID, CorrelationID: c.GetString("correlation_id")}
+}
+
+// summaryResponseHash is the ResponseHash of a receipt for resp.
+func summaryResponseHash(resp SummaryResponse) string {
+	body, _ := receipts.EncodeResponse(resp)
+	return receipts.HashData(body)
+}
@@ -0,0 +1,87 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"gateway/internal/testsupport"
+	"gateway/receipts"
+)
+
+var updateGolden = flag.Bool("update", false, "rewrite the testdata/*.golden files")
+
+// TestSummaryResponse_Golden locks the wire format the receipt's
+// ResponseHash covers. A change here breaks every client that recomputes the
+// hash; run go test -run Golden -update only for an intended change.
+func TestSummaryResponse_Golden(t *testing.T) {
+	tests := []struct {
+		name string
+		resp SummaryResponse
+	}{
+		{"summary_plain", SummaryResponse{Result: "A short summary.", ReceiptID: "rcpt_0123456789abcdef", CorrelationID: "corr-1"}},
+		{"summary_html", SummaryResponse{Result: `Use <b>R&D</b> "quotes" \ and a` + "\ttab", ReceiptID: "rcpt_0123456789abcdef", CorrelationID: "corr-1"}},
+		{"summary_unicode", SummaryResponse{Result: "naïve café — 日本語 😀\u2028next\u2029end", ReceiptID: "rcpt_0123456789abcdef", CorrelationID: "corr-1"}},
+		{"summary_result_only", SummaryResponse{Result: "Async job result"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := receipts.EncodeResponse(tt.resp)
+			if err != nil {
+				t.Fatal(err)
+			}
+			path := filepath.Join("testdata", tt.name+".golden")
+			if *updateGolden {
+				if err := os.WriteFile(path, got, 0o644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			want, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatalf("read golden file (run with -update to create it): %v", err)
+			}
+			if !bytes.Equal(got, want) {
+				t.Errorf("wire format changed:\n got %s\nwant %s", got, want)
+			}
+			var back SummaryResponse
+			if err := json.Unmarshal(got, &back); err != nil || back != tt.resp {
+				t.Errorf("expected %+v to round-trip, got %+v (%v)", tt.resp, back, err)
+			}
+		})
+	}
+}
+
+func TestSummarize_BodyIsHashedEnvelope(t *testing.T) {
+	withMemoryCache(t)
+	h := testsupport.NewHarness(t, newTestRouter)
+	h.AI.SetReply("Use <b>R&D</b> & more")
+
+	resp := h.Post(t, "/api/ai/summarize", `{"text":"hello"}`, "0xsig", "envelope-1")
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected 200, got %d", resp.StatusCode)
+	}
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	receipt := decodeReceiptHeader(t, resp.Header.Get("X-402-Receipt"))
+	if receipts.HashData(body) != receipt.Receipt.Service.ResponseHash {
+		t.Fatalf("expected the receipt to hash the body as sent: %s", body)
+	}
+	var out SummaryResponse
+	if err := json.Unmarshal(body, &out); err != nil {
+		t.Fatal(err)
+	}
+	want := SummaryResponse{Result: "Use <b>R&D</b> & more", ReceiptID: receipt.Receipt.ID, CorrelationID: resp.Header.Get("X-Correlation-ID")}
+	if out != want || out.CorrelationID == "" {
+		t.Errorf("expected %+v, got %+v", want, out)
+	}
+	if !bytes.Contains(body, []byte("<b>R&D</b>")) {
+		t.Errorf("expected HTML characters unescaped, got %s", body)
+	}
+}
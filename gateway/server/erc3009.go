@@ -0,0 +1,265 @@
+package server
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"encoding/json"
+	"fmt"
+	"log"
+	"math/big"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"gateway/payments"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+// outboxERC3009Relay submits a verified transfer authorization on-chain.
+const outboxERC3009Relay = "erc3009.relay"
+
+// usdcDomains are the EIP-712 domains of USDC on the known chains.
+var usdcDomains = map[int]payments.TokenDomain{
+	8453:     {Name: "USD Coin", Version: "2", ChainID: 8453, VerifyingContract: "0x833589fCD6eDb6E08f4c3C32D4f71b54bdA02913"},
+	84532:    {Name: "USDC", Version: "2", ChainID: 84532, VerifyingContract: "0x036CbD53842c5426634e7929541eC2318f3dCF7e"},
+	10:       {Name: "USD Coin", Version: "2", ChainID: 10, VerifyingContract: "0x0b2C639c533813f4Aa9D7837CAf62653d097Ff85"},
+	11155420: {Name: "USDC", Version: "2", ChainID: 11155420, VerifyingContract: "0x5fd84259d66Cd46123540766Be93DFE6D43130D7"},
+	42161:    {Name: "USD Coin", Version: "2", ChainID: 42161, VerifyingContract: "0xaf88d065e77c8cC2239327C5EDb3A432268e5831"},
+	421614:   {Name: "USDC", Version: "2", ChainID: 421614, VerifyingContract: "0x75faf114eafb1BDbe2F0316DF893fd58CE46AA4d"},
+	137:      {Name: "USD Coin", Version: "2", ChainID: 137, VerifyingContract: "0x3c499c542cEF5E3811e1192ce70d8cC03d5c3359"},
+	80002:    {Name: "USDC", Version: "2", ChainID: 80002, VerifyingContract: "0x41E94Eb019C0762f9Bfcf9Fb1E58725BfB0e7582"},
+}
+
+// parseERC3009Tokens returns the USDC domains with ERC3009_TOKENS applied:
+// "<chain name or id>=<token address>[:<EIP-712 name>]" entries for chains
+// without a known deployment or with another token. The name defaults to
+// "USD Coin" and the version is always "2".
+func parseERC3009Tokens(raw []string) (map[int]payments.TokenDomain, error) {
+	tokens := make(map[int]payments.TokenDomain, len(usdcDomains)+len(raw))
+	for id, d := range usdcDomains {
+		tokens[id] = d
+	}
+	for _, entry := range raw {
+		ref, token, ok := strings.Cut(entry, "=")
+		if !ok {
+			return nil, fmt.Errorf("token %q must be chain=address", entry)
+		}
+		id, err := parseChainRef(strings.TrimSpace(ref))
+		if err != nil {
+			return nil, err
+		}
+		address, name, _ := strings.Cut(strings.TrimSpace(token), ":")
+		if !common.IsHexAddress(address) {
+			return nil, fmt.Errorf("chain %q: invalid token address %q", ref, address)
+		}
+		if name == "" {
+			name = "USD Coin"
+		}
+		tokens[id] = payments.TokenDomain{Name: name, Version: "2", ChainID: id, VerifyingContract: common.HexToAddress(address).Hex()}
+	}
+	return tokens, nil
+}
+
+// erc3009Scheme verifies ERC-3009 transferWithAuthorization payloads. The
+// authorization must move exactly the price from its signer to the
+// payment's recipient, be valid now and carry the payment nonce's
+// AuthorizationNonce, or the payment nonce itself as standard x402 clients
+// send it, so the gateway's nonce check also stops it from paying twice.
+type erc3009Scheme struct{}
+
+func (erc3009Scheme) Verify(_ context.Context, payment PaymentContext, signature, _ string) (*VerifyResponse, error) {
+	p, err := payments.DecodeERC3009Payload(signature)
+	if err != nil {
+		return &VerifyResponse{Error: err.Error(), Reason: payments.ReasonMalformedSignature}, nil
+	}
+	domain, ok := getConfig().Signatures.Tokens[payment.ChainID]
+	if !ok {
+		return &VerifyResponse{Error: fmt.Sprintf("erc3009 payments are not accepted on chain %d", payment.ChainID)}, nil
+	}
+	a := p.Authorization
+	if !common.IsHexAddress(a.To) || common.HexToAddress(a.To) != common.HexToAddress(payment.Recipient) {
+		return &VerifyResponse{Error: "the authorization does not pay the recipient", Reason: payments.ReasonWrongRecipient}, nil
+	}
+	amount, err := payment.ParseAmount()
+	if err != nil {
+		return &VerifyResponse{Error: err.Error()}, nil
+	}
+	if value, ok := new(big.Int).SetString(a.Value, 10); !ok || value.Cmp(big.NewInt(amount.Units)) != 0 {
+		return &VerifyResponse{Error: fmt.Sprintf("the authorization value must be %d base units", amount.Units), Reason: payments.ReasonWrongAmount}, nil
+	}
+	if !strings.EqualFold(a.Nonce, payments.AuthorizationNonce(payment.Nonce)) && !strings.EqualFold(a.Nonce, payment.Nonce) {
+		return &VerifyResponse{Error: "the authorization nonce must be the payment nonce or its keccak256 hash"}, nil
+	}
+	now := time.Now().Unix()
+	if now <= a.ValidAfter {
+		return &VerifyResponse{Error: "the authorization is not valid yet"}, nil
+	}
+	if now >= a.ValidBefore {
+		return &VerifyResponse{Error: "the authorization has expired", Reason: payments.ReasonExpiredContext}, nil
+	}
+	if err := checkAuthorizationWindow(a, time.Unix(now, 0)); err != nil {
+		return &VerifyResponse{Error: err.Error()}, nil
+	}
+	signer, err := payments.RecoverTransferAuthorizationSigner(domain, a, p.Signature)
+	if err != nil {
+		return &VerifyResponse{Error: err.Error(), Reason: payments.ReasonMalformedSignature}, nil
+	}
+	if !common.IsHexAddress(a.From) || signer != common.HexToAddress(a.From) {
+		return &VerifyResponse{Error: "the authorization is not signed by its from address"}, nil
+	}
+	return &VerifyResponse{IsValid: true, RecoveredAddress: signer.Hex()}, nil
+}
+
+// checkAuthorizationWindow rejects an authorization that stays valid after
+// its nonce, claimed at now, is forgotten (NONCE_TTL_SECONDS): it could then
+// be spent again, and the relay, finding it already used on-chain, would not
+// settle it, so the replayed request would be served for free.
+func checkAuthorizationWindow(a payments.TransferAuthorization, now time.Time) error {
+	ttl := getNonceTTL()
+	if a.ValidBefore > now.Add(ttl).Unix() {
+		return fmt.Errorf("the authorization's validBefore must be at most %d seconds ahead, how long spent nonces are remembered", int(ttl.Seconds()))
+	}
+	return nil
+}
+
+// erc3009Relay is the outbox payload of an authorization to submit.
+type erc3009Relay struct {
+	ChainID int                     `json:"chainId"`
+	Payload payments.ERC3009Payload `json:"payload"`
+}
+
+// relayTransferAuthorization submits the verified erc3009 signature of a
+// payment on chainID from the relayer account, when ERC3009_RELAY_ENABLED
+// is set: through the outbox when one is running, so it survives restarts,
+// otherwise in the background.
+func relayTransferAuthorization(ctx context.Context, chainID int, signature string) {
+	if !getConfig().Signatures.Relay {
+		return
+	}
+	p, err := payments.DecodeERC3009Payload(signature)
+	if err != nil {
+		log.Printf("[WARNING] ERC-3009 relay skipped: %v", err)
+		return
+	}
+	ctx = context.WithoutCancel(ctx)
+	if o := activeOutbox.Load(); o != nil {
+		data, _ := json.Marshal(erc3009Relay{ChainID: chainID, Payload: p})
+		ev := OutboxEvent{Kind: outboxERC3009Relay, Key: p.Authorization.Nonce, Payload: data}
+		if err := o.store.Enqueue(ctx, []OutboxEvent{ev}); err != nil {
+			log.Printf("[WARNING] Failed to queue ERC-3009 authorization %s: %v", p.Authorization.Nonce, err)
+			return
+		}
+		o.notify()
+		return
+	}
+	go func() {
+		if _, err := submitTransferAuthorization(ctx, chainID, p); err != nil {
+			log.Printf("[WARNING] Failed to relay ERC-3009 authorization %s: %v", p.Authorization.Nonce, err)
+		}
+	}()
+}
+
+// deliverERC3009Relay submits an authorization from the outbox. An
+// authorization that was already used is not submitted again.
+func deliverERC3009Relay(ctx context.Context, ev OutboxEvent) error {
+	if !getConfig().Signatures.Relay {
+		// Relaying was switched off since the event was written.
+		return nil
+	}
+	var r erc3009Relay
+	if err := json.Unmarshal(ev.Payload, &r); err != nil {
+		return err
+	}
+	_, err := submitTransferAuthorization(ctx, r.ChainID, r.Payload)
+	return err
+}
+
+// erc3009RelayMu serializes submissions so each takes the relayer
+// account's next nonce.
+var erc3009RelayMu sync.Mutex
+
+// erc3009RelayerKey parses ERC3009_RELAYER_PRIVATE_KEY, the account that
+// pays gas for relayed authorizations.
+func erc3009RelayerKey() (*ecdsa.PrivateKey, error) {
+	keyHex := os.Getenv("ERC3009_RELAYER_PRIVATE_KEY")
+	if keyHex == "" {
+		return nil, fmt.Errorf("ERC3009_RELAYER_PRIVATE_KEY not set")
+	}
+	key, err := crypto.HexToECDSA(strings.TrimPrefix(keyHex, "0x"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid ERC3009_RELAYER_PRIVATE_KEY: %w", err)
+	}
+	return key, nil
+}
+
+// submitTransferAuthorization sends the token's transferWithAuthorization
+// for p and returns the transaction hash, or "" when the authorization was
+// already used or canceled.
+func submitTransferAuthorization(ctx context.Context, chainID int, p payments.ERC3009Payload) (string, error) {
+	cfg := getConfig()
+	token, ok := cfg.Signatures.Tokens[chainID]
+	rpcURL, hasRPC := cfg.Signatures.RelayRPCURLs[chainID]
+	if !ok || !hasRPC {
+		return "", fmt.Errorf("no token or RPC URL for chain %d", chainID)
+	}
+	key, err := erc3009RelayerKey()
+	if err != nil {
+		return "", err
+	}
+	state, err := payments.AuthorizationStateCalldata(p.Authorization)
+	if err != nil {
+		return "", err
+	}
+	used, err := ethCall(ctx, rpcURL, token.VerifyingContract, state)
+	if err != nil {
+		return "", fmt.Errorf("authorizationState: %w", err)
+	}
+	if new(big.Int).SetBytes(common.FromHex(used)).Sign() != 0 {
+		return "", nil
+	}
+	data, err := payments.TransferWithAuthorizationCalldata(p)
+	if err != nil {
+		return "", err
+	}
+
+	erc3009RelayMu.Lock()
+	defer erc3009RelayMu.Unlock()
+	from, to := crypto.PubkeyToAddress(key.PublicKey), common.HexToAddress(token.VerifyingContract)
+	var nonce, gas hexutil.Uint64
+	var gasPrice hexutil.Big
+	if err := rpcCall(ctx, rpcURL, "eth_getTransactionCount", []any{from.Hex(), "pending"}, &nonce); err != nil {
+		return "", err
+	}
+	if err := rpcCall(ctx, rpcURL, "eth_gasPrice", []any{}, &gasPrice); err != nil {
+		return "", err
+	}
+	call := map[string]string{"from": from.Hex(), "to": to.Hex(), "data": hexutil.Encode(data)}
+	if err := rpcCall(ctx, rpcURL, "eth_estimateGas", []any{call}, &gas); err != nil {
+		return "", err
+	}
+	tx, err := types.SignTx(types.NewTx(&types.LegacyTx{
+		Nonce:    uint64(nonce),
+		GasPrice: gasPrice.ToInt(),
+		Gas:      uint64(gas) * 6 / 5,
+		To:       &to,
+		Data:     data,
+	}), types.LatestSignerForChainID(big.NewInt(int64(chainID))), key)
+	if err != nil {
+		return "", err
+	}
+	raw, err := tx.MarshalBinary()
+	if err != nil {
+		return "", err
+	}
+	var hash string
+	if err := rpcCall(ctx, rpcURL, "eth_sendRawTransaction", []any{hexutil.Encode(raw)}, &hash); err != nil {
+		return "", err
+	}
+	log.Printf("Relayed ERC-3009 authorization %s from %s in transaction %s", p.Authorization.Nonce, p.Authorization.From, hash)
+	return hash, nil
+}
@@ -0,0 +1,310 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"gateway/internal/testsupport"
+	"gateway/payments"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+// signedAuthorization returns the erc3009 signature paying for payment from
+// key, after edit changes the authorization.
+func signedAuthorization(t *testing.T, key *ecdsa.PrivateKey, payment PaymentContext, edit func(*payments.TransferAuthorization)) string {
+	t.Helper()
+	amount, err := payment.ParseAmount()
+	if err != nil {
+		t.Fatal(err)
+	}
+	a := payments.TransferAuthorization{
+		From:        crypto.PubkeyToAddress(key.PublicKey).Hex(),
+		To:          payment.Recipient,
+		Value:       strconv.FormatInt(amount.Units, 10),
+		ValidAfter:  time.Now().Add(-time.Minute).Unix(),
+		ValidBefore: time.Now().Add(time.Hour).Unix(),
+		Nonce:       payments.AuthorizationNonce(payment.Nonce),
+	}
+	if edit != nil {
+		edit(&a)
+	}
+	sig, err := payments.SignTransferAuthorization(usdcDomains[payment.ChainID], a, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	raw, err := payments.EncodeERC3009Payload(payments.ERC3009Payload{Signature: sig, Authorization: a})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return raw
+}
+
+func TestERC3009_VerifiesTransferAuthorization(t *testing.T) {
+	t.Setenv("SIGNATURE_TYPES", "eip712,erc3009")
+	h := testsupport.NewHarness(t, newTestRouter)
+	key, err := crypto.GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	payment := offeredPayment(t, h)
+
+	resp := postPayment(t, h, paymentHeaderV2{Signature: signedAuthorization(t, key, payment, nil), Nonce: payment.Nonce, SignatureType: "erc3009"})
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected 200, got %d %+v", resp.StatusCode, decodeErrorBody(t, resp))
+	}
+	if h.Verifier.Calls() != 0 {
+		t.Error("erc3009 signatures should not be sent to the EIP-712 verifier")
+	}
+	if payer := decodeReceiptHeader(t, resp.Header.Get("X-402-Receipt")).Receipt.Payment.Payer; payer != crypto.PubkeyToAddress(key.PublicKey).Hex() {
+		t.Errorf("expected the authorization's from address as payer, got %s", payer)
+	}
+
+	other, err := crypto.GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	payment = offeredPayment(t, h)
+	for name, tc := range map[string]struct {
+		signature string
+		code      ErrorCode
+	}{
+		"not a payload": {"0x0102", CodeSignatureMalformed},
+		"wrong amount":  {signedAuthorization(t, key, payment, func(a *payments.TransferAuthorization) { a.Value = "1" }), CodePaymentWrongAmount},
+		"wrong recipient": {signedAuthorization(t, key, payment, func(a *payments.TransferAuthorization) {
+			a.To = "0x2222222222222222222222222222222222222222"
+		}), CodePaymentWrongRecipient},
+		"expired": {signedAuthorization(t, key, payment, func(a *payments.TransferAuthorization) {
+			a.ValidBefore = time.Now().Add(-time.Second).Unix()
+		}), CodePaymentContextExpired},
+		"outlives its nonce": {signedAuthorization(t, key, payment, func(a *payments.TransferAuthorization) {
+			a.ValidBefore = time.Now().Add(getNonceTTL() + time.Minute).Unix()
+		}), CodeSignatureInvalid},
+		"not yet valid": {signedAuthorization(t, key, payment, func(a *payments.TransferAuthorization) {
+			a.ValidAfter = time.Now().Add(time.Hour).Unix()
+		}), CodeSignatureInvalid},
+		"unbound nonce": {signedAuthorization(t, key, payment, func(a *payments.TransferAuthorization) {
+			a.Nonce = payments.AuthorizationNonce("another request")
+		}), CodeSignatureInvalid},
+		"signed by someone else": {signedAuthorization(t, other, payment, func(a *payments.TransferAuthorization) {
+			a.From = crypto.PubkeyToAddress(key.PublicKey).Hex()
+		}), CodeSignatureInvalid},
+	} {
+		resp := postPayment(t, h, paymentHeaderV2{Signature: tc.signature, Nonce: payment.Nonce, SignatureType: "erc3009"})
+		if body := decodeErrorBody(t, resp); body.Code != tc.code {
+			t.Errorf("%s: expected %s, got %d %+v", name, tc.code, resp.StatusCode, body)
+		}
+	}
+	if h.AI.Calls() != 1 {
+		t.Errorf("expected only the valid authorization to reach the AI, got %d calls", h.AI.Calls())
+	}
+}
+
+func TestERC3009_ReplayAfterNonceTTLIsRejected(t *testing.T) {
+	t.Setenv("SIGNATURE_TYPES", "eip712,erc3009")
+	t.Setenv("NONCE_TTL_SECONDS", "1")
+	h := testsupport.NewHarness(t, newTestRouter)
+	key, err := crypto.GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	payment := offeredPayment(t, h)
+
+	// An authorization outliving its nonce could be replayed once the
+	// nonce is forgotten, so it is refused up front.
+	long := signedAuthorization(t, key, payment, nil)
+	if resp := postPayment(t, h, paymentHeaderV2{Signature: long, Nonce: payment.Nonce, SignatureType: "erc3009"}); resp.StatusCode == http.StatusOK {
+		t.Fatal("expected an authorization valid for an hour to be refused with a 1s nonce TTL")
+	}
+
+	validBefore := time.Now().Add(time.Second).Unix()
+	sig := signedAuthorization(t, key, payment, func(a *payments.TransferAuthorization) { a.ValidBefore = validBefore })
+	if resp := postPayment(t, h, paymentHeaderV2{Signature: sig, Nonce: payment.Nonce, SignatureType: "erc3009"}); resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected 200, got %d %+v", resp.StatusCode, decodeErrorBody(t, resp))
+	}
+	// Wait out the nonce TTL, which also passes validBefore.
+	time.Sleep(1100 * time.Millisecond)
+	if spent, _ := nonceSpent(t.Context(), payment.Nonce); spent {
+		t.Fatal("expected the nonce to be forgotten after NONCE_TTL_SECONDS")
+	}
+
+	resp := postPayment(t, h, paymentHeaderV2{Signature: sig, Nonce: payment.Nonce, SignatureType: "erc3009"})
+	if resp.StatusCode == http.StatusOK {
+		t.Fatal("expected the authorization replayed after its nonce expired to be refused")
+	}
+	if h.AI.Calls() != 1 {
+		t.Errorf("expected the replay not to reach the AI, got %d calls", h.AI.Calls())
+	}
+}
+
+// fakeTokenNode serves the JSON-RPC calls the relayer makes, answering
+// authorizationState with used, and records the raw transactions sent.
+type fakeTokenNode struct {
+	*httptest.Server
+	used bool
+
+	mu  sync.Mutex
+	txs []*types.Transaction
+}
+
+func newFakeTokenNode(t *testing.T, used bool) *fakeTokenNode {
+	t.Helper()
+	n := &fakeTokenNode{used: used}
+	n.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			Method string            `json:"method"`
+			Params []json.RawMessage `json:"params"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "bad request", http.StatusBadRequest)
+			return
+		}
+		var result any
+		switch req.Method {
+		case "eth_call":
+			state := common.Big0
+			if n.used {
+				state = common.Big1
+			}
+			result = hexutil.Encode(common.LeftPadBytes(state.Bytes(), 32))
+		case "eth_getTransactionCount":
+			result = "0x7"
+		case "eth_gasPrice":
+			result = "0x3b9aca00"
+		case "eth_estimateGas":
+			result = "0x186a0"
+		case "eth_sendRawTransaction":
+			var raw string
+			json.Unmarshal(req.Params[0], &raw)
+			tx := new(types.Transaction)
+			if err := tx.UnmarshalBinary(common.FromHex(raw)); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			n.mu.Lock()
+			n.txs = append(n.txs, tx)
+			n.mu.Unlock()
+			result = tx.Hash().Hex()
+		}
+		json.NewEncoder(w).Encode(map[string]any{"jsonrpc": "2.0", "id": 1, "result": result})
+	}))
+	t.Cleanup(n.Close)
+	return n
+}
+
+func (n *fakeTokenNode) sent() []*types.Transaction {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return append([]*types.Transaction(nil), n.txs...)
+}
+
+func TestERC3009_RelaysAuthorizationOnChain(t *testing.T) {
+	node := newFakeTokenNode(t, false)
+	relayer, err := crypto.GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("SIGNATURE_TYPES", "eip712,erc3009")
+	t.Setenv("ERC3009_RELAY_RPC_URLS", "base="+node.URL)
+	t.Setenv("ERC3009_RELAY_ENABLED", "true")
+	t.Setenv("ERC3009_RELAYER_PRIVATE_KEY", common.Bytes2Hex(crypto.FromECDSA(relayer)))
+	h := testsupport.NewHarness(t, newTestRouter)
+	key, err := crypto.GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	payment := offeredPayment(t, h)
+	signature := signedAuthorization(t, key, payment, nil)
+
+	if resp := postPayment(t, h, paymentHeaderV2{Signature: signature, Nonce: payment.Nonce, SignatureType: "erc3009"}); resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected 200, got %d", resp.StatusCode)
+	}
+	deadline := time.Now().Add(2 * time.Second)
+	for len(node.sent()) == 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	txs := node.sent()
+	if len(txs) != 1 {
+		t.Fatalf("expected one relayed transaction, got %d", len(txs))
+	}
+	tx := txs[0]
+	p, err := payments.DecodeERC3009Payload(signature)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want, err := payments.TransferWithAuthorizationCalldata(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tx.To() == nil || *tx.To() != common.HexToAddress(usdcDomains[payment.ChainID].VerifyingContract) || !bytes.Equal(tx.Data(), want) {
+		t.Errorf("expected transferWithAuthorization on the USDC contract, got to=%v data=%x", tx.To(), tx.Data())
+	}
+	if tx.Nonce() != 7 || tx.ChainId().Int64() != int64(payment.ChainID) {
+		t.Errorf("expected the relayer's pending nonce on chain %d, got nonce %d chain %d", payment.ChainID, tx.Nonce(), tx.ChainId())
+	}
+	sender, err := types.Sender(types.LatestSignerForChainID(tx.ChainId()), tx)
+	if err != nil || sender != crypto.PubkeyToAddress(relayer.PublicKey) {
+		t.Errorf("expected the relayer to send the transaction, got %s (%v)", sender.Hex(), err)
+	}
+}
+
+func TestSubmitTransferAuthorization_SkipsUsedAuthorizations(t *testing.T) {
+	node := newFakeTokenNode(t, true)
+	relayer, err := crypto.GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("ERC3009_RELAY_RPC_URLS", "base="+node.URL)
+	t.Setenv("ERC3009_RELAYER_PRIVATE_KEY", common.Bytes2Hex(crypto.FromECDSA(relayer)))
+	resetConfigSnapshot(t)
+	currentConfig.Store(loadConfig())
+	key, err := crypto.GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	payment := paymentContextFor(ChainOption{ChainID: 8453, Recipient: "0x1111111111111111111111111111111111111111"}, "0.001", "nonce-1")
+	p, err := payments.DecodeERC3009Payload(signedAuthorization(t, key, payment, nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	hash, err := submitTransferAuthorization(context.Background(), 8453, p)
+	if err != nil || hash != "" {
+		t.Fatalf("expected a used authorization to be skipped, got %q (%v)", hash, err)
+	}
+	if len(node.sent()) != 0 {
+		t.Error("expected no transaction for a used authorization")
+	}
+}
+
+func TestParseERC3009Tokens(t *testing.T) {
+	tokens, err := parseERC3009Tokens([]string{"base=0x0000000000000000000000000000000000000abc:Test Dollar", "777=0x0000000000000000000000000000000000000def"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d := tokens[8453]; d.Name != "Test Dollar" || d.VerifyingContract != common.HexToAddress("0xabc").Hex() {
+		t.Errorf("expected the base override, got %+v", d)
+	}
+	if d := tokens[777]; d.Name != "USD Coin" || d.Version != "2" || d.ChainID != 777 {
+		t.Errorf("expected a USD Coin domain on chain 777, got %+v", d)
+	}
+	if tokens[10] != usdcDomains[10] {
+		t.Error("expected the known USDC domains to stay")
+	}
+	for _, bad := range []string{"base", "mars=0x0000000000000000000000000000000000000abc", "base=0x12"} {
+		if _, err := parseERC3009Tokens([]string{bad}); err == nil {
+			t.Errorf("%q: expected an error", bad)
+		}
+	}
+}
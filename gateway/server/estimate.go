@@ -0,0 +1,144 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"unicode/utf8"
+
+	"github.com/gin-gonic/gin"
+)
+
+// EstimateRequest is the body of POST /api/ai/estimate. Text is a string, or
+// for the embed endpoint a string or an array of strings. Endpoint names the
+// paid endpoint to price and defaults to summarize.
+type EstimateRequest struct {
+	Text     json.RawMessage `json:"text"`
+	Endpoint string          `json:"endpoint,omitempty"`
+}
+
+// Estimate is the price a request would be charged, with previews of the
+// payment contexts a 402 would offer for it. The previews carry no nonce or
+// quote signature; those are issued with the 402 challenge itself.
+type Estimate struct {
+	Endpoint    string `json:"endpoint"`
+	Model       string `json:"model,omitempty"`
+	Price       string `json:"price"`
+	Token       string `json:"token"`
+	Inputs      int    `json:"inputs"`
+	InputChars  int    `json:"input_chars"`
+	InputTokens int    `json:"input_tokens"`
+	// ContextWindow is the routed model's context window for summarize, if
+	// requests are checked against one.
+	ContextWindow int `json:"context_window,omitempty"`
+	// ProviderCost is the most the routed model would cost the gateway for
+	// summarize at the model catalog's prices, with a reply of
+	// GENERATION_MAX_TOKENS. It is omitted while the model is not priced.
+	ProviderCost string `json:"provider_cost,omitempty"`
+	// PaymentContext is the primary chain's preview; Accepts lists one per
+	// accepted chain, as in a 402 response.
+	PaymentContext PaymentContext   `json:"paymentContext"`
+	Accepts        []PaymentContext `json:"accepts"`
+}
+
+// estimateCost prices req under cfg without routing side effects: the model
+// is the routed preferred model, which failover may still substitute.
+func estimateCost(cfg *Config, req EstimateRequest) (Estimate, error) {
+	est := Estimate{Endpoint: req.Endpoint}
+	if est.Endpoint == "" {
+		est.Endpoint = "summarize"
+	}
+
+	var inputs []string
+	switch est.Endpoint {
+	case "embed":
+		parsed, err := parseEmbedInputs(req.Text, cfg.Embeddings.MaxInputs)
+		if err != nil {
+			return Estimate{}, err
+		}
+		inputs = parsed
+		est.InputTokens = estimateTokens(inputs)
+		est.Model, est.Price = cfg.Embeddings.Model, embeddingPrice(cfg, est.InputTokens)
+	default:
+		var text string
+		if err := json.Unmarshal(req.Text, &text); err != nil || text == "" {
+			return Estimate{}, errors.New("text must be a non-empty string")
+		}
+		inputs = []string{text}
+		est.InputTokens = estimateTokens(inputs)
+		if est.Endpoint == "summarize" {
+			est.InputTokens = countTokens(text)
+			est.Model, est.Price = routeModel(cfg, utf8.RuneCountInString(text))
+			est.ContextWindow = modelContextWindow(cfg, est.Model)
+			if info, ok := modelCatalog.Load().Lookup(est.Model); ok && info.PromptPrice+info.CompletionPrice > 0 {
+				usd := float64(summarizePromptTokens(text, GenerationParams{}))*info.PromptPrice + float64(cfg.GenerationMaxTokens)*info.CompletionPrice
+				est.ProviderCost = formatTokenAmount(usdToTokenUnits(usd))
+			}
+			break
+		}
+		found := false
+		for _, ep := range paidEndpoints.Endpoints() {
+			if ep.Name == est.Endpoint {
+				est.Price, found = ep.price(cfg), true
+			}
+		}
+		if !found {
+			return Estimate{}, errors.New("unknown endpoint " + est.Endpoint)
+		}
+	}
+
+	est.Inputs = len(inputs)
+	for _, input := range inputs {
+		est.InputChars += utf8.RuneCountInString(input)
+	}
+	chains := cfg.Chains
+	if len(chains) == 0 {
+		chains = []ChainOption{cfg.PrimaryChain()}
+	}
+	for _, chain := range chains {
+		est.Accepts = append(est.Accepts, paymentContextFor(chain, est.Price, ""))
+	}
+	est.PaymentContext = est.Accepts[0]
+	est.Token = est.PaymentContext.Token
+	return est, nil
+}
+
+// handleEstimate handles POST /api/ai/estimate. It is free, so it is only
+// bounded by the anonymous rate limit tier, and lets clients show the cost of
+// a request before asking the user to sign.
+func handleEstimate(c *gin.Context) {
+	req, ok := bindEstimateRequest(c)
+	if !ok {
+		return
+	}
+	est, err := estimateCost(getConfig(), req)
+	if err != nil {
+		respondError(c, CodeInvalidRequest, err.Error())
+		return
+	}
+	c.JSON(200, est)
+}
+
+// bindEstimateRequest reads an EstimateRequest body of at most 10MB. On
+// failure it has written the error response and returns false.
+func bindEstimateRequest(c *gin.Context) (EstimateRequest, bool) {
+	const maxBodySize = 10 * 1024 * 1024
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, int64(maxBodySize))
+	body, err := io.ReadAll(c.Request.Body)
+	if err != nil {
+		var maxBytesErr *http.MaxBytesError
+		if errors.As(err, &maxBytesErr) {
+			respondAPIError(c, errPayloadTooLarge())
+		} else {
+			respondError(c, CodeBodyReadFailed, "The request body could not be read")
+		}
+		return EstimateRequest{}, false
+	}
+	var req EstimateRequest
+	if err := json.Unmarshal(body, &req); err != nil {
+		respondError(c, CodeInvalidBody, "Request must be valid JSON")
+		return EstimateRequest{}, false
+	}
+	return req, true
+}
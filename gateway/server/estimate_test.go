@@ -0,0 +1,84 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"gateway/internal/testsupport"
+)
+
+// postEstimate requests an estimate and decodes a 200 response.
+func postEstimate(t *testing.T, h *testsupport.Harness, path, body string) Estimate {
+	t.Helper()
+	resp := h.Post(t, path, body, "", "")
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("%s %s: expected 200, got %d", path, body, resp.StatusCode)
+	}
+	var est Estimate
+	if err := json.NewDecoder(resp.Body).Decode(&est); err != nil {
+		t.Fatal(err)
+	}
+	return est
+}
+
+func TestEstimate_SummarizeMatchesQuote(t *testing.T) {
+	t.Setenv("MODEL_ROUTES", "100|small|0.001,*|large|0.003")
+	h := testsupport.NewHarness(t, newTestRouter)
+	long := strings.Repeat("a", 150)
+
+	est := postEstimate(t, h, "/api/ai/estimate", `{"text":"`+long+`"}`)
+	if est.Endpoint != "summarize" || est.Model != "large" || est.Price != "0.003" || est.Token != "USDC" {
+		t.Errorf("unexpected estimate %+v", est)
+	}
+	if est.InputChars != 150 || est.InputTokens != 38 {
+		t.Errorf("expected 150 chars and 38 tokens, got %d and %d", est.InputChars, est.InputTokens)
+	}
+	if len(est.Accepts) == 0 || est.PaymentContext.Amount != "0.003" || est.PaymentContext.Nonce != "" || est.PaymentContext.QuoteSignature != "" {
+		t.Errorf("expected an unsigned payment context preview, got %+v", est.PaymentContext)
+	}
+
+	// The estimate agrees with the 402 challenge for the same body.
+	resp := h.Post(t, "/api/ai/summarize", `{"text":"`+long+`"}`, "", "")
+	if got := resp.Header.Get("X-402-Price"); got != est.Price {
+		t.Errorf("expected the 402 to quote %s, got %s", est.Price, got)
+	}
+	if h.Verifier.Calls() != 0 || h.AI.Calls() != 0 {
+		t.Error("expected estimates to call neither the verifier nor the provider")
+	}
+
+	// v2 bodies use "input".
+	if est := postEstimate(t, h, "/api/v2/ai/estimate", `{"input":"short"}`); est.Model != "small" || est.Price != "0.001" {
+		t.Errorf("v2: unexpected estimate %+v", est)
+	}
+}
+
+func TestEstimate_EmbedAndRegisteredEndpoints(t *testing.T) {
+	var calls atomic.Int32
+	withEndpointRegistry(t, sentimentEndpoint(&calls))
+	h := testsupport.NewHarness(t, newTestRouter)
+
+	est := postEstimate(t, h, "/api/ai/estimate", `{"endpoint":"embed","text":["one","two"]}`)
+	if est.Inputs != 2 || est.InputTokens != 2 || est.Model != getConfig().Embeddings.Model {
+		t.Errorf("unexpected embed estimate %+v", est)
+	}
+
+	est = postEstimate(t, h, "/api/ai/estimate", `{"endpoint":"sentiment","text":"great"}`)
+	if est.Price != "0.002" || est.Model != "" {
+		t.Errorf("unexpected sentiment estimate %+v", est)
+	}
+	if calls.Load() != 0 {
+		t.Error("expected the endpoint handler not to run for an estimate")
+	}
+}
+
+func TestEstimate_RejectsInvalidRequests(t *testing.T) {
+	h := testsupport.NewHarness(t, newTestRouter)
+	for _, body := range []string{`not json`, `{"text":""}`, `{"text":["a"]}`, `{"endpoint":"translate","text":"hi"}`} {
+		if resp := h.Post(t, "/api/ai/estimate", body, "", ""); resp.StatusCode != http.StatusBadRequest {
+			t.Errorf("%s: expected 400, got %d", body, resp.StatusCode)
+		}
+	}
+}
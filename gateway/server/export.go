@@ -0,0 +1,322 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// Receipt export formats.
+const (
+	exportFormatCSV   = "csv"
+	exportFormatJSONL = "jsonl"
+)
+
+// exportFlushEvery is how many rows a streamed export writes between
+// flushes, so large exports reach the client in chunks.
+const exportFlushEvery = 100
+
+// ReceiptExportRow is a receipt flattened for accounting: one column per
+// payment and service field, plus its revocation status and IPFS CID.
+// Unset optional fields are empty.
+type ReceiptExportRow struct {
+	ID             string `json:"id"`
+	Timestamp      string `json:"timestamp"`
+	Version        string `json:"version"`
+	Payer          string `json:"payer"`
+	Recipient      string `json:"recipient"`
+	Amount         string `json:"amount"`
+	Token          string `json:"token"`
+	ChainID        int    `json:"chain_id"`
+	Nonce          string `json:"nonce"`
+	Sequence       int64  `json:"sequence"`
+	Endpoint       string `json:"endpoint"`
+	Model          string `json:"model"`
+	SubstitutedFor string `json:"substituted_for"`
+	RequestHash    string `json:"request_hash"`
+	ResponseHash   string `json:"response_hash"`
+	Dimensions     int    `json:"dimensions"`
+	Temperature    string `json:"temperature"`
+	MaxTokens      string `json:"max_tokens"`
+	TopP           string `json:"top_p"`
+	Status         string `json:"status"`
+	CID            string `json:"cid"`
+
+	issuedAt time.Time
+}
+
+// receiptExportColumns is the CSV header, in ReceiptExportRow order.
+var receiptExportColumns = []string{
+	"id", "timestamp", "version", "payer", "recipient", "amount", "token", "chain_id", "nonce", "sequence",
+	"endpoint", "model", "substituted_for", "request_hash", "response_hash", "dimensions",
+	"temperature", "max_tokens", "top_p", "status", "cid",
+}
+
+// record returns the row as CSV fields.
+func (r ReceiptExportRow) record() []string {
+	return []string{
+		r.ID, r.Timestamp, r.Version, r.Payer, r.Recipient, r.Amount, r.Token, strconv.Itoa(r.ChainID), r.Nonce,
+		strconv.FormatInt(r.Sequence, 10), r.Endpoint, r.Model, r.SubstitutedFor, r.RequestHash, r.ResponseHash,
+		strconv.Itoa(r.Dimensions), r.Temperature, r.MaxTokens, r.TopP, r.Status, r.CID,
+	}
+}
+
+// receiptExportRows returns the stored receipts issued in [from, to), oldest
+// first. Only receipts still within RECEIPT_TTL can be exported.
+func receiptExportRows(ctx context.Context, from, to time.Time) ([]ReceiptExportRow, error) {
+	revoked, err := listRevocations(ctx)
+	if err != nil {
+		return nil, err
+	}
+	statuses := make(map[string]string, len(revoked))
+	for _, rev := range revoked {
+		statuses[rev.ReceiptID] = rev.Status
+	}
+
+	now := time.Now()
+	var rows []ReceiptExportRow
+	receiptStoreMu.RLock()
+	for _, entry := range receiptStore {
+		r := entry.receipt.Receipt
+		if now.After(entry.expiresAt) || r.Timestamp.Before(from) || !r.Timestamp.Before(to) {
+			continue
+		}
+		row := ReceiptExportRow{
+			ID:             r.ID,
+			Timestamp:      r.Timestamp.UTC().Format(time.RFC3339Nano),
+			Version:        r.Version,
+			Payer:          r.Payment.Payer,
+			Recipient:      r.Payment.Recipient,
+			Amount:         r.Payment.Amount,
+			Token:          r.Payment.Token,
+			ChainID:        r.Payment.ChainID,
+			Nonce:          r.Payment.Nonce,
+			Sequence:       r.Payment.Sequence,
+			Endpoint:       r.Service.Endpoint,
+			Model:          r.Service.Model,
+			SubstitutedFor: r.Service.SubstitutedFor,
+			RequestHash:    r.Service.RequestHash,
+			ResponseHash:   r.Service.ResponseHash,
+			Dimensions:     r.Service.Dimensions,
+			Status:         receiptStatusValid,
+			CID:            entry.cid,
+			issuedAt:       r.Timestamp,
+		}
+		if p := r.Service.Parameters; p != nil {
+			if p.Temperature != nil {
+				row.Temperature = strconv.FormatFloat(*p.Temperature, 'f', -1, 64)
+			}
+			if p.MaxTokens != nil {
+				row.MaxTokens = strconv.Itoa(*p.MaxTokens)
+			}
+			if p.TopP != nil {
+				row.TopP = strconv.FormatFloat(*p.TopP, 'f', -1, 64)
+			}
+		}
+		if status, ok := statuses[r.ID]; ok {
+			row.Status = status
+		}
+		rows = append(rows, row)
+	}
+	receiptStoreMu.RUnlock()
+
+	sort.Slice(rows, func(i, j int) bool {
+		if !rows[i].issuedAt.Equal(rows[j].issuedAt) {
+			return rows[i].issuedAt.Before(rows[j].issuedAt)
+		}
+		return rows[i].ID < rows[j].ID
+	})
+	return rows, nil
+}
+
+// writeReceiptExport writes rows to w in format, calling flush every
+// exportFlushEvery rows.
+func writeReceiptExport(w io.Writer, format string, rows []ReceiptExportRow, flush func()) error {
+	if format == exportFormatJSONL {
+		enc := json.NewEncoder(w)
+		for i, row := range rows {
+			if err := enc.Encode(row); err != nil {
+				return err
+			}
+			if (i+1)%exportFlushEvery == 0 {
+				flush()
+			}
+		}
+		return nil
+	}
+
+	cw := csv.NewWriter(w)
+	if err := cw.Write(receiptExportColumns); err != nil {
+		return err
+	}
+	for i, row := range rows {
+		if err := cw.Write(row.record()); err != nil {
+			return err
+		}
+		if (i+1)%exportFlushEvery == 0 {
+			cw.Flush()
+			flush()
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// exportContentType returns the media type of format.
+func exportContentType(format string) string {
+	if format == exportFormatJSONL {
+		return "application/x-ndjson"
+	}
+	return "text/csv; charset=utf-8"
+}
+
+// exportFileName names an export of [from, to) in format.
+func exportFileName(from, to time.Time, format string) string {
+	return fmt.Sprintf("receipts-%s-%s.%s", from.Format("20060102T150405Z"), to.Format("20060102T150405Z"), format)
+}
+
+// parseExportRequest reads the from, to and format query parameters,
+// defaulting to the last 24 hours as CSV. Invalid values answer 400.
+func parseExportRequest(c *gin.Context) (time.Time, time.Time, string, bool) {
+	from, to, ok := parseTimeWindow(c, 24*time.Hour)
+	if !ok {
+		return time.Time{}, time.Time{}, "", false
+	}
+	format := c.DefaultQuery("format", exportFormatCSV)
+	if format != exportFormatCSV && format != exportFormatJSONL {
+		respondError(c, CodeInvalidRequest, "format must be csv or jsonl")
+		return time.Time{}, time.Time{}, "", false
+	}
+	return from, to, format, true
+}
+
+// handleExportReceipts handles GET /api/admin/receipts/export, streaming the
+// receipts issued in the window with chunked transfer encoding.
+func handleExportReceipts(c *gin.Context) {
+	from, to, format, ok := parseExportRequest(c)
+	if !ok {
+		return
+	}
+	rows, err := receiptExportRows(c.Request.Context(), from, to)
+	if err != nil {
+		log.Printf("[ERROR] %v", err)
+		respondError(c, CodeServiceUnavailable, "Receipt statuses could not be loaded")
+		return
+	}
+
+	c.Header("Content-Type", exportContentType(format))
+	c.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="%s"`, exportFileName(from, to, format)))
+	c.Header("X-Export-Rows", strconv.Itoa(len(rows)))
+	c.Status(200)
+	if err := writeReceiptExport(c.Writer, format, rows, c.Writer.Flush); err != nil {
+		log.Printf("[WARNING] Receipt export interrupted: %v", err)
+	}
+}
+
+// ReceiptExport is an async export job. Status is "pending", "completed" or
+// "failed"; Location is the s3:// URL of a completed export.
+type ReceiptExport struct {
+	ID          string     `json:"id"`
+	Status      string     `json:"status"`
+	Format      string     `json:"format"`
+	From        time.Time  `json:"from"`
+	To          time.Time  `json:"to"`
+	Rows        int        `json:"rows"`
+	Location    string     `json:"location,omitempty"`
+	Error       string     `json:"error,omitempty"`
+	CreatedAt   time.Time  `json:"created_at"`
+	CompletedAt *time.Time `json:"completed_at,omitempty"`
+}
+
+// receiptExports holds async export jobs. Exports run on the instance that
+// accepted them, so their status is kept in memory.
+var (
+	receiptExportsMu sync.RWMutex
+	receiptExports   = make(map[string]*ReceiptExport)
+)
+
+// handleCreateReceiptExport handles POST /api/admin/receipts/exports with the
+// same query parameters as the streaming export. It answers 202 and writes
+// the export to RECEIPT_EXPORT_S3_BUCKET in the background.
+func handleCreateReceiptExport(c *gin.Context) {
+	uploader := newS3Uploader()
+	if uploader == nil {
+		respondError(c, CodeServiceUnavailable, "Async exports need RECEIPT_EXPORT_S3_ENDPOINT and RECEIPT_EXPORT_S3_BUCKET")
+		return
+	}
+	from, to, format, ok := parseExportRequest(c)
+	if !ok {
+		return
+	}
+
+	export := &ReceiptExport{
+		ID:        "exp_" + uuid.New().String(),
+		Status:    "pending",
+		Format:    format,
+		From:      from,
+		To:        to,
+		CreatedAt: time.Now().UTC(),
+	}
+	receiptExportsMu.Lock()
+	receiptExports[export.ID] = export
+	snapshot := *export
+	receiptExportsMu.Unlock()
+
+	go runReceiptExport(uploader, snapshot)
+	c.Header("Location", "/api/admin/receipts/exports/"+export.ID)
+	c.JSON(202, snapshot)
+}
+
+// runReceiptExport builds export and uploads it, recording the outcome.
+func runReceiptExport(uploader *s3Uploader, export ReceiptExport) {
+	ctx := context.Background()
+	rows, err := receiptExportRows(ctx, export.From, export.To)
+	var location string
+	if err == nil {
+		var buf bytes.Buffer
+		if err = writeReceiptExport(&buf, export.Format, rows, func() {}); err == nil {
+			key := export.ID + "/" + exportFileName(export.From, export.To, export.Format)
+			location, err = uploader.Put(ctx, key, exportContentType(export.Format), buf.Bytes())
+		}
+	}
+
+	now := time.Now().UTC()
+	receiptExportsMu.Lock()
+	defer receiptExportsMu.Unlock()
+	stored := receiptExports[export.ID]
+	stored.CompletedAt = &now
+	if err != nil {
+		log.Printf("[WARNING] Receipt export %s failed: %v", export.ID, err)
+		stored.Status, stored.Error = "failed", err.Error()
+		return
+	}
+	stored.Status, stored.Rows, stored.Location = "completed", len(rows), location
+	log.Printf("Receipt export %s wrote %d receipts to %s", export.ID, len(rows), location)
+}
+
+// handleGetReceiptExport handles GET /api/admin/receipts/exports/:id.
+func handleGetReceiptExport(c *gin.Context) {
+	receiptExportsMu.RLock()
+	export, ok := receiptExports[c.Param("id")]
+	var snapshot ReceiptExport
+	if ok {
+		snapshot = *export
+	}
+	receiptExportsMu.RUnlock()
+	if !ok {
+		respondError(c, CodeNotFound, "Export not found")
+		return
+	}
+	c.JSON(200, snapshot)
+}
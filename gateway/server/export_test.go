@@ -0,0 +1,167 @@
+package server
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"gateway/internal/testsupport"
+)
+
+// withReceiptStore gives the test an empty receipt store.
+func withReceiptStore(t testing.TB) {
+	t.Helper()
+	receiptStoreMu.Lock()
+	prevStore, prevIndex := receiptStore, receiptsByRequestHash
+	receiptStore = make(map[string]*receiptEntry)
+	receiptsByRequestHash = make(map[string]string)
+	receiptStoreMu.Unlock()
+	t.Cleanup(func() {
+		receiptStoreMu.Lock()
+		receiptStore, receiptsByRequestHash = prevStore, prevIndex
+		receiptStoreMu.Unlock()
+	})
+}
+
+func TestReceiptExport_Formats(t *testing.T) {
+	withReceiptStore(t)
+	withRevocations(t)
+	t.Setenv("ADMIN_API_KEY", "s3cret")
+	h := testsupport.NewHarness(t, newTestRouter)
+	first := decodeReceiptHeader(t, paidReceipt(t, h, "nonce-export-1"))
+	second := decodeReceiptHeader(t, paidReceipt(t, h, "nonce-export-2"))
+	r := newTestRouter()
+	adminPost(t, r, "/api/admin/receipts/"+second.Receipt.ID+"/revoke", "s3cret", `{"reason":"chargeback"}`)
+
+	w := adminGet(t, r, "/api/admin/receipts/export", "s3cret")
+	if w.Code != http.StatusOK || !strings.HasPrefix(w.Header().Get("Content-Type"), "text/csv") {
+		t.Fatalf("expected a CSV export, got %d %q", w.Code, w.Header().Get("Content-Type"))
+	}
+	if !strings.Contains(w.Header().Get("Content-Disposition"), ".csv") {
+		t.Errorf("expected a CSV attachment, got %q", w.Header().Get("Content-Disposition"))
+	}
+	records, err := csv.NewReader(w.Body).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 3 || !slices.Equal(records[0], receiptExportColumns) {
+		t.Fatalf("expected a header and two rows, got %v", records)
+	}
+	col := func(row []string, name string) string { return row[slices.Index(receiptExportColumns, name)] }
+	if col(records[1], "id") != first.Receipt.ID || col(records[1], "status") != receiptStatusValid || col(records[1], "nonce") != "nonce-export-1" {
+		t.Errorf("unexpected first row %v", records[1])
+	}
+	if col(records[2], "id") != second.Receipt.ID || col(records[2], "status") != receiptStatusRevoked {
+		t.Errorf("unexpected second row %v", records[2])
+	}
+	if col(records[1], "amount") != first.Receipt.Payment.Amount || col(records[1], "payer") != first.Receipt.Payment.Payer || col(records[1], "model") == "" {
+		t.Errorf("expected flattened payment and service fields, got %v", records[1])
+	}
+
+	w = adminGet(t, r, "/api/admin/receipts/export?format=jsonl", "s3cret")
+	if w.Header().Get("Content-Type") != "application/x-ndjson" {
+		t.Errorf("unexpected JSONL content type %q", w.Header().Get("Content-Type"))
+	}
+	var rows []ReceiptExportRow
+	scanner := bufio.NewScanner(w.Body)
+	for scanner.Scan() {
+		var row ReceiptExportRow
+		if err := json.Unmarshal(scanner.Bytes(), &row); err != nil {
+			t.Fatal(err)
+		}
+		rows = append(rows, row)
+	}
+	if len(rows) != 2 || rows[0].ID != first.Receipt.ID || rows[1].Status != receiptStatusRevoked {
+		t.Errorf("unexpected JSONL rows %+v", rows)
+	}
+
+	future := time.Now().Add(time.Hour).UTC().Format(time.RFC3339)
+	w = adminGet(t, r, "/api/admin/receipts/export?from="+future+"&to="+time.Now().Add(2*time.Hour).UTC().Format(time.RFC3339), "s3cret")
+	if records, _ := csv.NewReader(w.Body).ReadAll(); len(records) != 1 {
+		t.Errorf("expected only the header outside the window, got %d records", len(records))
+	}
+
+	for _, query := range []string{"?format=xml", "?from=yesterday", "?from=" + future + "&to=" + future} {
+		if w := adminGet(t, r, "/api/admin/receipts/export"+query, "s3cret"); w.Code != http.StatusBadRequest {
+			t.Errorf("%s: expected 400, got %d", query, w.Code)
+		}
+	}
+}
+
+func TestReceiptExport_AsyncToObjectStorage(t *testing.T) {
+	withReceiptStore(t)
+	withRevocations(t)
+	t.Setenv("ADMIN_API_KEY", "s3cret")
+	h := testsupport.NewHarness(t, newTestRouter)
+	r := newTestRouter()
+
+	if w := adminPost(t, r, "/api/admin/receipts/exports", "s3cret", ""); w.Code != http.StatusServiceUnavailable {
+		t.Fatalf("expected 503 without object storage, got %d", w.Code)
+	}
+
+	var (
+		mu       sync.Mutex
+		uploaded = make(map[string]string)
+		auth     string
+	)
+	storage := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		mu.Lock()
+		defer mu.Unlock()
+		if r.Method != http.MethodPut || r.Header.Get("X-Amz-Content-Sha256") != sha256Hex(body) {
+			http.Error(w, "bad upload", http.StatusBadRequest)
+			return
+		}
+		uploaded[r.URL.Path] = string(body)
+		auth = r.Header.Get("Authorization")
+	}))
+	defer storage.Close()
+	t.Setenv("RECEIPT_EXPORT_S3_ENDPOINT", storage.URL)
+	t.Setenv("RECEIPT_EXPORT_S3_BUCKET", "accounting")
+	t.Setenv("RECEIPT_EXPORT_S3_ACCESS_KEY_ID", "AKIDEXAMPLE")
+	t.Setenv("RECEIPT_EXPORT_S3_SECRET_ACCESS_KEY", "secret")
+
+	receipt := decodeReceiptHeader(t, paidReceipt(t, h, "nonce-async-export"))
+	w := adminPost(t, r, "/api/admin/receipts/exports?format=jsonl", "s3cret", "")
+	if w.Code != http.StatusAccepted {
+		t.Fatalf("expected 202, got %d: %s", w.Code, w.Body)
+	}
+	var export ReceiptExport
+	if err := json.Unmarshal(w.Body.Bytes(), &export); err != nil {
+		t.Fatal(err)
+	}
+	if export.Status != "pending" || w.Header().Get("Location") != "/api/admin/receipts/exports/"+export.ID {
+		t.Errorf("unexpected pending export %+v", export)
+	}
+
+	deadline := time.Now().Add(2 * time.Second)
+	for export.Status == "pending" && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+		json.Unmarshal(adminGet(t, r, "/api/admin/receipts/exports/"+export.ID, "s3cret").Body.Bytes(), &export)
+	}
+	if export.Status != "completed" || export.Rows != 1 || !strings.HasPrefix(export.Location, "s3://accounting/receipt-exports/"+export.ID+"/") {
+		t.Fatalf("unexpected finished export %+v", export)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	body := uploaded["/accounting/"+strings.TrimPrefix(export.Location, "s3://accounting/")]
+	if !strings.Contains(body, receipt.Receipt.ID) {
+		t.Errorf("uploaded export does not contain the receipt: %q (uploads %v)", body, uploaded)
+	}
+	if !strings.HasPrefix(auth, "AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/") {
+		t.Errorf("expected a SigV4 authorization, got %q", auth)
+	}
+
+	if w := adminGet(t, r, "/api/admin/receipts/exports/exp_unknown", "s3cret"); w.Code != http.StatusNotFound {
+		t.Errorf("unknown export: expected 404, got %d", w.Code)
+	}
+}
@@ -0,0 +1,697 @@
+package server
+
+import (
+	"bytes"
+	"compress/zlib"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"net"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+	"unicode"
+	"unicode/utf16"
+	"unicode/utf8"
+
+	"github.com/gin-gonic/gin"
+	"golang.org/x/net/html"
+)
+
+// Document types summarize extracts text from, as recorded in receipts.
+const (
+	sourcePDF  = "pdf"
+	sourceHTML = "html"
+	sourceText = "text"
+)
+
+// errNoText is returned when a document parses but holds no text.
+var errNoText = errors.New("the document contains no extractable text")
+
+// extractionLimits bounds the work done on one document.
+type extractionLimits struct {
+	MaxDocumentBytes int64
+	MaxTextChars     int
+}
+
+// getExtractionLimits reads EXTRACT_MAX_DOCUMENT_BYTES (default 10MB, the
+// request body limit) and EXTRACT_MAX_TEXT_CHARS (default 200000).
+func getExtractionLimits() extractionLimits {
+	return extractionLimits{
+		MaxDocumentBytes: int64(getEnvAsInt("EXTRACT_MAX_DOCUMENT_BYTES", 10*1024*1024)),
+		MaxTextChars:     getEnvAsInt("EXTRACT_MAX_TEXT_CHARS", 200000),
+	}
+}
+
+// extractionMiddleware lets summarize take a document instead of text: a
+// PDF or HTML body (by Content-Type), or a JSON body with a "url" field
+// instead of "text". The document's text is extracted and the body rewritten
+// to {"text": ...} before quoting, caching and payment see it, so the rest of
+// the pipeline is unchanged. Receipts hash the body as sent and record the
+// source type and URL.
+func extractionMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if c.Request.Body == nil {
+			c.Next()
+			return
+		}
+		original, err := io.ReadAll(c.Request.Body)
+		if err != nil {
+			abortWithError(c, CodeBodyReadFailed, "The request body could not be read")
+			return
+		}
+		c.Request.Body = io.NopCloser(bytes.NewReader(original))
+		limits := getExtractionLimits()
+
+		mediaType, _, _ := mime.ParseMediaType(c.GetHeader("Content-Type"))
+		if source := documentSource(mediaType); source != "" {
+			if int64(len(original)) > limits.MaxDocumentBytes {
+				abortWithAPIError(c, errPayloadTooLarge())
+				return
+			}
+			if rewriteWithText(c, original, map[string]json.RawMessage{}, original, source, "", limits) {
+				c.Next()
+			}
+			return
+		}
+
+		// A JSON body needs extraction only when it has "url" and no "text";
+		// anything else is left for the handler to validate.
+		var fields map[string]json.RawMessage
+		if json.Unmarshal(original, &fields) != nil || fields["url"] == nil {
+			c.Next()
+			return
+		}
+		if _, ok := fields["text"]; ok {
+			abortWithError(c, CodeInvalidRequest, "send either text or url, not both")
+			return
+		}
+		var sourceURL string
+		if json.Unmarshal(fields["url"], &sourceURL) != nil || sourceURL == "" {
+			abortWithError(c, CodeInvalidRequest, "url must be a non-empty string")
+			return
+		}
+		if !requireFetchPayment(c) {
+			return
+		}
+		document, mediaType, apiErr := fetchDocument(c.Request.Context(), sourceURL, limits.MaxDocumentBytes)
+		if apiErr != nil {
+			abortWithAPIError(c, apiErr)
+			return
+		}
+		source := documentSource(mediaType)
+		if mediaType == "text/plain" {
+			source = sourceText
+		}
+		if source == "" {
+			abortWithError(c, CodeUnsupportedMedia, fmt.Sprintf("The document at url is %s; only HTML, PDF and plain text are supported", mediaType))
+			return
+		}
+		delete(fields, "url")
+		if rewriteWithText(c, original, fields, document, source, sourceURL, limits) {
+			c.Next()
+		}
+	}
+}
+
+// requireFetchPayment lets a url be fetched only for requests that carry a
+// payment with an unspent nonce, so unpaid callers cannot make the gateway
+// download documents. Requests without one get the 402 challenge for the
+// shortest input, since the document's length is not known before it is
+// fetched. It returns false after aborting with the error response.
+func requireFetchPayment(c *gin.Context) bool {
+	nonce := c.GetHeader("X-402-Nonce")
+	if c.GetHeader("X-402-Signature") == "" || nonce == "" {
+		quote := quotePrice(c)
+		respondPaymentRequired(c, quote.Price, newAPIError(CodePaymentRequired, "").with(gin.H{"quote": quote}))
+		return false
+	}
+	spent, err := nonceSpent(c.Request.Context(), nonce)
+	if err != nil {
+		log.Printf("[WARNING] Nonce store error: %v", err)
+		abortWithError(c, CodeServiceUnavailable, "Payment nonces cannot be checked right now")
+		return false
+	}
+	if spent {
+		abortWithError(c, CodeNonceReplayed, "The payment nonce was already used")
+		return false
+	}
+	return true
+}
+
+// rewriteWithText extracts the text of document and replaces the request
+// body with fields plus that text. It returns false after aborting with the
+// error response if there is no usable text.
+func rewriteWithText(c *gin.Context, original []byte, fields map[string]json.RawMessage, document []byte, source, sourceURL string, limits extractionLimits) bool {
+	text, err := extractText(source, document, limits.MaxTextChars)
+	if err != nil {
+		abortWithAPIError(c, newAPIError(CodeExtractionFailed, "Could not extract text from the "+source+" document").withDetails(err.Error()))
+		return false
+	}
+	fields["text"], _ = json.Marshal(text)
+	body, err := json.Marshal(fields)
+	if err != nil {
+		abortWithError(c, CodeInvalidBody, "Request must be valid JSON")
+		return false
+	}
+	if _, ok := c.Get("receipt_request_body"); !ok {
+		// Receipts hash what the client actually sent.
+		c.Set("receipt_request_body", original)
+	}
+	c.Set("input_source", source)
+	c.Set("input_source_url", sourceURL)
+	c.Request.Header.Set("Content-Type", "application/json")
+	c.Request.Body = io.NopCloser(bytes.NewReader(body))
+	c.Request.ContentLength = int64(len(body))
+	return true
+}
+
+// documentSource maps a media type to the document type extracted from it,
+// or "" for bodies sent as they are.
+func documentSource(mediaType string) string {
+	switch mediaType {
+	case "application/pdf":
+		return sourcePDF
+	case "text/html", "application/xhtml+xml":
+		return sourceHTML
+	}
+	return ""
+}
+
+// extractText returns the sanitized text of a document of the given source
+// type, failing if there is none or it is longer than maxChars.
+func extractText(source string, document []byte, maxChars int) (string, error) {
+	var raw string
+	var err error
+	switch source {
+	case sourcePDF:
+		raw, err = extractPDFText(document)
+	case sourceHTML:
+		raw, err = extractHTMLText(document)
+	default:
+		raw = string(document)
+	}
+	if err != nil {
+		return "", err
+	}
+	text := sanitizeExtractedText(raw)
+	if text == "" {
+		return "", errNoText
+	}
+	if n := utf8.RuneCountInString(text); n > maxChars {
+		return "", fmt.Errorf("the extracted text is %d characters, over the %d limit", n, maxChars)
+	}
+	return text, nil
+}
+
+var (
+	blankRuns   = regexp.MustCompile(`[ \t\p{Zs}]+`)
+	newlineRuns = regexp.MustCompile(`\n{3,}`)
+)
+
+// sanitizeExtractedText makes extracted text safe to send to the model:
+// invalid UTF-8 and control and format characters are dropped, blanks are
+// collapsed and paragraphs separated by at most one empty line.
+func sanitizeExtractedText(s string) string {
+	s = strings.ToValidUTF8(s, "")
+	s = strings.NewReplacer("\r\n", "\n", "\r", "\n").Replace(s)
+	s = strings.Map(func(r rune) rune {
+		switch {
+		case r == '\n' || r == '\t':
+			return r
+		case unicode.IsControl(r), unicode.Is(unicode.Cf, r), r == utf8.RuneError:
+			return -1
+		}
+		return r
+	}, s)
+	lines := strings.Split(s, "\n")
+	for i, line := range lines {
+		lines[i] = strings.TrimSpace(blankRuns.ReplaceAllString(line, " "))
+	}
+	s = strings.Join(lines, "\n")
+	return strings.TrimSpace(newlineRuns.ReplaceAllString(s, "\n\n"))
+}
+
+// htmlSkippedElements hold no readable text.
+var htmlSkippedElements = map[string]bool{
+	"script": true, "style": true, "noscript": true, "template": true,
+	"svg": true, "math": true, "iframe": true, "object": true,
+}
+
+// htmlBlockElements start a new line.
+var htmlBlockElements = map[string]bool{
+	"p": true, "div": true, "br": true, "li": true, "tr": true, "section": true,
+	"article": true, "header": true, "footer": true, "h1": true, "h2": true,
+	"h3": true, "h4": true, "h5": true, "h6": true, "pre": true, "blockquote": true,
+	"table": true, "ul": true, "ol": true, "hr": true, "title": true,
+}
+
+// extractHTMLText returns the visible text of an HTML document, with block
+// elements on their own lines. Scripts, styles and other non-text elements
+// are dropped and entities decoded.
+func extractHTMLText(document []byte) (string, error) {
+	z := html.NewTokenizer(bytes.NewReader(document))
+	var out strings.Builder
+	skip := 0
+	for {
+		tt := z.Next()
+		switch tt {
+		case html.ErrorToken:
+			if err := z.Err(); err != io.EOF {
+				return "", err
+			}
+			return out.String(), nil
+		case html.StartTagToken, html.EndTagToken, html.SelfClosingTagToken:
+			name, _ := z.TagName()
+			tag := string(name)
+			switch {
+			case htmlSkippedElements[tag] && tt == html.StartTagToken:
+				skip++
+			case htmlSkippedElements[tag] && tt == html.EndTagToken && skip > 0:
+				skip--
+			}
+			if htmlBlockElements[tag] {
+				out.WriteByte('\n')
+			}
+		case html.TextToken:
+			if skip == 0 {
+				out.Write(z.Text())
+			}
+		}
+	}
+}
+
+// pdfMaxInflatedBytes bounds the decompressed size of all streams in one
+// PDF, so a small compressed document cannot expand without limit.
+const pdfMaxInflatedBytes = 64 << 20
+
+// extractPDFText returns the text drawn by the content streams of a PDF.
+// It reads uncompressed and FlateDecode streams and the text-showing
+// operators in them; text in fonts with custom encodings may come out
+// garbled, and scanned (image-only) and encrypted documents have none.
+func extractPDFText(document []byte) (string, error) {
+	if !bytes.HasPrefix(bytes.TrimLeft(document, "\x00\t\n\f\r "), []byte("%PDF-")) {
+		return "", errors.New("the body is not a PDF document")
+	}
+	if bytes.Contains(document, []byte("/Encrypt")) {
+		return "", errors.New("encrypted PDFs are not supported")
+	}
+
+	var out strings.Builder
+	budget := int64(pdfMaxInflatedBytes)
+	rest := document
+	for {
+		start := bytes.Index(rest, []byte("stream"))
+		if start < 0 {
+			break
+		}
+		// Skip "endstream" and the word inside names such as /Substream.
+		if start >= 3 && string(rest[start-3:start]) == "end" || start > 0 && isPDFRegular(rest[start-1]) {
+			rest = rest[start+len("stream"):]
+			continue
+		}
+		dict := rest[:start]
+		if i := bytes.LastIndex(dict, []byte(" obj")); i >= 0 {
+			dict = dict[i:]
+		}
+		data := rest[start+len("stream"):]
+		data = bytes.TrimPrefix(data, []byte("\r"))
+		data = bytes.TrimPrefix(data, []byte("\n"))
+		end := bytes.Index(data, []byte("endstream"))
+		if end < 0 {
+			break
+		}
+		rest = data[end+len("endstream"):]
+		data = bytes.TrimRight(data[:end], "\r\n")
+
+		content, ok, err := pdfStreamContent(dict, data, &budget)
+		if err != nil {
+			return "", err
+		}
+		if ok {
+			pdfContentText(content, &out)
+		}
+	}
+	return out.String(), nil
+}
+
+// pdfStreamContent returns the decoded data of a stream that may hold page
+// content. ok is false for images, fonts, metadata and filters other than
+// FlateDecode.
+func pdfStreamContent(dict, data []byte, budget *int64) (content []byte, ok bool, err error) {
+	for _, skip := range []string{"/Image", "/FontFile", "/Length1", "/XRef", "/Metadata", "/EmbeddedFile"} {
+		if bytes.Contains(dict, []byte(skip)) {
+			return nil, false, nil
+		}
+	}
+	i := bytes.Index(dict, []byte("/Filter"))
+	if i < 0 {
+		return data, true, nil
+	}
+	filter := dict[i+len("/Filter"):]
+	if j := bytes.IndexAny(filter, "/"); j < 0 || !bytes.HasPrefix(filter[j:], []byte("/FlateDecode")) {
+		return nil, false, nil
+	}
+	if bytes.Count(filter, []byte("Decode")) > 1 {
+		return nil, false, nil
+	}
+	r, err := zlib.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, false, nil
+	}
+	defer r.Close()
+	content, err = io.ReadAll(io.LimitReader(r, *budget+1))
+	if int64(len(content)) > *budget {
+		return nil, false, errors.New("the PDF expands beyond the decompression limit")
+	}
+	*budget -= int64(len(content))
+	if err != nil && len(content) == 0 {
+		return nil, false, nil
+	}
+	return content, true, nil
+}
+
+// pdfOperand is one operand of a content stream operator.
+type pdfOperand struct {
+	text   string
+	isText bool
+	num    float64
+}
+
+// pdfContentText appends the text shown by a content stream to out: the
+// strings of Tj, TJ, ' and " operators, with line breaks for line moves and
+// spaces for wide TJ gaps.
+func pdfContentText(data []byte, out *strings.Builder) {
+	var operands []pdfOperand
+	var array strings.Builder
+	inArray := false
+	push := func(op pdfOperand) {
+		if inArray {
+			array.WriteString(op.text)
+			return
+		}
+		operands = append(operands, op)
+	}
+	newline := func() {
+		if s := out.String(); s != "" && !strings.HasSuffix(s, "\n") {
+			out.WriteByte('\n')
+		}
+	}
+	writeText := func() {
+		for _, op := range operands {
+			if op.isText {
+				out.WriteString(op.text)
+			}
+		}
+	}
+
+	for i := 0; i < len(data); {
+		ch := data[i]
+		switch {
+		case ch == '(':
+			s, n := pdfLiteralString(data[i:])
+			push(pdfOperand{text: s, isText: true})
+			i += n
+		case ch == '<' && i+1 < len(data) && data[i+1] == '<', ch == '>' && i+1 < len(data) && data[i+1] == '>':
+			i += 2
+		case ch == '<':
+			s, n := pdfHexString(data[i:])
+			push(pdfOperand{text: s, isText: true})
+			i += n
+		case ch == '[':
+			inArray = true
+			array.Reset()
+			i++
+		case ch == ']':
+			inArray = false
+			operands = append(operands, pdfOperand{text: array.String(), isText: true})
+			i++
+		case ch == '%':
+			for i < len(data) && data[i] != '\n' && data[i] != '\r' {
+				i++
+			}
+		case !isPDFRegular(ch):
+			i++
+		default:
+			j := i + 1
+			for j < len(data) && isPDFRegular(data[j]) {
+				j++
+			}
+			tok := string(data[i:j])
+			i = j
+			if n, err := strconv.ParseFloat(tok, 64); err == nil {
+				if inArray {
+					// Large negative kerning in TJ arrays separates words.
+					if n < -200 {
+						array.WriteByte(' ')
+					}
+					continue
+				}
+				operands = append(operands, pdfOperand{num: n})
+				continue
+			}
+			if tok[0] == '/' || inArray || tok == "true" || tok == "false" || tok == "null" {
+				continue
+			}
+			switch tok {
+			case "Tj", "TJ":
+				writeText()
+			case "'", `"`:
+				newline()
+				writeText()
+			case "T*", "ET":
+				newline()
+			case "Td", "TD":
+				if len(operands) >= 2 && operands[len(operands)-1].num != 0 {
+					newline()
+				} else if len(operands) >= 2 && operands[len(operands)-2].num > 0 {
+					out.WriteByte(' ')
+				}
+			}
+			operands = operands[:0]
+		}
+	}
+	newline()
+}
+
+// isPDFRegular reports whether ch is part of a token rather than
+// whitespace or a delimiter.
+func isPDFRegular(ch byte) bool {
+	switch ch {
+	case 0, '\t', '\n', '\f', '\r', ' ', '(', ')', '<', '>', '[', ']', '{', '}', '/', '%':
+		return false
+	}
+	return true
+}
+
+// pdfLiteralString decodes the (...) string at the start of data, returning
+// it and the number of bytes consumed.
+func pdfLiteralString(data []byte) (string, int) {
+	var b []byte
+	depth := 0
+	i := 0
+	for ; i < len(data); i++ {
+		ch := data[i]
+		switch ch {
+		case '(':
+			depth++
+			if depth == 1 {
+				continue
+			}
+		case ')':
+			depth--
+			if depth == 0 {
+				return pdfDecodeString(b), i + 1
+			}
+		case '\\':
+			i++
+			if i >= len(data) {
+				break
+			}
+			switch e := data[i]; e {
+			case 'n':
+				b = append(b, '\n')
+			case 'r':
+				b = append(b, '\r')
+			case 't':
+				b = append(b, '\t')
+			case 'b', 'f':
+			case '\r':
+				if i+1 < len(data) && data[i+1] == '\n' {
+					i++
+				}
+			case '\n':
+			default:
+				if e >= '0' && e <= '7' {
+					v := 0
+					for k := 0; k < 3 && i < len(data) && data[i] >= '0' && data[i] <= '7'; k++ {
+						v = v*8 + int(data[i]-'0')
+						i++
+					}
+					i--
+					b = append(b, byte(v))
+				} else {
+					b = append(b, e)
+				}
+			}
+			continue
+		}
+		b = append(b, ch)
+	}
+	return pdfDecodeString(b), i
+}
+
+// pdfHexString decodes the <...> string at the start of data, returning it
+// and the number of bytes consumed.
+func pdfHexString(data []byte) (string, int) {
+	end := bytes.IndexByte(data, '>')
+	if end < 0 {
+		end = len(data) - 1
+	}
+	var digits []byte
+	for _, ch := range data[1:end] {
+		if unhex(ch) >= 0 {
+			digits = append(digits, ch)
+		}
+	}
+	if len(digits)%2 == 1 {
+		digits = append(digits, '0')
+	}
+	b := make([]byte, len(digits)/2)
+	for i := range b {
+		b[i] = byte(unhex(digits[2*i])<<4 | unhex(digits[2*i+1]))
+	}
+	return pdfDecodeString(b), end + 1
+}
+
+func unhex(ch byte) int {
+	switch {
+	case ch >= '0' && ch <= '9':
+		return int(ch - '0')
+	case ch >= 'a' && ch <= 'f':
+		return int(ch-'a') + 10
+	case ch >= 'A' && ch <= 'F':
+		return int(ch-'A') + 10
+	}
+	return -1
+}
+
+// pdfDecodeString converts PDF string bytes to text: UTF-16BE when they
+// start with a byte order mark, otherwise one character per byte.
+func pdfDecodeString(b []byte) string {
+	if len(b) >= 2 && b[0] == 0xFE && b[1] == 0xFF {
+		units := make([]uint16, 0, len(b)/2)
+		for i := 2; i+1 < len(b); i += 2 {
+			units = append(units, uint16(b[i])<<8|uint16(b[i+1]))
+		}
+		return string(utf16.Decode(units))
+	}
+	runes := make([]rune, len(b))
+	for i, ch := range b {
+		runes[i] = rune(ch)
+	}
+	return string(runes)
+}
+
+// fetchDocument GETs a document for extraction, returning its body and
+// media type. The URL must be https unless EXTRACT_URL_ALLOW_HTTP is set, and
+// private, loopback and link-local addresses are refused unless
+// EXTRACT_URL_ALLOW_PRIVATE is set.
+func fetchDocument(ctx context.Context, raw string, maxBytes int64) ([]byte, string, *APIError) {
+	u, err := url.Parse(raw)
+	if err != nil || u.Host == "" || (u.Scheme != "https" && u.Scheme != "http") {
+		return nil, "", newAPIError(CodeInvalidRequest, "url must be an absolute http(s) URL")
+	}
+	if u.Scheme == "http" && !getEnvAsBool("EXTRACT_URL_ALLOW_HTTP", false) {
+		return nil, "", newAPIError(CodeInvalidRequest, "url must use https")
+	}
+	fetchFailed := func(err error) *APIError {
+		return newAPIError(CodeSourceFetchFailed, "The url could not be fetched").withDetails(err.Error())
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, getPositiveTimeout("EXTRACT_URL_TIMEOUT_SECONDS", 10))
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return nil, "", newAPIError(CodeInvalidRequest, "url must be an absolute http(s) URL")
+	}
+	req.Header.Set("Accept", "text/html, application/pdf, text/plain;q=0.5")
+	resp, err := documentFetchClient(getEnvAsBool("EXTRACT_URL_ALLOW_PRIVATE", false)).Do(req)
+	if err != nil {
+		if errors.Is(err, errPrivateAddress) {
+			return nil, "", newAPIError(CodeInvalidRequest, "url resolves to a private address")
+		}
+		return nil, "", fetchFailed(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return nil, "", fetchFailed(fmt.Errorf("the url answered %s", resp.Status))
+	}
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxBytes+1))
+	if err != nil {
+		return nil, "", fetchFailed(err)
+	}
+	if int64(len(body)) > maxBytes {
+		return nil, "", fetchFailed(fmt.Errorf("the document is over the %d byte limit", maxBytes))
+	}
+	mediaType, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	if mediaType == "" {
+		mediaType, _, _ = mime.ParseMediaType(http.DetectContentType(body))
+	}
+	return body, mediaType, nil
+}
+
+// errPrivateAddress is returned when a document URL resolves to an address
+// the gateway refuses to fetch from.
+var errPrivateAddress = errors.New("private address")
+
+// refusePrivateAddress is a net.Dialer Control hook that fails connections
+// to non-public addresses with errPrivateAddress.
+func refusePrivateAddress(network, address string, _ syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	if ip := net.ParseIP(host); ip == nil || !isPublicIP(ip) {
+		return errPrivateAddress
+	}
+	return nil
+}
+
+// isPublicIP reports whether ip is a routable public address.
+func isPublicIP(ip net.IP) bool {
+	return !(ip.IsLoopback() || ip.IsPrivate() || ip.IsLinkLocalUnicast() ||
+		ip.IsLinkLocalMulticast() || ip.IsUnspecified() || ip.IsMulticast())
+}
+
+// documentFetchClient returns a client for document URLs. Unless
+// allowPrivate is set its dialer refuses non-public addresses, checked after
+// DNS resolution so redirects and rebinding cannot reach internal services.
+// It never uses a proxy: the dialer would then only see the proxy's address.
+func documentFetchClient(allowPrivate bool) *http.Client {
+	dialer := &net.Dialer{Timeout: 5 * time.Second}
+	if !allowPrivate {
+		dialer.Control = refusePrivateAddress
+	}
+	return &http.Client{
+		Transport: &http.Transport{
+			Proxy:               nil,
+			DialContext:         dialer.DialContext,
+			TLSHandshakeTimeout: 5 * time.Second,
+		},
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if len(via) >= 5 {
+				return errors.New("too many redirects")
+			}
+			return nil
+		},
+	}
+}
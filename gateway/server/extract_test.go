@@ -0,0 +1,234 @@
+package server
+
+import (
+	"bytes"
+	"compress/zlib"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"gateway/internal/testsupport"
+	"gateway/receipts"
+)
+
+// testPDF builds a minimal PDF whose pages draw the given content streams;
+// compressed streams are FlateDecode encoded.
+func testPDF(t *testing.T, compressed bool, contents ...string) string {
+	t.Helper()
+	var b strings.Builder
+	b.WriteString("%PDF-1.4\n")
+	for i, content := range contents {
+		data, filter := []byte(content), ""
+		if compressed {
+			var buf bytes.Buffer
+			w := zlib.NewWriter(&buf)
+			w.Write(data)
+			w.Close()
+			data, filter = buf.Bytes(), " /Filter /FlateDecode"
+		}
+		fmt.Fprintf(&b, "%d 0 obj\n<< /Length %d%s >>\nstream\n%s\nendstream\nendobj\n", i+4, len(data), filter, data)
+	}
+	b.WriteString("trailer\n<< /Root 1 0 R >>\n%%EOF\n")
+	return b.String()
+}
+
+// lastPrompt returns the user message of the latest completion request.
+func lastPrompt(t *testing.T, h *testsupport.Harness) string {
+	t.Helper()
+	reqs := h.AI.Requests()
+	if len(reqs) == 0 {
+		t.Fatal("expected a completion request")
+	}
+	messages := reqs[len(reqs)-1]["messages"].([]interface{})
+	return messages[len(messages)-1].(map[string]interface{})["content"].(string)
+}
+
+func TestExtractPDFText(t *testing.T) {
+	page := `BT /F1 12 Tf 72 712 Td (Hello, \(PDF\) world!) Tj 0 -14 Td [(Sec) 20 (ond) -300 (line)] TJ ET`
+	for _, compressed := range []bool{false, true} {
+		got, err := extractText(sourcePDF, []byte(testPDF(t, compressed, page, `BT <FEFF00E9> Tj ET`)), 1000)
+		if err != nil {
+			t.Fatalf("compressed=%v: %v", compressed, err)
+		}
+		if want := "Hello, (PDF) world!\nSecond line\né"; got != want {
+			t.Errorf("compressed=%v: expected %q, got %q", compressed, want, got)
+		}
+	}
+}
+
+func TestExtractPDFText_Rejected(t *testing.T) {
+	cases := map[string]string{
+		"not a pdf": "<html>hi</html>",
+		"encrypted": "%PDF-1.4\ntrailer << /Encrypt 5 0 R >>",
+		"no text":   testPDF(t, true, "0 0 m 10 10 l S"),
+	}
+	for name, doc := range cases {
+		if _, err := extractText(sourcePDF, []byte(doc), 1000); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}
+
+func TestExtractHTMLText(t *testing.T) {
+	doc := `<html><head><title>Weekly</title><style>p{color:red}</style><script>alert("x")</script></head>
+<body><h1>Report</h1><p>Fish &amp; chips,   served<br>hot.</p><noscript>enable js</noscript>
+<ul><li>one</li><li>two</li></ul></body></html>`
+	got, err := extractText(sourceHTML, []byte(doc), 1000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "Weekly\n\nReport\n\nFish & chips, served\nhot.\n\none\n\ntwo"; got != want {
+		t.Errorf("expected %q, got %q", want, got)
+	}
+}
+
+func TestSanitizeExtractedText(t *testing.T) {
+	got := sanitizeExtractedText("  a\x00b\u200b c\r\n\r\n\r\n\r\nd\xff  ")
+	if got != "ab c\n\nd" {
+		t.Errorf("unexpected sanitized text %q", got)
+	}
+	if _, err := extractText(sourceText, []byte("way too long"), 5); err == nil {
+		t.Error("expected text over the limit to be rejected")
+	}
+}
+
+func TestSummarize_PDFBody(t *testing.T) {
+	h := testsupport.NewHarness(t, newTestRouter)
+	pdf := testPDF(t, true, `BT (Quarterly results were strong.) Tj ET`)
+
+	resp := h.PostContent(t, "/api/ai/summarize", "application/pdf", pdf, "", "")
+	if resp.StatusCode != http.StatusPaymentRequired {
+		t.Fatalf("expected the extracted text to be quoted with a 402, got %d", resp.StatusCode)
+	}
+
+	resp = h.PostContent(t, "/api/ai/summarize", "application/pdf", pdf, "0xsig", "nonce-pdf")
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %+v", resp.StatusCode, decodeErrorBody(t, resp))
+	}
+	if prompt := lastPrompt(t, h); !strings.Contains(prompt, "Quarterly results were strong.") {
+		t.Errorf("expected the PDF text in the prompt, got %q", prompt)
+	}
+	service := decodeReceiptHeader(t, resp.Header.Get("X-402-Receipt")).Receipt.Service
+	if service.Source != sourcePDF || service.SourceURL != "" {
+		t.Errorf("expected a pdf source, got %q %q", service.Source, service.SourceURL)
+	}
+	if service.RequestHash != receipts.HashData([]byte(pdf)) {
+		t.Error("expected the receipt to hash the PDF as sent")
+	}
+}
+
+func TestSummarize_UnreadablePDF(t *testing.T) {
+	h := testsupport.NewHarness(t, newTestRouter)
+	resp := h.PostContent(t, "/api/ai/summarize", "application/pdf", "not a pdf", "0xsig", "nonce-bad-pdf")
+	if body := decodeErrorBody(t, resp); resp.StatusCode != http.StatusUnprocessableEntity || body.Code != CodeExtractionFailed {
+		t.Errorf("expected EXTRACTION_FAILED, got %d %+v", resp.StatusCode, body)
+	}
+	if len(h.Verifier.Requests()) != 0 {
+		t.Error("nothing should be charged for an unreadable document")
+	}
+}
+
+func TestSummarize_URL(t *testing.T) {
+	t.Setenv("EXTRACT_URL_ALLOW_HTTP", "true")
+	t.Setenv("EXTRACT_URL_ALLOW_PRIVATE", "true")
+	docs := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/article":
+			w.Header().Set("Content-Type", "text/html; charset=utf-8")
+			fmt.Fprint(w, `<article><p>The launch slipped a week.</p><script>track()</script></article>`)
+		case "/image":
+			w.Header().Set("Content-Type", "image/png")
+			w.Write([]byte{0x89, 'P', 'N', 'G'})
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer docs.Close()
+	h := testsupport.NewHarness(t, newTestRouter)
+
+	resp := h.Post(t, "/api/ai/summarize", `{"url":"`+docs.URL+`/article"}`, "0xsig", "nonce-url")
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %+v", resp.StatusCode, decodeErrorBody(t, resp))
+	}
+	if prompt := lastPrompt(t, h); !strings.Contains(prompt, "The launch slipped a week.") || strings.Contains(prompt, "track()") {
+		t.Errorf("expected only the article text in the prompt, got %q", prompt)
+	}
+	service := decodeReceiptHeader(t, resp.Header.Get("X-402-Receipt")).Receipt.Service
+	if service.Source != sourceHTML || service.SourceURL != docs.URL+"/article" {
+		t.Errorf("expected the html source and its URL, got %q %q", service.Source, service.SourceURL)
+	}
+
+	cases := []struct {
+		body   string
+		status int
+		code   ErrorCode
+	}{
+		{`{"url":"` + docs.URL + `/image"}`, http.StatusUnsupportedMediaType, CodeUnsupportedMedia},
+		{`{"url":"` + docs.URL + `/missing"}`, http.StatusBadGateway, CodeSourceFetchFailed},
+		{`{"url":"` + docs.URL + `/article","text":"both"}`, http.StatusBadRequest, CodeInvalidRequest},
+		{`{"url":"ftp://example.com/doc"}`, http.StatusBadRequest, CodeInvalidRequest},
+	}
+	for i, tc := range cases {
+		resp := h.Post(t, "/api/ai/summarize", tc.body, "0xsig", fmt.Sprintf("nonce-url-%d", i))
+		if body := decodeErrorBody(t, resp); resp.StatusCode != tc.status || body.Code != tc.code {
+			t.Errorf("%s: expected %d %s, got %d %+v", tc.body, tc.status, tc.code, resp.StatusCode, body)
+		}
+	}
+}
+
+func TestSummarize_URLGuards(t *testing.T) {
+	docs := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "internal only")
+	}))
+	defer docs.Close()
+	h := testsupport.NewHarness(t, newTestRouter)
+
+	resp := h.Post(t, "/api/ai/summarize", `{"url":"`+docs.URL+`"}`, "0xsig", "nonce-http")
+	if body := decodeErrorBody(t, resp); resp.StatusCode != http.StatusBadRequest || !strings.Contains(body.Message, "https") {
+		t.Errorf("expected plain http to be refused, got %d %+v", resp.StatusCode, body)
+	}
+
+	t.Setenv("EXTRACT_URL_ALLOW_HTTP", "true")
+	resp = h.Post(t, "/api/ai/summarize", `{"url":"`+docs.URL+`"}`, "0xsig", "nonce-private")
+	if body := decodeErrorBody(t, resp); resp.StatusCode != http.StatusBadRequest || !strings.Contains(body.Message, "private") {
+		t.Errorf("expected a loopback address to be refused, got %d %+v", resp.StatusCode, body)
+	}
+
+	if transport := documentFetchClient(false).Transport.(*http.Transport); transport.Proxy != nil {
+		t.Error("expected document fetches never to go through a proxy, which would hide the target address")
+	}
+}
+
+func TestSummarize_URLFetchedOnlyWhenPaid(t *testing.T) {
+	t.Setenv("EXTRACT_URL_ALLOW_HTTP", "true")
+	t.Setenv("EXTRACT_URL_ALLOW_PRIVATE", "true")
+	var fetches atomic.Int32
+	docs := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fetches.Add(1)
+		fmt.Fprint(w, "A document worth summarizing.")
+	}))
+	defer docs.Close()
+	h := testsupport.NewHarness(t, newTestRouter)
+	body := `{"url":"` + docs.URL + `"}`
+
+	if resp := h.Post(t, "/api/ai/summarize", body, "", ""); resp.StatusCode != http.StatusPaymentRequired {
+		t.Errorf("expected 402 without payment, got %d", resp.StatusCode)
+	}
+	if fetches.Load() != 0 {
+		t.Fatal("expected no fetch without payment")
+	}
+
+	if resp := h.Post(t, "/api/ai/summarize", body, "0xsig", "nonce-fetch"); resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %+v", resp.StatusCode, decodeErrorBody(t, resp))
+	}
+	resp := h.Post(t, "/api/ai/summarize", body, "0xsig", "nonce-fetch")
+	if body := decodeErrorBody(t, resp); body.Code != CodeNonceReplayed {
+		t.Errorf("expected a spent nonce to be refused, got %d %+v", resp.StatusCode, body)
+	}
+	if fetches.Load() != 1 {
+		t.Errorf("expected only the paid request to fetch, got %d fetches", fetches.Load())
+	}
+}
@@ -0,0 +1,110 @@
+package server
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Ranges generation parameters are clamped to. max_tokens is capped by
+// GENERATION_MAX_TOKENS instead.
+const (
+	maxTemperature = 2.0
+	maxTopP        = 1.0
+)
+
+// clampGenerationParams limits p to the ranges providers accept: temperature
+// to [0, 2], top_p to [0, 1] and max_tokens to [1, maxTokens], and the
+// summary shape: sentences to [1, 10] and max_words to [10, 500].
+// Out-of-range values are clamped rather than rejected. The result shares no
+// pointers with p.
+func clampGenerationParams(p GenerationParams, maxTokens int) GenerationParams {
+	var out GenerationParams
+	if p.Temperature != nil {
+		v := min(max(*p.Temperature, 0), maxTemperature)
+		out.Temperature = &v
+	}
+	if p.TopP != nil {
+		v := min(max(*p.TopP, 0), maxTopP)
+		out.TopP = &v
+	}
+	if p.MaxTokens != nil {
+		v := min(max(*p.MaxTokens, 1), maxTokens)
+		out.MaxTokens = &v
+	}
+	if p.Sentences != nil {
+		v := min(max(*p.Sentences, 1), maxSummarySentences)
+		out.Sentences = &v
+	}
+	if p.MaxWords != nil {
+		v := min(max(*p.MaxWords, minSummaryWords), maxSummaryWords)
+		out.MaxWords = &v
+	}
+	out.OutputLanguage = p.OutputLanguage
+	out.Style = p.Style
+	return out
+}
+
+// setGenerationParams clamps the request's generation parameters and stores
+// them for the provider call and the receipt. Later calls return the stored
+// parameters, so the cache middleware and handler agree.
+func setGenerationParams(c *gin.Context, p GenerationParams) GenerationParams {
+	if v, ok := c.Get("generation_params"); ok {
+		return v.(GenerationParams)
+	}
+	clamped := clampGenerationParams(p, getConfig().GenerationMaxTokens)
+	c.Set("generation_params", clamped)
+	return clamped
+}
+
+// getGenerationParams returns the parameters stored by setGenerationParams.
+func getGenerationParams(c *gin.Context) GenerationParams {
+	if v, ok := c.Get("generation_params"); ok {
+		return v.(GenerationParams)
+	}
+	return GenerationParams{}
+}
+
+// generationCacheKey renders the set parameters in a fixed order for the
+// cache key, e.g. "temperature=0.2;top_p=0.9;output_language=es". It is
+// empty when none are set.
+func generationCacheKey(p GenerationParams) string {
+	var parts []string
+	if p.Temperature != nil {
+		parts = append(parts, "temperature="+strconv.FormatFloat(*p.Temperature, 'g', -1, 64))
+	}
+	if p.MaxTokens != nil {
+		parts = append(parts, "max_tokens="+strconv.Itoa(*p.MaxTokens))
+	}
+	if p.TopP != nil {
+		parts = append(parts, "top_p="+strconv.FormatFloat(*p.TopP, 'g', -1, 64))
+	}
+	if p.OutputLanguage != "" {
+		parts = append(parts, "output_language="+p.OutputLanguage)
+	}
+	if p.Sentences != nil {
+		parts = append(parts, "sentences="+strconv.Itoa(*p.Sentences))
+	}
+	if p.Style != "" {
+		parts = append(parts, "style="+p.Style)
+	}
+	if p.MaxWords != nil {
+		parts = append(parts, "max_words="+strconv.Itoa(*p.MaxWords))
+	}
+	return strings.Join(parts, ";")
+}
+
+// applyGenerationParams adds the set sampling parameters to a provider
+// request body. The output language goes into the prompt instead.
+func applyGenerationParams(body map[string]interface{}, p GenerationParams) {
+	if p.Temperature != nil {
+		body["temperature"] = *p.Temperature
+	}
+	if p.MaxTokens != nil {
+		body["max_tokens"] = *p.MaxTokens
+	}
+	if p.TopP != nil {
+		body["top_p"] = *p.TopP
+	}
+}
@@ -0,0 +1,81 @@
+package server
+
+import (
+	"net/http"
+	"testing"
+
+	"gateway/internal/testsupport"
+)
+
+func TestClampGenerationParams(t *testing.T) {
+	hot, negative, wide, huge := 3.5, -1.0, 1.7, 100000
+	got := clampGenerationParams(GenerationParams{Temperature: &hot, TopP: &wide, MaxTokens: &huge}, 1024)
+	if *got.Temperature != 2 || *got.TopP != 1 || *got.MaxTokens != 1024 {
+		t.Errorf("expected values clamped to their maxima, got %v %v %v", *got.Temperature, *got.TopP, *got.MaxTokens)
+	}
+	if got.Temperature == &hot {
+		t.Error("clamped params must not alias the request's")
+	}
+
+	zero := 0
+	got = clampGenerationParams(GenerationParams{Temperature: &negative, TopP: &negative, MaxTokens: &zero}, 1024)
+	if *got.Temperature != 0 || *got.TopP != 0 || *got.MaxTokens != 1 {
+		t.Errorf("expected values clamped to their minima, got %v %v %v", *got.Temperature, *got.TopP, *got.MaxTokens)
+	}
+
+	if got := clampGenerationParams(GenerationParams{}, 1024); !got.IsZero() {
+		t.Errorf("expected unset params to stay unset, got %+v", got)
+	}
+}
+
+func TestCacheKey_IncludesGenerationParams(t *testing.T) {
+	model := "z-ai/glm-4.5-air:free"
+	low, high := 0.2, 0.9
+	plain := getCacheKey("hello", model, GenerationParams{})
+	cool := getCacheKey("hello", model, GenerationParams{Temperature: &low})
+	warm := getCacheKey("hello", model, GenerationParams{Temperature: &high})
+	if plain == cool || cool == warm {
+		t.Error("expected different generation params to produce different cache keys")
+	}
+	if again := getCacheKey("hello", model, GenerationParams{Temperature: &low}); again != cool {
+		t.Error("expected equal params to produce the same cache key")
+	}
+}
+
+func TestGenerationParams_PassedToProviderAndReceipt(t *testing.T) {
+	t.Setenv("GENERATION_MAX_TOKENS", "512")
+	h := testsupport.NewHarness(t, newTestRouter)
+
+	resp := h.Post(t, "/api/ai/summarize", `{"text":"hello","temperature":5,"max_tokens":4096,"top_p":0.5}`, "0xsig", "nonce-gen")
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected 200, got %d", resp.StatusCode)
+	}
+
+	sent := h.AI.Requests()[0]
+	if sent["temperature"] != 2.0 || sent["max_tokens"] != 512.0 || sent["top_p"] != 0.5 {
+		t.Errorf("expected clamped params sent to the provider, got %v", sent)
+	}
+
+	params := decodeReceiptHeader(t, resp.Header.Get("X-402-Receipt")).Receipt.Service.Parameters
+	if params == nil || *params.Temperature != 2 || *params.MaxTokens != 512 || *params.TopP != 0.5 {
+		t.Errorf("expected the clamped params in the receipt, got %+v", params)
+	}
+}
+
+func TestGenerationParams_OmittedWhenUnset(t *testing.T) {
+	h := testsupport.NewHarness(t, newTestRouter)
+
+	resp := h.Post(t, "/api/ai/summarize", `{"text":"hello"}`, "0xsig", "nonce-plain")
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected 200, got %d", resp.StatusCode)
+	}
+	sent := h.AI.Requests()[0]
+	for _, field := range []string{"temperature", "max_tokens", "top_p"} {
+		if _, ok := sent[field]; ok {
+			t.Errorf("expected %s to be left to the provider default", field)
+		}
+	}
+	if params := decodeReceiptHeader(t, resp.Header.Get("X-402-Receipt")).Receipt.Service.Parameters; params != nil {
+		t.Errorf("expected no parameters in the receipt, got %+v", params)
+	}
+}
@@ -0,0 +1,202 @@
+package server
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptrace"
+	"net/url"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Outbound dependencies with their own tuned HTTP client.
+const (
+	depVerifier   = "verifier"
+	depOpenRouter = "openrouter"
+	depWebhooks   = "webhooks"
+)
+
+// HTTPClientConfig tunes the transport used for one outbound dependency.
+// ProxyURL overrides the HTTP(S)_PROXY environment variables. PublicOnly
+// refuses connections to non-public addresses, for URLs chosen by callers,
+// and disables proxies, which would hide the target address from the check.
+// Timeout, if set, bounds whole requests.
+type HTTPClientConfig struct {
+	MaxIdleConnsPerHost int
+	DialTimeout         time.Duration
+	TLSHandshakeTimeout time.Duration
+	IdleConnTimeout     time.Duration
+	ProxyURL            string
+	PublicOnly          bool
+	Timeout             time.Duration
+}
+
+// loadHTTPClientConfig reads <prefix>_MAX_IDLE_CONNS_PER_HOST,
+// _DIAL_TIMEOUT_SECONDS, _TLS_HANDSHAKE_TIMEOUT_SECONDS,
+// _IDLE_CONN_TIMEOUT_SECONDS and _PROXY_URL, falling back to defaults.
+func loadHTTPClientConfig(prefix string, defaults HTTPClientConfig) (HTTPClientConfig, error) {
+	hc := HTTPClientConfig{
+		MaxIdleConnsPerHost: getEnvAsInt(prefix+"_MAX_IDLE_CONNS_PER_HOST", defaults.MaxIdleConnsPerHost),
+		DialTimeout:         getPositiveTimeout(prefix+"_DIAL_TIMEOUT_SECONDS", int(defaults.DialTimeout.Seconds())),
+		TLSHandshakeTimeout: getPositiveTimeout(prefix+"_TLS_HANDSHAKE_TIMEOUT_SECONDS", int(defaults.TLSHandshakeTimeout.Seconds())),
+		IdleConnTimeout:     getPositiveTimeout(prefix+"_IDLE_CONN_TIMEOUT_SECONDS", int(defaults.IdleConnTimeout.Seconds())),
+		ProxyURL:            getEnv(prefix+"_PROXY_URL", defaults.ProxyURL),
+	}
+	if hc.MaxIdleConnsPerHost <= 0 {
+		return hc, fmt.Errorf("%s_MAX_IDLE_CONNS_PER_HOST must be positive", prefix)
+	}
+	if hc.ProxyURL != "" {
+		if u, err := url.Parse(hc.ProxyURL); err != nil || u.Scheme == "" || u.Host == "" {
+			return hc, fmt.Errorf("%s_PROXY_URL %q is not a valid URL", prefix, hc.ProxyURL)
+		}
+	}
+	return hc, nil
+}
+
+// newTransport builds a transport from hc. Timeouts for whole requests are
+// left to the caller's context or hc.Timeout.
+func (hc HTTPClientConfig) newTransport() *http.Transport {
+	proxy := http.ProxyFromEnvironment
+	if u, err := url.Parse(hc.ProxyURL); err == nil && hc.ProxyURL != "" {
+		proxy = http.ProxyURL(u)
+	}
+	dialer := &net.Dialer{Timeout: hc.DialTimeout, KeepAlive: 30 * time.Second}
+	if hc.PublicOnly {
+		proxy = nil
+		dialer.Control = refusePrivateAddress
+	}
+	return &http.Transport{
+		Proxy:                 proxy,
+		DialContext:           dialer.DialContext,
+		ForceAttemptHTTP2:     true,
+		MaxIdleConns:          hc.MaxIdleConnsPerHost * 4,
+		MaxIdleConnsPerHost:   hc.MaxIdleConnsPerHost,
+		IdleConnTimeout:       hc.IdleConnTimeout,
+		TLSHandshakeTimeout:   hc.TLSHandshakeTimeout,
+		ExpectContinueTimeout: time.Second,
+	}
+}
+
+// connStats counts requests and connection reuse for one dependency.
+type connStats struct {
+	requests atomic.Int64
+	reused   atomic.Int64
+	newConns atomic.Int64
+	errors   atomic.Int64
+}
+
+// countingTransport records connStats for every request it sends.
+type countingTransport struct {
+	next  http.RoundTripper
+	stats *connStats
+}
+
+func (t *countingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	t.stats.requests.Add(1)
+	trace := &httptrace.ClientTrace{
+		GotConn: func(info httptrace.GotConnInfo) {
+			if info.Reused {
+				t.stats.reused.Add(1)
+			} else {
+				t.stats.newConns.Add(1)
+			}
+		},
+	}
+	resp, err := t.next.RoundTrip(req.WithContext(httptrace.WithClientTrace(req.Context(), trace)))
+	if err != nil {
+		t.stats.errors.Add(1)
+	}
+	return resp, err
+}
+
+// pooledClient is the client built for a dependency's current settings.
+type pooledClient struct {
+	settings  HTTPClientConfig
+	client    *http.Client
+	transport *http.Transport
+}
+
+// httpClients holds one client per dependency. A client is rebuilt when a
+// config reload changes its settings; its stats carry over.
+var httpClients = struct {
+	mu      sync.Mutex
+	clients map[string]*pooledClient
+	stats   map[string]*connStats
+}{clients: map[string]*pooledClient{}, stats: map[string]*connStats{}}
+
+// dependencyHTTPClient returns the shared client for name with settings hc.
+func dependencyHTTPClient(name string, hc HTTPClientConfig) *http.Client {
+	httpClients.mu.Lock()
+	defer httpClients.mu.Unlock()
+	if pc, ok := httpClients.clients[name]; ok && pc.settings == hc {
+		return pc.client
+	}
+	if old, ok := httpClients.clients[name]; ok {
+		old.transport.CloseIdleConnections()
+	}
+	stats := httpClients.stats[name]
+	if stats == nil {
+		stats = &connStats{}
+		httpClients.stats[name] = stats
+	}
+	transport := hc.newTransport()
+	pc := &pooledClient{
+		settings:  hc,
+		transport: transport,
+		client:    &http.Client{Transport: &countingTransport{next: transport, stats: stats}, Timeout: hc.Timeout},
+	}
+	httpClients.clients[name] = pc
+	return pc.client
+}
+
+// VerifierHTTPClient returns the client for verifier calls.
+func (cfg *Config) VerifierHTTPClient() *http.Client {
+	return dependencyHTTPClient(depVerifier, cfg.VerifierHTTP)
+}
+
+// ProviderHTTPClient returns the client for OpenRouter calls.
+func (cfg *Config) ProviderHTTPClient() *http.Client {
+	return dependencyHTTPClient(depOpenRouter, cfg.ProviderHTTP)
+}
+
+// webhookHTTPClient returns the client for job webhook deliveries. Webhook
+// URLs are chosen by callers, so private, loopback and link-local addresses
+// are refused unless JOB_WEBHOOK_ALLOW_PRIVATE is set (for local
+// development), and each delivery is bounded to 10 seconds.
+func webhookHTTPClient() *http.Client {
+	return dependencyHTTPClient(depWebhooks, HTTPClientConfig{
+		MaxIdleConnsPerHost: 2,
+		DialTimeout:         5 * time.Second,
+		TLSHandshakeTimeout: 5 * time.Second,
+		IdleConnTimeout:     90 * time.Second,
+		PublicOnly:          !getEnvAsBool("JOB_WEBHOOK_ALLOW_PRIVATE", false),
+		Timeout:             10 * time.Second,
+	})
+}
+
+// httpClientStatus is the http_clients entry of /readyz.
+func httpClientStatus() gin.H {
+	httpClients.mu.Lock()
+	defer httpClients.mu.Unlock()
+	status := gin.H{}
+	for name, stats := range httpClients.stats {
+		entry := gin.H{
+			"requests_total":    stats.requests.Load(),
+			"reused_conns":      stats.reused.Load(),
+			"new_conns":         stats.newConns.Load(),
+			"errors_total":      stats.errors.Load(),
+			"max_idle_per_host": 0,
+			"proxy":             false,
+		}
+		if pc, ok := httpClients.clients[name]; ok {
+			entry["max_idle_per_host"] = pc.settings.MaxIdleConnsPerHost
+			entry["proxy"] = pc.settings.ProxyURL != ""
+		}
+		status[name] = entry
+	}
+	return status
+}
@@ -0,0 +1,121 @@
+package server
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"gateway/internal/testsupport"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestDependencyHTTPClient_ReusedUntilSettingsChange(t *testing.T) {
+	hc := HTTPClientConfig{MaxIdleConnsPerHost: 4, DialTimeout: time.Second, TLSHandshakeTimeout: time.Second, IdleConnTimeout: time.Minute}
+	first := dependencyHTTPClient("test-reuse", hc)
+	if dependencyHTTPClient("test-reuse", hc) != first {
+		t.Error("same settings should return the pooled client")
+	}
+	hc.MaxIdleConnsPerHost = 8
+	if dependencyHTTPClient("test-reuse", hc) == first {
+		t.Error("changed settings should rebuild the client")
+	}
+}
+
+// forgetDependencyClient drops the pooled client and stats for name when t
+// ends, so repeated runs start from zero.
+func forgetDependencyClient(t *testing.T, name string) {
+	t.Cleanup(func() {
+		httpClients.mu.Lock()
+		defer httpClients.mu.Unlock()
+		if pc, ok := httpClients.clients[name]; ok {
+			pc.transport.CloseIdleConnections()
+		}
+		delete(httpClients.clients, name)
+		delete(httpClients.stats, name)
+	})
+}
+
+func TestDependencyHTTPClient_CountsConnectionReuse(t *testing.T) {
+	forgetDependencyClient(t, "test-stats")
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "ok")
+	}))
+	t.Cleanup(srv.Close)
+
+	client := dependencyHTTPClient("test-stats", HTTPClientConfig{MaxIdleConnsPerHost: 2, DialTimeout: time.Second, TLSHandshakeTimeout: time.Second, IdleConnTimeout: time.Minute})
+	for i := 0; i < 3; i++ {
+		resp, err := client.Get(srv.URL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+	}
+
+	stats := httpClientStatus()["test-stats"].(gin.H)
+	if stats["requests_total"] != int64(3) || stats["new_conns"] != int64(1) || stats["reused_conns"] != int64(2) {
+		t.Errorf("expected 3 requests over 1 reused connection, got %v", stats)
+	}
+}
+
+func TestDependencyHTTPClient_Proxy(t *testing.T) {
+	var proxied atomic.Int32
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// A forward proxy sees the absolute target URL.
+		if r.URL.Host == "verifier.internal" {
+			proxied.Add(1)
+		}
+		io.WriteString(w, "ok")
+	}))
+	t.Cleanup(proxy.Close)
+
+	client := dependencyHTTPClient("test-proxy", HTTPClientConfig{MaxIdleConnsPerHost: 1, DialTimeout: time.Second, TLSHandshakeTimeout: time.Second, IdleConnTimeout: time.Minute, ProxyURL: proxy.URL})
+	resp, err := client.Get("http://verifier.internal/health")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if proxied.Load() != 1 {
+		t.Error("request should go through the configured proxy")
+	}
+}
+
+func TestLoadConfig_HTTPClientSettings(t *testing.T) {
+	t.Setenv("VERIFIER_HTTP_MAX_IDLE_CONNS_PER_HOST", "7")
+	t.Setenv("OPENROUTER_HTTP_DIAL_TIMEOUT_SECONDS", "9")
+	cfg := loadConfig()
+	if cfg.VerifierHTTP.MaxIdleConnsPerHost != 7 || cfg.ProviderHTTP.DialTimeout != 9*time.Second {
+		t.Errorf("unexpected settings %+v / %+v", cfg.VerifierHTTP, cfg.ProviderHTTP)
+	}
+
+	t.Setenv("OPENROUTER_HTTP_PROXY_URL", "not a url")
+	if err := loadConfig().Validate(); err == nil {
+		t.Error("invalid proxy URL should fail validation")
+	}
+}
+
+func TestHTTPClients_UsedForVerifierAndProvider(t *testing.T) {
+	h := testsupport.NewHarness(t, newTestRouter)
+	before := httpClientStatus()
+	count := func(status gin.H, name string) int64 {
+		if entry, ok := status[name].(gin.H); ok {
+			return entry["requests_total"].(int64)
+		}
+		return 0
+	}
+
+	resp := h.Post(t, "/api/ai/summarize", `{"text":"pooled"}`, "0xsig", "nonce")
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected 200, got %d", resp.StatusCode)
+	}
+	after := httpClientStatus()
+	for _, dep := range []string{depVerifier, depOpenRouter} {
+		if count(after, dep) <= count(before, dep) {
+			t.Errorf("%s calls should go through its pooled client", dep)
+		}
+	}
+}
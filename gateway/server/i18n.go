@@ -0,0 +1,366 @@
+package server
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// errorTranslation is the bundled translation of one language: error titles
+// by code, and messages by their English text, or their format for errors
+// built with newAPIErrorf. Codes and anything untranslated stay as they are.
+type errorTranslation struct {
+	titles   map[ErrorCode]string
+	messages map[string]string
+}
+
+// errorTranslations covers the errors a paying client is expected to act
+// on: 402 challenges, payment rejections, rate limits and load shedding.
+var errorTranslations = map[string]errorTranslation{
+	"es": {
+		titles: map[ErrorCode]string{
+			CodePaymentRequired:       "Pago requerido",
+			CodePaymentContextExpired: "Contexto de pago caducado",
+			CodeNonceReplayed:         "Nonce reutilizado",
+			CodeChainUnsupported:      "Cadena no admitida",
+			CodeQuoteRequired:         "Cotización requerida",
+			CodeQuoteInvalid:          "Cotización no válida",
+			CodeQuoteExpired:          "Cotización caducada",
+			CodeQuoteMismatch:         "La cotización no coincide",
+			CodeChallengeUnknown:      "Desafío desconocido",
+			CodeChallengeMismatch:     "El desafío no coincide",
+			CodeVoucherInsufficient:   "Vale insuficiente",
+			CodeBudgetExceeded:        "Presupuesto superado",
+			CodeSponsorCapExceeded:    "Límite del patrocinador superado",
+			CodeSignatureInvalid:      "Firma no válida",
+			CodeRateLimited:           "Demasiadas solicitudes",
+			CodeTemporarilyBanned:     "Bloqueado temporalmente",
+			CodeConcurrencyLimited:    "Demasiadas solicitudes simultáneas",
+			CodeOverloaded:            "Servicio sobrecargado",
+			CodeMaintenance:           "Mantenimiento",
+			CodeAIUnavailable:         "Proveedor de IA no disponible",
+		},
+		messages: map[string]string{
+			"Please sign the payment context":                                                                       "Firme el contexto de pago",
+			"Rate limit exceeded. Please retry later.":                                                              "Se ha superado el límite de solicitudes. Vuelva a intentarlo más tarde.",
+			"Too many failed requests; this client is temporarily banned":                                           "Demasiadas solicitudes fallidas; este cliente está bloqueado temporalmente",
+			"This wallet already has %d requests in progress":                                                       "Esta billetera ya tiene %d solicitudes en curso",
+			"The gateway is prioritizing other requests. Please retry later.":                                       "La pasarela está dando prioridad a otras solicitudes. Vuelva a intentarlo más tarde.",
+			"The gateway is shedding load. Please retry later.":                                                     "La pasarela está descartando carga. Vuelva a intentarlo más tarde.",
+			"The payment nonce was already used":                                                                    "El nonce del pago ya se ha utilizado",
+			"Echo the quoteSignature and expiry from the payment context":                                           "Reenvíe quoteSignature y expiry del contexto de pago",
+			"The price quote has expired; sign the new payment context":                                             "La cotización ha caducado; firme el nuevo contexto de pago",
+			"The signed quote does not match this request's price, nonce, chain or recipient":                       "La cotización firmada no coincide con el precio, el nonce, la cadena o el destinatario de esta solicitud",
+			"The nonce was not issued by this gateway, has expired or was already paid; sign a new payment context": "Esta pasarela no emitió el nonce, ha caducado o ya se pagó; firme un nuevo contexto de pago",
+			"The request costs more than the sponsor grant allows per payment":                                      "La solicitud cuesta más de lo que la concesión del patrocinador permite por pago",
+			"The sponsor grant's total cap has been reached":                                                        "Se ha alcanzado el límite total de la concesión del patrocinador",
+			"The AI provider is unavailable. Please retry later.":                                                   "El proveedor de IA no está disponible. Vuelva a intentarlo más tarde.",
+			"The AI provider is unavailable; only cached responses are being served. Please retry later.":           "El proveedor de IA no está disponible; solo se sirven respuestas en caché. Vuelva a intentarlo más tarde.",
+			"Chain %q is not accepted; pick one of the offered payment contexts":                                    "No se acepta la cadena %q; elija uno de los contextos de pago ofrecidos",
+			"The challenge was issued for %s but this request costs %s":                                             "El desafío se emitió por %s, pero esta solicitud cuesta %s",
+			"The voucher covers %s but this request costs %s":                                                       "El vale cubre %s, pero esta solicitud cuesta %s",
+			"The %s spending cap for this wallet has been reached":                                                  "Se ha alcanzado el límite de gasto %s de esta billetera",
+		},
+	},
+	"fr": {
+		titles: map[ErrorCode]string{
+			CodePaymentRequired:       "Paiement requis",
+			CodePaymentContextExpired: "Contexte de paiement expiré",
+			CodeNonceReplayed:         "Nonce réutilisé",
+			CodeChainUnsupported:      "Chaîne non prise en charge",
+			CodeQuoteRequired:         "Devis requis",
+			CodeQuoteInvalid:          "Devis invalide",
+			CodeQuoteExpired:          "Devis expiré",
+			CodeQuoteMismatch:         "Devis non concordant",
+			CodeChallengeUnknown:      "Défi inconnu",
+			CodeChallengeMismatch:     "Défi non concordant",
+			CodeVoucherInsufficient:   "Bon insuffisant",
+			CodeBudgetExceeded:        "Budget dépassé",
+			CodeSponsorCapExceeded:    "Plafond du sponsor dépassé",
+			CodeSignatureInvalid:      "Signature invalide",
+			CodeRateLimited:           "Trop de requêtes",
+			CodeTemporarilyBanned:     "Temporairement banni",
+			CodeConcurrencyLimited:    "Trop de requêtes simultanées",
+			CodeOverloaded:            "Service surchargé",
+			CodeMaintenance:           "Maintenance",
+			CodeAIUnavailable:         "Fournisseur d'IA indisponible",
+		},
+		messages: map[string]string{
+			"Please sign the payment context":                                                                       "Veuillez signer le contexte de paiement",
+			"Rate limit exceeded. Please retry later.":                                                              "Limite de requêtes dépassée. Veuillez réessayer plus tard.",
+			"Too many failed requests; this client is temporarily banned":                                           "Trop de requêtes en échec ; ce client est temporairement banni",
+			"This wallet already has %d requests in progress":                                                       "Ce portefeuille a déjà %d requêtes en cours",
+			"The gateway is prioritizing other requests. Please retry later.":                                       "La passerelle donne la priorité à d'autres requêtes. Veuillez réessayer plus tard.",
+			"The gateway is shedding load. Please retry later.":                                                     "La passerelle déleste la charge. Veuillez réessayer plus tard.",
+			"The payment nonce was already used":                                                                    "Le nonce de paiement a déjà été utilisé",
+			"Echo the quoteSignature and expiry from the payment context":                                           "Renvoyez quoteSignature et expiry du contexte de paiement",
+			"The price quote has expired; sign the new payment context":                                             "Le devis a expiré ; signez le nouveau contexte de paiement",
+			"The signed quote does not match this request's price, nonce, chain or recipient":                       "Le devis signé ne correspond pas au prix, au nonce, à la chaîne ou au destinataire de cette requête",
+			"The nonce was not issued by this gateway, has expired or was already paid; sign a new payment context": "Le nonce n'a pas été émis par cette passerelle, a expiré ou a déjà été payé ; signez un nouveau contexte de paiement",
+			"The request costs more than the sponsor grant allows per payment":                                      "La requête coûte plus que ce que l'autorisation du sponsor permet par paiement",
+			"The sponsor grant's total cap has been reached":                                                        "Le plafond total de l'autorisation du sponsor est atteint",
+			"The AI provider is unavailable. Please retry later.":                                                   "Le fournisseur d'IA est indisponible. Veuillez réessayer plus tard.",
+			"The AI provider is unavailable; only cached responses are being served. Please retry later.":           "Le fournisseur d'IA est indisponible ; seules les réponses en cache sont servies. Veuillez réessayer plus tard.",
+			"Chain %q is not accepted; pick one of the offered payment contexts":                                    "La chaîne %q n'est pas acceptée ; choisissez l'un des contextes de paiement proposés",
+			"The challenge was issued for %s but this request costs %s":                                             "Le défi a été émis pour %s mais cette requête coûte %s",
+			"The voucher covers %s but this request costs %s":                                                       "Le bon couvre %s mais cette requête coûte %s",
+			"The %s spending cap for this wallet has been reached":                                                  "Le plafond de dépenses %s de ce portefeuille est atteint",
+		},
+	},
+	"de": {
+		titles: map[ErrorCode]string{
+			CodePaymentRequired:       "Zahlung erforderlich",
+			CodePaymentContextExpired: "Zahlungskontext abgelaufen",
+			CodeNonceReplayed:         "Nonce bereits verwendet",
+			CodeChainUnsupported:      "Nicht unterstützte Chain",
+			CodeQuoteRequired:         "Angebot erforderlich",
+			CodeQuoteInvalid:          "Ungültiges Angebot",
+			CodeQuoteExpired:          "Angebot abgelaufen",
+			CodeQuoteMismatch:         "Angebot stimmt nicht überein",
+			CodeChallengeUnknown:      "Unbekannte Challenge",
+			CodeChallengeMismatch:     "Challenge stimmt nicht überein",
+			CodeVoucherInsufficient:   "Gutschein reicht nicht aus",
+			CodeBudgetExceeded:        "Budget überschritten",
+			CodeSponsorCapExceeded:    "Sponsorlimit überschritten",
+			CodeSignatureInvalid:      "Ungültige Signatur",
+			CodeRateLimited:           "Zu viele Anfragen",
+			CodeTemporarilyBanned:     "Vorübergehend gesperrt",
+			CodeConcurrencyLimited:    "Zu viele gleichzeitige Anfragen",
+			CodeOverloaded:            "Dienst überlastet",
+			CodeMaintenance:           "Wartung",
+			CodeAIUnavailable:         "KI-Anbieter nicht verfügbar",
+		},
+		messages: map[string]string{
+			"Please sign the payment context":                                                                       "Bitte signieren Sie den Zahlungskontext",
+			"Rate limit exceeded. Please retry later.":                                                              "Anfragelimit überschritten. Bitte versuchen Sie es später erneut.",
+			"Too many failed requests; this client is temporarily banned":                                           "Zu viele fehlgeschlagene Anfragen; dieser Client ist vorübergehend gesperrt",
+			"This wallet already has %d requests in progress":                                                       "Diese Wallet hat bereits %d laufende Anfragen",
+			"The gateway is prioritizing other requests. Please retry later.":                                       "Das Gateway bevorzugt andere Anfragen. Bitte versuchen Sie es später erneut.",
+			"The gateway is shedding load. Please retry later.":                                                     "Das Gateway reduziert die Last. Bitte versuchen Sie es später erneut.",
+			"The payment nonce was already used":                                                                    "Die Zahlungs-Nonce wurde bereits verwendet",
+			"Echo the quoteSignature and expiry from the payment context":                                           "Senden Sie quoteSignature und expiry aus dem Zahlungskontext zurück",
+			"The price quote has expired; sign the new payment context":                                             "Das Preisangebot ist abgelaufen; signieren Sie den neuen Zahlungskontext",
+			"The signed quote does not match this request's price, nonce, chain or recipient":                       "Das signierte Angebot passt nicht zu Preis, Nonce, Chain oder Empfänger dieser Anfrage",
+			"The nonce was not issued by this gateway, has expired or was already paid; sign a new payment context": "Die Nonce stammt nicht von diesem Gateway, ist abgelaufen oder wurde bereits bezahlt; signieren Sie einen neuen Zahlungskontext",
+			"The request costs more than the sponsor grant allows per payment":                                      "Die Anfrage kostet mehr, als die Sponsorfreigabe pro Zahlung erlaubt",
+			"The sponsor grant's total cap has been reached":                                                        "Das Gesamtlimit der Sponsorfreigabe ist erreicht",
+			"The AI provider is unavailable. Please retry later.":                                                   "Der KI-Anbieter ist nicht verfügbar. Bitte versuchen Sie es später erneut.",
+			"The AI provider is unavailable; only cached responses are being served. Please retry later.":           "Der KI-Anbieter ist nicht verfügbar; es werden nur zwischengespeicherte Antworten geliefert. Bitte versuchen Sie es später erneut.",
+			"Chain %q is not accepted; pick one of the offered payment contexts":                                    "Chain %q wird nicht akzeptiert; wählen Sie einen der angebotenen Zahlungskontexte",
+			"The challenge was issued for %s but this request costs %s":                                             "Die Challenge wurde für %s ausgestellt, diese Anfrage kostet aber %s",
+			"The voucher covers %s but this request costs %s":                                                       "Der Gutschein deckt %s ab, diese Anfrage kostet aber %s",
+			"The %s spending cap for this wallet has been reached":                                                  "Das Ausgabenlimit (%s) dieser Wallet ist erreicht",
+		},
+	},
+	"pt": {
+		titles: map[ErrorCode]string{
+			CodePaymentRequired:       "Pagamento necessário",
+			CodePaymentContextExpired: "Contexto de pagamento expirado",
+			CodeNonceReplayed:         "Nonce reutilizado",
+			CodeChainUnsupported:      "Rede não suportada",
+			CodeQuoteRequired:         "Cotação necessária",
+			CodeQuoteInvalid:          "Cotação inválida",
+			CodeQuoteExpired:          "Cotação expirada",
+			CodeQuoteMismatch:         "Cotação divergente",
+			CodeChallengeUnknown:      "Desafio desconhecido",
+			CodeChallengeMismatch:     "Desafio divergente",
+			CodeVoucherInsufficient:   "Voucher insuficiente",
+			CodeBudgetExceeded:        "Orçamento excedido",
+			CodeSponsorCapExceeded:    "Limite do patrocinador excedido",
+			CodeSignatureInvalid:      "Assinatura inválida",
+			CodeRateLimited:           "Muitas solicitações",
+			CodeTemporarilyBanned:     "Bloqueado temporariamente",
+			CodeConcurrencyLimited:    "Muitas solicitações simultâneas",
+			CodeOverloaded:            "Serviço sobrecarregado",
+			CodeMaintenance:           "Manutenção",
+			CodeAIUnavailable:         "Provedor de IA indisponível",
+		},
+		messages: map[string]string{
+			"Please sign the payment context":                                                                       "Assine o contexto de pagamento",
+			"Rate limit exceeded. Please retry later.":                                                              "Limite de solicitações excedido. Tente novamente mais tarde.",
+			"Too many failed requests; this client is temporarily banned":                                           "Muitas solicitações com falha; este cliente está bloqueado temporariamente",
+			"This wallet already has %d requests in progress":                                                       "Esta carteira já tem %d solicitações em andamento",
+			"The gateway is prioritizing other requests. Please retry later.":                                       "O gateway está priorizando outras solicitações. Tente novamente mais tarde.",
+			"The gateway is shedding load. Please retry later.":                                                     "O gateway está descartando carga. Tente novamente mais tarde.",
+			"The payment nonce was already used":                                                                    "O nonce do pagamento já foi usado",
+			"Echo the quoteSignature and expiry from the payment context":                                           "Reenvie quoteSignature e expiry do contexto de pagamento",
+			"The price quote has expired; sign the new payment context":                                             "A cotação expirou; assine o novo contexto de pagamento",
+			"The signed quote does not match this request's price, nonce, chain or recipient":                       "A cotação assinada não corresponde ao preço, nonce, rede ou destinatário desta solicitação",
+			"The nonce was not issued by this gateway, has expired or was already paid; sign a new payment context": "O nonce não foi emitido por este gateway, expirou ou já foi pago; assine um novo contexto de pagamento",
+			"The request costs more than the sponsor grant allows per payment":                                      "A solicitação custa mais do que a concessão do patrocinador permite por pagamento",
+			"The sponsor grant's total cap has been reached":                                                        "O limite total da concessão do patrocinador foi atingido",
+			"The AI provider is unavailable. Please retry later.":                                                   "O provedor de IA está indisponível. Tente novamente mais tarde.",
+			"The AI provider is unavailable; only cached responses are being served. Please retry later.":           "O provedor de IA está indisponível; apenas respostas em cache estão sendo servidas. Tente novamente mais tarde.",
+			"Chain %q is not accepted; pick one of the offered payment contexts":                                    "A rede %q não é aceita; escolha um dos contextos de pagamento oferecidos",
+			"The challenge was issued for %s but this request costs %s":                                             "O desafio foi emitido para %s, mas esta solicitação custa %s",
+			"The voucher covers %s but this request costs %s":                                                       "O voucher cobre %s, mas esta solicitação custa %s",
+			"The %s spending cap for this wallet has been reached":                                                  "O limite de gastos %s desta carteira foi atingido",
+		},
+	},
+	"ja": {
+		titles: map[ErrorCode]string{
+			CodePaymentRequired:       "支払いが必要です",
+			CodePaymentContextExpired: "支払いコンテキストの期限切れ",
+			CodeNonceReplayed:         "使用済みのノンス",
+			CodeChainUnsupported:      "未対応のチェーン",
+			CodeQuoteRequired:         "見積もりが必要です",
+			CodeQuoteInvalid:          "無効な見積もり",
+			CodeQuoteExpired:          "見積もりの期限切れ",
+			CodeQuoteMismatch:         "見積もりの不一致",
+			CodeChallengeUnknown:      "不明なチャレンジ",
+			CodeChallengeMismatch:     "チャレンジの不一致",
+			CodeVoucherInsufficient:   "バウチャー不足",
+			CodeBudgetExceeded:        "予算超過",
+			CodeSponsorCapExceeded:    "スポンサー上限超過",
+			CodeSignatureInvalid:      "無効な署名",
+			CodeRateLimited:           "リクエストが多すぎます",
+			CodeTemporarilyBanned:     "一時的に禁止されています",
+			CodeConcurrencyLimited:    "同時リクエストが多すぎます",
+			CodeOverloaded:            "サービス過負荷",
+			CodeMaintenance:           "メンテナンス中",
+			CodeAIUnavailable:         "AI プロバイダー利用不可",
+		},
+		messages: map[string]string{
+			"Please sign the payment context":                                                                       "支払いコンテキストに署名してください",
+			"Rate limit exceeded. Please retry later.":                                                              "レート制限を超えました。しばらくしてから再試行してください。",
+			"Too many failed requests; this client is temporarily banned":                                           "失敗したリクエストが多すぎるため、このクライアントは一時的に禁止されています",
+			"This wallet already has %d requests in progress":                                                       "このウォレットには既に処理中のリクエストが %d 件あります",
+			"The gateway is prioritizing other requests. Please retry later.":                                       "ゲートウェイは他のリクエストを優先しています。しばらくしてから再試行してください。",
+			"The gateway is shedding load. Please retry later.":                                                     "ゲートウェイは負荷を軽減しています。しばらくしてから再試行してください。",
+			"The payment nonce was already used":                                                                    "支払いノンスは既に使用されています",
+			"Echo the quoteSignature and expiry from the payment context":                                           "支払いコンテキストの quoteSignature と expiry を送り返してください",
+			"The price quote has expired; sign the new payment context":                                             "見積もりの期限が切れました。新しい支払いコンテキストに署名してください",
+			"The signed quote does not match this request's price, nonce, chain or recipient":                       "署名された見積もりが、このリクエストの価格、ノンス、チェーン、または受取人と一致しません",
+			"The nonce was not issued by this gateway, has expired or was already paid; sign a new payment context": "ノンスはこのゲートウェイが発行したものではないか、期限切れか、支払い済みです。新しい支払いコンテキストに署名してください",
+			"The request costs more than the sponsor grant allows per payment":                                      "リクエストの料金がスポンサー許可の 1 回あたりの上限を超えています",
+			"The sponsor grant's total cap has been reached":                                                        "スポンサー許可の合計上限に達しました",
+			"The AI provider is unavailable. Please retry later.":                                                   "AI プロバイダーを利用できません。しばらくしてから再試行してください。",
+			"The AI provider is unavailable; only cached responses are being served. Please retry later.":           "AI プロバイダーを利用できないため、キャッシュされた応答のみを返しています。しばらくしてから再試行してください。",
+			"Chain %q is not accepted; pick one of the offered payment contexts":                                    "チェーン %q は利用できません。提示された支払いコンテキストから選択してください",
+			"The challenge was issued for %s but this request costs %s":                                             "チャレンジは %s で発行されましたが、このリクエストの料金は %s です",
+			"The voucher covers %s but this request costs %s":                                                       "バウチャーの残高は %s ですが、このリクエストの料金は %s です",
+			"The %s spending cap for this wallet has been reached":                                                  "このウォレットの %s 支出上限に達しました",
+		},
+	},
+	"zh": {
+		titles: map[ErrorCode]string{
+			CodePaymentRequired:       "需要付款",
+			CodePaymentContextExpired: "付款上下文已过期",
+			CodeNonceReplayed:         "Nonce 已被使用",
+			CodeChainUnsupported:      "不支持的链",
+			CodeQuoteRequired:         "需要报价",
+			CodeQuoteInvalid:          "报价无效",
+			CodeQuoteExpired:          "报价已过期",
+			CodeQuoteMismatch:         "报价不匹配",
+			CodeChallengeUnknown:      "未知的质询",
+			CodeChallengeMismatch:     "质询不匹配",
+			CodeVoucherInsufficient:   "凭证余额不足",
+			CodeBudgetExceeded:        "超出预算",
+			CodeSponsorCapExceeded:    "超出赞助上限",
+			CodeSignatureInvalid:      "签名无效",
+			CodeRateLimited:           "请求过多",
+			CodeTemporarilyBanned:     "已被暂时封禁",
+			CodeConcurrencyLimited:    "并发请求过多",
+			CodeOverloaded:            "服务过载",
+			CodeMaintenance:           "维护中",
+			CodeAIUnavailable:         "AI 服务商不可用",
+		},
+		messages: map[string]string{
+			"Please sign the payment context":                                                                       "请签署付款上下文",
+			"Rate limit exceeded. Please retry later.":                                                              "超出速率限制，请稍后重试。",
+			"Too many failed requests; this client is temporarily banned":                                           "失败的请求过多，此客户端已被暂时封禁",
+			"This wallet already has %d requests in progress":                                                       "此钱包已有 %d 个请求正在处理",
+			"The gateway is prioritizing other requests. Please retry later.":                                       "网关正在优先处理其他请求，请稍后重试。",
+			"The gateway is shedding load. Please retry later.":                                                     "网关正在削减负载，请稍后重试。",
+			"The payment nonce was already used":                                                                    "付款 nonce 已被使用",
+			"Echo the quoteSignature and expiry from the payment context":                                           "请回传付款上下文中的 quoteSignature 和 expiry",
+			"The price quote has expired; sign the new payment context":                                             "报价已过期，请签署新的付款上下文",
+			"The signed quote does not match this request's price, nonce, chain or recipient":                       "已签名的报价与此请求的价格、nonce、链或收款人不匹配",
+			"The nonce was not issued by this gateway, has expired or was already paid; sign a new payment context": "该 nonce 不是此网关签发的、已过期或已付款，请签署新的付款上下文",
+			"The request costs more than the sponsor grant allows per payment":                                      "此请求的费用超过赞助授权的单次付款上限",
+			"The sponsor grant's total cap has been reached":                                                        "已达到赞助授权的总上限",
+			"The AI provider is unavailable. Please retry later.":                                                   "AI 服务商不可用，请稍后重试。",
+			"The AI provider is unavailable; only cached responses are being served. Please retry later.":           "AI 服务商不可用，目前仅提供缓存的响应，请稍后重试。",
+			"Chain %q is not accepted; pick one of the offered payment contexts":                                    "不接受链 %q，请从提供的付款上下文中选择一个",
+			"The challenge was issued for %s but this request costs %s":                                             "质询的签发金额为 %s，但此请求的费用为 %s",
+			"The voucher covers %s but this request costs %s":                                                       "凭证可抵扣 %s，但此请求的费用为 %s",
+			"The %s spending cap for this wallet has been reached":                                                  "此钱包已达到 %s 支出上限",
+		},
+	},
+}
+
+// errorLanguage negotiates the language of error responses from
+// Accept-Language: the bundled translation the client prefers most, or ""
+// for English when it prefers English, asks for nothing bundled, or
+// ERROR_LOCALIZATION is off.
+func errorLanguage(c *gin.Context) string {
+	if c.Request == nil || !getEnvAsBool("ERROR_LOCALIZATION", true) {
+		return ""
+	}
+	return negotiateErrorLanguage(c.GetHeader("Accept-Language"))
+}
+
+// negotiateErrorLanguage picks from an Accept-Language value, e.g.
+// "fr-CH, fr;q=0.9, en;q=0.8". Tags match by their primary subtag.
+func negotiateErrorLanguage(header string) string {
+	type weighted struct {
+		lang string
+		q    float64
+	}
+	var prefs []weighted
+	for _, part := range strings.Split(header, ",") {
+		tag, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		q := 1.0
+		if v, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			parsed, err := strconv.ParseFloat(v, 64)
+			if err != nil {
+				continue
+			}
+			q = parsed
+		}
+		base, _, _ := strings.Cut(strings.ToLower(strings.TrimSpace(tag)), "-")
+		if base == "" || base == "*" || q <= 0 {
+			continue
+		}
+		prefs = append(prefs, weighted{base, q})
+	}
+	sort.SliceStable(prefs, func(i, j int) bool { return prefs[i].q > prefs[j].q })
+	for _, p := range prefs {
+		if p.lang == "en" {
+			return ""
+		}
+		if _, ok := errorTranslations[p.lang]; ok {
+			return p.lang
+		}
+	}
+	return ""
+}
+
+// localize translates e's title and message into lang where the bundle has
+// them, and reports whether it translated anything.
+func (e *APIError) localize(lang string) bool {
+	t, ok := errorTranslations[lang]
+	if !ok {
+		return false
+	}
+	translated := false
+	if title, ok := t.titles[e.Code]; ok {
+		e.Title = title
+		translated = true
+	}
+	key := e.Message
+	if e.format != "" {
+		key = e.format
+	}
+	if msg, ok := t.messages[key]; ok {
+		if e.format != "" {
+			msg = fmt.Sprintf(msg, e.args...)
+		}
+		e.Message = msg
+		translated = true
+	}
+	return translated
+}
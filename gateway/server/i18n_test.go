@@ -0,0 +1,104 @@
+package server
+
+import (
+	"net/http"
+	"regexp"
+	"testing"
+
+	"gateway/internal/testsupport"
+)
+
+func TestNegotiateErrorLanguage(t *testing.T) {
+	cases := map[string]string{
+		"":                           "",
+		"es":                         "es",
+		"fr-CH, fr;q=0.9, en;q=0.8":  "fr",
+		"en-US,en;q=0.9,de;q=0.8":    "",
+		"de;q=0.5, ja;q=0.7":         "ja",
+		"sw, pt-BR;q=0.6":            "pt",
+		"zh-Hans-CN":                 "zh",
+		"*":                          "",
+		"de;q=0, fr;q=bad, es;q=0.1": "es",
+		"ko, nl":                     "",
+		"  FR  ;  q=1 ":              "fr",
+	}
+	for header, want := range cases {
+		if got := negotiateErrorLanguage(header); got != want {
+			t.Errorf("%q: expected %q, got %q", header, want, got)
+		}
+	}
+}
+
+func TestErrorTranslations_Complete(t *testing.T) {
+	verbs := regexp.MustCompile(`%[a-z]`)
+	// Every language translates the messages es does, keyed by the English.
+	reference := errorTranslations["es"]
+	for lang, tr := range errorTranslations {
+		if len(tr.titles) != len(reference.titles) || len(tr.messages) != len(reference.messages) {
+			t.Errorf("%s: expected the same entries as es", lang)
+		}
+		for code := range tr.titles {
+			if _, ok := errorCatalog[code]; !ok {
+				t.Errorf("%s: %s is not a registered code", lang, code)
+			}
+		}
+		for msg, translated := range tr.messages {
+			if _, ok := reference.messages[msg]; !ok {
+				t.Errorf("%s: unexpected message %q", lang, msg)
+			}
+			if got, want := verbs.FindAllString(translated, -1), verbs.FindAllString(msg, -1); len(got) != len(want) {
+				t.Errorf("%s: %q must keep the verbs of %q", lang, translated, msg)
+			}
+		}
+	}
+}
+
+func TestAPIError_LocalizeFormattedMessage(t *testing.T) {
+	e := newAPIErrorf(CodeVoucherInsufficient, "The voucher covers %s but this request costs %s", "0.001", "0.002")
+	if e.Message != "The voucher covers 0.001 but this request costs 0.002" {
+		t.Fatalf("unexpected English message %q", e.Message)
+	}
+	if !e.localize("de") || e.Code != CodeVoucherInsufficient || e.Title != "Gutschein reicht nicht aus" || e.Message != "Der Gutschein deckt 0.001 ab, diese Anfrage kostet aber 0.002" {
+		t.Errorf("unexpected translation %+v", e)
+	}
+
+	e = newAPIError(CodeInvalidRequest, "text is required")
+	if e.localize("fr") || e.Title != "Invalid request" || e.Message != "text is required" {
+		t.Errorf("expected an untranslated error to stay English, got %+v", e)
+	}
+}
+
+func TestPaymentRequired_LocalizedByAcceptLanguage(t *testing.T) {
+	h := testsupport.NewHarness(t, newTestRouter)
+
+	req, _ := http.NewRequest(http.MethodPost, h.Server.URL+"/api/ai/summarize", nil)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept-Language", "es-MX,es;q=0.9,en;q=0.5")
+	resp, err := h.Server.Client().Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body := decodeErrorBody(t, resp)
+	if resp.StatusCode != http.StatusPaymentRequired || body.Code != CodePaymentRequired {
+		t.Fatalf("expected a 402 PAYMENT_REQUIRED, got %d %s", resp.StatusCode, body.Code)
+	}
+	if body.Error != "Pago requerido" || body.Message != "Firme el contexto de pago" {
+		t.Errorf("expected the Spanish title and message, got %q / %q", body.Error, body.Message)
+	}
+	if resp.Header.Get("Content-Language") != "es" || resp.Header.Get("Vary") == "" {
+		t.Errorf("expected Content-Language es and Vary, got %q / %q", resp.Header.Get("Content-Language"), resp.Header.Get("Vary"))
+	}
+
+	t.Setenv("ERROR_LOCALIZATION", "false")
+	req, _ = http.NewRequest(http.MethodPost, h.Server.URL+"/api/ai/summarize", nil)
+	req.Header.Set("Accept-Language", "es")
+	resp, err = h.Server.Client().Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if body := decodeErrorBody(t, resp); body.Error != "Payment Required" {
+		t.Errorf("expected English with ERROR_LOCALIZATION off, got %q", body.Error)
+	}
+}
@@ -0,0 +1,340 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"gateway/client"
+	"gateway/internal/testsupport"
+	"gateway/payments"
+	"gateway/receipts"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+// integrationGateway is a full gateway booted the way main does it: validated
+// config snapshot, router and every lifecycle subsystem, wired to a
+// MockVerifier, FakeOpenRouter and miniredis.
+type integrationGateway struct {
+	*testsupport.Harness
+	Redis *miniredis.Miniredis
+}
+
+// redisKeys returns every key in gw's Redis with the given prefix.
+func (gw *integrationGateway) redisKeys(prefix string) []string {
+	var keys []string
+	for _, k := range gw.Redis.Keys() {
+		if strings.HasPrefix(k, prefix) {
+			keys = append(keys, k)
+		}
+	}
+	return keys
+}
+
+// startGateway boots the gateway with caching on and env applied on top.
+// Everything is stopped when the test ends.
+func startGateway(t *testing.T, env map[string]string) *integrationGateway {
+	t.Helper()
+	redis := miniredis.RunT(t)
+	t.Setenv("CACHE_ENABLED", "true")
+	t.Setenv("REDIS_URL", redis.Addr())
+	t.Setenv("RATE_LIMIT_ENABLED", "false")
+	for k, v := range env {
+		t.Setenv(k, v)
+	}
+
+	var lc *Lifecycle
+	h := testsupport.NewHarness(t, func() http.Handler {
+		cfg := loadConfig()
+		if err := cfg.Validate(); err != nil {
+			t.Fatalf("invalid config: %v", err)
+		}
+		currentConfig.Store(cfg)
+		r := setupRouter()
+		lc = NewLifecycle()
+		registerSubsystems(lc, "")
+		if err := lc.Start(context.Background()); err != nil {
+			t.Fatalf("startup failed: %v", err)
+		}
+		return r
+	})
+	t.Cleanup(func() {
+		if err := lc.Stop(context.Background()); err != nil {
+			t.Errorf("shutdown failed: %v", err)
+		}
+		currentConfig.Store(nil)
+	})
+	return &integrationGateway{Harness: h, Redis: redis}
+}
+
+// decodeReceiptHeader decodes and verifies a JSON X-402-Receipt header.
+func decodeReceiptHeader(t *testing.T, header string) *SignedReceipt {
+	t.Helper()
+	raw, err := base64.StdEncoding.DecodeString(header)
+	if err != nil {
+		t.Fatalf("decode receipt header: %v", err)
+	}
+	var receipt SignedReceipt
+	if err := json.Unmarshal(raw, &receipt); err != nil {
+		t.Fatalf("decode receipt: %v", err)
+	}
+	if err := receipts.Verify(&receipt, nil); err != nil {
+		t.Fatalf("receipt does not verify: %v", err)
+	}
+	return &receipt
+}
+
+func TestIntegration_PaymentFlowWithSignedClient(t *testing.T) {
+	gw := startGateway(t, nil)
+	gw.AI.SetReply("Signed summary")
+
+	key, err := crypto.GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := client.New(gw.Server.URL, key)
+	gw.Verifier.SetValid(c.Address())
+
+	summary, receipt, err := c.Summarize(context.Background(), "integration text")
+	if err != nil {
+		t.Fatalf("summarize: %v", err)
+	}
+	if summary != "Signed summary" {
+		t.Errorf("unexpected summary %q", summary)
+	}
+
+	// The receipt is retrievable and the sequence counter lives in Redis.
+	resp := gw.Get(t, "/api/receipts/"+receipt.Receipt.ID)
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("receipt lookup: expected 200, got %d", resp.StatusCode)
+	}
+	if seq, err := gw.Redis.Get("receipt:seq:" + strings.ToLower(c.Address())); err != nil || seq != "1" {
+		t.Errorf("expected receipt sequence 1 in Redis, got %q", seq)
+	}
+}
+
+func TestIntegration_CacheServesRepeatRequests(t *testing.T) {
+	gw := startGateway(t, nil)
+	body := `{"text":"cache me"}`
+
+	first := gw.Post(t, "/api/ai/summarize", body, "0xsig", "nonce-1")
+	if first.StatusCode != http.StatusOK {
+		t.Fatalf("first request: expected 200, got %d", first.StatusCode)
+	}
+	deadline := time.Now().Add(2 * time.Second)
+	for len(gw.redisKeys("ai:summary:")) == 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond) // cache writes are asynchronous
+	}
+
+	second := gw.Post(t, "/api/ai/summarize", body, "0xsig", "nonce-2")
+	if second.StatusCode != http.StatusOK {
+		t.Fatalf("second request: expected 200, got %d", second.StatusCode)
+	}
+	if calls := gw.AI.Calls(); calls != 1 {
+		t.Errorf("expected 1 provider call, got %d", calls)
+	}
+	if calls := gw.Verifier.Calls(); calls != 2 {
+		t.Errorf("cache hits must still be verified: expected 2 verifier calls, got %d", calls)
+	}
+	r1 := decodeReceiptHeader(t, first.Header.Get("X-402-Receipt"))
+	r2 := decodeReceiptHeader(t, second.Header.Get("X-402-Receipt"))
+	if r1.Receipt.ID == r2.Receipt.ID || r2.Receipt.Payment.Sequence != 2 {
+		t.Errorf("cache hit should get its own receipt with the next sequence, got %+v", r2.Receipt.Payment)
+	}
+}
+
+func TestIntegration_RateLimits(t *testing.T) {
+	gw := startGateway(t, map[string]string{
+		"RATE_LIMIT_ENABLED":         "true",
+		"RATE_LIMIT_ANONYMOUS_RPM":   "1",
+		"RATE_LIMIT_ANONYMOUS_BURST": "2",
+	})
+
+	var last *http.Response
+	for i := 0; i < 3; i++ {
+		last = gw.Post(t, "/api/ai/summarize", `{"text":"hi"}`, "", "")
+	}
+	if last.StatusCode != http.StatusTooManyRequests {
+		t.Fatalf("expected 429 after burst, got %d", last.StatusCode)
+	}
+	if last.Header.Get("Retry-After") == "" || last.Header.Get("X-RateLimit-Remaining") != "0" {
+		t.Error("429 should carry Retry-After and X-RateLimit-Remaining: 0")
+	}
+}
+
+func TestIntegration_PremiumWalletGetsVerifiedTier(t *testing.T) {
+	gw := startGateway(t, map[string]string{
+		"RATE_LIMIT_ENABLED":            "true",
+		"RATE_LIMIT_VERIFIED_RPM":       "500",
+		"RATE_LIMIT_VERIFIED_REDIS_SET": "paygate:premium",
+	})
+	key, _ := crypto.GenerateKey()
+	c := client.New(gw.Server.URL, key)
+	gw.Verifier.SetValid(c.Address())
+	if _, err := gw.Redis.SAdd("paygate:premium", strings.ToLower(c.Address())); err != nil {
+		t.Fatal(err)
+	}
+
+	resp, err := c.Post(context.Background(), "/api/ai/summarize", []byte(`{"text":"premium"}`))
+	if err != nil {
+		t.Fatalf("post: %v", err)
+	}
+	if got := resp.Header.Get("X-RateLimit-Limit"); got != "500" {
+		t.Errorf("expected verified tier limit 500, got %q", got)
+	}
+}
+
+func TestIntegration_XPaymentGetsVerifiedTier(t *testing.T) {
+	gw := startGateway(t, map[string]string{
+		"RATE_LIMIT_ENABLED":            "true",
+		"RATE_LIMIT_VERIFIED_RPM":       "500",
+		"RATE_LIMIT_VERIFIED_REDIS_SET": "paygate:premium",
+	})
+	key, _ := crypto.GenerateKey()
+	addr := crypto.PubkeyToAddress(key.PublicKey).Hex()
+	gw.Verifier.SetValid(addr)
+	if _, err := gw.Redis.SAdd("paygate:premium", strings.ToLower(addr)); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := getConfig()
+	sig, err := payments.Sign(payments.Context{Recipient: cfg.RecipientAddress, Token: "USDC", Amount: cfg.PaymentAmount, Nonce: "n-xpayment", ChainID: cfg.ChainID}, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	payment, _ := json.Marshal(paymentHeaderV2{Signature: sig, Nonce: "n-xpayment"})
+	req, _ := http.NewRequest(http.MethodPost, gw.Server.URL+"/api/v2/ai/summarize", bytes.NewBufferString(`{"input":"premium"}`))
+	req.Header.Set("X-PAYMENT", base64.StdEncoding.EncodeToString(payment))
+	resp, err := gw.Server.Client().Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected 200, got %d", resp.StatusCode)
+	}
+	if got := resp.Header.Get("X-RateLimit-Limit"); got != "500" {
+		t.Errorf("expected verified tier limit 500, got %q", got)
+	}
+}
+
+func TestIntegration_SpendCapsInRedis(t *testing.T) {
+	gw := startGateway(t, map[string]string{"SPEND_CAP_DAILY": "0.002", "PAYMENT_AMOUNT": "0.001"})
+	gw.Verifier.SetValid("0x00000000000000000000000000000000000b0d6e")
+
+	for i, nonce := range []string{"nonce-1", "nonce-2"} {
+		if resp := gw.Post(t, "/api/ai/summarize", `{"text":"spend `+nonce+`"}`, "0xsig", nonce); resp.StatusCode != http.StatusOK {
+			t.Fatalf("request %d: expected 200, got %d", i+1, resp.StatusCode)
+		}
+	}
+	// The reservation script keeps the daily total in Redis, not in memory.
+	if keys := gw.redisKeys("spend:daily:"); len(keys) != 1 {
+		t.Fatalf("expected one daily spend counter in Redis, got %v", keys)
+	}
+	if resp := gw.Post(t, "/api/ai/summarize", `{"text":"spend nonce-3"}`, "0xsig", "nonce-3"); resp.StatusCode != http.StatusPaymentRequired {
+		t.Errorf("expected 402 once the cap is reached, got %d", resp.StatusCode)
+	}
+}
+
+func TestIntegration_AITimeout(t *testing.T) {
+	gw := startGateway(t, map[string]string{"AI_REQUEST_TIMEOUT_SECONDS": "1"})
+	gw.AI.SetDelay(1500 * time.Millisecond)
+
+	resp := gw.Post(t, "/api/ai/summarize", `{"text":"slow"}`, "0xsig", "nonce")
+	if resp.StatusCode != http.StatusGatewayTimeout {
+		t.Errorf("expected 504, got %d", resp.StatusCode)
+	}
+}
+
+func TestIntegration_ErrorPaths(t *testing.T) {
+	gw := startGateway(t, nil)
+
+	t.Run("unpaid request gets a 402 challenge", func(t *testing.T) {
+		resp := gw.Post(t, "/api/ai/summarize", `{"text":"hi"}`, "", "")
+		var body map[string]any
+		_ = json.NewDecoder(resp.Body).Decode(&body)
+		if resp.StatusCode != http.StatusPaymentRequired || body["paymentContext"] == nil {
+			t.Errorf("expected 402 with paymentContext, got %d %v", resp.StatusCode, body)
+		}
+	})
+	t.Run("invalid JSON", func(t *testing.T) {
+		if resp := gw.Post(t, "/api/ai/summarize", `{not json`, "0xsig", "nonce"); resp.StatusCode != http.StatusBadRequest {
+			t.Errorf("expected 400, got %d", resp.StatusCode)
+		}
+	})
+	t.Run("oversized body", func(t *testing.T) {
+		big := `{"text":"` + strings.Repeat("a", 11*1024*1024) + `"}`
+		if resp := gw.Post(t, "/api/ai/summarize", big, "0xsig", "nonce"); resp.StatusCode != http.StatusRequestEntityTooLarge {
+			t.Errorf("expected 413, got %d", resp.StatusCode)
+		}
+	})
+	t.Run("invalid signature", func(t *testing.T) {
+		gw.Verifier.SetInvalid("bad signature")
+		defer gw.Verifier.SetValid(testsupport.DefaultPayer)
+		if resp := gw.Post(t, "/api/ai/summarize", `{"text":"sig"}`, "0xbad", "nonce"); resp.StatusCode != http.StatusForbidden {
+			t.Errorf("expected 403, got %d", resp.StatusCode)
+		}
+	})
+	t.Run("verifier outage", func(t *testing.T) {
+		gw.Verifier.SetStatus(http.StatusInternalServerError)
+		defer gw.Verifier.SetStatus(http.StatusOK)
+		if resp := gw.Post(t, "/api/ai/summarize", `{"text":"outage"}`, "0xsig", "nonce"); resp.StatusCode != http.StatusInternalServerError {
+			t.Errorf("expected 500, got %d", resp.StatusCode)
+		}
+	})
+	t.Run("provider failure", func(t *testing.T) {
+		gw.AI.SetStatus(http.StatusBadGateway)
+		defer gw.AI.SetStatus(http.StatusOK)
+		if resp := gw.Post(t, "/api/ai/summarize", `{"text":"provider down"}`, "0xsig", "nonce"); resp.StatusCode != http.StatusInternalServerError {
+			t.Errorf("expected 500, got %d", resp.StatusCode)
+		}
+	})
+	t.Run("unknown receipt", func(t *testing.T) {
+		if resp := gw.Get(t, "/api/receipts/doesnotexist"); resp.StatusCode != http.StatusNotFound {
+			t.Errorf("expected 404, got %d", resp.StatusCode)
+		}
+	})
+}
+
+func TestIntegration_AsyncJob(t *testing.T) {
+	gw := startGateway(t, nil)
+	gw.AI.SetReply("Job summary")
+
+	resp := gw.Post(t, "/api/ai/jobs", `{"text":"long input"}`, "0xsig", "nonce")
+	if resp.StatusCode != http.StatusAccepted {
+		data, _ := io.ReadAll(resp.Body)
+		t.Fatalf("expected 202, got %d: %s", resp.StatusCode, data)
+	}
+	job := waitForJob(t, gw.Harness, resp.Header.Get("Location"))
+	if job.Status != jobCompleted || job.Result != "Job summary" || job.Receipt == nil {
+		t.Errorf("unexpected job %+v", job)
+	}
+}
+
+func TestIntegration_V2RouteAndDiscovery(t *testing.T) {
+	gw := startGateway(t, nil)
+
+	payment, _ := json.Marshal(map[string]string{"signature": "0xsig", "nonce": "n"})
+	req, _ := http.NewRequest(http.MethodPost, gw.Server.URL+"/api/v2/ai/summarize", bytes.NewBufferString(`{"input":"v2"}`))
+	req.Header.Set("X-PAYMENT", base64.StdEncoding.EncodeToString(payment))
+	resp, err := gw.Server.Client().Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("v2 summarize: expected 200, got %d", resp.StatusCode)
+	}
+
+	if resp := gw.Get(t, "/.well-known/paygate-configuration"); resp.StatusCode != http.StatusOK {
+		t.Errorf("discovery: expected 200, got %d", resp.StatusCode)
+	}
+}
@@ -0,0 +1,132 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"mime/multipart"
+	"net/http"
+	"os"
+	"time"
+)
+
+// ipfsPinner uploads receipts to an IPFS pinning API that accepts a
+// multipart file upload and answers with the CID, such as the Kubo RPC
+// /api/v0/add?pin=true endpoint or Pinata's pinFileToIPFS.
+type ipfsPinner struct {
+	url     string
+	token   string
+	timeout time.Duration
+}
+
+// newIPFSPinner returns a pinner for RECEIPT_IPFS_API_URL, or nil when
+// archival is disabled.
+func newIPFSPinner() *ipfsPinner {
+	url := os.Getenv("RECEIPT_IPFS_API_URL")
+	if url == "" {
+		return nil
+	}
+	return &ipfsPinner{
+		url:     url,
+		token:   os.Getenv("RECEIPT_IPFS_API_TOKEN"),
+		timeout: getPositiveTimeout("RECEIPT_IPFS_TIMEOUT_SECONDS", 5),
+	}
+}
+
+// Pin uploads data as a file called name and returns its CID.
+func (p *ipfsPinner) Pin(ctx context.Context, name string, data []byte) (string, error) {
+	ctx, cancel := context.WithTimeout(ctx, p.timeout)
+	defer cancel()
+
+	var body bytes.Buffer
+	form := multipart.NewWriter(&body)
+	part, err := form.CreateFormFile("file", name)
+	if err != nil {
+		return "", err
+	}
+	if _, err := part.Write(data); err != nil {
+		return "", err
+	}
+	if err := form.Close(); err != nil {
+		return "", err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, p.url, &body)
+	if err != nil {
+		return "", fmt.Errorf("failed to create pin request: %w", err)
+	}
+	req.Header.Set("Content-Type", form.FormDataContentType())
+	if p.token != "" {
+		req.Header.Set("Authorization", "Bearer "+p.token)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		io.Copy(io.Discard, resp.Body)
+		return "", fmt.Errorf("pinning API returned status %d", resp.StatusCode)
+	}
+
+	// Kubo answers {"Hash": ...}, Pinata {"IpfsHash": ...}.
+	var result struct {
+		Hash     string `json:"Hash"`
+		IpfsHash string `json:"IpfsHash"`
+		CID      string `json:"cid"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return "", fmt.Errorf("failed to decode pinning API response: %w", err)
+	}
+	for _, cid := range []string{result.Hash, result.IpfsHash, result.CID} {
+		if cid != "" {
+			return cid, nil
+		}
+	}
+	return "", fmt.Errorf("pinning API response has no CID")
+}
+
+// ipfsRetryDelay is the wait before the first background pin retry; it
+// doubles on each of the following attempts.
+var ipfsRetryDelay = 2 * time.Second
+
+// archiveReceipt pins receipt to IPFS when RECEIPT_IPFS_API_URL is set and
+// records the CID with the stored receipt. It returns the CID, or "" when
+// archival is disabled or the pin failed; a failed pin is retried in the
+// background so the lookup endpoint picks up the CID later. Archival never
+// fails the request the receipt was issued for.
+func archiveReceipt(ctx context.Context, receipt *SignedReceipt) string {
+	pinner := newIPFSPinner()
+	if pinner == nil {
+		return ""
+	}
+	data, err := json.Marshal(receipt)
+	if err != nil {
+		log.Printf("[WARNING] Failed to encode receipt %s for IPFS: %v", receipt.Receipt.ID, err)
+		return ""
+	}
+	name := receipt.Receipt.ID + ".json"
+	cid, err := pinner.Pin(ctx, name, data)
+	if err == nil {
+		setReceiptCID(receipt.Receipt.ID, cid)
+		return cid
+	}
+	log.Printf("[WARNING] Failed to pin receipt %s to IPFS, retrying in background: %v", receipt.Receipt.ID, err)
+
+	go func() {
+		delay := ipfsRetryDelay
+		for attempt := 1; attempt <= 3; attempt++ {
+			time.Sleep(delay)
+			delay *= 2
+			if cid, err := pinner.Pin(context.Background(), name, data); err == nil {
+				setReceiptCID(receipt.Receipt.ID, cid)
+				return
+			}
+		}
+		log.Printf("[WARNING] Giving up pinning receipt %s to IPFS", receipt.Receipt.ID)
+	}()
+	return ""
+}
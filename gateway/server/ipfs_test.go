@@ -0,0 +1,109 @@
+package server
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"gateway/internal/testsupport"
+	"gateway/receipts"
+)
+
+// fakePinningAPI answers multipart uploads like Kubo's /api/v0/add. The
+// first failures requests get a 502.
+func fakePinningAPI(t *testing.T, failures int32, uploads chan<- []byte) *httptest.Server {
+	t.Helper()
+	var calls atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if calls.Add(1) <= failures {
+			http.Error(w, "unavailable", http.StatusBadGateway)
+			return
+		}
+		if r.Header.Get("Authorization") != "Bearer pin-token" {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		file, _, err := r.FormFile("file")
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		data, _ := io.ReadAll(file)
+		if uploads != nil {
+			uploads <- data
+		}
+		_ = json.NewEncoder(w).Encode(map[string]string{"Name": "receipt.json", "Hash": "bafytestcid", "Size": "100"})
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestReceiptIPFS_CIDHeaderAndLookup(t *testing.T) {
+	uploads := make(chan []byte, 1)
+	t.Setenv("RECEIPT_IPFS_API_URL", fakePinningAPI(t, 0, uploads).URL)
+	t.Setenv("RECEIPT_IPFS_API_TOKEN", "pin-token")
+	h := testsupport.NewHarness(t, newTestRouter)
+
+	resp := h.Post(t, "/api/ai/summarize", `{"text":"archive me"}`, "0xsig", "nonce")
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected 200, got %d", resp.StatusCode)
+	}
+	if got := resp.Header.Get("X-402-Receipt-CID"); got != "bafytestcid" {
+		t.Fatalf("expected CID header, got %q", got)
+	}
+
+	var pinned SignedReceipt
+	if err := json.Unmarshal(<-uploads, &pinned); err != nil {
+		t.Fatalf("pinned data is not a receipt: %v", err)
+	}
+	if err := receipts.Verify(&pinned, nil); err != nil {
+		t.Errorf("pinned receipt does not verify: %v", err)
+	}
+
+	lookup := h.Get(t, "/api/receipts/"+pinned.Receipt.ID)
+	var body struct {
+		CID string `json:"cid"`
+	}
+	_ = json.NewDecoder(lookup.Body).Decode(&body)
+	if body.CID != "bafytestcid" || lookup.Header.Get("X-402-Receipt-CID") != "bafytestcid" {
+		t.Errorf("lookup should return the stored CID, got %q", body.CID)
+	}
+}
+
+func TestReceiptIPFS_FailureDoesNotFailRequest(t *testing.T) {
+	prev := ipfsRetryDelay
+	ipfsRetryDelay = 10 * time.Millisecond
+	t.Cleanup(func() { ipfsRetryDelay = prev })
+	t.Setenv("RECEIPT_IPFS_API_URL", fakePinningAPI(t, 1, nil).URL)
+	t.Setenv("RECEIPT_IPFS_API_TOKEN", "pin-token")
+	h := testsupport.NewHarness(t, newTestRouter)
+
+	resp := h.Post(t, "/api/ai/summarize", `{"text":"archive later"}`, "0xsig", "nonce")
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("pinning failure must not fail the request, got %d", resp.StatusCode)
+	}
+	if resp.Header.Get("X-402-Receipt-CID") != "" {
+		t.Error("no CID header expected when the pin failed")
+	}
+
+	receipt := decodeReceiptHeader(t, resp.Header.Get("X-402-Receipt"))
+	deadline := time.Now().Add(2 * time.Second)
+	for getReceiptCID(receipt.Receipt.ID) == "" && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if got := getReceiptCID(receipt.Receipt.ID); got != "bafytestcid" {
+		t.Errorf("background retry should record the CID, got %q", got)
+	}
+}
+
+func TestReceiptIPFS_Disabled(t *testing.T) {
+	h := testsupport.NewHarness(t, newTestRouter)
+	resp := h.Post(t, "/api/ai/summarize", `{"text":"no archive"}`, "0xsig", "nonce")
+	if resp.Header.Get("X-402-Receipt-CID") != "" {
+		t.Error("no CID header expected without RECEIPT_IPFS_API_URL")
+	}
+}
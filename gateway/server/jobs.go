@@ -0,0 +1,519 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"gateway/payments"
+	"gateway/receipts"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Job states.
+const (
+	jobQueued    = "queued"
+	jobRunning   = "running"
+	jobCompleted = "completed"
+	jobFailed    = "failed"
+)
+
+// JobRequest is the body of POST /api/ai/jobs.
+type JobRequest struct {
+	Text       string `json:"text"`
+	WebhookURL string `json:"webhook_url,omitempty"`
+	GenerationParams
+}
+
+// Job is the state of an asynchronous summarization as returned by
+// GET /api/ai/jobs/:id and posted to the webhook. The receipt's
+// response_hash covers the canonical result document {"result": ...},
+// encoded by receipts.EncodeResponse: the synchronous endpoint's body without
+// its receipt_id and correlation_id.
+type Job struct {
+	ID         string         `json:"id"`
+	Status     string         `json:"status"`
+	Model      string         `json:"model"`
+	Result     string         `json:"result,omitempty"`
+	Error      string         `json:"error,omitempty"`
+	Receipt    *SignedReceipt `json:"receipt,omitempty"`
+	ReceiptCID string         `json:"receipt_cid,omitempty"`
+	// RefundVoucher is set when a paid job failed upstream; see
+	// issueRefundVoucher.
+	RefundVoucher *payments.Voucher `json:"refund_voucher,omitempty"`
+	CreatedAt     time.Time         `json:"created_at"`
+	CompletedAt   *time.Time        `json:"completed_at,omitempty"`
+}
+
+// jobTask is everything a worker needs to run a paid job.
+type jobTask struct {
+	id          string
+	text        string
+	params      GenerationParams
+	webhookURL  string
+	endpoint    string
+	requestBody []byte
+	selection   ModelSelection
+	payment     PaymentContext
+	payer       string
+	// session is the X-402-Session the receipt is added to, if any.
+	session string
+	// sponsor paid for the job under a sponsor grant, if any.
+	sponsor *paymentSponsor
+	refund  func()
+}
+
+// jobEntry pairs a job with its expiry in the store.
+type jobEntry struct {
+	job       Job
+	expiresAt time.Time
+}
+
+// jobQueue runs paid jobs on a fixed pool of workers. Jobs are kept in memory
+// until JOB_TTL_SECONDS after they finish, like receipts.
+type jobQueue struct {
+	mu      sync.RWMutex
+	jobs    map[string]*jobEntry
+	tasks   chan *jobTask
+	ttl     time.Duration
+	timeout time.Duration
+	workers int
+
+	stopped bool
+	cancel  context.CancelFunc
+	wg      sync.WaitGroup
+}
+
+// newJobQueue builds a queue from JOB_* environment variables.
+func newJobQueue() *jobQueue {
+	return &jobQueue{
+		jobs:    make(map[string]*jobEntry),
+		tasks:   make(chan *jobTask, max(getEnvAsInt("JOB_QUEUE_SIZE", 100), 1)),
+		ttl:     getPositiveTimeout("JOB_TTL_SECONDS", 3600),
+		timeout: getPositiveTimeout("JOB_TIMEOUT_SECONDS", 300),
+		workers: max(getEnvAsInt("JOB_WORKERS", 4), 1),
+	}
+}
+
+// activeJobs is the running queue; nil until the lifecycle starts it.
+var (
+	activeJobsMu sync.RWMutex
+	activeJobs   *jobQueue
+)
+
+func getJobQueue() *jobQueue {
+	activeJobsMu.RLock()
+	defer activeJobsMu.RUnlock()
+	return activeJobs
+}
+
+// Start launches the workers and the expiry sweep.
+func (q *jobQueue) Start() {
+	ctx, cancel := context.WithCancel(context.Background())
+	q.cancel = cancel
+	for i := 0; i < q.workers; i++ {
+		q.wg.Add(1)
+		go func() {
+			defer q.wg.Done()
+			for task := range q.tasks {
+				q.run(ctx, task)
+			}
+		}()
+	}
+	go func() {
+		ticker := time.NewTicker(time.Minute)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				q.cleanup()
+			}
+		}
+	}()
+}
+
+// Stop stops accepting jobs and lets the workers finish the queue until ctx
+// expires. Jobs still running or queued after that are cancelled, failed and
+// refunded.
+func (q *jobQueue) Stop(ctx context.Context) {
+	q.mu.Lock()
+	if q.stopped {
+		q.mu.Unlock()
+		return
+	}
+	q.stopped = true
+	close(q.tasks)
+	q.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		q.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-ctx.Done():
+		log.Println("[WARNING] Job queue did not drain before shutdown, cancelling remaining jobs")
+	}
+	q.cancel()
+	<-done
+}
+
+// Enqueue stores a queued job and hands it to the workers. It returns false
+// when the queue is full or stopped.
+func (q *jobQueue) Enqueue(task *jobTask) (Job, bool) {
+	job := Job{ID: task.id, Status: jobQueued, Model: task.selection.Model, CreatedAt: time.Now().UTC()}
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.stopped {
+		return Job{}, false
+	}
+	select {
+	case q.tasks <- task:
+		q.jobs[job.ID] = &jobEntry{job: job, expiresAt: time.Now().Add(q.ttl + q.timeout)}
+		return job, true
+	default:
+		return Job{}, false
+	}
+}
+
+// Get returns a copy of the job with id.
+func (q *jobQueue) Get(id string) (Job, bool) {
+	q.mu.RLock()
+	defer q.mu.RUnlock()
+	entry, ok := q.jobs[id]
+	if !ok || time.Now().After(entry.expiresAt) {
+		return Job{}, false
+	}
+	return entry.job, true
+}
+
+func (q *jobQueue) update(id string, fn func(*Job)) Job {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	entry, ok := q.jobs[id]
+	if !ok {
+		return Job{}
+	}
+	fn(&entry.job)
+	if entry.job.Status == jobCompleted || entry.job.Status == jobFailed {
+		now := time.Now().UTC()
+		entry.job.CompletedAt = &now
+		entry.expiresAt = now.Add(q.ttl)
+	}
+	return entry.job
+}
+
+// run calls the provider for task and records the outcome.
+func (q *jobQueue) run(ctx context.Context, task *jobTask) {
+	q.update(task.id, func(j *Job) { j.Status = jobRunning })
+
+	ctx, cancel := context.WithTimeout(ctx, q.timeout)
+	defer cancel()
+	res, err := summarizeShared(ctx, task.selection.Model, task.text, task.params)
+	if err != nil {
+		log.Printf("Job %s failed: %v", task.id, err)
+		message := "AI Service Failed"
+		if errors.Is(err, context.DeadlineExceeded) {
+			message = "AI request timed out"
+		}
+		q.fail(task, message, issueRefundVoucher(ctx, task.payment, task.payer))
+		return
+	}
+
+	task.selection = task.selection.servedBy(res)
+	responseBody, _ := receipts.EncodeResponse(SummaryResponse{Result: res.Summary})
+	receipt, err := issueReceipt(ctx, task.payment, task.payer, task.endpoint, task.requestBody, responseBody, task.selection, task.params, task.sponsor.receiptOptions()...)
+	if err != nil {
+		log.Printf("Job %s: failed to generate receipt: %v", task.id, err)
+		q.fail(task, "Failed to generate receipt", issueRefundVoucher(ctx, task.payment, task.payer))
+		return
+	}
+	cid, err := persistReceipt(ctx, receipt)
+	if err != nil {
+		log.Printf("Job %s: %v", task.id, err)
+		q.fail(task, "Failed to store receipt", issueRefundVoucher(ctx, task.payment, task.payer))
+		return
+	}
+	retainPayloads(ctx, receipt, task.requestBody, responseBody)
+	recordMarginFor(task.endpoint, task.selection.Model, receipt.Receipt.ID, task.payment, task.payer, res.Usage)
+	if task.session != "" {
+		addSessionCall(task.session, receipt)
+	}
+
+	job := q.update(task.id, func(j *Job) {
+		j.Status = jobCompleted
+		j.Result = res.Summary
+		j.Receipt = receipt
+		j.ReceiptCID = cid
+	})
+	q.notify(task.webhookURL, job)
+}
+
+// fail marks task failed, refunds its reserved spend and notifies the
+// webhook. voucher, if not nil, is returned with the job.
+func (q *jobQueue) fail(task *jobTask, message string, voucher *payments.Voucher) {
+	task.refund()
+	job := q.update(task.id, func(j *Job) {
+		j.Status = jobFailed
+		j.Error = message
+		j.RefundVoucher = voucher
+	})
+	q.notify(task.webhookURL, job)
+}
+
+// webhookRetryDelay is the wait before the first webhook retry; it doubles
+// on each of the following attempts.
+var webhookRetryDelay = time.Second
+
+// notify POSTs the finished job to webhookURL, making up to three attempts
+// when delivery fails or the receiver answers non-2xx. With the outbox
+// enabled the webhook is written to it instead and retried from there.
+func (q *jobQueue) notify(webhookURL string, job Job) {
+	if webhookURL == "" || job.ID == "" {
+		return
+	}
+	if o := activeOutbox.Load(); o != nil {
+		err := enqueueJobWebhook(context.Background(), o, webhookURL, job)
+		if err == nil {
+			return
+		}
+		log.Printf("[WARNING] Failed to write webhook for job %s to the outbox, delivering directly: %v", job.ID, err)
+	}
+	body, err := json.Marshal(job)
+	if err != nil {
+		return
+	}
+	delay := webhookRetryDelay
+	for attempt := 1; attempt <= 3; attempt++ {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		req, _ := http.NewRequestWithContext(ctx, http.MethodPost, webhookURL, bytes.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("X-Paygate-Job-ID", job.ID)
+		resp, err := webhookHTTPClient().Do(req)
+		if err == nil {
+			io.Copy(io.Discard, resp.Body)
+			resp.Body.Close()
+		}
+		cancel()
+		if err == nil && resp.StatusCode >= 200 && resp.StatusCode < 300 {
+			return
+		}
+		if attempt < 3 {
+			time.Sleep(delay)
+			delay *= 2
+		}
+	}
+	log.Printf("[WARNING] Webhook delivery for job %s failed after 3 attempts", job.ID)
+}
+
+// cleanup removes expired jobs.
+func (q *jobQueue) cleanup() {
+	now := time.Now()
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for id, entry := range q.jobs {
+		if now.After(entry.expiresAt) {
+			delete(q.jobs, id)
+		}
+	}
+}
+
+// issueReceipt generates, signs and stores a receipt outside a request, for
+// responses that are delivered later. opts add further details.
+func issueReceipt(ctx context.Context, payment PaymentContext, payer, endpoint string, requestBody, responseBody []byte, sel ModelSelection, params GenerationParams, opts ...receipts.Option) (*SignedReceipt, error) {
+	seq, err := nextReceiptSequence(ctx, payer)
+	if err != nil {
+		return nil, err
+	}
+	opts = append([]receipts.Option{receipts.WithSequence(seq), receipts.WithModel(sel.Model, sel.SubstitutedFor), receipts.WithProvider(sel.Provider), receipts.WithParameters(params)}, opts...)
+	receipt, err := GenerateReceipt(payment, payer, endpoint, requestBody, responseBody, opts...)
+	if err != nil {
+		return nil, err
+	}
+	if err := storeReceipt(receipt, getReceiptTTL()); err != nil {
+		return nil, err
+	}
+	return receipt, nil
+}
+
+// validateWebhookURL accepts absolute https URLs, or http when
+// JOB_WEBHOOK_ALLOW_HTTP is set (for local development). Hosts that are
+// obviously internal are refused up front unless JOB_WEBHOOK_ALLOW_PRIVATE
+// is set; webhookHTTPClient checks the resolved address of every delivery.
+func validateWebhookURL(raw string) error {
+	u, err := url.Parse(raw)
+	if err != nil || u.Host == "" {
+		return fmt.Errorf("webhook_url must be an absolute URL")
+	}
+	if !getEnvAsBool("JOB_WEBHOOK_ALLOW_PRIVATE", false) {
+		host := u.Hostname()
+		if ip := net.ParseIP(host); strings.EqualFold(host, "localhost") || (ip != nil && !isPublicIP(ip)) {
+			return fmt.Errorf("webhook_url must not point to a private address")
+		}
+	}
+	switch u.Scheme {
+	case "https":
+		return nil
+	case "http":
+		if getEnvAsBool("JOB_WEBHOOK_ALLOW_HTTP", false) {
+			return nil
+		}
+	}
+	return fmt.Errorf("webhook_url must use https")
+}
+
+// newJobID returns a random 128-bit job ID.
+func newJobID() string {
+	b := make([]byte, 16)
+	_, _ = rand.Read(b)
+	return "job_" + hex.EncodeToString(b)
+}
+
+// handleCreateJob handles POST /api/ai/jobs. Payment works as for
+// /api/ai/summarize, but the request is answered with 202 and a job ID as
+// soon as it is paid; the summary is produced by a background worker with
+// JOB_TIMEOUT_SECONDS instead of the synchronous AI timeout.
+func handleCreateJob(c *gin.Context) {
+	signature := c.GetHeader("X-402-Signature")
+	nonce := c.GetHeader("X-402-Nonce")
+	if signature == "" || nonce == "" {
+		quote := quotePrice(c)
+		respondPaymentRequired(c, quote.Price, newAPIError(CodePaymentRequired, "").with(gin.H{"quote": quote}))
+		return
+	}
+
+	queue := getJobQueue()
+	if queue == nil {
+		respondError(c, CodeServiceUnavailable, "Job processing is not running")
+		return
+	}
+
+	const maxBodySize = 10 * 1024 * 1024
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, int64(maxBodySize))
+	requestBody, err := io.ReadAll(c.Request.Body)
+	if err != nil {
+		var maxBytesErr *http.MaxBytesError
+		if errors.As(err, &maxBytesErr) {
+			respondAPIError(c, errPayloadTooLarge())
+		} else {
+			respondError(c, CodeBodyReadFailed, "The request body could not be read")
+		}
+		return
+	}
+	var req JobRequest
+	if err := json.Unmarshal(requestBody, &req); err != nil {
+		respondError(c, CodeInvalidBody, "Request must be valid JSON")
+		return
+	}
+	if req.Text == "" {
+		respondError(c, CodeInvalidRequest, "text field cannot be empty")
+		return
+	}
+	if req.WebhookURL != "" {
+		if err := validateWebhookURL(req.WebhookURL); err != nil {
+			respondError(c, CodeInvalidRequest, err.Error())
+			return
+		}
+	}
+	if !screenContent(c, req.Text) {
+		return
+	}
+	req.Text = sanitizeInput(c, req.Text)
+
+	selectModelForText(c, req.Text)
+	setGenerationParams(c, req.GenerationParams)
+	sel, ok := shapeSummary(c)
+	if !ok {
+		return
+	}
+	params, ok := localizeSummary(c, req.Text)
+	if !ok {
+		return
+	}
+	if !checkContextWindow(c, sel.Model, req.Text, params) {
+		return
+	}
+	if cfg := getConfig(); !providerCircuit.Allow(cfg) {
+		rejectProviderOutage(c, cfg)
+		return
+	}
+
+	verifyResp, paymentCtx, ok := authorizePayment(c, signature, nonce, sel.Price)
+	if !ok {
+		return
+	}
+
+	refundSpend, ok := reserveSpend(c, verifyResp.RecoveredAddress, sel.Price)
+	if !ok {
+		return
+	}
+
+	if original, ok := c.Get("receipt_request_body"); ok {
+		// Hash the body as sent, not as translated by apiCompatMiddleware.
+		requestBody = original.([]byte)
+	}
+	job, ok := queue.Enqueue(&jobTask{
+		id:          newJobID(),
+		text:        req.Text,
+		params:      params,
+		webhookURL:  req.WebhookURL,
+		endpoint:    c.Request.URL.Path,
+		requestBody: requestBody,
+		selection:   sel,
+		payment:     *paymentCtx,
+		payer:       verifyResp.RecoveredAddress,
+		session:     c.GetHeader("X-402-Session"),
+		sponsor:     requestSponsor(c),
+		refund:      refundSpend,
+	})
+	if !ok {
+		// The nonce is spent, so the client retries with the voucher.
+		refundSpend()
+		c.Header("Retry-After", "5")
+		respondWithRefund(c, newAPIError(CodeServiceUnavailable, "Job queue is full, please retry later"), *paymentCtx, verifyResp.RecoveredAddress)
+		return
+	}
+
+	statusURL := strings.TrimSuffix(c.Request.URL.Path, "/") + "/" + job.ID
+	c.Header("Location", statusURL)
+	setPriceHeader(c, sel.Price)
+	c.JSON(202, gin.H{"id": job.ID, "status": job.Status, "model": job.Model, "status_url": statusURL})
+}
+
+// handleGetJob handles GET /api/ai/jobs/:id. Job IDs are random 128-bit
+// values, so knowing one is the only access control, as for receipts.
+func handleGetJob(c *gin.Context) {
+	queue := getJobQueue()
+	if queue == nil {
+		respondError(c, CodeNotFound, "Job not found or expired")
+		return
+	}
+	job, ok := queue.Get(c.Param("id"))
+	if !ok {
+		respondError(c, CodeNotFound, "Job not found or expired")
+		return
+	}
+	body, err := json.Marshal(job)
+	if err != nil {
+		respondError(c, CodeInternal, "Failed to encode job")
+		return
+	}
+	signResponse(c, body)
+	c.Data(200, "application/json; charset=utf-8", body)
+}
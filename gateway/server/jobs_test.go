@@ -0,0 +1,215 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"gateway/internal/testsupport"
+	"gateway/payments"
+	"gateway/receipts"
+)
+
+// withJobQueue starts a job queue for the test and stops it afterwards.
+func withJobQueue(t *testing.T) *jobQueue {
+	t.Helper()
+	queue := newJobQueue()
+	queue.Start()
+	activeJobsMu.Lock()
+	prev := activeJobs
+	activeJobs = queue
+	activeJobsMu.Unlock()
+	t.Cleanup(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		queue.Stop(ctx)
+		activeJobsMu.Lock()
+		activeJobs = prev
+		activeJobsMu.Unlock()
+	})
+	return queue
+}
+
+// waitForJob polls the job until it finishes.
+func waitForJob(t *testing.T, h *testsupport.Harness, statusURL string) Job {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		resp := h.Get(t, statusURL)
+		var job Job
+		if err := json.NewDecoder(resp.Body).Decode(&job); err != nil {
+			t.Fatalf("decode job: %v", err)
+		}
+		if job.Status == jobCompleted || job.Status == jobFailed {
+			return job
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	t.Fatal("job did not finish in time")
+	return Job{}
+}
+
+func TestJobs_CompletesWithReceiptAndWebhook(t *testing.T) {
+	withJobQueue(t)
+	t.Setenv("JOB_WEBHOOK_ALLOW_HTTP", "true")
+	t.Setenv("JOB_WEBHOOK_ALLOW_PRIVATE", "true")
+	h := testsupport.NewHarness(t, newTestRouter)
+	h.AI.SetReply("Async summary")
+
+	delivered := make(chan Job, 1)
+	hook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var job Job
+		_ = json.NewDecoder(r.Body).Decode(&job)
+		delivered <- job
+	}))
+	defer hook.Close()
+
+	body := `{"text":"a very long document","webhook_url":"` + hook.URL + `"}`
+	resp := h.Post(t, "/api/ai/jobs", body, "0xsig", "nonce")
+	if resp.StatusCode != http.StatusAccepted {
+		data, _ := io.ReadAll(resp.Body)
+		t.Fatalf("expected 202, got %d: %s", resp.StatusCode, data)
+	}
+	var accepted struct {
+		ID        string `json:"id"`
+		Status    string `json:"status"`
+		StatusURL string `json:"status_url"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&accepted); err != nil {
+		t.Fatal(err)
+	}
+	if accepted.Status != jobQueued || accepted.StatusURL != "/api/ai/jobs/"+accepted.ID {
+		t.Fatalf("unexpected 202 body %+v", accepted)
+	}
+
+	job := waitForJob(t, h, accepted.StatusURL)
+	if job.Status != jobCompleted || job.Result != "Async summary" || job.CompletedAt == nil {
+		t.Fatalf("unexpected job %+v", job)
+	}
+	if job.Receipt == nil {
+		t.Fatal("completed job has no receipt")
+	}
+	if err := receipts.Verify(job.Receipt, nil); err != nil {
+		t.Fatalf("receipt does not verify: %v", err)
+	}
+	canonical, _ := json.Marshal(map[string]string{"result": job.Result})
+	if job.Receipt.Receipt.Service.ResponseHash != receipts.HashData(canonical) {
+		t.Error("receipt should hash the canonical result document")
+	}
+	if job.Receipt.Receipt.Service.RequestHash != receipts.HashData([]byte(body)) {
+		t.Error("receipt should hash the submitted body")
+	}
+
+	select {
+	case got := <-delivered:
+		if got.ID != accepted.ID || got.Status != jobCompleted {
+			t.Errorf("unexpected webhook payload %+v", got)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("webhook was not delivered")
+	}
+}
+
+func TestJobs_ProviderFailureMarksJobFailed(t *testing.T) {
+	withJobQueue(t)
+	withCircuit(t)
+	h := testsupport.NewHarness(t, newTestRouter)
+	h.AI.SetStatus(http.StatusInternalServerError)
+
+	resp := h.Post(t, "/api/ai/jobs", `{"text":"hello"}`, "0xsig", "nonce")
+	if resp.StatusCode != http.StatusAccepted {
+		t.Fatalf("expected 202, got %d", resp.StatusCode)
+	}
+	job := waitForJob(t, h, resp.Header.Get("Location"))
+	if job.Status != jobFailed || job.Error == "" || job.Receipt != nil {
+		t.Errorf("expected failed job without receipt, got %+v", job)
+	}
+	if job.RefundVoucher == nil || job.RefundVoucher.Nonce != "nonce" || job.RefundVoucher.Payer != testsupport.DefaultPayer {
+		t.Errorf("expected a refund voucher for the failed job's payment, got %+v", job.RefundVoucher)
+	}
+}
+
+func TestJobs_RequestValidation(t *testing.T) {
+	withJobQueue(t)
+	h := testsupport.NewHarness(t, newTestRouter)
+
+	if resp := h.Post(t, "/api/ai/jobs", `{"text":"hello"}`, "", ""); resp.StatusCode != http.StatusPaymentRequired {
+		t.Errorf("expected 402 without payment, got %d", resp.StatusCode)
+	}
+	if resp := h.Post(t, "/api/ai/jobs", `{"text":""}`, "0xsig", "nonce"); resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("expected 400 for empty text, got %d", resp.StatusCode)
+	}
+	if resp := h.Post(t, "/api/ai/jobs", `{"text":"hi","webhook_url":"http://example.com/hook"}`, "0xsig", "nonce"); resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("expected 400 for plain-http webhook, got %d", resp.StatusCode)
+	}
+	for _, hook := range []string{"https://127.0.0.1/hook", "https://169.254.169.254/latest", "https://localhost:8080/hook", "https://[::1]/hook"} {
+		if resp := h.Post(t, "/api/ai/jobs", `{"text":"hi","webhook_url":"`+hook+`"}`, "0xsig", "nonce"); resp.StatusCode != http.StatusBadRequest {
+			t.Errorf("expected 400 for private webhook %s, got %d", hook, resp.StatusCode)
+		}
+	}
+	if h.Verifier.Calls() != 0 {
+		t.Error("invalid requests should be rejected before verification")
+	}
+	if resp := h.Get(t, "/api/ai/jobs/job_missing"); resp.StatusCode != http.StatusNotFound {
+		t.Errorf("expected 404 for unknown job, got %d", resp.StatusCode)
+	}
+}
+
+func TestJobs_UnavailableWithoutWorkers(t *testing.T) {
+	h := testsupport.NewHarness(t, newTestRouter)
+	if resp := h.Post(t, "/api/ai/jobs", `{"text":"hello"}`, "0xsig", "nonce"); resp.StatusCode != http.StatusServiceUnavailable {
+		t.Errorf("expected 503 when no job queue is running, got %d", resp.StatusCode)
+	}
+}
+
+func TestJobs_FullQueueRefundsPayment(t *testing.T) {
+	t.Setenv("JOB_QUEUE_SIZE", "1")
+	queue := newJobQueue() // not started, so nothing drains the queue
+	activeJobsMu.Lock()
+	prev := activeJobs
+	activeJobs = queue
+	activeJobsMu.Unlock()
+	t.Cleanup(func() {
+		activeJobsMu.Lock()
+		activeJobs = prev
+		activeJobsMu.Unlock()
+	})
+	if _, ok := queue.Enqueue(&jobTask{id: "job_1", refund: func() {}}); !ok {
+		t.Fatal("first job should be queued")
+	}
+	h := testsupport.NewHarness(t, newTestRouter)
+
+	resp := h.Post(t, "/api/ai/jobs", `{"text":"hello"}`, "0xsig", "nonce-full")
+	if resp.StatusCode != http.StatusServiceUnavailable {
+		t.Fatalf("expected 503, got %d", resp.StatusCode)
+	}
+	var body struct {
+		RefundVoucher *payments.Voucher `json:"refund_voucher"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		t.Fatal(err)
+	}
+	if body.RefundVoucher == nil || body.RefundVoucher.Nonce != "nonce-full" || body.RefundVoucher.Payer != testsupport.DefaultPayer {
+		t.Errorf("expected a refund voucher for the rejected job's payment, got %+v", body.RefundVoucher)
+	}
+}
+
+func TestJobQueue_RejectsWhenFull(t *testing.T) {
+	t.Setenv("JOB_QUEUE_SIZE", "1")
+	q := newJobQueue() // not started, so nothing drains the queue
+	task := func(id string) *jobTask { return &jobTask{id: id, refund: func() {}} }
+
+	if _, ok := q.Enqueue(task("job_1")); !ok {
+		t.Fatal("first job should be queued")
+	}
+	if _, ok := q.Enqueue(task("job_2")); ok {
+		t.Fatal("second job should be rejected when the queue is full")
+	}
+	if _, ok := q.Get("job_2"); ok {
+		t.Error("rejected job should not be stored")
+	}
+}
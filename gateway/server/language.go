@@ -0,0 +1,208 @@
+package server
+
+import (
+	"strings"
+	"unicode"
+
+	"github.com/gin-gonic/gin"
+)
+
+// languageNames maps the ISO 639-1 codes output_language accepts to the
+// English names used in the prompt.
+var languageNames = map[string]string{
+	"ar": "Arabic", "cs": "Czech", "da": "Danish", "de": "German", "el": "Greek",
+	"en": "English", "es": "Spanish", "fi": "Finnish", "fr": "French", "he": "Hebrew",
+	"hi": "Hindi", "id": "Indonesian", "it": "Italian", "ja": "Japanese", "ko": "Korean",
+	"nl": "Dutch", "no": "Norwegian", "pl": "Polish", "pt": "Portuguese", "ru": "Russian",
+	"sv": "Swedish", "th": "Thai", "tr": "Turkish", "uk": "Ukrainian", "vi": "Vietnamese",
+	"zh": "Chinese",
+}
+
+// normalizeLanguage returns the code for a supported language given as a
+// code, a tag with a region such as "pt-BR", or an English name.
+func normalizeLanguage(s string) (string, bool) {
+	s = strings.ToLower(strings.TrimSpace(s))
+	if base, _, ok := strings.Cut(strings.ReplaceAll(s, "_", "-"), "-"); ok {
+		s = base
+	}
+	if _, ok := languageNames[s]; ok {
+		return s, true
+	}
+	for code, name := range languageNames {
+		if strings.EqualFold(name, s) {
+			return code, true
+		}
+	}
+	return "", false
+}
+
+// detectSampleRunes is how much of the input detectLanguage examines.
+const detectSampleRunes = 2000
+
+// minLanguageHits is how many common words of a Latin-script language the
+// sample must contain for it to be detected.
+const minLanguageHits = 3
+
+// commonWords lists frequent function words of the Latin-script languages
+// detectLanguage recognizes.
+var commonWords = map[string][]string{
+	"en": {"the", "and", "of", "to", "is", "in", "that", "it", "was", "for", "with", "are", "this", "on", "be", "have", "not", "they", "which", "from", "were", "has", "by"},
+	"es": {"el", "la", "los", "las", "que", "de", "y", "en", "es", "por", "un", "una", "con", "para", "del", "se", "no", "su", "al", "como", "más", "pero", "fue", "está"},
+	"fr": {"le", "la", "les", "des", "et", "est", "que", "une", "un", "du", "en", "dans", "pour", "pas", "qui", "sur", "au", "avec", "ce", "il", "sont", "nous", "été", "mais"},
+	"de": {"der", "die", "das", "und", "ist", "nicht", "ein", "eine", "zu", "den", "mit", "von", "sich", "auf", "für", "dem", "des", "im", "auch", "es", "wird", "wurde", "sind"},
+	"it": {"il", "la", "che", "di", "e", "è", "un", "una", "per", "non", "con", "del", "della", "sono", "gli", "le", "nel", "anche", "si", "da", "ma", "alla", "come"},
+	"pt": {"o", "a", "os", "as", "que", "de", "e", "é", "um", "uma", "não", "para", "com", "do", "da", "em", "no", "na", "se", "por", "mais", "foi", "são", "dos"},
+	"nl": {"de", "het", "een", "en", "van", "is", "dat", "niet", "in", "op", "te", "zijn", "met", "voor", "er", "die", "maar", "ook", "wordt", "werd", "bij", "aan"},
+	"sv": {"och", "att", "det", "som", "en", "är", "av", "för", "på", "med", "inte", "till", "den", "har", "de", "om", "ett", "var", "jag", "men", "sig"},
+	"pl": {"i", "w", "na", "z", "się", "nie", "do", "to", "jest", "że", "jak", "o", "co", "ale", "po", "od", "przez", "są", "dla", "był", "tak"},
+	"tr": {"ve", "bir", "bu", "da", "de", "için", "ile", "çok", "daha", "olarak", "gibi", "ama", "olan", "ne", "değil", "var", "en", "kadar", "sonra"},
+	"id": {"yang", "dan", "di", "ini", "itu", "dengan", "untuk", "tidak", "dari", "dalam", "akan", "pada", "adalah", "ke", "juga", "ada", "saya", "kami", "oleh"},
+	"vi": {"và", "của", "là", "có", "không", "những", "được", "cho", "các", "một", "trong", "người", "với", "này", "đã", "khi", "để"},
+}
+
+// commonWordLanguages is commonWords indexed by word.
+var commonWordLanguages = func() map[string][]string {
+	index := make(map[string][]string)
+	for lang, words := range commonWords {
+		for _, w := range words {
+			index[w] = append(index[w], lang)
+		}
+	}
+	return index
+}()
+
+// scriptLanguages maps the non-Latin scripts detectLanguage recognizes to
+// their language. Han is Chinese unless kana show the text is Japanese,
+// and Cyrillic is Russian unless Ukrainian letters appear.
+var scriptLanguages = []struct {
+	table *unicode.RangeTable
+	lang  string
+}{
+	{unicode.Han, "zh"},
+	{unicode.Hiragana, "ja"},
+	{unicode.Katakana, "ja"},
+	{unicode.Hangul, "ko"},
+	{unicode.Cyrillic, "ru"},
+	{unicode.Greek, "el"},
+	{unicode.Arabic, "ar"},
+	{unicode.Hebrew, "he"},
+	{unicode.Devanagari, "hi"},
+	{unicode.Thai, "th"},
+}
+
+// detectLanguage returns the ISO 639-1 code of the language text is
+// written in, or "" when it cannot tell. Non-Latin scripts are recognized
+// by their letters and Latin-script languages by their most common words.
+// Only the first detectSampleRunes runes are examined.
+func detectLanguage(text string) string {
+	counts := make(map[string]int)
+	var latin, kana int
+	var ukrainian bool
+	var words []string
+	var word strings.Builder
+	endWord := func() {
+		if word.Len() > 0 {
+			words = append(words, word.String())
+			word.Reset()
+		}
+	}
+
+	n := 0
+	for _, r := range text {
+		if n++; n > detectSampleRunes {
+			break
+		}
+		if !unicode.IsLetter(r) && !unicode.Is(unicode.Mn, r) {
+			endWord()
+			continue
+		}
+		word.WriteRune(unicode.ToLower(r))
+		if unicode.Is(unicode.Latin, r) {
+			latin++
+			continue
+		}
+		for _, s := range scriptLanguages {
+			if unicode.Is(s.table, r) {
+				counts[s.lang]++
+				break
+			}
+		}
+		switch {
+		case unicode.In(r, unicode.Hiragana, unicode.Katakana):
+			kana++
+		case strings.ContainsRune("іїєґІЇЄҐ", r):
+			ukrainian = true
+		}
+	}
+	endWord()
+
+	// A non-Latin script that makes up most of the letters decides.
+	best, bestCount, other := "", 0, latin
+	for lang, count := range counts {
+		other += count
+		if count > bestCount || (count == bestCount && lang < best) {
+			best, bestCount = lang, count
+		}
+	}
+	if best != "" && bestCount*2 > other {
+		switch {
+		case best == "zh" && kana > 0:
+			return "ja"
+		case best == "ru" && ukrainian:
+			return "uk"
+		}
+		return best
+	}
+	if latin == 0 {
+		return ""
+	}
+
+	hits := make(map[string]int)
+	for _, w := range words {
+		for _, lang := range commonWordLanguages[w] {
+			hits[lang]++
+		}
+	}
+	best, bestHits, secondHits := "", 0, 0
+	for lang, count := range hits {
+		switch {
+		case count > bestHits || (count == bestHits && lang < best):
+			best, bestHits, secondHits = lang, count, max(bestHits, secondHits)
+		case count > secondHits:
+			secondHits = count
+		}
+	}
+	if bestHits < minLanguageHits || bestHits == secondHits {
+		return ""
+	}
+	return best
+}
+
+// localizeSummary decides the language the summary of text is written in:
+// the requested output_language or, with LANGUAGE_DETECTION_ENABLED, the
+// detected language of text. The choice is stored with the generation
+// parameters, so it reaches the prompt, cache key and receipt, and sent as
+// Content-Language. Later calls return the stored parameters. An
+// unsupported output_language aborts with 400.
+func localizeSummary(c *gin.Context, text string) (GenerationParams, bool) {
+	params := getGenerationParams(c)
+	if c.GetBool("language_resolved") {
+		return params, true
+	}
+	if params.OutputLanguage != "" {
+		code, ok := normalizeLanguage(params.OutputLanguage)
+		if !ok {
+			abortWithError(c, CodeInvalidRequest, "output_language must be an ISO 639-1 code or English name of a supported language, such as es or Spanish")
+			return params, false
+		}
+		params.OutputLanguage = code
+	} else if getConfig().LanguageDetection {
+		params.OutputLanguage = detectLanguage(text)
+	}
+	c.Set("generation_params", params)
+	c.Set("language_resolved", true)
+	if params.OutputLanguage != "" {
+		c.Header("Content-Language", params.OutputLanguage)
+	}
+	return params, true
+}
@@ -0,0 +1,108 @@
+package server
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+
+	"gateway/internal/testsupport"
+)
+
+const spanishText = "El gobierno anunció que la nueva ley entrará en vigor el próximo mes, pero los críticos dicen que no es suficiente para resolver el problema de la vivienda."
+
+func TestDetectLanguage(t *testing.T) {
+	cases := map[string]string{
+		"The committee said that it was not ready to publish the report, which has been delayed for months.": "en",
+		spanishText: "es",
+		"Le gouvernement a annoncé que la réforme sera présentée dans les prochains jours, mais les syndicats ne sont pas convaincus.":        "fr",
+		"Die Regierung hat angekündigt, dass das neue Gesetz im nächsten Monat in Kraft treten wird, und die Opposition ist nicht zufrieden.": "de",
+		"Правительство объявило, что новый закон вступит в силу в следующем месяце.":                                                          "ru",
+		"Уряд оголосив, що новий закон набуде чинності наступного місяця і всі його підтримують.":                                             "uk",
+		"政府は来月から新しい法律が施行されると発表した。":                                                                                                            "ja",
+		"政府宣布新法律将于下个月生效。":               "zh",
+		"정부는 새로운 법이 다음 달부터 시행된다고 발표했다.": "ko",
+		"hello":   "",
+		"12345 !": "",
+	}
+	for text, want := range cases {
+		if got := detectLanguage(text); got != want {
+			t.Errorf("detectLanguage(%.30q) = %q, want %q", text, got, want)
+		}
+	}
+}
+
+func TestNormalizeLanguage(t *testing.T) {
+	for in, want := range map[string]string{"es": "es", "pt-BR": "pt", "zh_Hans": "zh", "German": "de", " FR ": "fr"} {
+		if got, ok := normalizeLanguage(in); !ok || got != want {
+			t.Errorf("normalizeLanguage(%q) = %q, %v, want %q", in, got, ok, want)
+		}
+	}
+	if _, ok := normalizeLanguage("klingon"); ok {
+		t.Error("expected an unsupported language to be refused")
+	}
+}
+
+func TestSummarize_InDetectedLanguage(t *testing.T) {
+	h := testsupport.NewHarness(t, newTestRouter)
+
+	resp := h.Post(t, "/api/ai/summarize", `{"text":"`+spanishText+`"}`, "0xsig", "nonce-lang-es")
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected 200, got %d", resp.StatusCode)
+	}
+	if prompt := lastPrompt(t, h); !strings.Contains(prompt, "writing the summary in Spanish") {
+		t.Errorf("expected the prompt to ask for Spanish, got %q", prompt)
+	}
+	if got := resp.Header.Get("Content-Language"); got != "es" {
+		t.Errorf("expected Content-Language es, got %q", got)
+	}
+	params := decodeReceiptHeader(t, resp.Header.Get("X-402-Receipt")).Receipt.Service.Parameters
+	if params == nil || params.OutputLanguage != "es" {
+		t.Errorf("expected the language in the receipt, got %+v", params)
+	}
+
+	// Text too short to tell keeps the original prompt.
+	h.Post(t, "/api/ai/summarize", `{"text":"hello"}`, "0xsig", "nonce-lang-short")
+	if prompt := lastPrompt(t, h); prompt != summarizePrompt("hello", GenerationParams{}) {
+		t.Errorf("expected the plain prompt, got %q", prompt)
+	}
+}
+
+func TestSummarize_RequestedLanguage(t *testing.T) {
+	t.Setenv("LANGUAGE_DETECTION_ENABLED", "false")
+	h := testsupport.NewHarness(t, newTestRouter)
+
+	h.Post(t, "/api/ai/summarize", `{"text":"`+spanishText+`"}`, "0xsig", "nonce-lang-off")
+	if prompt := lastPrompt(t, h); strings.Contains(prompt, "writing the summary in") {
+		t.Errorf("expected no language instruction with detection off, got %q", prompt)
+	}
+
+	resp := h.Post(t, "/api/ai/summarize", `{"text":"`+spanishText+`","output_language":"German"}`, "0xsig", "nonce-lang-de")
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected 200, got %d", resp.StatusCode)
+	}
+	if prompt := lastPrompt(t, h); !strings.Contains(prompt, "writing the summary in German") {
+		t.Errorf("expected the prompt to ask for German, got %q", prompt)
+	}
+	if params := decodeReceiptHeader(t, resp.Header.Get("X-402-Receipt")).Receipt.Service.Parameters; params == nil || params.OutputLanguage != "de" {
+		t.Errorf("expected the requested language in the receipt, got %+v", params)
+	}
+
+	calls := h.AI.Calls()
+	resp = h.Post(t, "/api/ai/summarize", `{"text":"hello","output_language":"klingon"}`, "0xsig", "nonce-lang-bad")
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("expected 400 for an unsupported language, got %d", resp.StatusCode)
+	}
+	if h.AI.Calls() != calls {
+		t.Error("expected no provider call for an unsupported language")
+	}
+}
+
+func TestCacheKey_IncludesOutputLanguage(t *testing.T) {
+	model := "z-ai/glm-4.5-air:free"
+	plain := getCacheKey("hello", model, GenerationParams{})
+	es := getCacheKey("hello", model, GenerationParams{OutputLanguage: "es"})
+	de := getCacheKey("hello", model, GenerationParams{OutputLanguage: "de"})
+	if plain == es || es == de {
+		t.Error("expected different output languages to produce different cache keys")
+	}
+}
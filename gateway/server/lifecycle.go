@@ -0,0 +1,111 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+)
+
+// defaultHookTimeout bounds a hook that does not set its own timeout.
+const defaultHookTimeout = 10 * time.Second
+
+// LifecycleHook describes how a subsystem starts and stops. Either function
+// may be nil. Timeout bounds each call; zero means defaultHookTimeout.
+type LifecycleHook struct {
+	Name    string
+	Start   func(ctx context.Context) error
+	Stop    func(ctx context.Context) error
+	Timeout time.Duration
+}
+
+// Lifecycle runs registered hooks in registration order on Start and in
+// reverse order on Stop, so a subsystem is always stopped before anything it
+// depends on. Subsystems register once in main and get correct startup and
+// shutdown ordering without hand-maintained defer chains.
+type Lifecycle struct {
+	mu      sync.Mutex
+	hooks   []LifecycleHook
+	started int
+}
+
+// NewLifecycle returns an empty lifecycle manager.
+func NewLifecycle() *Lifecycle {
+	return &Lifecycle{}
+}
+
+// Register appends a hook. Hooks must be registered before Start.
+func (l *Lifecycle) Register(hook LifecycleHook) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.hooks = append(l.hooks, hook)
+}
+
+// Start runs every Start hook in order. If one fails, the hooks that already
+// started are stopped in reverse order and the original error is returned.
+func (l *Lifecycle) Start(ctx context.Context) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	for i, hook := range l.hooks {
+		if err := runHook(ctx, hook, hook.Start); err != nil {
+			l.started = i
+			stopErr := l.stopLocked(ctx)
+			return errors.Join(fmt.Errorf("start %s: %w", hook.Name, err), stopErr)
+		}
+		log.Printf("[lifecycle] started %s", hook.Name)
+	}
+	l.started = len(l.hooks)
+	return nil
+}
+
+// Stop runs the Stop hook of every started subsystem in reverse order. A
+// failing or slow hook does not prevent the remaining hooks from running;
+// all errors are joined and returned.
+func (l *Lifecycle) Stop(ctx context.Context) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.stopLocked(ctx)
+}
+
+func (l *Lifecycle) stopLocked(ctx context.Context) error {
+	var errs []error
+	for i := l.started - 1; i >= 0; i-- {
+		hook := l.hooks[i]
+		if err := runHook(ctx, hook, hook.Stop); err != nil {
+			errs = append(errs, fmt.Errorf("stop %s: %w", hook.Name, err))
+			log.Printf("[lifecycle] failed to stop %s: %v", hook.Name, err)
+			continue
+		}
+		log.Printf("[lifecycle] stopped %s", hook.Name)
+	}
+	l.started = 0
+	return errors.Join(errs...)
+}
+
+// runHook calls fn with a context bounded by the hook's timeout. If fn does
+// not return in time the hook is reported as timed out and left running in
+// the background; fn is expected to honor ctx.
+func runHook(ctx context.Context, hook LifecycleHook, fn func(context.Context) error) error {
+	if fn == nil {
+		return nil
+	}
+	timeout := hook.Timeout
+	if timeout <= 0 {
+		timeout = defaultHookTimeout
+	}
+	hookCtx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	done := make(chan error, 1)
+	go func() { done <- fn(hookCtx) }()
+
+	select {
+	case err := <-done:
+		return err
+	case <-hookCtx.Done():
+		return fmt.Errorf("timed out after %s: %w", timeout, hookCtx.Err())
+	}
+}
@@ -0,0 +1,102 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func recordingHook(name string, events *[]string) LifecycleHook {
+	return LifecycleHook{
+		Name: name,
+		Start: func(ctx context.Context) error {
+			*events = append(*events, "start "+name)
+			return nil
+		},
+		Stop: func(ctx context.Context) error {
+			*events = append(*events, "stop "+name)
+			return nil
+		},
+	}
+}
+
+func TestLifecycle_StartsInOrderStopsInReverse(t *testing.T) {
+	var events []string
+	lc := NewLifecycle()
+	lc.Register(recordingHook("redis", &events))
+	lc.Register(recordingHook("receipts", &events))
+	lc.Register(recordingHook("http", &events))
+
+	if err := lc.Start(context.Background()); err != nil {
+		t.Fatalf("Start failed: %v", err)
+	}
+	if err := lc.Stop(context.Background()); err != nil {
+		t.Fatalf("Stop failed: %v", err)
+	}
+
+	want := []string{
+		"start redis", "start receipts", "start http",
+		"stop http", "stop receipts", "stop redis",
+	}
+	if !reflect.DeepEqual(events, want) {
+		t.Errorf("unexpected hook order:\n got  %v\n want %v", events, want)
+	}
+}
+
+func TestLifecycle_StartFailureStopsStartedHooks(t *testing.T) {
+	var events []string
+	lc := NewLifecycle()
+	lc.Register(recordingHook("redis", &events))
+	lc.Register(LifecycleHook{
+		Name:  "broken",
+		Start: func(ctx context.Context) error { return errors.New("boom") },
+		Stop: func(ctx context.Context) error {
+			events = append(events, "stop broken")
+			return nil
+		},
+	})
+	lc.Register(recordingHook("http", &events))
+
+	err := lc.Start(context.Background())
+	if err == nil || !strings.Contains(err.Error(), "start broken") {
+		t.Fatalf("expected start error naming the hook, got %v", err)
+	}
+
+	want := []string{"start redis", "stop redis"}
+	if !reflect.DeepEqual(events, want) {
+		t.Errorf("unexpected hook order:\n got  %v\n want %v", events, want)
+	}
+}
+
+func TestLifecycle_StopTimeoutDoesNotBlockOtherHooks(t *testing.T) {
+	var events []string
+	lc := NewLifecycle()
+	lc.Register(recordingHook("redis", &events))
+	lc.Register(LifecycleHook{
+		Name:    "slow",
+		Timeout: 50 * time.Millisecond,
+		Stop: func(ctx context.Context) error {
+			time.Sleep(time.Second)
+			return nil
+		},
+	})
+
+	if err := lc.Start(context.Background()); err != nil {
+		t.Fatalf("Start failed: %v", err)
+	}
+
+	start := time.Now()
+	err := lc.Stop(context.Background())
+	if err == nil || !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected timeout error, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+		t.Errorf("Stop waited %v for a hook with a 50ms timeout", elapsed)
+	}
+	if len(events) != 2 || events[1] != "stop redis" {
+		t.Errorf("expected redis to stop after slow hook timed out, got %v", events)
+	}
+}
@@ -0,0 +1,226 @@
+package server
+
+import (
+	"fmt"
+	"log"
+	"runtime"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Load-shedding levels. Anonymous requests are shed first; paid requests only
+// once pressure passes the critical factor.
+const (
+	shedNone = iota
+	shedAnonymous
+	shedAll
+)
+
+var shedLevelNames = [...]string{"normal", "shedding-anonymous", "shedding-all"}
+
+// heapSampleInterval bounds how often ReadMemStats is called.
+const heapSampleInterval = time.Second
+
+// LoadShedConfig controls overload protection. A zero threshold disables
+// that signal. Pressure is the highest ratio of a signal to its threshold:
+// at 1 anonymous requests are shed, at CriticalFactor every request is. A
+// level is only left once pressure falls below RecoverFactor times the
+// threshold that raised it.
+type LoadShedConfig struct {
+	Enabled        bool
+	MaxGoroutines  int
+	MaxHeapMB      int
+	MaxInFlight    int
+	CriticalFactor float64
+	RecoverFactor  float64
+	RetryAfter     time.Duration
+}
+
+// validate reports settings that would shed nothing or never recover.
+func (lc LoadShedConfig) validate() error {
+	if !lc.Enabled {
+		return nil
+	}
+	if lc.MaxGoroutines < 0 || lc.MaxHeapMB < 0 || lc.MaxInFlight < 0 {
+		return fmt.Errorf("load shedding thresholds must not be negative")
+	}
+	if lc.MaxGoroutines == 0 && lc.MaxHeapMB == 0 && lc.MaxInFlight == 0 {
+		return fmt.Errorf("load shedding needs at least one threshold")
+	}
+	if lc.CriticalFactor < 1 {
+		return fmt.Errorf("LOAD_SHED_CRITICAL_FACTOR must be at least 1")
+	}
+	if lc.RecoverFactor <= 0 || lc.RecoverFactor >= 1 {
+		return fmt.Errorf("LOAD_SHED_RECOVER_FACTOR must be in (0, 1)")
+	}
+	return nil
+}
+
+// loadSample is one reading of the signals load shedding watches.
+type loadSample struct {
+	Goroutines int
+	HeapMB     int
+	InFlight   int64
+}
+
+// pressure returns the highest ratio of a signal to its threshold.
+func (s loadSample) pressure(lc LoadShedConfig) float64 {
+	var p float64
+	if lc.MaxGoroutines > 0 {
+		p = max(p, float64(s.Goroutines)/float64(lc.MaxGoroutines))
+	}
+	if lc.MaxHeapMB > 0 {
+		p = max(p, float64(s.HeapMB)/float64(lc.MaxHeapMB))
+	}
+	if lc.MaxInFlight > 0 {
+		p = max(p, float64(s.InFlight)/float64(lc.MaxInFlight))
+	}
+	return p
+}
+
+// nextShedLevel applies pressure to the current level. Levels rise as soon as
+// their threshold is crossed but step down only below RecoverFactor of it,
+// so the gateway does not flap around a threshold.
+func nextShedLevel(current int, pressure float64, lc LoadShedConfig) int {
+	target := shedNone
+	switch {
+	case pressure >= lc.CriticalFactor:
+		target = shedAll
+	case pressure >= 1:
+		target = shedAnonymous
+	}
+	for current > target {
+		threshold := 1.0
+		if current == shedAll {
+			threshold = lc.CriticalFactor
+		}
+		if pressure >= threshold*lc.RecoverFactor {
+			break
+		}
+		current--
+	}
+	return max(current, target)
+}
+
+// loadShedder tracks in-flight requests and the current shedding level.
+type loadShedder struct {
+	inFlight atomic.Int64
+
+	mu            sync.Mutex
+	level         int
+	last          loadSample
+	heapMB        int
+	heapSampledAt time.Time
+	// readRuntime returns the goroutine count and heap size in MB; tests
+	// replace it.
+	readRuntime func(s *loadShedder) (int, int)
+
+	shedAnonymousTotal atomic.Int64
+	shedPaidTotal      atomic.Int64
+	transitions        atomic.Int64
+}
+
+// shedder guards the whole router.
+var shedder = &loadShedder{readRuntime: (*loadShedder).readRuntimeStats}
+
+// readRuntimeStats reads the goroutine count and a heap size refreshed at
+// most once per heapSampleInterval. Called with s.mu held.
+func (s *loadShedder) readRuntimeStats() (int, int) {
+	if time.Since(s.heapSampledAt) >= heapSampleInterval {
+		var m runtime.MemStats
+		runtime.ReadMemStats(&m)
+		s.heapMB = int(m.HeapAlloc / 1024 / 1024)
+		s.heapSampledAt = time.Now()
+	}
+	return runtime.NumGoroutine(), s.heapMB
+}
+
+// evaluate samples the signals and returns the level to apply now.
+func (s *loadShedder) evaluate(lc LoadShedConfig) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	goroutines, heapMB := s.readRuntime(s)
+	s.last = loadSample{Goroutines: goroutines, HeapMB: heapMB, InFlight: s.inFlight.Load()}
+	next := nextShedLevel(s.level, s.last.pressure(lc), lc)
+	if next != s.level {
+		s.transitions.Add(1)
+		if next > s.level {
+			log.Printf("[WARNING] Load shedding %s (goroutines=%d heap_mb=%d in_flight=%d)",
+				shedLevelNames[next], s.last.Goroutines, s.last.HeapMB, s.last.InFlight)
+		} else {
+			log.Printf("Load shedding eased to %s", shedLevelNames[next])
+		}
+		s.level = next
+	}
+	return s.level
+}
+
+// Status reports the level, last sample and shed counts for /readyz.
+func (s *loadShedder) Status(lc LoadShedConfig) gin.H {
+	if !lc.Enabled {
+		return gin.H{"state": "disabled"}
+	}
+	s.mu.Lock()
+	level, last := s.level, s.last
+	s.mu.Unlock()
+	return gin.H{
+		"state":             shedLevelNames[level],
+		"goroutines":        last.Goroutines,
+		"heap_mb":           last.HeapMB,
+		"in_flight":         s.inFlight.Load(),
+		"pressure":          last.pressure(lc),
+		"shed_anonymous":    s.shedAnonymousTotal.Load(),
+		"shed_paid":         s.shedPaidTotal.Load(),
+		"transitions_total": s.transitions.Load(),
+		"max_goroutines":    lc.MaxGoroutines,
+		"max_heap_mb":       lc.MaxHeapMB,
+		"max_in_flight":     lc.MaxInFlight,
+		"critical_factor":   lc.CriticalFactor,
+		"recover_factor":    lc.RecoverFactor,
+	}
+}
+
+// isAnonymousRequest reports whether c carries no payment. Unlike the rate
+// limit tier it recovers no signer, so it stays cheap under overload.
+func isAnonymousRequest(c *gin.Context) bool {
+	return c.GetHeader("X-402-Signature") == "" && c.GetHeader("X-PAYMENT") == ""
+}
+
+// loadSheddingMiddleware rejects requests with 503 while the gateway is
+// overloaded: anonymous ones first, then all. Health and readiness probes
+// are never shed or counted.
+func loadSheddingMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		lc := getConfig().LoadShed
+		if !lc.Enabled {
+			c.Next()
+			return
+		}
+		switch c.Request.URL.Path {
+		case "/healthz", "/readyz":
+			c.Next()
+			return
+		}
+
+		level := shedder.evaluate(lc)
+		anonymous := isAnonymousRequest(c)
+		if level == shedAll || (level == shedAnonymous && anonymous) {
+			if anonymous {
+				shedder.shedAnonymousTotal.Add(1)
+			} else {
+				shedder.shedPaidTotal.Add(1)
+			}
+			c.Header("Retry-After", strconv.Itoa(max(int(lc.RetryAfter.Seconds()), 1)))
+			abortWithError(c, CodeOverloaded, "The gateway is shedding load. Please retry later.")
+			return
+		}
+
+		shedder.inFlight.Add(1)
+		defer shedder.inFlight.Add(-1)
+		c.Next()
+	}
+}
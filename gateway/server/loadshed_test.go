@@ -0,0 +1,151 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"gateway/internal/testsupport"
+)
+
+// withShedder installs a fresh shedder whose goroutine count is read from
+// *goroutines, with load shedding enabled on a 100-goroutine threshold.
+func withShedder(t *testing.T, goroutines *int) *loadShedder {
+	t.Helper()
+	t.Setenv("LOAD_SHED_ENABLED", "true")
+	t.Setenv("LOAD_SHED_MAX_GOROUTINES", "100")
+	t.Setenv("LOAD_SHED_MAX_HEAP_MB", "0")
+	t.Setenv("LOAD_SHED_MAX_IN_FLIGHT", "0")
+	prev := shedder
+	shedder = &loadShedder{readRuntime: func(*loadShedder) (int, int) { return *goroutines, 0 }}
+	t.Cleanup(func() { shedder = prev })
+	return shedder
+}
+
+func TestNextShedLevel_Hysteresis(t *testing.T) {
+	lc := LoadShedConfig{CriticalFactor: 1.5, RecoverFactor: 0.8}
+	steps := []struct {
+		pressure float64
+		want     int
+	}{
+		{0.5, shedNone},
+		{1.0, shedAnonymous},
+		{0.9, shedAnonymous}, // above 0.8 of the threshold: stays
+		{1.6, shedAll},
+		{1.3, shedAll}, // above 0.8 of 1.5
+		{1.1, shedAnonymous},
+		{0.7, shedNone},
+		{2.0, shedAll},
+		{0.1, shedNone}, // steps all the way down at once
+	}
+	level := shedNone
+	for i, step := range steps {
+		level = nextShedLevel(level, step.pressure, lc)
+		if level != step.want {
+			t.Fatalf("step %d (pressure %v): expected level %d, got %d", i, step.pressure, step.want, level)
+		}
+	}
+}
+
+func TestLoadShedConfig_Validate(t *testing.T) {
+	base := LoadShedConfig{Enabled: true, MaxInFlight: 10, CriticalFactor: 1.5, RecoverFactor: 0.8}
+	if err := base.validate(); err != nil {
+		t.Fatalf("expected valid config, got %v", err)
+	}
+	for name, mutate := range map[string]func(*LoadShedConfig){
+		"no thresholds":    func(lc *LoadShedConfig) { lc.MaxInFlight = 0 },
+		"negative":         func(lc *LoadShedConfig) { lc.MaxHeapMB = -1 },
+		"critical below 1": func(lc *LoadShedConfig) { lc.CriticalFactor = 0.9 },
+		"recover of 1":     func(lc *LoadShedConfig) { lc.RecoverFactor = 1 },
+	} {
+		lc := base
+		mutate(&lc)
+		if err := lc.validate(); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}
+
+func TestLoadShedding_ShedsAnonymousThenAll(t *testing.T) {
+	goroutines := 50
+	s := withShedder(t, &goroutines)
+	h := testsupport.NewHarness(t, newTestRouter)
+
+	paidRequests := 0
+	post := func(paid bool) int {
+		t.Helper()
+		if paid {
+			paidRequests++
+			return h.Post(t, "/api/ai/summarize", `{"text":"hello"}`, "0xsig", fmt.Sprintf("nonce-shed-%d", paidRequests)).StatusCode
+		}
+		return h.Post(t, "/api/ai/summarize", `{"text":"hello"}`, "", "").StatusCode
+	}
+
+	if code := post(false); code != http.StatusPaymentRequired {
+		t.Fatalf("normal load: expected 402, got %d", code)
+	}
+
+	goroutines = 120
+	if code := post(false); code != http.StatusServiceUnavailable {
+		t.Errorf("over threshold: expected anonymous request shed, got %d", code)
+	}
+	if code := post(true); code != http.StatusOK {
+		t.Errorf("over threshold: expected paid request served, got %d", code)
+	}
+
+	goroutines = 160
+	if code := post(true); code != http.StatusServiceUnavailable {
+		t.Errorf("critical: expected paid request shed, got %d", code)
+	}
+	if resp := h.Get(t, "/healthz"); resp.StatusCode != http.StatusOK {
+		t.Errorf("expected health checks never to be shed, got %d", resp.StatusCode)
+	}
+
+	goroutines = 90 // below critical recovery (120) but above 80
+	if code := post(true); code != http.StatusOK {
+		t.Errorf("easing: expected paid request served, got %d", code)
+	}
+	if code := post(false); code != http.StatusServiceUnavailable {
+		t.Errorf("easing: expected anonymous requests still shed, got %d", code)
+	}
+
+	goroutines = 70
+	if code := post(false); code != http.StatusPaymentRequired {
+		t.Errorf("recovered: expected 402, got %d", code)
+	}
+	if got := s.shedAnonymousTotal.Load(); got != 2 {
+		t.Errorf("expected 2 anonymous requests shed, got %d", got)
+	}
+	if got := s.shedPaidTotal.Load(); got != 1 {
+		t.Errorf("expected 1 paid request shed, got %d", got)
+	}
+}
+
+func TestLoadShedding_InFlightAndReadyz(t *testing.T) {
+	goroutines := 0
+	s := withShedder(t, &goroutines)
+	t.Setenv("LOAD_SHED_MAX_GOROUTINES", "0")
+	t.Setenv("LOAD_SHED_MAX_IN_FLIGHT", "4")
+	h := testsupport.NewHarness(t, newTestRouter)
+
+	s.inFlight.Store(4) // requests already being served
+	resp := h.Get(t, "/api/receipts/rcpt_000000000000")
+	if resp.StatusCode != http.StatusServiceUnavailable || resp.Header.Get("Retry-After") != "5" {
+		t.Fatalf("expected 503 with Retry-After 5, got %d %q", resp.StatusCode, resp.Header.Get("Retry-After"))
+	}
+
+	resp = h.Get(t, "/readyz")
+	var body struct {
+		Checks struct {
+			LoadShedding map[string]interface{} `json:"load_shedding"`
+		} `json:"checks"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		t.Fatal(err)
+	}
+	status := body.Checks.LoadShedding
+	if status["state"] != "shedding-anonymous" || status["shed_anonymous"] != 1.0 || status["in_flight"] != 4.0 {
+		t.Errorf("unexpected load_shedding status: %v", status)
+	}
+}
@@ -0,0 +1,205 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/redis/go-redis/v9"
+)
+
+// MaintenanceConfig is the maintenance mode set by the environment. The admin
+// API can override it at runtime.
+type MaintenanceConfig struct {
+	Enabled    bool
+	Message    string
+	RetryAfter time.Duration
+}
+
+const defaultMaintenanceMessage = "The gateway is undergoing maintenance. Please retry later."
+
+// maintenanceKey is the Redis key of the admin maintenance override.
+const maintenanceKey = "gateway:maintenance"
+
+// MaintenanceState is the effective maintenance mode. Source is "config"
+// when it comes from MAINTENANCE_MODE and "admin" for an override.
+type MaintenanceState struct {
+	Enabled           bool       `json:"enabled"`
+	Message           string     `json:"message"`
+	RetryAfterSeconds int        `json:"retry_after_seconds"`
+	Since             *time.Time `json:"since,omitempty"`
+	Source            string     `json:"source"`
+}
+
+var (
+	maintenanceMu       sync.RWMutex
+	maintenanceOverride *MaintenanceState
+)
+
+// setMaintenanceOverride replaces the admin override; nil clears it so the
+// config applies again. Overrides are kept in Redis (without expiry) when it
+// is connected so every replica enters maintenance together; the in-memory
+// fallback only affects this instance.
+func setMaintenanceOverride(ctx context.Context, state *MaintenanceState) error {
+	if redisClient != nil {
+		if state == nil {
+			if err := redisClient.Del(ctx, maintenanceKey).Err(); err != nil {
+				return fmt.Errorf("failed to clear maintenance override: %w", err)
+			}
+			return nil
+		}
+		data, err := json.Marshal(state)
+		if err != nil {
+			return err
+		}
+		if err := redisClient.Set(ctx, maintenanceKey, data, 0).Err(); err != nil {
+			return fmt.Errorf("failed to store maintenance override: %w", err)
+		}
+		return nil
+	}
+
+	maintenanceMu.Lock()
+	defer maintenanceMu.Unlock()
+	maintenanceOverride = state
+	return nil
+}
+
+// getMaintenanceOverride returns the admin override, if any.
+func getMaintenanceOverride(ctx context.Context) (*MaintenanceState, error) {
+	if redisClient != nil {
+		data, err := redisClient.Get(ctx, maintenanceKey).Bytes()
+		if errors.Is(err, redis.Nil) {
+			return nil, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to load maintenance override: %w", err)
+		}
+		var state MaintenanceState
+		if err := json.Unmarshal(data, &state); err != nil {
+			return nil, fmt.Errorf("invalid maintenance override: %w", err)
+		}
+		return &state, nil
+	}
+
+	maintenanceMu.RLock()
+	defer maintenanceMu.RUnlock()
+	return maintenanceOverride, nil
+}
+
+// maintenanceStatus returns the effective maintenance mode: the admin
+// override if one is set, else the config. An unreadable override is logged
+// and the config applies.
+func maintenanceStatus(ctx context.Context, cfg *Config) MaintenanceState {
+	override, err := getMaintenanceOverride(ctx)
+	if err != nil {
+		log.Printf("[WARNING] %v", err)
+	}
+	if override != nil {
+		return *override
+	}
+	return MaintenanceState{
+		Enabled:           cfg.Maintenance.Enabled,
+		Message:           cfg.Maintenance.Message,
+		RetryAfterSeconds: int(cfg.Maintenance.RetryAfter.Seconds()),
+		Source:            "config",
+	}
+}
+
+// maintenanceMiddleware rejects paid requests (every POST on the AI routes)
+// with 503 and Retry-After while maintenance mode is on. Job status, receipt
+// lookups and the health endpoints are not behind it and keep working.
+func maintenanceMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if c.Request.Method != http.MethodPost {
+			c.Next()
+			return
+		}
+		state := maintenanceStatus(c.Request.Context(), getConfig())
+		if !state.Enabled {
+			c.Next()
+			return
+		}
+		c.Header("Retry-After", strconv.Itoa(max(state.RetryAfterSeconds, 1)))
+		apiErr := newAPIError(CodeMaintenance, state.Message).with(gin.H{
+			"maintenance":         true,
+			"retry_after_seconds": state.RetryAfterSeconds,
+		})
+		if state.Since != nil {
+			apiErr.with(gin.H{"since": state.Since})
+		}
+		abortWithAPIError(c, apiErr)
+	}
+}
+
+// handleGetMaintenance handles GET /api/admin/maintenance.
+func handleGetMaintenance(c *gin.Context) {
+	c.JSON(200, maintenanceStatus(c.Request.Context(), getConfig()))
+}
+
+// handleSetMaintenance handles POST /api/admin/maintenance with body
+// {"enabled": true, "message": "...", "retry_after_seconds": 600}. Message
+// and retry_after_seconds default to the config.
+func handleSetMaintenance(c *gin.Context) {
+	var req struct {
+		Enabled           *bool  `json:"enabled"`
+		Message           string `json:"message"`
+		RetryAfterSeconds *int   `json:"retry_after_seconds"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		respondError(c, CodeInvalidRequest, "Request must be valid JSON")
+		return
+	}
+	if req.Enabled == nil {
+		respondError(c, CodeInvalidRequest, "enabled is required")
+		return
+	}
+	cfg := getConfig()
+	state := MaintenanceState{
+		Enabled:           *req.Enabled,
+		Message:           strings.TrimSpace(req.Message),
+		RetryAfterSeconds: int(cfg.Maintenance.RetryAfter.Seconds()),
+		Source:            "admin",
+	}
+	if state.Message == "" {
+		state.Message = cfg.Maintenance.Message
+	}
+	if req.RetryAfterSeconds != nil {
+		if *req.RetryAfterSeconds <= 0 {
+			respondError(c, CodeInvalidRequest, "retry_after_seconds must be positive")
+			return
+		}
+		state.RetryAfterSeconds = *req.RetryAfterSeconds
+	}
+	if state.Enabled {
+		now := time.Now().UTC()
+		state.Since = &now
+	}
+
+	if err := setMaintenanceOverride(c.Request.Context(), &state); err != nil {
+		log.Printf("[ERROR] %v", err)
+		respondError(c, CodeServiceUnavailable, "Maintenance state could not be stored")
+		return
+	}
+	log.Printf("Maintenance mode set to %t by admin: %s", state.Enabled, state.Message)
+	c.JSON(200, state)
+}
+
+// handleClearMaintenance handles DELETE /api/admin/maintenance, returning
+// control to MAINTENANCE_MODE.
+func handleClearMaintenance(c *gin.Context) {
+	if err := setMaintenanceOverride(c.Request.Context(), nil); err != nil {
+		log.Printf("[ERROR] %v", err)
+		respondError(c, CodeServiceUnavailable, "Maintenance state could not be stored")
+		return
+	}
+	log.Println("Maintenance override cleared by admin")
+	c.JSON(200, maintenanceStatus(c.Request.Context(), getConfig()))
+}
@@ -0,0 +1,140 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"gateway/internal/testsupport"
+)
+
+// withMaintenance clears the in-memory maintenance override for the test.
+func withMaintenance(t *testing.T) {
+	t.Helper()
+	maintenanceMu.Lock()
+	prev := maintenanceOverride
+	maintenanceOverride = nil
+	maintenanceMu.Unlock()
+	t.Cleanup(func() {
+		maintenanceMu.Lock()
+		maintenanceOverride = prev
+		maintenanceMu.Unlock()
+	})
+}
+
+func TestMaintenanceMode_FromConfig(t *testing.T) {
+	withMaintenance(t)
+	withJobQueue(t)
+	t.Setenv("MAINTENANCE_MODE", "true")
+	t.Setenv("MAINTENANCE_RETRY_AFTER_SECONDS", "120")
+	h := testsupport.NewHarness(t, newTestRouter)
+
+	for _, path := range []string{"/api/ai/summarize", "/api/v2/ai/summarize", "/api/ai/embed", "/api/ai/jobs"} {
+		resp := h.Post(t, path, `{"text":"hello"}`, "0xsig", "nonce-maint")
+		if resp.StatusCode != http.StatusServiceUnavailable || resp.Header.Get("Retry-After") != "120" {
+			t.Fatalf("%s: expected 503 with Retry-After 120, got %d %q", path, resp.StatusCode, resp.Header.Get("Retry-After"))
+		}
+		var body struct {
+			Error       string `json:"error"`
+			Message     string `json:"message"`
+			Maintenance bool   `json:"maintenance"`
+		}
+		if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+			t.Fatal(err)
+		}
+		if body.Error != "Maintenance" || !body.Maintenance || body.Message != defaultMaintenanceMessage {
+			t.Errorf("%s: unexpected body %+v", path, body)
+		}
+	}
+	if h.Verifier.Calls() != 0 || h.AI.Calls() != 0 {
+		t.Error("expected no payment verification or provider calls during maintenance")
+	}
+
+	for path, want := range map[string]int{
+		"/healthz":                           http.StatusOK,
+		"/api/receipts/rcpt_unknown":         http.StatusNotFound,
+		"/api/ai/jobs/job_unknown":           http.StatusNotFound,
+		"/.well-known/paygate-configuration": http.StatusOK,
+	} {
+		if resp := h.Get(t, path); resp.StatusCode != want {
+			t.Errorf("%s: expected %d during maintenance, got %d", path, want, resp.StatusCode)
+		}
+	}
+}
+
+func TestMaintenanceMode_AdminToggle(t *testing.T) {
+	withMaintenance(t)
+	t.Setenv("ADMIN_API_KEY", "s3cret")
+	h := testsupport.NewHarness(t, newTestRouter)
+	r := newTestRouter()
+
+	w := adminPost(t, r, "/api/admin/maintenance", "s3cret", `{"enabled":true,"message":"Upgrading the verifier","retry_after_seconds":600}`)
+	if w.Code != http.StatusOK {
+		t.Fatalf("enable: expected 200, got %d: %s", w.Code, w.Body)
+	}
+	resp := h.Post(t, "/api/ai/summarize", `{"text":"hello"}`, "0xsig", "nonce-admin")
+	var body struct {
+		Message string `json:"message"`
+		Since   string `json:"since"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusServiceUnavailable || resp.Header.Get("Retry-After") != "600" || body.Message != "Upgrading the verifier" || body.Since == "" {
+		t.Errorf("expected the admin maintenance response, got %d %q %+v", resp.StatusCode, resp.Header.Get("Retry-After"), body)
+	}
+
+	var state MaintenanceState
+	if err := json.Unmarshal(adminGet(t, r, "/api/admin/maintenance", "s3cret").Body.Bytes(), &state); err != nil {
+		t.Fatal(err)
+	}
+	if !state.Enabled || state.Source != "admin" {
+		t.Errorf("expected an enabled admin override, got %+v", state)
+	}
+
+	// An override can also turn off maintenance the config turned on.
+	t.Setenv("MAINTENANCE_MODE", "true")
+	adminPost(t, r, "/api/admin/maintenance", "s3cret", `{"enabled":false}`)
+	if resp := h.Post(t, "/api/ai/summarize", `{"text":"hello"}`, "0xsig", "nonce-off"); resp.StatusCode != http.StatusOK {
+		t.Errorf("disabled by admin: expected 200, got %d", resp.StatusCode)
+	}
+
+	req := httptest.NewRequest(http.MethodDelete, "/api/admin/maintenance", nil)
+	req.Header.Set("Authorization", "Bearer s3cret")
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	if err := json.Unmarshal(w.Body.Bytes(), &state); err != nil {
+		t.Fatal(err)
+	}
+	if w.Code != http.StatusOK || !state.Enabled || state.Source != "config" {
+		t.Errorf("expected clearing the override to restore MAINTENANCE_MODE, got %d %+v", w.Code, state)
+	}
+
+	for _, bad := range []string{`{}`, `{"enabled":true,"retry_after_seconds":0}`, `not json`} {
+		if w := adminPost(t, r, "/api/admin/maintenance", "s3cret", bad); w.Code != http.StatusBadRequest {
+			t.Errorf("%s: expected 400, got %d", bad, w.Code)
+		}
+	}
+}
+
+func TestMaintenanceOverride_SharedThroughRedis(t *testing.T) {
+	withMaintenance(t)
+	gw := startGateway(t, nil)
+
+	if err := setMaintenanceOverride(t.Context(), &MaintenanceState{Enabled: true, Message: "Back soon", RetryAfterSeconds: 30, Source: "admin"}); err != nil {
+		t.Fatal(err)
+	}
+	if maintenanceOverride != nil || len(gw.redisKeys(maintenanceKey)) != 1 {
+		t.Error("expected the override to be stored in Redis, not in memory")
+	}
+	if resp := gw.Post(t, "/api/ai/summarize", `{"text":"hello"}`, "0xsig", "nonce-redis"); resp.StatusCode != http.StatusServiceUnavailable {
+		t.Errorf("expected 503 from the Redis override, got %d", resp.StatusCode)
+	}
+	if err := setMaintenanceOverride(t.Context(), nil); err != nil {
+		t.Fatal(err)
+	}
+	if resp := gw.Post(t, "/api/ai/summarize", `{"text":"hello"}`, "0xsig", "nonce-cleared"); resp.StatusCode != http.StatusOK {
+		t.Errorf("expected 200 after clearing the override, got %d", resp.StatusCode)
+	}
+}
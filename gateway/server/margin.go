@@ -0,0 +1,249 @@
+package server
+
+import (
+	"log"
+	"math"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// marginBucket is the finest granularity margins are kept at; reports roll
+// hourly buckets up into larger intervals.
+const marginBucket = time.Hour
+
+// marginKey identifies one hourly aggregate. Tenant is the payer wallet.
+type marginKey struct {
+	Hour     time.Time
+	Endpoint string
+	Model    string
+	Tenant   string
+}
+
+// marginTotals accumulates revenue and provider cost in token base units.
+type marginTotals struct {
+	Requests int64
+	Revenue  int64
+	Cost     int64
+}
+
+func (t *marginTotals) add(o marginTotals) {
+	t.Requests += o.Requests
+	t.Revenue += o.Revenue
+	t.Cost += o.Cost
+}
+
+// MarginRow is one line of a margin report. Dimensions not in the report's
+// group_by are left empty.
+type MarginRow struct {
+	PeriodStart time.Time `json:"period_start"`
+	Endpoint    string    `json:"endpoint,omitempty"`
+	Model       string    `json:"model,omitempty"`
+	Tenant      string    `json:"tenant,omitempty"`
+	Requests    int64     `json:"requests"`
+	Revenue     string    `json:"revenue"`
+	Cost        string    `json:"cost"`
+	Margin      string    `json:"margin"`
+	MarginPct   float64   `json:"margin_pct"`
+}
+
+func newMarginRow(start time.Time, key marginKey, t marginTotals) MarginRow {
+	row := MarginRow{
+		PeriodStart: start,
+		Endpoint:    key.Endpoint,
+		Model:       key.Model,
+		Tenant:      key.Tenant,
+		Requests:    t.Requests,
+		Revenue:     formatTokenAmount(t.Revenue),
+		Cost:        formatTokenAmount(t.Cost),
+		Margin:      formatTokenAmount(t.Revenue - t.Cost),
+	}
+	if t.Revenue > 0 {
+		row.MarginPct = math.Round(float64(t.Revenue-t.Cost)/float64(t.Revenue)*10000) / 100
+	}
+	return row
+}
+
+// marginLedger keeps hourly revenue/cost aggregates in memory for the
+// retention period. Aggregating per hour bounds memory by the number of
+// distinct endpoint/model/tenant combinations rather than by request volume.
+type marginLedger struct {
+	mu        sync.Mutex
+	buckets   map[marginKey]*marginTotals
+	retention time.Duration
+	lastPrune time.Time
+}
+
+func newMarginLedger(retention time.Duration) *marginLedger {
+	return &marginLedger{buckets: make(map[marginKey]*marginTotals), retention: retention}
+}
+
+// Record adds one served request to the ledger.
+func (l *marginLedger) Record(at time.Time, endpoint, model, tenant string, revenue, cost int64) {
+	key := marginKey{
+		Hour:     at.UTC().Truncate(marginBucket),
+		Endpoint: endpoint,
+		Model:    model,
+		Tenant:   strings.ToLower(tenant),
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	totals, ok := l.buckets[key]
+	if !ok {
+		totals = &marginTotals{}
+		l.buckets[key] = totals
+	}
+	totals.add(marginTotals{Requests: 1, Revenue: revenue, Cost: cost})
+
+	if at.Sub(l.lastPrune) >= marginBucket {
+		l.lastPrune = at
+		cutoff := at.Add(-l.retention)
+		for k := range l.buckets {
+			if k.Hour.Add(marginBucket).Before(cutoff) {
+				delete(l.buckets, k)
+			}
+		}
+	}
+}
+
+// Report aggregates buckets in [from, to) into periods of interval, grouped by
+// the given dimensions ("endpoint", "model", "tenant"). Rows are ordered by
+// period and then by dimension values. The second return value sums all rows.
+func (l *marginLedger) Report(from, to time.Time, interval time.Duration, groupBy []string) ([]MarginRow, MarginRow) {
+	type rowKey struct {
+		Start time.Time
+		marginKey
+	}
+	byEndpoint := slices.Contains(groupBy, "endpoint")
+	byModel := slices.Contains(groupBy, "model")
+	byTenant := slices.Contains(groupBy, "tenant")
+
+	grouped := make(map[rowKey]*marginTotals)
+	var total marginTotals
+
+	l.mu.Lock()
+	for k, t := range l.buckets {
+		if k.Hour.Before(from) || !k.Hour.Before(to) {
+			continue
+		}
+		rk := rowKey{Start: from.Add(k.Hour.Sub(from).Truncate(interval))}
+		if byEndpoint {
+			rk.Endpoint = k.Endpoint
+		}
+		if byModel {
+			rk.Model = k.Model
+		}
+		if byTenant {
+			rk.Tenant = k.Tenant
+		}
+		g, ok := grouped[rk]
+		if !ok {
+			g = &marginTotals{}
+			grouped[rk] = g
+		}
+		g.add(*t)
+		total.add(*t)
+	}
+	l.mu.Unlock()
+
+	rows := make([]MarginRow, 0, len(grouped))
+	for rk, t := range grouped {
+		rows = append(rows, newMarginRow(rk.Start, rk.marginKey, *t))
+	}
+	slices.SortFunc(rows, func(a, b MarginRow) int {
+		if c := a.PeriodStart.Compare(b.PeriodStart); c != 0 {
+			return c
+		}
+		return strings.Compare(a.Endpoint+"\x00"+a.Model+"\x00"+a.Tenant, b.Endpoint+"\x00"+b.Model+"\x00"+b.Tenant)
+	})
+	return rows, newMarginRow(from, marginKey{}, total)
+}
+
+// getMarginRetention returns how long margin data is kept (default 30 days).
+func getMarginRetention() time.Duration {
+	return time.Duration(getEnvAsInt("MARGIN_RETENTION_DAYS", 30)) * 24 * time.Hour
+}
+
+// margins is the process-wide ledger fed by successful paid requests.
+var margins = newMarginLedger(getMarginRetention())
+
+// usdToTokenUnits converts a provider cost in USD to USDC base units.
+func usdToTokenUnits(usd float64) int64 {
+	return int64(math.Round(usd * math.Pow10(tokenDecimals)))
+}
+
+// providerCostUSD returns what usage on model cost in USD and where the
+// figure came from: the provider's reported cost ("provider"), else the
+// tokens priced at the model catalog's rates ("catalog"), else "none".
+func providerCostUSD(model string, usage ProviderUsage) (float64, string) {
+	if usage.Cost > 0 {
+		return usage.Cost, "provider"
+	}
+	if usage.PromptTokens+usage.CompletionTokens > 0 {
+		if info, ok := modelCatalog.Load().Lookup(model); ok && info.PromptPrice+info.CompletionPrice > 0 {
+			return float64(usage.PromptTokens)*info.PromptPrice + float64(usage.CompletionTokens)*info.CompletionPrice, "catalog"
+		}
+	}
+	return 0, "none"
+}
+
+// ReceiptMargin is the provider usage and margin of the request a receipt
+// was issued for.
+type ReceiptMargin struct {
+	ReceiptID        string  `json:"receipt_id"`
+	Endpoint         string  `json:"endpoint"`
+	Model            string  `json:"model"`
+	PromptTokens     int64   `json:"prompt_tokens"`
+	CompletionTokens int64   `json:"completion_tokens"`
+	Revenue          string  `json:"revenue"`
+	Cost             string  `json:"cost"`
+	CostSource       string  `json:"cost_source"`
+	Margin           string  `json:"margin"`
+	MarginPct        float64 `json:"margin_pct"`
+}
+
+// recordMargin adds a delivered request to the margin ledger and the stats,
+// attributing it to the request path, the model that served it and the
+// payer, and keeps its margin with the receipt issued for it.
+func recordMargin(c *gin.Context, payment PaymentContext, payer string, usage ProviderUsage) {
+	receiptID := ""
+	if v, ok := c.Get("issued_receipt"); ok {
+		receiptID = v.(*SignedReceipt).Receipt.ID
+	}
+	recordMarginFor(c.Request.URL.Path, getModelSelection(c).Model, receiptID, payment, payer, usage)
+}
+
+// recordMarginFor is recordMargin for work finished outside the request.
+func recordMarginFor(endpoint, model, receiptID string, payment PaymentContext, payer string, usage ProviderUsage) {
+	revenue, err := parseTokenAmount(payment.Amount)
+	if err != nil {
+		log.Printf("[WARNING] Margin not recorded, invalid payment amount: %v", err)
+		return
+	}
+	costUSD, source := providerCostUSD(model, usage)
+	cost := usdToTokenUnits(costUSD)
+	now := time.Now()
+	margins.Record(now, endpoint, model, payer, revenue, cost)
+	gatewayStats.RecordMargin(now, revenue, cost, usage)
+	if receiptID == "" {
+		return
+	}
+	row := newMarginRow(now, marginKey{}, marginTotals{Revenue: revenue, Cost: cost})
+	setReceiptMargin(receiptID, &ReceiptMargin{
+		ReceiptID:        receiptID,
+		Endpoint:         endpoint,
+		Model:            model,
+		PromptTokens:     usage.PromptTokens,
+		CompletionTokens: usage.CompletionTokens,
+		Revenue:          row.Revenue,
+		Cost:             row.Cost,
+		CostSource:       source,
+		Margin:           row.Margin,
+		MarginPct:        row.MarginPct,
+	})
+}
@@ -0,0 +1,79 @@
+package server
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+func TestMarginLedger_ReportGroupsAndRollsUp(t *testing.T) {
+	l := newMarginLedger(30 * 24 * time.Hour)
+	day := time.Date(2026, 3, 10, 0, 0, 0, 0, time.UTC)
+
+	l.Record(day.Add(1*time.Hour), "/api/ai/summarize", "model-a", "0xAAA", 1000, 400)
+	l.Record(day.Add(2*time.Hour), "/api/ai/summarize", "model-a", "0xaaa", 1000, 600)
+	l.Record(day.Add(3*time.Hour), "/api/ai/summarize", "model-b", "0xbbb", 1000, 1500)
+	l.Record(day.Add(26*time.Hour), "/api/ai/summarize", "model-a", "0xaaa", 1000, 0)
+
+	rows, totals := l.Report(day, day.Add(48*time.Hour), 24*time.Hour, []string{"model"})
+	if len(rows) != 3 {
+		t.Fatalf("expected 3 rows (2 models on day 1, 1 on day 2), got %d: %+v", len(rows), rows)
+	}
+	first := rows[0]
+	if first.Model != "model-a" || first.Tenant != "" || first.Requests != 2 {
+		t.Errorf("unexpected first row: %+v", first)
+	}
+	if first.Revenue != "0.002" || first.Cost != "0.001" || first.Margin != "0.001" || first.MarginPct != 50 {
+		t.Errorf("unexpected first row amounts: %+v", first)
+	}
+	if rows[1].Model != "model-b" || rows[1].Margin != "-0.0005" {
+		t.Errorf("expected model-b to run at a loss, got %+v", rows[1])
+	}
+	if !rows[2].PeriodStart.Equal(day.Add(24 * time.Hour)) {
+		t.Errorf("expected last row in second day, got %v", rows[2].PeriodStart)
+	}
+	if totals.Requests != 4 || totals.Revenue != "0.004" || totals.Cost != "0.0025" {
+		t.Errorf("unexpected totals: %+v", totals)
+	}
+}
+
+func TestMarginLedger_PrunesExpiredBuckets(t *testing.T) {
+	l := newMarginLedger(24 * time.Hour)
+	start := time.Date(2026, 3, 10, 0, 0, 0, 0, time.UTC)
+
+	l.Record(start, "/e", "m", "t", 1000, 0)
+	l.Record(start.Add(48*time.Hour), "/e", "m", "t", 1000, 0)
+
+	if len(l.buckets) != 1 {
+		t.Errorf("expected bucket older than retention to be pruned, have %d", len(l.buckets))
+	}
+}
+
+func TestUSDToTokenUnits(t *testing.T) {
+	if got := usdToTokenUnits(0.0004215); got != 422 {
+		t.Errorf("expected 422 base units, got %d", got)
+	}
+}
+
+func TestProviderCostUSD(t *testing.T) {
+	modelCatalog.Store(&ModelCatalog{Models: map[string]ModelInfo{"priced": {PromptPrice: 0.000001, CompletionPrice: 0.000002}}})
+	t.Cleanup(func() { modelCatalog.Store(nil) })
+
+	cases := []struct {
+		model  string
+		usage  ProviderUsage
+		cost   float64
+		source string
+	}{
+		{"priced", ProviderUsage{PromptTokens: 100, CompletionTokens: 50, Cost: 0.01}, 0.01, "provider"},
+		{"priced", ProviderUsage{PromptTokens: 100, CompletionTokens: 50}, 0.0002, "catalog"},
+		{"unpriced", ProviderUsage{PromptTokens: 100}, 0, "none"},
+		{"priced", ProviderUsage{}, 0, "none"},
+	}
+	for _, tc := range cases {
+		cost, source := providerCostUSD(tc.model, tc.usage)
+		if math.Abs(cost-tc.cost) > 1e-12 || source != tc.source {
+			t.Errorf("%s %+v: got %v %q, want %v %q", tc.model, tc.usage, cost, source, tc.cost, tc.source)
+		}
+	}
+}
@@ -1,13 +1,17 @@
-package main
+package server
 
 import (
 	"bufio"
 	"bytes"
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"log"
 	"net"
 	"net/http"
+	"os"
 	"sync"
 	"time"
 
@@ -40,9 +44,14 @@ func CorrelationIDMiddleware() gin.HandlerFunc {
 	}
 }
 
+// errResponseTooLarge is returned for the write that takes a response past
+// the buffer limit when it cannot be spilled to disk.
+var errResponseTooLarge = errors.New("response exceeds the response buffer limit")
+
 // bufferedWriter captures response writes in-memory so the middleware can
 // decide whether to send the real response or a timeout response without
-// racing with handler writes.
+// racing with handler writes. Past maxBytes the response moves to a spill
+// file or, in abort mode, is abandoned and overflow is closed.
 type bufferedWriter struct {
 	buf    *bytes.Buffer
 	head   http.Header
@@ -50,15 +59,26 @@ type bufferedWriter struct {
 	wrote  bool
 	closed bool
 	mu     sync.RWMutex
+
+	maxBytes int64
+	abort    bool
+	spillDir string
+	spill    *os.File
+	spilled  int64
+	overflow chan struct{}
 }
 
 // newBufferedWriter returns an initialized bufferedWriter used to capture
 // response headers and body from handlers without flushing to the client.
-func newBufferedWriter() *bufferedWriter {
+func newBufferedWriter(rb ResponseBufferConfig) *bufferedWriter {
 	return &bufferedWriter{
-		buf:    bytes.NewBuffer(nil),
-		head:   make(http.Header),
-		status: http.StatusOK,
+		buf:      bytes.NewBuffer(nil),
+		head:     make(http.Header),
+		status:   http.StatusOK,
+		maxBytes: rb.MaxBytes,
+		abort:    rb.Overflow == bufferOverflowAbort,
+		spillDir: rb.SpillDir,
+		overflow: make(chan struct{}),
 	}
 }
 
@@ -78,17 +98,79 @@ func (b *bufferedWriter) Write(data []byte) (int, error) {
 		return 0, nil
 	}
 	b.wrote = true
+	if b.spill == nil && b.maxBytes > 0 && int64(b.buf.Len()+len(data)) > b.maxBytes {
+		if err := b.overflowLocked(); err != nil {
+			return 0, err
+		}
+	}
+	if b.spill != nil {
+		n, err := b.spill.Write(data)
+		b.spilled += int64(n)
+		return n, err
+	}
 	return b.buf.Write(data)
 }
 
 func (b *bufferedWriter) WriteString(s string) (int, error) {
+	return b.Write([]byte(s))
+}
+
+// overflowLocked handles the write that takes the response past maxBytes.
+// In spill mode the buffered bytes move to a temporary file that takes the
+// rest of the response; otherwise, or if the file cannot be written, the
+// response is dropped and overflow closed. b.mu must be held.
+func (b *bufferedWriter) overflowLocked() error {
+	if !b.abort {
+		f, err := os.CreateTemp(b.spillDir, "paygate-response-*")
+		if err == nil {
+			if _, err = f.Write(b.buf.Bytes()); err == nil {
+				b.spill, b.spilled = f, int64(b.buf.Len())
+				b.buf = bytes.NewBuffer(nil)
+				return nil
+			}
+			f.Close()
+			os.Remove(f.Name())
+		}
+		log.Printf("[WARNING] Failed to spill response to disk, dropping it: %v", err)
+	}
+	b.closed = true
+	b.buf = bytes.NewBuffer(nil)
+	close(b.overflow)
+	return errResponseTooLarge
+}
+
+// size returns the number of body bytes written so far.
+func (b *bufferedWriter) size() int {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	if b.spill != nil {
+		return int(b.spilled)
+	}
+	return b.buf.Len()
+}
+
+// overflowed reports whether the response was dropped for outgrowing the
+// buffer.
+func (b *bufferedWriter) overflowed() bool {
+	select {
+	case <-b.overflow:
+		return true
+	default:
+		return false
+	}
+}
+
+// release drops the response, removing its spill file. Later writes are
+// discarded.
+func (b *bufferedWriter) release() {
 	b.mu.Lock()
 	defer b.mu.Unlock()
-	if b.closed {
-		return 0, nil
+	b.closed = true
+	if b.spill != nil {
+		b.spill.Close()
+		os.Remove(b.spill.Name())
+		b.spill = nil
 	}
-	b.wrote = true
-	return b.buf.WriteString(s)
 }
 
 // WriteHeader captures the status code but does not flush to the client.
@@ -131,13 +213,26 @@ func (b *bufferedWriter) flushTo(w http.ResponseWriter) {
 		}
 	}
 	w.WriteHeader(b.Status())
+	if b.spill != nil {
+		// Copied in chunks so a spilled response never returns to memory.
+		_, err := b.spill.Seek(0, io.SeekStart)
+		if err == nil {
+			_, err = io.Copy(w, b.spill)
+		}
+		if err != nil {
+			log.Printf("[WARNING] Failed to send spilled response: %v", err)
+		}
+		return
+	}
 	_, _ = w.Write(b.buf.Bytes())
 }
 
 // RequestTimeoutMiddleware applies a context timeout to the request and
 // buffers handler output. If the context deadline is exceeded, the middleware
-// returns 504 and discards the handler response. This avoids concurrent
-// response writes and ensures safe behavior with Gin.
+// returns 504, discards the handler response and waits for the cancelled
+// handler to return before the chain continues. This avoids concurrent
+// response writes and context access and ensures safe behavior with Gin. The buffer is bounded
+// by RESPONSE_BUFFER_MAX_BYTES, and STREAMING_ROUTES are not buffered.
 func RequestTimeoutMiddleware(timeout time.Duration) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		// Choose a deadline that ensures a per-route timeout can shorten any
@@ -155,7 +250,7 @@ func RequestTimeoutMiddleware(timeout time.Duration) gin.HandlerFunc {
 				// If an earlier deadline already exists, keep it. Otherwise set
 				// a new deadline at the desired point.
 				if d.Before(desired) {
-					ctx = c.Request.Context()
+					ctx, cancel = context.WithCancel(c.Request.Context())
 				} else {
 					ctx, cancel = context.WithDeadline(c.Request.Context(), desired)
 				}
@@ -163,13 +258,35 @@ func RequestTimeoutMiddleware(timeout time.Duration) gin.HandlerFunc {
 				ctx, cancel = context.WithTimeout(c.Request.Context(), timeout)
 			}
 		}
-		if cancel != nil {
-			defer cancel()
-		}
+		defer cancel()
 		c.Request = c.Request.WithContext(ctx)
 
+		// Built before the handler runs so the timeout path does not read
+		// the context concurrently with it.
+		timeoutErr := newAPIError(CodeRequestTimeout, "Request exceeded maximum allowed time").forRequest(c)
+
+		rb := getConfig().ResponseBuffer
+		if rb.IsStreaming(c.FullPath(), c.Request.URL.Path) {
+			// Streamed responses reach the client as they are written, so
+			// the handler must stop at the deadline itself; only a response
+			// not yet started can still become a 504.
+			c.Next()
+			if errors.Is(ctx.Err(), context.DeadlineExceeded) && !c.Writer.Written() {
+				abortWithAPIError(c, timeoutErr)
+			}
+			return
+		}
+		tooLargeErr := newAPIError(CodeResponseTooLarge, "The response exceeded the gateway's response buffer").forRequest(c)
+		writeError := func(w gin.ResponseWriter, status int, e *APIError) {
+			w.Header().Set("Content-Type", "application/json; charset=utf-8")
+			w.WriteHeader(status)
+			body, _ := json.Marshal(e)
+			_, _ = w.Write(body)
+		}
+
 		origWriter := c.Writer
-		bw := newBufferedWriter()
+		bw := newBufferedWriter(rb)
+		defer bw.release()
 		// replace the gin writer with a shim that uses bw and keeps orig writer
 		c.Writer = &responseWriterShim{bw: bw, orig: origWriter}
 		finished := make(chan struct{}, 1)
@@ -179,21 +296,37 @@ func RequestTimeoutMiddleware(timeout time.Duration) gin.HandlerFunc {
 		go func() {
 			defer func() {
 				if r := recover(); r != nil {
-					panicChan <- r
+					panicChan <- capturePanic(r)
 				}
 			}()
 			c.Next()
 			close(finished)
 		}()
+		// awaitHandler waits for a cancelled handler to return after the
+		// error response is sent. The handler and the rest of the chain share
+		// c (its handler index, keys and refund state), so the middleware
+		// must not hand c back to the chain while the handler still runs.
+		awaitHandler := func() {
+			select {
+			case <-finished:
+			case p := <-panicChan:
+				log.Printf("[WARNING] Handler for %s panicked after its response was sent: %v", c.Request.URL.Path, p.(*capturedPanic).value)
+			}
+		}
 		select {
 		case <-finished:
 			// Handler finished before deadline: flush buffered response. Do not
 			// restore c.Writer here to avoid racing with handler goroutine.
+			if bw.overflowed() {
+				writeError(origWriter, 500, tooLargeErr)
+				return
+			}
 			bw.flushTo(origWriter)
 			return
 		case p := <-panicChan:
 			// Restore the original writer so upstream Recovery middleware writes
 			// directly to the real response, then re-panic so Recovery can handle it.
+			// The panic carries the handler's stack (capturePanic).
 			c.Writer = origWriter
 			panic(p)
 		case <-ctx.Done():
@@ -201,12 +334,19 @@ func RequestTimeoutMiddleware(timeout time.Duration) gin.HandlerFunc {
 			// writes. Do NOT restore c.Writer here, otherwise a concurrently
 			// running handler may write directly to the real writer after the
 			// timeout response was already sent (causing panics or corruption).
-			bw.mu.Lock()
-			bw.closed = true
-			bw.mu.Unlock()
-			origWriter.Header().Set("Content-Type", "application/json; charset=utf-8")
-			origWriter.WriteHeader(504)
-			_, _ = origWriter.Write([]byte(`{"error":"Gateway Timeout","message":"Request exceeded maximum allowed time"}`))
+			bw.release()
+			writeError(origWriter, 504, timeoutErr)
+			origWriter.Flush()
+			awaitHandler()
+			return
+		case <-bw.overflow:
+			// The response outgrew the buffer and could not be spilled:
+			// stop the handler and answer 500 rather than a truncated body.
+			cancel()
+			log.Printf("[WARNING] Response to %s exceeded the %d byte response buffer", c.Request.URL.Path, rb.MaxBytes)
+			writeError(origWriter, 500, tooLargeErr)
+			origWriter.Flush()
+			awaitHandler()
 			return
 		}
 	}
@@ -227,16 +367,14 @@ func (rws *responseWriterShim) WriteHeader(statusCode int)        { rws.bw.Write
 func (rws *responseWriterShim) WriteHeaderNow()                   { rws.bw.WriteHeaderNow() }
 func (rws *responseWriterShim) Status() int                       { return rws.bw.Status() }
 func (rws *responseWriterShim) Written() bool                     { return rws.bw.wrote }
-func (rws *responseWriterShim) Size() int                         { return rws.bw.buf.Len() }
+func (rws *responseWriterShim) Size() int                         { return rws.bw.size() }
 func (rws *responseWriterShim) WriteHeaderNowWithoutLock()        {}
 
-// Flush flushes the response to the client if the underlying writer
-// supports http.Flusher. This is a no-op otherwise.
-func (rws *responseWriterShim) Flush() {
-	if fl, ok := rws.orig.(http.Flusher); ok {
-		fl.Flush()
-	}
-}
+// Flush is a no-op. The response is buffered until the handler finishes so
+// the timeout path can still replace it; flushing the underlying writer here
+// would commit a 200 status and headers early (on HTTP/2 as a HEADERS frame)
+// before the buffered status and headers are copied across.
+func (rws *responseWriterShim) Flush() {}
 
 // Hijack delegates to the underlying writer if it supports http.Hijacker.
 func (rws *responseWriterShim) Hijack() (net.Conn, *bufio.ReadWriter, error) {
@@ -246,12 +384,10 @@ func (rws *responseWriterShim) Hijack() (net.Conn, *bufio.ReadWriter, error) {
 	return nil, nil, fmt.Errorf("hijack not supported")
 }
 
-// Pusher delegates to the underlying writer if it supports http.Pusher.
+// Pusher returns the HTTP/2 server push handle of the underlying writer, or
+// nil when the connection does not support push.
 func (rws *responseWriterShim) Pusher() http.Pusher {
-	if p, ok := rws.orig.(http.Pusher); ok {
-		return p
-	}
-	return nil
+	return rws.orig.Pusher()
 }
 
 // CloseNotify delegates to the original writer's CloseNotify when available.
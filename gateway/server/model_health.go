@@ -0,0 +1,192 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// maxModelSamples bounds the per-model sample buffer so a traffic spike
+// cannot grow memory without limit inside a single health window.
+const maxModelSamples = 1000
+
+// modelSample is a single observed AI call.
+type modelSample struct {
+	at      time.Time
+	latency time.Duration
+	failed  bool
+}
+
+// modelStats accumulates samples and lifetime counters for one model.
+type modelStats struct {
+	samples       []modelSample
+	requests      int64
+	errors        int64
+	substitutions int64
+}
+
+// ModelHealth is a point-in-time summary of a model's recent behavior.
+type ModelHealth struct {
+	Samples       int     `json:"samples"`
+	ErrorRate     float64 `json:"error_rate"`
+	AvgLatencyMs  int64   `json:"avg_latency_ms"`
+	Degraded      bool    `json:"degraded"`
+	Requests      int64   `json:"requests_total"`
+	Errors        int64   `json:"errors_total"`
+	Substitutions int64   `json:"substitutions_total"`
+}
+
+// modelHealthTracker keeps a rolling window of latency and error samples per
+// model. Samples older than the configured window are ignored, so a model
+// that stops receiving traffic after failover is treated as healthy again
+// once its bad samples age out.
+type modelHealthTracker struct {
+	mu     sync.Mutex
+	models map[string]*modelStats
+}
+
+// newModelHealthTracker returns an empty tracker.
+func newModelHealthTracker() *modelHealthTracker {
+	return &modelHealthTracker{models: make(map[string]*modelStats)}
+}
+
+// modelHealth is the process-wide tracker fed by callProvider.
+var modelHealth = newModelHealthTracker()
+
+func (t *modelHealthTracker) stats(model string) *modelStats {
+	s, ok := t.models[model]
+	if !ok {
+		s = &modelStats{}
+		t.models[model] = s
+	}
+	return s
+}
+
+// Record stores the outcome of a call to model.
+func (t *modelHealthTracker) Record(model string, latency time.Duration, failed bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	s := t.stats(model)
+	s.requests++
+	if failed {
+		s.errors++
+	}
+	s.samples = append(s.samples, modelSample{at: time.Now(), latency: latency, failed: failed})
+	if len(s.samples) > maxModelSamples {
+		s.samples = s.samples[len(s.samples)-maxModelSamples:]
+	}
+}
+
+// RecordSubstitution counts a request that was moved off model.
+func (t *modelHealthTracker) RecordSubstitution(model string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.stats(model).substitutions++
+}
+
+// Health summarizes model over the failover window in cfg.
+func (t *modelHealthTracker) Health(model string, cfg *Config) ModelHealth {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.healthLocked(model, cfg, time.Now())
+}
+
+func (t *modelHealthTracker) healthLocked(model string, cfg *Config, now time.Time) ModelHealth {
+	s, ok := t.models[model]
+	if !ok {
+		return ModelHealth{}
+	}
+
+	// Drop samples that have aged out of the window.
+	cutoff := now.Add(-cfg.ModelFailover.Window)
+	i := 0
+	for i < len(s.samples) && s.samples[i].at.Before(cutoff) {
+		i++
+	}
+	s.samples = s.samples[i:]
+
+	h := ModelHealth{
+		Samples:       len(s.samples),
+		Requests:      s.requests,
+		Errors:        s.errors,
+		Substitutions: s.substitutions,
+	}
+	if h.Samples == 0 {
+		return h
+	}
+
+	var failed int
+	var total time.Duration
+	for _, sample := range s.samples {
+		if sample.failed {
+			failed++
+		}
+		total += sample.latency
+	}
+	h.ErrorRate = float64(failed) / float64(h.Samples)
+	h.AvgLatencyMs = (total / time.Duration(h.Samples)).Milliseconds()
+	h.Degraded = h.Samples >= cfg.ModelFailover.MinSamples &&
+		(h.ErrorRate >= cfg.ModelFailover.ErrorRate ||
+			total/time.Duration(h.Samples) >= cfg.ModelFailover.Latency)
+	return h
+}
+
+// Snapshot returns the health of every model seen so far.
+func (t *modelHealthTracker) Snapshot(cfg *Config) map[string]ModelHealth {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	now := time.Now()
+	out := make(map[string]ModelHealth, len(t.models))
+	for model := range t.models {
+		out[model] = t.healthLocked(model, cfg, now)
+	}
+	return out
+}
+
+// ModelSelection records which model serves a request, the price quoted for
+// it and, when failover kicked in, which preferred model it replaced.
+// Provider is set once an AI provider has served the request.
+type ModelSelection struct {
+	Model          string
+	SubstitutedFor string
+	Price          string
+	Provider       string
+}
+
+// selectModel picks the preferred model for a text of inputChars characters
+// (see routeModel) unless it is degraded and a healthy backup model is
+// configured. The routed price applies even when the backup serves.
+func selectModel(cfg *Config, inputChars int) ModelSelection {
+	preferred, price := routeModel(cfg, inputChars)
+	backup := cfg.ModelFailover.BackupModel
+	if backup == "" || backup == preferred {
+		return ModelSelection{Model: preferred, Price: price}
+	}
+	if !modelHealth.Health(preferred, cfg).Degraded || modelHealth.Health(backup, cfg).Degraded {
+		return ModelSelection{Model: preferred, Price: price}
+	}
+	modelHealth.RecordSubstitution(preferred)
+	return ModelSelection{Model: backup, SubstitutedFor: preferred, Price: price}
+}
+
+// getModelSelection returns the model chosen for this request by
+// selectModelForText, or the route for an empty text if none was made yet.
+func getModelSelection(c *gin.Context) ModelSelection {
+	if v, ok := c.Get("model_selection"); ok {
+		return v.(ModelSelection)
+	}
+	sel := selectModel(getConfig(), 0)
+	c.Set("model_selection", sel)
+	return sel
+}
+
+// isModelFailure reports whether err should count against a model's health.
+// Client cancellations are not the provider's fault and are ignored.
+func isModelFailure(err error) bool {
+	return err != nil && !errors.Is(err, context.Canceled)
+}
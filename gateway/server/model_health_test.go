@@ -0,0 +1,139 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"gateway/receipts"
+)
+
+func testFailoverConfig() *Config {
+	return &Config{
+		Model: "primary",
+		ModelFailover: ModelFailoverConfig{
+			BackupModel: "backup",
+			Latency:     500 * time.Millisecond,
+			ErrorRate:   0.5,
+			MinSamples:  3,
+			Window:      time.Minute,
+		},
+	}
+}
+
+// withModelHealth swaps in a fresh tracker for the duration of a test.
+func withModelHealth(t *testing.T) *modelHealthTracker {
+	t.Helper()
+	previous := modelHealth
+	modelHealth = newModelHealthTracker()
+	t.Cleanup(func() { modelHealth = previous })
+	return modelHealth
+}
+
+func TestModelHealth_DegradesOnErrorRate(t *testing.T) {
+	cfg := testFailoverConfig()
+	tracker := newModelHealthTracker()
+
+	tracker.Record("primary", 10*time.Millisecond, true)
+	tracker.Record("primary", 10*time.Millisecond, true)
+	if tracker.Health("primary", cfg).Degraded {
+		t.Fatal("expected model to stay healthy below MinSamples")
+	}
+
+	tracker.Record("primary", 10*time.Millisecond, false)
+	h := tracker.Health("primary", cfg)
+	if !h.Degraded {
+		t.Fatalf("expected model to be degraded at error rate %.2f", h.ErrorRate)
+	}
+	if h.Requests != 3 || h.Errors != 2 {
+		t.Errorf("expected 3 requests / 2 errors, got %d / %d", h.Requests, h.Errors)
+	}
+}
+
+func TestModelHealth_DegradesOnLatency(t *testing.T) {
+	cfg := testFailoverConfig()
+	tracker := newModelHealthTracker()
+
+	for i := 0; i < 3; i++ {
+		tracker.Record("primary", time.Second, false)
+	}
+	if !tracker.Health("primary", cfg).Degraded {
+		t.Fatal("expected slow model to be degraded")
+	}
+}
+
+func TestModelHealth_SamplesAgeOutOfWindow(t *testing.T) {
+	cfg := testFailoverConfig()
+	cfg.ModelFailover.Window = 50 * time.Millisecond
+	tracker := newModelHealthTracker()
+
+	for i := 0; i < 3; i++ {
+		tracker.Record("primary", 10*time.Millisecond, true)
+	}
+	if !tracker.Health("primary", cfg).Degraded {
+		t.Fatal("expected model to be degraded")
+	}
+
+	time.Sleep(100 * time.Millisecond)
+	if h := tracker.Health("primary", cfg); h.Degraded || h.Samples != 0 {
+		t.Errorf("expected samples to age out, got %+v", h)
+	}
+}
+
+func TestSelectModel_SwitchesToHealthyBackup(t *testing.T) {
+	tracker := withModelHealth(t)
+	cfg := testFailoverConfig()
+
+	if sel := selectModel(cfg, 0); sel.Model != "primary" || sel.SubstitutedFor != "" {
+		t.Fatalf("expected preferred model when healthy, got %+v", sel)
+	}
+
+	for i := 0; i < 3; i++ {
+		tracker.Record("primary", 10*time.Millisecond, true)
+	}
+	sel := selectModel(cfg, 0)
+	if sel.Model != "backup" || sel.SubstitutedFor != "primary" {
+		t.Fatalf("expected substitution to backup, got %+v", sel)
+	}
+	if got := tracker.Health("primary", cfg).Substitutions; got != 1 {
+		t.Errorf("expected 1 substitution recorded, got %d", got)
+	}
+
+	// A degraded backup is no better than the preferred model.
+	for i := 0; i < 3; i++ {
+		tracker.Record("backup", 10*time.Millisecond, true)
+	}
+	if sel := selectModel(cfg, 0); sel.Model != "primary" {
+		t.Errorf("expected preferred model when backup is also degraded, got %+v", sel)
+	}
+}
+
+func TestIsModelFailure_IgnoresCancellation(t *testing.T) {
+	if isModelFailure(nil) {
+		t.Error("nil error should not count as failure")
+	}
+	if isModelFailure(fmt.Errorf("wrapped: %w", context.Canceled)) {
+		t.Error("client cancellation should not count as failure")
+	}
+	if !isModelFailure(errors.New("invalid response from AI provider")) {
+		t.Error("provider error should count as failure")
+	}
+}
+
+func TestGenerateReceipt_RecordsModelSubstitution(t *testing.T) {
+	t.Setenv("SERVER_WALLET_PRIVATE_KEY", "0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef")
+	if _, err := getServerPrivateKey(); err != nil {
+		t.Skipf("server key unavailable: %v", err)
+	}
+
+	receipt, err := GenerateReceipt(PaymentContext{Token: "USDC", Amount: "0.001", Nonce: "n"}, "0xpayer", "/api/ai/summarize",
+		[]byte(`{"text":"hi"}`), []byte(`{"result":"ok"}`), receipts.WithModel("backup", "primary"))
+	if err != nil {
+		t.Fatalf("GenerateReceipt failed: %v", err)
+	}
+	if receipt.Receipt.Service.Model != "backup" || receipt.Receipt.Service.SubstitutedFor != "primary" {
+		t.Errorf("expected substitution in receipt, got %+v", receipt.Receipt.Service)
+	}
+}
@@ -0,0 +1,117 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ModelRoute sends texts of up to MaxChars characters to Model and charges
+// Price for them. MaxChars 0 marks the catch-all route for longer texts.
+type ModelRoute struct {
+	MaxChars int    `json:"max_chars,omitempty"`
+	Model    string `json:"model"`
+	Price    string `json:"price"`
+}
+
+// parseModelRoutes parses MODEL_ROUTES, a comma-separated list of
+// "max_chars|model|price" entries in ascending max_chars order. The last
+// entry may use "*" as max_chars; if it does not, texts longer than every
+// threshold are still sent to the last route.
+func parseModelRoutes(s string) ([]ModelRoute, error) {
+	var routes []ModelRoute
+	for _, entry := range strings.Split(s, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		parts := strings.Split(entry, "|")
+		if len(parts) != 3 {
+			return nil, fmt.Errorf("model route %q must be max_chars|model|price", entry)
+		}
+		route := ModelRoute{Model: strings.TrimSpace(parts[1]), Price: strings.TrimSpace(parts[2])}
+		if limit := strings.TrimSpace(parts[0]); limit != "*" {
+			n, err := strconv.Atoi(limit)
+			if err != nil || n <= 0 {
+				return nil, fmt.Errorf("model route %q: max_chars must be a positive integer or *", entry)
+			}
+			route.MaxChars = n
+		}
+		if route.Model == "" {
+			return nil, fmt.Errorf("model route %q: model is empty", entry)
+		}
+		if _, err := parseTokenAmount(route.Price); err != nil {
+			return nil, fmt.Errorf("model route %q: %w", entry, err)
+		}
+		if n := len(routes); n > 0 {
+			prev := routes[n-1]
+			if prev.MaxChars == 0 {
+				return nil, fmt.Errorf("model route %q follows the catch-all route", entry)
+			}
+			if route.MaxChars != 0 && route.MaxChars <= prev.MaxChars {
+				return nil, fmt.Errorf("model routes must be in ascending max_chars order")
+			}
+		}
+		routes = append(routes, route)
+	}
+	return routes, nil
+}
+
+// routeModel returns the preferred model and price for a text of inputChars
+// characters. Without routes every request uses OPENROUTER_MODEL at
+// PAYMENT_AMOUNT.
+func routeModel(cfg *Config, inputChars int) (model, price string) {
+	if len(cfg.ModelRoutes) == 0 {
+		return cfg.Model, cfg.PaymentAmount
+	}
+	for _, route := range cfg.ModelRoutes {
+		if route.MaxChars == 0 || inputChars <= route.MaxChars {
+			return route.Model, route.Price
+		}
+	}
+	last := cfg.ModelRoutes[len(cfg.ModelRoutes)-1]
+	return last.Model, last.Price
+}
+
+// selectModelForText routes the request by the length of text and stores the
+// selection so the cache key, verification, AI call and receipt all agree.
+// Once a request has been routed later calls return the stored selection.
+func selectModelForText(c *gin.Context, text string) ModelSelection {
+	if v, ok := c.Get("model_selection"); ok {
+		return v.(ModelSelection)
+	}
+	sel := selectModel(getConfig(), utf8.RuneCountInString(text))
+	c.Set("model_selection", sel)
+	return sel
+}
+
+// PriceQuote is returned with a 402 so clients know which model and amount a
+// request will be charged at before signing.
+type PriceQuote struct {
+	Model      string `json:"model"`
+	Price      string `json:"price"`
+	InputChars int    `json:"input_chars"`
+}
+
+// quotePrice prices an unsigned request from its body. Clients that want the
+// routed price must send the same body they intend to pay for; an unreadable
+// or empty body is quoted as empty text.
+func quotePrice(c *gin.Context) PriceQuote {
+	const maxBodySize = 10 * 1024 * 1024
+	var req SummarizeRequest
+	if c.Request.Body != nil {
+		if body, err := io.ReadAll(io.LimitReader(c.Request.Body, maxBodySize)); err == nil {
+			_ = json.Unmarshal(body, &req)
+		}
+	}
+	chars := utf8.RuneCountInString(req.Text)
+	model, price := routeModel(getConfig(), chars)
+	params := clampGenerationParams(req.GenerationParams, getConfig().GenerationMaxTokens)
+	params.Style, _ = normalizeSummaryStyle(params.Style)
+	return PriceQuote{Model: model, Price: summaryPrice(price, params), InputChars: chars}
+}
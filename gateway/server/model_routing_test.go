@@ -0,0 +1,110 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+
+	"gateway/internal/testsupport"
+)
+
+func TestParseModelRoutes(t *testing.T) {
+	routes, err := parseModelRoutes("2000|small/model:free|0.001, 16000|large/model|0.004, *|huge/model|0.01")
+	if err != nil {
+		t.Fatalf("parseModelRoutes failed: %v", err)
+	}
+	want := []ModelRoute{
+		{MaxChars: 2000, Model: "small/model:free", Price: "0.001"},
+		{MaxChars: 16000, Model: "large/model", Price: "0.004"},
+		{MaxChars: 0, Model: "huge/model", Price: "0.01"},
+	}
+	if len(routes) != len(want) {
+		t.Fatalf("expected %d routes, got %+v", len(want), routes)
+	}
+	for i := range want {
+		if routes[i] != want[i] {
+			t.Errorf("route %d = %+v, want %+v", i, routes[i], want[i])
+		}
+	}
+
+	if routes, err := parseModelRoutes(""); err != nil || routes != nil {
+		t.Errorf("expected empty MODEL_ROUTES to disable routing, got %v, %v", routes, err)
+	}
+
+	for _, bad := range []string{
+		"2000|model",
+		"abc|model|0.001",
+		"2000|model|free",
+		"2000||0.001",
+		"2000|a|0.001,1000|b|0.002",
+		"*|a|0.001,2000|b|0.002",
+	} {
+		if _, err := parseModelRoutes(bad); err == nil {
+			t.Errorf("expected %q to be rejected", bad)
+		}
+	}
+}
+
+func TestRouteModel(t *testing.T) {
+	cfg := &Config{Model: "default", PaymentAmount: "0.001"}
+	if model, price := routeModel(cfg, 50000); model != "default" || price != "0.001" {
+		t.Errorf("expected default model and price without routes, got %s %s", model, price)
+	}
+
+	cfg.ModelRoutes = []ModelRoute{
+		{MaxChars: 100, Model: "small", Price: "0.001"},
+		{MaxChars: 1000, Model: "large", Price: "0.003"},
+	}
+	cases := map[int]string{0: "small", 100: "small", 101: "large", 1000: "large", 5000: "large"}
+	for chars, want := range cases {
+		if model, _ := routeModel(cfg, chars); model != want {
+			t.Errorf("routeModel(%d) = %s, want %s", chars, model, want)
+		}
+	}
+}
+
+func TestConfigValidate_RejectsRoutedModelOutsideAllowlist(t *testing.T) {
+	t.Setenv("OPENROUTER_MODEL", "small")
+	t.Setenv("OPENROUTER_ALLOWED_MODELS", "small")
+	t.Setenv("MODEL_ROUTES", "100|small|0.001,*|large|0.003")
+	if err := loadConfig().Validate(); err == nil {
+		t.Error("expected routed model outside the allowlist to be rejected")
+	}
+
+	t.Setenv("MODEL_ROUTES", "100|small")
+	if err := loadConfig().Validate(); err == nil {
+		t.Error("expected malformed MODEL_ROUTES to be rejected")
+	}
+}
+
+func TestSummarize_RoutesByInputLength(t *testing.T) {
+	t.Setenv("MODEL_ROUTES", "20|small/model|0.001,*|large/model|0.005")
+	h := testsupport.NewHarness(t, newTestRouter)
+	longText := `{"text":"` + strings.Repeat("x", 50) + `"}`
+
+	for body, want := range map[string]string{`{"text":"short"}`: "0.001", longText: "0.005"} {
+		resp := h.Post(t, "/api/ai/summarize", body, "", "")
+		var quote struct {
+			PaymentContext PaymentContext `json:"paymentContext"`
+			Quote          PriceQuote     `json:"quote"`
+		}
+		if err := json.NewDecoder(resp.Body).Decode(&quote); err != nil {
+			t.Fatalf("decode 402: %v", err)
+		}
+		if quote.PaymentContext.Amount != want || quote.Quote.Price != want {
+			t.Errorf("expected quote of %s, got %+v", want, quote)
+		}
+	}
+
+	resp := h.Post(t, "/api/ai/summarize", longText, "0xsig", "nonce")
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected 200, got %d", resp.StatusCode)
+	}
+	if got := h.Verifier.Requests()[0].Context.Amount; got != "0.005" {
+		t.Errorf("expected verifier to check the routed price, got %s", got)
+	}
+	if got := h.AI.Models(); len(got) != 1 || got[0] != "large/model" {
+		t.Errorf("expected long text to use large/model, got %v", got)
+	}
+}
@@ -0,0 +1,213 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+// modelCatalogKey holds the last fetched catalog in Redis, so a restarted or
+// newly scaled replica has one before its first fetch succeeds.
+const modelCatalogKey = "model_catalog"
+
+// modelCatalogTTL bounds how long a cached catalog is trusted in Redis.
+const modelCatalogTTL = 24 * time.Hour
+
+// modelCatalogTimeout bounds a single model list fetch.
+const modelCatalogTimeout = 10 * time.Second
+
+// ModelInfo is a provider model's context window and per-token USD pricing.
+type ModelInfo struct {
+	ContextLength   int     `json:"context_length"`
+	PromptPrice     float64 `json:"prompt_price"`
+	CompletionPrice float64 `json:"completion_price"`
+}
+
+// ModelCatalog is the provider's model list as of FetchedAt.
+type ModelCatalog struct {
+	Models    map[string]ModelInfo `json:"models"`
+	FetchedAt time.Time            `json:"fetched_at"`
+}
+
+// Lookup returns model's entry. A nil or empty catalog has no entries.
+func (mc *ModelCatalog) Lookup(model string) (ModelInfo, bool) {
+	if mc == nil {
+		return ModelInfo{}, false
+	}
+	info, ok := mc.Models[model]
+	return info, ok
+}
+
+// Loaded reports whether the catalog lists any model. Checks against the
+// catalog are skipped until it does.
+func (mc *ModelCatalog) Loaded() bool {
+	return mc != nil && len(mc.Models) > 0
+}
+
+// modelCatalog is the latest catalog, nil until the first fetch or Redis load.
+var modelCatalog atomic.Pointer[ModelCatalog]
+
+// getModelCatalogURL returns the provider's model list endpoint.
+func getModelCatalogURL() string {
+	return getEnv("OPENROUTER_MODELS_URL", "https://openrouter.ai/api/v1/models")
+}
+
+// getModelCatalogInterval returns how often the catalog is refreshed
+// (MODEL_CATALOG_REFRESH_SECONDS, default 1h); 0 disables it.
+func getModelCatalogInterval() time.Duration {
+	return time.Duration(getEnvAsInt("MODEL_CATALOG_REFRESH_SECONDS", 3600)) * time.Second
+}
+
+// refreshModelCatalog fetches the catalog and swaps it in. When the fetch
+// fails the current catalog is kept, falling back to the one cached in Redis
+// if there is none yet, and the error is returned.
+func refreshModelCatalog(ctx context.Context) error {
+	catalog, err := fetchModelCatalog(ctx)
+	if err != nil {
+		if modelCatalog.Load() == nil {
+			if cached := loadCachedModelCatalog(ctx); cached != nil {
+				modelCatalog.Store(cached)
+				log.Printf("Loaded %d models from the cached model catalog", len(cached.Models))
+			}
+		}
+		return fmt.Errorf("model catalog refresh failed: %w", err)
+	}
+	modelCatalog.Store(catalog)
+	cacheModelCatalog(ctx, catalog)
+	if err := checkModelsOffered(getConfig(), catalog); err != nil {
+		log.Printf("[WARNING] %v", err)
+	}
+	return nil
+}
+
+// fetchModelCatalog reads the provider's model list. Prices are USD per
+// token, sent as decimal strings.
+func fetchModelCatalog(ctx context.Context) (*ModelCatalog, error) {
+	ctx, cancel := context.WithTimeout(ctx, modelCatalogTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, getModelCatalogURL(), nil)
+	if err != nil {
+		return nil, err
+	}
+	if apiKey := os.Getenv("OPENROUTER_API_KEY"); apiKey != "" {
+		req.Header.Set("Authorization", "Bearer "+apiKey)
+	}
+	resp, err := getConfig().ProviderHTTPClient().Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("model list returned status %d", resp.StatusCode)
+	}
+
+	var body struct {
+		Data []struct {
+			ID            string `json:"id"`
+			ContextLength int    `json:"context_length"`
+			Pricing       struct {
+				Prompt     string `json:"prompt"`
+				Completion string `json:"completion"`
+			} `json:"pricing"`
+		} `json:"data"`
+	}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 16<<20)).Decode(&body); err != nil {
+		return nil, fmt.Errorf("decode model list: %w", err)
+	}
+	catalog := &ModelCatalog{Models: make(map[string]ModelInfo, len(body.Data)), FetchedAt: time.Now()}
+	for _, m := range body.Data {
+		if m.ID == "" {
+			continue
+		}
+		// Unparseable prices (OpenRouter sends "-1" for variable pricing)
+		// are left unknown.
+		prompt, _ := strconv.ParseFloat(m.Pricing.Prompt, 64)
+		completion, _ := strconv.ParseFloat(m.Pricing.Completion, 64)
+		catalog.Models[m.ID] = ModelInfo{
+			ContextLength:   m.ContextLength,
+			PromptPrice:     max(prompt, 0),
+			CompletionPrice: max(completion, 0),
+		}
+	}
+	return catalog, nil
+}
+
+func cacheModelCatalog(ctx context.Context, catalog *ModelCatalog) {
+	if redisClient == nil {
+		return
+	}
+	data, err := json.Marshal(catalog)
+	if err != nil {
+		return
+	}
+	if err := redisClient.Set(ctx, modelCatalogKey, data, modelCatalogTTL).Err(); err != nil {
+		log.Printf("[WARNING] Failed to cache model catalog: %v", err)
+	}
+}
+
+func loadCachedModelCatalog(ctx context.Context) *ModelCatalog {
+	if redisClient == nil {
+		return nil
+	}
+	data, err := redisClient.Get(ctx, modelCatalogKey).Bytes()
+	if err != nil {
+		return nil
+	}
+	var catalog ModelCatalog
+	if err := json.Unmarshal(data, &catalog); err != nil {
+		return nil
+	}
+	return &catalog
+}
+
+// modelContextWindow returns model's context window, or 0 if it is not
+// checked. MODEL_CONTEXT_WINDOWS entries win over the catalog, which wins
+// over MODEL_CONTEXT_WINDOW_DEFAULT.
+func modelContextWindow(cfg *Config, model string) int {
+	if n, ok := cfg.ContextWindows.Windows[model]; ok {
+		return n
+	}
+	if info, ok := modelCatalog.Load().Lookup(model); ok && info.ContextLength > 0 {
+		return info.ContextLength
+	}
+	return cfg.ContextWindows.Default
+}
+
+// checkModelsOffered returns an error naming the first configured model
+// (default, routed, backup or allowlisted) the catalog does not list. It
+// passes while no catalog is loaded.
+func checkModelsOffered(cfg *Config, catalog *ModelCatalog) error {
+	if !catalog.Loaded() {
+		return nil
+	}
+	check := func(kind, model string) error {
+		if _, ok := catalog.Lookup(model); model != "" && !ok {
+			return fmt.Errorf("%s %q is not offered by the provider", kind, model)
+		}
+		return nil
+	}
+	if err := check("model", cfg.Model); err != nil {
+		return err
+	}
+	for _, route := range cfg.ModelRoutes {
+		if err := check("routed model", route.Model); err != nil {
+			return err
+		}
+	}
+	if err := check("backup model", cfg.ModelFailover.BackupModel); err != nil {
+		return err
+	}
+	for _, model := range cfg.AllowedModels {
+		if err := check("allowed model", model); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,103 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"testing"
+
+	"gateway/internal/testsupport"
+)
+
+// withModelCatalog lists models on h's fake provider and loads them as the
+// catalog, which is cleared when the test ends.
+func withModelCatalog(t *testing.T, h *testsupport.Harness, models ...testsupport.CatalogModel) {
+	t.Helper()
+	h.AI.SetCatalog(models...)
+	t.Cleanup(func() { modelCatalog.Store(nil) })
+	refreshModelCatalog(context.Background())
+	if !modelCatalog.Load().Loaded() {
+		t.Fatal("expected the model catalog to load")
+	}
+}
+
+func TestModelCatalog_FetchParsesWindowsAndPricing(t *testing.T) {
+	h := testsupport.NewHarness(t, newTestRouter)
+	withModelCatalog(t, h,
+		testsupport.CatalogModel{ID: "small-model", ContextLength: 100, PromptPrice: "0.000001", CompletionPrice: "0.000002"},
+		testsupport.CatalogModel{ID: "auto-model", ContextLength: 2000, PromptPrice: "-1", CompletionPrice: "-1"},
+	)
+
+	info, ok := modelCatalog.Load().Lookup("small-model")
+	if !ok || info.ContextLength != 100 || info.PromptPrice != 0.000001 || info.CompletionPrice != 0.000002 {
+		t.Errorf("unexpected catalog entry %+v", info)
+	}
+	if info, _ := modelCatalog.Load().Lookup("auto-model"); info.PromptPrice != 0 || info.CompletionPrice != 0 {
+		t.Errorf("expected variable pricing to be left unknown, got %+v", info)
+	}
+}
+
+func TestModelCatalog_FailedRefreshKeepsCatalog(t *testing.T) {
+	h := testsupport.NewHarness(t, newTestRouter)
+	withModelCatalog(t, h, testsupport.CatalogModel{ID: "small-model", ContextLength: 100})
+	t.Setenv("OPENROUTER_MODELS_URL", h.AI.URL+"/missing")
+
+	refreshModelCatalog(context.Background())
+	if _, ok := modelCatalog.Load().Lookup("small-model"); !ok {
+		t.Error("expected a failed refresh to keep the previous catalog")
+	}
+}
+
+func TestModelCatalog_ContextWindowFallback(t *testing.T) {
+	t.Setenv("OPENROUTER_MODEL", "small-model")
+	h := testsupport.NewHarness(t, newTestRouter)
+	withModelCatalog(t, h, testsupport.CatalogModel{ID: "small-model", ContextLength: 100})
+
+	resp := h.Post(t, "/api/ai/summarize", `{"text":"`+strings.Repeat("word ", 200)+`"}`, "0xsig", "nonce-catalog-window")
+	if resp.StatusCode != http.StatusRequestEntityTooLarge {
+		t.Fatalf("expected the catalog's context window to apply, got %d", resp.StatusCode)
+	}
+	if h.Verifier.Calls() != 0 {
+		t.Error("expected the request to be rejected before payment")
+	}
+
+	// An explicit MODEL_CONTEXT_WINDOWS entry wins over the catalog.
+	t.Setenv("MODEL_CONTEXT_WINDOWS", "small-model=100000")
+	if got := modelContextWindow(loadConfig(), "small-model"); got != 100000 {
+		t.Errorf("expected the configured window, got %d", got)
+	}
+}
+
+func TestModelCatalog_EstimateIncludesProviderCost(t *testing.T) {
+	t.Setenv("OPENROUTER_MODEL", "small-model")
+	h := testsupport.NewHarness(t, newTestRouter)
+
+	if est := postEstimate(t, h, "/api/ai/estimate", `{"text":"hello world"}`); est.ProviderCost != "" || est.ContextWindow != 0 {
+		t.Errorf("expected no provider cost or window without a catalog, got %+v", est)
+	}
+
+	withModelCatalog(t, h, testsupport.CatalogModel{ID: "small-model", ContextLength: 4096, PromptPrice: "0.000001", CompletionPrice: "0.000002"})
+	est := postEstimate(t, h, "/api/ai/estimate", `{"text":"hello world"}`)
+	usd := float64(summarizePromptTokens("hello world", GenerationParams{}))*0.000001 + float64(getConfig().GenerationMaxTokens)*0.000002
+	if want := formatTokenAmount(usdToTokenUnits(usd)); est.ProviderCost != want || est.ContextWindow != 4096 {
+		t.Errorf("expected provider cost %s and window 4096, got %+v", want, est)
+	}
+}
+
+func TestModelCatalog_ReloadRejectsUnofferedModel(t *testing.T) {
+	t.Setenv("OPENROUTER_MODEL", "small-model")
+	h := testsupport.NewHarness(t, newTestRouter)
+	withModelCatalog(t, h, testsupport.CatalogModel{ID: "small-model"}, testsupport.CatalogModel{ID: "large-model"})
+	resetConfigSnapshot(t)
+	currentConfig.Store(loadConfig())
+
+	t.Setenv("OPENROUTER_BACKUP_MODEL", "retired-model")
+	if err := reloadConfig(""); err == nil || !strings.Contains(err.Error(), "retired-model") {
+		t.Fatalf("expected reload to reject a model the provider does not offer, got %v", err)
+	}
+
+	t.Setenv("OPENROUTER_BACKUP_MODEL", "large-model")
+	if err := reloadConfig(""); err != nil {
+		t.Fatalf("expected reload with offered models to succeed, got %v", err)
+	}
+}
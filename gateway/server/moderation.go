@@ -0,0 +1,232 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"regexp"
+	"slices"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ModerationRule flags input matching Pattern with Category.
+type ModerationRule struct {
+	Category string
+	Pattern  *regexp.Regexp
+}
+
+// ModerationConfig controls content screening of AI inputs. Local rules run
+// first; the provider API, if configured, only sees inputs they let through.
+type ModerationConfig struct {
+	Enabled    bool
+	Rules      []ModerationRule
+	APIURL     string
+	APIKey     string
+	APIModel   string
+	APITimeout time.Duration
+	FailOpen   bool
+}
+
+// loadModerationConfig reads MODERATION_* variables. Rules come from
+// MODERATION_KEYWORDS ("category:word|phrase,...", matched as whole words,
+// case-insensitively) and MODERATION_RULES_FILE, whose lines are
+// "<category> <regexp>" with # comments.
+func loadModerationConfig() (ModerationConfig, error) {
+	mc := ModerationConfig{
+		Enabled:    getEnvAsBool("MODERATION_ENABLED", false),
+		APIURL:     os.Getenv("MODERATION_API_URL"),
+		APIKey:     os.Getenv("MODERATION_API_KEY"),
+		APIModel:   os.Getenv("MODERATION_API_MODEL"),
+		APITimeout: getPositiveTimeout("MODERATION_API_TIMEOUT_SECONDS", 5),
+		FailOpen:   getEnvAsBool("MODERATION_FAIL_OPEN", true),
+	}
+	for _, entry := range getEnvAsList("MODERATION_KEYWORDS", nil) {
+		category, words, _ := strings.Cut(entry, ":")
+		var quoted []string
+		for _, w := range strings.Split(words, "|") {
+			if w = strings.TrimSpace(w); w != "" {
+				quoted = append(quoted, regexp.QuoteMeta(w))
+			}
+		}
+		category = strings.TrimSpace(category)
+		if category == "" || len(quoted) == 0 {
+			return ModerationConfig{}, fmt.Errorf("MODERATION_KEYWORDS entry %q must be category:word|word", entry)
+		}
+		pattern := regexp.MustCompile(`(?i)\b(?:` + strings.Join(quoted, "|") + `)\b`)
+		mc.Rules = append(mc.Rules, ModerationRule{Category: category, Pattern: pattern})
+	}
+
+	path := os.Getenv("MODERATION_RULES_FILE")
+	if path == "" {
+		return mc, nil
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return ModerationConfig{}, fmt.Errorf("MODERATION_RULES_FILE: %w", err)
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		category, expr, ok := strings.Cut(line, " ")
+		if !ok {
+			return ModerationConfig{}, fmt.Errorf("MODERATION_RULES_FILE line %d: expected \"<category> <regexp>\"", n)
+		}
+		pattern, err := regexp.Compile(strings.TrimSpace(expr))
+		if err != nil {
+			return ModerationConfig{}, fmt.Errorf("MODERATION_RULES_FILE line %d: %w", n, err)
+		}
+		mc.Rules = append(mc.Rules, ModerationRule{Category: category, Pattern: pattern})
+	}
+	if err := scanner.Err(); err != nil {
+		return ModerationConfig{}, fmt.Errorf("MODERATION_RULES_FILE: %w", err)
+	}
+	return mc, nil
+}
+
+// moderationStats counts screening outcomes for /readyz.
+var moderationStats struct {
+	rejected  atomic.Int64
+	apiErrors atomic.Int64
+}
+
+// screenContent checks texts against the moderation rules and provider
+// before any payment is verified, so rejected requests are never charged and
+// their nonce stays unused. It aborts with 422 and the violated categories
+// (or 503 if the provider is down and MODERATION_FAIL_OPEN is false) and
+// returns false when the request must not proceed.
+func screenContent(c *gin.Context, texts ...string) bool {
+	mc := getConfig().Moderation
+	if !mc.Enabled || c.GetBool("content_screened") {
+		return true
+	}
+
+	categories := matchModerationRules(mc.Rules, texts)
+	if len(categories) == 0 && mc.APIURL != "" {
+		flagged, err := callModerationAPI(c.Request.Context(), mc, texts)
+		if err != nil {
+			moderationStats.apiErrors.Add(1)
+			log.Printf("[WARNING] Moderation API failed: %v", err)
+			if !mc.FailOpen {
+				abortWithError(c, CodeServiceUnavailable, "Content screening is unavailable")
+				return false
+			}
+		}
+		categories = flagged
+	}
+	if len(categories) > 0 {
+		moderationStats.rejected.Add(1)
+		abortWithAPIError(c, newAPIError(CodeContentRejected, "The input violates the content policy; no payment was taken").
+			with(gin.H{"categories": categories}))
+		return false
+	}
+	c.Set("content_screened", true)
+	return true
+}
+
+// matchModerationRules returns the sorted categories whose rules match any
+// of texts.
+func matchModerationRules(rules []ModerationRule, texts []string) []string {
+	var categories []string
+	for _, rule := range rules {
+		if slices.Contains(categories, rule.Category) {
+			continue
+		}
+		for _, text := range texts {
+			if rule.Pattern.MatchString(text) {
+				categories = append(categories, rule.Category)
+				break
+			}
+		}
+	}
+	slices.Sort(categories)
+	return categories
+}
+
+// callModerationAPI screens texts with an OpenAI-compatible moderation
+// endpoint and returns the categories flagged for any of them.
+func callModerationAPI(ctx context.Context, mc ModerationConfig, texts []string) ([]string, error) {
+	ctx, cancel := context.WithTimeout(ctx, mc.APITimeout)
+	defer cancel()
+
+	payload := map[string]interface{}{"input": texts}
+	if mc.APIModel != "" {
+		payload["model"] = mc.APIModel
+	}
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, mc.APIURL, bytes.NewReader(body))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create moderation request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if mc.APIKey != "" {
+		req.Header.Set("Authorization", "Bearer "+mc.APIKey)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		io.Copy(io.Discard, resp.Body)
+		return nil, fmt.Errorf("moderation API returned status %d", resp.StatusCode)
+	}
+
+	var result struct {
+		Results []struct {
+			Flagged    bool            `json:"flagged"`
+			Categories map[string]bool `json:"categories"`
+		} `json:"results"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, fmt.Errorf("failed to decode moderation response: %w", err)
+	}
+	var categories []string
+	for _, r := range result.Results {
+		if !r.Flagged {
+			continue
+		}
+		flagged := false
+		for name, hit := range r.Categories {
+			if !hit {
+				continue
+			}
+			flagged = true
+			if !slices.Contains(categories, name) {
+				categories = append(categories, name)
+			}
+		}
+		if !flagged && !slices.Contains(categories, "flagged") {
+			categories = append(categories, "flagged")
+		}
+	}
+	slices.Sort(categories)
+	return categories, nil
+}
+
+// moderationStatus is the moderation entry of /readyz.
+func moderationStatus(cfg *Config) gin.H {
+	return gin.H{
+		"enabled":          cfg.Moderation.Enabled,
+		"rules":            len(cfg.Moderation.Rules),
+		"provider":         cfg.Moderation.APIURL != "",
+		"rejected_total":   moderationStats.rejected.Load(),
+		"api_errors_total": moderationStats.apiErrors.Load(),
+	}
+}
@@ -0,0 +1,152 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"gateway/internal/testsupport"
+)
+
+// fakeModerationAPI flags inputs containing "attack" as violence.
+func fakeModerationAPI(t *testing.T, status int) *httptest.Server {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if status != http.StatusOK {
+			w.WriteHeader(status)
+			return
+		}
+		var req struct {
+			Input []string `json:"input"`
+		}
+		_ = json.NewDecoder(r.Body).Decode(&req)
+		type result struct {
+			Flagged    bool            `json:"flagged"`
+			Categories map[string]bool `json:"categories"`
+		}
+		var results []result
+		for _, in := range req.Input {
+			hit := strings.Contains(in, "attack")
+			results = append(results, result{Flagged: hit, Categories: map[string]bool{"violence": hit, "hate": false}})
+		}
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{"results": results})
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func decodeRejection(t *testing.T, resp *http.Response) []string {
+	t.Helper()
+	var body struct {
+		Categories []string `json:"categories"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		t.Fatal(err)
+	}
+	return body.Categories
+}
+
+func TestModeration_KeywordRulesRejectBeforePayment(t *testing.T) {
+	t.Setenv("MODERATION_ENABLED", "true")
+	t.Setenv("MODERATION_KEYWORDS", "spam:buy now|free money, malware:ransomware")
+	h := testsupport.NewHarness(t, newTestRouter)
+
+	resp := h.Post(t, "/api/ai/summarize", `{"text":"FREE MONEY and ransomware kits"}`, "0xsig", "nonce")
+	if resp.StatusCode != http.StatusUnprocessableEntity {
+		t.Fatalf("expected 422, got %d", resp.StatusCode)
+	}
+	if got := decodeRejection(t, resp); !reflect.DeepEqual(got, []string{"malware", "spam"}) {
+		t.Errorf("unexpected categories %v", got)
+	}
+	if h.Verifier.Calls() != 0 || h.AI.Calls() != 0 {
+		t.Errorf("rejected input must not reach the verifier (%d) or provider (%d)", h.Verifier.Calls(), h.AI.Calls())
+	}
+
+	// Whole words only.
+	resp = h.Post(t, "/api/ai/summarize", `{"text":"the freemoneyfund annual report"}`, "0xsig", "nonce")
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("clean input: expected 200, got %d", resp.StatusCode)
+	}
+}
+
+func TestModeration_ProviderAPI(t *testing.T) {
+	t.Setenv("MODERATION_ENABLED", "true")
+	t.Setenv("MODERATION_API_URL", fakeModerationAPI(t, http.StatusOK).URL)
+	h := testsupport.NewHarness(t, newTestRouter)
+	withJobQueue(t)
+
+	resp := h.Post(t, "/api/ai/embed", `{"text":["hello","plan the attack"]}`, "0xsig", "nonce")
+	if resp.StatusCode != http.StatusUnprocessableEntity {
+		t.Fatalf("expected 422, got %d", resp.StatusCode)
+	}
+	if got := decodeRejection(t, resp); !reflect.DeepEqual(got, []string{"violence"}) {
+		t.Errorf("unexpected categories %v", got)
+	}
+	if h.Verifier.Calls() != 0 {
+		t.Error("the nonce must not be sent to the verifier")
+	}
+
+	resp = h.Post(t, "/api/ai/jobs", `{"text":"attack at dawn"}`, "0xsig", "nonce")
+	if resp.StatusCode != http.StatusUnprocessableEntity {
+		t.Errorf("jobs: expected 422, got %d", resp.StatusCode)
+	}
+}
+
+func TestModeration_ProviderFailure(t *testing.T) {
+	t.Setenv("MODERATION_ENABLED", "true")
+	t.Setenv("MODERATION_API_URL", fakeModerationAPI(t, http.StatusInternalServerError).URL)
+	h := testsupport.NewHarness(t, newTestRouter)
+
+	resp := h.Post(t, "/api/ai/summarize", `{"text":"hello"}`, "0xsig", "nonce")
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("fail open: expected 200, got %d", resp.StatusCode)
+	}
+
+	t.Setenv("MODERATION_FAIL_OPEN", "false")
+	resp = h.Post(t, "/api/ai/summarize", `{"text":"hello"}`, "0xsig", "nonce")
+	if resp.StatusCode != http.StatusServiceUnavailable {
+		t.Errorf("fail closed: expected 503, got %d", resp.StatusCode)
+	}
+}
+
+func TestModeration_DisabledByDefault(t *testing.T) {
+	t.Setenv("MODERATION_KEYWORDS", "spam:buy now")
+	h := testsupport.NewHarness(t, newTestRouter)
+	resp := h.Post(t, "/api/ai/summarize", `{"text":"buy now"}`, "0xsig", "nonce")
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("expected 200 with moderation disabled, got %d", resp.StatusCode)
+	}
+}
+
+func TestLoadModerationConfig_RulesFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "rules.txt")
+	content := "# secrets\ncredentials (?i)api[_-]?key\\s*[:=]\n\n"
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("MODERATION_RULES_FILE", path)
+	mc, err := loadModerationConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := matchModerationRules(mc.Rules, []string{"my API_KEY= abc"}); !reflect.DeepEqual(got, []string{"credentials"}) {
+		t.Errorf("expected credentials match, got %v", got)
+	}
+
+	if err := os.WriteFile(path, []byte("broken (unclosed\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := loadConfig().Validate(); err == nil {
+		t.Error("an invalid regexp should fail validation")
+	}
+	t.Setenv("MODERATION_RULES_FILE", "")
+	t.Setenv("MODERATION_KEYWORDS", "spam:|")
+	if _, err := loadModerationConfig(); err == nil {
+		t.Error("a keyword rule without words should be rejected")
+	}
+}
@@ -0,0 +1,64 @@
+package server
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// spentNonceKeyPrefix prefixes the Redis keys of spent payment nonces.
+const spentNonceKeyPrefix = "payment:nonce:"
+
+var (
+	spentNoncesMu      sync.Mutex
+	spentNonces        = make(map[string]time.Time)
+	spentNoncesSweptAt time.Time
+)
+
+// getNonceTTL returns how long a spent payment nonce is remembered
+// (NONCE_TTL_SECONDS, default 24h). A signature replayed after that is
+// accepted again, so it should outlive any quote a payer may still hold.
+func getNonceTTL() time.Duration {
+	return time.Duration(getEnvAsInt("NONCE_TTL_SECONDS", 86400)) * time.Second
+}
+
+// claimNonce atomically marks nonce as spent, reporting whether this call
+// was the first to spend it. With Redis connected the claim is a SET NX
+// shared by every replica, so a nonce spent on one instance is refused on
+// all others; without it nonces are only tracked per process.
+func claimNonce(ctx context.Context, nonce string, ttl time.Duration) (bool, error) {
+	if redisClient != nil {
+		return redisClient.SetNX(ctx, spentNonceKeyPrefix+nonce, time.Now().Unix(), ttl).Result()
+	}
+
+	spentNoncesMu.Lock()
+	defer spentNoncesMu.Unlock()
+	now := time.Now()
+	if expiresAt, ok := spentNonces[nonce]; ok && now.Before(expiresAt) {
+		return false, nil
+	}
+	if now.Sub(spentNoncesSweptAt) > time.Minute {
+		for n, expiresAt := range spentNonces {
+			if !now.Before(expiresAt) {
+				delete(spentNonces, n)
+			}
+		}
+		spentNoncesSweptAt = now
+	}
+	spentNonces[nonce] = now.Add(ttl)
+	return true, nil
+}
+
+// nonceSpent reports whether nonce has been spent and is still remembered,
+// without claiming it.
+func nonceSpent(ctx context.Context, nonce string) (bool, error) {
+	if redisClient != nil {
+		n, err := redisClient.Exists(ctx, spentNonceKeyPrefix+nonce).Result()
+		return n > 0, err
+	}
+
+	spentNoncesMu.Lock()
+	defer spentNoncesMu.Unlock()
+	expiresAt, ok := spentNonces[nonce]
+	return ok && time.Now().Before(expiresAt), nil
+}
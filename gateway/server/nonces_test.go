@@ -0,0 +1,72 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"gateway/internal/testsupport"
+)
+
+func TestClaimNonce_InMemory(t *testing.T) {
+	ctx := context.Background()
+	if ok, err := claimNonce(ctx, "nonce-claim", time.Hour); !ok || err != nil {
+		t.Fatalf("expected the first claim to succeed, got %v, %v", ok, err)
+	}
+	if ok, _ := claimNonce(ctx, "nonce-claim", time.Hour); ok {
+		t.Error("expected a spent nonce to be refused")
+	}
+
+	if ok, _ := claimNonce(ctx, "nonce-short", time.Millisecond); !ok {
+		t.Fatal("expected the first claim to succeed")
+	}
+	time.Sleep(5 * time.Millisecond)
+	if ok, _ := claimNonce(ctx, "nonce-short", time.Hour); !ok {
+		t.Error("expected a nonce to be claimable again once its TTL passed")
+	}
+}
+
+func TestSummarize_ReplayedNonceRejected(t *testing.T) {
+	h := testsupport.NewHarness(t, newTestRouter)
+	paidReceipt(t, h, "nonce-replayed")
+
+	resp := h.Post(t, "/api/ai/summarize", `{"text":"hello again"}`, "0xsig", "nonce-replayed")
+	if resp.StatusCode != http.StatusConflict {
+		t.Fatalf("expected 409 for a replayed nonce, got %d", resp.StatusCode)
+	}
+	var body struct {
+		Code string `json:"code"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil || body.Code != string(CodeNonceReplayed) {
+		t.Errorf("expected %s, got %+v (%v)", CodeNonceReplayed, body, err)
+	}
+	if h.AI.Calls() != 1 {
+		t.Errorf("expected the replay not to reach the provider, got %d calls", h.AI.Calls())
+	}
+}
+
+func TestNonce_SpentOnOneReplicaRefusedOnAnother(t *testing.T) {
+	gw := startGateway(t, nil)
+	if resp := gw.Post(t, "/api/ai/summarize", `{"text":"hello"}`, "0xsig", "nonce-shared"); resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected 200, got %d", resp.StatusCode)
+	}
+	if keys := gw.redisKeys(spentNonceKeyPrefix); len(keys) != 1 {
+		t.Fatalf("expected the spent nonce in Redis, got %v", keys)
+	}
+
+	// A second replica has no local record of the nonce.
+	replica := newTestRouter()
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodPost, "/api/ai/summarize", strings.NewReader(`{"text":"hello"}`))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-402-Signature", "0xsig")
+	req.Header.Set("X-402-Nonce", "nonce-shared")
+	replica.ServeHTTP(w, req)
+	if w.Code != http.StatusConflict {
+		t.Errorf("expected the other replica to refuse the spent nonce, got %d: %s", w.Code, w.Body)
+	}
+}
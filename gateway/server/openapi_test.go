@@ -1,4 +1,4 @@
-package main
+package server
 
 import (
 	"os"
@@ -19,6 +19,8 @@ func TestOpenAPISpecMatchesRoutes(t *testing.T) {
 	expectedPaths := []string{
 		"/healthz",
 		"/api/ai/summarize",
+		"/.well-known/paygate-configuration",
+		"/.well-known/paygate-keys",
 	}
 
 	for _, path := range expectedPaths {
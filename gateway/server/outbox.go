@@ -0,0 +1,512 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// Outbox event kinds.
+const (
+	outboxReceiptArchive = "receipt.archive"
+	outboxJobWebhook     = "job.webhook"
+)
+
+// OutboxEvent is a side effect of a receipt or job, recorded in the outbox
+// so it is delivered even if the process dies before it ran. Key is the ID
+// of the receipt or job it belongs to.
+type OutboxEvent struct {
+	ID        int64
+	Kind      string
+	Key       string
+	Payload   []byte
+	Attempts  int
+	CreatedAt time.Time
+}
+
+// outboxStore is the durable outbox table. SaveReceipt writes a receipt and
+// its events in one transaction. Claim leases due events for delivery so
+// replicas sharing the table do not deliver the same event concurrently; an
+// event whose lease runs out before it is completed or retried is claimed
+// again.
+type outboxStore interface {
+	SaveReceipt(ctx context.Context, receipt *SignedReceipt, events []OutboxEvent) error
+	Enqueue(ctx context.Context, events []OutboxEvent) error
+	Claim(ctx context.Context, now time.Time, lease time.Duration, limit int) ([]OutboxEvent, error)
+	Complete(ctx context.Context, id int64) error
+	Retry(ctx context.Context, id int64, next time.Time, lastErr string, dead bool) error
+	Close() error
+}
+
+// outboxRetryDelay is the wait before an event's first retry; it doubles
+// with each attempt up to outboxMaxRetryDelay.
+var (
+	outboxRetryDelay    = 2 * time.Second
+	outboxMaxRetryDelay = 10 * time.Minute
+)
+
+// outboxHandler delivers one event; an error schedules a retry.
+type outboxHandler func(ctx context.Context, ev OutboxEvent) error
+
+// outbox drains the outbox table in the background. Events are removed
+// only after their handler succeeded, so delivery is at least once:
+// handlers must tolerate seeing an event again after a crash.
+type outbox struct {
+	store       outboxStore
+	handlers    map[string]outboxHandler
+	interval    time.Duration
+	lease       time.Duration
+	maxAttempts int
+	wake        chan struct{}
+	cancel      context.CancelFunc
+	done        chan struct{}
+}
+
+// activeOutbox is the running outbox; nil when OUTBOX_DATABASE_URL is unset,
+// in which case side effects run directly as before.
+var activeOutbox atomic.Pointer[outbox]
+
+// newOutboxFromEnv opens the outbox table from OUTBOX_DATABASE_DRIVER and
+// OUTBOX_DATABASE_URL. It returns nil when no database is configured.
+func newOutboxFromEnv(ctx context.Context) (*outbox, error) {
+	dsn := os.Getenv("OUTBOX_DATABASE_URL")
+	if dsn == "" {
+		return nil, nil
+	}
+	store, err := openSQLOutbox(ctx, getEnv("OUTBOX_DATABASE_DRIVER", "postgres"), dsn)
+	if err != nil {
+		return nil, err
+	}
+	return newOutbox(store,
+		time.Duration(max(getEnvAsInt("OUTBOX_POLL_INTERVAL_MS", 1000), 10))*time.Millisecond,
+		max(getEnvAsInt("OUTBOX_MAX_ATTEMPTS", 10), 1),
+	), nil
+}
+
+func newOutbox(store outboxStore, interval time.Duration, maxAttempts int) *outbox {
+	return &outbox{
+		store: store,
+		handlers: map[string]outboxHandler{
+			outboxReceiptArchive:   deliverReceiptArchive,
+			outboxJobWebhook:       deliverJobWebhook,
+			outboxPayloadRetention: deliverPayloadRetention,
+			outboxERC3009Relay:     deliverERC3009Relay,
+		},
+		interval:    interval,
+		lease:       time.Minute,
+		maxAttempts: maxAttempts,
+		wake:        make(chan struct{}, 1),
+		done:        make(chan struct{}),
+	}
+}
+
+// Start launches the dispatcher.
+func (o *outbox) Start() {
+	ctx, cancel := context.WithCancel(context.Background())
+	o.cancel = cancel
+	go o.run(ctx)
+}
+
+// Close stops the dispatcher, waiting for the current batch unless ctx
+// expires, and closes the store. Undelivered events stay in the table.
+func (o *outbox) Close(ctx context.Context) error {
+	if o.cancel != nil {
+		o.cancel()
+		select {
+		case <-o.done:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	return o.store.Close()
+}
+
+func (o *outbox) run(ctx context.Context) {
+	defer close(o.done)
+	ticker := time.NewTicker(o.interval)
+	defer ticker.Stop()
+	for {
+		// Keep draining while full batches come back.
+		for o.drain(ctx) == outboxBatchSize && ctx.Err() == nil {
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		case <-o.wake:
+		}
+	}
+}
+
+// outboxBatchSize is the number of events claimed at a time.
+const outboxBatchSize = 50
+
+// drain delivers one batch of due events and returns how many it claimed.
+func (o *outbox) drain(ctx context.Context) int {
+	events, err := o.store.Claim(ctx, time.Now(), o.lease, outboxBatchSize)
+	if err != nil {
+		if ctx.Err() == nil {
+			log.Printf("[WARNING] Outbox claim failed: %v", err)
+		}
+		return 0
+	}
+	for _, ev := range events {
+		o.deliver(ctx, ev)
+	}
+	return len(events)
+}
+
+// deliver runs ev's handler and completes it or schedules a retry. After
+// maxAttempts failures the event is kept as dead for inspection.
+func (o *outbox) deliver(ctx context.Context, ev OutboxEvent) {
+	handler, ok := o.handlers[ev.Kind]
+	err := fmt.Errorf("no handler for %q", ev.Kind)
+	if ok {
+		err = handler(ctx, ev)
+	}
+	if err == nil {
+		if err := o.store.Complete(ctx, ev.ID); err != nil {
+			log.Printf("[WARNING] Outbox event %d delivered but not completed, it will be redelivered: %v", ev.ID, err)
+		}
+		return
+	}
+	if ctx.Err() != nil {
+		// Shutting down; the lease runs out and the event is claimed again.
+		return
+	}
+	attempts := ev.Attempts + 1
+	dead := attempts >= o.maxAttempts
+	if dead {
+		log.Printf("[WARNING] Outbox event %d (%s %s) failed %d times, giving up: %v", ev.ID, ev.Kind, ev.Key, attempts, err)
+	} else {
+		log.Printf("[WARNING] Outbox event %d (%s %s) failed, retrying: %v", ev.ID, ev.Kind, ev.Key, err)
+	}
+	next := time.Now().Add(outboxBackoff(attempts))
+	if err := o.store.Retry(ctx, ev.ID, next, err.Error(), dead); err != nil {
+		log.Printf("[WARNING] Outbox event %d retry not recorded: %v", ev.ID, err)
+	}
+}
+
+// outboxBackoff returns the delay before retry number attempts.
+func outboxBackoff(attempts int) time.Duration {
+	delay := outboxRetryDelay
+	for i := 1; i < attempts && delay < outboxMaxRetryDelay; i++ {
+		delay *= 2
+	}
+	return min(delay, outboxMaxRetryDelay)
+}
+
+// notify wakes the dispatcher after new events were written.
+func (o *outbox) notify() {
+	select {
+	case o.wake <- struct{}{}:
+	default:
+	}
+}
+
+// persistReceipt runs the durable side effects of a stored receipt. With
+// the outbox enabled the receipt and its IPFS archival event are written in
+// one transaction before the response is sent, and an error means neither
+// was; the CID is then recorded once the dispatcher pinned the receipt.
+// Without it the receipt is archived directly and the CID returned when
+// the pin succeeded at once.
+func persistReceipt(ctx context.Context, receipt *SignedReceipt) (string, error) {
+	o := activeOutbox.Load()
+	if o == nil {
+		return archiveReceipt(ctx, receipt), nil
+	}
+	var events []OutboxEvent
+	if os.Getenv("RECEIPT_IPFS_API_URL") != "" {
+		data, err := json.Marshal(receipt)
+		if err != nil {
+			return "", err
+		}
+		events = append(events, OutboxEvent{Kind: outboxReceiptArchive, Key: receipt.Receipt.ID, Payload: data})
+	}
+	if err := o.store.SaveReceipt(ctx, receipt, events); err != nil {
+		return "", fmt.Errorf("failed to write receipt to outbox: %w", err)
+	}
+	if len(events) > 0 {
+		o.notify()
+	}
+	return "", nil
+}
+
+// deliverReceiptArchive pins a receipt to IPFS. Pinning the same content
+// twice yields the same CID, so redelivery is harmless.
+func deliverReceiptArchive(ctx context.Context, ev OutboxEvent) error {
+	pinner := newIPFSPinner()
+	if pinner == nil {
+		// Archival was switched off since the event was written.
+		return nil
+	}
+	cid, err := pinner.Pin(ctx, ev.Key+".json", ev.Payload)
+	if err != nil {
+		return err
+	}
+	setReceiptCID(ev.Key, cid)
+	return nil
+}
+
+// jobWebhookEvent is the payload of a job.webhook event.
+type jobWebhookEvent struct {
+	URL string          `json:"url"`
+	Job json.RawMessage `json:"job"`
+}
+
+// enqueueJobWebhook records the webhook for a finished job in the outbox.
+func enqueueJobWebhook(ctx context.Context, o *outbox, webhookURL string, job Job) error {
+	body, err := json.Marshal(job)
+	if err != nil {
+		return err
+	}
+	payload, err := json.Marshal(jobWebhookEvent{URL: webhookURL, Job: body})
+	if err != nil {
+		return err
+	}
+	if err := o.store.Enqueue(ctx, []OutboxEvent{{Kind: outboxJobWebhook, Key: job.ID, Payload: payload}}); err != nil {
+		return err
+	}
+	o.notify()
+	return nil
+}
+
+// deliverJobWebhook POSTs a finished job to its webhook. Receivers can
+// detect redeliveries by X-Paygate-Job-ID.
+func deliverJobWebhook(ctx context.Context, ev OutboxEvent) error {
+	var hook jobWebhookEvent
+	if err := json.Unmarshal(ev.Payload, &hook); err != nil {
+		return fmt.Errorf("invalid webhook event: %w", err)
+	}
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, hook.URL, bytes.NewReader(hook.Job))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Paygate-Job-ID", ev.Key)
+	req.Header.Set("X-Paygate-Delivery-Attempt", strconv.Itoa(ev.Attempts+1))
+	resp, err := webhookHTTPClient().Do(req)
+	if err != nil {
+		return err
+	}
+	io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook answered %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// sqlOutbox keeps the outbox in Postgres or SQLite through database/sql.
+// Timestamps are Unix milliseconds so the schema is the same for both.
+type sqlOutbox struct {
+	db       *sql.DB
+	postgres bool
+}
+
+// openSQLOutbox connects to dsn with the driver linked in outbox_drivers.go
+// for driver ("postgres" or "pgx" for Postgres, "sqlite" or "sqlite3" for
+// SQLite) and creates the tables if needed.
+func openSQLOutbox(ctx context.Context, driver, dsn string) (*sqlOutbox, error) {
+	var name string
+	var postgres bool
+	switch driver {
+	case "postgres", "pgx":
+		name, postgres = postgresDriver, true
+	case "sqlite", "sqlite3":
+		name = sqliteDriver
+	default:
+		return nil, fmt.Errorf("OUTBOX_DATABASE_DRIVER must be postgres, pgx, sqlite or sqlite3, got %q", driver)
+	}
+	db, err := sql.Open(name, dsn)
+	if err != nil {
+		return nil, fmt.Errorf("outbox database: %w", err)
+	}
+	if !postgres {
+		// SQLite allows one writer; a single connection avoids SQLITE_BUSY.
+		db.SetMaxOpenConns(1)
+	}
+	s, err := newSQLOutbox(ctx, db, postgres)
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+// newSQLOutbox keeps the outbox in db, creating the tables if needed.
+func newSQLOutbox(ctx context.Context, db *sql.DB, postgres bool) (*sqlOutbox, error) {
+	s := &sqlOutbox{db: db, postgres: postgres}
+	for _, stmt := range s.schema() {
+		if _, err := db.ExecContext(ctx, stmt); err != nil {
+			return nil, fmt.Errorf("outbox schema: %w", err)
+		}
+	}
+	return s, nil
+}
+
+// schema returns the statements creating the receipt and outbox tables.
+func (s *sqlOutbox) schema() []string {
+	id := "INTEGER PRIMARY KEY AUTOINCREMENT"
+	if s.postgres {
+		id = "BIGSERIAL PRIMARY KEY"
+	}
+	return []string{
+		`CREATE TABLE IF NOT EXISTS paygate_receipts (
+			id TEXT PRIMARY KEY,
+			payer TEXT NOT NULL,
+			created_at BIGINT NOT NULL,
+			body TEXT NOT NULL
+		)`,
+		`CREATE TABLE IF NOT EXISTS paygate_outbox (
+			id ` + id + `,
+			kind TEXT NOT NULL,
+			event_key TEXT NOT NULL,
+			payload TEXT NOT NULL,
+			status TEXT NOT NULL DEFAULT 'pending',
+			attempts INTEGER NOT NULL DEFAULT 0,
+			next_attempt_at BIGINT NOT NULL,
+			locked_until BIGINT NOT NULL DEFAULT 0,
+			last_error TEXT NOT NULL DEFAULT '',
+			created_at BIGINT NOT NULL
+		)`,
+		`CREATE INDEX IF NOT EXISTS paygate_outbox_due ON paygate_outbox (status, next_attempt_at)`,
+	}
+}
+
+// rebind rewrites ? placeholders as $1, $2, ... for Postgres.
+func (s *sqlOutbox) rebind(query string) string {
+	if !s.postgres {
+		return query
+	}
+	var b strings.Builder
+	n := 0
+	for _, r := range query {
+		if r == '?' {
+			n++
+			b.WriteString("$" + strconv.Itoa(n))
+			continue
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+// execer is satisfied by *sql.DB and *sql.Tx.
+type execer interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+}
+
+func (s *sqlOutbox) insertEvents(ctx context.Context, db execer, events []OutboxEvent) error {
+	now := time.Now().UnixMilli()
+	query := s.rebind(`INSERT INTO paygate_outbox (kind, event_key, payload, next_attempt_at, created_at) VALUES (?, ?, ?, ?, ?)`)
+	for _, ev := range events {
+		if _, err := db.ExecContext(ctx, query, ev.Kind, ev.Key, string(ev.Payload), now, now); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *sqlOutbox) SaveReceipt(ctx context.Context, receipt *SignedReceipt, events []OutboxEvent) error {
+	body, err := json.Marshal(receipt)
+	if err != nil {
+		return err
+	}
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if _, err := tx.ExecContext(ctx, s.rebind(`INSERT INTO paygate_receipts (id, payer, created_at, body) VALUES (?, ?, ?, ?)`),
+		receipt.Receipt.ID, strings.ToLower(receipt.Receipt.Payment.Payer), time.Now().UnixMilli(), string(body)); err != nil {
+		return err
+	}
+	if err := s.insertEvents(ctx, tx, events); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (s *sqlOutbox) Enqueue(ctx context.Context, events []OutboxEvent) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if err := s.insertEvents(ctx, tx, events); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (s *sqlOutbox) Claim(ctx context.Context, now time.Time, lease time.Duration, limit int) ([]OutboxEvent, error) {
+	nowMs := now.UnixMilli()
+	rows, err := s.db.QueryContext(ctx, s.rebind(`SELECT id, kind, event_key, payload, attempts, created_at FROM paygate_outbox
+		WHERE status = 'pending' AND next_attempt_at <= ? AND locked_until <= ? ORDER BY id LIMIT ?`), nowMs, nowMs, limit)
+	if err != nil {
+		return nil, err
+	}
+	var candidates []OutboxEvent
+	for rows.Next() {
+		var ev OutboxEvent
+		var payload string
+		var created int64
+		if err := rows.Scan(&ev.ID, &ev.Kind, &ev.Key, &payload, &ev.Attempts, &created); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		ev.Payload, ev.CreatedAt = []byte(payload), time.UnixMilli(created)
+		candidates = append(candidates, ev)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	// Lease each event with a conditional update; another replica may have
+	// claimed it between the select and here.
+	claim := s.rebind(`UPDATE paygate_outbox SET locked_until = ? WHERE id = ? AND status = 'pending' AND locked_until <= ?`)
+	claimed := candidates[:0]
+	for _, ev := range candidates {
+		res, err := s.db.ExecContext(ctx, claim, now.Add(lease).UnixMilli(), ev.ID, nowMs)
+		if err != nil {
+			return claimed, err
+		}
+		if n, _ := res.RowsAffected(); n == 1 {
+			claimed = append(claimed, ev)
+		}
+	}
+	return claimed, nil
+}
+
+func (s *sqlOutbox) Complete(ctx context.Context, id int64) error {
+	_, err := s.db.ExecContext(ctx, s.rebind(`DELETE FROM paygate_outbox WHERE id = ?`), id)
+	return err
+}
+
+func (s *sqlOutbox) Retry(ctx context.Context, id int64, next time.Time, lastErr string, dead bool) error {
+	status := "pending"
+	if dead {
+		status = "dead"
+	}
+	_, err := s.db.ExecContext(ctx, s.rebind(`UPDATE paygate_outbox SET attempts = attempts + 1, next_attempt_at = ?, locked_until = 0, last_error = ?, status = ? WHERE id = ?`),
+		next.UnixMilli(), lastErr, status, id)
+	return err
+}
+
+func (s *sqlOutbox) Close() error {
+	return s.db.Close()
+}
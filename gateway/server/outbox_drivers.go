@@ -0,0 +1,12 @@
+package server
+
+import (
+	_ "github.com/lib/pq"  // registers "postgres"
+	_ "modernc.org/sqlite" // registers "sqlite"
+)
+
+// database/sql driver names the outbox opens; see openSQLOutbox.
+const (
+	postgresDriver = "postgres"
+	sqliteDriver   = "sqlite"
+)
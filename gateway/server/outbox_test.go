@@ -0,0 +1,344 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"gateway/internal/testsupport"
+)
+
+// memoryOutbox is an outboxStore for tests.
+type memoryOutbox struct {
+	mu       sync.Mutex
+	nextID   int64
+	receipts map[string]*SignedReceipt
+	events   map[int64]*memoryOutboxEvent
+	saveErr  error
+}
+
+type memoryOutboxEvent struct {
+	OutboxEvent
+	next   time.Time
+	locked time.Time
+	dead   bool
+}
+
+func newMemoryOutbox() *memoryOutbox {
+	return &memoryOutbox{receipts: make(map[string]*SignedReceipt), events: make(map[int64]*memoryOutboxEvent)}
+}
+
+func (m *memoryOutbox) SaveReceipt(ctx context.Context, receipt *SignedReceipt, events []OutboxEvent) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.saveErr != nil {
+		return m.saveErr
+	}
+	m.receipts[receipt.Receipt.ID] = receipt
+	m.insert(events)
+	return nil
+}
+
+func (m *memoryOutbox) Enqueue(ctx context.Context, events []OutboxEvent) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.insert(events)
+	return nil
+}
+
+func (m *memoryOutbox) insert(events []OutboxEvent) {
+	for _, ev := range events {
+		m.nextID++
+		ev.ID, ev.CreatedAt = m.nextID, time.Now()
+		m.events[ev.ID] = &memoryOutboxEvent{OutboxEvent: ev}
+	}
+}
+
+func (m *memoryOutbox) Claim(ctx context.Context, now time.Time, lease time.Duration, limit int) ([]OutboxEvent, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var claimed []OutboxEvent
+	for id := int64(1); id <= m.nextID && len(claimed) < limit; id++ {
+		ev, ok := m.events[id]
+		if !ok || ev.dead || ev.next.After(now) || ev.locked.After(now) {
+			continue
+		}
+		ev.locked = now.Add(lease)
+		claimed = append(claimed, ev.OutboxEvent)
+	}
+	return claimed, nil
+}
+
+func (m *memoryOutbox) Complete(ctx context.Context, id int64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.events, id)
+	return nil
+}
+
+func (m *memoryOutbox) Retry(ctx context.Context, id int64, next time.Time, lastErr string, dead bool) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	ev := m.events[id]
+	ev.Attempts++
+	ev.next, ev.locked, ev.dead = next, time.Time{}, dead
+	return nil
+}
+
+func (m *memoryOutbox) Close() error { return nil }
+
+// pending returns the events not yet delivered, dead ones included.
+func (m *memoryOutbox) pending() []memoryOutboxEvent {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var out []memoryOutboxEvent
+	for _, ev := range m.events {
+		out = append(out, *ev)
+	}
+	return out
+}
+
+// withOutbox makes store the active outbox without starting its dispatcher;
+// tests drain it explicitly.
+func withOutbox(t *testing.T, store outboxStore) *outbox {
+	t.Helper()
+	o := newOutbox(store, time.Hour, 3)
+	prev := activeOutbox.Swap(o)
+	t.Cleanup(func() { activeOutbox.Store(prev) })
+	return o
+}
+
+func TestOutbox_ReceiptWrittenWithArchivalEvent(t *testing.T) {
+	withReceiptStore(t)
+	store := newMemoryOutbox()
+	o := withOutbox(t, store)
+	uploads := make(chan []byte, 1)
+	t.Setenv("RECEIPT_IPFS_API_URL", fakePinningAPI(t, 0, uploads).URL)
+	t.Setenv("RECEIPT_IPFS_API_TOKEN", "pin-token")
+	h := testsupport.NewHarness(t, newTestRouter)
+
+	resp := h.Post(t, "/api/ai/summarize", `{"text":"outbox"}`, "0xsig", "nonce-outbox")
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected 200, got %d", resp.StatusCode)
+	}
+	if resp.Header.Get("X-402-Receipt-CID") != "" {
+		t.Error("with the outbox the receipt is pinned after the response")
+	}
+	id := decodeReceiptHeader(t, resp.Header.Get("X-402-Receipt")).Receipt.ID
+	events := store.pending()
+	if store.receipts[id] == nil || len(events) != 1 || events[0].Kind != outboxReceiptArchive || events[0].Key != id {
+		t.Fatalf("expected the receipt and its archival event in the outbox, got %+v", events)
+	}
+
+	if n := o.drain(t.Context()); n != 1 {
+		t.Fatalf("expected one event delivered, got %d", n)
+	}
+	<-uploads
+	if getReceiptCID(id) != "bafytestcid" || len(store.pending()) != 0 {
+		t.Errorf("expected the receipt pinned and the event completed, CID %q", getReceiptCID(id))
+	}
+}
+
+func TestOutbox_WriteFailureFailsRequest(t *testing.T) {
+	store := newMemoryOutbox()
+	store.saveErr = errors.New("database is down")
+	withOutbox(t, store)
+	h := testsupport.NewHarness(t, newTestRouter)
+
+	resp := h.Post(t, "/api/ai/summarize", `{"text":"outbox down"}`, "0xsig", "nonce-outbox-down")
+	if body := decodeErrorBody(t, resp); resp.StatusCode != http.StatusInternalServerError || body.Code != CodeReceiptFailed {
+		t.Errorf("expected RECEIPT_FAILED, got %d %+v", resp.StatusCode, body)
+	}
+	if resp.Header.Get("X-402-Receipt") != "" {
+		t.Error("no receipt should be sent when it could not be written")
+	}
+}
+
+func TestOutbox_WebhookRetriedUntilDelivered(t *testing.T) {
+	prevDelay := outboxRetryDelay
+	outboxRetryDelay = time.Millisecond
+	t.Cleanup(func() { outboxRetryDelay = prevDelay })
+	t.Setenv("JOB_WEBHOOK_ALLOW_PRIVATE", "true")
+
+	var mu sync.Mutex
+	var attempts []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		attempts = append(attempts, r.Header.Get("X-Paygate-Delivery-Attempt"))
+		n := len(attempts)
+		mu.Unlock()
+		if r.Header.Get("X-Paygate-Job-ID") != "job_1" {
+			t.Errorf("missing job ID header")
+		}
+		if n == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer srv.Close()
+
+	store := newMemoryOutbox()
+	o := newOutbox(store, time.Hour, 3)
+	if err := enqueueJobWebhook(t.Context(), o, srv.URL, Job{ID: "job_1", Status: jobCompleted}); err != nil {
+		t.Fatal(err)
+	}
+	o.drain(t.Context())
+	if events := store.pending(); len(events) != 1 || events[0].Attempts != 1 || events[0].dead {
+		t.Fatalf("expected the failed webhook scheduled for retry, got %+v", events)
+	}
+	time.Sleep(5 * time.Millisecond)
+	o.drain(t.Context())
+	if len(store.pending()) != 0 || strings.Join(attempts, ",") != "1,2" {
+		t.Errorf("expected delivery on the second attempt, got attempts %v", attempts)
+	}
+}
+
+func TestOutbox_GivesUpAfterMaxAttempts(t *testing.T) {
+	prevDelay := outboxRetryDelay
+	outboxRetryDelay = 0
+	t.Cleanup(func() { outboxRetryDelay = prevDelay })
+
+	store := newMemoryOutbox()
+	o := newOutbox(store, time.Hour, 2)
+	store.Enqueue(t.Context(), []OutboxEvent{{Kind: "unknown.kind", Key: "k"}})
+	o.drain(t.Context())
+	o.drain(t.Context())
+	o.drain(t.Context())
+	if events := store.pending(); len(events) != 1 || !events[0].dead || events[0].Attempts != 2 {
+		t.Errorf("expected the event kept as dead after 2 attempts, got %+v", events)
+	}
+}
+
+func TestOutboxBackoff(t *testing.T) {
+	for attempts, want := range map[int]time.Duration{1: 2 * time.Second, 2: 4 * time.Second, 4: 16 * time.Second, 30: 10 * time.Minute} {
+		if got := outboxBackoff(attempts); got != want {
+			t.Errorf("attempt %d: expected %v, got %v", attempts, want, got)
+		}
+	}
+}
+
+func TestSQLOutbox_Dialects(t *testing.T) {
+	pg := &sqlOutbox{postgres: true}
+	if got := pg.rebind(`UPDATE t SET a = ? WHERE id = ? AND b <= ?`); got != `UPDATE t SET a = $1 WHERE id = $2 AND b <= $3` {
+		t.Errorf("unexpected postgres query %q", got)
+	}
+	if !strings.Contains(pg.schema()[1], "BIGSERIAL") {
+		t.Error("expected a BIGSERIAL key on postgres")
+	}
+	lite := &sqlOutbox{}
+	if got := lite.rebind(`SELECT ?`); got != `SELECT ?` {
+		t.Errorf("sqlite queries should keep ? placeholders, got %q", got)
+	}
+	if !strings.Contains(lite.schema()[1], "AUTOINCREMENT") {
+		t.Error("expected an AUTOINCREMENT key on sqlite")
+	}
+
+	if _, err := openSQLOutbox(t.Context(), "mysql", "dsn"); err == nil {
+		t.Error("expected an unsupported driver to be rejected")
+	}
+}
+
+// openTestSQLOutbox opens an SQLite outbox in a temporary file.
+func openTestSQLOutbox(t *testing.T) *sqlOutbox {
+	t.Helper()
+	store, err := openSQLOutbox(t.Context(), "sqlite3", filepath.Join(t.TempDir(), "outbox.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { store.Close() })
+	return store
+}
+
+func TestSQLOutbox_ClaimCompleteRetry(t *testing.T) {
+	store := openTestSQLOutbox(t)
+	ctx := t.Context()
+	receipt := &SignedReceipt{Receipt: Receipt{ID: "rcpt_sql", Payment: PaymentDetails{Payer: "0xABC"}}}
+	if err := store.SaveReceipt(ctx, receipt, []OutboxEvent{{Kind: outboxReceiptArchive, Key: "rcpt_sql", Payload: []byte(`{"id":"rcpt_sql"}`)}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Enqueue(ctx, []OutboxEvent{{Kind: outboxJobWebhook, Key: "job-1", Payload: []byte(`{}`)}}); err != nil {
+		t.Fatal(err)
+	}
+	var payer string
+	if err := store.db.QueryRowContext(ctx, `SELECT payer FROM paygate_receipts WHERE id = ?`, "rcpt_sql").Scan(&payer); err != nil || payer != "0xabc" {
+		t.Errorf("expected the receipt row with a lowercased payer, got %q (%v)", payer, err)
+	}
+
+	now := time.Now()
+	events, err := store.Claim(ctx, now, time.Minute, 10)
+	if err != nil || len(events) != 2 || events[0].Key != "rcpt_sql" || string(events[0].Payload) != `{"id":"rcpt_sql"}` || events[1].Kind != outboxJobWebhook {
+		t.Fatalf("expected both events claimed in order, got %+v (%v)", events, err)
+	}
+	if again, _ := store.Claim(ctx, now.Add(time.Second), time.Minute, 10); len(again) != 0 {
+		t.Errorf("expected leased events not to be claimed again, got %+v", again)
+	}
+	if expired, _ := store.Claim(ctx, now.Add(2*time.Minute), time.Minute, 10); len(expired) != 2 {
+		t.Errorf("expected events claimed again after the lease, got %+v", expired)
+	}
+
+	if err := store.Complete(ctx, events[0].ID); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Retry(ctx, events[1].ID, now.Add(time.Hour), "boom", false); err != nil {
+		t.Fatal(err)
+	}
+	if due, _ := store.Claim(ctx, now.Add(30*time.Minute), time.Minute, 10); len(due) != 0 {
+		t.Errorf("expected nothing due before the retry time, got %+v", due)
+	}
+	retried, err := store.Claim(ctx, now.Add(2*time.Hour), time.Minute, 10)
+	if err != nil || len(retried) != 1 || retried[0].ID != events[1].ID || retried[0].Attempts != 1 {
+		t.Fatalf("expected the retried event with one attempt, got %+v (%v)", retried, err)
+	}
+	if err := store.Retry(ctx, retried[0].ID, now, "boom", true); err != nil {
+		t.Fatal(err)
+	}
+	if dead, _ := store.Claim(ctx, now.Add(3*time.Hour), time.Minute, 10); len(dead) != 0 {
+		t.Errorf("expected dead events never to be claimed, got %+v", dead)
+	}
+}
+
+func TestSQLOutbox_DeliversJobWebhook(t *testing.T) {
+	t.Setenv("JOB_WEBHOOK_ALLOW_PRIVATE", "true")
+	received := make(chan string, 1)
+	hook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received <- r.Header.Get("X-Paygate-Job-ID")
+	}))
+	defer hook.Close()
+
+	store := openTestSQLOutbox(t)
+	o := newOutbox(store, time.Hour, 3)
+	if err := enqueueJobWebhook(t.Context(), o, hook.URL, Job{ID: "job-sql", Status: jobCompleted}); err != nil {
+		t.Fatal(err)
+	}
+	if n := o.drain(t.Context()); n != 1 {
+		t.Fatalf("expected one event delivered, got %d", n)
+	}
+	if id := <-received; id != "job-sql" {
+		t.Errorf("expected the webhook for job-sql, got %q", id)
+	}
+	if left, _ := store.Claim(t.Context(), time.Now().Add(time.Hour), time.Minute, 10); len(left) != 0 {
+		t.Errorf("expected the delivered event removed, got %+v", left)
+	}
+}
+
+func TestDeliverJobWebhook_RefusesPrivateAddresses(t *testing.T) {
+	hits := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits++
+	}))
+	defer srv.Close()
+
+	payload, _ := json.Marshal(jobWebhookEvent{URL: srv.URL, Job: json.RawMessage(`{}`)})
+	if err := deliverJobWebhook(t.Context(), OutboxEvent{Kind: "job.webhook", Key: "job_1", Payload: payload}); err == nil {
+		t.Error("expected delivery to a loopback address to fail")
+	}
+	if hits != 0 {
+		t.Errorf("the webhook should not have been dialled, got %d requests", hits)
+	}
+}
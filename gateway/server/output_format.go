@@ -0,0 +1,106 @@
+package server
+
+import (
+	"encoding/json"
+	"strings"
+
+	"gateway/receipts"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Summary output formats accepted in the output_format field.
+const (
+	outputFormatJSON     = "json"
+	outputFormatText     = "text"
+	outputFormatMarkdown = "markdown"
+)
+
+// outputFormatAliases maps accepted spellings of an output format to its name.
+var outputFormatAliases = map[string]string{
+	"json":          outputFormatJSON,
+	"text":          outputFormatText,
+	"plain":         outputFormatText,
+	"plaintext":     outputFormatText,
+	"markdown":      outputFormatMarkdown,
+	"md":            outputFormatMarkdown,
+	"text/plain":    outputFormatText,
+	"text/markdown": outputFormatMarkdown,
+}
+
+// outputContentTypes is the Content-Type a summary is sent with per format.
+var outputContentTypes = map[string]string{
+	outputFormatJSON:     "application/json; charset=utf-8",
+	outputFormatText:     "text/plain; charset=utf-8",
+	outputFormatMarkdown: "text/markdown; charset=utf-8",
+}
+
+// acceptOutputFormat returns the format of the first media type in accept
+// that a summary can be sent as. JSON is the default, including for */*.
+func acceptOutputFormat(accept string) string {
+	for _, part := range strings.Split(strings.ToLower(accept), ",") {
+		mediaType, _, _ := strings.Cut(part, ";")
+		switch strings.TrimSpace(mediaType) {
+		case "application/json", "application/*", "*/*":
+			return outputFormatJSON
+		case "text/plain":
+			return outputFormatText
+		case "text/markdown":
+			return outputFormatMarkdown
+		}
+	}
+	return outputFormatJSON
+}
+
+// negotiateOutputFormat stores the format the summary is sent in: the
+// request's output_format field, case-insensitively, or else the Accept
+// header. It is a no-op when the cache middleware already ran it. On an
+// unknown output_format it aborts with 400.
+func negotiateOutputFormat(c *gin.Context, field string) bool {
+	if _, ok := c.Get("output_format"); ok {
+		return true
+	}
+	format := acceptOutputFormat(c.GetHeader("Accept"))
+	if field = strings.ToLower(strings.TrimSpace(field)); field != "" {
+		var ok bool
+		if format, ok = outputFormatAliases[field]; !ok {
+			abortWithError(c, CodeInvalidRequest, "output_format must be one of json, text or markdown")
+			return false
+		}
+	}
+	c.Set("output_format", format)
+	c.Header("Vary", "Accept")
+	return true
+}
+
+// outputFormat is the format negotiated for the request, JSON by default.
+func outputFormat(c *gin.Context) string {
+	if format := c.GetString("output_format"); format != "" {
+		return format
+	}
+	return outputFormatJSON
+}
+
+// encodeSummary renders resp in format. JSON is the canonical envelope; text
+// and markdown are the summary itself, with the receipt ID and correlation
+// ID left to the X-402-Receipt and X-Correlation-ID headers.
+func encodeSummary(resp SummaryResponse, format string) (contentType string, body []byte, err error) {
+	if format == outputFormatJSON {
+		body, err = receipts.EncodeResponse(resp)
+		return outputContentTypes[format], body, err
+	}
+	return outputContentTypes[format], []byte(resp.Result), nil
+}
+
+// decodeSummary is the summary in a body encodeSummary rendered in format.
+func decodeSummary(body []byte, format string) (string, bool) {
+	if format != outputFormatJSON {
+		return string(body), true
+	}
+	var resp map[string]interface{}
+	if err := json.Unmarshal(body, &resp); err != nil {
+		return "", false
+	}
+	result, ok := resp["result"].(string)
+	return result, ok
+}
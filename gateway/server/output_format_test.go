@@ -0,0 +1,132 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"gateway/internal/testsupport"
+	"gateway/receipts"
+)
+
+func TestAcceptOutputFormat(t *testing.T) {
+	tests := []struct {
+		accept string
+		want   string
+	}{
+		{"", outputFormatJSON},
+		{"*/*", outputFormatJSON},
+		{"text/plain", outputFormatText},
+		{"text/markdown; charset=utf-8", outputFormatMarkdown},
+		{"application/jose, text/plain;q=0.9", outputFormatText},
+		{"application/json, text/plain", outputFormatJSON},
+		{"text/html, */*;q=0.8", outputFormatJSON},
+		{"image/png", outputFormatJSON},
+	}
+	for _, tt := range tests {
+		if got := acceptOutputFormat(tt.accept); got != tt.want {
+			t.Errorf("acceptOutputFormat(%q) = %q, want %q", tt.accept, got, tt.want)
+		}
+	}
+}
+
+// postSummary sends a paid summarize request with an Accept header.
+func postSummary(t *testing.T, h *testsupport.Harness, body, accept, nonce string) *http.Response {
+	t.Helper()
+	req, err := http.NewRequest(http.MethodPost, h.Server.URL+"/api/ai/summarize", strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-402-Signature", "0xsig")
+	req.Header.Set("X-402-Nonce", nonce)
+	if accept != "" {
+		req.Header.Set("Accept", accept)
+	}
+	resp, err := h.Server.Client().Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { resp.Body.Close() })
+	return resp
+}
+
+func TestSummarize_OutputFormats(t *testing.T) {
+	h := testsupport.NewHarness(t, newTestRouter)
+	h.AI.SetReply("- **Point** one & <two>")
+
+	tests := []struct {
+		name, body, accept string
+		contentType        string
+	}{
+		{"field text", `{"text":"hello","output_format":"text"}`, "", "text/plain; charset=utf-8"},
+		{"field markdown", `{"text":"hello","output_format":"Markdown"}`, "", "text/markdown; charset=utf-8"},
+		{"accept markdown", `{"text":"hello"}`, "text/markdown", "text/markdown; charset=utf-8"},
+		{"field overrides accept", `{"text":"hello","output_format":"json"}`, "text/plain", "application/json; charset=utf-8"},
+	}
+	for i, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp := postSummary(t, h, tt.body, tt.accept, "format-"+string(rune('a'+i)))
+			if resp.StatusCode != http.StatusOK {
+				t.Fatalf("expected 200, got %d", resp.StatusCode)
+			}
+			if got := resp.Header.Get("Content-Type"); got != tt.contentType {
+				t.Errorf("expected Content-Type %q, got %q", tt.contentType, got)
+			}
+			body, _ := io.ReadAll(resp.Body)
+			receipt := decodeReceiptHeader(t, resp.Header.Get("X-402-Receipt"))
+			if receipts.HashData(body) != receipt.Receipt.Service.ResponseHash {
+				t.Errorf("expected the receipt to hash the body as sent: %q", body)
+			}
+			if tt.contentType != "application/json; charset=utf-8" && string(body) != "- **Point** one & <two>" {
+				t.Errorf("expected the bare summary, got %q", body)
+			}
+		})
+	}
+}
+
+func TestSummarize_UnknownOutputFormat(t *testing.T) {
+	h := testsupport.NewHarness(t, newTestRouter)
+	resp := h.Post(t, "/api/ai/summarize", `{"text":"hello","output_format":"yaml"}`, "0xsig", "format-unknown")
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Fatalf("expected 400, got %d", resp.StatusCode)
+	}
+	if h.AI.Calls() != 0 {
+		t.Errorf("expected no provider call, got %d", h.AI.Calls())
+	}
+	var body APIError
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil || body.Code != CodeInvalidRequest {
+		t.Errorf("expected INVALID_REQUEST, got %+v (%v)", body, err)
+	}
+}
+
+func TestSummarize_TextResponseIsCached(t *testing.T) {
+	withMemoryCache(t)
+	h := testsupport.NewHarness(t, newTestRouter)
+	h.AI.SetReply("Cached summary.")
+
+	if resp := postSummary(t, h, `{"text":"cache me"}`, "text/plain", "format-cache-1"); resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected 200, got %d", resp.StatusCode)
+	}
+	// The cache is filled in the background; a JSON request for the same
+	// text is then served from it.
+	deadline := time.Now().Add(2 * time.Second)
+	for i := 0; ; i++ {
+		resp := postSummary(t, h, `{"text":"cache me"}`, "", "format-cache-2-"+time.Now().String())
+		body, _ := io.ReadAll(resp.Body)
+		if h.AI.Calls() == 1 && i > 0 {
+			if !bytes.Contains(body, []byte(`"result":"Cached summary."`)) {
+				t.Errorf("expected the cached summary in a JSON envelope, got %s", body)
+			}
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("expected a cache hit, got %d provider calls", h.AI.Calls())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
@@ -0,0 +1,176 @@
+package server
+
+import (
+	"context"
+	"io"
+	"log"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/gin-gonic/gin"
+)
+
+// Parallel verification modes, VERIFY_PARALLEL_MODE.
+const (
+	// verifyParallelOff verifies the payment, then calls the provider.
+	verifyParallelOff = "off"
+	// verifyParallelWarm opens a connection to the provider while the
+	// payment is verified, so the AI call skips the TCP and TLS handshakes.
+	verifyParallelWarm = "warm"
+	// verifyParallelSpeculative also dispatches the AI call during
+	// verification for payers whose payments verified recently, discarding
+	// it if this one does not.
+	verifyParallelSpeculative = "speculative"
+)
+
+// providerWarmInterval is the least time between two connection warm-ups;
+// a connection opened less than this ago is still in the idle pool.
+const providerWarmInterval = 15 * time.Second
+
+// recentPayerTTL is how long a payer counts as recently verified for
+// speculative dispatch.
+const recentPayerTTL = 10 * time.Minute
+
+var lastProviderWarm atomic.Int64
+
+// warmProviderConnection opens a connection to the first AI provider in the
+// background, at most once per providerWarmInterval. It sends a HEAD request
+// through the provider HTTP client so the connection stays in its idle pool.
+func warmProviderConnection(cfg *Config) {
+	now := time.Now().UnixNano()
+	last := lastProviderWarm.Load()
+	if now-last < int64(providerWarmInterval) || !lastProviderWarm.CompareAndSwap(last, now) {
+		return
+	}
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), getHealthCheckTimeout())
+		defer cancel()
+		req, err := http.NewRequestWithContext(ctx, http.MethodHead, cfg.AIProviders[0].URL, nil)
+		if err != nil {
+			return
+		}
+		resp, err := cfg.ProviderHTTPClient().Do(req)
+		if err != nil {
+			log.Printf("[WARNING] Provider connection warm-up failed: %v", err)
+			return
+		}
+		_, _ = io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+	}()
+}
+
+// recentPayerCache remembers the wallets whose payments verified recently.
+type recentPayerCache struct {
+	mu    sync.Mutex
+	seen  map[common.Address]time.Time
+	swept time.Time
+}
+
+var recentPayers = &recentPayerCache{seen: make(map[common.Address]time.Time)}
+
+// Add records a verified payment by addr.
+func (r *recentPayerCache) Add(addr common.Address) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	now := time.Now()
+	if now.Sub(r.swept) > recentPayerTTL {
+		for a, at := range r.seen {
+			if now.Sub(at) > recentPayerTTL {
+				delete(r.seen, a)
+			}
+		}
+		r.swept = now
+	}
+	r.seen[addr] = now
+}
+
+// Has reports whether addr paid successfully within recentPayerTTL.
+func (r *recentPayerCache) Has(addr common.Address) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	at, ok := r.seen[addr]
+	return ok && time.Since(at) <= recentPayerTTL
+}
+
+// rememberVerifiedPayer records payer for speculative dispatch.
+func rememberVerifiedPayer(payer string) {
+	if getConfig().VerifyParallel == verifyParallelSpeculative && common.IsHexAddress(payer) {
+		recentPayers.Add(common.HexToAddress(payer))
+	}
+}
+
+// speculativeCall is an AI call dispatched before its payment verified.
+type speculativeCall struct {
+	cancel context.CancelFunc
+	done   chan struct{}
+	res    providerResult
+	err    error
+}
+
+// Wait returns the call's result, giving up when ctx ends.
+func (s *speculativeCall) Wait(ctx context.Context) (providerResult, error) {
+	select {
+	case <-s.done:
+		return s.res, s.err
+	case <-ctx.Done():
+		s.cancel()
+		return providerResult{}, ctx.Err()
+	}
+}
+
+// Discard cancels the call because its payment was not authorized. It is a
+// no-op on a nil call.
+func (s *speculativeCall) Discard() {
+	if s == nil {
+		return
+	}
+	s.cancel()
+	speculativeDiscarded.Add(1)
+}
+
+var speculativeDispatched, speculativeDiscarded atomic.Int64
+
+// prepareAICall overlaps the AI call with payment verification as
+// VERIFY_PARALLEL_MODE asks. In speculative mode, when the signature recovers
+// to a recently verified payer, it starts the call and returns it; the
+// caller must Wait for it once the payment is authorized or Discard it
+// otherwise. Otherwise it at most warms the provider connection and returns
+// nil.
+func prepareAICall(c *gin.Context, model, text string, params GenerationParams) *speculativeCall {
+	cfg := getConfig()
+	switch cfg.VerifyParallel {
+	case verifyParallelWarm:
+		warmProviderConnection(cfg)
+		return nil
+	case verifyParallelSpeculative:
+	default:
+		return nil
+	}
+	if c.GetHeader("X-402-Voucher") != "" || !isPremiumPayer(c, cfg, recentPayers.Has) {
+		warmProviderConnection(cfg)
+		return nil
+	}
+
+	// Not shared with identical in-flight calls: those run detached from
+	// cancellation, and a discarded speculative call must stop.
+	ctx, cancel := context.WithCancel(c.Request.Context())
+	s := &speculativeCall{cancel: cancel, done: make(chan struct{})}
+	speculativeDispatched.Add(1)
+	go func() {
+		defer close(s.done)
+		s.res, s.err = callAIProviders(ctx, model, text, params)
+	}()
+	return s
+}
+
+// parallelVerifyStatus is the parallel_verify entry of /readyz.
+func parallelVerifyStatus(cfg *Config) gin.H {
+	return gin.H{
+		"mode":                   cfg.VerifyParallel,
+		"speculative_dispatched": speculativeDispatched.Load(),
+		"speculative_discarded":  speculativeDiscarded.Load(),
+	}
+}
@@ -0,0 +1,114 @@
+package server
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"gateway/internal/testsupport"
+	"gateway/payments"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+// resetParallelVerify forgets recent payers and counters for one test.
+func resetParallelVerify(t *testing.T) {
+	t.Helper()
+	reset := func() {
+		recentPayers = &recentPayerCache{seen: make(map[common.Address]time.Time)}
+		speculativeDispatched.Store(0)
+		speculativeDiscarded.Store(0)
+		lastProviderWarm.Store(0)
+	}
+	reset()
+	t.Cleanup(reset)
+}
+
+func TestRecentPayerCache(t *testing.T) {
+	cache := &recentPayerCache{seen: make(map[common.Address]time.Time)}
+	payer := common.HexToAddress("0x742d35Cc6634C0532925a3b844Bc9e7595f8fE21")
+	if cache.Has(payer) {
+		t.Fatal("expected an empty cache to have no payers")
+	}
+	cache.Add(payer)
+	if !cache.Has(payer) {
+		t.Error("expected a recorded payer to be recent")
+	}
+	cache.seen[payer] = time.Now().Add(-2 * recentPayerTTL)
+	if cache.Has(payer) {
+		t.Error("expected a payer older than the TTL not to be recent")
+	}
+}
+
+func TestConfigValidate_VerifyParallelMode(t *testing.T) {
+	t.Setenv("VERIFY_PARALLEL_MODE", "eager")
+	if err := loadConfig().Validate(); err == nil {
+		t.Error("expected an unknown mode to be rejected")
+	}
+	t.Setenv("VERIFY_PARALLEL_MODE", "Warm")
+	if cfg := loadConfig(); cfg.VerifyParallel != verifyParallelWarm {
+		t.Errorf("expected the mode to be case-insensitive, got %q", cfg.VerifyParallel)
+	}
+}
+
+func TestParallelVerify_WarmDoesNotCallProvider(t *testing.T) {
+	resetParallelVerify(t)
+	t.Setenv("VERIFY_PARALLEL_MODE", verifyParallelWarm)
+	h := testsupport.NewHarness(t, newTestRouter)
+
+	if resp := h.Post(t, "/api/ai/summarize", `{"text":"hello"}`, "0xsig", "nonce-warm"); resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected 200, got %d", resp.StatusCode)
+	}
+	if lastProviderWarm.Load() == 0 {
+		t.Error("expected the provider connection to be warmed")
+	}
+	if h.AI.Calls() != 1 {
+		t.Errorf("expected the warm-up not to count as a completion, got %d calls", h.AI.Calls())
+	}
+}
+
+func TestParallelVerify_Speculative(t *testing.T) {
+	resetParallelVerify(t)
+	t.Setenv("VERIFY_PARALLEL_MODE", verifyParallelSpeculative)
+	h := testsupport.NewHarness(t, newTestRouter)
+	key, err := crypto.GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	payer := crypto.PubkeyToAddress(key.PublicKey).Hex()
+	h.Verifier.SetValid(payer)
+
+	cfg := getConfig()
+	post := func(nonce string) *http.Response {
+		sig, err := payments.Sign(paymentContextFor(cfg.PrimaryChain(), cfg.PaymentAmount, nonce), key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return h.Post(t, "/api/ai/summarize", `{"text":"hello"}`, sig, nonce)
+	}
+
+	// The first payment has no history, so it is verified before the call.
+	if resp := post("nonce-spec-1"); resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected 200, got %d", resp.StatusCode)
+	}
+	if n := speculativeDispatched.Load(); n != 0 {
+		t.Fatalf("expected no speculative call for an unknown payer, got %d", n)
+	}
+
+	if resp := post("nonce-spec-2"); resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected 200, got %d", resp.StatusCode)
+	}
+	if n := speculativeDispatched.Load(); n != 1 {
+		t.Fatalf("expected a speculative call for a recent payer, got %d", n)
+	}
+
+	h.Verifier.SetInvalid("bad signature")
+	if resp := post("nonce-spec-3"); resp.StatusCode == http.StatusOK {
+		t.Fatal("expected the failed verification not to be served")
+	}
+	if speculativeDispatched.Load() != 2 || speculativeDiscarded.Load() != 1 {
+		t.Errorf("expected the speculative call to be discarded, got %d dispatched, %d discarded",
+			speculativeDispatched.Load(), speculativeDiscarded.Load())
+	}
+}
@@ -0,0 +1,361 @@
+package server
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"gateway/receipts"
+
+	"github.com/gin-gonic/gin"
+	"github.com/redis/go-redis/v9"
+)
+
+// outboxPayloadRetention uploads the sealed payloads of a receipt.
+const outboxPayloadRetention = "payload.retain"
+
+// payloadExpiryKey is the Redis sorted set of retained receipt IDs scored
+// by when their payloads expire.
+const payloadExpiryKey = "payload:retention:expiry"
+
+// payloadPurgeInterval is how often expired payloads are deleted.
+const payloadPurgeInterval = time.Hour
+
+var (
+	payloadExpiryMu sync.Mutex
+	// payloadExpiries maps retained receipt IDs to when their payloads
+	// expire, when Redis is not connected.
+	payloadExpiries = make(map[string]time.Time)
+)
+
+// PayloadRetentionPolicy says which paid request and response bodies are
+// kept for dispute resolution, and for how long.
+type PayloadRetentionPolicy struct {
+	// Period is how long payloads are kept; zero retains nothing.
+	Period time.Duration
+	// Endpoints are the path prefixes whose payloads are kept; empty keeps
+	// every paid endpoint's.
+	Endpoints []string
+	// MaxBytes skips requests whose bodies together are larger.
+	MaxBytes int
+}
+
+// getPayloadRetentionPolicy reads PAYLOAD_RETENTION_DAYS,
+// PAYLOAD_RETENTION_ENDPOINTS and PAYLOAD_RETENTION_MAX_BYTES. The default
+// period of zero retains nothing, since the bodies are customer content.
+func getPayloadRetentionPolicy() PayloadRetentionPolicy {
+	policy := PayloadRetentionPolicy{
+		Period:   time.Duration(max(getEnvAsInt("PAYLOAD_RETENTION_DAYS", 0), 0)) * 24 * time.Hour,
+		MaxBytes: getEnvAsInt("PAYLOAD_RETENTION_MAX_BYTES", 10*1024*1024),
+	}
+	for _, prefix := range strings.Split(os.Getenv("PAYLOAD_RETENTION_ENDPOINTS"), ",") {
+		if prefix = strings.TrimSpace(prefix); prefix != "" {
+			policy.Endpoints = append(policy.Endpoints, prefix)
+		}
+	}
+	return policy
+}
+
+// Retains reports whether the policy keeps size bytes of payloads of a
+// request to endpoint.
+func (p PayloadRetentionPolicy) Retains(endpoint string, size int) bool {
+	if p.Period <= 0 || (p.MaxBytes > 0 && size > p.MaxBytes) {
+		return false
+	}
+	if len(p.Endpoints) == 0 {
+		return true
+	}
+	for _, prefix := range p.Endpoints {
+		if strings.HasPrefix(endpoint, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// RetainedPayloads are the bodies of one paid request, as hashed into its
+// receipt.
+type RetainedPayloads struct {
+	ReceiptID  string    `json:"receipt_id"`
+	Endpoint   string    `json:"endpoint"`
+	Request    []byte    `json:"request"`
+	Response   []byte    `json:"response"`
+	RetainedAt time.Time `json:"retained_at"`
+	ExpiresAt  time.Time `json:"expires_at"`
+}
+
+// payloadRetention stores payloads under a policy, encrypted with
+// AES-256-GCM, in object storage.
+type payloadRetention struct {
+	policy  PayloadRetentionPolicy
+	storage *s3Uploader
+	aead    cipher.AEAD
+}
+
+// newPayloadRetention returns the payload store configured by the policy,
+// the PAYLOAD_RETENTION_S3_* variables and PAYLOAD_RETENTION_KEY (32 bytes,
+// hex). It returns nil when the policy retains nothing, and an error when it
+// does but storage or the key is missing.
+func newPayloadRetention() (*payloadRetention, error) {
+	policy := getPayloadRetentionPolicy()
+	if policy.Period <= 0 {
+		return nil, nil
+	}
+	storage := newS3UploaderFromEnv("PAYLOAD_RETENTION_S3", "payloads/")
+	if storage == nil {
+		return nil, errors.New("PAYLOAD_RETENTION_S3_ENDPOINT and PAYLOAD_RETENTION_S3_BUCKET are required when PAYLOAD_RETENTION_DAYS is set")
+	}
+	key, err := hex.DecodeString(strings.TrimPrefix(os.Getenv("PAYLOAD_RETENTION_KEY"), "0x"))
+	if err != nil || len(key) != 32 {
+		return nil, errors.New("PAYLOAD_RETENTION_KEY must be 32 hex-encoded bytes when PAYLOAD_RETENTION_DAYS is set")
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	return &payloadRetention{policy: policy, storage: storage, aead: aead}, nil
+}
+
+// objectName is where the payloads of receipt id are stored, under the
+// storage prefix.
+func (r *payloadRetention) objectName(id string) string {
+	return id + ".bin"
+}
+
+// seal encrypts p. The receipt ID is authenticated with it, so an object
+// cannot be passed off as another receipt's.
+func (r *payloadRetention) seal(p RetainedPayloads) ([]byte, error) {
+	data, err := json.Marshal(p)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, r.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return r.aead.Seal(nonce, nonce, data, []byte(p.ReceiptID)), nil
+}
+
+// open decrypts the sealed payloads of receipt id.
+func (r *payloadRetention) open(id string, sealed []byte) (RetainedPayloads, error) {
+	var p RetainedPayloads
+	n := r.aead.NonceSize()
+	if len(sealed) < n {
+		return p, errors.New("sealed payloads are truncated")
+	}
+	data, err := r.aead.Open(nil, sealed[:n], sealed[n:], []byte(id))
+	if err != nil {
+		return p, fmt.Errorf("failed to decrypt payloads: %w", err)
+	}
+	err = json.Unmarshal(data, &p)
+	return p, err
+}
+
+// retainPayloads keeps the request and response bodies of receipt when the
+// policy covers them. With the outbox enabled the upload is written to it,
+// otherwise it runs in the background. Failures are logged; the client has
+// already been answered.
+func retainPayloads(ctx context.Context, receipt *SignedReceipt, requestBody, responseBody []byte) {
+	r, err := newPayloadRetention()
+	if err != nil {
+		log.Printf("[WARNING] Payload retention skipped: %v", err)
+		return
+	}
+	endpoint := receipt.Receipt.Service.Endpoint
+	if r == nil || !r.policy.Retains(endpoint, len(requestBody)+len(responseBody)) {
+		return
+	}
+	now := time.Now().UTC()
+	payloads := RetainedPayloads{
+		ReceiptID:  receipt.Receipt.ID,
+		Endpoint:   endpoint,
+		Request:    requestBody,
+		Response:   responseBody,
+		RetainedAt: now,
+		ExpiresAt:  now.Add(r.policy.Period),
+	}
+	sealed, err := r.seal(payloads)
+	if err != nil {
+		log.Printf("[WARNING] Failed to encrypt payloads of receipt %s: %v", payloads.ReceiptID, err)
+		return
+	}
+	ctx = context.WithoutCancel(ctx)
+	// Index first: purging an object that was never uploaded is harmless.
+	if err := indexRetainedPayloads(ctx, payloads.ReceiptID, payloads.ExpiresAt); err != nil {
+		log.Printf("[WARNING] Failed to index payloads of receipt %s: %v", payloads.ReceiptID, err)
+		return
+	}
+
+	if o := activeOutbox.Load(); o != nil {
+		ev := OutboxEvent{Kind: outboxPayloadRetention, Key: payloads.ReceiptID, Payload: sealed}
+		if err := o.store.Enqueue(ctx, []OutboxEvent{ev}); err != nil {
+			log.Printf("[WARNING] Failed to queue payloads of receipt %s: %v", payloads.ReceiptID, err)
+			return
+		}
+		o.notify()
+		return
+	}
+	go func() {
+		if _, err := r.storage.Put(ctx, r.objectName(payloads.ReceiptID), "application/octet-stream", sealed); err != nil {
+			log.Printf("[WARNING] Failed to retain payloads of receipt %s: %v", payloads.ReceiptID, err)
+		}
+	}()
+}
+
+// deliverPayloadRetention uploads sealed payloads from the outbox. The
+// object is simply rewritten on redelivery.
+func deliverPayloadRetention(ctx context.Context, ev OutboxEvent) error {
+	r, err := newPayloadRetention()
+	if r == nil {
+		// Retention was switched off since the event was written.
+		return err
+	}
+	_, err = r.storage.Put(ctx, r.objectName(ev.Key), "application/octet-stream", ev.Payload)
+	return err
+}
+
+// indexRetainedPayloads records when the payloads of receipt id expire, in
+// Redis when it is connected so any replica purges them, else in memory.
+func indexRetainedPayloads(ctx context.Context, id string, expiresAt time.Time) error {
+	if redisClient != nil {
+		return redisClient.ZAdd(ctx, payloadExpiryKey, redis.Z{Score: float64(expiresAt.Unix()), Member: id}).Err()
+	}
+	payloadExpiryMu.Lock()
+	defer payloadExpiryMu.Unlock()
+	payloadExpiries[id] = expiresAt
+	return nil
+}
+
+// expiredPayloads returns the IDs of receipts whose payloads expired by now.
+func expiredPayloads(ctx context.Context, now time.Time) ([]string, error) {
+	if redisClient != nil {
+		return redisClient.ZRangeByScore(ctx, payloadExpiryKey, &redis.ZRangeBy{
+			Min: "-inf", Max: strconv.FormatInt(now.Unix(), 10),
+		}).Result()
+	}
+	payloadExpiryMu.Lock()
+	defer payloadExpiryMu.Unlock()
+	var ids []string
+	for id, expiresAt := range payloadExpiries {
+		if !now.Before(expiresAt) {
+			ids = append(ids, id)
+		}
+	}
+	return ids, nil
+}
+
+// forgetRetainedPayloads removes receipt id from the expiry index.
+func forgetRetainedPayloads(ctx context.Context, id string) error {
+	if redisClient != nil {
+		return redisClient.ZRem(ctx, payloadExpiryKey, id).Err()
+	}
+	payloadExpiryMu.Lock()
+	defer payloadExpiryMu.Unlock()
+	delete(payloadExpiries, id)
+	return nil
+}
+
+// purgeExpiredPayloads deletes the payloads whose retention period has
+// passed. Objects that fail to delete stay indexed and are retried on the
+// next run.
+func purgeExpiredPayloads(ctx context.Context) error {
+	r, err := newPayloadRetention()
+	if r == nil {
+		return err
+	}
+	ids, err := expiredPayloads(ctx, time.Now())
+	if err != nil {
+		return fmt.Errorf("failed to list expired payloads: %w", err)
+	}
+	var failed int
+	for _, id := range ids {
+		if err := r.storage.Delete(ctx, r.storage.prefix+r.objectName(id)); err != nil {
+			log.Printf("[WARNING] Failed to delete payloads of receipt %s: %v", id, err)
+			failed++
+			continue
+		}
+		if err := forgetRetainedPayloads(ctx, id); err != nil {
+			return err
+		}
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d expired payloads could not be deleted", failed, len(ids))
+	}
+	return nil
+}
+
+// loadRetainedPayloads fetches and decrypts the payloads of receipt id. ok
+// is false when none are retained or their period has passed.
+func loadRetainedPayloads(ctx context.Context, r *payloadRetention, id string) (p RetainedPayloads, ok bool, err error) {
+	sealed, err := r.storage.Get(ctx, r.storage.prefix+r.objectName(id))
+	if errors.Is(err, errObjectNotFound) {
+		return p, false, nil
+	}
+	if err != nil {
+		return p, false, fmt.Errorf("failed to fetch payloads: %w", err)
+	}
+	if p, err = r.open(id, sealed); err != nil {
+		return p, false, err
+	}
+	// Expired but not purged yet.
+	return p, time.Now().Before(p.ExpiresAt), nil
+}
+
+// handleGetReceiptPayloads handles GET /api/admin/receipts/:id/payloads,
+// returning the retained request and response bodies of a receipt with
+// their hashes, which match the receipt's request_hash and response_hash.
+func handleGetReceiptPayloads(c *gin.Context) {
+	id := c.Param("id")
+	r, err := newPayloadRetention()
+	if err != nil {
+		log.Printf("[ERROR] Payload retention is misconfigured: %v", err)
+		respondError(c, CodeServiceUnavailable, "Payload retention is misconfigured")
+		return
+	}
+	if r == nil {
+		respondError(c, CodePayloadsNotFound, "Payload retention is disabled")
+		return
+	}
+	p, ok, err := loadRetainedPayloads(c.Request.Context(), r, id)
+	if err != nil {
+		log.Printf("[ERROR] Failed to load payloads of receipt %s: %v", id, err)
+		respondError(c, CodeServiceUnavailable, "Payload storage is unavailable")
+		return
+	}
+	if !ok {
+		respondError(c, CodePayloadsNotFound, "No payloads are retained for this receipt")
+		return
+	}
+	log.Printf("Admin fetched the retained payloads of receipt %s", id)
+
+	body := gin.H{
+		"receipt_id":    p.ReceiptID,
+		"endpoint":      p.Endpoint,
+		"request":       p.Request,
+		"response":      p.Response,
+		"request_hash":  receipts.HashData(p.Request),
+		"response_hash": receipts.HashData(p.Response),
+		"retained_at":   p.RetainedAt,
+		"expires_at":    p.ExpiresAt,
+	}
+	if receipt, exists := getReceipt(id); exists {
+		body["matches_receipt"] = receipt.Receipt.Service.RequestHash == body["request_hash"] &&
+			receipt.Receipt.Service.ResponseHash == body["response_hash"]
+	}
+	c.JSON(200, body)
+}
@@ -0,0 +1,159 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"gateway/internal/testsupport"
+)
+
+const testPayloadKey = "000102030405060708090a0b0c0d0e0f101112131415161718191a1b1c1d1e1f"
+
+// withPayloadRetention retains payloads for a day in a fake bucket and
+// gives the test an empty expiry index.
+func withPayloadRetention(t *testing.T) *fakeObjectStorage {
+	t.Helper()
+	storage := &fakeObjectStorage{objects: make(map[string][]byte)}
+	server := httptest.NewServer(storage)
+	t.Cleanup(server.Close)
+	t.Setenv("PAYLOAD_RETENTION_DAYS", "1")
+	t.Setenv("PAYLOAD_RETENTION_KEY", testPayloadKey)
+	t.Setenv("PAYLOAD_RETENTION_S3_ENDPOINT", server.URL)
+	t.Setenv("PAYLOAD_RETENTION_S3_BUCKET", "disputes")
+	t.Setenv("PAYLOAD_RETENTION_S3_ACCESS_KEY_ID", "AKIDEXAMPLE")
+	t.Setenv("PAYLOAD_RETENTION_S3_SECRET_ACCESS_KEY", "secret")
+
+	payloadExpiryMu.Lock()
+	prev := payloadExpiries
+	payloadExpiries = make(map[string]time.Time)
+	payloadExpiryMu.Unlock()
+	t.Cleanup(func() {
+		payloadExpiryMu.Lock()
+		payloadExpiries = prev
+		payloadExpiryMu.Unlock()
+	})
+	return storage
+}
+
+// waitForObject waits until path is in storage.
+func waitForObject(t *testing.T, storage *fakeObjectStorage, path string) []byte {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for time.Now().Before(deadline) {
+		storage.mu.Lock()
+		data, ok := storage.objects[path]
+		storage.mu.Unlock()
+		if ok {
+			return data
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	t.Fatalf("object %s was not uploaded", path)
+	return nil
+}
+
+func TestPayloadRetentionPolicy_Retains(t *testing.T) {
+	policy := PayloadRetentionPolicy{Period: time.Hour, Endpoints: []string{"/api/ai/summarize", "/api/v2/"}, MaxBytes: 100}
+	cases := []struct {
+		endpoint string
+		size     int
+		want     bool
+	}{
+		{"/api/ai/summarize", 10, true},
+		{"/api/v2/ai/embed", 100, true},
+		{"/api/ai/embed", 10, false},
+		{"/api/ai/summarize", 101, false},
+	}
+	for _, tc := range cases {
+		if got := policy.Retains(tc.endpoint, tc.size); got != tc.want {
+			t.Errorf("Retains(%s, %d) = %v, want %v", tc.endpoint, tc.size, got, tc.want)
+		}
+	}
+	if (PayloadRetentionPolicy{}).Retains("/api/ai/summarize", 1) {
+		t.Error("expected no retention without a period")
+	}
+}
+
+func TestNewPayloadRetention_RequiresKeyAndStorage(t *testing.T) {
+	withPayloadRetention(t)
+	r, err := newPayloadRetention()
+	if err != nil || r == nil {
+		t.Fatalf("expected retention to be configured, got %v", err)
+	}
+	sealed, err := r.seal(RetainedPayloads{ReceiptID: "rcpt_1", Request: []byte("secret input")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(sealed), "secret input") {
+		t.Error("expected the payloads to be encrypted")
+	}
+	if p, err := r.open("rcpt_1", sealed); err != nil || string(p.Request) != "secret input" {
+		t.Errorf("expected the payloads back, got %+v (%v)", p, err)
+	}
+	if _, err := r.open("rcpt_2", sealed); err == nil {
+		t.Error("expected another receipt's object not to decrypt")
+	}
+
+	t.Setenv("PAYLOAD_RETENTION_KEY", "abcd")
+	if _, err := newPayloadRetention(); err == nil {
+		t.Error("expected a short key to be rejected")
+	}
+	t.Setenv("PAYLOAD_RETENTION_DAYS", "0")
+	if r, err := newPayloadRetention(); r != nil || err != nil {
+		t.Errorf("expected retention to be disabled, got %v (%v)", r, err)
+	}
+}
+
+func TestReceiptPayloads_RetainedFetchedAndPurged(t *testing.T) {
+	storage := withPayloadRetention(t)
+	t.Setenv("ADMIN_API_KEY", "s3cret")
+	h := testsupport.NewHarness(t, newTestRouter)
+	h.AI.SetReply("Retained summary")
+
+	receipt := decodeReceiptHeader(t, paidReceipt(t, h, "nonce-payloads"))
+	id := receipt.Receipt.ID
+	waitForObject(t, storage, "/disputes/payloads/"+id+".bin")
+
+	router := newTestRouter()
+	w := adminGet(t, router, "/api/admin/receipts/"+id+"/payloads", "s3cret")
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", w.Code, w.Body)
+	}
+	var body struct {
+		Endpoint       string `json:"endpoint"`
+		Request        []byte `json:"request"`
+		Response       []byte `json:"response"`
+		RequestHash    string `json:"request_hash"`
+		ResponseHash   string `json:"response_hash"`
+		MatchesReceipt bool   `json:"matches_receipt"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+		t.Fatal(err)
+	}
+	if string(body.Request) != `{"text":"hello"}` || !strings.Contains(string(body.Response), "Retained summary") {
+		t.Errorf("unexpected payloads %s / %s", body.Request, body.Response)
+	}
+	if !body.MatchesReceipt || body.RequestHash != receipt.Receipt.Service.RequestHash || body.ResponseHash != receipt.Receipt.Service.ResponseHash {
+		t.Errorf("expected the payloads to match the receipt, got %+v", body)
+	}
+
+	payloadExpiryMu.Lock()
+	payloadExpiries[id] = time.Now().Add(-time.Second)
+	payloadExpiryMu.Unlock()
+	if err := purgeExpiredPayloads(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	w = adminGet(t, router, "/api/admin/receipts/"+id+"/payloads", "s3cret")
+	var purged errorBody
+	if err := json.Unmarshal(w.Body.Bytes(), &purged); err != nil || w.Code != http.StatusNotFound || purged.Code != CodePayloadsNotFound {
+		t.Errorf("expected 404 %s after the purge, got %d %+v", CodePayloadsNotFound, w.Code, purged)
+	}
+	if len(storage.objects) != 0 {
+		t.Errorf("expected the object to be deleted, got %d objects", len(storage.objects))
+	}
+}
@@ -0,0 +1,137 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"slices"
+	"strings"
+
+	"gateway/payments"
+
+	"github.com/gin-gonic/gin"
+)
+
+// PaymentVerification is the result of a dry-run verification. Valid says
+// the signature verifies for the payment context as sent; Payable also
+// requires the context to be one the gateway would charge and its nonce to
+// be unspent. Issues explain what would make a paid call with it fail.
+type PaymentVerification struct {
+	Valid         bool     `json:"valid"`
+	Payable       bool     `json:"payable"`
+	SignatureType string   `json:"signature_type"`
+	Signer        string   `json:"signer,omitempty"`
+	Reason        string   `json:"reason,omitempty"`
+	Code          string   `json:"code,omitempty"`
+	Message       string   `json:"message,omitempty"`
+	Details       string   `json:"details,omitempty"`
+	NonceSpent    bool     `json:"nonce_spent"`
+	Issues        []string `json:"issues"`
+}
+
+// paymentContextIssues lists what about payment differs from the contexts
+// the gateway offers: an unaccepted chain, another recipient or token, an
+// amount not in canonical form, or a nonce the gateway did not issue when
+// PAYMENT_CHALLENGE_REQUIRED is set (or issued for another price).
+func paymentContextIssues(ctx context.Context, cfg *Config, payment PaymentContext) []string {
+	issues := []string{}
+	chain, ok := cfg.Chain(payment.ChainID)
+	if !ok {
+		issues = append(issues, fmt.Sprintf("chainId %d is not an accepted chain", payment.ChainID))
+	} else if !strings.EqualFold(payment.Recipient, chain.Recipient) {
+		issues = append(issues, fmt.Sprintf("recipient must be %s on chain %d", chain.Recipient, chain.ChainID))
+	}
+	if payment.Token != "USDC" {
+		issues = append(issues, `token must be "USDC"`)
+	}
+	if _, err := payment.ParseAmount(); err != nil {
+		issues = append(issues, "amount is not a valid USDC amount")
+	} else if canonical := payments.CanonicalAmount(payment.Amount, payment.Token); canonical != payment.Amount {
+		issues = append(issues, fmt.Sprintf("amount must be written as %q, as the gateway signs it", canonical))
+	}
+
+	ch, err := loadChallenge(ctx, payment.Nonce)
+	if err != nil {
+		log.Printf("[WARNING] Payment challenge lookup failed: %v", err)
+	}
+	switch {
+	case ch != nil && ch.Price != payment.Amount && payments.CanonicalAmount(ch.Price, "USDC") != payment.Amount:
+		issues = append(issues, fmt.Sprintf("the nonce was issued for %s", ch.Price))
+	case ch == nil && cfg.Challenges.Required:
+		issues = append(issues, "the nonce was not issued by this gateway, has expired or was already paid")
+	}
+	return issues
+}
+
+// handleVerifyPayment handles POST /api/payment/verify, a dry run of the
+// payment check of a paid call: it verifies signature over paymentContext
+// with the scheme of signature_type (default eip712) and, when payer is
+// set, checks it signed, without spending the nonce or counting the result
+// in the stats. Wallet integrations can use it to test their signing code.
+func handleVerifyPayment(c *gin.Context) {
+	var req struct {
+		PaymentContext *PaymentContext `json:"paymentContext"`
+		Signature      string          `json:"signature"`
+		SignatureType  string          `json:"signature_type"`
+		Payer          string          `json:"payer"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		respondError(c, CodeInvalidRequest, "Request must be valid JSON")
+		return
+	}
+	if req.PaymentContext == nil || req.PaymentContext.Nonce == "" || req.Signature == "" {
+		respondError(c, CodeInvalidRequest, "paymentContext with a nonce and signature are required")
+		return
+	}
+	cfg := getConfig()
+	sigType := strings.ToLower(strings.TrimSpace(req.SignatureType))
+	if sigType == "" {
+		sigType = payments.SignatureTypeEIP712
+	}
+	if !slices.Contains(cfg.Signatures.Types, sigType) {
+		respondAPIError(c, newAPIError(CodeSignatureTypeUnsupported, fmt.Sprintf("Signature type %q is not accepted", sigType)).
+			with(gin.H{"accepted": cfg.Signatures.Types}))
+		return
+	}
+
+	// Quote fields are not part of the signed payment.
+	payment := *req.PaymentContext
+	payment.Expiry, payment.QuoteSignature = 0, ""
+	ctx := c.Request.Context()
+	resp, err := verifySignature(ctx, sigType, payment, req.Signature, req.Payer)
+	if err != nil {
+		log.Printf("Verification error: %v", err)
+		if errors.Is(err, context.DeadlineExceeded) {
+			respondError(c, CodeVerifierTimeout, "Verifier request timed out")
+		} else {
+			respondError(c, CodeVerifierFailed, "An internal error occurred")
+		}
+		return
+	}
+
+	result := PaymentVerification{
+		Valid:         resp.IsValid,
+		SignatureType: sigType,
+		Issues:        paymentContextIssues(ctx, cfg, payment),
+	}
+	if resp.IsValid {
+		result.Signer = resp.RecoveredAddress
+	} else {
+		result.Reason = signatureFailureReason(resp, sigType, payment, req.Signature, req.Payer)
+		result.Code = string(verifyFailureCodes[result.Reason])
+		result.Message = verifyFailureMessages[result.Reason]
+		result.Details = resp.Error
+	}
+	spent, err := nonceSpent(ctx, payment.Nonce)
+	if err != nil {
+		log.Printf("[WARNING] Nonce store error: %v", err)
+		result.Issues = append(result.Issues, "payment nonces cannot be checked right now")
+	}
+	if spent {
+		result.NonceSpent = true
+		result.Issues = append(result.Issues, "the nonce was already used; sign a new payment context")
+	}
+	result.Payable = result.Valid && len(result.Issues) == 0
+	c.JSON(200, result)
+}
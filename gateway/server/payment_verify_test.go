@@ -0,0 +1,88 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"gateway/internal/testsupport"
+	"gateway/payments"
+
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+// verifyPaymentDryRun calls POST /api/payment/verify.
+func verifyPaymentDryRun(t *testing.T, h *testsupport.Harness, payment PaymentContext, sig, sigType, payer string) (int, PaymentVerification) {
+	t.Helper()
+	body, _ := json.Marshal(map[string]interface{}{"paymentContext": payment, "signature": sig, "signature_type": sigType, "payer": payer})
+	resp := h.Post(t, "/api/payment/verify", string(body), "", "")
+	var result PaymentVerification
+	json.NewDecoder(resp.Body).Decode(&result)
+	return resp.StatusCode, result
+}
+
+func TestVerifyPayment_DryRunKeepsNonce(t *testing.T) {
+	h := testsupport.NewHarness(t, newTestRouter)
+	payment := offeredPayment(t, h)
+	key, _ := crypto.GenerateKey()
+	sig, err := payments.SignPersonal(payment, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer := crypto.PubkeyToAddress(key.PublicKey).Hex()
+
+	for i := 0; i < 2; i++ {
+		status, result := verifyPaymentDryRun(t, h, payment, sig, "personal_sign", signer)
+		if status != http.StatusOK || !result.Valid || !result.Payable || result.Signer != signer || len(result.Issues) != 0 {
+			t.Fatalf("expected a payable signature, got %d %+v", status, result)
+		}
+	}
+	// The nonce was not spent, so the paid call still goes through.
+	if resp := postPayment(t, h, paymentHeaderV2{Signature: sig, Nonce: payment.Nonce, SignatureType: "personal_sign"}); resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected the paid call to succeed after the dry run, got %d", resp.StatusCode)
+	}
+
+	_, result := verifyPaymentDryRun(t, h, payment, sig, "personal_sign", "")
+	if !result.Valid || result.Payable || !result.NonceSpent {
+		t.Errorf("expected a valid signature for a spent nonce, got %+v", result)
+	}
+}
+
+func TestVerifyPayment_ReportsReasonAndIssues(t *testing.T) {
+	h := testsupport.NewHarness(t, newTestRouter)
+	payment := offeredPayment(t, h)
+	key, _ := crypto.GenerateKey()
+	signer := crypto.PubkeyToAddress(key.PublicKey).Hex()
+
+	// Signed with the amount written differently from the gateway's.
+	wrong := payment
+	wrong.Amount = payment.Amount + "0"
+	sig, _ := payments.SignPersonal(wrong, key)
+	_, result := verifyPaymentDryRun(t, h, wrong, sig, "personal_sign", signer)
+	if !result.Valid || result.Payable || len(result.Issues) != 1 {
+		t.Errorf("expected a valid but unpayable signature with one issue, got %+v", result)
+	}
+
+	// A signature over another context is refused with the paid call's reason.
+	other := payment
+	other.Recipient = "0x0000000000000000000000000000000000000001"
+	sig, _ = payments.SignPersonal(other, key)
+	_, result = verifyPaymentDryRun(t, h, payment, sig, "personal_sign", signer)
+	if result.Valid || result.Reason != payments.ReasonSignerMismatch || result.Code != string(CodeSignerMismatch) {
+		t.Errorf("expected a signer mismatch, got %+v", result)
+	}
+
+	h.Verifier.SetInvalid("invalid signature")
+	before := h.Verifier.Calls()
+	_, result = verifyPaymentDryRun(t, h, payment, "0xbad", "", "")
+	if result.Valid || result.SignatureType != payments.SignatureTypeEIP712 || h.Verifier.Calls() != before+1 {
+		t.Errorf("expected an eip712 rejection from the verifier, got %+v", result)
+	}
+
+	if status, _ := verifyPaymentDryRun(t, h, payment, sig, "erc1155", ""); status != http.StatusBadRequest {
+		t.Errorf("expected 400 for an unaccepted signature type, got %d", status)
+	}
+	if resp := h.Post(t, "/api/payment/verify", `{"signature":"0x1"}`, "", ""); resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("expected 400 without a payment context, got %d", resp.StatusCode)
+	}
+}
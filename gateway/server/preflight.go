@@ -0,0 +1,170 @@
+package server
+
+import (
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// corsRequestHeaders are the request headers browsers may send to any route.
+var corsRequestHeaders = []string{"Origin", "Content-Type", "X-Client-Name", "X-Correlation-ID", "If-None-Match", "If-Modified-Since"}
+
+// paymentRequestHeaders carry a payment or payment-signed request. Routes
+// that accept them also allow them in preflight responses.
+var paymentRequestHeaders = []string{
+	"X-402-Signature", "X-402-Nonce", "X-402-Quote-Signature", "X-402-Quote-Expiry", "X-402-Chain-Id",
+	"X-402-Signature-Type", "X-402-Payer", "X-402-Voucher", "X-402-Session", "X-402-Sponsor-Grant", "X-402-Sponsor-Signature", "X-402-Timestamp", "X-PAYMENT",
+}
+
+// acceptsPaymentHeaders reports whether the route method fullPath reads
+// payment headers: every POST under the AI groups, like
+// maintenanceMiddleware, and the signature-authenticated usage and receipt
+// lookups.
+func acceptsPaymentHeaders(method, fullPath string) bool {
+	if method == http.MethodPost && (strings.HasPrefix(fullPath, "/api/ai/") || strings.HasPrefix(fullPath, "/api/v2/ai/")) {
+		return true
+	}
+	return method == http.MethodGet && (fullPath == "/api/me/usage" || fullPath == "/api/receipts/mine")
+}
+
+// routeTable holds the methods and preflight headers of every registered
+// route pattern.
+type routeTable struct {
+	methods map[string][]string
+	headers map[string][]string
+}
+
+// buildRouteTable reads the routes registered on r. Every pattern allows
+// OPTIONS, and HEAD wherever it allows GET.
+func buildRouteTable(r *gin.Engine) *routeTable {
+	t := &routeTable{methods: make(map[string][]string), headers: make(map[string][]string)}
+	payment := make(map[string]bool)
+	for _, route := range r.Routes() {
+		methods := t.methods[route.Path]
+		if !slices.Contains(methods, route.Method) {
+			methods = append(methods, route.Method)
+		}
+		if route.Method == http.MethodGet && !slices.Contains(methods, http.MethodHead) {
+			methods = append(methods, http.MethodHead)
+		}
+		if !slices.Contains(methods, http.MethodOptions) {
+			methods = append(methods, http.MethodOptions)
+		}
+		t.methods[route.Path] = methods
+		payment[route.Path] = payment[route.Path] || acceptsPaymentHeaders(route.Method, route.Path)
+	}
+	for path, methods := range t.methods {
+		slices.Sort(methods)
+		headers := slices.Clone(corsRequestHeaders)
+		if payment[path] {
+			headers = append(headers, paymentRequestHeaders...)
+		}
+		t.headers[path] = headers
+	}
+	return t
+}
+
+// registerOptionsRoutes adds an OPTIONS route for every pattern in t that
+// has none; preflightMiddleware answers them. It must run after every other
+// route is registered.
+func registerOptionsRoutes(r *gin.Engine, t *routeTable) {
+	existing := make(map[string]bool)
+	for _, route := range r.Routes() {
+		if route.Method == http.MethodOptions {
+			existing[route.Path] = true
+		}
+	}
+	for path := range t.methods {
+		if !existing[path] {
+			r.OPTIONS(path, func(c *gin.Context) { c.Status(http.StatusNoContent) })
+		}
+	}
+}
+
+// preflightMiddleware answers OPTIONS requests for registered routes with
+// 204 and Allow, before CORS, rate limiting, abuse detection and payment
+// run. CORS preflights from allowed origins also get the route's methods
+// and request headers; other origins get 403 as from the CORS middleware.
+// OPTIONS for unknown paths is left to the CORS middleware and router.
+func preflightMiddleware(t *routeTable) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		methods, ok := t.methods[c.FullPath()]
+		if c.Request.Method != http.MethodOptions || !ok {
+			c.Next()
+			return
+		}
+		allow := strings.Join(methods, ", ")
+		c.Header("Allow", allow)
+
+		origin := c.GetHeader("Origin")
+		if origin != "" && c.GetHeader("Access-Control-Request-Method") != "" {
+			if !getConfig().IsOriginAllowed(origin) {
+				c.AbortWithStatus(http.StatusForbidden)
+				return
+			}
+			h := c.Writer.Header()
+			h.Set("Access-Control-Allow-Origin", origin)
+			h.Set("Access-Control-Allow-Credentials", "true")
+			h.Set("Access-Control-Allow-Methods", allow)
+			h.Set("Access-Control-Allow-Headers", strings.Join(t.headers[c.FullPath()], ", "))
+			h.Add("Vary", "Origin")
+			h.Add("Vary", "Access-Control-Request-Method")
+			h.Add("Vary", "Access-Control-Request-Headers")
+		}
+		c.AbortWithStatus(http.StatusNoContent)
+	}
+}
+
+// handleNoRoute answers requests for unknown paths.
+func handleNoRoute(c *gin.Context) {
+	respondError(c, CodeNotFound, "No route for "+c.Request.URL.Path)
+}
+
+// handleNoMethod answers requests with a method the path does not accept;
+// the router has set Allow.
+func handleNoMethod(c *gin.Context) {
+	respondError(c, CodeMethodNotAllowed, c.Request.Method+" is not allowed for "+c.Request.URL.Path)
+}
+
+// headResponseWriter discards the body of a HEAD request served by a GET
+// handler.
+type headResponseWriter struct {
+	gin.ResponseWriter
+}
+
+func (w headResponseWriter) Write(p []byte) (int, error) {
+	w.ResponseWriter.WriteHeaderNow()
+	return len(p), nil
+}
+
+func (w headResponseWriter) WriteString(s string) (int, error) {
+	w.ResponseWriter.WriteHeaderNow()
+	return len(s), nil
+}
+
+func (w headResponseWriter) WriteHeader(code int) {
+	w.ResponseWriter.WriteHeader(code)
+	w.ResponseWriter.WriteHeaderNow()
+}
+
+// headMiddleware serves HEAD requests without a HEAD route as GET requests
+// with the body discarded, so they run exactly the GET handler and its
+// middleware. HEAD on a route without GET, such as a paid POST endpoint,
+// gets the router's 405 with Allow and never reaches payment. It must be
+// the first middleware, since the request is served again from the top.
+func headMiddleware(r *gin.Engine) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if c.Request.Method != http.MethodHead || c.FullPath() != "" {
+			c.Next()
+			return
+		}
+		// The router's 405 Allow header is for the HEAD request.
+		c.Writer.Header().Del("Allow")
+		req := c.Request.Clone(c.Request.Context())
+		req.Method = http.MethodGet
+		r.ServeHTTP(headResponseWriter{c.Writer}, req)
+		c.Abort()
+	}
+}
@@ -0,0 +1,103 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"gateway/internal/testsupport"
+)
+
+// serveMethod sends an empty method request for path to router with headers.
+func serveMethod(router http.Handler, method, path string, headers map[string]string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, path, nil)
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	return w
+}
+
+func TestOptions_PaidRouteSkipsPayment(t *testing.T) {
+	h := testsupport.NewHarness(t, newTestRouter)
+	router := h.Server.Config.Handler
+
+	w := serveMethod(router, http.MethodOptions, "/api/ai/summarize", nil)
+	if w.Code != http.StatusNoContent || w.Header().Get("Allow") != "OPTIONS, POST" {
+		t.Fatalf("expected 204 with Allow: OPTIONS, POST, got %d %q", w.Code, w.Header().Get("Allow"))
+	}
+	if w := serveMethod(router, http.MethodOptions, "/api/receipts/rcpt_123", nil); w.Header().Get("Allow") != "GET, HEAD, OPTIONS" {
+		t.Errorf("expected GET routes to allow HEAD, got %q", w.Header().Get("Allow"))
+	}
+	if h.Verifier.Calls() != 0 {
+		t.Errorf("expected OPTIONS not to reach payment, got %d verifier calls", h.Verifier.Calls())
+	}
+}
+
+func TestOptions_PreflightListsRouteHeaders(t *testing.T) {
+	t.Setenv("CORS_ALLOWED_ORIGINS", "http://localhost:3001")
+	router := newTestRouter()
+	preflight := map[string]string{"Origin": "http://localhost:3001", "Access-Control-Request-Method": "POST"}
+
+	w := serveMethod(router, http.MethodOptions, "/api/v2/ai/summarize", preflight)
+	if w.Code != http.StatusNoContent {
+		t.Fatalf("expected 204, got %d", w.Code)
+	}
+	if got := w.Header().Get("Access-Control-Allow-Origin"); got != "http://localhost:3001" {
+		t.Errorf("expected the origin to be allowed, got %q", got)
+	}
+	if got := w.Header().Get("Access-Control-Allow-Methods"); got != "OPTIONS, POST" {
+		t.Errorf("expected the route's methods, got %q", got)
+	}
+	if got := w.Header().Get("Access-Control-Allow-Headers"); !strings.Contains(got, "X-402-Signature") || !strings.Contains(got, "X-PAYMENT") {
+		t.Errorf("expected payment headers on a paid route, got %q", got)
+	}
+
+	preflight["Access-Control-Request-Method"] = "GET"
+	w = serveMethod(router, http.MethodOptions, "/api/receipts/rcpt_123", preflight)
+	if got := w.Header().Get("Access-Control-Allow-Headers"); strings.Contains(got, "X-402-Signature") || !strings.Contains(got, "If-None-Match") {
+		t.Errorf("expected no payment headers on a receipt lookup, got %q", got)
+	}
+
+	preflight["Origin"] = "http://evil.example"
+	if w := serveMethod(router, http.MethodOptions, "/api/ai/summarize", preflight); w.Code != http.StatusForbidden {
+		t.Errorf("expected a disallowed origin to get 403, got %d", w.Code)
+	}
+}
+
+func TestHead_ServesGetWithoutBody(t *testing.T) {
+	router := newTestRouter()
+
+	get := serveMethod(router, http.MethodGet, "/api/errors", nil)
+	head := serveMethod(router, http.MethodHead, "/api/errors", nil)
+	if head.Code != http.StatusOK || head.Body.Len() != 0 {
+		t.Fatalf("expected 200 without a body, got %d with %d bytes", head.Code, head.Body.Len())
+	}
+	if head.Header().Get("Content-Type") != get.Header().Get("Content-Type") {
+		t.Errorf("expected the GET headers, got %q", head.Header().Get("Content-Type"))
+	}
+	if w := serveMethod(router, http.MethodHead, "/api/receipts/rcpt_missing", nil); w.Code != http.StatusNotFound || w.Body.Len() != 0 {
+		t.Errorf("expected the GET handler's 404 without a body, got %d", w.Code)
+	}
+}
+
+func TestHead_PaidRouteIsNotAllowed(t *testing.T) {
+	h := testsupport.NewHarness(t, newTestRouter)
+	router := h.Server.Config.Handler
+
+	w := serveMethod(router, http.MethodHead, "/api/ai/summarize", nil)
+	if w.Code != http.StatusMethodNotAllowed || !strings.Contains(w.Header().Get("Allow"), "POST") {
+		t.Fatalf("expected 405 with Allow, got %d %q", w.Code, w.Header().Get("Allow"))
+	}
+	if w := serveMethod(router, http.MethodGet, "/api/ai/summarize", nil); w.Code != http.StatusMethodNotAllowed {
+		t.Errorf("expected GET on a paid route to get 405, got %d", w.Code)
+	}
+	if w := serveMethod(router, http.MethodGet, "/api/nope", nil); w.Code != http.StatusNotFound || !strings.Contains(w.Body.String(), string(CodeNotFound)) {
+		t.Errorf("expected unknown paths to get a %s error, got %d %s", CodeNotFound, w.Code, w.Body)
+	}
+	if h.Verifier.Calls() != 0 {
+		t.Errorf("expected HEAD not to reach payment, got %d verifier calls", h.Verifier.Calls())
+	}
+}
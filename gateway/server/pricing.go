@@ -0,0 +1,44 @@
+package server
+
+import (
+	"github.com/gin-gonic/gin"
+)
+
+// pricingToken is a payment token and its contract on the accepted chains
+// where it is known.
+type pricingToken struct {
+	Symbol    string                 `json:"symbol"`
+	Decimals  int                    `json:"decimals"`
+	Contracts []pricingTokenContract `json:"contracts,omitempty"`
+}
+
+// pricingTokenContract is the token's contract on one chain: the built-in
+// USDC deployment or its ERC3009_TOKENS entry.
+type pricingTokenContract struct {
+	ChainID int    `json:"chain_id"`
+	Address string `json:"address"`
+}
+
+// handleGetPricing handles GET /api/pricing: the current price of every
+// paid endpoint, the accepted tokens and chains, the recipient and how long
+// receipts are kept, so clients can show prices without triggering a 402.
+// Prices are the ones a 402 would quote for the shortest input; POST
+// /api/ai/estimate prices a specific request.
+func handleGetPricing(c *gin.Context) {
+	cfg := getConfig()
+	token := pricingToken{Symbol: "USDC", Decimals: tokenDecimals}
+	for _, chain := range cfg.Chains {
+		if domain, ok := cfg.Signatures.Tokens[chain.ChainID]; ok {
+			token.Contracts = append(token.Contracts, pricingTokenContract{ChainID: chain.ChainID, Address: domain.VerifyingContract})
+		}
+	}
+
+	c.JSON(200, gin.H{
+		"endpoints":           pricedEndpoints(cfg),
+		"tokens":              []pricingToken{token},
+		"chains":              acceptedChains(cfg),
+		"recipient":           cfg.RecipientAddress,
+		"receipt_ttl_seconds": int(getReceiptTTL().Seconds()),
+		"estimate_url":        publicBaseURL(c) + "/api/ai/estimate",
+	})
+}
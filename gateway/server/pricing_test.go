@@ -0,0 +1,54 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestGetPricing(t *testing.T) {
+	t.Setenv("PAYMENT_AMOUNT", "0.002")
+	t.Setenv("CHAIN_ID", "84532")
+	t.Setenv("ACCEPTED_CHAINS", "optimism:"+testOptimismRecipient)
+	t.Setenv("ERC3009_TOKENS", "optimism=0x2222222222222222222222222222222222222222")
+	t.Setenv("RECEIPT_TTL", "3600")
+	t.Setenv("PUBLIC_BASE_URL", "https://pay.example.com")
+
+	w := httptest.NewRecorder()
+	newTestRouter().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api/pricing", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200 without payment, got %d", w.Code)
+	}
+	var pricing struct {
+		Endpoints []pricedEndpoint `json:"endpoints"`
+		Tokens    []pricingToken   `json:"tokens"`
+		Chains    []struct {
+			ChainID   int    `json:"chain_id"`
+			Recipient string `json:"recipient"`
+		} `json:"chains"`
+		Recipient         string `json:"recipient"`
+		ReceiptTTLSeconds int    `json:"receipt_ttl_seconds"`
+		EstimateURL       string `json:"estimate_url"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &pricing); err != nil {
+		t.Fatal(err)
+	}
+	if len(pricing.Endpoints) != 2 || pricing.Endpoints[0].Path != "/api/ai/summarize" || pricing.Endpoints[0].Price != "0.002" {
+		t.Errorf("unexpected endpoints %+v", pricing.Endpoints)
+	}
+	if len(pricing.Chains) != 2 || pricing.Chains[0].ChainID != 84532 || pricing.Chains[1].Recipient != testOptimismRecipient {
+		t.Errorf("unexpected chains %+v", pricing.Chains)
+	}
+	want := []pricingTokenContract{
+		{ChainID: 84532, Address: "0x036CbD53842c5426634e7929541eC2318f3dCF7e"},
+		{ChainID: 10, Address: "0x2222222222222222222222222222222222222222"},
+	}
+	if len(pricing.Tokens) != 1 || pricing.Tokens[0].Symbol != "USDC" || pricing.Tokens[0].Decimals != 6 ||
+		len(pricing.Tokens[0].Contracts) != 2 || pricing.Tokens[0].Contracts[0] != want[0] || pricing.Tokens[0].Contracts[1] != want[1] {
+		t.Errorf("unexpected tokens %+v", pricing.Tokens)
+	}
+	if pricing.Recipient != getConfig().RecipientAddress || pricing.ReceiptTTLSeconds != 3600 || pricing.EstimateURL != "https://pay.example.com/api/ai/estimate" {
+		t.Errorf("unexpected pricing %+v", pricing)
+	}
+}
@@ -0,0 +1,187 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Environment profiles, APP_ENV.
+const (
+	appEnvDev     = "dev"
+	appEnvStaging = "staging"
+	appEnvProd    = "prod"
+)
+
+// Log formats, LOG_FORMAT.
+const (
+	logFormatText = "text"
+	logFormatJSON = "json"
+)
+
+// appProfiles are the defaults each APP_ENV applies to variables the
+// environment and .env leave unset. dev keeps the gateway's historical
+// defaults.
+var appProfiles = map[string]map[string]string{
+	appEnvDev: {
+		"GIN_MODE":   gin.DebugMode,
+		"LOG_FORMAT": logFormatText,
+	},
+	appEnvStaging: {
+		"GIN_MODE":             gin.ReleaseMode,
+		"LOG_FORMAT":           logFormatJSON,
+		"CORS_ALLOWED_ORIGINS": "",
+		"RATE_LIMIT_ENABLED":   "true",
+	},
+	appEnvProd: {
+		"GIN_MODE":                     gin.ReleaseMode,
+		"LOG_FORMAT":                   logFormatJSON,
+		"CORS_ALLOWED_ORIGINS":         "",
+		"RATE_LIMIT_ENABLED":           "true",
+		"REQUEST_TIMEOUT_SECONDS":      "30",
+		"AI_REQUEST_TIMEOUT_SECONDS":   "25",
+		"HEALTH_CHECK_TIMEOUT_SECONDS": "1",
+	},
+}
+
+// placeholderPrivateKeys are private keys published in this repository's
+// examples and tests; prod refuses to sign with them.
+var placeholderPrivateKeys = []string{
+	"your_private_key_here",
+	"0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef",
+}
+
+// getAppEnv returns APP_ENV, defaulting to dev.
+func getAppEnv() string {
+	return strings.ToLower(getEnv("APP_ENV", appEnvDev))
+}
+
+// applyAppProfile sets the defaults of the APP_ENV profile for every variable
+// that is unset, then applies GIN_MODE and LOG_FORMAT. Explicit values always
+// win. It runs once at startup, after .env is loaded; changing APP_ENV on
+// reload does not apply the new defaults.
+func applyAppProfile() (string, error) {
+	env := getAppEnv()
+	defaults, ok := appProfiles[env]
+	if !ok {
+		return env, fmt.Errorf("APP_ENV must be %s, %s or %s, got %q", appEnvDev, appEnvStaging, appEnvProd, env)
+	}
+	for key, value := range defaults {
+		if _, set := os.LookupEnv(key); !set {
+			os.Setenv(key, value)
+		}
+	}
+
+	gin.SetMode(os.Getenv("GIN_MODE"))
+	switch format := strings.ToLower(os.Getenv("LOG_FORMAT")); format {
+	case logFormatText:
+	case logFormatJSON:
+		useJSONLogs(os.Stderr)
+	default:
+		return env, fmt.Errorf("LOG_FORMAT must be %s or %s, got %q", logFormatText, logFormatJSON, format)
+	}
+	return env, nil
+}
+
+// validateProfile reports settings the profile forbids. prod refuses the
+// built-in recipient address, placeholder signing keys and a wildcard CORS
+// origin, since receipts and payments would otherwise go to someone else's
+// wallet or be readable from any site.
+func (cfg *Config) validateProfile() error {
+	if _, ok := appProfiles[cfg.AppEnv]; !ok {
+		return fmt.Errorf("APP_ENV must be %s, %s or %s, got %q", appEnvDev, appEnvStaging, appEnvProd, cfg.AppEnv)
+	}
+	if cfg.AppEnv != appEnvProd {
+		return nil
+	}
+	if strings.EqualFold(cfg.RecipientAddress, defaultRecipientAddress) {
+		return fmt.Errorf("APP_ENV=prod requires RECIPIENT_ADDRESS to be set to your own wallet")
+	}
+	key := strings.TrimPrefix(os.Getenv("SERVER_WALLET_PRIVATE_KEY"), "0x")
+	for _, placeholder := range placeholderPrivateKeys {
+		if cfg.Signer.Backend == signerBackendEnv && strings.EqualFold(key, placeholder) {
+			return fmt.Errorf("APP_ENV=prod refuses the example SERVER_WALLET_PRIVATE_KEY")
+		}
+	}
+	for _, origin := range cfg.CORSOrigins {
+		if origin == "*" {
+			return fmt.Errorf("APP_ENV=prod does not allow CORS_ALLOWED_ORIGINS=*")
+		}
+	}
+	return nil
+}
+
+// jsonLogWriter turns each standard log line into a JSON object with time,
+// level and msg. The level is taken from a [WARNING], [ERROR] or [DEBUG]
+// prefix, else info.
+type jsonLogWriter struct {
+	out io.Writer
+}
+
+var logLevelPrefixes = []struct{ prefix, level string }{
+	{"[WARNING]", "warning"},
+	{"WARNING:", "warning"},
+	{"[ERROR]", "error"},
+	{"[DEBUG]", "debug"},
+}
+
+func (w jsonLogWriter) Write(p []byte) (int, error) {
+	msg := strings.TrimRight(string(p), "\n")
+	level := "info"
+	for _, l := range logLevelPrefixes {
+		if rest, ok := strings.CutPrefix(msg, l.prefix); ok {
+			level, msg = l.level, strings.TrimSpace(rest)
+			break
+		}
+	}
+	line, err := json.Marshal(struct {
+		Time  string `json:"time"`
+		Level string `json:"level"`
+		Msg   string `json:"msg"`
+	}{time.Now().UTC().Format(time.RFC3339Nano), level, msg})
+	if err != nil {
+		return 0, err
+	}
+	if _, err := w.out.Write(append(line, '\n')); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// useJSONLogs writes the standard logger and gin's request log to out as
+// JSON lines.
+func useJSONLogs(out io.Writer) {
+	log.SetFlags(0)
+	log.SetOutput(jsonLogWriter{out: out})
+	gin.DefaultWriter = out
+}
+
+// requestLogger is gin's request log, as JSON lines when LOG_FORMAT=json.
+func requestLogger() gin.HandlerFunc {
+	if strings.ToLower(os.Getenv("LOG_FORMAT")) != logFormatJSON {
+		return gin.Logger()
+	}
+	return gin.LoggerWithFormatter(func(p gin.LogFormatterParams) string {
+		fields := gin.H{
+			"time":       p.TimeStamp.UTC().Format(time.RFC3339Nano),
+			"level":      "info",
+			"msg":        "request",
+			"method":     p.Method,
+			"path":       p.Path,
+			"status":     p.StatusCode,
+			"latency_ms": p.Latency.Milliseconds(),
+			"client_ip":  p.ClientIP,
+		}
+		if t, ok := p.Keys[requestTimingKey].(*requestTiming); ok {
+			fields["timing_ms"] = t.millis()
+		}
+		line, _ := json.Marshal(fields)
+		return string(line) + "\n"
+	})
+}
@@ -0,0 +1,126 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"log"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+// unsetEnv unsets keys for one test, restoring them afterwards.
+func unsetEnv(t *testing.T, keys ...string) {
+	t.Helper()
+	for _, key := range keys {
+		t.Setenv(key, "") // registers the restore
+		os.Unsetenv(key)
+	}
+}
+
+// restoreLogging undoes the logging changes of applyAppProfile.
+func restoreLogging(t *testing.T) {
+	t.Cleanup(func() {
+		log.SetFlags(log.LstdFlags)
+		log.SetOutput(os.Stderr)
+		gin.DefaultWriter = os.Stdout
+		gin.SetMode(gin.TestMode)
+	})
+}
+
+func TestApplyAppProfile_ProdDefaults(t *testing.T) {
+	restoreLogging(t)
+	unsetEnv(t, "GIN_MODE", "LOG_FORMAT", "CORS_ALLOWED_ORIGINS", "RATE_LIMIT_ENABLED", "AI_REQUEST_TIMEOUT_SECONDS", "HEALTH_CHECK_TIMEOUT_SECONDS")
+	t.Setenv("APP_ENV", "PROD")
+	t.Setenv("REQUEST_TIMEOUT_SECONDS", "90")
+
+	env, err := applyAppProfile()
+	if err != nil || env != appEnvProd {
+		t.Fatalf("expected the prod profile, got %q, %v", env, err)
+	}
+	if gin.Mode() != gin.ReleaseMode || !getRateLimitEnabled() || getAITimeout().Seconds() != 25 {
+		t.Errorf("expected prod defaults, got mode %s, rate limiting %v, AI timeout %s", gin.Mode(), getRateLimitEnabled(), getAITimeout())
+	}
+	if getRequestTimeout().Seconds() != 90 {
+		t.Errorf("expected an explicit value to win over the profile, got %s", getRequestTimeout())
+	}
+	if origins := loadConfig().CORSOrigins; len(origins) != 0 {
+		t.Errorf("expected no CORS origins by default in prod, got %v", origins)
+	}
+}
+
+func TestApplyAppProfile_DevKeepsDefaults(t *testing.T) {
+	restoreLogging(t)
+	unsetEnv(t, "APP_ENV", "GIN_MODE", "LOG_FORMAT", "CORS_ALLOWED_ORIGINS", "RATE_LIMIT_ENABLED")
+
+	if env, err := applyAppProfile(); err != nil || env != appEnvDev {
+		t.Fatalf("expected the dev profile by default, got %q, %v", env, err)
+	}
+	if getRateLimitEnabled() {
+		t.Error("expected rate limiting to stay off in dev")
+	}
+	if origins := loadConfig().CORSOrigins; len(origins) != 1 || origins[0] != "http://localhost:3001" {
+		t.Errorf("expected the local frontend origin in dev, got %v", origins)
+	}
+}
+
+func TestApplyAppProfile_RejectsUnknown(t *testing.T) {
+	restoreLogging(t)
+	t.Setenv("APP_ENV", "qa")
+	if _, err := applyAppProfile(); err == nil {
+		t.Error("expected an unknown APP_ENV to be rejected")
+	}
+	t.Setenv("APP_ENV", appEnvDev)
+	t.Setenv("LOG_FORMAT", "xml")
+	if _, err := applyAppProfile(); err == nil {
+		t.Error("expected an unknown LOG_FORMAT to be rejected")
+	}
+}
+
+func TestValidateProfile_ProdRefusesDefaults(t *testing.T) {
+	t.Setenv("APP_ENV", appEnvProd)
+	t.Setenv("RECIPIENT_ADDRESS", "0x742d35Cc6634C0532925a3b844Bc9e7595f8fE21")
+	t.Setenv("SERVER_WALLET_PRIVATE_KEY", "4c0883a69102937d6231471b5dbb6204fe5129617082792ae468d01a3f362318")
+	t.Setenv("CORS_ALLOWED_ORIGINS", "https://app.example.com")
+	if err := loadConfig().Validate(); err != nil {
+		t.Fatalf("expected a configured prod deployment to be valid, got %v", err)
+	}
+
+	for name, env := range map[string][2]string{
+		"default recipient": {"RECIPIENT_ADDRESS", ""},
+		"example key":       {"SERVER_WALLET_PRIVATE_KEY", "your_private_key_here"},
+		"test key":          {"SERVER_WALLET_PRIVATE_KEY", "0x0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef"},
+		"wildcard origin":   {"CORS_ALLOWED_ORIGINS", "*"},
+	} {
+		t.Run(name, func(t *testing.T) {
+			t.Setenv(env[0], env[1])
+			if err := loadConfig().Validate(); err == nil || !strings.Contains(err.Error(), "APP_ENV=prod") {
+				t.Errorf("expected prod to refuse it, got %v", err)
+			}
+		})
+	}
+
+	t.Setenv("APP_ENV", appEnvStaging)
+	t.Setenv("RECIPIENT_ADDRESS", "")
+	if err := loadConfig().Validate(); err != nil {
+		t.Errorf("expected staging to allow the default recipient, got %v", err)
+	}
+}
+
+func TestJSONLogWriter(t *testing.T) {
+	var buf bytes.Buffer
+	logger := log.New(jsonLogWriter{out: &buf}, "", 0)
+	logger.Printf("[WARNING] Provider slow: %dms", 1200)
+
+	var line struct {
+		Time, Level, Msg string
+	}
+	if err := json.Unmarshal(buf.Bytes(), &line); err != nil {
+		t.Fatalf("expected a JSON line, got %q: %v", buf.String(), err)
+	}
+	if line.Level != "warning" || line.Msg != "Provider slow: 1200ms" || line.Time == "" {
+		t.Errorf("unexpected log line %+v", line)
+	}
+}
@@ -0,0 +1,81 @@
+package server
+
+import (
+	"regexp"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Prompt sanitization levels, PROMPT_SANITIZATION.
+const (
+	// sanitizeOff passes input to the prompt unchanged.
+	sanitizeOff = "off"
+	// sanitizeStandard neutralizes explicit attempts to override the
+	// instructions and chat template control tokens.
+	sanitizeStandard = "standard"
+	// sanitizeStrict also neutralizes role prefixes, persona switches and
+	// requests to answer instead of summarizing, at the cost of sometimes
+	// altering harmless text that quotes them.
+	sanitizeStrict = "strict"
+)
+
+// injectionPlaceholder replaces each neutralized span.
+const injectionPlaceholder = "[filtered]"
+
+// standardInjectionPatterns are neutralized at sanitizeStandard and above.
+var standardInjectionPatterns = []*regexp.Regexp{
+	regexp.MustCompile(`(?i)\b(?:ignore|disregard|forget|override|bypass)\s+(?:(?:all|any|every|the|your|of|these|those)\s+)*(?:previous|prior|above|earlier|preceding|system|original|initial)\s+(?:instructions?|prompts?|directions?|directives?|rules?|context|messages?)`),
+	regexp.MustCompile(`(?i)\b(?:new|updated|real|actual)\s+(?:system\s+)?instructions?\s*:`),
+	regexp.MustCompile(`(?i)\byou\s+are\s+now\s+(?:a|an|in|the|my)\b`),
+	regexp.MustCompile(`(?i)\b(?:reveal|print|show|repeat|output)\s+(?:me\s+)?(?:your|the)\s+(?:system\s+|hidden\s+|initial\s+)?(?:prompt|instructions)`),
+	regexp.MustCompile(`<\|(?:im_start|im_end|system|user|assistant|endoftext)\|>|\[/?INST\]|<<\/?SYS>>`),
+}
+
+// strictInjectionPatterns are additionally neutralized at sanitizeStrict.
+var strictInjectionPatterns = []*regexp.Regexp{
+	regexp.MustCompile(`(?im)^\s*(?:system|assistant|user|developer)\s*:`),
+	regexp.MustCompile(`(?im)^\s*#{1,6}\s*(?:system|instructions?|prompt)\b`),
+	regexp.MustCompile(`(?i)\b(?:act|pretend|roleplay|behave)\s+(?:as|like|to\s+be)\b`),
+	regexp.MustCompile(`(?i)\bjailbreak\b|\bdeveloper\s+mode\b|\bDAN\s+mode\b`),
+	regexp.MustCompile(`(?i)\b(?:do\s+not|don't|instead\s+of)\s+summari[sz](?:e|ing)\b`),
+	regexp.MustCompile(`(?i)\binstead\s*,?\s+(?:write|say|output|respond|reply|answer)\b`),
+}
+
+// sanitizeInjections returns text with the injection patterns of level
+// replaced by injectionPlaceholder, and whether anything was replaced.
+func sanitizeInjections(text, level string) (string, bool) {
+	var patterns []*regexp.Regexp
+	switch level {
+	case sanitizeStandard:
+		patterns = standardInjectionPatterns
+	case sanitizeStrict:
+		patterns = append(append(patterns, standardInjectionPatterns...), strictInjectionPatterns...)
+	default:
+		return text, false
+	}
+	out := text
+	for _, p := range patterns {
+		out = p.ReplaceAllString(out, injectionPlaceholder)
+	}
+	return out, out != text
+}
+
+// sanitizeInput neutralizes prompt injection in text before it is put into
+// the prompt, at PROMPT_SANITIZATION strictness, and reports in
+// X-Prompt-Sanitized whether it changed anything. The sanitized text is
+// what is cached, summarized and retained for replay. Later calls return
+// the stored result.
+func sanitizeInput(c *gin.Context, text string) string {
+	if v, ok := c.Get("sanitized_text"); ok {
+		return v.(string)
+	}
+	level := getConfig().PromptSanitization
+	if level == sanitizeOff {
+		return text
+	}
+	out, changed := sanitizeInjections(text, level)
+	c.Set("sanitized_text", out)
+	c.Header("X-Prompt-Sanitized", strconv.FormatBool(changed))
+	return out
+}
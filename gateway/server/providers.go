@@ -0,0 +1,122 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"time"
+
+	"gateway/providers"
+)
+
+// The provider clients live in gateway/providers; the aliases keep
+// handlers unchanged.
+type (
+	AIProvider    = providers.Provider
+	ProviderUsage = providers.Usage
+)
+
+// providerResult is a summary and the provider and model that produced it.
+type providerResult struct {
+	Summary  string
+	Usage    ProviderUsage
+	Provider string
+	Model    string
+}
+
+// callAIProviders summarizes text with the AI_PROVIDERS chain in order: when
+// a provider errors or times out, the next one is tried within whatever is
+// left of ctx's deadline. The circuit breaker sees the chain as a single
+// provider call, so it only counts a failure when every provider failed.
+func callAIProviders(ctx context.Context, model, text string, params GenerationParams) (res providerResult, err error) {
+	cfg := getConfig()
+	start := time.Now()
+	defer func() {
+		providerCircuit.Record(cfg, isModelFailure(err))
+		gatewayStats.RecordAICall(time.Now(), time.Since(start))
+	}()
+
+	for i, p := range cfg.AIProviders {
+		if i > 0 && ctx.Err() != nil {
+			break
+		}
+		attemptModel := model
+		if p.Model != "" {
+			attemptModel = p.Model
+		}
+		attemptCtx, cancel := providerAttemptContext(ctx, cfg, len(cfg.AIProviders)-i)
+		summary, usage, callErr := callProvider(attemptCtx, p, attemptModel, text, params)
+		cancel()
+		if callErr == nil {
+			if i > 0 {
+				log.Printf("AI provider %s served the request after %d failed", p.Name, i)
+			}
+			return providerResult{Summary: summary, Usage: usage, Provider: p.Name, Model: attemptModel}, nil
+		}
+		err = callErr
+		if errors.Is(callErr, context.Canceled) {
+			// The client went away; there is nobody left to serve.
+			break
+		}
+		if i < len(cfg.AIProviders)-1 {
+			log.Printf("[WARNING] AI provider %s failed, trying the next: %v", p.Name, callErr)
+		}
+	}
+	if ctx.Err() == context.DeadlineExceeded {
+		err = context.DeadlineExceeded
+	}
+	return providerResult{}, err
+}
+
+// providerAttemptContext bounds one attempt while fallbacks remain, either
+// to AI_PROVIDER_ATTEMPT_TIMEOUT_SECONDS or to an even share of the time
+// left, so a hung provider cannot use up the whole deadline.
+func providerAttemptContext(ctx context.Context, cfg *Config, remaining int) (context.Context, context.CancelFunc) {
+	if remaining <= 1 {
+		return context.WithCancel(ctx)
+	}
+	if cfg.ProviderAttemptTimeout > 0 {
+		return context.WithTimeout(ctx, cfg.ProviderAttemptTimeout)
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		return context.WithTimeout(ctx, time.Until(deadline)/time.Duration(remaining))
+	}
+	return context.WithCancel(ctx)
+}
+
+// callProvider sends one summarization request to p and records the model's
+// health.
+func callProvider(ctx context.Context, p AIProvider, model, text string, params GenerationParams) (summary string, usage ProviderUsage, err error) {
+	start := time.Now()
+	defer func() {
+		modelHealth.Record(model, time.Since(start), isModelFailure(err))
+	}()
+
+	body := map[string]interface{}{
+		"model": model,
+		"messages": []map[string]string{
+			{"role": "user", "content": summarizePrompt(text, params)},
+		},
+	}
+	applyGenerationParams(body, params)
+	header := http.Header{}
+	if cid, ok := ctx.Value(correlationIDKey).(string); ok {
+		header.Set("X-Correlation-ID", cid)
+	}
+	// Use the pooled provider client and rely on ctx for cancellation/timeouts.
+	return p.Complete(ctx, getConfig().ProviderHTTPClient(), body, header)
+}
+
+// servedBy returns sel updated with the provider and model that produced
+// res. A provider pinned to its own model counts as a substitution.
+func (sel ModelSelection) servedBy(res providerResult) ModelSelection {
+	sel.Provider = res.Provider
+	if res.Model != "" && res.Model != sel.Model {
+		if sel.SubstitutedFor == "" {
+			sel.SubstitutedFor = sel.Model
+		}
+		sel.Model = res.Model
+	}
+	return sel
+}
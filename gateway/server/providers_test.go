@@ -0,0 +1,128 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"gateway/internal/testsupport"
+)
+
+func TestAIProvidersValidation(t *testing.T) {
+	t.Setenv("AI_PROVIDERS", "openrouter,bogus")
+	if err := loadConfig().Validate(); err == nil || !strings.Contains(err.Error(), "AI_PROVIDERS") {
+		t.Errorf("expected an AI_PROVIDERS validation error, got %v", err)
+	}
+}
+
+func TestProviderAttemptContext(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	// An even share of what is left while fallbacks remain...
+	attempt, stop := providerAttemptContext(ctx, &Config{}, 3)
+	deadline, _ := attempt.Deadline()
+	stop()
+	if left := time.Until(deadline); left > time.Second || left < 900*time.Millisecond {
+		t.Errorf("expected about a third of the deadline, got %v", left)
+	}
+	// ...the configured per-attempt timeout when set...
+	attempt, stop = providerAttemptContext(ctx, &Config{ProviderAttemptTimeout: 200 * time.Millisecond}, 2)
+	deadline, _ = attempt.Deadline()
+	stop()
+	if left := time.Until(deadline); left > 200*time.Millisecond {
+		t.Errorf("expected the per-attempt timeout, got %v", left)
+	}
+	// ...and everything that is left for the last provider.
+	attempt, stop = providerAttemptContext(ctx, &Config{ProviderAttemptTimeout: 200 * time.Millisecond}, 1)
+	last, _ := attempt.Deadline()
+	stop()
+	if want, _ := ctx.Deadline(); !last.Equal(want) {
+		t.Errorf("expected the last attempt to keep the request deadline, got %v", last)
+	}
+}
+
+func TestCallAIProviders_TimeoutFallsThroughWithinDeadline(t *testing.T) {
+	withCircuit(t)
+	withModelHealth(t)
+	primary := testsupport.NewFakeOpenRouter(t)
+	primary.SetDelay(2 * time.Second)
+	backup := testsupport.NewFakeOpenRouter(t)
+	backup.SetReply("local summary")
+	t.Setenv("AI_PROVIDERS", "openrouter,ollama")
+	t.Setenv("OPENROUTER_URL", primary.URL)
+	t.Setenv("OLLAMA_URL", backup.URL)
+	t.Setenv("OLLAMA_MODEL", "llama-test")
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	start := time.Now()
+	res, err := callAIProviders(ctx, "routed/model", "hello", GenerationParams{})
+	if err != nil {
+		t.Fatalf("expected the fallback to answer, got %v", err)
+	}
+	if res.Provider != "ollama" || res.Model != "llama-test" || res.Summary != "local summary" {
+		t.Errorf("unexpected result %+v", res)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("the chain overran the request deadline: %v", elapsed)
+	}
+	if got := backup.Requests()[0]; got["usage"] != nil {
+		t.Errorf("usage accounting is OpenRouter-specific, got %v", got["usage"])
+	}
+	if providerCircuit.Status(getConfig())["consecutive_failures"] != 0 {
+		t.Errorf("a served chain should not count against the circuit: %v", providerCircuit.Status(getConfig()))
+	}
+
+	// When every provider fails the last error is returned.
+	backup.SetStatus(http.StatusInternalServerError)
+	primary.SetDelay(0)
+	primary.SetStatus(http.StatusInternalServerError)
+	if _, err := callAIProviders(context.Background(), "routed/model", "hello", GenerationParams{}); err == nil {
+		t.Error("expected an error when every provider fails")
+	}
+}
+
+func TestSummarize_ReceiptRecordsServingProvider(t *testing.T) {
+	backup := testsupport.NewFakeOpenRouter(t)
+	backup.SetReply("fallback summary")
+	gw := startGateway(t, map[string]string{
+		"AI_PROVIDERS": "openrouter,openai",
+		"OPENAI_URL":   backup.URL,
+		"OPENAI_MODEL": "gpt-test",
+	})
+
+	resp := gw.Post(t, "/api/ai/summarize", `{"text":"primary works"}`, "0xsig", "nonce-provider-1")
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected 200, got %d", resp.StatusCode)
+	}
+	service := decodeReceiptHeader(t, resp.Header.Get("X-402-Receipt")).Receipt.Service
+	if service.Provider != "openrouter" || service.SubstitutedFor != "" || backup.Calls() != 0 {
+		t.Errorf("expected the primary to serve, got %+v and %d fallback calls", service, backup.Calls())
+	}
+
+	gw.AI.SetStatus(http.StatusBadGateway)
+	resp = gw.Post(t, "/api/ai/summarize", `{"text":"primary down"}`, "0xsig", "nonce-provider-2")
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected the fallback to serve, got %d", resp.StatusCode)
+	}
+	service = decodeReceiptHeader(t, resp.Header.Get("X-402-Receipt")).Receipt.Service
+	if service.Provider != "openai" || service.Model != "gpt-test" || service.SubstitutedFor == "" {
+		t.Errorf("expected the receipt to name the fallback provider and model, got %+v", service)
+	}
+	if !slices.Equal(backup.Models(), []string{"gpt-test"}) {
+		t.Errorf("expected the fallback to be asked for its own model, got %v", backup.Models())
+	}
+
+	// The fallback answer was not cached under the routed model.
+	time.Sleep(100 * time.Millisecond)
+	gw.AI.SetStatus(http.StatusOK)
+	primaryCalls := gw.AI.Calls()
+	gw.Post(t, "/api/ai/summarize", `{"text":"primary down"}`, "0xsig", "nonce-provider-3")
+	if gw.AI.Calls() != primaryCalls+1 {
+		t.Error("expected the repeat request to reach the primary instead of a cached fallback answer")
+	}
+}
@@ -0,0 +1,86 @@
+package server
+
+import (
+	"log"
+	"strconv"
+	"time"
+
+	"gateway/payments"
+
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/gin-gonic/gin"
+)
+
+// QuoteConfig controls signed price quotes. Every 402 paymentContext is
+// signed with the server key and valid for TTL; with Required set, paid
+// requests must echo a valid quote signature.
+type QuoteConfig struct {
+	TTL      time.Duration
+	Required bool
+}
+
+// signQuote stamps payment with an expiry and the server's quote signature.
+// Without a server key the quote is left unsigned.
+func signQuote(payment *PaymentContext) {
+	signQuoteUntil(payment, time.Now().Add(getConfig().Quotes.TTL))
+}
+
+// signQuoteUntil is signQuote with an explicit expiry.
+func signQuoteUntil(payment *PaymentContext, expiry time.Time) {
+	signer, err := getServerSigner()
+	if err != nil {
+		return
+	}
+	payment.Expiry = expiry.Unix()
+	sig, err := payments.SignQuote(*payment, signer)
+	if err != nil {
+		log.Printf("[WARNING] Failed to sign payment quote: %v", err)
+		payment.Expiry = 0
+		return
+	}
+	payment.QuoteSignature = sig
+}
+
+// checkQuote verifies the signed quote echoed in X-402-Quote-Signature and
+// X-402-Quote-Expiry against chain and the price the request is charged now. On
+// failure it aborts with 402 and a fresh paymentContext and returns false.
+// Requests without a quote pass unless QUOTE_SIGNATURE_REQUIRED is set.
+func checkQuote(c *gin.Context, chain ChainOption, nonce, amount string) bool {
+	sig := c.GetHeader("X-402-Quote-Signature")
+	if sig == "" {
+		if !getConfig().Quotes.Required {
+			return true
+		}
+		rejectQuote(c, amount, CodeQuoteRequired, "Echo the quoteSignature and expiry from the payment context")
+		return false
+	}
+
+	expiry, err := strconv.ParseInt(c.GetHeader("X-402-Quote-Expiry"), 10, 64)
+	if err != nil || expiry <= 0 {
+		rejectQuote(c, amount, CodeQuoteInvalid, "X-402-Quote-Expiry must be the quote's unix expiry time")
+		return false
+	}
+	if time.Now().Unix() > expiry {
+		rejectQuote(c, amount, CodeQuoteExpired, "The price quote has expired; sign the new payment context")
+		return false
+	}
+
+	signer, err := getServerSigner()
+	if err != nil {
+		abortWithError(c, CodeQuoteUnavailable, "Server signing key is not configured")
+		return false
+	}
+	quote := paymentContextFor(chain, amount, nonce)
+	quote.Expiry, quote.QuoteSignature = expiry, sig
+	quoteSigner, err := payments.RecoverQuoteSigner(quote)
+	if err != nil || quoteSigner != crypto.PubkeyToAddress(*signer.Public()) {
+		rejectQuote(c, amount, CodeQuoteMismatch, "The signed quote does not match this request's price, nonce, chain or recipient")
+		return false
+	}
+	return true
+}
+
+// rejectQuote aborts with 402 and a newly signed payment context for amount.
+func rejectQuote(c *gin.Context, amount string, code ErrorCode, message string) {
+	respondPaymentRequired(c, amount, newAPIError(code, message))
+}
@@ -0,0 +1,137 @@
+package server
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"testing"
+	"time"
+
+	"gateway/internal/testsupport"
+	"gateway/payments"
+	"gateway/signing"
+
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+// requestQuote fetches a 402 challenge and returns its payment context.
+func requestQuote(t *testing.T, h *testsupport.Harness, body string) PaymentContext {
+	t.Helper()
+	resp := h.Post(t, "/api/ai/summarize", body, "", "")
+	if resp.StatusCode != http.StatusPaymentRequired {
+		t.Fatalf("expected 402, got %d", resp.StatusCode)
+	}
+	var challenge struct {
+		PaymentContext PaymentContext `json:"paymentContext"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&challenge); err != nil {
+		t.Fatal(err)
+	}
+	return challenge.PaymentContext
+}
+
+// postWithQuote sends a paid request echoing quote's signature and expiry.
+func postWithQuote(t *testing.T, h *testsupport.Harness, body string, quote PaymentContext) (int, string) {
+	t.Helper()
+	req, _ := http.NewRequest(http.MethodPost, h.Server.URL+"/api/ai/summarize", bytes.NewBufferString(body))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-402-Signature", "0xsig")
+	req.Header.Set("X-402-Nonce", quote.Nonce)
+	req.Header.Set("X-402-Quote-Signature", quote.QuoteSignature)
+	req.Header.Set("X-402-Quote-Expiry", strconv.FormatInt(quote.Expiry, 10))
+	resp, err := h.Server.Client().Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	var out struct {
+		Error string `json:"error"`
+	}
+	_ = json.NewDecoder(resp.Body).Decode(&out)
+	return resp.StatusCode, out.Error
+}
+
+func TestQuote_402IsSignedByServer(t *testing.T) {
+	h := testsupport.NewHarness(t, newTestRouter)
+	quote := requestQuote(t, h, `{"text":"hello"}`)
+
+	if quote.QuoteSignature == "" || quote.Expiry <= time.Now().Unix() {
+		t.Fatalf("expected a signed quote with a future expiry, got %+v", quote)
+	}
+	key, _ := getServerPrivateKey()
+	signer, err := payments.RecoverQuoteSigner(quote)
+	if err != nil || signer != crypto.PubkeyToAddress(key.PublicKey) {
+		t.Errorf("quote should be signed by the server key, got %s (%v)", signer.Hex(), err)
+	}
+}
+
+func TestQuote_EchoedQuoteIsVerified(t *testing.T) {
+	h := testsupport.NewHarness(t, newTestRouter)
+	quote := requestQuote(t, h, `{"text":"hello"}`)
+
+	if code, errCode := postWithQuote(t, h, `{"text":"hello"}`, quote); code != http.StatusOK {
+		t.Fatalf("valid quote: expected 200, got %d %s", code, errCode)
+	}
+
+	// A quote for another nonce (or price) does not match.
+	other := quote
+	other.Nonce = "another-nonce"
+	if code, errCode := postWithQuote(t, h, `{"text":"hello"}`, other); code != http.StatusPaymentRequired || errCode != "Quote Mismatch" {
+		t.Errorf("mismatched quote: expected 402 Quote Mismatch, got %d %s", code, errCode)
+	}
+
+	key, _ := getServerPrivateKey()
+	expired := quote
+	expired.Expiry = time.Now().Add(-time.Minute).Unix()
+	expired.QuoteSignature, _ = payments.SignQuote(expired, signing.FromKey(key))
+	if code, errCode := postWithQuote(t, h, `{"text":"hello"}`, expired); code != http.StatusPaymentRequired || errCode != "Quote Expired" {
+		t.Errorf("expired quote: expected 402 Quote Expired, got %d %s", code, errCode)
+	}
+}
+
+func TestQuote_PriceSwitchIsRejected(t *testing.T) {
+	t.Setenv("PAYMENT_AMOUNT", "0.001")
+	h := testsupport.NewHarness(t, newTestRouter)
+	quote := requestQuote(t, h, `{"text":"hello"}`)
+
+	t.Setenv("PAYMENT_AMOUNT", "0.002")
+	if code, errCode := postWithQuote(t, h, `{"text":"hello"}`, quote); code != http.StatusPaymentRequired || errCode != "Quote Mismatch" {
+		t.Errorf("price changed after quoting: expected 402 Quote Mismatch, got %d %s", code, errCode)
+	}
+}
+
+func TestQuote_Required(t *testing.T) {
+	t.Setenv("QUOTE_SIGNATURE_REQUIRED", "true")
+	h := testsupport.NewHarness(t, newTestRouter)
+
+	resp := h.Post(t, "/api/ai/summarize", `{"text":"hello"}`, "0xsig", "nonce")
+	var out struct {
+		Error          string         `json:"error"`
+		PaymentContext PaymentContext `json:"paymentContext"`
+	}
+	_ = json.NewDecoder(resp.Body).Decode(&out)
+	if resp.StatusCode != http.StatusPaymentRequired || out.Error != "Quote Required" || out.PaymentContext.QuoteSignature == "" {
+		t.Fatalf("expected 402 Quote Required with a new signed quote, got %d %+v", resp.StatusCode, out)
+	}
+}
+
+func TestQuote_V2PaymentHeader(t *testing.T) {
+	t.Setenv("QUOTE_SIGNATURE_REQUIRED", "true")
+	h := testsupport.NewHarness(t, newTestRouter)
+	quote := requestQuote(t, h, `{"text":"hello"}`)
+
+	payment, _ := json.Marshal(paymentHeaderV2{Signature: "0xsig", Nonce: quote.Nonce, QuoteSignature: quote.QuoteSignature, Expiry: quote.Expiry})
+	req, _ := http.NewRequest(http.MethodPost, h.Server.URL+"/api/v2/ai/summarize", bytes.NewBufferString(`{"input":"hello"}`))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-PAYMENT", base64.StdEncoding.EncodeToString(payment))
+	resp, err := h.Server.Client().Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("quote in X-PAYMENT: expected 200, got %d", resp.StatusCode)
+	}
+}
@@ -0,0 +1,94 @@
+package server
+
+import (
+	"log"
+	"maps"
+	"sort"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+)
+
+// RateLimitTierState is a tier's limits and how many client buckets it is
+// tracking.
+type RateLimitTierState struct {
+	Tier    string `json:"tier"`
+	RPM     int    `json:"rpm"`
+	Burst   int    `json:"burst"`
+	Buckets int    `json:"buckets"`
+}
+
+// rateLimitTierState describes tier under cfg.
+func rateLimitTierState(cfg *Config, tier string) RateLimitTierState {
+	limits := cfg.RateLimits[tier]
+	return RateLimitTierState{Tier: tier, RPM: limits.RPM, Burst: limits.Burst, Buckets: rateLimitBuckets()[tier]}
+}
+
+// rateLimitAdminMu serializes admin tier changes so concurrent ones do not
+// drop each other's snapshot.
+var rateLimitAdminMu sync.Mutex
+
+// setTierLimits installs a config snapshot with tier's limits replaced and
+// tunes its limiter in place, keeping every client's bucket. Like any other
+// snapshot it lasts until the next config reload.
+func setTierLimits(tier string, limits RateLimitTier) *Config {
+	rateLimitAdminMu.Lock()
+	defer rateLimitAdminMu.Unlock()
+	next := *getConfig()
+	next.RateLimits = maps.Clone(next.RateLimits)
+	next.RateLimits[tier] = limits
+	currentConfig.Store(&next)
+	if activeRateLimiters.Load() != nil {
+		applyRateLimits(next.RateLimits)
+	}
+	return &next
+}
+
+// handleListRateLimits handles GET /api/admin/rate-limits.
+func handleListRateLimits(c *gin.Context) {
+	cfg := getConfig()
+	tiers := make([]RateLimitTierState, 0, len(cfg.RateLimits))
+	for tier := range cfg.RateLimits {
+		tiers = append(tiers, rateLimitTierState(cfg, tier))
+	}
+	sort.Slice(tiers, func(i, j int) bool { return tiers[i].Tier < tiers[j].Tier })
+	c.JSON(200, gin.H{"enabled": getRateLimitEnabled(), "tiers": tiers})
+}
+
+// handleSetRateLimit handles PUT /api/admin/rate-limits/:tier with body
+// {"rpm": 120, "burst": 40}. Either field may be omitted to keep its value.
+// Existing buckets are rescaled to the new burst rather than reset.
+func handleSetRateLimit(c *gin.Context) {
+	tier := c.Param("tier")
+	limits, ok := getConfig().RateLimits[tier]
+	if !ok {
+		respondError(c, CodeNotFound, "Unknown rate limit tier")
+		return
+	}
+	var req struct {
+		RPM   *int `json:"rpm"`
+		Burst *int `json:"burst"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		respondError(c, CodeInvalidRequest, "Request must be valid JSON")
+		return
+	}
+	if req.RPM == nil && req.Burst == nil {
+		respondError(c, CodeInvalidRequest, "rpm or burst is required")
+		return
+	}
+	if req.RPM != nil {
+		limits.RPM = *req.RPM
+	}
+	if req.Burst != nil {
+		limits.Burst = *req.Burst
+	}
+	if limits.RPM <= 0 || limits.Burst <= 0 {
+		respondError(c, CodeInvalidRequest, "rpm and burst must be positive")
+		return
+	}
+
+	cfg := setTierLimits(tier, limits)
+	log.Printf("Rate limit tier %s set to %d rpm, burst %d by admin", tier, limits.RPM, limits.Burst)
+	c.JSON(200, rateLimitTierState(cfg, tier))
+}
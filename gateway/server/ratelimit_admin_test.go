@@ -0,0 +1,94 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"gateway/ratelimit"
+)
+
+func adminPut(t *testing.T, h http.Handler, path, token, body string) *httptest.ResponseRecorder {
+	t.Helper()
+	req := httptest.NewRequest(http.MethodPut, path, bytes.NewBufferString(body))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+token)
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	return w
+}
+
+func TestAdminRateLimits_RetunesInPlace(t *testing.T) {
+	resetConfigSnapshot(t)
+	t.Setenv("ADMIN_API_KEY", "s3cret")
+	t.Setenv("RATE_LIMIT_ENABLED", "true")
+	t.Setenv("RATE_LIMIT_ANONYMOUS_RPM", "1")
+	t.Setenv("RATE_LIMIT_ANONYMOUS_BURST", "100")
+	t.Setenv("RATE_LIMIT_STANDARD_BURST", "4")
+	currentConfig.Store(loadConfig())
+	t.Cleanup(func() {
+		for _, limiter := range getActiveRateLimiters() {
+			limiter.(*ratelimit.TokenBucket).Stop()
+		}
+		activeRateLimiters.Store(nil)
+	})
+	router := newTestRouter()
+
+	standard := getActiveRateLimiters()["standard"]
+	standard.Allow("ip:203.0.113.9")
+	standard.Allow("ip:203.0.113.9")
+
+	w := adminPut(t, router, "/api/admin/rate-limits/standard", "s3cret", `{"burst": 8}`)
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+	var state RateLimitTierState
+	json.Unmarshal(w.Body.Bytes(), &state)
+	if state.Burst != 8 || state.RPM != 60 || state.Buckets != 1 {
+		t.Errorf("unexpected tier state %+v", state)
+	}
+	if getActiveRateLimiters()["standard"] != standard {
+		t.Fatal("expected the standard limiter to be tuned in place")
+	}
+	if got := standard.GetRemaining("ip:203.0.113.9"); got != 4 {
+		t.Errorf("expected the half-used bucket to keep half of the new burst, got %d", got)
+	}
+	if got := getLimitForTier("standard"); got != 60 {
+		t.Errorf("expected headers to keep the standard rpm, got %d", got)
+	}
+
+	// A config reload that changes the tier again also keeps the bucket.
+	t.Setenv("RATE_LIMIT_STANDARD_BURST", "16")
+	reloadRateLimiters(getConfig(), loadConfig())
+	if getActiveRateLimiters()["standard"] != standard {
+		t.Fatal("expected a reload to tune the standard limiter in place")
+	}
+	if got := standard.GetRemaining("ip:203.0.113.9"); got != 8 {
+		t.Errorf("expected the bucket to be rescaled to 8 of 16 on reload, got %d", got)
+	}
+
+	for body, want := range map[string]int{
+		`{}`:            http.StatusBadRequest,
+		`{"rpm": 0}`:    http.StatusBadRequest,
+		`{"burst": -1}`: http.StatusBadRequest,
+	} {
+		if w := adminPut(t, router, "/api/admin/rate-limits/standard", "s3cret", body); w.Code != want {
+			t.Errorf("%s: expected %d, got %d", body, want, w.Code)
+		}
+	}
+	if w := adminPut(t, router, "/api/admin/rate-limits/platinum", "s3cret", `{"rpm": 10}`); w.Code != http.StatusNotFound {
+		t.Errorf("expected 404 for an unknown tier, got %d", w.Code)
+	}
+
+	w = adminGet(t, router, "/api/admin/rate-limits", "s3cret")
+	var list struct {
+		Enabled bool                 `json:"enabled"`
+		Tiers   []RateLimitTierState `json:"tiers"`
+	}
+	json.Unmarshal(w.Body.Bytes(), &list)
+	if !list.Enabled || len(list.Tiers) != 3 || list.Tiers[1].Tier != "standard" || list.Tiers[1].Burst != 8 || list.Tiers[1].Buckets != 1 {
+		t.Errorf("unexpected rate limit list %+v", list)
+	}
+}
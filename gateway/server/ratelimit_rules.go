@@ -0,0 +1,241 @@
+package server
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/netip"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"gateway/ratelimit"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/gin-gonic/gin"
+	"gopkg.in/yaml.v3"
+)
+
+// RateLimitRules are the exemptions and overrides read from
+// RATE_LIMIT_RULES_FILE. Exempt requests skip the limiter, wallets listed in
+// Wallets get their own limits and Routes scale the tier limits of matching
+// paths. With DryRun set the rules are only evaluated and logged.
+type RateLimitRules struct {
+	DryRun  bool                `yaml:"dry_run"`
+	Exempt  RateLimitExemptions `yaml:"exempt"`
+	Wallets []WalletRateLimit   `yaml:"wallets"`
+	Routes  []RouteRateLimit    `yaml:"routes"`
+
+	networks []netip.Prefix
+	wallets  map[common.Address]WalletRateLimit
+}
+
+// RateLimitExemptions match requests that are never rate limited: client IPs
+// or CIDRs, case-insensitive User-Agent substrings (health checkers) and
+// request paths.
+type RateLimitExemptions struct {
+	CIDRs      []string `yaml:"cidrs"`
+	UserAgents []string `yaml:"user_agents"`
+	Paths      []string `yaml:"paths"`
+}
+
+// WalletRateLimit replaces the tier limits of requests signed by Address,
+// counted per wallet rather than per nonce.
+type WalletRateLimit struct {
+	Address string `yaml:"address"`
+	RPM     int    `yaml:"rpm"`
+	Burst   int    `yaml:"burst"`
+}
+
+// RouteRateLimit multiplies the tier limits on Path, an exact path or a
+// prefix ending in "*".
+type RouteRateLimit struct {
+	Path       string  `yaml:"path"`
+	Multiplier float64 `yaml:"multiplier"`
+}
+
+// loadRateLimitRules reads and validates the rules file at path. An empty
+// path means no rules.
+func loadRateLimitRules(path string) (*RateLimitRules, error) {
+	if path == "" {
+		return nil, nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return parseRateLimitRules(data)
+}
+
+// parseRateLimitRules parses a YAML rules document.
+func parseRateLimitRules(data []byte) (*RateLimitRules, error) {
+	var rules RateLimitRules
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	dec.KnownFields(true)
+	if err := dec.Decode(&rules); err != nil && !errors.Is(err, io.EOF) {
+		return nil, err
+	}
+
+	networks, err := parsePrefixes(rules.Exempt.CIDRs)
+	if err != nil {
+		return nil, fmt.Errorf("exempt: %w", err)
+	}
+	rules.networks = networks
+	rules.wallets = make(map[common.Address]WalletRateLimit, len(rules.Wallets))
+	for _, w := range rules.Wallets {
+		if !common.IsHexAddress(w.Address) {
+			return nil, fmt.Errorf("wallet %q is not an address", w.Address)
+		}
+		if w.RPM <= 0 || w.Burst <= 0 {
+			return nil, fmt.Errorf("wallet %s: rpm and burst must be positive", w.Address)
+		}
+		rules.wallets[common.HexToAddress(w.Address)] = w
+	}
+	for _, r := range rules.Routes {
+		if !strings.HasPrefix(r.Path, "/") {
+			return nil, fmt.Errorf("route %q must start with /", r.Path)
+		}
+		if r.Multiplier <= 0 {
+			return nil, fmt.Errorf("route %s: multiplier must be positive", r.Path)
+		}
+	}
+	return &rules, nil
+}
+
+// rateLimitDecision is how one request is rate limited.
+type rateLimitDecision struct {
+	rule    string // the rule that applied, empty for the tier default
+	exempt  bool
+	limiter ratelimit.RateLimiter
+	key     string
+	limit   int
+}
+
+// exemption returns the exemption rule matching the request, if any.
+func (r *RateLimitRules) exemption(c *gin.Context) string {
+	if ip, err := netip.ParseAddr(c.ClientIP()); err == nil {
+		ip = ip.Unmap()
+		for _, p := range r.networks {
+			if p.Contains(ip) {
+				return "exempt cidr " + p.String()
+			}
+		}
+	}
+	if ua := strings.ToLower(c.Request.UserAgent()); ua != "" {
+		for _, sub := range r.Exempt.UserAgents {
+			if sub != "" && strings.Contains(ua, strings.ToLower(sub)) {
+				return "exempt user agent " + sub
+			}
+		}
+	}
+	for _, path := range r.Exempt.Paths {
+		if matchRulePath(path, c.Request.URL.Path) {
+			return "exempt path " + path
+		}
+	}
+	return ""
+}
+
+// route returns the first route rule matching path.
+func (r *RateLimitRules) route(path string) (RouteRateLimit, bool) {
+	for _, route := range r.Routes {
+		if matchRulePath(route.Path, path) {
+			return route, true
+		}
+	}
+	return RouteRateLimit{}, false
+}
+
+// matchRulePath reports whether path is pattern, or starts with pattern
+// without its trailing "*".
+func matchRulePath(pattern, path string) bool {
+	if prefix, ok := strings.CutSuffix(pattern, "*"); ok {
+		return strings.HasPrefix(path, prefix)
+	}
+	return pattern == path
+}
+
+// decide applies the rules to a request the tier defaults would limit as
+// def. Exemptions win over wallet overrides, which win over route
+// multipliers.
+func (r *RateLimitRules) decide(c *gin.Context, cfg *Config, tier string, def rateLimitDecision) rateLimitDecision {
+	if rule := r.exemption(c); rule != "" {
+		return rateLimitDecision{rule: rule, exempt: true}
+	}
+	if len(r.wallets) > 0 && c.GetHeader("X-402-Signature") != "" && c.GetHeader("X-402-Nonce") != "" {
+		var override *WalletRateLimit
+		isOverridden := func(addr common.Address) bool {
+			if w, ok := r.wallets[addr]; ok {
+				override = &w
+				return true
+			}
+			return false
+		}
+		if isPremiumPayer(c, cfg, isOverridden) {
+			addr := common.HexToAddress(override.Address).Hex()
+			return rateLimitDecision{
+				rule:    "wallet " + addr,
+				limiter: ruleLimiter("wallet:"+addr, override.RPM, override.Burst),
+				key:     "wallet:" + addr,
+				limit:   override.RPM,
+			}
+		}
+	}
+	if route, ok := r.route(c.Request.URL.Path); ok {
+		limits := cfg.RateLimits[tier]
+		rpm := max(int(float64(limits.RPM)*route.Multiplier), 1)
+		burst := max(int(float64(limits.Burst)*route.Multiplier), 1)
+		return rateLimitDecision{
+			rule:    fmt.Sprintf("route %s x%g", route.Path, route.Multiplier),
+			limiter: ruleLimiter("route:"+route.Path+":"+tier, rpm, burst),
+			key:     def.key,
+			limit:   rpm,
+		}
+	}
+	return def
+}
+
+// ruleLimiters holds the token buckets of wallet and route rules by rule, so
+// buckets survive reloads; a rule whose limits changed is retuned in place.
+var ruleLimiters sync.Map // map[string]*ratelimit.TokenBucket
+
+func ruleLimiter(id string, rpm, burst int) ratelimit.RateLimiter {
+	if limiter, ok := ruleLimiters.Load(id); ok {
+		tb := limiter.(*ratelimit.TokenBucket)
+		if curRPM, curBurst := tb.Limits(); curRPM != rpm || curBurst != burst {
+			tb.SetLimits(rpm, burst)
+		}
+		return tb
+	}
+	cleanupTTL := time.Duration(getEnvAsInt("RATE_LIMIT_CLEANUP_INTERVAL", 300)) * time.Second
+	limiter := ratelimit.NewTokenBucket(rpm, burst, cleanupTTL)
+	actual, loaded := ruleLimiters.LoadOrStore(id, limiter)
+	if loaded {
+		limiter.Stop()
+	}
+	return actual.(*ratelimit.TokenBucket)
+}
+
+// stopRuleLimiters stops the cleanup goroutines of every rule limiter.
+func stopRuleLimiters() {
+	ruleLimiters.Range(func(id, limiter any) bool {
+		limiter.(*ratelimit.TokenBucket).Stop()
+		ruleLimiters.Delete(id)
+		return true
+	})
+}
+
+// logDryRun logs what enforcing decision would have done to the request.
+func logDryRun(c *gin.Context, decision rateLimitDecision) {
+	outcome := "skip the limiter"
+	if !decision.exempt {
+		outcome = "allow"
+		if !decision.limiter.Allow(decision.key) {
+			outcome = "reject with 429"
+		}
+	}
+	log.Printf("[RATE LIMIT DRY RUN] %s %s matched %s; enforcing it would %s", c.Request.Method, c.Request.URL.Path, decision.rule, outcome)
+}
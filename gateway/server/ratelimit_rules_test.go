@@ -0,0 +1,224 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"gateway/payments"
+
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/gin-gonic/gin"
+)
+
+// withRateLimitRules writes rules to a file named by RATE_LIMIT_RULES_FILE
+// and returns its path.
+func withRateLimitRules(t *testing.T, rules string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "ratelimit.yaml")
+	if err := os.WriteFile(path, []byte(rules), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("RATE_LIMIT_RULES_FILE", path)
+	t.Cleanup(stopRuleLimiters)
+	return path
+}
+
+// rulesRouter serves GET on paths behind the rate limiter.
+func rulesRouter(paths ...string) *gin.Engine {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.Use(RateLimitMiddleware(initRateLimiters()))
+	for _, path := range paths {
+		r.GET(path, func(c *gin.Context) { c.JSON(200, gin.H{"ok": true}) })
+	}
+	return r
+}
+
+func rulesGet(r http.Handler, path string, headers map[string]string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodGet, path, nil)
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	return w
+}
+
+func TestParseRateLimitRules(t *testing.T) {
+	rules, err := parseRateLimitRules([]byte(`
+dry_run: true
+exempt:
+  cidrs: [10.0.0.0/8, 192.0.2.7]
+  user_agents: [kube-probe]
+  paths: [/healthz]
+wallets:
+  - address: "0x742d35Cc6634C0532925a3b844Bc9e7595f8fE21"
+    rpm: 600
+    burst: 100
+routes:
+  - path: /api/ai/*
+    multiplier: 0.5
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !rules.DryRun || len(rules.networks) != 2 || len(rules.wallets) != 1 || len(rules.Routes) != 1 {
+		t.Errorf("unexpected rules %+v", rules)
+	}
+	if empty, err := parseRateLimitRules(nil); err != nil || empty.DryRun {
+		t.Errorf("expected an empty file to have no rules, got %+v, %v", empty, err)
+	}
+
+	for _, bad := range []string{
+		"exempt:\n  cidrs: [not-an-ip]\n",
+		"wallets:\n  - address: nobody\n    rpm: 1\n    burst: 1\n",
+		"wallets:\n  - address: \"0x742d35Cc6634C0532925a3b844Bc9e7595f8fE21\"\n    rpm: 0\n    burst: 1\n",
+		"routes:\n  - path: /api/ai/embed\n    multiplier: 0\n",
+		"routes:\n  - path: api\n    multiplier: 2\n",
+		"exemptions: {}\n",
+	} {
+		if _, err := parseRateLimitRules([]byte(bad)); err == nil {
+			t.Errorf("expected %q to be rejected", bad)
+		}
+	}
+}
+
+func TestRateLimitRules_Exemptions(t *testing.T) {
+	t.Setenv("RATE_LIMIT_ANONYMOUS_RPM", "1")
+	t.Setenv("RATE_LIMIT_ANONYMOUS_BURST", "1")
+	withRateLimitRules(t, "exempt:\n  user_agents: [kube-probe]\n  paths: [/healthz]\n")
+	r := rulesRouter("/healthz", "/test")
+
+	for i := 0; i < 3; i++ {
+		if w := rulesGet(r, "/healthz", nil); w.Code != 200 || w.Header().Get("X-RateLimit-Limit") != "" {
+			t.Fatalf("exempt path: expected 200 without rate limit headers, got %d", w.Code)
+		}
+		if w := rulesGet(r, "/test", map[string]string{"User-Agent": "kube-probe/1.29"}); w.Code != 200 {
+			t.Fatalf("exempt user agent: expected 200, got %d", w.Code)
+		}
+	}
+	rulesGet(r, "/test", nil)
+	if w := rulesGet(r, "/test", nil); w.Code != http.StatusTooManyRequests {
+		t.Errorf("expected other requests to be limited, got %d", w.Code)
+	}
+}
+
+func TestRateLimitRules_ExemptCIDR(t *testing.T) {
+	t.Setenv("RATE_LIMIT_ANONYMOUS_RPM", "1")
+	t.Setenv("RATE_LIMIT_ANONYMOUS_BURST", "1")
+	// httptest requests come from 192.0.2.1.
+	withRateLimitRules(t, "exempt:\n  cidrs: [192.0.2.0/24]\n")
+	r := rulesRouter("/test")
+
+	for i := 0; i < 3; i++ {
+		if w := rulesGet(r, "/test", nil); w.Code != 200 {
+			t.Fatalf("request %d from an exempt network: expected 200, got %d", i+1, w.Code)
+		}
+	}
+}
+
+func TestRateLimitRules_RouteMultiplier(t *testing.T) {
+	t.Setenv("RATE_LIMIT_ANONYMOUS_RPM", "10")
+	t.Setenv("RATE_LIMIT_ANONYMOUS_BURST", "2")
+	withRateLimitRules(t, "routes:\n  - path: /api/ai/*\n    multiplier: 2\n")
+	r := rulesRouter("/api/ai/embed", "/test")
+
+	for i := 0; i < 4; i++ {
+		w := rulesGet(r, "/api/ai/embed", nil)
+		if w.Code != 200 {
+			t.Fatalf("request %d: expected the doubled burst to allow it, got %d", i+1, w.Code)
+		}
+		if w.Header().Get("X-RateLimit-Limit") != "20" {
+			t.Errorf("expected the doubled limit, got %s", w.Header().Get("X-RateLimit-Limit"))
+		}
+	}
+	if w := rulesGet(r, "/api/ai/embed", nil); w.Code != http.StatusTooManyRequests {
+		t.Errorf("expected the route bucket to run out, got %d", w.Code)
+	}
+	if w := rulesGet(r, "/test", nil); w.Code != 200 || w.Header().Get("X-RateLimit-Limit") != "10" {
+		t.Errorf("expected other routes to keep the tier limit, got %d %s", w.Code, w.Header().Get("X-RateLimit-Limit"))
+	}
+}
+
+func TestRateLimitRules_WalletOverride(t *testing.T) {
+	t.Setenv("PAYMENT_AMOUNT", "0.001")
+	t.Setenv("RATE_LIMIT_STANDARD_BURST", "10")
+	key, err := crypto.GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	wallet := crypto.PubkeyToAddress(key.PublicKey)
+	withRateLimitRules(t, "wallets:\n  - address: \""+wallet.Hex()+"\"\n    rpm: 600\n    burst: 2\n")
+	r := rulesRouter("/test")
+
+	cfg := getConfig()
+	signed := func(nonce string) map[string]string {
+		sig, err := payments.Sign(paymentContextFor(cfg.PrimaryChain(), cfg.PaymentAmount, nonce), key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return map[string]string{"X-402-Signature": sig, "X-402-Nonce": nonce}
+	}
+
+	// The override is counted per wallet, across nonces.
+	for i, nonce := range []string{"n-1", "n-2"} {
+		w := rulesGet(r, "/test", signed(nonce))
+		if w.Code != 200 || w.Header().Get("X-RateLimit-Limit") != "600" {
+			t.Fatalf("request %d: expected 200 under the wallet limit, got %d %s", i+1, w.Code, w.Header().Get("X-RateLimit-Limit"))
+		}
+	}
+	if w := rulesGet(r, "/test", signed("n-3")); w.Code != http.StatusTooManyRequests {
+		t.Errorf("expected the wallet's burst to run out, got %d", w.Code)
+	}
+}
+
+func TestRateLimitRules_DryRunOnlyLogs(t *testing.T) {
+	t.Setenv("RATE_LIMIT_ANONYMOUS_RPM", "1")
+	t.Setenv("RATE_LIMIT_ANONYMOUS_BURST", "1")
+	withRateLimitRules(t, "dry_run: true\nexempt:\n  user_agents: [kube-probe]\n")
+	var logs bytes.Buffer
+	log.SetOutput(&logs)
+	t.Cleanup(func() { log.SetOutput(os.Stderr) })
+	r := rulesRouter("/test")
+
+	probe := map[string]string{"User-Agent": "kube-probe/1.29"}
+	rulesGet(r, "/test", probe)
+	if w := rulesGet(r, "/test", probe); w.Code != http.StatusTooManyRequests {
+		t.Errorf("expected dry-run rules not to be enforced, got %d", w.Code)
+	}
+	if !strings.Contains(logs.String(), "[RATE LIMIT DRY RUN] GET /test matched exempt user agent kube-probe; enforcing it would skip the limiter") {
+		t.Errorf("expected the dry-run decision to be logged, got %q", logs.String())
+	}
+}
+
+func TestRateLimitRules_HotReload(t *testing.T) {
+	resetConfigSnapshot(t)
+	path := withRateLimitRules(t, "exempt:\n  paths: [/healthz]\n")
+	currentConfig.Store(loadConfig())
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	if err := watchConfigFiles(ctx, "", []string{path}); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte("dry_run: true\nexempt:\n  paths: [/healthz]\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	deadline := time.Now().Add(3 * time.Second)
+	for time.Now().Before(deadline) {
+		if rules := getConfig().RateLimitRules; rules != nil && rules.DryRun {
+			return
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	t.Fatal("expected the rules file change to be reloaded")
+}
@@ -0,0 +1,105 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"time"
+
+	"gateway/ratelimit"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// rateLimitSnapshotKey holds the last saved bucket state of every tier.
+const rateLimitSnapshotKey = "ratelimit:snapshot"
+
+// bucketSnapshotter is a limiter whose buckets can be saved and restored.
+type bucketSnapshotter interface {
+	Snapshot() []ratelimit.BucketState
+	Restore(states []ratelimit.BucketState)
+}
+
+// rateLimitSnapshot is the saved state of the tier limiters.
+type rateLimitSnapshot struct {
+	SavedAt time.Time                          `json:"saved_at"`
+	Tiers   map[string][]ratelimit.BucketState `json:"tiers"`
+}
+
+// getRateLimitSnapshotInterval returns how often the tier buckets are saved
+// to Redis (RATE_LIMIT_SNAPSHOT_SECONDS, default 0: never).
+func getRateLimitSnapshotInterval() time.Duration {
+	return time.Duration(getEnvAsInt("RATE_LIMIT_SNAPSHOT_SECONDS", 0)) * time.Second
+}
+
+// saveRateLimitSnapshot writes the buckets of every tier that are not full
+// to Redis, replacing the previous snapshot. It expires after
+// RATE_LIMIT_CLEANUP_INTERVAL, by when every saved bucket would be idle.
+func saveRateLimitSnapshot(ctx context.Context) error {
+	if redisClient == nil {
+		return nil
+	}
+	snap := rateLimitSnapshot{SavedAt: time.Now().UTC(), Tiers: make(map[string][]ratelimit.BucketState)}
+	for tier, limiter := range getActiveRateLimiters() {
+		if s, ok := limiter.(bucketSnapshotter); ok {
+			if states := s.Snapshot(); len(states) > 0 {
+				snap.Tiers[tier] = states
+			}
+		}
+	}
+	data, err := json.Marshal(snap)
+	if err != nil {
+		return err
+	}
+	ttl := time.Duration(getEnvAsInt("RATE_LIMIT_CLEANUP_INTERVAL", 300)) * time.Second
+	return redisClient.Set(ctx, rateLimitSnapshotKey, data, ttl).Err()
+}
+
+// restoreRateLimitSnapshot loads the saved buckets into the tier limiters,
+// so a restart neither refills clients nor lets them burst again. Tiers that
+// no longer exist are skipped.
+func restoreRateLimitSnapshot(ctx context.Context) error {
+	if redisClient == nil {
+		return nil
+	}
+	data, err := redisClient.Get(ctx, rateLimitSnapshotKey).Bytes()
+	if errors.Is(err, redis.Nil) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	var snap rateLimitSnapshot
+	if err := json.Unmarshal(data, &snap); err != nil {
+		return err
+	}
+	limiters := getActiveRateLimiters()
+	restored := 0
+	for tier, states := range snap.Tiers {
+		if s, ok := limiters[tier].(bucketSnapshotter); ok {
+			s.Restore(states)
+			restored += len(states)
+		}
+	}
+	log.Printf("Restored %d rate limit buckets saved at %s", restored, snap.SavedAt.Format(time.RFC3339))
+	return nil
+}
+
+// startRateLimitSnapshots restores the saved buckets and schedules saving
+// them every interval. It reports whether snapshots are on.
+func startRateLimitSnapshots(ctx context.Context, scheduler *Scheduler) bool {
+	interval := getRateLimitSnapshotInterval()
+	if interval <= 0 || !getRateLimitEnabled() {
+		return false
+	}
+	if redisClient == nil {
+		log.Printf("[WARNING] RATE_LIMIT_SNAPSHOT_SECONDS is set but Redis is not connected; rate limits reset on restart")
+		return false
+	}
+	if err := restoreRateLimitSnapshot(ctx); err != nil {
+		log.Printf("[WARNING] Failed to restore rate limit buckets: %v", err)
+	}
+	scheduler.Add(ScheduledJob{Name: "rate limit snapshots", Interval: interval, Run: saveRateLimitSnapshot})
+	return true
+}
@@ -0,0 +1,79 @@
+package server
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"gateway/ratelimit"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// withTierLimiters installs a fresh limiter per tier for the duration of
+// the test.
+func withTierLimiters(t *testing.T, rpm, burst int) map[string]ratelimit.RateLimiter {
+	t.Helper()
+	prev := activeRateLimiters.Load()
+	limiters := map[string]ratelimit.RateLimiter{"anonymous": ratelimit.NewTokenBucket(rpm, burst, time.Minute)}
+	activeRateLimiters.Store(&limiters)
+	t.Cleanup(func() {
+		limiters["anonymous"].(*ratelimit.TokenBucket).Stop()
+		activeRateLimiters.Store(prev)
+	})
+	return limiters
+}
+
+func TestRateLimitSnapshots_OffWithoutRedis(t *testing.T) {
+	prev := redisClient
+	redisClient = nil
+	t.Cleanup(func() { redisClient = prev })
+	t.Setenv("RATE_LIMIT_ENABLED", "true")
+	scheduler := NewScheduler(0)
+
+	t.Setenv("RATE_LIMIT_SNAPSHOT_SECONDS", "0")
+	if startRateLimitSnapshots(context.Background(), scheduler) {
+		t.Error("expected snapshots to be off by default")
+	}
+	t.Setenv("RATE_LIMIT_SNAPSHOT_SECONDS", "30")
+	if startRateLimitSnapshots(context.Background(), scheduler) || len(scheduler.Status()) != 0 {
+		t.Error("expected snapshots to stay off without Redis")
+	}
+	if err := saveRateLimitSnapshot(context.Background()); err != nil {
+		t.Errorf("expected saving without Redis to be a no-op, got %v", err)
+	}
+}
+
+func TestRateLimitSnapshots_SurviveRestart(t *testing.T) {
+	rdb := redis.NewClient(&redis.Options{Addr: "127.0.0.1:6379"})
+	ctx := context.Background()
+	if err := rdb.Ping(ctx).Err(); err != nil {
+		t.Skipf("Redis unavailable, skipping integration test: %v", err)
+	}
+	prev := redisClient
+	redisClient = rdb
+	t.Cleanup(func() {
+		rdb.Del(ctx, rateLimitSnapshotKey)
+		rdb.Close()
+		redisClient = prev
+	})
+
+	before := withTierLimiters(t, 1, 5)
+	for i := 0; i < 4; i++ {
+		before["anonymous"].Allow("203.0.113.7")
+	}
+	if err := saveRateLimitSnapshot(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	after := withTierLimiters(t, 1, 5)
+	if err := restoreRateLimitSnapshot(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if got := after["anonymous"].GetRemaining("203.0.113.7"); got != 1 {
+		t.Errorf("expected the drained bucket to survive the restart with 1 token, got %d", got)
+	}
+	if got := after["anonymous"].GetRemaining("198.51.100.1"); got != 5 {
+		t.Errorf("expected other clients to start full, got %d", got)
+	}
+}
@@ -0,0 +1,82 @@
+package server
+
+import (
+	"sync"
+	"time"
+)
+
+// dependencyCheck is the outcome of one dependency probe in /readyz.
+type dependencyCheck struct {
+	Status  string
+	Latency time.Duration
+}
+
+// dependencyChecks are the remote dependencies /readyz probes. They are
+// looked up on every run so tests can stub the check functions.
+func dependencyChecks() map[string]func() string {
+	return map[string]func() string{
+		"verifier":   checkVerifierHealth,
+		"openrouter": checkOpenRouterHealth,
+	}
+}
+
+// runDependencyChecks probes every dependency concurrently, so a probe takes
+// as long as the slowest check rather than the sum of them.
+func runDependencyChecks() map[string]dependencyCheck {
+	checks := dependencyChecks()
+	var (
+		mu      sync.Mutex
+		wg      sync.WaitGroup
+		results = make(map[string]dependencyCheck, len(checks))
+	)
+	for name, check := range checks {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			start := time.Now()
+			status := check()
+			mu.Lock()
+			results[name] = dependencyCheck{Status: status, Latency: time.Since(start)}
+			mu.Unlock()
+		}()
+	}
+	wg.Wait()
+	return results
+}
+
+// readinessCache keeps the last dependency check results for
+// READYZ_CACHE_SECONDS so frequent probes do not hit the verifier and
+// OpenRouter (and burn provider quota) every time. Concurrent probes that
+// find the cache stale wait for a single refresh.
+type readinessCache struct {
+	mu        sync.Mutex
+	results   map[string]dependencyCheck
+	checkedAt time.Time
+}
+
+var readiness readinessCache
+
+// Get returns dependency results no older than ttl, running the checks if
+// needed. cached reports whether the results were reused.
+func (rc *readinessCache) Get(ttl time.Duration) (results map[string]dependencyCheck, checkedAt time.Time, cached bool) {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	if rc.results != nil && ttl > 0 && time.Since(rc.checkedAt) < ttl {
+		return rc.results, rc.checkedAt, true
+	}
+	rc.results = runDependencyChecks()
+	rc.checkedAt = time.Now()
+	return rc.results, rc.checkedAt, false
+}
+
+// Reset drops the cached results.
+func (rc *readinessCache) Reset() {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	rc.results = nil
+}
+
+// getReadyzCacheTTL returns READYZ_CACHE_SECONDS (default 5, 0 disables).
+func getReadyzCacheTTL() time.Duration {
+	return time.Duration(max(getEnvAsInt("READYZ_CACHE_SECONDS", 5), 0)) * time.Second
+}
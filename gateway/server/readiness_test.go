@@ -0,0 +1,80 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// stubDependencyChecks replaces the readiness checks for the test and clears
+// any cached results.
+func stubDependencyChecks(t *testing.T, verifier, openRouter func() string) {
+	t.Helper()
+	origVerifier, origOpenRouter := checkVerifierHealth, checkOpenRouterHealth
+	checkVerifierHealth, checkOpenRouterHealth = verifier, openRouter
+	readiness.Reset()
+	t.Cleanup(func() {
+		checkVerifierHealth, checkOpenRouterHealth = origVerifier, origOpenRouter
+		readiness.Reset()
+	})
+}
+
+func getReadyz(t *testing.T) map[string]interface{} {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.GET("/readyz", handleReadyz)
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+	var body map[string]interface{}
+	if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	return body
+}
+
+func TestReadyz_ChecksRunConcurrently(t *testing.T) {
+	t.Setenv("READYZ_CACHE_SECONDS", "0")
+	slow := func() string { time.Sleep(200 * time.Millisecond); return "ok" }
+	stubDependencyChecks(t, slow, slow)
+
+	start := time.Now()
+	body := getReadyz(t)
+	if elapsed := time.Since(start); elapsed >= 350*time.Millisecond {
+		t.Errorf("checks should run in parallel, took %v", elapsed)
+	}
+	latency := body["latency_ms"].(map[string]interface{})
+	for _, name := range []string{"verifier", "openrouter"} {
+		if ms, _ := latency[name].(float64); ms < 200 {
+			t.Errorf("expected %s latency >= 200ms, got %v", name, latency[name])
+		}
+	}
+}
+
+func TestReadyz_CachesDependencyResults(t *testing.T) {
+	t.Setenv("READYZ_CACHE_SECONDS", "60")
+	var calls atomic.Int32
+	status := "ok"
+	stubDependencyChecks(t, func() string { calls.Add(1); return status }, func() string { return "ok" })
+
+	if body := getReadyz(t); body["ready"] != true || body["cached"] != false {
+		t.Fatalf("first probe should run the checks: %v", body)
+	}
+	status = "unreachable"
+	if body := getReadyz(t); body["ready"] != true || body["cached"] != true {
+		t.Fatalf("second probe should reuse cached results: %v", body)
+	}
+	if calls.Load() != 1 {
+		t.Errorf("expected 1 verifier check, got %d", calls.Load())
+	}
+
+	t.Setenv("READYZ_CACHE_SECONDS", "0")
+	if body := getReadyz(t); body["ready"] != false || body["cached"] != false {
+		t.Errorf("a zero cache window should re-check every probe: %v", body)
+	}
+}
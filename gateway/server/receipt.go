@@ -0,0 +1,209 @@
+package server
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"gateway/receipts"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Receipt types live in the receipts package so other services can verify
+// receipts without running the gateway. The aliases keep handler code terse.
+type (
+	Receipt        = receipts.Receipt
+	PaymentDetails = receipts.PaymentDetails
+	ServiceDetails = receipts.ServiceDetails
+	SignedReceipt  = receipts.SignedReceipt
+	// GenerationParams are the optional temperature, max_tokens and top_p
+	// of a summarize request.
+	GenerationParams = receipts.GenerationParams
+)
+
+// GenerateReceipt creates a new receipt for a successful payment, signed with
+// the server wallet key.
+func GenerateReceipt(payment PaymentContext, payer string, endpoint string, reqBody, respBody []byte, opts ...receipts.Option) (*SignedReceipt, error) {
+	signer, err := getServerSigner()
+	if err != nil {
+		return nil, fmt.Errorf("failed to load server signer: %w", err)
+	}
+	return receipts.Generate(signer, payment, payer, endpoint, reqBody, respBody, opts...)
+}
+
+// signReceipt signs a receipt using the server's private key
+func signReceipt(receipt Receipt) (*SignedReceipt, error) {
+	signer, err := getServerSigner()
+	if err != nil {
+		return nil, fmt.Errorf("failed to load server signer: %w", err)
+	}
+	return receipts.Sign(receipt, signer)
+}
+
+// signResponse sets X-402-Response-Signature, the server's signature over
+// body and the request's correlation ID. body must be exactly what is sent.
+// Without a signing key the header is left out.
+func signResponse(c *gin.Context, body []byte) {
+	signer, err := getServerSigner()
+	if err != nil {
+		log.Printf("[WARNING] Response not signed: %v", err)
+		return
+	}
+	sig, err := receipts.SignResponse(body, c.GetString("correlation_id"), signer)
+	if err != nil {
+		log.Printf("[WARNING] Response not signed: %v", err)
+		return
+	}
+	c.Header(receipts.ResponseSignatureHeader, sig)
+}
+
+var (
+	receiptSequenceMu sync.Mutex
+	receiptSequences  = make(map[string]int64)
+)
+
+// nextReceiptSequence returns the next receipt sequence number for payer.
+// Counters are kept in Redis (without expiry) when it is connected so they
+// survive restarts and are shared across replicas; the in-memory fallback
+// restarts from 1 with the process.
+func nextReceiptSequence(ctx context.Context, payer string) (int64, error) {
+	payer = strings.ToLower(payer)
+	if redisClient != nil {
+		seq, err := redisClient.Incr(ctx, "receipt:seq:"+payer).Result()
+		if err != nil {
+			return 0, fmt.Errorf("failed to increment receipt sequence: %w", err)
+		}
+		return seq, nil
+	}
+
+	receiptSequenceMu.Lock()
+	defer receiptSequenceMu.Unlock()
+	receiptSequences[payer]++
+	return receiptSequences[payer], nil
+}
+
+// Receipt encodings. JSON is the native base64 SignedReceipt; JWS and COSE
+// re-sign the same receipt with ES256K for standards-based consumers.
+const (
+	receiptFormatJSON = "json"
+	receiptFormatJWS  = "jws"
+	receiptFormatCOSE = "cose"
+)
+
+// receiptMediaTypes maps the standards-based formats to their media types.
+var receiptMediaTypes = map[string]string{
+	receiptFormatJWS:  "application/jose",
+	receiptFormatCOSE: "application/cose",
+}
+
+// receiptFormat returns the encoding requested via the receipt_format query
+// parameter or, failing that, an application/jose or application/cose entry
+// in the Accept header. ok is false for an unknown receipt_format value.
+func receiptFormat(c *gin.Context) (format string, ok bool) {
+	switch q := strings.ToLower(c.Query("receipt_format")); q {
+	case receiptFormatJSON, receiptFormatJWS, receiptFormatCOSE:
+		return q, true
+	case "":
+	default:
+		return receiptFormatJSON, false
+	}
+
+	accept := strings.ToLower(c.GetHeader("Accept"))
+	switch {
+	case strings.Contains(accept, "application/jose"):
+		return receiptFormatJWS, true
+	case strings.Contains(accept, "application/cose"):
+		return receiptFormatCOSE, true
+	}
+	return receiptFormatJSON, true
+}
+
+// encodeReceipt renders signed in format. JWS is returned as its compact
+// serialization, COSE as raw CBOR and JSON as the marshalled SignedReceipt.
+func encodeReceipt(signed *SignedReceipt, format string) ([]byte, error) {
+	switch format {
+	case receiptFormatJWS, receiptFormatCOSE:
+		signer, err := getServerSigner()
+		if err != nil {
+			return nil, fmt.Errorf("failed to load server signer: %w", err)
+		}
+		if format == receiptFormatJWS {
+			token, err := receipts.EncodeJWS(signed.Receipt, signer)
+			return []byte(token), err
+		}
+		return receipts.EncodeCOSE(signed.Receipt, signer)
+	default:
+		return json.Marshal(signed)
+	}
+}
+
+// encodeReceiptHeader renders signed for the X-402-Receipt header. The
+// compact JWS is already header-safe; other encodings are base64.
+func encodeReceiptHeader(signed *SignedReceipt, format string) (string, error) {
+	data, err := encodeReceipt(signed, format)
+	if err != nil {
+		return "", err
+	}
+	if format == receiptFormatJWS {
+		return string(data), nil
+	}
+	return base64.StdEncoding.EncodeToString(data), nil
+}
+
+// getReceiptCacheMaxAge returns how long clients and proxies may reuse a
+// receipt lookup without revalidating (RECEIPT_CACHE_MAX_AGE_SECONDS,
+// default 60). The receipt itself never changes, but its revocation status
+// and CID can, so this bounds how stale those may be.
+func getReceiptCacheMaxAge() int {
+	return max(getEnvAsInt("RECEIPT_CACHE_MAX_AGE_SECONDS", 60), 0)
+}
+
+// receiptETag identifies one representation of a stored receipt: the
+// receipt (by ID and timestamp) in format, with its current status and CID.
+func receiptETag(receipt *SignedReceipt, format string, rev *Revocation, cid string) string {
+	h := sha256.New()
+	fmt.Fprintf(h, "%s\n%d\n%s\n%s\n", receipt.Receipt.ID, receipt.Receipt.Timestamp.UnixNano(), format, cid)
+	if rev != nil {
+		fmt.Fprintf(h, "%s\n%d\n", rev.Status, rev.RevokedAt.UnixNano())
+	}
+	return `"` + hex.EncodeToString(h.Sum(nil))[:32] + `"`
+}
+
+// setReceiptCacheHeaders writes ETag, Last-Modified, Cache-Control and Vary
+// for a receipt lookup and reports whether the request's If-None-Match (or,
+// without one, If-Modified-Since) shows the client's copy is current.
+func setReceiptCacheHeaders(c *gin.Context, receipt *SignedReceipt, format string, rev *Revocation, cid string) bool {
+	etag := receiptETag(receipt, format, rev, cid)
+	modified := receipt.Receipt.Timestamp
+	if rev != nil && rev.RevokedAt.After(modified) {
+		modified = rev.RevokedAt
+	}
+	c.Header("ETag", etag)
+	c.Header("Last-Modified", modified.UTC().Format(http.TimeFormat))
+	c.Header("Cache-Control", "public, max-age="+strconv.Itoa(getReceiptCacheMaxAge()))
+	c.Header("Vary", "Accept")
+
+	if match := c.GetHeader("If-None-Match"); match != "" {
+		for _, candidate := range strings.Split(match, ",") {
+			candidate = strings.TrimPrefix(strings.TrimSpace(candidate), "W/")
+			if candidate == "*" || candidate == etag {
+				return true
+			}
+		}
+		return false
+	}
+	if since, err := http.ParseTime(c.GetHeader("If-Modified-Since")); err == nil {
+		return !modified.Truncate(time.Second).After(since)
+	}
+	return false
+}
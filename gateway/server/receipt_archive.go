@@ -0,0 +1,193 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/redis/go-redis/v9"
+)
+
+// receiptArchiveKeyPrefix prefixes the Redis keys mapping an archived
+// receipt ID to the object key of its batch.
+const receiptArchiveKeyPrefix = "receipt:archive:"
+
+var (
+	receiptArchiveMu sync.Mutex
+	// receiptArchiveIndex maps archived receipt IDs to their batch's object
+	// key when Redis is not connected.
+	receiptArchiveIndex = make(map[string]string)
+)
+
+// archivedReceipt is one line of an archive batch: the receipt as issued
+// and its IPFS CID, if pinned.
+type archivedReceipt struct {
+	*SignedReceipt
+	CID string `json:"cid,omitempty"`
+}
+
+// newReceiptArchive returns the object storage receipts are archived to,
+// configured by the RECEIPT_ARCHIVE_S3_* variables, or nil when archival is
+// disabled.
+func newReceiptArchive() *s3Uploader {
+	return newS3UploaderFromEnv("RECEIPT_ARCHIVE_S3", "receipt-archive/")
+}
+
+// getReceiptArchiveBatchSize returns the most receipts written to one
+// archive object, RECEIPT_ARCHIVE_BATCH_SIZE.
+func getReceiptArchiveBatchSize() int {
+	if n := getEnvAsInt("RECEIPT_ARCHIVE_BATCH_SIZE", 1000); n > 0 {
+		return n
+	}
+	return 1000
+}
+
+// archiveReceipts writes the stored receipts that expire before deadline and
+// are not yet archived to object storage as gzipped JSONL batches, oldest
+// first, and marks them archived so the cleanup may delete them. Batches
+// that fail to upload are logged and retried on the next run.
+func archiveReceipts(ctx context.Context, deadline time.Time) {
+	archive := newReceiptArchive()
+	if archive == nil {
+		return
+	}
+	var due []archivedReceipt
+	receiptStoreMu.RLock()
+	for _, entry := range receiptStore {
+		if !entry.archived && entry.expiresAt.Before(deadline) {
+			due = append(due, archivedReceipt{SignedReceipt: entry.receipt, CID: entry.cid})
+		}
+	}
+	receiptStoreMu.RUnlock()
+	sort.Slice(due, func(i, j int) bool {
+		return due[i].Receipt.Timestamp.Before(due[j].Receipt.Timestamp)
+	})
+
+	size := getReceiptArchiveBatchSize()
+	for start := 0; start < len(due); start += size {
+		batch := due[start:min(start+size, len(due))]
+		key, err := writeReceiptArchiveBatch(ctx, archive, batch)
+		if err != nil {
+			log.Printf("[WARNING] Failed to archive %d receipts: %v", len(batch), err)
+			continue
+		}
+		receiptStoreMu.Lock()
+		for _, r := range batch {
+			if entry, ok := receiptStore[r.Receipt.ID]; ok {
+				entry.archived = true
+			}
+		}
+		receiptStoreMu.Unlock()
+		log.Printf("Archived %d receipts to s3://%s/%s", len(batch), archive.bucket, key)
+	}
+}
+
+// writeReceiptArchiveBatch uploads batch as one gzipped JSONL object, named
+// by the day it was archived, indexes its receipt IDs and returns its
+// object key.
+func writeReceiptArchiveBatch(ctx context.Context, archive *s3Uploader, batch []archivedReceipt) (string, error) {
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	enc := json.NewEncoder(zw)
+	for _, r := range batch {
+		if err := enc.Encode(r); err != nil {
+			return "", err
+		}
+	}
+	if err := zw.Close(); err != nil {
+		return "", err
+	}
+
+	name := time.Now().UTC().Format("2006/01/02/") + "rcpts_" + uuid.New().String() + ".jsonl.gz"
+	if _, err := archive.Put(ctx, name, "application/gzip", buf.Bytes()); err != nil {
+		return "", err
+	}
+	key := archive.prefix + name
+	if err := indexArchivedReceipts(ctx, batch, key); err != nil {
+		return "", fmt.Errorf("failed to index archived receipts: %w", err)
+	}
+	return key, nil
+}
+
+// indexArchivedReceipts records key as the batch holding each receipt, in
+// Redis when it is connected so any replica can find it, else in memory.
+func indexArchivedReceipts(ctx context.Context, batch []archivedReceipt, key string) error {
+	if redisClient != nil {
+		pipe := redisClient.Pipeline()
+		for _, r := range batch {
+			pipe.Set(ctx, receiptArchiveKeyPrefix+r.Receipt.ID, key, 0)
+		}
+		_, err := pipe.Exec(ctx)
+		return err
+	}
+	receiptArchiveMu.Lock()
+	defer receiptArchiveMu.Unlock()
+	for _, r := range batch {
+		receiptArchiveIndex[r.Receipt.ID] = key
+	}
+	return nil
+}
+
+// loadArchivedReceipt fetches the receipt id from its archive batch; ok is
+// false when archival is disabled or the receipt was never archived.
+func loadArchivedReceipt(ctx context.Context, id string) (receipt archivedReceipt, ok bool, err error) {
+	archive := newReceiptArchive()
+	if archive == nil {
+		return archivedReceipt{}, false, nil
+	}
+	var key string
+	if redisClient != nil {
+		key, err = redisClient.Get(ctx, receiptArchiveKeyPrefix+id).Result()
+		if errors.Is(err, redis.Nil) {
+			return archivedReceipt{}, false, nil
+		}
+		if err != nil {
+			return archivedReceipt{}, false, fmt.Errorf("failed to look up archived receipt: %w", err)
+		}
+	} else {
+		receiptArchiveMu.Lock()
+		key, ok = receiptArchiveIndex[id]
+		receiptArchiveMu.Unlock()
+		if !ok {
+			return archivedReceipt{}, false, nil
+		}
+	}
+
+	data, err := archive.Get(ctx, key)
+	if errors.Is(err, errObjectNotFound) {
+		// Removed by the bucket's lifecycle rules.
+		return archivedReceipt{}, false, nil
+	}
+	if err != nil {
+		return archivedReceipt{}, false, fmt.Errorf("failed to fetch receipt archive %s: %w", key, err)
+	}
+	zr, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return archivedReceipt{}, false, fmt.Errorf("failed to read receipt archive %s: %w", key, err)
+	}
+	scanner := bufio.NewScanner(zr)
+	scanner.Buffer(make([]byte, 64*1024), 1<<20)
+	for scanner.Scan() {
+		var r archivedReceipt
+		if err := json.Unmarshal(scanner.Bytes(), &r); err != nil {
+			return archivedReceipt{}, false, fmt.Errorf("failed to decode receipt archive %s: %w", key, err)
+		}
+		if r.SignedReceipt != nil && r.Receipt.ID == id {
+			r.SignedReceipt = migrateStoredReceipt(r.SignedReceipt)
+			return r, true, nil
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return archivedReceipt{}, false, fmt.Errorf("failed to read receipt archive %s: %w", key, err)
+	}
+	return archivedReceipt{}, false, nil
+}
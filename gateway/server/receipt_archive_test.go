@@ -0,0 +1,229 @@
+package server
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeObjectStorage is an S3-compatible bucket kept in memory.
+type fakeObjectStorage struct {
+	mu      sync.Mutex
+	objects map[string][]byte // path -> body
+	failPut bool
+}
+
+func (s *fakeObjectStorage) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, _ := io.ReadAll(r.Body)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if r.Header.Get("X-Amz-Content-Sha256") != sha256Hex(body) || !strings.HasPrefix(r.Header.Get("Authorization"), "AWS4-HMAC-SHA256 ") {
+		http.Error(w, "bad signature", http.StatusForbidden)
+		return
+	}
+	switch r.Method {
+	case http.MethodPut:
+		if s.failPut {
+			http.Error(w, "unavailable", http.StatusServiceUnavailable)
+			return
+		}
+		s.objects[r.URL.Path] = body
+	case http.MethodGet:
+		data, ok := s.objects[r.URL.Path]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write(data)
+	case http.MethodDelete:
+		delete(s.objects, r.URL.Path)
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// withReceiptArchive points the receipt archive at a fake bucket and gives
+// the test an empty receipt store and archive index.
+func withReceiptArchive(t *testing.T) *fakeObjectStorage {
+	t.Helper()
+	withReceiptStore(t)
+	withRevocations(t)
+	storage := &fakeObjectStorage{objects: make(map[string][]byte)}
+	server := httptest.NewServer(storage)
+	t.Cleanup(server.Close)
+	t.Setenv("RECEIPT_ARCHIVE_S3_ENDPOINT", server.URL)
+	t.Setenv("RECEIPT_ARCHIVE_S3_BUCKET", "cold")
+	t.Setenv("RECEIPT_ARCHIVE_S3_ACCESS_KEY_ID", "AKIDEXAMPLE")
+	t.Setenv("RECEIPT_ARCHIVE_S3_SECRET_ACCESS_KEY", "secret")
+
+	receiptArchiveMu.Lock()
+	prev := receiptArchiveIndex
+	receiptArchiveIndex = make(map[string]string)
+	receiptArchiveMu.Unlock()
+	t.Cleanup(func() {
+		receiptArchiveMu.Lock()
+		receiptArchiveIndex = prev
+		receiptArchiveMu.Unlock()
+	})
+	return storage
+}
+
+// storeTestReceipt stores a receipt with id that expires after ttl.
+func storeTestReceipt(t *testing.T, id string, ttl time.Duration) *SignedReceipt {
+	t.Helper()
+	receipt := &SignedReceipt{
+		Receipt: Receipt{
+			ID:        id,
+			Version:   "1.0",
+			Timestamp: time.Now().UTC().Truncate(time.Second),
+			Payment:   PaymentDetails{Payer: "0x742d35Cc6634C0532925a3b844Bc9e7595f8fE21", Recipient: "0x2cAF48b4BA1C58721a85dFADa5aC01C2DFa62219", Amount: "0.001", Token: "USDC", ChainID: 8453, Nonce: "nonce-" + id},
+			Service:   ServiceDetails{Endpoint: "/api/ai/summarize", RequestHash: "sha256:request-" + id, ResponseHash: "sha256:response"},
+		},
+		Signature:       "0x1234",
+		ServerPublicKey: "0xabcd",
+	}
+	if err := storeReceipt(receipt, ttl); err != nil {
+		t.Fatal(err)
+	}
+	return receipt
+}
+
+func TestReceiptArchive_ArchivesBeforeDeletion(t *testing.T) {
+	storage := withReceiptArchive(t)
+	t.Setenv("RECEIPT_ARCHIVE_BATCH_SIZE", "2")
+	expired := storeTestReceipt(t, "rcpt_archive000001", -time.Second)
+	storeTestReceipt(t, "rcpt_archive000002", time.Minute)
+	storeTestReceipt(t, "rcpt_archive000003", time.Minute)
+	setReceiptCID(expired.Receipt.ID, "bafyexample")
+	storeTestReceipt(t, "rcpt_archive000004", time.Hour)
+
+	cleanupExpiredReceipts()
+
+	storage.mu.Lock()
+	var lines []string
+	for path, data := range storage.objects {
+		if !strings.HasPrefix(path, "/cold/receipt-archive/"+time.Now().UTC().Format("2006/01/02/")) || !strings.HasSuffix(path, ".jsonl.gz") {
+			t.Errorf("unexpected archive object %s", path)
+		}
+		zr, err := gzip.NewReader(bytes.NewReader(data))
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, _ := io.ReadAll(zr)
+		lines = append(lines, strings.Split(strings.TrimSpace(string(body)), "\n")...)
+	}
+	batches := len(storage.objects)
+	storage.mu.Unlock()
+	if batches != 2 || len(lines) != 3 {
+		t.Fatalf("expected the 3 receipts due before the next cleanup in 2 batches, got %d lines in %d", len(lines), batches)
+	}
+	var first archivedReceipt
+	if err := json.Unmarshal([]byte(lines[0]), &first); err != nil || first.SignedReceipt == nil || first.Signature != "0x1234" {
+		t.Errorf("unexpected archive line %s", lines[0])
+	}
+
+	if _, ok := getReceipt("rcpt_archive000002"); !ok {
+		t.Error("expected an unexpired receipt to stay in the store after archival")
+	}
+	receiptStoreMu.RLock()
+	_, stored := receiptStore[expired.Receipt.ID]
+	receiptStoreMu.RUnlock()
+	if stored {
+		t.Error("expected the archived expired receipt to be deleted")
+	}
+
+	w := httptest.NewRecorder()
+	newTestRouter().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api/receipts/"+expired.Receipt.ID, nil))
+	var body struct {
+		Receipt   Receipt `json:"receipt"`
+		Signature string  `json:"signature"`
+		Status    string  `json:"status"`
+	}
+	if w.Code != http.StatusOK || json.Unmarshal(w.Body.Bytes(), &body) != nil {
+		t.Fatalf("expected the archived receipt, got %d: %s", w.Code, w.Body)
+	}
+	if body.Receipt.Payment.Nonce != expired.Receipt.Payment.Nonce || body.Signature != expired.Signature || body.Status != "valid" {
+		t.Errorf("unexpected archived receipt %+v", body)
+	}
+	if w.Header().Get("X-402-Receipt-Source") != "archive" || w.Header().Get("X-402-Receipt-CID") != "bafyexample" {
+		t.Errorf("unexpected archive headers %v", w.Header())
+	}
+
+	w = httptest.NewRecorder()
+	newTestRouter().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api/receipts/rcpt_unknown000000", nil))
+	if w.Code != http.StatusNotFound {
+		t.Errorf("expected 404 for a receipt never archived, got %d", w.Code)
+	}
+}
+
+func TestReceiptArchive_KeepsReceiptsUntilUploaded(t *testing.T) {
+	storage := withReceiptArchive(t)
+	storage.failPut = true
+	expired := storeTestReceipt(t, "rcpt_archive000005", -time.Second)
+
+	cleanupExpiredReceipts()
+	receiptStoreMu.RLock()
+	entry, stored := receiptStore[expired.Receipt.ID]
+	receiptStoreMu.RUnlock()
+	if !stored || entry.archived {
+		t.Fatal("expected a receipt that failed to upload to stay in the store")
+	}
+
+	storage.mu.Lock()
+	storage.failPut = false
+	storage.mu.Unlock()
+	cleanupExpiredReceipts()
+	receiptStoreMu.RLock()
+	_, stored = receiptStore[expired.Receipt.ID]
+	receiptStoreMu.RUnlock()
+	if stored {
+		t.Error("expected the receipt to be deleted once archived on retry")
+	}
+	if _, ok, err := loadArchivedReceipt(t.Context(), expired.Receipt.ID); !ok || err != nil {
+		t.Errorf("expected the receipt in the archive, got %v, %v", ok, err)
+	}
+}
+
+func TestReceiptArchive_Disabled(t *testing.T) {
+	withReceiptStore(t)
+	expired := storeTestReceipt(t, "rcpt_archive000006", -time.Second)
+	cleanupExpiredReceipts()
+	receiptStoreMu.RLock()
+	_, stored := receiptStore[expired.Receipt.ID]
+	receiptStoreMu.RUnlock()
+	if stored {
+		t.Error("expected expired receipts to be deleted without an archive")
+	}
+	if _, ok, err := loadArchivedReceipt(t.Context(), expired.Receipt.ID); ok || err != nil {
+		t.Errorf("expected no archive lookup when disabled, got %v, %v", ok, err)
+	}
+}
+
+func TestReceiptArchive_IndexSharedThroughRedis(t *testing.T) {
+	withReceiptArchive(t)
+	gw := startGateway(t, nil)
+	expired := storeTestReceipt(t, "rcpt_archive000007", -time.Second)
+
+	cleanupExpiredReceipts()
+	if keys := gw.redisKeys(receiptArchiveKeyPrefix); len(keys) != 1 {
+		t.Fatalf("expected the archive index in Redis, got %v", keys)
+	}
+	receiptArchiveMu.Lock()
+	indexed := len(receiptArchiveIndex)
+	receiptArchiveMu.Unlock()
+	if indexed != 0 {
+		t.Error("expected no in-memory index with Redis connected")
+	}
+
+	w := httptest.NewRecorder()
+	gw.Server.Config.Handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api/receipts/"+expired.Receipt.ID, nil))
+	if w.Code != http.StatusOK || w.Header().Get("X-402-Receipt-Source") != "archive" {
+		t.Errorf("expected the receipt from the archive, got %d: %s", w.Code, w.Body)
+	}
+}
@@ -0,0 +1,122 @@
+package server
+
+import (
+	"encoding/json"
+
+	"gateway/receipts"
+
+	"github.com/gin-gonic/gin"
+)
+
+// sharedBlob is one value of the receipt blob pool, a string or generation
+// parameters, and how many stored receipts refer to it.
+type sharedBlob struct {
+	str    string
+	params *receipts.GenerationParams
+	size   int
+	refs   int
+}
+
+// paramsBlobPrefix starts the pool keys of generation parameters. Strings
+// are keyed by themselves, so the key and the value share their bytes.
+const paramsBlobPrefix = "\x00params:"
+
+// receiptBlobs is a content-addressed pool of the values stored receipts
+// repeat: request and response hashes, endpoints, models, addresses, the
+// server key and generation parameters. With RECEIPT_STORE_DEDUPE every
+// stored receipt points at the pooled copy instead of its own, so identical
+// requests cost one copy of each. Guarded by receiptStoreMu.
+var receiptBlobs = make(map[string]*sharedBlob)
+
+// getReceiptStoreDedupe reports whether RECEIPT_STORE_DEDUPE is set.
+func getReceiptStoreDedupe() bool {
+	return getEnvAsBool("RECEIPT_STORE_DEDUPE", false)
+}
+
+// receiptStrings calls fn with each pooled string field of r.
+func receiptStrings(r *SignedReceipt, fn func(*string)) {
+	payment, service := &r.Receipt.Payment, &r.Receipt.Service
+	for _, s := range []*string{&r.ServerPublicKey, &r.Receipt.Version,
+		&payment.Payer, &payment.Recipient, &payment.Amount, &payment.Token, &payment.Sponsor, &payment.SponsorGrant,
+		&service.Endpoint, &service.RequestHash, &service.ResponseHash, &service.Model, &service.SubstitutedFor,
+		&service.Provider, &service.Source, &service.SourceURL} {
+		if *s != "" {
+			fn(s)
+		}
+	}
+}
+
+// paramsBlobKey is the pool key of p, or "" if p is nil.
+func paramsBlobKey(p *receipts.GenerationParams) string {
+	if p == nil {
+		return ""
+	}
+	data, err := json.Marshal(p)
+	if err != nil {
+		return ""
+	}
+	return paramsBlobPrefix + string(data)
+}
+
+// dedupeReceipt returns a copy of r whose repeated values are shared with
+// the pool. The caller must hold receiptStoreMu and pass the copy to
+// releaseReceiptBlobs once it leaves the store. r itself is not modified,
+// since it may still be in use by the request that issued it.
+func dedupeReceipt(r *SignedReceipt) *SignedReceipt {
+	shared := *r
+	receiptStrings(&shared, func(s *string) {
+		blob, ok := receiptBlobs[*s]
+		if !ok {
+			blob = &sharedBlob{str: *s, size: len(*s)}
+			receiptBlobs[blob.str] = blob
+		}
+		blob.refs++
+		*s = blob.str
+	})
+	if key := paramsBlobKey(shared.Receipt.Service.Parameters); key != "" {
+		blob, ok := receiptBlobs[key]
+		if !ok {
+			blob = &sharedBlob{params: shared.Receipt.Service.Parameters, size: len(key) - len(paramsBlobPrefix)}
+			receiptBlobs[key] = blob
+		}
+		blob.refs++
+		shared.Receipt.Service.Parameters = blob.params
+	}
+	return &shared
+}
+
+// releaseReceiptBlobs drops the references of r, a copy made by
+// dedupeReceipt, removing values no receipt refers to any more. The caller
+// must hold receiptStoreMu.
+func releaseReceiptBlobs(r *SignedReceipt) {
+	release := func(key string) {
+		if blob, ok := receiptBlobs[key]; ok {
+			if blob.refs--; blob.refs <= 0 {
+				delete(receiptBlobs, key)
+			}
+		}
+	}
+	receiptStrings(r, func(s *string) { release(*s) })
+	if key := paramsBlobKey(r.Receipt.Service.Parameters); key != "" {
+		release(key)
+	}
+}
+
+// receiptDedupeStats reports the pool size: the distinct values held, the
+// references to them and the bytes of value data sharing saved.
+func receiptDedupeStats() (blobs, refs, savedBytes int) {
+	receiptStoreMu.RLock()
+	defer receiptStoreMu.RUnlock()
+	for _, blob := range receiptBlobs {
+		blobs++
+		refs += blob.refs
+		savedBytes += blob.size * (blob.refs - 1)
+	}
+	return blobs, refs, savedBytes
+}
+
+// receiptDedupeReport is receiptDedupeStats for the admin stats endpoint.
+func receiptDedupeReport() gin.H {
+	blobs, refs, saved := receiptDedupeStats()
+	return gin.H{"enabled": getReceiptStoreDedupe(), "shared_values": blobs, "references": refs, "bytes_saved": saved}
+}
@@ -0,0 +1,171 @@
+package server
+
+import (
+	"fmt"
+	"runtime"
+	"testing"
+	"time"
+	"unsafe"
+
+	"gateway/internal/testsupport"
+	"gateway/receipts"
+	"gateway/signing"
+
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+// withReceiptBlobs gives the test an empty receipt blob pool.
+func withReceiptBlobs(t testing.TB) {
+	t.Helper()
+	receiptStoreMu.Lock()
+	prev := receiptBlobs
+	receiptBlobs = make(map[string]*sharedBlob)
+	receiptStoreMu.Unlock()
+	t.Cleanup(func() {
+		receiptStoreMu.Lock()
+		receiptBlobs = prev
+		receiptStoreMu.Unlock()
+	})
+}
+
+// identicalReceipts returns n receipts for the same request and response,
+// differing only in ID, nonce and timestamp, each generated separately as
+// the gateway would.
+func identicalReceipts(t testing.TB, n int) []*SignedReceipt {
+	t.Helper()
+	t.Setenv("SERVER_WALLET_PRIVATE_KEY", testsupport.TestPrivateKey)
+	signer := testSigner(t)
+	out := make([]*SignedReceipt, n)
+	for i := range out {
+		maxTokens := 256
+		payment := PaymentContext{Amount: "0.001", Token: "USDC", Nonce: fmt.Sprintf("nonce-%d", i), Recipient: "0x2cAF48b4BA1C58721a85dFADa5aC01C2DFa62219", ChainID: 8453}
+		r, err := receipts.Generate(signer, payment, "0x742d35Cc6634C0532925a3b844Bc9e7595f8fE21", "/api/ai/summarize",
+			[]byte(`{"text":"hello"}`), []byte(`{"result":"hi"}`),
+			receipts.WithModel("z-ai/glm-4.5-air:free", ""), receipts.WithParameters(receipts.GenerationParams{MaxTokens: &maxTokens}))
+		if err != nil {
+			t.Fatal(err)
+		}
+		out[i] = r
+	}
+	return out
+}
+
+// testSigner returns the signer of the test server key.
+func testSigner(t testing.TB) signing.Signer {
+	t.Helper()
+	signer, err := getServerSigner()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signer
+}
+
+func TestReceiptStore_DedupeSharesValues(t *testing.T) {
+	resetConfigSnapshot(t)
+	withReceiptStore(t)
+	withReceiptBlobs(t)
+	t.Setenv("RECEIPT_STORE_DEDUPE", "true")
+	currentConfig.Store(loadConfig())
+
+	issued := identicalReceipts(t, 3)
+	for _, r := range issued {
+		if err := storeReceipt(r, time.Hour); err != nil {
+			t.Fatal(err)
+		}
+	}
+	first, _ := getReceipt(issued[0].Receipt.ID)
+	second, _ := getReceipt(issued[1].Receipt.ID)
+	if first == issued[0] {
+		t.Fatal("expected the store to keep its own copy of the receipt")
+	}
+	if first.Receipt.ID != issued[0].Receipt.ID || first.Receipt.Service.RequestHash != issued[0].Receipt.Service.RequestHash {
+		t.Fatal("expected the stored copy to equal the issued receipt")
+	}
+	if unsafe.StringData(first.Receipt.Service.RequestHash) != unsafe.StringData(second.Receipt.Service.RequestHash) ||
+		unsafe.StringData(first.ServerPublicKey) != unsafe.StringData(second.ServerPublicKey) {
+		t.Error("expected identical receipts to share their hashes and key")
+	}
+	if first.Receipt.Service.Parameters != second.Receipt.Service.Parameters {
+		t.Error("expected identical generation parameters to be shared")
+	}
+	if unsafe.StringData(issued[0].Receipt.Service.RequestHash) == unsafe.StringData(issued[1].Receipt.Service.RequestHash) {
+		t.Error("expected the issued receipts to be left alone")
+	}
+	key, _ := crypto.HexToECDSA(testsupport.TestPrivateKey)
+	if err := receipts.Verify(first, &key.PublicKey); err != nil {
+		t.Errorf("expected the shared copy to verify: %v", err)
+	}
+
+	blobs, refs, saved := receiptDedupeStats()
+	if blobs == 0 || refs != 3*blobs || saved == 0 {
+		t.Errorf("unexpected pool stats: %d values, %d refs, %d bytes saved", blobs, refs, saved)
+	}
+
+	// Expired receipts release their values.
+	receiptStoreMu.Lock()
+	for _, entry := range receiptStore {
+		entry.expiresAt = time.Now().Add(-time.Second)
+	}
+	receiptStoreMu.Unlock()
+	cleanupExpiredReceipts()
+	if blobs, _, _ := receiptDedupeStats(); blobs != 0 {
+		t.Errorf("expected the pool to be empty once its receipts expired, has %d values", blobs)
+	}
+}
+
+func TestReceiptStore_DedupeOffKeepsReceipt(t *testing.T) {
+	resetConfigSnapshot(t)
+	withReceiptStore(t)
+	withReceiptBlobs(t)
+	currentConfig.Store(loadConfig())
+
+	r := identicalReceipts(t, 1)[0]
+	if err := storeReceipt(r, time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	if stored, _ := getReceipt(r.Receipt.ID); stored != r {
+		t.Error("expected the receipt to be stored as issued without RECEIPT_STORE_DEDUPE")
+	}
+	if blobs, _, _ := receiptDedupeStats(); blobs != 0 {
+		t.Errorf("expected an empty pool, got %d values", blobs)
+	}
+}
+
+// benchmarkReceiptStore stores b.N identical-request receipts and reports
+// the heap the store retains per receipt.
+func benchmarkReceiptStore(b *testing.B, dedupe string) {
+	resetConfigSnapshot(b)
+	withReceiptStore(b)
+	withReceiptBlobs(b)
+	b.Setenv("RECEIPT_STORE_DEDUPE", dedupe)
+	currentConfig.Store(loadConfig())
+
+	var before, after runtime.MemStats
+	runtime.GC()
+	runtime.ReadMemStats(&before)
+	issued := identicalReceipts(b, b.N)
+
+	b.ResetTimer()
+	for _, r := range issued {
+		if err := storeReceipt(r, time.Hour); err != nil {
+			b.Fatal(err)
+		}
+	}
+	b.StopTimer()
+	// Drop the issued receipts so only what the store retains is counted:
+	// the receipts themselves without sharing, else the copies and the pool.
+	for i := range issued {
+		issued[i] = nil
+	}
+	runtime.GC()
+	runtime.ReadMemStats(&after)
+	b.ReportMetric(float64(int64(after.HeapAlloc)-int64(before.HeapAlloc))/float64(b.N), "heap-B/receipt")
+}
+
+// BenchmarkReceiptStore compares the memory stored receipts of identical
+// requests retain with and without RECEIPT_STORE_DEDUPE, e.g.
+// go test -run x -bench ReceiptStore -benchtime 20000x
+func BenchmarkReceiptStore(b *testing.B) {
+	b.Run("shared=false", func(b *testing.B) { benchmarkReceiptStore(b, "false") })
+	b.Run("shared=true", func(b *testing.B) { benchmarkReceiptStore(b, "true") })
+}
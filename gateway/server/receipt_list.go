@@ -0,0 +1,171 @@
+package server
+
+import (
+	"encoding/base64"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"gateway/payments"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/gin-gonic/gin"
+)
+
+// Receipts returned per page by GET /api/receipts/mine unless ?limit= asks
+// for more, up to maxReceiptListPage.
+const (
+	defaultReceiptListPage = 50
+	maxReceiptListPage     = 500
+)
+
+// receiptListMessage is the text a wallet personal_signs, with the current
+// unix time, to list its receipts. It cannot be mistaken for a payment or a
+// usage challenge.
+func receiptListMessage(timestamp int64) string {
+	return fmt.Sprintf("%s receipts request\nTimestamp: %d", payments.DomainName, timestamp)
+}
+
+// getReceiptListMaxSkew returns how far X-402-Timestamp may be from the
+// gateway's clock, RECEIPT_LIST_MAX_SKEW_SECONDS, default 5 minutes. A
+// signature can be reused for further pages within that time.
+func getReceiptListMaxSkew() time.Duration {
+	return time.Duration(getEnvAsInt("RECEIPT_LIST_MAX_SKEW_SECONDS", 300)) * time.Second
+}
+
+// authenticateReceiptList recovers the wallet that personal_signed
+// receiptListMessage for X-402-Timestamp. Unlike usage challenges nothing
+// is stored, so paging needs no round trip per page. On failure it responds
+// 401 and returns false.
+func authenticateReceiptList(c *gin.Context) (common.Address, bool) {
+	signature := c.GetHeader("X-402-Signature")
+	raw := c.GetHeader("X-402-Timestamp")
+	if signature == "" || raw == "" {
+		respondError(c, CodeUnauthorized, `Sign "`+payments.DomainName+` receipts request\nTimestamp: <unix seconds>" and send it in X-402-Signature with the time in X-402-Timestamp`)
+		return common.Address{}, false
+	}
+	timestamp, err := strconv.ParseInt(raw, 10, 64)
+	if err != nil {
+		respondError(c, CodeUnauthorized, "X-402-Timestamp must be unix seconds")
+		return common.Address{}, false
+	}
+	if skew := time.Since(time.Unix(timestamp, 0)); skew > getReceiptListMaxSkew() || -skew > getReceiptListMaxSkew() {
+		respondError(c, CodeUnauthorized, "X-402-Timestamp is too far from the current time; sign a new message")
+		return common.Address{}, false
+	}
+	signer, err := payments.RecoverMessageSigner(receiptListMessage(timestamp), signature)
+	if err != nil {
+		respondError(c, CodeUnauthorized, "Invalid signature: "+err.Error())
+		return common.Address{}, false
+	}
+	if payer := c.GetHeader("X-402-Payer"); payer != "" && !strings.EqualFold(payer, signer.Hex()) {
+		respondError(c, CodeUnauthorized, "The message was not signed by the X-402-Payer wallet")
+		return common.Address{}, false
+	}
+	return signer, true
+}
+
+// receiptCursor is the position after the last receipt of a page: receipts
+// are listed newest first, by timestamp then ID.
+type receiptCursor struct {
+	Timestamp time.Time
+	ID        string
+}
+
+// encode returns the opaque next_cursor value.
+func (rc receiptCursor) encode() string {
+	return base64.RawURLEncoding.EncodeToString([]byte(strconv.FormatInt(rc.Timestamp.UnixNano(), 10) + ":" + rc.ID))
+}
+
+// decodeReceiptCursor parses a next_cursor value.
+func decodeReceiptCursor(raw string) (receiptCursor, error) {
+	data, err := base64.RawURLEncoding.DecodeString(raw)
+	if err != nil {
+		return receiptCursor{}, err
+	}
+	nanos, id, ok := strings.Cut(string(data), ":")
+	n, err := strconv.ParseInt(nanos, 10, 64)
+	if !ok || err != nil || id == "" {
+		return receiptCursor{}, fmt.Errorf("malformed cursor")
+	}
+	return receiptCursor{Timestamp: time.Unix(0, n), ID: id}, nil
+}
+
+// before reports whether r sorts after the cursor, i.e. is older.
+func (rc receiptCursor) before(r *SignedReceipt) bool {
+	ts := r.Receipt.Timestamp
+	return ts.Before(rc.Timestamp) || ts.Equal(rc.Timestamp) && r.Receipt.ID < rc.ID
+}
+
+// payerReceiptPage returns up to limit stored receipts issued to payer,
+// newest first, starting after cursor (from the newest when nil), and
+// whether more follow. Only receipts within RECEIPT_TTL are seen.
+func payerReceiptPage(payer string, cursor *receiptCursor, limit int) ([]*SignedReceipt, bool) {
+	now := time.Now()
+	var list []*SignedReceipt
+	receiptStoreMu.RLock()
+	for _, entry := range receiptStore {
+		r := entry.receipt
+		if now.After(entry.expiresAt) || !strings.EqualFold(r.Receipt.Payment.Payer, payer) {
+			continue
+		}
+		if cursor != nil && !cursor.before(r) {
+			continue
+		}
+		list = append(list, r)
+	}
+	receiptStoreMu.RUnlock()
+
+	sort.Slice(list, func(i, j int) bool {
+		a, b := list[i].Receipt, list[j].Receipt
+		if !a.Timestamp.Equal(b.Timestamp) {
+			return a.Timestamp.After(b.Timestamp)
+		}
+		return a.ID > b.ID
+	})
+	if len(list) > limit {
+		return list[:limit], true
+	}
+	return list, false
+}
+
+// handleListMyReceipts handles GET /api/receipts/mine: the receipts of the
+// wallet that signed receiptListMessage, a page at a time. Each page's
+// next_cursor, passed back as ?cursor=, continues after it.
+func handleListMyReceipts(c *gin.Context) {
+	limit := defaultReceiptListPage
+	if raw := c.Query("limit"); raw != "" {
+		n, err := strconv.Atoi(raw)
+		if err != nil || n < 1 || n > maxReceiptListPage {
+			respondError(c, CodeInvalidRequest, fmt.Sprintf("limit must be between 1 and %d", maxReceiptListPage))
+			return
+		}
+		limit = n
+	}
+	var cursor *receiptCursor
+	if raw := c.Query("cursor"); raw != "" {
+		rc, err := decodeReceiptCursor(raw)
+		if err != nil {
+			respondError(c, CodeInvalidRequest, "cursor must be a next_cursor from a previous page")
+			return
+		}
+		cursor = &rc
+	}
+	payer, ok := authenticateReceiptList(c)
+	if !ok {
+		return
+	}
+
+	page, more := payerReceiptPage(payer.Hex(), cursor, limit)
+	resp := gin.H{"address": payer.Hex(), "receipts": page}
+	if page == nil {
+		resp["receipts"] = []*SignedReceipt{}
+	}
+	if more {
+		last := page[len(page)-1].Receipt
+		resp["next_cursor"] = receiptCursor{Timestamp: last.Timestamp, ID: last.ID}.encode()
+	}
+	c.JSON(200, resp)
+}
@@ -0,0 +1,156 @@
+package server
+
+import (
+	"crypto/ecdsa"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"testing"
+	"time"
+
+	"gateway/internal/testsupport"
+	"gateway/payments"
+
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+// receiptListResponse is the body of GET /api/receipts/mine.
+type receiptListResponse struct {
+	Address    string           `json:"address"`
+	Receipts   []*SignedReceipt `json:"receipts"`
+	NextCursor string           `json:"next_cursor"`
+}
+
+// listMyReceipts sends GET /api/receipts/mine signed by key at timestamp.
+func listMyReceipts(t *testing.T, h *testsupport.Harness, key *ecdsa.PrivateKey, timestamp time.Time, query url.Values) *http.Response {
+	t.Helper()
+	req, err := http.NewRequest(http.MethodGet, h.Server.URL+"/api/receipts/mine?"+query.Encode(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if key != nil {
+		sig, err := payments.SignMessage(receiptListMessage(timestamp.Unix()), key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("X-402-Signature", sig)
+		req.Header.Set("X-402-Timestamp", strconv.FormatInt(timestamp.Unix(), 10))
+	}
+	resp, err := h.Server.Client().Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { resp.Body.Close() })
+	return resp
+}
+
+func TestListMyReceipts_PagesThroughPayerReceipts(t *testing.T) {
+	h := testsupport.NewHarness(t, newTestRouter)
+	key, _ := crypto.GenerateKey()
+	payer := crypto.PubkeyToAddress(key.PublicKey)
+
+	h.Verifier.SetValid(payer.Hex())
+	for i := range 5 {
+		if resp := h.Post(t, "/api/ai/summarize", fmt.Sprintf(`{"text":"mine %d"}`, i), "0xsig", fmt.Sprintf("nonce-mine-%d", i)); resp.StatusCode != http.StatusOK {
+			t.Fatalf("expected 200, got %d", resp.StatusCode)
+		}
+	}
+	other, _ := crypto.GenerateKey()
+	h.Verifier.SetValid(crypto.PubkeyToAddress(other.PublicKey).Hex())
+	h.Post(t, "/api/ai/summarize", `{"text":"someone else"}`, "0xsig", "nonce-mine-other")
+
+	// One signature serves every page while its timestamp is fresh.
+	signedAt := time.Now()
+	var seen []*SignedReceipt
+	query := url.Values{"limit": {"2"}}
+	for page := 0; ; page++ {
+		if page > 3 {
+			t.Fatal("expected the cursor to run out")
+		}
+		resp := listMyReceipts(t, h, key, signedAt, query)
+		var body receiptListResponse
+		if resp.StatusCode != http.StatusOK || json.NewDecoder(resp.Body).Decode(&body) != nil {
+			t.Fatalf("page %d: expected 200, got %d", page, resp.StatusCode)
+		}
+		if body.Address != payer.Hex() {
+			t.Fatalf("expected the signer's address, got %s", body.Address)
+		}
+		seen = append(seen, body.Receipts...)
+		if body.NextCursor == "" {
+			break
+		}
+		query.Set("cursor", body.NextCursor)
+	}
+
+	if len(seen) != 5 {
+		t.Fatalf("expected the payer's 5 receipts, got %d", len(seen))
+	}
+	ids := make(map[string]bool)
+	for i, r := range seen {
+		if r.Receipt.Payment.Payer != payer.Hex() || r.Signature == "" {
+			t.Errorf("unexpected receipt %+v", r.Receipt.Payment)
+		}
+		if i > 0 && r.Receipt.Timestamp.After(seen[i-1].Receipt.Timestamp) {
+			t.Error("expected receipts newest first")
+		}
+		ids[r.Receipt.ID] = true
+	}
+	if len(ids) != 5 {
+		t.Errorf("expected no receipt on two pages, got %d distinct", len(ids))
+	}
+}
+
+func TestListMyReceipts_Rejected(t *testing.T) {
+	h := testsupport.NewHarness(t, newTestRouter)
+	key, _ := crypto.GenerateKey()
+	now := time.Now()
+
+	cases := []struct {
+		name      string
+		key       *ecdsa.PrivateKey
+		timestamp time.Time
+		query     url.Values
+		status    int
+	}{
+		{"no headers", nil, now, nil, http.StatusUnauthorized},
+		{"stale timestamp", key, now.Add(-10 * time.Minute), nil, http.StatusUnauthorized},
+		{"future timestamp", key, now.Add(10 * time.Minute), nil, http.StatusUnauthorized},
+		{"bad limit", key, now, url.Values{"limit": {"0"}}, http.StatusBadRequest},
+		{"bad cursor", key, now, url.Values{"cursor": {"!!"}}, http.StatusBadRequest},
+	}
+	for _, tc := range cases {
+		if resp := listMyReceipts(t, h, tc.key, tc.timestamp, tc.query); resp.StatusCode != tc.status {
+			t.Errorf("%s: expected %d, got %d", tc.name, tc.status, resp.StatusCode)
+		}
+	}
+
+	// A signature over another timestamp recovers to an unrelated wallet.
+	req, _ := http.NewRequest(http.MethodGet, h.Server.URL+"/api/receipts/mine", nil)
+	sig, _ := payments.SignMessage(receiptListMessage(now.Unix()), key)
+	req.Header.Set("X-402-Signature", sig)
+	req.Header.Set("X-402-Timestamp", strconv.FormatInt(now.Unix()-1, 10))
+	req.Header.Set("X-402-Payer", crypto.PubkeyToAddress(key.PublicKey).Hex())
+	resp, err := h.Server.Client().Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("expected a signature of another timestamp to fail X-402-Payer, got %d", resp.StatusCode)
+	}
+}
+
+func TestReceiptCursor_RoundTrip(t *testing.T) {
+	rc := receiptCursor{Timestamp: time.Unix(1700000000, 123456789), ID: "rcpt_abc"}
+	got, err := decodeReceiptCursor(rc.encode())
+	if err != nil || !got.Timestamp.Equal(rc.Timestamp) || got.ID != rc.ID {
+		t.Fatalf("decoded %+v (%v), want %+v", got, err, rc)
+	}
+	for _, bad := range []string{"", "bm90LWEtY3Vyc29y", "MTIzOg"} {
+		if _, err := decodeReceiptCursor(bad); err == nil {
+			t.Errorf("%q: expected an error", bad)
+		}
+	}
+}
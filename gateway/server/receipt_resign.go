@@ -0,0 +1,78 @@
+package server
+
+import (
+	"encoding/hex"
+	"log"
+	"sort"
+	"time"
+
+	"gateway/receipts"
+
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/gin-gonic/gin"
+)
+
+// resignFailure is a stored receipt the re-signing batch could not re-sign.
+type resignFailure struct {
+	ReceiptID string `json:"receipt_id"`
+	Error     string `json:"error"`
+}
+
+// handleResignReceipts handles POST /api/admin/receipts/resign. After the
+// server key is rotated it re-signs every stored receipt signed with another
+// key, keeping the replaced signature in previous_signatures, so lookups
+// verify against the current key. Receipts already in the archive are not
+// rewritten.
+func handleResignReceipts(c *gin.Context) {
+	signer, err := getServerSigner()
+	if err != nil {
+		respondError(c, CodeServiceUnavailable, "Receipt signing key is not configured")
+		return
+	}
+
+	receiptStoreMu.RLock()
+	stored := make([]*SignedReceipt, 0, len(receiptStore))
+	for _, entry := range receiptStore {
+		stored = append(stored, entry.receipt)
+	}
+	receiptStoreMu.RUnlock()
+	sort.Slice(stored, func(i, j int) bool { return stored[i].Receipt.ID < stored[j].Receipt.ID })
+
+	now := time.Now()
+	resigned := make(map[string]*SignedReceipt)
+	failed := []resignFailure{}
+	for _, signed := range stored {
+		updated, changed, err := receipts.Resign(signed, signer, now)
+		if err != nil {
+			failed = append(failed, resignFailure{ReceiptID: signed.Receipt.ID, Error: err.Error()})
+			continue
+		}
+		if changed {
+			resigned[signed.Receipt.ID] = updated
+		}
+	}
+
+	// Receipts may have expired or been re-signed by a concurrent batch
+	// meanwhile; only replace the version that was re-signed.
+	count := 0
+	receiptStoreMu.Lock()
+	for _, signed := range stored {
+		updated, ok := resigned[signed.Receipt.ID]
+		if entry, exists := receiptStore[signed.Receipt.ID]; ok && exists && entry.receipt == signed {
+			entry.receipt = updated
+			count++
+		}
+	}
+	receiptStoreMu.Unlock()
+
+	for _, f := range failed {
+		log.Printf("[WARNING] Could not re-sign receipt %s: %s", f.ReceiptID, f.Error)
+	}
+	log.Printf("Re-signed %d of %d stored receipts", count, len(stored))
+	c.JSON(200, gin.H{
+		"server_public_key": "0x" + hex.EncodeToString(crypto.FromECDSAPub(signer.Public())),
+		"checked":           len(stored),
+		"resigned":          count,
+		"failed":            failed,
+	})
+}
@@ -0,0 +1,71 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"gateway/internal/testsupport"
+	"gateway/receipts"
+)
+
+const rotatedPrivateKey = "fedcba9876543210fedcba9876543210fedcba9876543210fedcba9876543210"
+
+func TestResignReceipts_AfterKeyRotation(t *testing.T) {
+	withReceiptStore(t)
+	withRevocations(t)
+	t.Setenv("ADMIN_API_KEY", "s3cret")
+	h := testsupport.NewHarness(t, newTestRouter)
+	issued := decodeReceiptHeader(t, paidReceipt(t, h, "nonce-resign"))
+
+	t.Setenv("SERVER_WALLET_PRIVATE_KEY", rotatedPrivateKey)
+	newKey, err := getServerPrivateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := newTestRouter()
+	w := adminPost(t, r, "/api/admin/receipts/resign", "s3cret", "")
+	var out struct {
+		ServerPublicKey string          `json:"server_public_key"`
+		Checked         int             `json:"checked"`
+		Resigned        int             `json:"resigned"`
+		Failed          []resignFailure `json:"failed"`
+	}
+	if w.Code != http.StatusOK || json.Unmarshal(w.Body.Bytes(), &out) != nil {
+		t.Fatalf("expected 200, got %d: %s", w.Code, w.Body)
+	}
+	if out.Checked != 1 || out.Resigned != 1 || len(out.Failed) != 0 {
+		t.Errorf("expected the stored receipt to be re-signed, got %+v", out)
+	}
+
+	lookup := httptest.NewRecorder()
+	r.ServeHTTP(lookup, httptest.NewRequest(http.MethodGet, "/api/receipts/"+issued.Receipt.ID, nil))
+	var stored receipts.SignedReceipt
+	if err := json.Unmarshal(lookup.Body.Bytes(), &stored); err != nil {
+		t.Fatal(err)
+	}
+	if err := receipts.Verify(&stored, &newKey.PublicKey); err != nil {
+		t.Errorf("expected the receipt to verify against the current key, got %v", err)
+	}
+	if len(stored.PreviousSignatures) != 1 || stored.PreviousSignatures[0].Signature != issued.Signature || stored.PreviousSignatures[0].ServerPublicKey != issued.ServerPublicKey {
+		t.Fatalf("expected the original signature in previous_signatures, got %+v", stored.PreviousSignatures)
+	}
+	original := &receipts.SignedReceipt{Receipt: stored.Receipt, Signature: stored.PreviousSignatures[0].Signature, ServerPublicKey: stored.PreviousSignatures[0].ServerPublicKey}
+	if err := receipts.Verify(original, nil); err != nil {
+		t.Errorf("expected the original signature to still verify, got %v", err)
+	}
+
+	w = adminPost(t, r, "/api/admin/receipts/resign", "s3cret", "")
+	if json.Unmarshal(w.Body.Bytes(), &out) != nil || out.Resigned != 0 {
+		t.Errorf("expected nothing left to re-sign, got %s", w.Body)
+	}
+}
+
+func TestResignReceipts_RequiresAdminKey(t *testing.T) {
+	t.Setenv("ADMIN_API_KEY", "s3cret")
+	testsupport.NewHarness(t, newTestRouter)
+	if w := adminPost(t, newTestRouter(), "/api/admin/receipts/resign", "wrong", ""); w.Code != http.StatusUnauthorized {
+		t.Errorf("expected 401, got %d", w.Code)
+	}
+}
@@ -1,77 +1,21 @@
-package main
+package server
 
 import (
-	"crypto/sha256"
+	"context"
 	"encoding/hex"
 	"encoding/json"
+	"io"
+	"net/http"
 	"strings"
 	"testing"
 	"time"
 
+	"gateway/internal/testsupport"
+	"gateway/receipts"
+
 	"github.com/ethereum/go-ethereum/crypto"
 )
 
-func TestGenerateReceiptID(t *testing.T) {
-	// Generate multiple IDs and check format
-	ids := make(map[string]bool)
-
-	for i := 0; i < 100; i++ {
-		id, err := generateReceiptID()
-		if err != nil {
-			t.Fatalf("generateReceiptID() failed: %v", err)
-		}
-
-		// Check format
-		if !strings.HasPrefix(id, "rcpt_") {
-			t.Errorf("Receipt ID should start with 'rcpt_', got: %s", id)
-		}
-
-		// Check length (rcpt_ + 12 hex chars = 17 total)
-		if len(id) != 17 {
-			t.Errorf("Receipt ID should be 17 characters, got %d: %s", len(id), id)
-		}
-
-		// Check uniqueness
-		if ids[id] {
-			t.Errorf("Duplicate receipt ID generated: %s", id)
-		}
-		ids[id] = true
-	}
-}
-
-func TestHashData(t *testing.T) {
-	tests := []struct {
-		name     string
-		data     []byte
-		expected string
-	}{
-		{
-			name:     "Empty data",
-			data:     []byte{},
-			expected: "sha256:e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855",
-		},
-		{
-			name:     "Simple text",
-			data:     []byte("test"),
-			expected: "sha256:" + hashHex([]byte("test")),
-		},
-		{
-			name:     "JSON data",
-			data:     []byte(`{"key":"value"}`),
-			expected: "sha256:" + hashHex([]byte(`{"key":"value"}`)),
-		},
-	}
-
-	for _, tt := range tests {
-		t.Run(tt.name, func(t *testing.T) {
-			result := hashData(tt.data)
-			if result != tt.expected {
-				t.Errorf("hashData() = %v, want %v", result, tt.expected)
-			}
-		})
-	}
-}
-
 func TestSignReceipt(t *testing.T) {
 	// Create a test receipt
 	receipt := Receipt{
@@ -121,56 +65,10 @@ func TestSignReceipt(t *testing.T) {
 	}
 }
 
-func TestReceiptJSONSerialization(t *testing.T) {
-	receipt := Receipt{
-		ID:        "rcpt_abc123def456",
-		Version:   "1.0",
-		Timestamp: time.Date(2024, 1, 15, 10, 30, 0, 0, time.UTC),
-		Payment: PaymentDetails{
-			Payer:     "0x742d35Cc6634C0532925a3b844Bc9e7595f8fE21",
-			Recipient: "0x2cAF48b4BA1C58721a85dFADa5aC01C2DFa62219",
-			Amount:    "0.001",
-			Token:     "USDC",
-			ChainID:   8453,
-			Nonce:     "test-nonce",
-		},
-		Service: ServiceDetails{
-			Endpoint:     "/api/ai/summarize",
-			RequestHash:  "sha256:request",
-			ResponseHash: "sha256:response",
-		},
-	}
-
-	// Serialize twice to check determinism
-	json1, err1 := json.Marshal(receipt)
-	json2, err2 := json.Marshal(receipt)
-
-	if err1 != nil || err2 != nil {
-		t.Fatalf("JSON marshaling failed: %v, %v", err1, err2)
-	}
-
-	if string(json1) != string(json2) {
-		t.Error("JSON serialization is not deterministic")
-	}
-
-	// Verify all fields are present
-	var decoded map[string]interface{}
-	if err := json.Unmarshal(json1, &decoded); err != nil {
-		t.Fatalf("Failed to unmarshal JSON for field verification: %v", err)
-	}
-
-	requiredFields := []string{"id", "version", "timestamp", "payment", "service"}
-	for _, field := range requiredFields {
-		if _, exists := decoded[field]; !exists {
-			t.Errorf("Missing field in JSON: %s", field)
-		}
-	}
-}
-
 func TestStoreAndRetrieveReceipt(t *testing.T) {
-	receiptID, err := generateReceiptID()
+	receiptID, err := receipts.NewID()
 	if err != nil {
-		t.Fatalf("generateReceiptID() failed: %v", err)
+		t.Fatalf("receipts.NewID() failed: %v", err)
 	}
 
 	signedReceipt := &SignedReceipt{
@@ -223,25 +121,6 @@ func TestReceiptNotFound(t *testing.T) {
 	}
 }
 
-func TestHashDataConsistency(t *testing.T) {
-	data := []byte("consistent test data")
-
-	// Hash multiple times
-	hash1 := hashData(data)
-	hash2 := hashData(data)
-	hash3 := hashData(data)
-
-	if hash1 != hash2 || hash2 != hash3 {
-		t.Error("hashData should produce consistent results")
-	}
-}
-
-// Helper function for testing
-func hashHex(data []byte) string {
-	hash := sha256.Sum256(data)
-	return hex.EncodeToString(hash[:])
-}
-
 func TestVerifyReceiptSignature(t *testing.T) {
 	// This test verifies that signature verification works correctly
 	// Skip if private key not available
@@ -250,9 +129,9 @@ func TestVerifyReceiptSignature(t *testing.T) {
 		t.Skip("Skipping verification test: SERVER_WALLET_PRIVATE_KEY not set")
 	}
 
-	receiptID, err := generateReceiptID()
+	receiptID, err := receipts.NewID()
 	if err != nil {
-		t.Fatalf("generateReceiptID() failed: %v", err)
+		t.Fatalf("receipts.NewID() failed: %v", err)
 	}
 
 	receipt := Receipt{
@@ -456,3 +335,181 @@ func TestReceiptFullFlowIntegration(t *testing.T) {
 	t.Logf("  - Expiration working correctly")
 	t.Logf("  - Validation working correctly")
 }
+
+func TestNextReceiptSequence_PerPayer(t *testing.T) {
+	t.Cleanup(func() { receiptSequences = make(map[string]int64) })
+	receiptSequences = make(map[string]int64)
+	ctx := context.Background()
+
+	for want := int64(1); want <= 3; want++ {
+		got, err := nextReceiptSequence(ctx, "0xAbC")
+		if err != nil {
+			t.Fatalf("nextReceiptSequence failed: %v", err)
+		}
+		if got != want {
+			t.Errorf("expected sequence %d, got %d", want, got)
+		}
+	}
+
+	// Addresses are case-insensitive and each payer has its own counter.
+	if got, _ := nextReceiptSequence(ctx, "0xabc"); got != 4 {
+		t.Errorf("expected checksummed and lowercase address to share a counter, got %d", got)
+	}
+	if got, _ := nextReceiptSequence(ctx, "0xdef"); got != 1 {
+		t.Errorf("expected new payer to start at 1, got %d", got)
+	}
+}
+
+func TestGetReceiptByRequestHash(t *testing.T) {
+	h := testsupport.NewHarness(t, newTestRouter)
+	body := `{"text":"look me up by hash"}`
+	resp := h.Post(t, "/api/ai/summarize", body, "0xsig", "nonce-by-hash")
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected 200, got %d", resp.StatusCode)
+	}
+	issued := decodeReceiptHeader(t, resp.Header.Get("X-402-Receipt"))
+
+	hash := receipts.HashData([]byte(body))
+	for _, path := range []string{hash, strings.TrimPrefix(hash, "sha256:"), strings.ToUpper(strings.TrimPrefix(hash, "sha256:"))} {
+		resp := h.Get(t, "/api/receipts/by-request-hash/"+path)
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("%s: expected 200, got %d", path, resp.StatusCode)
+		}
+		var got struct {
+			Receipt Receipt `json:"receipt"`
+			Status  string  `json:"status"`
+		}
+		if err := json.NewDecoder(resp.Body).Decode(&got); err != nil {
+			t.Fatal(err)
+		}
+		if got.Receipt.ID != issued.Receipt.ID || got.Status != "valid" {
+			t.Errorf("%s: expected receipt %s, got %s (%s)", path, issued.Receipt.ID, got.Receipt.ID, got.Status)
+		}
+	}
+
+	if resp := h.Get(t, "/api/receipts/by-request-hash/"+strings.Repeat("0", 64)); resp.StatusCode != http.StatusNotFound {
+		t.Errorf("unknown hash: expected 404, got %d", resp.StatusCode)
+	}
+	if resp := h.Get(t, "/api/receipts/by-request-hash/not-a-hash"); resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("malformed hash: expected 400, got %d", resp.StatusCode)
+	}
+}
+
+func TestGetReceiptByRequestHash_Expired(t *testing.T) {
+	hash := receipts.HashData([]byte("expired request"))
+	receipt := &SignedReceipt{
+		Receipt: Receipt{
+			ID:        "rcpt_0123456789ab",
+			Version:   "1.0",
+			Timestamp: time.Now().UTC(),
+			Payment:   PaymentDetails{Payer: "0x742d35Cc6634C0532925a3b844Bc9e7595f8fE21", Recipient: "0x2cAF48b4BA1C58721a85dFADa5aC01C2DFa62219", Amount: "0.001", Token: "USDC", ChainID: 8453, Nonce: "nonce-expired"},
+			Service:   ServiceDetails{Endpoint: "/api/ai/summarize", RequestHash: hash, ResponseHash: "sha256:response"},
+		},
+		Signature:       "0x1234",
+		ServerPublicKey: "0xabcd",
+	}
+	if err := storeReceipt(receipt, -time.Second); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := getReceiptByRequestHash(hash); ok {
+		t.Error("expected an expired receipt not to be found by request hash")
+	}
+
+	cleanupExpiredReceipts()
+	receiptStoreMu.RLock()
+	_, indexed := receiptsByRequestHash[hash]
+	receiptStoreMu.RUnlock()
+	if indexed {
+		t.Error("expected cleanup to drop the index entry of an expired receipt")
+	}
+}
+
+func TestGetReceipt_ETagAndConditionalRequests(t *testing.T) {
+	withRevocations(t)
+	t.Setenv("RECEIPT_CACHE_MAX_AGE_SECONDS", "120")
+	h := testsupport.NewHarness(t, newTestRouter)
+	resp := h.Post(t, "/api/ai/summarize", `{"text":"cache me"}`, "0xsig", "nonce-etag")
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected 200, got %d", resp.StatusCode)
+	}
+	id := decodeReceiptHeader(t, resp.Header.Get("X-402-Receipt")).Receipt.ID
+	path := "/api/receipts/" + id
+
+	lookup := func(path string, headers map[string]string) *http.Response {
+		t.Helper()
+		req, err := http.NewRequest(http.MethodGet, h.Server.URL+path, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for k, v := range headers {
+			req.Header.Set(k, v)
+		}
+		resp, err := h.Server.Client().Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { resp.Body.Close() })
+		return resp
+	}
+
+	first := lookup(path, nil)
+	etag := first.Header.Get("ETag")
+	if first.StatusCode != http.StatusOK || etag == "" || first.Header.Get("Last-Modified") == "" {
+		t.Fatalf("expected 200 with ETag and Last-Modified, got %d %q", first.StatusCode, etag)
+	}
+	if got := first.Header.Get("Cache-Control"); got != "public, max-age=120" {
+		t.Errorf("unexpected Cache-Control %q", got)
+	}
+	if again := lookup(path, nil); again.Header.Get("ETag") != etag {
+		t.Error("expected a stable ETag across lookups")
+	}
+
+	notModified := lookup(path, map[string]string{"If-None-Match": `"other", ` + etag})
+	if notModified.StatusCode != http.StatusNotModified || notModified.Header.Get("X-402-Receipt-Status") != "valid" {
+		t.Errorf("If-None-Match: expected 304 with the status header, got %d", notModified.StatusCode)
+	}
+	if resp := lookup(path, map[string]string{"If-Modified-Since": first.Header.Get("Last-Modified")}); resp.StatusCode != http.StatusNotModified {
+		t.Errorf("If-Modified-Since: expected 304, got %d", resp.StatusCode)
+	}
+	if resp := lookup(path, map[string]string{"If-None-Match": `"stale"`}); resp.StatusCode != http.StatusOK {
+		t.Errorf("stale ETag: expected 200, got %d", resp.StatusCode)
+	}
+
+	// Each encoding and each status is its own representation.
+	if jws := lookup(path+"?receipt_format=jws", map[string]string{"If-None-Match": etag}); jws.StatusCode != http.StatusOK || jws.Header.Get("ETag") == etag {
+		t.Errorf("jws: expected 200 with a different ETag, got %d", jws.StatusCode)
+	}
+	if err := revokeReceipt(t.Context(), Revocation{ReceiptID: id, Status: receiptStatusRevoked, Reason: "chargeback", RevokedAt: time.Now().UTC()}); err != nil {
+		t.Fatal(err)
+	}
+	revoked := lookup(path, map[string]string{"If-None-Match": etag})
+	if revoked.StatusCode != http.StatusOK || revoked.Header.Get("ETag") == etag {
+		t.Errorf("after revocation: expected 200 with a new ETag, got %d", revoked.StatusCode)
+	}
+}
+
+func TestResponseSignature_CoversBodyAndCorrelationID(t *testing.T) {
+	h := testsupport.NewHarness(t, newTestRouter)
+	resp := h.Post(t, "/api/ai/summarize", `{"text":"hello"}`, "0xsig", "nonce-resp-sig")
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected 200, got %d", resp.StatusCode)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	sig := resp.Header.Get("X-402-Response-Signature")
+	correlationID := resp.Header.Get("X-Correlation-ID")
+	key, _ := getServerPrivateKey()
+	server := crypto.PubkeyToAddress(key.PublicKey)
+
+	if sig == "" || correlationID == "" {
+		t.Fatalf("expected a response signature and correlation ID, got %q and %q", sig, correlationID)
+	}
+	if err := receipts.VerifyResponse(body, correlationID, sig, server); err != nil {
+		t.Errorf("expected the signature to verify against the published key: %v", err)
+	}
+	if err := receipts.VerifyResponse([]byte(strings.Replace(string(body), "}", ` }`, 1)), correlationID, sig, server); err == nil {
+		t.Error("expected an altered body to fail verification")
+	}
+	if err := receipts.VerifyResponse(body, "another-request", sig, server); err == nil {
+		t.Error("expected another correlation ID to fail verification")
+	}
+}
@@ -0,0 +1,46 @@
+package server
+
+import (
+	"slices"
+	"strings"
+
+	"gateway/receipts"
+
+	"github.com/gin-gonic/gin"
+)
+
+// receiptVersionHeader lists, in a request, the receipt schema versions the
+// client reads and, in the response, the one the gateway answered with.
+const receiptVersionHeader = "X-402-Receipt-Version"
+
+// negotiateReceiptVersion picks the newest supported schema version listed
+// in the request's X-402-Receipt-Version header, or the current one when
+// the header is absent. It aborts with 406 when none is supported.
+func negotiateReceiptVersion(c *gin.Context) (string, bool) {
+	header := strings.TrimSpace(c.GetHeader(receiptVersionHeader))
+	if header == "" {
+		return receipts.Version, true
+	}
+	accepted := strings.Split(header, ",")
+	for i := range accepted {
+		accepted[i] = strings.TrimSpace(accepted[i])
+	}
+	for _, v := range slices.Backward(receipts.SupportedVersions) {
+		if slices.Contains(accepted, v) {
+			return v, true
+		}
+	}
+	abortWithAPIError(c, newAPIError(CodeReceiptVersionUnsupported, "None of the requested receipt versions is supported").
+		with(gin.H{"supported_versions": receipts.SupportedVersions}))
+	return "", false
+}
+
+// migrateStoredReceipt reads a receipt loaded from storage up to the current
+// schema with receipts.Migrate, keeping it as stored if it cannot be.
+func migrateStoredReceipt(r *SignedReceipt) *SignedReceipt {
+	migrated, err := receipts.Migrate(r)
+	if err != nil {
+		return r
+	}
+	return migrated
+}
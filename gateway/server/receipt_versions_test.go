@@ -0,0 +1,93 @@
+package server
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"gateway/internal/testsupport"
+	"gateway/receipts"
+
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+// verifyReceiptAs posts a JSON receipt to the verify endpoint, listing
+// versions in X-402-Receipt-Version when set.
+func verifyReceiptAs(t *testing.T, h *testsupport.Harness, body []byte, versions string) (*http.Response, map[string]interface{}) {
+	t.Helper()
+	req, _ := http.NewRequest(http.MethodPost, h.Server.URL+"/api/receipts/verify", bytes.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	if versions != "" {
+		req.Header.Set(receiptVersionHeader, versions)
+	}
+	resp, err := h.Server.Client().Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	var out map[string]interface{}
+	json.NewDecoder(resp.Body).Decode(&out)
+	return resp, out
+}
+
+func TestReceiptVersions_IssuedAndNegotiated(t *testing.T) {
+	h := testsupport.NewHarness(t, newTestRouter)
+	h.AI.SetUsage(120, 30)
+	header := paidReceipt(t, h, "nonce-receipt-version")
+	receipt := decodeReceiptHeader(t, header)
+	key, _ := crypto.HexToECDSA(testsupport.TestPrivateKey)
+	kid := crypto.PubkeyToAddress(key.PublicKey).Hex()
+	if receipt.Receipt.Version != receipts.Version || receipt.Receipt.KeyID != kid {
+		t.Fatalf("expected a %s receipt with kid %s, got %+v", receipts.Version, kid, receipt.Receipt)
+	}
+	if tokens := receipt.Receipt.Service.Tokens; tokens == nil || tokens.Input != 120 || tokens.Output != 30 {
+		t.Errorf("expected the provider's token usage, got %+v", tokens)
+	}
+	raw, _ := base64.StdEncoding.DecodeString(header)
+
+	resp, out := verifyReceiptAs(t, h, raw, "")
+	shaped, _ := out["receipt"].(map[string]interface{})
+	if out["valid"] != true || out["version"] != receipts.Version || resp.Header.Get(receiptVersionHeader) != receipts.Version || shaped["kid"] != kid {
+		t.Errorf("expected the receipt in the current schema, got %v", out)
+	}
+
+	resp, out = verifyReceiptAs(t, h, raw, "1.0, 0.9")
+	shaped, _ = out["receipt"].(map[string]interface{})
+	service, _ := shaped["service"].(map[string]interface{})
+	if out["valid"] != true || resp.Header.Get(receiptVersionHeader) != receipts.Version1 || shaped["kid"] != nil || service["tokens"] != nil {
+		t.Errorf("expected the receipt in the 1.0 schema, got %v", out)
+	}
+
+	resp, out = verifyReceiptAs(t, h, raw, "3.0")
+	if resp.StatusCode != http.StatusNotAcceptable || out["code"] != string(CodeReceiptVersionUnsupported) {
+		t.Errorf("expected 406 for unsupported versions, got %d %v", resp.StatusCode, out)
+	}
+}
+
+func TestReceiptVersions_V1ReceiptsVerifyAfterMigration(t *testing.T) {
+	h := testsupport.NewHarness(t, newTestRouter)
+	t.Setenv("SERVER_WALLET_PRIVATE_KEY", testsupport.TestPrivateKey)
+	current := decodeReceiptHeader(t, paidReceipt(t, h, "nonce-receipt-v1"))
+
+	legacy := current.Receipt
+	legacy.Version = receipts.Version1
+	legacy.KeyID, legacy.Service.Tokens = "", nil
+	signed, err := receipts.Sign(legacy, testSigner(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	raw, _ := json.Marshal(signed)
+	_, out := verifyReceiptAs(t, h, raw, "")
+	shaped, _ := out["receipt"].(map[string]interface{})
+	if out["valid"] != true || out["version"] != receipts.Version1 || shaped["kid"] != current.Receipt.KeyID {
+		t.Errorf("expected the 1.0 receipt to verify and gain its kid, got %v", out)
+	}
+
+	signed.Receipt.Version = "9.9"
+	raw, _ = json.Marshal(signed)
+	if _, out := verifyReceiptAs(t, h, raw, ""); out["valid"] != false || out["supported_versions"] == nil {
+		t.Errorf("expected an unknown version to be invalid, got %v", out)
+	}
+}
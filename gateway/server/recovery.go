@@ -0,0 +1,183 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"runtime"
+	"runtime/debug"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// PanicReport describes a panic recovered while serving a request.
+type PanicReport struct {
+	Value any
+	// Stack is the panicking goroutine's stack as debug.Stack prints it;
+	// Frames is the same stack, innermost first.
+	Stack         []byte
+	Frames        []runtime.Frame
+	CorrelationID string
+	Method        string
+	// Path is the request path and Route the matched route pattern.
+	Path  string
+	Route string
+	Time  time.Time
+}
+
+// PanicReporter sends recovered panics to an error tracker. Reports are
+// made in the background after the 500 has been written.
+type PanicReporter interface {
+	ReportPanic(ctx context.Context, p PanicReport) error
+}
+
+var (
+	panicReporterMu sync.RWMutex
+	panicReporter   PanicReporter
+)
+
+// setPanicReporter installs r in place of the SENTRY_DSN reporter, or
+// restores it when r is nil, and returns the previous override.
+func setPanicReporter(r PanicReporter) PanicReporter {
+	panicReporterMu.Lock()
+	defer panicReporterMu.Unlock()
+	prev := panicReporter
+	panicReporter = r
+	return prev
+}
+
+// activePanicReporter returns the installed reporter, else the Sentry
+// reporter when SENTRY_DSN is set, else nil.
+func activePanicReporter() PanicReporter {
+	panicReporterMu.RLock()
+	r := panicReporter
+	panicReporterMu.RUnlock()
+	if r != nil {
+		return r
+	}
+	return sentryReporterFor(getConfig().Sentry)
+}
+
+// capturedPanic carries a panic's value and stack across the goroutine
+// RequestTimeoutMiddleware runs handlers in, so the stack recoveryMiddleware
+// reports is the handler's rather than the re-panic's.
+type capturedPanic struct {
+	value  any
+	stack  []byte
+	frames []runtime.Frame
+}
+
+// capturePanic records the current goroutine's stack for p; it is called
+// from the deferred function that recovered p. An already captured panic is
+// returned as is.
+func capturePanic(p any) *capturedPanic {
+	if cp, ok := p.(*capturedPanic); ok {
+		return cp
+	}
+	pcs := make([]uintptr, 64)
+	// Skip runtime.Callers, capturePanic and the deferred function.
+	n := runtime.Callers(3, pcs)
+	frames := runtime.CallersFrames(pcs[:n])
+	cp := &capturedPanic{value: p, stack: debug.Stack()}
+	for more := n > 0; more; {
+		var f runtime.Frame
+		f, more = frames.Next()
+		cp.frames = append(cp.frames, f)
+	}
+	return cp
+}
+
+// isBrokenConnection reports whether p is a write to a client that went
+// away, which needs no 500 or report.
+func isBrokenConnection(p any) bool {
+	err, ok := p.(error)
+	return ok && (errors.Is(err, syscall.EPIPE) || errors.Is(err, syscall.ECONNRESET))
+}
+
+// recoveryMiddleware replaces gin.Recovery: a panicking handler is logged
+// with its stack and correlation ID, counted, reported to the
+// PanicReporter, and answered with an RFC 7807 500 when nothing was written
+// yet. http.ErrAbortHandler is re-panicked so net/http drops the
+// connection as intended.
+func recoveryMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		defer func() {
+			p := recover()
+			if p == nil {
+				return
+			}
+			cp := capturePanic(p)
+			if cp.value == http.ErrAbortHandler {
+				panic(cp.value)
+			}
+			if isBrokenConnection(cp.value) {
+				log.Printf("[WARNING] [CorrelationID: %s] Client connection lost: %v", c.GetString("correlation_id"), cp.value)
+				c.Abort()
+				return
+			}
+
+			report := PanicReport{
+				Value:         cp.value,
+				Stack:         cp.stack,
+				Frames:        cp.frames,
+				CorrelationID: c.GetString("correlation_id"),
+				Method:        c.Request.Method,
+				Path:          c.Request.URL.Path,
+				Route:         c.FullPath(),
+				Time:          time.Now(),
+			}
+			logPanic(report)
+			gatewayStats.RecordPanic(report.Time)
+			if reporter := activePanicReporter(); reporter != nil {
+				go func() {
+					ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+					defer cancel()
+					if err := reporter.ReportPanic(ctx, report); err != nil {
+						log.Printf("[WARNING] [CorrelationID: %s] Failed to report panic: %v", report.CorrelationID, err)
+					}
+				}()
+			}
+
+			c.Set(errorCodeKey, CodeInternal)
+			if c.Writer.Written() {
+				// The response already started; all that can be done is to
+				// stop writing it.
+				c.Abort()
+				return
+			}
+			body, _ := json.Marshal(problemDetails(c, report.CorrelationID))
+			c.Data(http.StatusInternalServerError, "application/problem+json", body)
+			c.Abort()
+		}()
+		c.Next()
+	}
+}
+
+// problemDetails is the RFC 7807 body of a recovered panic. type links the
+// INTERNAL_ERROR entry of the error catalog, and the code, error, message
+// and correlation_id members every error body has are kept as extensions.
+func problemDetails(c *gin.Context, correlationID string) gin.H {
+	spec := errorCatalog[CodeInternal]
+	return gin.H{
+		"type":           errorDocsURL(c, CodeInternal),
+		"title":          spec.Title,
+		"status":         http.StatusInternalServerError,
+		"detail":         spec.Description,
+		"instance":       c.Request.URL.Path,
+		"code":           CodeInternal,
+		"error":          spec.Title,
+		"message":        spec.Description,
+		"correlation_id": correlationID,
+	}
+}
+
+// logPanic logs p with its stack. With LOG_FORMAT=json the stack stays in
+// the one line's msg.
+func logPanic(p PanicReport) {
+	log.Printf("[ERROR] [CorrelationID: %s] Panic serving %s %s: %v\n%s", p.CorrelationID, p.Method, p.Path, p.Value, p.Stack)
+}
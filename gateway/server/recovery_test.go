@@ -0,0 +1,140 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// recordingReporter collects the panics reported to it.
+type recordingReporter struct {
+	reports chan PanicReport
+}
+
+func (r *recordingReporter) ReportPanic(_ context.Context, p PanicReport) error {
+	r.reports <- p
+	return nil
+}
+
+// withPanicReporter installs a recordingReporter for one test.
+func withPanicReporter(t *testing.T) *recordingReporter {
+	t.Helper()
+	r := &recordingReporter{reports: make(chan PanicReport, 1)}
+	prev := setPanicReporter(r)
+	t.Cleanup(func() { setPanicReporter(prev) })
+	return r
+}
+
+// panickingHandler panics so the reported stack can be checked for it.
+func panickingHandler(c *gin.Context) {
+	panic("boom")
+}
+
+func newRecoveryRouter(extra ...gin.HandlerFunc) *gin.Engine {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.Use(recoveryMiddleware(), CorrelationIDMiddleware())
+	r.Use(extra...)
+	r.GET("/panic/:id", panickingHandler)
+	r.GET("/partial", func(c *gin.Context) {
+		c.String(200, "started")
+		panic("late")
+	})
+	return r
+}
+
+func TestRecoveryMiddleware_ProblemDetails(t *testing.T) {
+	withStats(t)
+	reporter := withPanicReporter(t)
+
+	req := httptest.NewRequest(http.MethodGet, "/panic/7", nil)
+	req.Header.Set("X-Correlation-ID", "corr-panic")
+	w := httptest.NewRecorder()
+	newRecoveryRouter().ServeHTTP(w, req)
+
+	if w.Code != http.StatusInternalServerError || w.Header().Get("Content-Type") != "application/problem+json" {
+		t.Fatalf("expected a problem+json 500, got %d %q", w.Code, w.Header().Get("Content-Type"))
+	}
+	var body map[string]any
+	if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+		t.Fatal(err)
+	}
+	if body["status"] != float64(500) || body["title"] != "Internal Server Error" || body["instance"] != "/panic/7" ||
+		!strings.HasSuffix(body["type"].(string), "/api/errors/INTERNAL_ERROR") {
+		t.Errorf("unexpected problem details %v", body)
+	}
+	if body["code"] != "INTERNAL_ERROR" || body["correlation_id"] != "corr-panic" {
+		t.Errorf("expected the error code and correlation ID as extensions, got %v", body)
+	}
+	if strings.Contains(w.Body.String(), "boom") {
+		t.Error("the panic value must not reach the client")
+	}
+
+	select {
+	case p := <-reporter.reports:
+		if p.Value != "boom" || p.CorrelationID != "corr-panic" || p.Route != "/panic/:id" || p.Path != "/panic/7" {
+			t.Errorf("unexpected report %+v", p)
+		}
+		if !strings.Contains(string(p.Stack), "panickingHandler") || !framesInclude(p, "panickingHandler") {
+			t.Error("expected the handler in the reported stack")
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("expected the panic to be reported")
+	}
+	if got := gatewayStats.Total().Panics; got != 1 {
+		t.Errorf("expected one panic counted, got %d", got)
+	}
+}
+
+func TestRecoveryMiddleware_KeepsHandlerStackThroughTimeout(t *testing.T) {
+	reporter := withPanicReporter(t)
+
+	w := httptest.NewRecorder()
+	newRecoveryRouter(RequestTimeoutMiddleware(time.Second)).ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/panic/1", nil))
+	if w.Code != http.StatusInternalServerError {
+		t.Fatalf("expected 500, got %d", w.Code)
+	}
+	p := <-reporter.reports
+	if p.Value != "boom" || !framesInclude(p, "panickingHandler") {
+		t.Errorf("expected the handler's own panic and stack, got %v", p.Value)
+	}
+}
+
+func TestRecoveryMiddleware_StartedResponse(t *testing.T) {
+	withPanicReporter(t)
+
+	w := httptest.NewRecorder()
+	newRecoveryRouter().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/partial", nil))
+	if w.Code != http.StatusOK || w.Body.String() != "started" {
+		t.Errorf("expected the started response to be left alone, got %d %q", w.Code, w.Body.String())
+	}
+}
+
+func TestRecoveryMiddleware_ReraisesAbortHandler(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.Use(recoveryMiddleware())
+	r.GET("/abort", func(c *gin.Context) { panic(http.ErrAbortHandler) })
+
+	defer func() {
+		if p := recover(); p != http.ErrAbortHandler {
+			t.Errorf("expected http.ErrAbortHandler to propagate, got %v", p)
+		}
+	}()
+	r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/abort", nil))
+}
+
+func framesInclude(p PanicReport, function string) bool {
+	for _, f := range p.Frames {
+		if strings.HasSuffix(f.Function, "."+function) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,148 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// Redis deployment modes, REDIS_MODE.
+const (
+	redisModeStandalone = "standalone"
+	redisModeSentinel   = "sentinel"
+	redisModeCluster    = "cluster"
+)
+
+// redisClient is the shared Redis connection, nil when caching is disabled
+// or Redis is unreachable. It is a single node, a Sentinel-managed master
+// or a Cluster depending on REDIS_MODE.
+var redisClient redis.UniversalClient
+
+// RedisConfig selects the Redis deployment. It is only applied at startup.
+// Standalone connects to URL; sentinel asks the Sentinels at Addrs for the
+// current master of MasterName and follows failovers; cluster discovers the
+// cluster from the seed nodes at Addrs and follows slot migrations and
+// failovers.
+type RedisConfig struct {
+	Mode             string
+	URL              string
+	Addrs            []string
+	MasterName       string
+	Password         string
+	SentinelPassword string
+	DB               int
+}
+
+// loadRedisConfig reads REDIS_MODE (default standalone), REDIS_URL,
+// REDIS_ADDRS, REDIS_MASTER_NAME, REDIS_PASSWORD, REDIS_SENTINEL_PASSWORD
+// and REDIS_DB.
+func loadRedisConfig() (RedisConfig, error) {
+	rc := RedisConfig{
+		Mode:             strings.ToLower(getEnv("REDIS_MODE", redisModeStandalone)),
+		URL:              getEnv("REDIS_URL", "localhost:6379"),
+		Addrs:            getEnvAsList("REDIS_ADDRS", nil),
+		MasterName:       os.Getenv("REDIS_MASTER_NAME"),
+		Password:         os.Getenv("REDIS_PASSWORD"),
+		SentinelPassword: os.Getenv("REDIS_SENTINEL_PASSWORD"),
+		DB:               getEnvAsInt("REDIS_DB", 0),
+	}
+	switch rc.Mode {
+	case redisModeStandalone:
+	case redisModeSentinel:
+		if len(rc.Addrs) == 0 || rc.MasterName == "" {
+			return rc, fmt.Errorf("REDIS_MODE=sentinel needs REDIS_ADDRS (the Sentinels) and REDIS_MASTER_NAME")
+		}
+	case redisModeCluster:
+		if len(rc.Addrs) == 0 {
+			return rc, fmt.Errorf("REDIS_MODE=cluster needs REDIS_ADDRS (seed nodes)")
+		}
+		if rc.DB != 0 {
+			return rc, fmt.Errorf("REDIS_DB must be 0 with REDIS_MODE=cluster")
+		}
+	default:
+		return rc, fmt.Errorf("REDIS_MODE must be %s, %s or %s, got %q", redisModeStandalone, redisModeSentinel, redisModeCluster, rc.Mode)
+	}
+	return rc, nil
+}
+
+// newRedisClient returns a client for rc without connecting.
+func newRedisClient(rc RedisConfig) (redis.UniversalClient, error) {
+	if rc.Mode == redisModeSentinel || rc.Mode == redisModeCluster {
+		return redis.NewUniversalClient(&redis.UniversalOptions{
+			Addrs:            rc.Addrs,
+			MasterName:       rc.MasterName,
+			IsClusterMode:    rc.Mode == redisModeCluster,
+			Password:         rc.Password,
+			SentinelPassword: rc.SentinelPassword,
+			DB:               rc.DB,
+		}), nil
+	}
+
+	var opts *redis.Options
+	if strings.HasPrefix(rc.URL, "redis://") || strings.HasPrefix(rc.URL, "rediss://") {
+		// Parse full Redis URL
+		var err error
+		opts, err = redis.ParseURL(rc.URL)
+		if err != nil {
+			return nil, fmt.Errorf("invalid REDIS_URL format: %w", err)
+		}
+	} else {
+		// Treat as host:port and build options manually
+		opts = &redis.Options{
+			Addr:     rc.URL,
+			Password: rc.Password,
+			DB:       rc.DB,
+		}
+	}
+	return redis.NewClient(opts), nil
+}
+
+func initRedis() {
+	if !getCacheEnabled() {
+		return
+	}
+
+	// Close existing client if any
+	if redisClient != nil {
+		redisClient.Close()
+	}
+
+	rc := getConfig().Redis
+	client, err := newRedisClient(rc)
+	if err != nil {
+		log.Printf("WARNING: %v", err)
+		log.Println("Continuing with caching disabled. Set CACHE_ENABLED=false to suppress this warning.")
+		redisClient = nil
+		return
+	}
+	redisClient = client
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if err := redisClient.Ping(ctx).Err(); err != nil {
+		log.Printf("WARNING: Redis connection failed when CACHE_ENABLED=true: %v", err)
+		log.Println("Continuing with caching disabled. Set CACHE_ENABLED=false to suppress this warning.")
+		redisClient.Close()
+		redisClient = nil
+		return
+	}
+	log.Printf("Redis connected successfully (%s)", rc.Mode)
+}
+
+func getCacheEnabled() bool {
+	enabled := strings.ToLower(os.Getenv("CACHE_ENABLED"))
+	return enabled == "true" || enabled == "1"
+}
+
+func getEnv(key, fallback string) string {
+	if value, ok := os.LookupEnv(key); ok {
+		return value
+	}
+	return fallback
+}
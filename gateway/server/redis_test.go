@@ -0,0 +1,75 @@
+package server
+
+import (
+	"testing"
+
+	"github.com/redis/go-redis/v9"
+)
+
+func TestLoadRedisConfig(t *testing.T) {
+	rc, err := loadRedisConfig()
+	if err != nil || rc.Mode != redisModeStandalone || rc.URL != "localhost:6379" {
+		t.Fatalf("unexpected defaults %+v: %v", rc, err)
+	}
+
+	cases := []struct {
+		name string
+		env  map[string]string
+	}{
+		{"unknown mode", map[string]string{"REDIS_MODE": "replica"}},
+		{"sentinel without master", map[string]string{"REDIS_MODE": "sentinel", "REDIS_ADDRS": "s1:26379"}},
+		{"sentinel without addrs", map[string]string{"REDIS_MODE": "sentinel", "REDIS_MASTER_NAME": "mymaster"}},
+		{"cluster without addrs", map[string]string{"REDIS_MODE": "cluster"}},
+		{"cluster with db", map[string]string{"REDIS_MODE": "cluster", "REDIS_ADDRS": "n1:6379", "REDIS_DB": "2"}},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			for k, v := range tc.env {
+				t.Setenv(k, v)
+			}
+			if err := loadConfig().Validate(); err == nil {
+				t.Error("expected validation to fail")
+			}
+		})
+	}
+}
+
+func TestNewRedisClient_Modes(t *testing.T) {
+	t.Setenv("REDIS_MODE", "Sentinel")
+	t.Setenv("REDIS_ADDRS", "s1:26379, s2:26379")
+	t.Setenv("REDIS_MASTER_NAME", "mymaster")
+	rc, err := loadRedisConfig()
+	if err != nil || len(rc.Addrs) != 2 {
+		t.Fatalf("unexpected sentinel config %+v: %v", rc, err)
+	}
+	client, err := newRedisClient(rc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	if _, ok := client.(*redis.Client); !ok {
+		t.Errorf("expected a failover client for sentinel, got %T", client)
+	}
+
+	// A single seed node is still a cluster.
+	cluster, err := newRedisClient(RedisConfig{Mode: redisModeCluster, Addrs: []string{"n1:6379"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cluster.Close()
+	if _, ok := cluster.(*redis.ClusterClient); !ok {
+		t.Errorf("expected a cluster client, got %T", cluster)
+	}
+
+	standalone, err := newRedisClient(RedisConfig{Mode: redisModeStandalone, URL: "redis://localhost:6380/3"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer standalone.Close()
+	if opts := standalone.(*redis.Client).Options(); opts.Addr != "localhost:6380" || opts.DB != 3 {
+		t.Errorf("expected REDIS_URL to be parsed, got %s db %d", opts.Addr, opts.DB)
+	}
+	if _, err := newRedisClient(RedisConfig{Mode: redisModeStandalone, URL: "redis://localhost:6380/x"}); err == nil {
+		t.Error("expected a malformed REDIS_URL to fail")
+	}
+}
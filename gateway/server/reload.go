@@ -0,0 +1,216 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"maps"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"gateway/ratelimit"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/joho/godotenv"
+)
+
+var (
+	configReloadHooksMu sync.Mutex
+	configReloadHooks   []func(old, cfg *Config)
+)
+
+// onConfigReload registers a hook that runs after a new config snapshot has
+// been installed. Hooks receive both snapshots so they can skip work when the
+// settings they care about did not change.
+func onConfigReload(hook func(old, cfg *Config)) {
+	configReloadHooksMu.Lock()
+	defer configReloadHooksMu.Unlock()
+	configReloadHooks = append(configReloadHooks, hook)
+}
+
+// reloadConfig re-reads envFile (when set) over the process environment,
+// validates the resulting snapshot and atomically swaps it in. On failure the
+// previous snapshot stays active. Variables removed from the file keep their
+// previous value because godotenv cannot tell them apart from real env vars.
+func reloadConfig(envFile string) error {
+	if envFile != "" {
+		if err := godotenv.Overload(envFile); err != nil {
+			return fmt.Errorf("read %s: %w", envFile, err)
+		}
+	}
+
+	cfg := loadConfig()
+	if err := cfg.Validate(); err != nil {
+		return fmt.Errorf("invalid configuration: %w", err)
+	}
+	if err := checkModelsOffered(cfg, modelCatalog.Load()); err != nil {
+		return fmt.Errorf("invalid configuration: %w", err)
+	}
+
+	old := currentConfig.Swap(cfg)
+
+	configReloadHooksMu.Lock()
+	hooks := append([]func(old, cfg *Config){}, configReloadHooks...)
+	configReloadHooksMu.Unlock()
+	for _, hook := range hooks {
+		hook(old, cfg)
+	}
+
+	log.Println("Configuration reloaded")
+	return nil
+}
+
+// watchConfigSignals reloads the configuration every time the process
+// receives SIGHUP, until ctx is cancelled.
+func watchConfigSignals(ctx context.Context, envFile string) {
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, syscall.SIGHUP)
+	defer signal.Stop(sigCh)
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-sigCh:
+			log.Println("SIGHUP received, reloading configuration")
+			if err := reloadConfig(envFile); err != nil {
+				log.Printf("[WARNING] Config reload failed, keeping previous config: %v", err)
+			}
+		}
+	}
+}
+
+// watchConfigFile reloads the configuration whenever envFile changes on disk.
+func watchConfigFile(ctx context.Context, envFile string) error {
+	return watchConfigFiles(ctx, envFile, []string{envFile})
+}
+
+// watchConfigFiles reloads the configuration from envFile whenever one of
+// files changes on disk. Parent directories are watched rather than the files
+// themselves so editors and config management tools that replace a file via
+// rename are still seen. Bursts of events are debounced into a single reload.
+func watchConfigFiles(ctx context.Context, envFile string, files []string) error {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return fmt.Errorf("create config watcher: %w", err)
+	}
+	targets := make(map[string]bool, len(files))
+	for _, file := range files {
+		target := filepath.Clean(file)
+		if err := watcher.Add(filepath.Dir(target)); err != nil {
+			watcher.Close()
+			return fmt.Errorf("watch %s: %w", file, err)
+		}
+		targets[target] = true
+	}
+
+	go func() {
+		defer watcher.Close()
+
+		const debounce = 250 * time.Millisecond
+		var changed string
+		var timer *time.Timer
+		var timerC <-chan time.Time
+
+		for {
+			select {
+			case <-ctx.Done():
+				if timer != nil {
+					timer.Stop()
+				}
+				return
+			case event, ok := <-watcher.Events:
+				if !ok {
+					return
+				}
+				if !targets[filepath.Clean(event.Name)] || event.Has(fsnotify.Chmod) {
+					continue
+				}
+				changed = event.Name
+				if timer == nil {
+					timer = time.NewTimer(debounce)
+				} else {
+					timer.Reset(debounce)
+				}
+				timerC = timer.C
+			case <-timerC:
+				timerC = nil
+				log.Printf("Config file %s changed, reloading configuration", changed)
+				if err := reloadConfig(envFile); err != nil {
+					log.Printf("[WARNING] Config reload failed, keeping previous config: %v", err)
+				}
+			case err, ok := <-watcher.Errors:
+				if !ok {
+					return
+				}
+				log.Printf("[WARNING] Config watcher error: %v", err)
+			}
+		}
+	}()
+	return nil
+}
+
+// getConfigWatchEnabled checks if the .env file watcher is enabled
+func getConfigWatchEnabled() bool {
+	enabled := strings.ToLower(os.Getenv("CONFIG_WATCH_ENABLED"))
+	return enabled == "true" || enabled == "1"
+}
+
+// activeRateLimiters holds the per-tier limiters used by the global rate
+// limit middleware so a reload can replace them without re-registering it.
+var activeRateLimiters atomic.Pointer[map[string]ratelimit.RateLimiter]
+
+// getActiveRateLimiters returns the currently installed limiter set.
+func getActiveRateLimiters() map[string]ratelimit.RateLimiter {
+	if limiters := activeRateLimiters.Load(); limiters != nil {
+		return *limiters
+	}
+	return nil
+}
+
+// tunableLimiter is a limiter whose rate and burst can change in place.
+type tunableLimiter interface {
+	SetLimits(rpm, burst int)
+}
+
+// reloadRateLimiters applies changed tier limits. Tiers whose limiter can be
+// tuned keep their buckets, rescaled to the new burst, so a tuning change
+// neither resets nor refills clients; other tiers get a new limiter.
+func reloadRateLimiters(old, cfg *Config) {
+	if old != nil && maps.Equal(old.RateLimits, cfg.RateLimits) {
+		return
+	}
+	applyRateLimits(cfg.RateLimits)
+	log.Println("Rate limiters updated with new limits")
+}
+
+// applyRateLimits tunes the active limiters to limits, creating limiters for
+// new tiers and stopping those of removed ones.
+func applyRateLimits(limits map[string]RateLimitTier) {
+	cleanupTTL := time.Duration(getEnvAsInt("RATE_LIMIT_CLEANUP_INTERVAL", 300)) * time.Second
+	current := getActiveRateLimiters()
+	next := make(map[string]ratelimit.RateLimiter, len(limits))
+	for tier, l := range limits {
+		if limiter, ok := current[tier].(tunableLimiter); ok {
+			limiter.SetLimits(l.RPM, l.Burst)
+			next[tier] = current[tier]
+			continue
+		}
+		next[tier] = ratelimit.NewTokenBucket(l.RPM, l.Burst, cleanupTTL)
+	}
+	activeRateLimiters.Store(&next)
+	for tier, limiter := range current {
+		if next[tier] == limiter {
+			continue
+		}
+		if stopper, ok := limiter.(interface{ Stop() }); ok {
+			stopper.Stop()
+		}
+	}
+}
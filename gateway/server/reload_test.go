@@ -0,0 +1,122 @@
+package server
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"gateway/ratelimit"
+)
+
+// resetConfigSnapshot clears any installed snapshot so other tests keep
+// reading the environment directly.
+func resetConfigSnapshot(t testing.TB) {
+	t.Helper()
+	t.Cleanup(func() { currentConfig.Store(nil) })
+}
+
+func TestReloadConfig_SwapsSnapshotFromEnvFile(t *testing.T) {
+	resetConfigSnapshot(t)
+	t.Setenv("PAYMENT_AMOUNT", "0.001")
+	t.Setenv("CORS_ALLOWED_ORIGINS", "http://localhost:3001")
+
+	currentConfig.Store(loadConfig())
+	if got := getPaymentAmount(); got != "0.001" {
+		t.Fatalf("expected initial amount 0.001, got %s", got)
+	}
+
+	envFile := filepath.Join(t.TempDir(), ".env")
+	content := "PAYMENT_AMOUNT=0.005\nCORS_ALLOWED_ORIGINS=https://app.example.com, https://admin.example.com\n"
+	if err := os.WriteFile(envFile, []byte(content), 0o600); err != nil {
+		t.Fatalf("write env file: %v", err)
+	}
+
+	if err := reloadConfig(envFile); err != nil {
+		t.Fatalf("reloadConfig failed: %v", err)
+	}
+
+	if got := getPaymentAmount(); got != "0.005" {
+		t.Errorf("expected reloaded amount 0.005, got %s", got)
+	}
+	if !getConfig().IsOriginAllowed("https://admin.example.com") {
+		t.Error("expected reloaded CORS origin to be allowed")
+	}
+	if getConfig().IsOriginAllowed("http://localhost:3001") {
+		t.Error("expected old CORS origin to be dropped after reload")
+	}
+}
+
+func TestReloadConfig_InvalidKeepsPreviousSnapshot(t *testing.T) {
+	resetConfigSnapshot(t)
+	t.Setenv("OPENROUTER_MODEL", "model-a")
+	t.Setenv("OPENROUTER_ALLOWED_MODELS", "")
+
+	previous := loadConfig()
+	currentConfig.Store(previous)
+
+	t.Setenv("OPENROUTER_ALLOWED_MODELS", "model-b,model-c")
+	if err := reloadConfig(""); err == nil {
+		t.Fatal("expected reload to fail when model is not in allowlist")
+	}
+
+	if getConfig() != previous {
+		t.Error("expected previous snapshot to remain active after failed reload")
+	}
+}
+
+func TestReloadRateLimiters_RebuildsOnlyWhenLimitsChange(t *testing.T) {
+	resetConfigSnapshot(t)
+	t.Cleanup(func() { activeRateLimiters.Store(nil) })
+	t.Setenv("RATE_LIMIT_ANONYMOUS_RPM", "60")
+	t.Setenv("RATE_LIMIT_ANONYMOUS_BURST", "2")
+
+	old := loadConfig()
+	limiters := newRateLimiters(old)
+	activeRateLimiters.Store(&limiters)
+
+	reloadRateLimiters(old, loadConfig())
+	if getActiveRateLimiters()["anonymous"] != limiters["anonymous"] {
+		t.Error("expected limiters to be kept when limits are unchanged")
+	}
+
+	t.Setenv("RATE_LIMIT_ANONYMOUS_BURST", "7")
+	reloadRateLimiters(old, loadConfig())
+	if got := getActiveRateLimiters()["anonymous"].GetRemaining("ip:1.2.3.4"); got != 7 {
+		t.Errorf("expected new burst of 7, got %d", got)
+	}
+	for _, limiter := range getActiveRateLimiters() {
+		limiter.(*ratelimit.TokenBucket).Stop()
+	}
+}
+
+func TestWatchConfigFile_ReloadsOnWrite(t *testing.T) {
+	resetConfigSnapshot(t)
+	t.Setenv("PAYMENT_AMOUNT", "0.001")
+	currentConfig.Store(loadConfig())
+
+	envFile := filepath.Join(t.TempDir(), ".env")
+	if err := os.WriteFile(envFile, []byte("PAYMENT_AMOUNT=0.001\n"), 0o600); err != nil {
+		t.Fatalf("write env file: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	if err := watchConfigFile(ctx, envFile); err != nil {
+		t.Fatalf("watchConfigFile failed: %v", err)
+	}
+
+	if err := os.WriteFile(envFile, []byte("PAYMENT_AMOUNT=0.002\n"), 0o600); err != nil {
+		t.Fatalf("rewrite env file: %v", err)
+	}
+
+	deadline := time.Now().Add(3 * time.Second)
+	for time.Now().Before(deadline) {
+		if getPaymentAmount() == "0.002" {
+			return
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	t.Fatalf("expected watcher to reload amount to 0.002, got %s", getPaymentAmount())
+}
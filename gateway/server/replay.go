@@ -0,0 +1,169 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/redis/go-redis/v9"
+)
+
+// replayInputKeyPrefix prefixes the Redis keys of retained replay inputs, by
+// request hash.
+const replayInputKeyPrefix = "replay:input:"
+
+var (
+	replayInputsMu sync.Mutex
+	replayInputs   = make(map[string]retainedReplayInput) // request hash -> input
+)
+
+// ReplayInput is what the AI pipeline was given for a paid summary: the text
+// after extraction and the clamped, localized generation parameters, plus
+// the correlation ID the response body carried.
+type ReplayInput struct {
+	Text          string           `json:"text"`
+	Params        GenerationParams `json:"params"`
+	CorrelationID string           `json:"correlation_id,omitempty"`
+}
+
+type retainedReplayInput struct {
+	input     ReplayInput
+	expiresAt time.Time
+}
+
+// getReplayRetention returns how long summary inputs are kept for replay,
+// REPLAY_RETENTION_SECONDS. The default of zero retains nothing, since the
+// inputs are customer content.
+func getReplayRetention() time.Duration {
+	return time.Duration(getEnvAsInt("REPLAY_RETENTION_SECONDS", 0)) * time.Second
+}
+
+// retainReplayInput keeps the input of the summary just sent under its
+// receipt's request hash, in Redis when it is connected so any replica can
+// replay it. Failures are logged; the client has already been answered.
+func retainReplayInput(c *gin.Context, text string) {
+	ttl := getReplayRetention()
+	v, ok := c.Get("issued_receipt")
+	if ttl <= 0 || !ok {
+		return
+	}
+	hash := v.(*SignedReceipt).Receipt.Service.RequestHash
+	input := ReplayInput{Text: text, Params: getGenerationParams(c), CorrelationID: c.GetString("correlation_id")}
+	if err := storeReplayInput(c.Request.Context(), hash, input, ttl); err != nil {
+		log.Printf("[WARNING] Failed to retain replay input: %v", err)
+	}
+}
+
+func storeReplayInput(ctx context.Context, hash string, input ReplayInput, ttl time.Duration) error {
+	if redisClient != nil {
+		data, err := json.Marshal(input)
+		if err != nil {
+			return err
+		}
+		return redisClient.Set(ctx, replayInputKeyPrefix+hash, data, ttl).Err()
+	}
+	replayInputsMu.Lock()
+	defer replayInputsMu.Unlock()
+	now := time.Now()
+	for old, retained := range replayInputs {
+		if now.After(retained.expiresAt) {
+			delete(replayInputs, old)
+		}
+	}
+	replayInputs[hash] = retainedReplayInput{input: input, expiresAt: now.Add(ttl)}
+	return nil
+}
+
+// loadReplayInput returns the input retained for the request hash; ok is
+// false when it was never retained or has expired.
+func loadReplayInput(ctx context.Context, hash string) (input ReplayInput, ok bool, err error) {
+	if redisClient != nil {
+		data, err := redisClient.Get(ctx, replayInputKeyPrefix+hash).Bytes()
+		if errors.Is(err, redis.Nil) {
+			return ReplayInput{}, false, nil
+		}
+		if err != nil {
+			return ReplayInput{}, false, fmt.Errorf("failed to load replay input: %w", err)
+		}
+		if err := json.Unmarshal(data, &input); err != nil {
+			return ReplayInput{}, false, fmt.Errorf("failed to decode replay input: %w", err)
+		}
+		return input, true, nil
+	}
+	replayInputsMu.Lock()
+	defer replayInputsMu.Unlock()
+	retained, ok := replayInputs[hash]
+	if !ok || time.Now().After(retained.expiresAt) {
+		return ReplayInput{}, false, nil
+	}
+	return retained.input, true, nil
+}
+
+// handleReplay handles POST /api/admin/replay/:id. It re-runs the retained
+// input of the receipt's request through the AI providers on the receipt's
+// model, without payment, cache or receipt, and compares the new response
+// hash with the receipt's. The original summary is included while it is
+// still cached.
+func handleReplay(c *gin.Context) {
+	receipt, ok := getReceipt(c.Param("id"))
+	if !ok {
+		respondError(c, CodeReceiptNotFound, "Receipt not found or expired")
+		return
+	}
+	service := receipt.Receipt.Service
+	ctx := c.Request.Context()
+	input, ok, err := loadReplayInput(ctx, service.RequestHash)
+	if err != nil {
+		log.Printf("[WARNING] Replay input lookup failed: %v", err)
+		respondError(c, CodeServiceUnavailable, "Replay inputs are unavailable")
+		return
+	}
+	if !ok {
+		respondError(c, CodeNotFound, "The request of this receipt was not retained; set REPLAY_RETENTION_SECONDS to keep summary inputs for replay")
+		return
+	}
+	model := service.Model
+	if model == "" {
+		model = getConfig().Model
+	}
+
+	res, err := callAIProviders(ctx, model, input.Text, input.Params)
+	if err != nil {
+		if errors.Is(err, context.DeadlineExceeded) {
+			respondError(c, CodeAITimeout, "AI request timed out")
+			return
+		}
+		respondAPIError(c, newAPIError(CodeAIFailed, "The AI provider request failed").withDetails(err.Error()))
+		return
+	}
+
+	original := gin.H{"response_hash": service.ResponseHash, "model": service.Model}
+	if cached, err := getFromCache(ctx, getCacheKey(input.Text, model, input.Params)); err == nil && summaryResponseHash(replayedSummary(receipt, input, cached.Result)) == service.ResponseHash {
+		original["result"] = cached.Result
+	}
+	replayHash := summaryResponseHash(replayedSummary(receipt, input, res.Summary))
+	c.JSON(200, gin.H{
+		"receipt_id":   receipt.Receipt.ID,
+		"request_hash": service.RequestHash,
+		"input":        input,
+		"original":     original,
+		"replay": gin.H{
+			"result":        res.Summary,
+			"response_hash": replayHash,
+			"model":         res.Model,
+			"provider":      res.Provider,
+		},
+		"match": replayHash == service.ResponseHash,
+	})
+}
+
+// replayedSummary is the body the receipt's request would have been answered
+// with had the summary been summary.
+func replayedSummary(receipt *SignedReceipt, input ReplayInput, summary string) SummaryResponse {
+	return SummaryResponse{Result: summary, ReceiptID: receipt.Receipt.ID, CorrelationID: input.CorrelationID}
+}
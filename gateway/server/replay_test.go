@@ -0,0 +1,109 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"gateway/internal/testsupport"
+)
+
+// replayResponse is the body of POST /api/admin/replay/:id.
+type replayResponse struct {
+	Input    ReplayInput `json:"input"`
+	Original struct {
+		ResponseHash string `json:"response_hash"`
+		Result       string `json:"result"`
+	} `json:"original"`
+	Replay struct {
+		Result       string `json:"result"`
+		ResponseHash string `json:"response_hash"`
+	} `json:"replay"`
+	Match bool `json:"match"`
+}
+
+// replay replays the receipt id through router and decodes the result.
+func replay(t *testing.T, router http.Handler, id string) replayResponse {
+	t.Helper()
+	w := adminPost(t, router, "/api/admin/replay/"+id, "s3cret", "")
+	var out replayResponse
+	if w.Code != http.StatusOK || json.Unmarshal(w.Body.Bytes(), &out) != nil {
+		t.Fatalf("replay: expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+	return out
+}
+
+func TestReplay_RerunsRetainedInput(t *testing.T) {
+	t.Setenv("ADMIN_API_KEY", "s3cret")
+	t.Setenv("REPLAY_RETENTION_SECONDS", "3600")
+	h := testsupport.NewHarness(t, newTestRouter)
+
+	resp := h.Post(t, "/api/ai/summarize", `{"text":"`+spanishText+`","temperature":0.3}`, "0xsig", "nonce-replay")
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected 200, got %d", resp.StatusCode)
+	}
+	receipt := decodeReceiptHeader(t, resp.Header.Get("X-402-Receipt"))
+	calls, verifications := h.AI.Calls(), h.Verifier.Calls()
+
+	out := replay(t, newTestRouter(), receipt.Receipt.ID)
+	if !out.Match || out.Replay.ResponseHash != receipt.Receipt.Service.ResponseHash {
+		t.Errorf("expected the same answer to match the receipt, got %+v", out)
+	}
+	if out.Input.Text != spanishText || out.Input.Params.OutputLanguage != "es" || *out.Input.Params.Temperature != 0.3 {
+		t.Errorf("expected the retained input, got %+v", out.Input)
+	}
+	if h.AI.Calls() != calls+1 || h.Verifier.Calls() != verifications {
+		t.Error("expected one provider call and no payment verification")
+	}
+	if prompt := lastPrompt(t, h); !strings.Contains(prompt, "in Spanish") {
+		t.Errorf("expected the replay to keep the output language, got %q", prompt)
+	}
+
+	h.AI.SetReply("A different summary.")
+	if out := replay(t, newTestRouter(), receipt.Receipt.ID); out.Match || out.Replay.Result != "A different summary." {
+		t.Errorf("expected a changed answer not to match, got %+v", out)
+	}
+}
+
+func TestReplay_NotAvailable(t *testing.T) {
+	t.Setenv("ADMIN_API_KEY", "s3cret")
+	h := testsupport.NewHarness(t, newTestRouter)
+	receipt := decodeReceiptHeader(t, paidReceipt(t, h, "nonce-replay-off"))
+
+	if w := adminPost(t, newTestRouter(), "/api/admin/replay/"+receipt.Receipt.ID, "s3cret", ""); w.Code != http.StatusNotFound || !strings.Contains(w.Body.String(), `"code":"NOT_FOUND"`) {
+		t.Errorf("expected 404 NOT_FOUND without retention, got %d: %s", w.Code, w.Body.String())
+	}
+	if w := adminPost(t, newTestRouter(), "/api/admin/replay/unknown", "s3cret", ""); w.Code != http.StatusNotFound || !strings.Contains(w.Body.String(), `"code":"RECEIPT_NOT_FOUND"`) {
+		t.Errorf("expected 404 RECEIPT_NOT_FOUND, got %d: %s", w.Code, w.Body.String())
+	}
+	if w := adminPost(t, newTestRouter(), "/api/admin/replay/"+receipt.Receipt.ID, "wrong", ""); w.Code != http.StatusUnauthorized {
+		t.Errorf("expected the admin key to be required, got %d", w.Code)
+	}
+}
+
+func TestReplay_SharedThroughRedis(t *testing.T) {
+	withCacheVersion(t)
+	gw := startGateway(t, map[string]string{"ADMIN_API_KEY": "s3cret", "REPLAY_RETENTION_SECONDS": "3600"})
+
+	resp := gw.Post(t, "/api/ai/summarize", `{"text":"replayed through redis"}`, "0xsig", "nonce-replay-redis")
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected 200, got %d", resp.StatusCode)
+	}
+	receipt := decodeReceiptHeader(t, resp.Header.Get("X-402-Receipt"))
+	if keys := gw.redisKeys(replayInputKeyPrefix); len(keys) != 1 {
+		t.Fatalf("expected the input retained in Redis, got %v", keys)
+	}
+	deadline := time.Now().Add(2 * time.Second)
+	for len(gw.redisKeys("ai:summary:")) == 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond) // cache writes are asynchronous
+	}
+
+	h := gw.Server.Config.Handler
+	gw.AI.SetReply("Something else entirely.")
+	out := replay(t, h, receipt.Receipt.ID)
+	if out.Match || out.Original.Result == "" || out.Original.Result == out.Replay.Result {
+		t.Errorf("expected the cached original next to the differing replay, got %+v", out)
+	}
+}
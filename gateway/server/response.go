@@ -0,0 +1,46 @@
+package server
+
+import (
+	"strconv"
+
+	"gateway/ratelimit"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Response helpers. Headers that clients rely on across every response path
+// (rate limits, price) are written here so 402 challenges, cache hits and
+// fresh AI responses all carry them the same way.
+
+// setRateLimitHeaders writes X-RateLimit-Limit, -Remaining and -Reset for
+// key's bucket in limiter, which allows limit requests per minute.
+func setRateLimitHeaders(c *gin.Context, limiter ratelimit.RateLimiter, limit int, key string, remaining int) {
+	c.Header("X-RateLimit-Limit", strconv.Itoa(limit))
+	c.Header("X-RateLimit-Remaining", strconv.Itoa(remaining))
+	c.Header("X-RateLimit-Reset", strconv.FormatInt(limiter.GetResetTime(key), 10))
+}
+
+// setPriceHeader writes X-402-Price, the amount (in token units) this
+// request costs or was charged.
+func setPriceHeader(c *gin.Context, price string) {
+	c.Header("X-402-Price", price)
+}
+
+// respondPaymentRequired aborts with a 402 challenge for price: the
+// X-402-Price header and a body offering a fresh signed payment context per
+// accepted chain in "accepts", with the primary chain's as "paymentContext".
+// Offers payable with the x402 exact scheme also carry its requirements.
+// e replaces the default PAYMENT_REQUIRED error and keeps the default
+// message when it has none; nil sends the default.
+func respondPaymentRequired(c *gin.Context, price string, e *APIError) {
+	if e == nil {
+		e = newAPIError(CodePaymentRequired, "")
+	}
+	if e.Message == "" {
+		e.Message = "Please sign the payment context"
+	}
+	contexts := challengeContexts(c, price)
+	e.with(gin.H{"x402Version": x402Version, "paymentContext": contexts[0], "accepts": paymentOffers(c, contexts)})
+	setPriceHeader(c, price)
+	abortWithAPIError(c, e)
+}
@@ -0,0 +1,64 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestResponseHeaders_OnEveryPath(t *testing.T) {
+	gw := startGateway(t, map[string]string{
+		"RATE_LIMIT_ENABLED":        "true",
+		"RATE_LIMIT_STANDARD_RPM":   "600",
+		"RATE_LIMIT_STANDARD_BURST": "100",
+		"PAYMENT_AMOUNT":            "0.003",
+	})
+
+	paths := map[string]*http.Response{
+		"402 challenge": gw.Post(t, "/api/ai/summarize", `{"text":"headers"}`, "", ""),
+		"paid":          gw.Post(t, "/api/ai/summarize", `{"text":"headers"}`, "0xsig", "nonce-1"),
+	}
+	// Wait for the async cache write, then hit the cache.
+	deadline := time.Now().Add(2 * time.Second)
+	for len(gw.redisKeys("ai:summary:")) == 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	paths["cache hit"] = gw.Post(t, "/api/ai/summarize", `{"text":"headers"}`, "0xsig", "nonce-2")
+	if gw.AI.Calls() != 1 {
+		t.Fatalf("expected the third request to be served from cache, got %d AI calls", gw.AI.Calls())
+	}
+
+	for name, resp := range paths {
+		for _, h := range []string{"X-RateLimit-Limit", "X-RateLimit-Remaining", "X-RateLimit-Reset"} {
+			if resp.Header.Get(h) == "" {
+				t.Errorf("%s: missing %s", name, h)
+			}
+		}
+		if got := resp.Header.Get("X-402-Price"); got != "0.003" {
+			t.Errorf("%s: expected X-402-Price 0.003, got %q", name, got)
+		}
+	}
+}
+
+func TestRespondPaymentRequired_Overrides(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	respondPaymentRequired(c, "0.005", newAPIError(CodeQuoteExpired, "").with(gin.H{"quote": "q"}))
+
+	var body map[string]interface{}
+	_ = json.Unmarshal(w.Body.Bytes(), &body)
+	if w.Code != http.StatusPaymentRequired || w.Header().Get("X-402-Price") != "0.005" {
+		t.Fatalf("expected 402 with X-402-Price, got %d %q", w.Code, w.Header().Get("X-402-Price"))
+	}
+	if body["code"] != "QUOTE_EXPIRED" || body["error"] != "Quote Expired" || body["message"] != "Please sign the payment context" || body["quote"] != "q" {
+		t.Errorf("unexpected body %v", body)
+	}
+	if ctx, _ := body["paymentContext"].(map[string]interface{}); ctx["amount"] != "0.005" {
+		t.Errorf("paymentContext should quote the price, got %v", body["paymentContext"])
+	}
+}
@@ -0,0 +1,63 @@
+package server
+
+import (
+	"fmt"
+	"os"
+	"strings"
+)
+
+// Response buffer overflow modes.
+const (
+	// bufferOverflowSpill moves the response to a temporary file once it
+	// outgrows the in-memory limit.
+	bufferOverflowSpill = "spill"
+	// bufferOverflowAbort cancels the request and answers 500 Response Too
+	// Large.
+	bufferOverflowAbort = "abort"
+)
+
+// ResponseBufferConfig bounds the memory RequestTimeoutMiddleware uses to
+// hold a response until the handler finishes. MaxBytes of zero buffers
+// without limit. Responses to StreamingRoutes, patterns as in
+// ROUTE_TIMEOUTS, are written straight through instead.
+type ResponseBufferConfig struct {
+	MaxBytes        int64
+	Overflow        string
+	SpillDir        string
+	StreamingRoutes []string
+}
+
+// loadResponseBufferConfig reads RESPONSE_BUFFER_MAX_BYTES (default 8MB),
+// RESPONSE_BUFFER_OVERFLOW, RESPONSE_BUFFER_SPILL_DIR and STREAMING_ROUTES
+// (default the streamed receipt export).
+func loadResponseBufferConfig() (ResponseBufferConfig, error) {
+	rb := ResponseBufferConfig{
+		MaxBytes:        int64(getEnvAsInt("RESPONSE_BUFFER_MAX_BYTES", 8<<20)),
+		Overflow:        strings.ToLower(getEnv("RESPONSE_BUFFER_OVERFLOW", bufferOverflowSpill)),
+		SpillDir:        getEnv("RESPONSE_BUFFER_SPILL_DIR", os.TempDir()),
+		StreamingRoutes: getEnvAsList("STREAMING_ROUTES", []string{"/api/admin/receipts/export"}),
+	}
+	if rb.MaxBytes < 0 {
+		return rb, fmt.Errorf("RESPONSE_BUFFER_MAX_BYTES must not be negative")
+	}
+	if rb.Overflow != bufferOverflowSpill && rb.Overflow != bufferOverflowAbort {
+		return rb, fmt.Errorf("RESPONSE_BUFFER_OVERFLOW must be %s or %s, got %q", bufferOverflowSpill, bufferOverflowAbort, rb.Overflow)
+	}
+	for _, pattern := range rb.StreamingRoutes {
+		if err := checkRoutePattern(pattern); err != nil {
+			return rb, fmt.Errorf("STREAMING_ROUTES: %w", err)
+		}
+	}
+	return rb, nil
+}
+
+// IsStreaming reports whether responses to path, served by the gin route
+// fullPath, bypass the buffer.
+func (rb ResponseBufferConfig) IsStreaming(fullPath, path string) bool {
+	for _, pattern := range rb.StreamingRoutes {
+		if matchRoutePattern(pattern, fullPath, path) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,132 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// bigResponseRouter serves GET /big, writing size bytes in 100 byte chunks,
+// behind RequestTimeoutMiddleware. handlerDone receives the handler's
+// context error when it returns.
+func bigResponseRouter(size int, handlerDone chan<- error) *gin.Engine {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.Use(RequestTimeoutMiddleware(5 * time.Second))
+	r.GET("/big", func(c *gin.Context) {
+		c.Status(200)
+		chunk := strings.Repeat("x", 100)
+		for written := 0; written < size; written += len(chunk) {
+			if _, err := c.Writer.WriteString(chunk); err != nil {
+				break
+			}
+		}
+		if handlerDone != nil {
+			select {
+			case <-c.Request.Context().Done():
+			case <-time.After(time.Second):
+			}
+			handlerDone <- c.Request.Context().Err()
+		}
+	})
+	return r
+}
+
+func TestResponseBuffer_SpillsToDisk(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv("RESPONSE_BUFFER_MAX_BYTES", "250")
+	t.Setenv("RESPONSE_BUFFER_SPILL_DIR", dir)
+
+	w := httptest.NewRecorder()
+	bigResponseRouter(10000, nil).ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/big", nil))
+	if w.Code != 200 || w.Body.Len() != 10000 || strings.Trim(w.Body.String(), "x") != "" {
+		t.Fatalf("expected the full 10000 byte response, got %d with %d bytes", w.Code, w.Body.Len())
+	}
+	if entries, _ := os.ReadDir(dir); len(entries) != 0 {
+		t.Errorf("expected the spill file to be removed, found %d entries", len(entries))
+	}
+}
+
+func TestResponseBuffer_AbortsOverLimit(t *testing.T) {
+	t.Setenv("RESPONSE_BUFFER_MAX_BYTES", "250")
+	t.Setenv("RESPONSE_BUFFER_OVERFLOW", "abort")
+	handlerDone := make(chan error, 1)
+
+	w := httptest.NewRecorder()
+	bigResponseRouter(10000, handlerDone).ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/big", nil))
+	if w.Code != http.StatusInternalServerError || !strings.Contains(w.Body.String(), `"code":"RESPONSE_TOO_LARGE"`) {
+		t.Fatalf("expected 500 RESPONSE_TOO_LARGE, got %d: %s", w.Code, w.Body.String())
+	}
+	if strings.Contains(w.Body.String(), "xxx") {
+		t.Error("expected none of the oversized response to be sent")
+	}
+	if err := <-handlerDone; err == nil {
+		t.Error("expected the handler's context to be cancelled")
+	}
+
+	w = httptest.NewRecorder()
+	bigResponseRouter(200, nil).ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/big", nil))
+	if w.Code != 200 || w.Body.Len() != 200 {
+		t.Errorf("expected responses under the limit to pass, got %d with %d bytes", w.Code, w.Body.Len())
+	}
+}
+
+func TestResponseBuffer_StreamingRoutesBypassBuffer(t *testing.T) {
+	t.Setenv("STREAMING_ROUTES", "/stream/*")
+	t.Setenv("RESPONSE_BUFFER_MAX_BYTES", "10")
+	t.Setenv("RESPONSE_BUFFER_OVERFLOW", "abort")
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.Use(RequestTimeoutMiddleware(100 * time.Millisecond))
+	r.GET("/stream/events", func(c *gin.Context) {
+		c.Writer.WriteString("data: one\n\n")
+		c.Writer.Flush()
+		c.Writer.WriteString("data: two\n\n")
+	})
+	r.GET("/stream/slow", func(c *gin.Context) {
+		<-c.Request.Context().Done()
+	})
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/stream/events", nil))
+	if w.Code != 200 || !w.Flushed || w.Body.String() != "data: one\n\ndata: two\n\n" {
+		t.Errorf("expected an unbuffered, flushed stream, got %d flushed=%v %q", w.Code, w.Flushed, w.Body.String())
+	}
+
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/stream/slow", nil))
+	if w.Code != http.StatusGatewayTimeout {
+		t.Errorf("expected 504 for a stream that never started, got %d", w.Code)
+	}
+}
+
+func TestLoadResponseBufferConfig(t *testing.T) {
+	rb, err := loadResponseBufferConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rb.MaxBytes != 8<<20 || rb.Overflow != bufferOverflowSpill || !rb.IsStreaming("/api/admin/receipts/export", "/api/admin/receipts/export") {
+		t.Errorf("unexpected defaults %+v", rb)
+	}
+	if rb.IsStreaming("/api/ai/summarize", "/api/ai/summarize") {
+		t.Error("expected summarize to be buffered")
+	}
+
+	for key, value := range map[string]string{
+		"RESPONSE_BUFFER_MAX_BYTES": "-1",
+		"RESPONSE_BUFFER_OVERFLOW":  "truncate",
+		"STREAMING_ROUTES":          "/api/*/stream",
+	} {
+		t.Run(key, func(t *testing.T) {
+			t.Setenv(key, value)
+			if err := loadConfig().Validate(); err == nil {
+				t.Errorf("expected %s=%s to fail validation", key, value)
+			}
+		})
+	}
+}
@@ -0,0 +1,272 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"gateway/receipts"
+
+	"github.com/gin-gonic/gin"
+	"github.com/redis/go-redis/v9"
+)
+
+// Receipt statuses reported by the lookup and verify endpoints. Consumers
+// should only honor a receipt whose status is valid.
+const (
+	receiptStatusValid    = "valid"
+	receiptStatusRevoked  = "revoked"
+	receiptStatusDisputed = "disputed"
+	receiptStatusInvalid  = "invalid"
+)
+
+// revocationsKey is the Redis hash of revocations keyed by receipt ID.
+const revocationsKey = "receipt:revocations"
+
+// Revocation marks a receipt that must no longer be honored.
+type Revocation struct {
+	ReceiptID string    `json:"receipt_id"`
+	Status    string    `json:"status"`
+	Reason    string    `json:"reason"`
+	RevokedAt time.Time `json:"revoked_at"`
+}
+
+var (
+	revocationsMu sync.RWMutex
+	revocations   = make(map[string]Revocation)
+)
+
+// revokeReceipt records rev, replacing any earlier revocation of the same
+// receipt. Revocations are kept in Redis (without expiry) when it is
+// connected so the list survives restarts and is shared across replicas;
+// the in-memory fallback is lost with the process.
+func revokeReceipt(ctx context.Context, rev Revocation) error {
+	if redisClient != nil {
+		data, err := json.Marshal(rev)
+		if err != nil {
+			return err
+		}
+		if err := redisClient.HSet(ctx, revocationsKey, rev.ReceiptID, data).Err(); err != nil {
+			return fmt.Errorf("failed to store revocation: %w", err)
+		}
+		return nil
+	}
+
+	revocationsMu.Lock()
+	defer revocationsMu.Unlock()
+	revocations[rev.ReceiptID] = rev
+	return nil
+}
+
+// getRevocation returns the revocation of receipt id, if any.
+func getRevocation(ctx context.Context, id string) (*Revocation, error) {
+	if redisClient != nil {
+		data, err := redisClient.HGet(ctx, revocationsKey, id).Result()
+		if errors.Is(err, redis.Nil) {
+			return nil, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to load revocation: %w", err)
+		}
+		var rev Revocation
+		if err := json.Unmarshal([]byte(data), &rev); err != nil {
+			return nil, fmt.Errorf("invalid revocation for %s: %w", id, err)
+		}
+		return &rev, nil
+	}
+
+	revocationsMu.RLock()
+	defer revocationsMu.RUnlock()
+	if rev, ok := revocations[id]; ok {
+		return &rev, nil
+	}
+	return nil, nil
+}
+
+// listRevocations returns every revocation, oldest first.
+func listRevocations(ctx context.Context) ([]Revocation, error) {
+	var list []Revocation
+	if redisClient != nil {
+		all, err := redisClient.HGetAll(ctx, revocationsKey).Result()
+		if err != nil {
+			return nil, fmt.Errorf("failed to load revocations: %w", err)
+		}
+		for id, data := range all {
+			var rev Revocation
+			if err := json.Unmarshal([]byte(data), &rev); err != nil {
+				log.Printf("[WARNING] Skipping invalid revocation for %s: %v", id, err)
+				continue
+			}
+			list = append(list, rev)
+		}
+	} else {
+		revocationsMu.RLock()
+		for _, rev := range revocations {
+			list = append(list, rev)
+		}
+		revocationsMu.RUnlock()
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if !list[i].RevokedAt.Equal(list[j].RevokedAt) {
+			return list[i].RevokedAt.Before(list[j].RevokedAt)
+		}
+		return list[i].ReceiptID < list[j].ReceiptID
+	})
+	return list, nil
+}
+
+// receiptStatus returns the status to report for receipt id, with its
+// revocation when it has one.
+func receiptStatus(ctx context.Context, id string) (string, *Revocation, error) {
+	rev, err := getRevocation(ctx, id)
+	if err != nil || rev == nil {
+		return receiptStatusValid, nil, err
+	}
+	return rev.Status, rev, nil
+}
+
+// revocationBody is the revocation detail added to receipt responses.
+func revocationBody(rev *Revocation) gin.H {
+	return gin.H{"reason": rev.Reason, "revoked_at": rev.RevokedAt}
+}
+
+// handleRevokeReceipt handles POST /api/admin/receipts/:id/revoke. The body
+// is {"reason": "...", "status": "revoked"|"disputed"}; status defaults to
+// revoked. The receipt does not need to still be in the store, since
+// consumers keep receipts longer than RECEIPT_TTL.
+func handleRevokeReceipt(c *gin.Context) {
+	id := c.Param("id")
+	if !strings.HasPrefix(id, "rcpt_") {
+		respondError(c, CodeInvalidRequest, "receipt ID must start with 'rcpt_'")
+		return
+	}
+	var req struct {
+		Reason string `json:"reason"`
+		Status string `json:"status"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		respondError(c, CodeInvalidRequest, "Request must be valid JSON")
+		return
+	}
+	req.Reason = strings.TrimSpace(req.Reason)
+	if req.Reason == "" {
+		respondError(c, CodeInvalidRequest, "reason is required")
+		return
+	}
+	switch req.Status {
+	case "":
+		req.Status = receiptStatusRevoked
+	case receiptStatusRevoked, receiptStatusDisputed:
+	default:
+		respondError(c, CodeInvalidRequest, "status must be revoked or disputed")
+		return
+	}
+
+	rev := Revocation{ReceiptID: id, Status: req.Status, Reason: req.Reason, RevokedAt: time.Now().UTC()}
+	if err := revokeReceipt(c.Request.Context(), rev); err != nil {
+		log.Printf("[ERROR] Failed to revoke receipt %s: %v", id, err)
+		respondError(c, CodeServiceUnavailable, "Revocation list is unavailable")
+		return
+	}
+	log.Printf("Receipt %s marked %s: %s", id, rev.Status, rev.Reason)
+	c.JSON(200, gin.H{"revocation": rev})
+}
+
+// handleListRevocations handles GET /api/admin/receipts/revocations.
+func handleListRevocations(c *gin.Context) {
+	list, err := listRevocations(c.Request.Context())
+	if err != nil {
+		log.Printf("[ERROR] Failed to list revocations: %v", err)
+		respondError(c, CodeServiceUnavailable, "Revocation list is unavailable")
+		return
+	}
+	if list == nil {
+		list = []Revocation{}
+	}
+	c.JSON(200, gin.H{"revocations": list, "count": len(list)})
+}
+
+// handleVerifyReceipt handles POST /api/receipts/verify. The body is a receipt
+// as issued in X-402-Receipt: a JSON SignedReceipt, a compact JWS
+// (application/jose) or COSE_Sign1 (application/cose). It checks the receipt
+// was signed by this gateway and reports its revocation status; a well-formed
+// but unacceptable receipt is a 200 with valid false. Receipts of every
+// supported schema version verify; a verified receipt is returned in the
+// version negotiated through X-402-Receipt-Version.
+func handleVerifyReceipt(c *gin.Context) {
+	version, ok := negotiateReceiptVersion(c)
+	if !ok {
+		return
+	}
+	signer, err := getServerSigner()
+	if err != nil {
+		respondError(c, CodeServiceUnavailable, "Receipt signing key is not configured")
+		return
+	}
+	body, err := io.ReadAll(http.MaxBytesReader(c.Writer, c.Request.Body, 64*1024))
+	if err != nil || len(body) == 0 {
+		respondError(c, CodeInvalidRequest, "Request body must contain a receipt")
+		return
+	}
+
+	var receipt *Receipt
+	var verifyErr error
+	mediaType, _, _ := mime.ParseMediaType(c.GetHeader("Content-Type"))
+	switch mediaType {
+	case receiptMediaTypes[receiptFormatJWS]:
+		receipt, verifyErr = receipts.VerifyJWS(strings.TrimSpace(string(body)), signer.Public())
+	case receiptMediaTypes[receiptFormatCOSE]:
+		receipt, verifyErr = receipts.VerifyCOSE(body, signer.Public())
+	default:
+		var signed SignedReceipt
+		if err := json.Unmarshal(body, &signed); err != nil {
+			respondError(c, CodeInvalidRequest, "Receipt must be a JSON signed receipt, JWS or COSE")
+			return
+		}
+		receipt = &signed.Receipt
+		verifyErr = receipts.Verify(&signed, signer.Public())
+		if migrated, err := receipts.Migrate(&signed); err == nil {
+			receipt = &migrated.Receipt
+		}
+	}
+	id := ""
+	if receipt != nil {
+		id = receipt.ID
+		if verifyErr == nil && !receipts.IsSupported(receipt.Version) {
+			verifyErr = fmt.Errorf("unsupported receipt version %q", receipt.Version)
+		}
+	}
+	if verifyErr != nil {
+		c.JSON(200, gin.H{"valid": false, "status": receiptStatusInvalid, "receipt_id": id, "message": verifyErr.Error(),
+			"supported_versions": receipts.SupportedVersions})
+		return
+	}
+	shaped, err := receipts.As(*receipt, version)
+	if err != nil {
+		respondError(c, CodeInternal, "Failed to encode receipt")
+		return
+	}
+
+	status, rev, err := receiptStatus(c.Request.Context(), id)
+	if err != nil {
+		log.Printf("[ERROR] Failed to check revocation of %s: %v", id, err)
+		respondError(c, CodeServiceUnavailable, "Revocation list is unavailable")
+		return
+	}
+	resp := gin.H{"valid": rev == nil, "status": status, "receipt_id": id,
+		"version": receipt.Version, "supported_versions": receipts.SupportedVersions, "receipt": shaped}
+	if rev != nil {
+		resp["revocation"] = revocationBody(rev)
+	}
+	c.Header(receiptVersionHeader, version)
+	c.JSON(200, resp)
+}
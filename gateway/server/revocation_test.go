@@ -0,0 +1,189 @@
+package server
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"gateway/internal/testsupport"
+)
+
+// withRevocations installs an empty in-memory revocation list for the test.
+func withRevocations(t *testing.T) {
+	t.Helper()
+	revocationsMu.Lock()
+	prev := revocations
+	revocations = make(map[string]Revocation)
+	revocationsMu.Unlock()
+	t.Cleanup(func() {
+		revocationsMu.Lock()
+		revocations = prev
+		revocationsMu.Unlock()
+	})
+}
+
+func adminPost(t *testing.T, h http.Handler, path, token, body string) *httptest.ResponseRecorder {
+	t.Helper()
+	req := httptest.NewRequest(http.MethodPost, path, bytes.NewBufferString(body))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+token)
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	return w
+}
+
+// paidReceipt makes a paid summarize request and returns its receipt header.
+func paidReceipt(t *testing.T, h *testsupport.Harness, nonce string) string {
+	t.Helper()
+	resp := h.Post(t, "/api/ai/summarize", `{"text":"hello"}`, "0xsig", nonce)
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected 200, got %d", resp.StatusCode)
+	}
+	return resp.Header.Get("X-402-Receipt")
+}
+
+// verifyReceiptBody posts body to the receipt verify endpoint.
+func verifyReceiptBody(t *testing.T, h *testsupport.Harness, contentType string, body []byte) map[string]interface{} {
+	t.Helper()
+	resp, err := h.Server.Client().Post(h.Server.URL+"/api/receipts/verify", contentType, bytes.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("verify: expected 200, got %d", resp.StatusCode)
+	}
+	var out map[string]interface{}
+	if err := json.NewDecoder(resp.Body).Decode(&out); err != nil {
+		t.Fatal(err)
+	}
+	return out
+}
+
+func TestRevocation_LookupAndVerifyReportRevokedStatus(t *testing.T) {
+	withRevocations(t)
+	t.Setenv("ADMIN_API_KEY", "s3cret")
+	h := testsupport.NewHarness(t, newTestRouter)
+	header := paidReceipt(t, h, "nonce-rev")
+	receipt := decodeReceiptHeader(t, header)
+	raw, _ := base64.StdEncoding.DecodeString(header)
+
+	if out := verifyReceiptBody(t, h, "application/json", raw); out["valid"] != true || out["status"] != receiptStatusValid {
+		t.Fatalf("expected an unrevoked receipt to verify, got %v", out)
+	}
+
+	w := adminPost(t, newTestRouter(), "/api/admin/receipts/"+receipt.Receipt.ID+"/revoke", "s3cret", `{"reason":"chargeback"}`)
+	if w.Code != http.StatusOK {
+		t.Fatalf("revoke: expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+
+	resp := h.Get(t, "/api/receipts/"+receipt.Receipt.ID)
+	var lookup struct {
+		Status     string `json:"status"`
+		Revocation struct {
+			Reason string `json:"reason"`
+		} `json:"revocation"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&lookup); err != nil {
+		t.Fatal(err)
+	}
+	if lookup.Status != receiptStatusRevoked || lookup.Revocation.Reason != "chargeback" {
+		t.Errorf("expected lookup to report revoked with reason, got %+v", lookup)
+	}
+	if got := resp.Header.Get("X-402-Receipt-Status"); got != receiptStatusRevoked {
+		t.Errorf("expected X-402-Receipt-Status revoked, got %q", got)
+	}
+
+	out := verifyReceiptBody(t, h, "application/json", raw)
+	if out["valid"] != false || out["status"] != receiptStatusRevoked {
+		t.Errorf("expected verify to report revoked, got %v", out)
+	}
+	if rev, _ := out["revocation"].(map[string]interface{}); rev["reason"] != "chargeback" {
+		t.Errorf("expected the revocation reason, got %v", out["revocation"])
+	}
+}
+
+func TestRevocation_DisputedJWSReceipt(t *testing.T) {
+	withRevocations(t)
+	t.Setenv("ADMIN_API_KEY", "s3cret")
+	h := testsupport.NewHarness(t, newTestRouter)
+	receipt := decodeReceiptHeader(t, paidReceipt(t, h, "nonce-jws"))
+
+	w := adminPost(t, newTestRouter(), "/api/admin/receipts/"+receipt.Receipt.ID+"/revoke", "s3cret", `{"reason":"under review","status":"disputed"}`)
+	if w.Code != http.StatusOK {
+		t.Fatalf("revoke: expected 200, got %d", w.Code)
+	}
+
+	resp := h.Get(t, "/api/receipts/"+receipt.Receipt.ID+"?receipt_format=jws")
+	if got := resp.Header.Get("X-402-Receipt-Status"); got != receiptStatusDisputed {
+		t.Errorf("expected X-402-Receipt-Status disputed, got %q", got)
+	}
+	var token bytes.Buffer
+	token.ReadFrom(resp.Body)
+
+	out := verifyReceiptBody(t, h, "application/jose", token.Bytes())
+	if out["valid"] != false || out["status"] != receiptStatusDisputed || out["receipt_id"] != receipt.Receipt.ID {
+		t.Errorf("expected a disputed JWS receipt, got %v", out)
+	}
+}
+
+func TestRevocation_VerifyRejectsTamperedReceipt(t *testing.T) {
+	withRevocations(t)
+	h := testsupport.NewHarness(t, newTestRouter)
+	receipt := decodeReceiptHeader(t, paidReceipt(t, h, "nonce-tamper"))
+
+	receipt.Receipt.Payment.Amount = "0"
+	raw, _ := json.Marshal(receipt)
+	if out := verifyReceiptBody(t, h, "application/json", raw); out["valid"] != false || out["status"] != receiptStatusInvalid {
+		t.Errorf("expected a tampered receipt to be invalid, got %v", out)
+	}
+}
+
+func TestRevocation_AdminValidation(t *testing.T) {
+	withRevocations(t)
+	t.Setenv("ADMIN_API_KEY", "s3cret")
+	r := newTestRouter()
+
+	cases := map[string]struct{ path, body string }{
+		"bad id":         {"/api/admin/receipts/nope/revoke", `{"reason":"x"}`},
+		"missing reason": {"/api/admin/receipts/rcpt_000000000001/revoke", `{"reason":"  "}`},
+		"bad status":     {"/api/admin/receipts/rcpt_000000000001/revoke", `{"reason":"x","status":"refunded"}`},
+	}
+	for name, tc := range cases {
+		if w := adminPost(t, r, tc.path, "s3cret", tc.body); w.Code != http.StatusBadRequest {
+			t.Errorf("%s: expected 400, got %d", name, w.Code)
+		}
+	}
+	if w := adminPost(t, r, "/api/admin/receipts/rcpt_000000000001/revoke", "wrong", `{"reason":"x"}`); w.Code != http.StatusUnauthorized {
+		t.Errorf("expected 401 without the admin token, got %d", w.Code)
+	}
+}
+
+func TestRevocation_PersistedInRedis(t *testing.T) {
+	withRevocations(t)
+	gw := startGateway(t, map[string]string{"ADMIN_API_KEY": "s3cret"})
+
+	for _, id := range []string{"rcpt_000000000002", "rcpt_000000000001"} {
+		if w := adminPost(t, gw.Server.Config.Handler, "/api/admin/receipts/"+id+"/revoke", "s3cret", `{"reason":"fraud"}`); w.Code != http.StatusOK {
+			t.Fatalf("revoke %s: expected 200, got %d", id, w.Code)
+		}
+	}
+	if len(revocations) != 0 {
+		t.Error("expected revocations to be stored in Redis, not in memory")
+	}
+
+	w := adminGet(t, gw.Server.Config.Handler, "/api/admin/receipts/revocations", "s3cret")
+	var list struct {
+		Revocations []Revocation `json:"revocations"`
+		Count       int          `json:"count"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &list); err != nil {
+		t.Fatal(err)
+	}
+	if list.Count != 2 || list.Revocations[0].Reason != "fraud" || list.Revocations[0].RevokedAt.After(list.Revocations[1].RevokedAt) {
+		t.Errorf("expected both revocations oldest first, got %+v", list)
+	}
+}
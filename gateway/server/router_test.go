@@ -0,0 +1,206 @@
+package server
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+
+	"gateway/client"
+	"gateway/internal/testsupport"
+	"gateway/receipts"
+
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/gin-gonic/gin"
+)
+
+func newTestRouter() http.Handler {
+	gin.SetMode(gin.TestMode)
+	// Tests reuse nonces across cases, so each router starts with none spent.
+	spentNoncesMu.Lock()
+	clear(spentNonces)
+	spentNoncesMu.Unlock()
+	// Spend caps start from zero for the same reason.
+	localSpendStore.mu.Lock()
+	clear(localSpendStore.totals)
+	clear(localSpendStore.expires)
+	localSpendStore.mu.Unlock()
+	return setupRouter()
+}
+
+func TestRouter_PaymentFlowWithMocks(t *testing.T) {
+	h := testsupport.NewHarness(t, newTestRouter)
+	h.AI.SetReply("Mocked summary.")
+
+	// Unsigned request gets a payment challenge without touching dependencies.
+	resp := h.Post(t, "/api/ai/summarize", `{"text":"hello"}`, "", "")
+	if resp.StatusCode != http.StatusPaymentRequired {
+		t.Fatalf("expected 402, got %d", resp.StatusCode)
+	}
+	if h.Verifier.Calls() != 0 || h.AI.Calls() != 0 {
+		t.Fatal("402 challenge should not call verifier or AI")
+	}
+
+	// Signed request is verified, summarized and receipted.
+	resp = h.Post(t, "/api/ai/summarize", `{"text":"hello"}`, "0xsig", "nonce-1")
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected 200, got %d", resp.StatusCode)
+	}
+	var body map[string]string
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		t.Fatalf("decode body: %v", err)
+	}
+	if body["result"] != "Mocked summary." {
+		t.Errorf("expected mocked summary, got %q", body["result"])
+	}
+	if got := h.Verifier.Requests()[0].Context.Nonce; got != "nonce-1" {
+		t.Errorf("expected verifier to see nonce-1, got %q", got)
+	}
+
+	receiptJSON, err := base64.StdEncoding.DecodeString(resp.Header.Get("X-402-Receipt"))
+	if err != nil {
+		t.Fatalf("decode receipt header: %v", err)
+	}
+	var receipt SignedReceipt
+	if err := json.Unmarshal(receiptJSON, &receipt); err != nil {
+		t.Fatalf("unmarshal receipt: %v", err)
+	}
+	if receipt.Receipt.Payment.Payer != testsupport.DefaultPayer {
+		t.Errorf("expected payer %s, got %s", testsupport.DefaultPayer, receipt.Receipt.Payment.Payer)
+	}
+
+	resp = h.Get(t, "/api/receipts/"+receipt.Receipt.ID)
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("expected stored receipt to be retrievable, got %d", resp.StatusCode)
+	}
+}
+
+func TestRouter_InvalidSignatureRejected(t *testing.T) {
+	h := testsupport.NewHarness(t, newTestRouter)
+	h.Verifier.SetInvalid("signature mismatch")
+
+	resp := h.Post(t, "/api/ai/summarize", `{"text":"hello"}`, "0xbad", "nonce-2")
+	if resp.StatusCode != http.StatusForbidden {
+		t.Fatalf("expected 403, got %d", resp.StatusCode)
+	}
+	if h.AI.Calls() != 0 {
+		t.Error("AI provider should not be called for an invalid signature")
+	}
+}
+
+func TestRouter_VerifierOutageReturns500(t *testing.T) {
+	h := testsupport.NewHarness(t, newTestRouter)
+	h.Verifier.SetStatus(http.StatusInternalServerError)
+
+	resp := h.Post(t, "/api/ai/summarize", `{"text":"hello"}`, "0xsig", "nonce-3")
+	if resp.StatusCode != http.StatusInternalServerError {
+		t.Fatalf("expected 500, got %d", resp.StatusCode)
+	}
+}
+
+func TestRouter_SignsVerifierRequestsWithSharedSecret(t *testing.T) {
+	t.Setenv("VERIFIER_SHARED_SECRET", "s3cret")
+	h := testsupport.NewHarness(t, newTestRouter)
+	h.Verifier.RequireSignature("s3cret")
+
+	for _, nonce := range []string{"nonce-auth-1", "nonce-auth-2"} {
+		if resp := h.Post(t, "/api/ai/summarize", `{"text":"hello"}`, "0xsig", nonce); resp.StatusCode != http.StatusOK {
+			t.Fatalf("expected 200 from an authenticated verifier call, got %d", resp.StatusCode)
+		}
+	}
+
+	t.Setenv("VERIFIER_SHARED_SECRET", "wrong")
+	if resp := h.Post(t, "/api/ai/summarize", `{"text":"hello"}`, "0xsig", "nonce-auth-3"); resp.StatusCode != http.StatusInternalServerError {
+		t.Errorf("expected 500 when the verifier rejects the gateway, got %d", resp.StatusCode)
+	}
+}
+
+func TestRouter_ReceiptSequenceIncrementsPerPayer(t *testing.T) {
+	h := testsupport.NewHarness(t, newTestRouter)
+	h.Verifier.SetValid("0x00000000000000000000000000000000000005e9")
+
+	var last int64
+	for i := 0; i < 2; i++ {
+		resp := h.Post(t, "/api/ai/summarize", `{"text":"hello"}`, "0xsig", fmt.Sprintf("nonce-seq-%d", i))
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("expected 200, got %d", resp.StatusCode)
+		}
+		receiptJSON, err := base64.StdEncoding.DecodeString(resp.Header.Get("X-402-Receipt"))
+		if err != nil {
+			t.Fatalf("decode receipt header: %v", err)
+		}
+		var receipt SignedReceipt
+		if err := json.Unmarshal(receiptJSON, &receipt); err != nil {
+			t.Fatalf("unmarshal receipt: %v", err)
+		}
+		seq := receipt.Receipt.Payment.Sequence
+		if seq == 0 || (last != 0 && seq != last+1) {
+			t.Errorf("expected consecutive sequence after %d, got %d", last, seq)
+		}
+		last = seq
+	}
+}
+
+func TestRouter_ReceiptFormats(t *testing.T) {
+	h := testsupport.NewHarness(t, newTestRouter)
+	key, err := crypto.HexToECDSA(strings.TrimPrefix(testsupport.TestPrivateKey, "0x"))
+	if err != nil {
+		t.Fatalf("parse test key: %v", err)
+	}
+
+	resp := h.Post(t, "/api/ai/summarize?receipt_format=jws", `{"text":"hello"}`, "0xsig", "nonce")
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected 200, got %d", resp.StatusCode)
+	}
+	if got := resp.Header.Get("X-402-Receipt-Format"); got != "jws" {
+		t.Errorf("expected jws receipt format, got %q", got)
+	}
+	receipt, err := receipts.VerifyJWS(resp.Header.Get("X-402-Receipt"), &key.PublicKey)
+	if err != nil {
+		t.Fatalf("VerifyJWS failed: %v", err)
+	}
+
+	resp = h.Get(t, "/api/receipts/"+receipt.ID+"?receipt_format=cose")
+	if ct := resp.Header.Get("Content-Type"); ct != "application/cose" {
+		t.Fatalf("expected application/cose, got %q", ct)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	fromStore, err := receipts.VerifyCOSE(body, &key.PublicKey)
+	if err != nil {
+		t.Fatalf("VerifyCOSE failed: %v", err)
+	}
+	if fromStore.ID != receipt.ID {
+		t.Errorf("expected stored receipt %s, got %s", receipt.ID, fromStore.ID)
+	}
+
+	if resp := h.Get(t, "/api/receipts/"+receipt.ID+"?receipt_format=xml"); resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("expected 400 for unknown format, got %d", resp.StatusCode)
+	}
+}
+
+func TestRouter_GoClientCompletesPaymentFlow(t *testing.T) {
+	h := testsupport.NewHarness(t, newTestRouter)
+	h.AI.SetReply("Client summary.")
+
+	payerKey, err := crypto.GenerateKey()
+	if err != nil {
+		t.Fatalf("GenerateKey failed: %v", err)
+	}
+	c := client.New(h.Server.URL, payerKey)
+	h.Verifier.SetValid(c.Address())
+
+	summary, receipt, err := c.Summarize(context.Background(), "hello")
+	if err != nil {
+		t.Fatalf("Summarize failed: %v", err)
+	}
+	if summary != "Client summary." {
+		t.Errorf("unexpected summary %q", summary)
+	}
+	if receipt.Receipt.Payment.Nonce != h.Verifier.Requests()[0].Context.Nonce {
+		t.Error("expected receipt to cover the signed nonce")
+	}
+}
@@ -0,0 +1,128 @@
+package server
+
+import (
+	"cmp"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// RouteTimeout bounds requests whose path matches Pattern: an exact request
+// path or gin route (such as /api/ai/jobs/:id), or a prefix ending in "*".
+type RouteTimeout struct {
+	Pattern string
+	Timeout time.Duration
+}
+
+// prefix returns the path prefix of a wildcard pattern.
+func (rt RouteTimeout) prefix() (string, bool) {
+	return strings.CutSuffix(rt.Pattern, "*")
+}
+
+// RouteTimeoutConfig maps routes to request timeouts. Routes holds the most
+// specific patterns first; requests matching none get Default.
+type RouteTimeoutConfig struct {
+	Default time.Duration
+	Routes  []RouteTimeout
+}
+
+// loadRouteTimeouts builds the route timeout table: REQUEST_TIMEOUT_SECONDS
+// as the default, AI_REQUEST_TIMEOUT_SECONDS for both AI route groups, then
+// the ROUTE_TIMEOUTS entries, which add routes or override those.
+func loadRouteTimeouts() (RouteTimeoutConfig, error) {
+	ai := getAITimeout()
+	routes := []RouteTimeout{{"/api/ai/*", ai}, {"/api/v2/ai/*", ai}}
+	custom, err := parseRouteTimeouts(getEnv("ROUTE_TIMEOUTS", ""))
+	for _, rt := range custom {
+		if i := slices.IndexFunc(routes, func(r RouteTimeout) bool { return r.Pattern == rt.Pattern }); i >= 0 {
+			routes[i] = rt
+		} else {
+			routes = append(routes, rt)
+		}
+	}
+	// Exact patterns first, then longer prefixes before shorter ones.
+	slices.SortStableFunc(routes, func(a, b RouteTimeout) int {
+		_, aWild := a.prefix()
+		_, bWild := b.prefix()
+		if aWild != bWild {
+			if aWild {
+				return 1
+			}
+			return -1
+		}
+		return cmp.Compare(len(b.Pattern), len(a.Pattern))
+	})
+	return RouteTimeoutConfig{Default: getRequestTimeout(), Routes: routes}, err
+}
+
+// parseRouteTimeouts parses ROUTE_TIMEOUTS, a comma-separated list of
+// "pattern=seconds" entries such as "/api/ai/jobs=5,/api/admin/*=120".
+func parseRouteTimeouts(s string) ([]RouteTimeout, error) {
+	var routes []RouteTimeout
+	for _, entry := range strings.Split(s, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		pattern, seconds, ok := strings.Cut(entry, "=")
+		pattern = strings.TrimSpace(pattern)
+		if !ok {
+			return nil, fmt.Errorf("route timeout %q must be /path=seconds", entry)
+		}
+		if err := checkRoutePattern(pattern); err != nil {
+			return nil, fmt.Errorf("route timeout %q: %w", entry, err)
+		}
+		n, err := strconv.Atoi(strings.TrimSpace(seconds))
+		if err != nil || n <= 0 {
+			return nil, fmt.Errorf("route timeout %q: seconds must be a positive integer", entry)
+		}
+		routes = append(routes, RouteTimeout{Pattern: pattern, Timeout: time.Duration(n) * time.Second})
+	}
+	return routes, nil
+}
+
+// checkRoutePattern rejects route patterns other than an absolute path or
+// gin route with an optional trailing "*".
+func checkRoutePattern(pattern string) error {
+	if !strings.HasPrefix(pattern, "/") {
+		return fmt.Errorf("path %q must start with /", pattern)
+	}
+	if i := strings.Index(pattern, "*"); i >= 0 && i != len(pattern)-1 {
+		return fmt.Errorf("* is only allowed at the end of the path")
+	}
+	return nil
+}
+
+// matchRoutePattern reports whether a request to path, served by the gin
+// route fullPath, matches pattern.
+func matchRoutePattern(pattern, fullPath, path string) bool {
+	if prefix, wild := strings.CutSuffix(pattern, "*"); wild {
+		return strings.HasPrefix(path, prefix)
+	}
+	return pattern == path || pattern == fullPath
+}
+
+// For returns the timeout for a request to path, served by the gin route
+// fullPath ("" when no route matched).
+func (rc RouteTimeoutConfig) For(fullPath, path string) time.Duration {
+	for _, rt := range rc.Routes {
+		if matchRoutePattern(rt.Pattern, fullPath, path) {
+			return rt.Timeout
+		}
+	}
+	return rc.Default
+}
+
+// routeTimeoutMiddleware applies the configured timeout of each request's
+// route, read from the active config so reloads take effect on the next
+// request.
+func routeTimeoutMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		timeout := getConfig().RouteTimeouts.For(c.FullPath(), c.Request.URL.Path)
+		RequestTimeoutMiddleware(timeout)(c)
+	}
+}
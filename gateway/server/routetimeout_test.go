@@ -0,0 +1,76 @@
+package server
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"gateway/internal/testsupport"
+)
+
+func TestParseRouteTimeouts(t *testing.T) {
+	routes, err := parseRouteTimeouts(" /api/ai/jobs=5, /api/admin/*=120 ,")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(routes) != 2 || routes[0] != (RouteTimeout{"/api/ai/jobs", 5 * time.Second}) || routes[1] != (RouteTimeout{"/api/admin/*", 2 * time.Minute}) {
+		t.Errorf("unexpected routes %+v", routes)
+	}
+
+	for _, bad := range []string{"/api/ai/jobs", "api/ai=5", "/api/*/jobs=5", "/api/ai=0", "/api/ai=soon"} {
+		if _, err := parseRouteTimeouts(bad); err == nil {
+			t.Errorf("%q: expected an error", bad)
+		}
+	}
+}
+
+func TestRouteTimeouts_For(t *testing.T) {
+	t.Setenv("REQUEST_TIMEOUT_SECONDS", "60")
+	t.Setenv("AI_REQUEST_TIMEOUT_SECONDS", "30")
+	t.Setenv("ROUTE_TIMEOUTS", "/api/ai/jobs/:id=2,/api/ai/*=45,/api/ai/embed=10,/api/admin/receipts/*=300,/api/admin/*=120")
+	rc, err := loadRouteTimeouts()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		fullPath, path string
+		want           time.Duration
+	}{
+		{"/api/ai/summarize", "/api/ai/summarize", 45 * time.Second},       // overridden AI default
+		{"/api/v2/ai/summarize", "/api/v2/ai/summarize", 30 * time.Second}, // AI default
+		{"/api/ai/embed", "/api/ai/embed", 10 * time.Second},               // exact beats prefix
+		{"/api/ai/jobs/:id", "/api/ai/jobs/job_1", 2 * time.Second},        // gin route pattern
+		{"/api/admin/receipts/export", "/api/admin/receipts/export", 5 * time.Minute},
+		{"/api/admin/stats", "/api/admin/stats", 2 * time.Minute},
+		{"/healthz", "/healthz", time.Minute},
+		{"", "/missing", time.Minute},
+	}
+	for _, tc := range cases {
+		if got := rc.For(tc.fullPath, tc.path); got != tc.want {
+			t.Errorf("%s: expected %v, got %v", tc.path, tc.want, got)
+		}
+	}
+}
+
+func TestRouteTimeouts_InvalidConfigRejected(t *testing.T) {
+	t.Setenv("ROUTE_TIMEOUTS", "/api/ai/summarize=fast")
+	if err := loadConfig().Validate(); err == nil {
+		t.Error("expected an invalid ROUTE_TIMEOUTS to fail validation")
+	}
+}
+
+func TestRouteTimeoutMiddleware_AppliesConfiguredTimeout(t *testing.T) {
+	t.Setenv("ROUTE_TIMEOUTS", "/api/ai/summarize=1")
+	h := testsupport.NewHarness(t, newTestRouter)
+	h.AI.SetDelay(3 * time.Second)
+
+	start := time.Now()
+	resp := h.Post(t, "/api/ai/summarize", `{"text":"slow"}`, "0xsig", "nonce-route-timeout")
+	if resp.StatusCode != http.StatusGatewayTimeout {
+		t.Fatalf("expected 504, got %d", resp.StatusCode)
+	}
+	if elapsed := time.Since(start); elapsed > 2500*time.Millisecond {
+		t.Errorf("expected the 1s route timeout to apply, took %v", elapsed)
+	}
+}
@@ -0,0 +1,181 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// s3Uploader writes and reads objects in S3-compatible storage (AWS S3,
+// MinIO, R2, GCS interoperability...) with path-style requests signed with
+// AWS Signature Version 4.
+type s3Uploader struct {
+	endpoint  string
+	bucket    string
+	region    string
+	accessKey string
+	secretKey string
+	prefix    string
+	timeout   time.Duration
+}
+
+// newS3Uploader returns an uploader for RECEIPT_EXPORT_S3_BUCKET at
+// RECEIPT_EXPORT_S3_ENDPOINT, or nil when async exports are disabled.
+func newS3Uploader() *s3Uploader {
+	return newS3UploaderFromEnv("RECEIPT_EXPORT_S3", "receipt-exports/")
+}
+
+// newS3UploaderFromEnv returns an uploader configured by the <env>_ENDPOINT,
+// _BUCKET, _REGION, _ACCESS_KEY_ID, _SECRET_ACCESS_KEY, _PREFIX and
+// _TIMEOUT_SECONDS variables, or nil when the endpoint or bucket is unset.
+func newS3UploaderFromEnv(env, defaultPrefix string) *s3Uploader {
+	endpoint := os.Getenv(env + "_ENDPOINT")
+	bucket := os.Getenv(env + "_BUCKET")
+	if endpoint == "" || bucket == "" {
+		return nil
+	}
+	return &s3Uploader{
+		endpoint:  strings.TrimSuffix(endpoint, "/"),
+		bucket:    bucket,
+		region:    getEnv(env+"_REGION", "us-east-1"),
+		accessKey: os.Getenv(env + "_ACCESS_KEY_ID"),
+		secretKey: os.Getenv(env + "_SECRET_ACCESS_KEY"),
+		prefix:    getEnv(env+"_PREFIX", defaultPrefix),
+		timeout:   getPositiveTimeout(env+"_TIMEOUT_SECONDS", 60),
+	}
+}
+
+// errObjectNotFound is returned by Get for a missing object.
+var errObjectNotFound = errors.New("object not found")
+
+// Put uploads data as key (under the configured prefix) and returns the
+// object's s3:// location.
+func (u *s3Uploader) Put(ctx context.Context, key, contentType string, data []byte) (string, error) {
+	key = u.prefix + key
+	if _, err := u.do(ctx, http.MethodPut, key, contentType, data); err != nil {
+		return "", err
+	}
+	return "s3://" + u.bucket + "/" + key, nil
+}
+
+// Get downloads the object stored as key, which is the full object key
+// including the configured prefix.
+func (u *s3Uploader) Get(ctx context.Context, key string) ([]byte, error) {
+	return u.do(ctx, http.MethodGet, key, "", nil)
+}
+
+// Delete removes the object stored as key, the full object key including
+// the configured prefix. A missing object is not an error.
+func (u *s3Uploader) Delete(ctx context.Context, key string) error {
+	_, err := u.do(ctx, http.MethodDelete, key, "", nil)
+	if errors.Is(err, errObjectNotFound) {
+		return nil
+	}
+	return err
+}
+
+// do sends a signed request for the object key and returns the response
+// body.
+func (u *s3Uploader) do(ctx context.Context, method, key, contentType string, data []byte) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(ctx, u.timeout)
+	defer cancel()
+
+	path := "/" + u.bucket + "/" + awsURIEncode(key)
+	req, err := http.NewRequestWithContext(ctx, method, u.endpoint+path, bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create object storage request: %w", err)
+	}
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	u.sign(req, path, data, time.Now().UTC())
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound && method != http.MethodPut {
+		return nil, errObjectNotFound
+	}
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return nil, fmt.Errorf("object storage returned status %d: %s", resp.StatusCode, strings.TrimSpace(string(body)))
+	}
+	return io.ReadAll(resp.Body)
+}
+
+// sign adds the SigV4 headers for a request with no query string. The
+// Content-Type header is signed when set.
+func (u *s3Uploader) sign(req *http.Request, path string, payload []byte, now time.Time) {
+	amzDate := now.Format("20060102T150405Z")
+	date := now.Format("20060102")
+	payloadHash := sha256Hex(payload)
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+
+	signedHeaders := "host;x-amz-content-sha256;x-amz-date"
+	canonicalHeaders := "host:" + req.URL.Host + "\nx-amz-content-sha256:" + payloadHash + "\nx-amz-date:" + amzDate + "\n"
+	if contentType := req.Header.Get("Content-Type"); contentType != "" {
+		signedHeaders = "content-type;" + signedHeaders
+		canonicalHeaders = "content-type:" + contentType + "\n" + canonicalHeaders
+	}
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		path,
+		"",
+		canonicalHeaders,
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+	scope := date + "/" + u.region + "/s3/aws4_request"
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + sha256Hex([]byte(canonicalRequest))
+	signature := hex.EncodeToString(hmacSHA256(sigV4Key(u.secretKey, date, u.region, "s3"), stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		u.accessKey, scope, signedHeaders, signature))
+}
+
+// sigV4Key derives the SigV4 signing key for a day, region and service.
+func sigV4Key(secret, date, region, service string) []byte {
+	key := hmacSHA256([]byte("AWS4"+secret), date)
+	key = hmacSHA256(key, region)
+	key = hmacSHA256(key, service)
+	return hmacSHA256(key, "aws4_request")
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+func sha256Hex(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// awsURIEncode percent-encodes an object key as SigV4 requires: every byte
+// but unreserved characters and the '/' separating path segments.
+func awsURIEncode(key string) string {
+	var b strings.Builder
+	for _, c := range []byte(key) {
+		switch {
+		case 'A' <= c && c <= 'Z', 'a' <= c && c <= 'z', '0' <= c && c <= '9',
+			c == '-', c == '_', c == '.', c == '~', c == '/':
+			b.WriteByte(c)
+		default:
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,61 @@
+package server
+
+import (
+	"encoding/hex"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSigV4Key(t *testing.T) {
+	// Example from the AWS Signature Version 4 documentation.
+	key := sigV4Key("wJalrXUtnFEMI/K7MDENG+bPxRfiCYEXAMPLEKEY", "20120215", "us-east-1", "iam")
+	if got := hex.EncodeToString(key); got != "f4780e2d9f65fa895f9c67b32ce1baf0b0d8a43505a000a1a9e090d414db404d" {
+		t.Errorf("unexpected signing key %s", got)
+	}
+}
+
+func TestAWSURIEncode(t *testing.T) {
+	for in, want := range map[string]string{
+		"receipt-exports/exp_1/receipts.csv": "receipt-exports/exp_1/receipts.csv",
+		"a b+c":                              "a%20b%2Bc",
+		"é":                                  "%C3%A9",
+	} {
+		if got := awsURIEncode(in); got != want {
+			t.Errorf("awsURIEncode(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
+
+func TestS3Uploader_Sign(t *testing.T) {
+	u := &s3Uploader{bucket: "accounting", region: "eu-west-1", accessKey: "AKID", secretKey: "secret"}
+	req, _ := http.NewRequest(http.MethodPut, "https://storage.example/accounting/a.csv", strings.NewReader("x"))
+	req.Header.Set("Content-Type", "text/csv")
+	u.sign(req, "/accounting/a.csv", []byte("x"), time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC))
+
+	if req.Header.Get("X-Amz-Date") != "20260102T030405Z" || req.Header.Get("X-Amz-Content-Sha256") != sha256Hex([]byte("x")) {
+		t.Errorf("unexpected SigV4 headers %v", req.Header)
+	}
+	auth := req.Header.Get("Authorization")
+	if !strings.HasPrefix(auth, "AWS4-HMAC-SHA256 Credential=AKID/20260102/eu-west-1/s3/aws4_request, SignedHeaders=content-type;host;x-amz-content-sha256;x-amz-date, Signature=") {
+		t.Errorf("unexpected authorization %q", auth)
+	}
+
+	// The signature covers the payload.
+	other, _ := http.NewRequest(http.MethodPut, "https://storage.example/accounting/a.csv", strings.NewReader("y"))
+	other.Header.Set("Content-Type", "text/csv")
+	u.sign(other, "/accounting/a.csv", []byte("y"), time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC))
+	if other.Header.Get("Authorization") == auth {
+		t.Error("different payloads produced the same signature")
+	}
+}
+
+func TestS3Uploader_SignWithoutContentType(t *testing.T) {
+	u := &s3Uploader{bucket: "cold", region: "us-east-1", accessKey: "AKID", secretKey: "secret"}
+	req, _ := http.NewRequest(http.MethodGet, "https://storage.example/cold/a.jsonl.gz", nil)
+	u.sign(req, "/cold/a.jsonl.gz", nil, time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC))
+	if auth := req.Header.Get("Authorization"); !strings.Contains(auth, "SignedHeaders=host;x-amz-content-sha256;x-amz-date,") {
+		t.Errorf("expected Content-Type not to be signed when unset, got %q", auth)
+	}
+}
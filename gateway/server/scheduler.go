@@ -0,0 +1,210 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"math/rand/v2"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ScheduledJob is a periodic maintenance task. Run is called every Interval,
+// give or take the scheduler's jitter, and also right away when Immediate is
+// set. Runs of one job never overlap.
+type ScheduledJob struct {
+	Name      string
+	Interval  time.Duration
+	Immediate bool
+	Run       func(ctx context.Context) error
+}
+
+// ScheduledJobStatus is the last-run status of a job.
+type ScheduledJobStatus struct {
+	Name           string     `json:"name"`
+	Interval       string     `json:"interval"`
+	Running        bool       `json:"running"`
+	Runs           int64      `json:"runs"`
+	Failures       int64      `json:"failures"`
+	LastRun        *time.Time `json:"last_run,omitempty"`
+	LastDurationMs int64      `json:"last_duration_ms"`
+	LastError      string     `json:"last_error,omitempty"`
+	NextRun        *time.Time `json:"next_run,omitempty"`
+}
+
+type scheduledJob struct {
+	ScheduledJob
+	status ScheduledJobStatus // guarded by Scheduler.mu
+}
+
+// Scheduler runs periodic maintenance jobs in the background, one goroutine
+// per job. A panicking run is recovered and counted as a failure.
+type Scheduler struct {
+	jitter float64
+
+	mu     sync.Mutex
+	jobs   []*scheduledJob
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+// activeScheduler is the running scheduler, for GET /api/admin/jobs.
+var activeScheduler atomic.Pointer[Scheduler]
+
+// getSchedulerJitter returns the fraction each wait between runs varies by
+// (SCHEDULER_JITTER_PERCENT, default 10), so replicas do not run in step.
+func getSchedulerJitter() float64 {
+	return float64(min(max(getEnvAsInt("SCHEDULER_JITTER_PERCENT", 10), 0), 50)) / 100
+}
+
+// NewScheduler returns a stopped scheduler whose waits vary by up to
+// jitter, a fraction of each job's interval.
+func NewScheduler(jitter float64) *Scheduler {
+	return &Scheduler{jitter: jitter}
+}
+
+// Add registers a job. Jobs added after Start begin at once. Jobs without a
+// positive interval are ignored.
+func (s *Scheduler) Add(job ScheduledJob) {
+	if job.Interval <= 0 || job.Run == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	j := &scheduledJob{ScheduledJob: job, status: ScheduledJobStatus{Name: job.Name, Interval: job.Interval.String()}}
+	s.jobs = append(s.jobs, j)
+	if s.ctx != nil {
+		s.launch(j)
+	}
+}
+
+// Start runs every registered job until Stop.
+func (s *Scheduler) Start() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.ctx != nil {
+		return
+	}
+	s.ctx, s.cancel = context.WithCancel(context.Background())
+	for _, j := range s.jobs {
+		s.launch(j)
+	}
+}
+
+// Stop cancels the jobs and waits for running ones to return until ctx
+// expires.
+func (s *Scheduler) Stop(ctx context.Context) error {
+	s.mu.Lock()
+	cancel := s.cancel
+	s.ctx, s.cancel = nil, nil
+	s.mu.Unlock()
+	if cancel == nil {
+		return nil
+	}
+	cancel()
+
+	done := make(chan struct{})
+	go func() {
+		s.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return fmt.Errorf("scheduled jobs still running: %w", ctx.Err())
+	}
+}
+
+// Status returns the status of every job in registration order.
+func (s *Scheduler) Status() []ScheduledJobStatus {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	out := make([]ScheduledJobStatus, 0, len(s.jobs))
+	for _, j := range s.jobs {
+		out = append(out, j.status)
+	}
+	return out
+}
+
+// launch starts the loop of j. s.mu must be held.
+func (s *Scheduler) launch(j *scheduledJob) {
+	ctx := s.ctx
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		if j.Immediate {
+			s.run(ctx, j)
+		}
+		for {
+			wait := s.nextWait(j.Interval)
+			next := time.Now().Add(wait)
+			s.mu.Lock()
+			j.status.NextRun = &next
+			s.mu.Unlock()
+
+			timer := time.NewTimer(wait)
+			select {
+			case <-ctx.Done():
+				timer.Stop()
+				return
+			case <-timer.C:
+				s.run(ctx, j)
+			}
+		}
+	}()
+}
+
+// nextWait is interval varied by up to the scheduler's jitter either way.
+func (s *Scheduler) nextWait(interval time.Duration) time.Duration {
+	if s.jitter <= 0 {
+		return interval
+	}
+	return interval + time.Duration((rand.Float64()*2-1)*s.jitter*float64(interval))
+}
+
+// run calls the job once and records its outcome.
+func (s *Scheduler) run(ctx context.Context, j *scheduledJob) {
+	started := time.Now()
+	s.mu.Lock()
+	j.status.Running = true
+	s.mu.Unlock()
+
+	err := func() (err error) {
+		defer func() {
+			if r := recover(); r != nil {
+				err = fmt.Errorf("panic: %v", r)
+			}
+		}()
+		return j.Run(ctx)
+	}()
+	if err != nil {
+		log.Printf("[WARNING] Scheduled job %s failed: %v", j.Name, err)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	j.status.Running = false
+	j.status.Runs++
+	j.status.LastRun = &started
+	j.status.LastDurationMs = time.Since(started).Milliseconds()
+	j.status.LastError = ""
+	if err != nil {
+		j.status.Failures++
+		j.status.LastError = err.Error()
+	}
+}
+
+// handleListScheduledJobs handles GET /api/admin/jobs with the status of
+// every scheduled maintenance job.
+func handleListScheduledJobs(c *gin.Context) {
+	jobs := []ScheduledJobStatus{}
+	if s := activeScheduler.Load(); s != nil {
+		jobs = s.Status()
+	}
+	c.JSON(200, gin.H{"jobs": jobs})
+}
@@ -0,0 +1,109 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// waitForRuns waits until the job at index i of s has run n times.
+func waitForRuns(t *testing.T, s *Scheduler, i int, n int64) ScheduledJobStatus {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for time.Now().Before(deadline) {
+		if st := s.Status()[i]; st.Runs >= n && !st.Running {
+			return st
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	t.Fatalf("job %d did not run %d times: %+v", i, n, s.Status()[i])
+	return ScheduledJobStatus{}
+}
+
+func TestScheduler_RecordsRunsAndRecoversPanics(t *testing.T) {
+	s := NewScheduler(0.1)
+	var ticks atomic.Int64
+	s.Add(ScheduledJob{Name: "tick", Interval: 10 * time.Millisecond, Run: func(context.Context) error {
+		ticks.Add(1)
+		return nil
+	}})
+	s.Add(ScheduledJob{Name: "panics", Interval: time.Hour, Immediate: true, Run: func(context.Context) error {
+		panic("boom")
+	}})
+	s.Add(ScheduledJob{Name: "disabled", Interval: 0, Run: func(context.Context) error { return errors.New("ran") }})
+	s.Start()
+
+	if st := waitForRuns(t, s, 0, 3); st.Failures != 0 || st.LastRun == nil || st.NextRun == nil {
+		t.Errorf("unexpected status %+v", st)
+	}
+	if st := waitForRuns(t, s, 1, 1); st.Failures != 1 || !strings.Contains(st.LastError, "boom") {
+		t.Errorf("expected the panic to be recorded, got %+v", st)
+	}
+	if len(s.Status()) != 2 {
+		t.Errorf("expected a job without an interval to be ignored, got %+v", s.Status())
+	}
+
+	if err := s.Stop(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	stopped := ticks.Load()
+	time.Sleep(30 * time.Millisecond)
+	if ticks.Load() != stopped {
+		t.Error("expected no runs after Stop")
+	}
+}
+
+func TestScheduler_StopWaitsForRunningJob(t *testing.T) {
+	s := NewScheduler(0)
+	release := make(chan struct{})
+	s.Add(ScheduledJob{Name: "slow", Interval: time.Hour, Immediate: true, Run: func(ctx context.Context) error {
+		<-release
+		return ctx.Err()
+	}})
+	s.Start()
+	time.Sleep(10 * time.Millisecond)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if err := s.Stop(ctx); err == nil {
+		t.Error("expected Stop to time out while a run is blocked")
+	}
+	close(release)
+}
+
+func TestScheduler_Jitter(t *testing.T) {
+	s := NewScheduler(0.2)
+	for range 100 {
+		if wait := s.nextWait(time.Minute); wait < 48*time.Second || wait > 72*time.Second {
+			t.Fatalf("wait %s outside 20%% of the interval", wait)
+		}
+	}
+}
+
+func TestAdminJobs_ListsMaintenanceJobs(t *testing.T) {
+	t.Setenv("ADMIN_API_KEY", "s3cret")
+	gw := startGateway(t, nil)
+
+	w := adminGet(t, gw.Server.Config.Handler, "/api/admin/jobs", "s3cret")
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", w.Code)
+	}
+	var body struct {
+		Jobs []ScheduledJobStatus `json:"jobs"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+		t.Fatal(err)
+	}
+	names := make(map[string]bool)
+	for _, job := range body.Jobs {
+		names[job.Name] = true
+	}
+	if !names["receipt cleanup"] || !names["session receipts"] || !names["model catalog"] {
+		t.Errorf("expected the maintenance jobs, got %+v", body.Jobs)
+	}
+}
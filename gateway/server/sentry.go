@@ -0,0 +1,175 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// SentryConfig reports recovered panics to Sentry when DSN is set.
+type SentryConfig struct {
+	DSN         string
+	Environment string
+}
+
+// loadSentryConfig reads SENTRY_DSN and SENTRY_ENVIRONMENT (default:
+// APP_ENV).
+func loadSentryConfig() SentryConfig {
+	return SentryConfig{
+		DSN:         os.Getenv("SENTRY_DSN"),
+		Environment: getEnv("SENTRY_ENVIRONMENT", getAppEnv()),
+	}
+}
+
+// validate reports a malformed SENTRY_DSN.
+func (sc SentryConfig) validate() error {
+	if sc.DSN == "" {
+		return nil
+	}
+	if _, _, err := parseSentryDSN(sc.DSN); err != nil {
+		return fmt.Errorf("invalid SENTRY_DSN: %w", err)
+	}
+	return nil
+}
+
+// parseSentryDSN returns the envelope endpoint and public key of a DSN such
+// as https://<key>@o1.ingest.sentry.io/<project>.
+func parseSentryDSN(dsn string) (endpoint, key string, err error) {
+	u, err := url.Parse(dsn)
+	if err != nil {
+		return "", "", err
+	}
+	if u.Scheme != "http" && u.Scheme != "https" || u.Host == "" || u.User == nil || u.User.Username() == "" {
+		return "", "", fmt.Errorf("expected http(s)://<key>@<host>/<project>")
+	}
+	prefix, project := "", strings.Trim(u.Path, "/")
+	if i := strings.LastIndex(project, "/"); i >= 0 {
+		prefix, project = "/"+project[:i], project[i+1:]
+	}
+	if project == "" {
+		return "", "", fmt.Errorf("no project ID")
+	}
+	return fmt.Sprintf("%s://%s%s/api/%s/envelope/", u.Scheme, u.Host, prefix, project), u.User.Username(), nil
+}
+
+// sentryReporter sends panics to Sentry as events through its envelope
+// endpoint.
+type sentryReporter struct {
+	endpoint    string
+	key         string
+	environment string
+}
+
+// sentryReporters caches a reporter per config, so reloads that change
+// SENTRY_DSN take effect.
+var sentryReporters sync.Map // SentryConfig -> *sentryReporter
+
+// sentryReporterFor returns the reporter of sc, or nil without a DSN.
+func sentryReporterFor(sc SentryConfig) PanicReporter {
+	if sc.DSN == "" {
+		return nil
+	}
+	if r, ok := sentryReporters.Load(sc); ok {
+		return r.(*sentryReporter)
+	}
+	endpoint, key, err := parseSentryDSN(sc.DSN)
+	if err != nil {
+		return nil
+	}
+	r, _ := sentryReporters.LoadOrStore(sc, &sentryReporter{endpoint: endpoint, key: key, environment: sc.Environment})
+	return r.(*sentryReporter)
+}
+
+// sentryFrame is a stack frame of a Sentry exception.
+type sentryFrame struct {
+	Function string `json:"function"`
+	Module   string `json:"module,omitempty"`
+	Filename string `json:"filename"`
+	AbsPath  string `json:"abs_path"`
+	Lineno   int    `json:"lineno"`
+	InApp    bool   `json:"in_app"`
+}
+
+// event builds the Sentry event of p, with the panic as the exception and
+// the correlation ID as a tag.
+func (r *sentryReporter) event(p PanicReport) map[string]any {
+	// Sentry lists frames oldest first.
+	frames := make([]sentryFrame, 0, len(p.Frames))
+	for i := len(p.Frames) - 1; i >= 0; i-- {
+		f := p.Frames[i]
+		module, function := "", f.Function
+		if dot := strings.LastIndex(function, "/"); dot >= 0 {
+			if i := strings.Index(function[dot:], "."); i >= 0 {
+				module, function = function[:dot+i], function[dot+i+1:]
+			}
+		} else if i := strings.Index(function, "."); i >= 0 {
+			module, function = function[:i], function[i+1:]
+		}
+		frames = append(frames, sentryFrame{
+			Function: function,
+			Module:   module,
+			Filename: f.File[strings.LastIndex(f.File, "/")+1:],
+			AbsPath:  f.File,
+			Lineno:   f.Line,
+			InApp:    module == "main" || module == "gateway" || strings.HasPrefix(module, "gateway/"),
+		})
+	}
+	return map[string]any{
+		"event_id":    strings.ReplaceAll(uuid.New().String(), "-", ""),
+		"timestamp":   p.Time.UTC().Format(time.RFC3339Nano),
+		"platform":    "go",
+		"level":       "error",
+		"logger":      "gateway.recovery",
+		"environment": r.environment,
+		"transaction": p.Method + " " + p.Route,
+		"tags":        map[string]string{"correlation_id": p.CorrelationID},
+		"request":     map[string]string{"method": p.Method, "url": p.Path},
+		"exception": map[string]any{"values": []map[string]any{{
+			"type":       "panic",
+			"value":      fmt.Sprint(p.Value),
+			"stacktrace": map[string]any{"frames": frames},
+			"mechanism":  map[string]any{"type": "gin.recovery", "handled": true},
+		}}},
+	}
+}
+
+// ReportPanic posts p as a Sentry envelope with one event.
+func (r *sentryReporter) ReportPanic(ctx context.Context, p PanicReport) error {
+	event := r.event(p)
+	payload, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	var body bytes.Buffer
+	header, _ := json.Marshal(map[string]string{"event_id": event["event_id"].(string), "sent_at": time.Now().UTC().Format(time.RFC3339Nano)})
+	item, _ := json.Marshal(map[string]any{"type": "event", "length": len(payload)})
+	for _, line := range [][]byte{header, item, payload} {
+		body.Write(line)
+		body.WriteByte('\n')
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, r.endpoint, &body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-sentry-envelope")
+	req.Header.Set("X-Sentry-Auth", "Sentry sentry_version=7, sentry_client=microai-paygate/1.0, sentry_key="+r.key)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("sentry answered %s", resp.Status)
+	}
+	return nil
+}
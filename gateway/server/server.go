@@ -0,0 +1,1665 @@
+// Package server implements the gateway HTTP server used by MicroAI-Paygate.
+// It provides request handlers, middleware, and configuration helpers
+// for timeouts and rate limiting. cmd/gateway runs it with Main; NewRouter
+// builds the router for mounting inside another Go server.
+package server
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"runtime"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"gateway/payments"
+	"gateway/ratelimit"
+	"gateway/receipts"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/gin-contrib/cors"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/joho/godotenv"
+)
+
+// Payment types live in the payments package; the aliases keep handler and
+// test code unchanged.
+type (
+	PaymentContext = payments.Context
+	VerifyRequest  = payments.VerifyRequest
+	VerifyResponse = payments.VerifyResponse
+)
+
+type SummarizeRequest struct {
+	Text string `json:"text"`
+	GenerationParams
+	// OutputFormat is json (default), text or markdown; see output_format.go.
+	OutputFormat string `json:"output_format,omitempty"`
+}
+
+func validateConfig() error {
+	required := []string{
+		"OPENROUTER_API_KEY",
+	}
+	var missing []string
+	for _, key := range required {
+		if os.Getenv(key) == "" {
+			missing = append(missing, key)
+		}
+	}
+	if len(missing) > 0 {
+		return fmt.Errorf("missing required environment variables: %v", missing)
+	}
+	return nil
+}
+
+// Main runs the gateway until it receives SIGINT or SIGTERM, loading .env
+// from the working directory or its parent.
+func Main() {
+	// Try loading .env from current directory first, then fallback to parent
+	envFile := ".env"
+	err := godotenv.Load(envFile)
+	if err != nil {
+		// fallback to parent
+		envFile = "../.env"
+		err = godotenv.Load(envFile)
+		if err != nil {
+			log.Println("Warning: Error loading .env file")
+			envFile = ""
+		}
+	}
+	appEnv, err := applyAppProfile()
+	if err != nil {
+		fmt.Println("[Error] Invalid environment profile:")
+		fmt.Println("  -", err.Error())
+		os.Exit(1)
+	}
+	fmt.Printf("[OK] Environment profile: %s\n", appEnv)
+	if err := validateConfig(); err != nil {
+		fmt.Println("[Error] Missing required environment variables:")
+		fmt.Println("  -", err.Error())
+		fmt.Println()
+		fmt.Println("Copy .env.example to .env and fill in the required values.")
+		fmt.Println("See README.md for more configuration details.")
+		os.Exit(1)
+	}
+	cfg := loadConfig()
+	if err := cfg.Validate(); err != nil {
+		fmt.Println("[Error] Invalid configuration:")
+		fmt.Println("  -", err.Error())
+		os.Exit(1)
+	}
+	currentConfig.Store(cfg)
+	fmt.Println("[OK] Configuration validated")
+	if port := os.Getenv("PORT"); port != "" {
+		fmt.Printf("    - Port: %s\n", port)
+	}
+	if model := os.Getenv("MODEL"); model != "" {
+		fmt.Printf("    - Model: %s\n", model)
+	}
+	if verifier := os.Getenv("VERIFIER_URL"); verifier != "" {
+		fmt.Printf("    - Verifier: %s\n", verifier)
+	}
+	if chainID := os.Getenv("CHAIN_ID"); chainID != "" {
+		fmt.Printf("    - Chain ID: %s\n", chainID)
+	}
+	if os.Getenv("PORT") == "" {
+		fmt.Println("[WARN] PORT not set, using default: 3000")
+	}
+	if os.Getenv("MODEL") == "" {
+		fmt.Println("[WARN] MODEL not set, using default model")
+	}
+	if os.Getenv("VERIFIER_URL") == "" {
+		fmt.Println("[WARN] VERIFIER_URL not set, using default verifier")
+	}
+	if os.Getenv("CHAIN_ID") == "" {
+		fmt.Println("[WARN] CHAIN_ID not set, using default: 8453(base)")
+	}
+
+	r := setupRouter()
+
+	port := os.Getenv("PORT")
+	if port == "" {
+		port = "3000"
+	}
+	srv := &http.Server{Addr: ":" + port, Handler: r}
+	serve, challengeSrv, err := configureServer(srv, loadTLSSettings())
+	if err != nil {
+		fmt.Println("[Error] Invalid TLS configuration:")
+		fmt.Println("  -", err.Error())
+		os.Exit(1)
+	}
+	serverErr := make(chan error, 2)
+
+	lc := NewLifecycle()
+	registerSubsystems(lc, envFile)
+	if challengeSrv != nil {
+		lc.Register(httpServerHook("acme challenge server", challengeSrv, challengeSrv.ListenAndServe, serverErr))
+	}
+	// The HTTP server is registered last so it is the first thing stopped:
+	// in-flight requests drain before the subsystems they use shut down.
+	lc.Register(httpServerHook("http server", srv, serve, serverErr))
+
+	if err := lc.Start(context.Background()); err != nil {
+		log.Fatalf("Startup failed: %v", err)
+	}
+
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, syscall.SIGINT, syscall.SIGTERM)
+	select {
+	case sig := <-sigCh:
+		log.Printf("Received %s, shutting down", sig)
+	case err := <-serverErr:
+		log.Printf("HTTP server failed: %v", err)
+	}
+
+	if err := lc.Stop(context.Background()); err != nil {
+		log.Printf("Shutdown completed with errors: %v", err)
+		os.Exit(1)
+	}
+	log.Println("Shutdown complete")
+}
+
+// httpServerHook runs serve in the background on Start and gracefully shuts
+// srv down on Stop. Unexpected serve errors are reported on errCh.
+func httpServerHook(name string, srv *http.Server, serve func() error, errCh chan<- error) LifecycleHook {
+	return LifecycleHook{
+		Name: name,
+		Start: func(ctx context.Context) error {
+			go func() {
+				log.Printf("%s listening on %s", name, srv.Addr)
+				if err := serve(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+					errCh <- fmt.Errorf("%s: %w", name, err)
+				}
+			}()
+			return nil
+		},
+		Stop:    srv.Shutdown,
+		Timeout: getShutdownTimeout(),
+	}
+}
+
+// registerSubsystems registers the background subsystems in dependency
+// order. Later registrations may rely on earlier ones being started.
+func registerSubsystems(lc *Lifecycle, envFile string) {
+	// Initialize Redis early to fail-fast if Redis required but unavailable
+	lc.Register(LifecycleHook{
+		Name: "redis",
+		Start: func(ctx context.Context) error {
+			initRedis()
+			return nil
+		},
+		Stop: func(ctx context.Context) error {
+			if redisClient == nil {
+				return nil
+			}
+			err := redisClient.Close()
+			redisClient = nil
+			return err
+		},
+	})
+
+	lc.Register(LifecycleHook{
+		Name: "rate limiters",
+		Start: func(ctx context.Context) error {
+			if getRateLimitEnabled() {
+				onConfigReload(reloadRateLimiters)
+			}
+			return nil
+		},
+		Stop: func(ctx context.Context) error {
+			for _, limiter := range getActiveRateLimiters() {
+				if stopper, ok := limiter.(interface{ Stop() }); ok {
+					stopper.Stop()
+				}
+			}
+			stopRuleLimiters()
+			return nil
+		},
+	})
+
+	// Periodic maintenance jobs are added by the hooks below and run by the
+	// scheduler hook, which stops before their final runs on shutdown.
+	scheduler := NewScheduler(getSchedulerJitter())
+
+	// Rate limit buckets saved to Redis survive restarts; the last state is
+	// saved on shutdown.
+	var rateLimitSnapshots bool
+	lc.Register(LifecycleHook{
+		Name: "rate limit snapshots",
+		Start: func(ctx context.Context) error {
+			rateLimitSnapshots = startRateLimitSnapshots(ctx, scheduler)
+			return nil
+		},
+		Stop: func(ctx context.Context) error {
+			if !rateLimitSnapshots {
+				return nil
+			}
+			return saveRateLimitSnapshot(ctx)
+		},
+	})
+
+	// Receipt store cleanup; a final sweep on shutdown prevents receipt leaks.
+	lc.Register(LifecycleHook{
+		Name: "receipt cleanup",
+		Start: func(ctx context.Context) error {
+			scheduler.Add(ScheduledJob{Name: "receipt cleanup", Interval: receiptCleanupInterval, Run: func(context.Context) error {
+				cleanupExpiredReceipts()
+				return nil
+			}})
+			return nil
+		},
+		Stop: func(ctx context.Context) error {
+			// The store is in memory, so archive everything still in it.
+			archiveReceipts(ctx, time.Now().Add(100*365*24*time.Hour))
+			cleanupExpiredReceipts()
+			return nil
+		},
+	})
+
+	// Session receipt aggregation; pending calls are aggregated on shutdown.
+	lc.Register(LifecycleHook{
+		Name: "session receipts",
+		Start: func(ctx context.Context) error {
+			scheduler.Add(ScheduledJob{Name: "session receipts", Interval: getSessionReceiptInterval(), Run: func(context.Context) error {
+				aggregateSessions()
+				return nil
+			}})
+			return nil
+		},
+		Stop: func(ctx context.Context) error {
+			aggregateSessions()
+			return nil
+		},
+	})
+
+	// Receipt transparency roots; pending receipts are published on shutdown.
+	lc.Register(LifecycleHook{
+		Name: "transparency",
+		Start: func(ctx context.Context) error {
+			if cfg := getConfig().Transparency; cfg.Enabled {
+				scheduler.Add(ScheduledJob{Name: "transparency roots", Interval: cfg.Interval, Run: publishTransparencyRoot})
+			}
+			return nil
+		},
+		Stop: func(ctx context.Context) error {
+			return publishTransparencyRoot(ctx)
+		},
+	})
+
+	// Payload retention; a misconfigured policy fails startup rather than
+	// silently dropping payloads kept for disputes.
+	lc.Register(LifecycleHook{
+		Name: "payload retention",
+		Start: func(ctx context.Context) error {
+			r, err := newPayloadRetention()
+			if err != nil || r == nil {
+				return err
+			}
+			scheduler.Add(ScheduledJob{Name: "payload retention", Interval: payloadPurgeInterval, Run: purgeExpiredPayloads})
+			return nil
+		},
+	})
+
+	// Operational alerts to Slack, a webhook and/or email.
+	lc.Register(LifecycleHook{
+		Name: "alerts",
+		Start: func(ctx context.Context) error {
+			if cfg := getConfig().Alerts; cfg.Enabled() {
+				scheduler.Add(ScheduledJob{Name: "alerts", Interval: cfg.Interval, Run: alerts.run})
+			}
+			return nil
+		},
+	})
+
+	// Provider model catalog: context windows, pricing and offered models.
+	lc.Register(LifecycleHook{
+		Name: "model catalog",
+		Start: func(ctx context.Context) error {
+			scheduler.Add(ScheduledJob{Name: "model catalog", Interval: getModelCatalogInterval(), Immediate: true, Run: refreshModelCatalog})
+			return nil
+		},
+	})
+
+	lc.Register(LifecycleHook{
+		Name: "scheduler",
+		Start: func(ctx context.Context) error {
+			scheduler.Start()
+			activeScheduler.Store(scheduler)
+			return nil
+		},
+		Stop: func(ctx context.Context) error {
+			activeScheduler.CompareAndSwap(scheduler, nil)
+			return scheduler.Stop(ctx)
+		},
+	})
+
+	// Outbox dispatcher for receipt and webhook side effects. It stops after
+	// the job workers so webhooks of jobs finishing during shutdown are
+	// still written to it; whatever is undelivered stays in the table.
+	lc.Register(LifecycleHook{
+		Name: "outbox",
+		Start: func(ctx context.Context) error {
+			o, err := newOutboxFromEnv(ctx)
+			if err != nil || o == nil {
+				return err
+			}
+			o.Start()
+			activeOutbox.Store(o)
+			return nil
+		},
+		Stop: func(ctx context.Context) error {
+			if o := activeOutbox.Swap(nil); o != nil {
+				return o.Close(ctx)
+			}
+			return nil
+		},
+	})
+
+	// Async job workers; on shutdown queued jobs get the hook timeout to finish.
+	lc.Register(LifecycleHook{
+		Name: "job workers",
+		Start: func(ctx context.Context) error {
+			queue := newJobQueue()
+			queue.Start()
+			activeJobsMu.Lock()
+			activeJobs = queue
+			activeJobsMu.Unlock()
+			return nil
+		},
+		Stop: func(ctx context.Context) error {
+			activeJobsMu.Lock()
+			queue := activeJobs
+			activeJobs = nil
+			activeJobsMu.Unlock()
+			if queue != nil {
+				queue.Stop(ctx)
+			}
+			return nil
+		},
+		Timeout: getPositiveTimeout("JOB_SHUTDOWN_TIMEOUT_SECONDS", 30),
+	})
+
+	// Audit log writer; on shutdown queued records are flushed to the sinks.
+	lc.Register(LifecycleHook{
+		Name: "audit log",
+		Start: func(ctx context.Context) error {
+			logger, err := newAuditLogger()
+			if err != nil || logger == nil {
+				return err
+			}
+			logger.Start()
+			activeAudit.Store(logger)
+			return nil
+		},
+		Stop: func(ctx context.Context) error {
+			if logger := activeAudit.Swap(nil); logger != nil {
+				return logger.Close(ctx)
+			}
+			return nil
+		},
+	})
+
+	// Reload rate limits, pricing, models and CORS on SIGHUP, whenever the
+	// rate limit rules file changes and, if enabled, whenever the .env file
+	// changes.
+	reloadCtx, reloadCancel := context.WithCancel(context.Background())
+	lc.Register(LifecycleHook{
+		Name: "config reload",
+		Start: func(ctx context.Context) error {
+			go watchConfigSignals(reloadCtx, envFile)
+			var files []string
+			if rulesFile := os.Getenv("RATE_LIMIT_RULES_FILE"); rulesFile != "" {
+				files = append(files, rulesFile)
+			}
+			if getConfigWatchEnabled() {
+				if envFile == "" {
+					log.Println("Warning: CONFIG_WATCH_ENABLED set but no .env file was loaded")
+				} else {
+					files = append(files, envFile)
+				}
+			}
+			if len(files) == 0 {
+				return nil
+			}
+			if err := watchConfigFiles(reloadCtx, envFile, files); err != nil {
+				log.Printf("Warning: config file watcher disabled: %v", err)
+				return nil
+			}
+			log.Printf("Watching %s for configuration changes", strings.Join(files, ", "))
+			return nil
+		},
+		Stop: func(ctx context.Context) error {
+			reloadCancel()
+			return nil
+		},
+	})
+}
+
+// NewRouter returns the gateway's router, configured from the environment,
+// for mounting inside another Go server. Like setupRouter it starts no
+// background work, so Redis, job workers and the other subsystems Main
+// starts are not running.
+func NewRouter() http.Handler {
+	return setupRouter()
+}
+
+// setupRouter builds the gin engine with all middleware and routes. It reads
+// the environment and active config but starts no background work, so tests
+// can build the full production router.
+func setupRouter() *gin.Engine {
+	r := gin.New()
+	// Wrong methods get 405 with Allow rather than 404. HEAD is served by
+	// the GET route, re-entering the router, so it comes first.
+	r.HandleMethodNotAllowed = true
+	r.Use(headMiddleware(r), requestLogger(), recoveryMiddleware())
+
+	// VIBE FIX: Register the Correlation ID Middleware immediately
+	// This ensures every single request gets an ID before anything else happens.
+	r.Use(CorrelationIDMiddleware())
+
+	// Network ACL runs before everything else, including rate limiting.
+	applyTrustedProxies(r)
+	r.Use(networkACLMiddleware())
+
+	r.StaticFileFS("/openapi.yaml", "openapi.yaml", http.FS(openAPISpec))
+
+	// Discovery documents for client SDK auto-configuration
+	r.GET("/.well-known/paygate-configuration", handlePaygateConfiguration)
+	r.GET("/.well-known/paygate-keys", handlePaygateKeys)
+
+	// API docs with a payment console for trying the 402 flow
+	r.GET("/docs", handleDocs)
+
+	// OPTIONS for registered routes is answered from the route table, filled
+	// once every route is registered, before CORS and payment run.
+	routes := new(routeTable)
+	r.Use(preflightMiddleware(routes))
+
+	// Allowed origins are read from the active config so they can be reloaded.
+	r.Use(cors.New(cors.Config{
+		AllowOriginFunc: func(origin string) bool { return getConfig().IsOriginAllowed(origin) },
+		AllowMethods:    []string{"GET", "HEAD", "POST", "OPTIONS"},
+		AllowHeaders:    append(slices.Clone(corsRequestHeaders), paymentRequestHeaders...),
+		ExposeHeaders: []string{
+			"Content-Length", "X-RateLimit-Limit", "X-RateLimit-Remaining", "X-RateLimit-Reset", "Retry-After",
+			"X-402-Receipt", "X-402-Receipt-Format", "X-402-Response-Signature", "X-Prompt-Sanitized", "X-402-Receipt-CID", "X-402-Receipt-Status", "X-402-Receipt-Source", "X-402-Session", "X-402-Price", "X-PAYMENT-RESPONSE", "X-Correlation-ID", "Server-Timing", "ETag", "Last-Modified",
+			"X-Budget-Daily-Spent", "X-Budget-Daily-Limit", "X-Budget-Monthly-Spent", "X-Budget-Monthly-Limit", "X-Budget-Reset",
+			"Deprecation", "Link", "X-API-Deprecated-Features", "X-Outage-Mode",
+		},
+		AllowCredentials: true,
+	}))
+
+	// Live counters for GET /api/admin/stats, including shed and rate
+	// limited requests.
+	r.Use(statsMiddleware())
+
+	// X-PAYMENT is translated into the X-402 headers before any middleware
+	// that tiers or scores requests by payer.
+	r.Use(paymentHeaderMiddleware())
+
+	// Overload protection sheds requests before they are rate limited or
+	// reach a handler.
+	r.Use(loadSheddingMiddleware())
+
+	// Clients with too many signature failures or 4xx responses are
+	// temporarily banned before they reach the rate limiter.
+	r.Use(abuseMiddleware())
+
+	// Initialize rate limiters if enabled
+	if getRateLimitEnabled() {
+		limiters := initRateLimiters()
+		activeRateLimiters.Store(&limiters)
+		walletTiers.Store(newWalletTierResolver())
+		r.Use(rateLimitMiddleware(getActiveRateLimiters))
+		log.Println("Rate limiting enabled")
+	}
+
+	// Priority lanes: near capacity, tiers past their weighted share are
+	// shed, anonymous first. They run after the rate limiter, which assigns
+	// the tier.
+	r.Use(admissionMiddleware())
+
+	// Request timeouts per route (ROUTE_TIMEOUTS, default 60s and 30s for
+	// the AI endpoints). Paid endpoints registered with their own timeout
+	// may shorten the deadline further; nested timeouts always keep the
+	// earliest deadline.
+	r.Use(routeTimeoutMiddleware())
+
+	//health check if server is up
+	r.GET("/healthz", handleHealthz)
+
+	//readiness check
+	r.GET("/readyz", handleReadyz)
+
+	// AI endpoints with AI-specific timeout (30s). The unversioned routes are
+	// v1; apiCompatMiddleware lets each version accept the other's formats.
+	registerAIRoutes(r.Group("/api/ai"), apiV1)
+	registerAIRoutes(r.Group("/api/v2/ai"), apiV2)
+
+	// Receipt lookup endpoint
+	// Note: Rate limiting applies only if enabled globally via RATE_LIMIT_ENABLED=true
+	// Random 12-char receipt IDs (2^48 space) make brute-force enumeration impractical
+	r.GET("/api/receipts/mine", handleListMyReceipts)
+	r.GET("/api/receipts/:id", handleGetReceipt)
+	r.GET("/api/receipts/:id/proof", handleGetReceiptProof)
+	r.GET("/api/transparency/roots", handleListTransparencyRoots)
+	r.GET("/api/receipts/by-request-hash/:hash", handleGetReceiptByRequestHash)
+	r.GET("/api/receipts/sessions/:sessionId", handleGetSession)
+
+	// Pre-issued payment challenges, signed and paid later
+	r.POST("/api/payment/challenge", handleCreateChallenge)
+	r.POST("/api/payment/verify", handleVerifyPayment)
+	r.POST("/api/receipts/verify", handleVerifyReceipt)
+
+	// Self-service usage for the wallet that signs a usage challenge
+	r.POST("/api/me/challenge", handleCreateUsageChallenge)
+	r.GET("/api/me/usage", handleGetUsage)
+
+	// Current prices, tokens and chains, without a 402
+	r.GET("/api/pricing", handleGetPricing)
+
+	// Error code registry, linked from every error's docs_url
+	r.GET("/api/errors", handleListErrors)
+	r.GET("/api/errors/:code", handleGetError)
+
+	// Operator endpoints, enabled by ADMIN_API_KEY
+	adminGroup := r.Group("/api/admin")
+	adminGroup.Use(adminAuthMiddleware())
+	adminGroup.GET("/margins", handleMarginReport)
+	adminGroup.GET("/deprecations", handleDeprecationReport)
+	adminGroup.GET("/stats", handleStats)
+	adminGroup.GET("/jobs", handleListScheduledJobs)
+	adminGroup.POST("/receipts/:id/revoke", handleRevokeReceipt)
+	adminGroup.GET("/receipts/revocations", handleListRevocations)
+	adminGroup.GET("/receipts/:id/payloads", handleGetReceiptPayloads)
+	adminGroup.GET("/receipts/:id/margin", handleGetReceiptMargin)
+	adminGroup.POST("/receipts/resign", handleResignReceipts)
+	adminGroup.GET("/receipts/export", handleExportReceipts)
+	adminGroup.POST("/receipts/exports", handleCreateReceiptExport)
+	adminGroup.GET("/receipts/exports/:id", handleGetReceiptExport)
+	adminGroup.DELETE("/cache", handlePurgeCache)
+	adminGroup.DELETE("/cache/:key", handleDeleteCacheKey)
+	adminGroup.POST("/cache/version", handleBumpCacheVersion)
+	adminGroup.POST("/replay/:id", handleReplay)
+	adminGroup.GET("/shadow", handleShadowReport)
+	adminGroup.GET("/alerts", handleListAlerts)
+	adminGroup.GET("/bans", handleListBans)
+	adminGroup.DELETE("/bans/:key", handleLiftBan)
+	adminGroup.GET("/rate-limits", handleListRateLimits)
+	adminGroup.PUT("/rate-limits/:tier", handleSetRateLimit)
+	adminGroup.GET("/maintenance", handleGetMaintenance)
+	adminGroup.POST("/maintenance", handleSetMaintenance)
+	adminGroup.DELETE("/maintenance", handleClearMaintenance)
+
+	*routes = *buildRouteTable(r)
+	registerOptionsRoutes(r, routes)
+	r.NoRoute(handleNoRoute)
+	r.NoMethod(handleNoMethod)
+	return r
+}
+
+// registerAIRoutes mounts the AI endpoints on group for one API version.
+func registerAIRoutes(group *gin.RouterGroup, version string) {
+	group.Use(serverTimingMiddleware(), auditMiddleware(), maintenanceMiddleware(), apiCompatMiddleware(version), walletConcurrencyMiddleware())
+	if getCacheEnabled() {
+		group.POST("/summarize", extractionMiddleware(), CacheMiddleware(), handleSummarize)
+	} else {
+		group.POST("/summarize", extractionMiddleware(), handleSummarize)
+	}
+	group.POST("/embed", handleEmbed)
+	group.POST("/estimate", handleEstimate)
+	group.POST("/jobs", handleCreateJob)
+	group.GET("/jobs/:id", handleGetJob)
+	paidEndpoints.mount(group)
+}
+
+// handleSummarize handles POST /api/ai/summarize requests. It validates
+// payment headers, calls the verifier service to validate the signature, and
+// forwards the text to the AI service. The handler respects context timeouts
+// applied by middleware and returns appropriate HTTP errors (402, 403, 504,
+// 500) to the client.
+func handleSummarize(c *gin.Context) {
+	// 1. Payment Verification
+	// Note: CacheMiddleware aborts on cache HIT, so this handler only runs on cache MISS or when caching is disabled
+	var requestBody []byte
+	var err error
+
+	signature := c.GetHeader("X-402-Signature")
+	nonce := c.GetHeader("X-402-Nonce")
+
+	// Basic check
+	if signature == "" || nonce == "" {
+		quote := quotePrice(c)
+		respondPaymentRequired(c, quote.Price, newAPIError(CodePaymentRequired, "").with(gin.H{"quote": quote}))
+		return
+	}
+
+	// Check if body already read by middleware
+	if body, exists := c.Get("request_body"); exists {
+		// Cache middleware always sets this as []byte, safe to assert
+		requestBody = body.([]byte)
+	}
+
+	// Read body if not already available
+	if requestBody == nil {
+		// Read body with limit (only if middleware didn't process it)
+		const maxBodySize = 10 * 1024 * 1024
+		c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, int64(maxBodySize))
+		requestBody, err = io.ReadAll(c.Request.Body)
+		if err != nil {
+			var maxBytesErr *http.MaxBytesError
+			if errors.As(err, &maxBytesErr) {
+				respondAPIError(c, errPayloadTooLarge())
+			} else {
+				respondError(c, CodeBodyReadFailed, "The request body could not be read")
+			}
+			return
+		}
+	}
+
+	// 2. Parse Request. The text is needed before verification because the
+	// routed model determines the price the payment must have been signed for.
+	var req SummarizeRequest
+	if err := json.Unmarshal(requestBody, &req); err != nil {
+		respondError(c, CodeInvalidBody, "Request must be valid JSON")
+		return
+	}
+
+	// Validate text is not empty (also validated in cache middleware, but needed here for non-cached requests)
+	if req.Text == "" {
+		respondError(c, CodeInvalidRequest, "text field cannot be empty")
+		return
+	}
+
+	if !negotiateOutputFormat(c, req.OutputFormat) {
+		return
+	}
+
+	// Screen the input before anything is charged (a no-op if the cache
+	// middleware already did)
+	if !screenContent(c, req.Text) {
+		return
+	}
+	req.Text = sanitizeInput(c, req.Text)
+
+	// Route by input length, clamp the generation parameters, price the
+	// summary length and choose the summary language (no-ops if the cache
+	// middleware already did)
+	selectModelForText(c, req.Text)
+	setGenerationParams(c, req.GenerationParams)
+	sel, ok := shapeSummary(c)
+	if !ok {
+		return
+	}
+	params, ok := localizeSummary(c, req.Text)
+	if !ok {
+		return
+	}
+	if !checkContextWindow(c, sel.Model, req.Text, params) {
+		return
+	}
+	price := sel.Price
+
+	// Fail fast while the provider circuit is open, before the payment is
+	// verified or charged.
+	if cfg := getConfig(); !providerCircuit.Allow(cfg) {
+		rejectProviderOutage(c, cfg)
+		return
+	}
+
+	// Verify, overlapping the AI call with verification if configured
+	spec := prepareAICall(c, getModelSelection(c).Model, req.Text, params)
+	verifyResp, paymentCtx, ok := authorizePayment(c, signature, nonce, price)
+	if !ok {
+		spec.Discard()
+		return
+	}
+
+	// 3. Enforce per-wallet spending caps before incurring provider cost
+	refundSpend, ok := reserveSpend(c, verifyResp.RecoveredAddress, price)
+	if !ok {
+		spec.Discard()
+		return
+	}
+
+	// 4. Call AI Service (possibly on the backup model if the preferred one is
+	// degraded), joining an identical call already in flight. A share of
+	// summaries is mirrored to the shadow model in parallel.
+	shadow := startShadow(c, req.Text, params)
+	defer shadow.Done(c)
+	aiStart := time.Now()
+	var res providerResult
+	if spec != nil {
+		res, err = spec.Wait(c.Request.Context())
+	} else {
+		res, err = summarizeShared(c.Request.Context(), getModelSelection(c).Model, req.Text, params)
+	}
+	recordTiming(c, timingAI, "", time.Since(aiStart))
+	if err != nil {
+		refundSpend()
+		if errors.Is(err, context.DeadlineExceeded) || c.Request.Context().Err() == context.DeadlineExceeded {
+			respondWithRefund(c, newAPIError(CodeAITimeout, "AI request timed out"), *paymentCtx, verifyResp.RecoveredAddress)
+			return
+		}
+		respondWithRefund(c, newAPIError(CodeAIFailed, "The AI provider request failed").withDetails(err.Error()), *paymentCtx, verifyResp.RecoveredAddress)
+		return
+	}
+
+	c.Set("model_selection", getModelSelection(c).servedBy(res))
+	c.Set("provider_usage", res.Usage)
+	shadow.Primary(res, time.Since(aiStart))
+	if res.Provider != getConfig().AIProviders[0].Name {
+		// Keep fallback answers out of the cache entry for the routed model.
+		c.Set("provider_fallback", true)
+	}
+
+	// 5. Generate & Send Receipt
+	if err := generateAndSendReceipt(c, *paymentCtx, verifyResp.RecoveredAddress, requestBody, res.Summary); err != nil {
+		refundSpend()
+		log.Printf("Failed to generate receipt: %v", err)
+		// generateAndSendReceipt sends error response if it fails?
+		// No, it returns error, we might have already written status if we aren't careful.
+		// Let's implement generateAndSendReceipt to handle sending response.
+		return
+	}
+	retainReplayInput(c, req.Text)
+	recordMargin(c, *paymentCtx, verifyResp.RecoveredAddress, res.Usage)
+}
+
+// authorizePayment settles a paid request. With a refund voucher in
+// X-402-Voucher it redeems the voucher; otherwise it negotiates the chain,
+// asks the verifier and spends the nonce, so each signed payment is accepted
+// once across all replicas. On failure it has aborted with the error response and
+// returns false.
+func authorizePayment(c *gin.Context, signature, nonce, price string) (*VerifyResponse, *PaymentContext, bool) {
+	defer startTiming(c, timingVerify)()
+	sigType, ok := requestSignatureType(c)
+	if !ok {
+		return nil, nil, false
+	}
+	if _, ok := requestSessionID(c); !ok {
+		return nil, nil, false
+	}
+	if id := c.GetHeader("X-402-Voucher"); id != "" {
+		return redeemVoucher(c, id, sigType, signature, nonce, price)
+	}
+
+	chain, ok := negotiatePayment(c, nonce, price)
+	if !ok {
+		return nil, nil, false
+	}
+	verifyResp, paymentCtx, err := verifyPayment(c.Request.Context(), chain, sigType, signature, c.GetHeader("X-402-Payer"), nonce, price)
+	if err != nil {
+		log.Printf("Verification error: %v", err)
+		if errors.Is(err, context.DeadlineExceeded) {
+			abortWithError(c, CodeVerifierTimeout, "Verifier request timed out")
+		} else {
+			abortWithError(c, CodeVerifierFailed, "An internal error occurred")
+		}
+		return nil, nil, false
+	}
+	if !verifyResp.IsValid {
+		rejectSignature(c, verifyResp, sigType, *paymentCtx, signature, c.GetHeader("X-402-Payer"))
+		return nil, nil, false
+	}
+	if !acquireWalletSlot(c, verifyResp.RecoveredAddress) {
+		return nil, nil, false
+	}
+	releaseSponsor, ok := authorizeSponsor(c, *paymentCtx, verifyResp.RecoveredAddress, price)
+	if !ok {
+		return nil, nil, false
+	}
+	claimed, err := claimNonce(c.Request.Context(), nonce, getNonceTTL())
+	if err != nil {
+		releaseSponsor()
+		log.Printf("[WARNING] Nonce store error: %v", err)
+		abortWithError(c, CodeServiceUnavailable, "Payment nonces cannot be checked right now")
+		return nil, nil, false
+	}
+	if !claimed {
+		releaseSponsor()
+		abortWithError(c, CodeNonceReplayed, "The payment nonce was already used")
+		return nil, nil, false
+	}
+	if _, ok := c.Get("payment_challenge"); ok {
+		consumeChallenge(c.Request.Context(), nonce)
+	}
+	if sigType == payments.SignatureTypeERC3009 {
+		relayTransferAuthorization(c.Request.Context(), paymentCtx.ChainID, signature)
+	}
+	rememberVerifiedPayer(verifyResp.RecoveredAddress)
+	recordVerifiedRevenue(price)
+	return verifyResp, paymentCtx, true
+}
+
+// verifyPayment checks that signature, of type sigType, authorizes a payment
+// of amount by payer (or by whoever signed, when payer is empty).
+func verifyPayment(ctx context.Context, chain ChainOption, sigType, signature, payer, nonce, amount string) (*VerifyResponse, *PaymentContext, error) {
+	paymentCtx := paymentContextFor(chain, amount, nonce)
+
+	verifyResp, err := verifySignature(ctx, sigType, paymentCtx, signature, payer)
+	if err != nil {
+		return nil, nil, err
+	}
+	return verifyResp, &paymentCtx, nil
+}
+
+// newVerifierClient builds a verifier client from VERIFIER_URL and
+// VERIFIER_TIMEOUT_SECONDS that forwards the request's correlation ID and
+// signs requests with VERIFIER_SHARED_SECRET when it is set.
+func newVerifierClient() *payments.VerifierClient {
+	return &payments.VerifierClient{
+		BaseURL:      getVerifierURL(),
+		HTTPClient:   getConfig().VerifierHTTPClient(),
+		Timeout:      getVerifierTimeout(),
+		SharedSecret: []byte(os.Getenv("VERIFIER_SHARED_SECRET")),
+		// VIBE FIX: Pass Correlation ID to the Verifier Service
+		BeforeSend: func(ctx context.Context, req *http.Request) {
+			if cid, ok := ctx.Value(correlationIDKey).(string); ok {
+				req.Header.Set("X-Correlation-ID", cid)
+			}
+		},
+	}
+}
+
+// getVerifierURL returns VERIFIER_URL or the local default.
+func getVerifierURL() string {
+	verifierURL := os.Getenv("VERIFIER_URL")
+	if verifierURL == "" {
+		verifierURL = "http://127.0.0.1:3002"
+	}
+	return verifierURL
+}
+
+// generateAndSendReceipt handles receipt generation, storage, and sending the final JSON response.
+// The receipt is sent ONLY in the X-402-Receipt header, not in the response body,
+// to ensure the ResponseHash in the receipt matches the actual JSON body clients receive.
+// The body names the receipt by ID, which is chosen before the body is hashed,
+// and is rendered in the negotiated output format.
+func generateAndSendReceipt(c *gin.Context, paymentCtx PaymentContext, recoveredAddr string, requestBody []byte, aiResult string) error {
+	receiptID, err := receipts.NewID()
+	if err != nil {
+		respondAPIError(c, newAPIError(CodeReceiptFailed, "Failed to generate receipt").withDetails(err.Error()))
+		return err
+	}
+	contentType, responseBody, err := encodeSummary(newSummaryResponse(c, aiResult, receiptID), outputFormat(c))
+	if err != nil {
+		respondError(c, CodeInternal, "Failed to encode response")
+		return err
+	}
+	return sendBodyWithReceipt(c, paymentCtx, recoveredAddr, requestBody, contentType, responseBody, receipts.WithID(receiptID))
+}
+
+// sendWithReceipt is generateAndSendReceipt for any JSON response body.
+// opts add endpoint-specific details to the receipt.
+func sendWithReceipt(c *gin.Context, paymentCtx PaymentContext, recoveredAddr string, requestBody []byte, response interface{}, opts ...receipts.Option) error {
+	responseBody, err := receipts.EncodeResponse(response)
+	if err != nil {
+		respondError(c, CodeInternal, "Failed to encode response")
+		return err
+	}
+	return sendBodyWithReceipt(c, paymentCtx, recoveredAddr, requestBody, outputContentTypes[outputFormatJSON], responseBody, opts...)
+}
+
+// sendBodyWithReceipt sends responseBody, already encoded as contentType,
+// with a receipt whose ResponseHash covers exactly those bytes.
+func sendBodyWithReceipt(c *gin.Context, paymentCtx PaymentContext, recoveredAddr string, requestBody []byte, contentType string, responseBody []byte, opts ...receipts.Option) error {
+	receiptStart := time.Now()
+
+	// Generate receipt with the actual response body hash
+	seq, err := nextReceiptSequence(c.Request.Context(), recoveredAddr)
+	if err != nil {
+		respondAPIError(c, newAPIError(CodeReceiptFailed, "Failed to generate receipt").withDetails(err.Error()))
+		return err
+	}
+	opts = append(opts, receipts.WithSequence(seq))
+	if v, ok := c.Get("model_selection"); ok {
+		sel := v.(ModelSelection)
+		opts = append(opts, receipts.WithModel(sel.Model, sel.SubstitutedFor), receipts.WithProvider(sel.Provider))
+	}
+	opts = append(opts, receipts.WithParameters(getGenerationParams(c)))
+	if v, ok := c.Get("provider_usage"); ok {
+		usage := v.(ProviderUsage)
+		opts = append(opts, receipts.WithTokens(usage.PromptTokens, usage.CompletionTokens))
+	}
+	opts = append(opts, requestSponsor(c).receiptOptions()...)
+	if source := c.GetString("input_source"); source != "" {
+		opts = append(opts, receipts.WithSource(source, c.GetString("input_source_url")))
+	}
+	if original, ok := c.Get("receipt_request_body"); ok {
+		// Hash the body as sent, not as translated by apiCompatMiddleware.
+		requestBody = original.([]byte)
+	}
+	receipt, err := GenerateReceipt(paymentCtx, recoveredAddr, c.Request.URL.Path, requestBody, responseBody, opts...)
+	if err != nil {
+		respondAPIError(c, newAPIError(CodeReceiptFailed, "Failed to generate receipt").withDetails(err.Error()))
+		return err
+	}
+
+	if err := storeReceipt(receipt, getReceiptTTL()); err != nil {
+		respondError(c, CodeReceiptFailed, "Failed to store receipt")
+		return err
+	}
+	cid, err := persistReceipt(c.Request.Context(), receipt)
+	if err != nil {
+		respondError(c, CodeReceiptFailed, "Failed to store receipt")
+		return err
+	}
+	c.Set("issued_receipt", receipt)
+	retainPayloads(c.Request.Context(), receipt, requestBody, responseBody)
+	if cid != "" {
+		c.Header("X-402-Receipt-CID", cid)
+	}
+	if id := c.GetHeader("X-402-Session"); id != "" && addSessionCall(id, receipt) {
+		c.Header("X-402-Session", id)
+	}
+
+	// Unknown receipt_format values fall back to JSON rather than failing a
+	// request that has already been paid for.
+	format, _ := receiptFormat(c)
+	receiptHeader, err := encodeReceiptHeader(receipt, format)
+	if err != nil {
+		respondError(c, CodeReceiptFailed, "Failed to encode receipt")
+		return err
+	}
+
+	// Send receipt in header only (not in body) so ResponseHash matches body
+	setPriceHeader(c, paymentCtx.Amount)
+	c.Header("X-402-Receipt", receiptHeader)
+	c.Header("X-402-Receipt-Format", format)
+	setX402PaymentResponse(c, recoveredAddr)
+	signResponse(c, responseBody)
+	recordTiming(c, timingReceipt, "", time.Since(receiptStart))
+	// Send the hashed bytes themselves; encoding response again could differ.
+	c.Data(200, contentType, responseBody)
+	return nil
+}
+
+// createPaymentContexts constructs one PaymentContext per accepted chain for amount, primary chain first. They share a newly generated UUID nonce, so the client signs exactly one, and each is signed as a quote when the server key is available.
+func createPaymentContexts(amount string) []PaymentContext {
+	nonce := uuid.New().String()
+	chains := getConfig().Chains
+	if len(chains) == 0 {
+		chains = []ChainOption{getConfig().PrimaryChain()}
+	}
+	contexts := make([]PaymentContext, len(chains))
+	for i, chain := range chains {
+		contexts[i] = paymentContextFor(chain, amount, nonce)
+		signQuote(&contexts[i])
+	}
+	return contexts
+}
+
+// getRecipientAddress returns the payment recipient from the active config.
+// RECIPIENT_ADDRESS falls back to a default address (with a warning) if unset.
+func getRecipientAddress() string {
+	return getConfig().RecipientAddress
+}
+
+// getPaymentAmount returns the payment amount from the active config.
+// PAYMENT_AMOUNT defaults to "0.001" if unset.
+func getPaymentAmount() string {
+	return getConfig().PaymentAmount
+}
+
+// getChainID returns the blockchain chain ID from the active config.
+// CHAIN_ID defaults to 8453 (Base) if unset or invalid.
+func getChainID() int {
+	return getConfig().ChainID
+}
+
+// summarizePrompt is the user message sent to the provider for text, in
+// the length and style p asks for and in its output language when set.
+func summarizePrompt(text string, p GenerationParams) string {
+	if name, ok := languageNames[p.OutputLanguage]; ok {
+		return fmt.Sprintf("%s, writing the summary in %s: %s", summaryInstruction(p), name, text)
+	}
+	return fmt.Sprintf("%s: %s", summaryInstruction(p), text)
+}
+
+// Rate Limiting Functions
+
+// initRateLimiters creates rate limiters for each tier
+func initRateLimiters() map[string]ratelimit.RateLimiter {
+	return newRateLimiters(getConfig())
+}
+
+// newRateLimiters builds one token bucket per tier from a config snapshot.
+func newRateLimiters(cfg *Config) map[string]ratelimit.RateLimiter {
+	cleanupInterval := getEnvAsInt("RATE_LIMIT_CLEANUP_INTERVAL", 300)
+	cleanupTTL := time.Duration(cleanupInterval) * time.Second
+
+	limiters := make(map[string]ratelimit.RateLimiter, len(cfg.RateLimits))
+	for tier, limits := range cfg.RateLimits {
+		limiters[tier] = ratelimit.NewTokenBucket(limits.RPM, limits.Burst, cleanupTTL)
+	}
+	return limiters
+}
+
+// RateLimitMiddleware applies rate limiting to requests
+func RateLimitMiddleware(limiters map[string]ratelimit.RateLimiter) gin.HandlerFunc {
+	return rateLimitMiddleware(func() map[string]ratelimit.RateLimiter { return limiters })
+}
+
+// rateLimitMiddleware applies rate limiting using whichever limiter set the
+// lookup returns, allowing the set to be swapped on config reload.
+func rateLimitMiddleware(lookup func() map[string]ratelimit.RateLimiter) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		// Determine rate limit key and tier
+		key := getRateLimitKey(c)
+		tier := selectRateLimitTier(c)
+		c.Set("rate_limit_tier", tier)
+		decision := rateLimitDecision{limiter: lookup()[tier], key: key, limit: getLimitForTier(tier)}
+
+		// Exemption and override rules replace the tier default, or are
+		// only logged in dry-run mode.
+		cfg := getConfig()
+		if rules := cfg.RateLimitRules; rules != nil {
+			if ruled := rules.decide(c, cfg, tier, decision); ruled.rule != "" {
+				if rules.DryRun {
+					logDryRun(c, ruled)
+				} else {
+					decision = ruled
+				}
+			}
+		}
+		if decision.exempt {
+			c.Next()
+			return
+		}
+		limiter, key := decision.limiter, decision.key
+
+		// Check if request is allowed
+		if !limiter.Allow(key) {
+			retryAfter := calculateRetryAfter(limiter, key)
+			c.Header("Retry-After", strconv.Itoa(retryAfter))
+			setRateLimitHeaders(c, limiter, decision.limit, key, 0)
+			abortWithAPIError(c, newAPIError(CodeRateLimited, "Rate limit exceeded. Please retry later.").with(gin.H{"retry_after": retryAfter}))
+			return
+		}
+
+		// Every response that passes the limiter carries the headers too,
+		// including 402 challenges and cache hits.
+		setRateLimitHeaders(c, limiter, decision.limit, key, limiter.GetRemaining(key))
+
+		c.Next()
+	}
+}
+
+// getRateLimitKey determines the key for rate limiting (nonce/wallet > IP)
+func getRateLimitKey(c *gin.Context) string {
+	signature := c.GetHeader("X-402-Signature")
+	nonce := c.GetHeader("X-402-Nonce")
+
+	// Only use nonce-based key if BOTH signature and nonce are present
+	// This prevents attackers from bypassing IP rate limits with fake nonces
+	if signature != "" && nonce != "" {
+		hash := sha256.Sum256([]byte(nonce))
+		// Use 32 hex chars (128 bits) for better collision resistance
+		return "nonce:" + hex.EncodeToString(hash[:])[:32]
+	}
+
+	return "ip:" + c.ClientIP()
+}
+
+// selectRateLimitTier determines which tier to apply based on request
+func selectRateLimitTier(c *gin.Context) string {
+	// Check if request has signature (authenticated)
+	signature := c.GetHeader("X-402-Signature")
+	nonce := c.GetHeader("X-402-Nonce")
+
+	if signature != "" && nonce != "" {
+		// Premium wallets get the verified tier
+		if resolver := walletTiers.Load(); resolver != nil {
+			isPremium := func(addr common.Address) bool { return resolver.IsVerified(c.Request.Context(), addr) }
+			if isPremiumPayer(c, getConfig(), isPremium) {
+				return "verified"
+			}
+		}
+		return "standard"
+	}
+
+	// Unsigned requests get anonymous tier
+	return "anonymous"
+}
+
+// calculateRetryAfter calculates seconds until rate limit resets
+func calculateRetryAfter(limiter ratelimit.RateLimiter, key string) int {
+	resetTime := limiter.GetResetTime(key)
+	now := time.Now().Unix()
+	retryAfter := int(resetTime - now)
+	if retryAfter < 1 {
+		return 1
+	}
+	return retryAfter
+}
+
+// getLimitForTier returns the RPM limit for a given tier
+func getLimitForTier(tier string) int {
+	if limits, ok := getConfig().RateLimits[tier]; ok {
+		return limits.RPM
+	}
+	return 10
+}
+
+// getRateLimitEnabled checks if rate limiting is enabled
+func getRateLimitEnabled() bool {
+	enabled := strings.ToLower(os.Getenv("RATE_LIMIT_ENABLED"))
+	return enabled == "true" || enabled == "1"
+}
+
+// getEnvAsInt retrieves an environment variable as an integer with a default value
+func getEnvAsInt(key string, defaultValue int) int {
+	valStr := os.Getenv(key)
+	if valStr == "" {
+		return defaultValue
+	}
+	val, err := strconv.Atoi(valStr)
+	if err != nil {
+		log.Printf("Warning: Invalid value for %s: %s, using default %d", key, valStr, defaultValue)
+		return defaultValue
+	}
+	return val
+}
+
+// Receipt Management Functions
+
+var (
+	receiptStoreMu         sync.RWMutex
+	receiptStore           = make(map[string]*receiptEntry)
+	receiptCleanupInterval = 5 * time.Minute
+	// receiptsByRequestHash maps a receipt's RequestHash to the ID of the
+	// latest receipt issued for that request body. Guarded by receiptStoreMu.
+	receiptsByRequestHash = make(map[string]string)
+)
+
+type receiptEntry struct {
+	receipt   *SignedReceipt
+	expiresAt time.Time
+	// cid is the IPFS CID of the archived receipt, if pinned.
+	cid string
+	// archived is set once the receipt is in the cold storage archive.
+	archived bool
+	// margin is the provider usage and margin of the request, once served.
+	margin *ReceiptMargin
+	// shared is the copy of the receipt whose values are held in
+	// receiptBlobs, with RECEIPT_STORE_DEDUPE. Re-signing replaces receipt
+	// but not shared, which is what the pool references are released by.
+	shared *SignedReceipt
+}
+
+// cleanupExpiredReceipts removes expired receipts from the store. With a
+// receipt archive, receipts expiring before the next run are archived first
+// and expired receipts are only removed once archived.
+func cleanupExpiredReceipts() {
+	archiving := newReceiptArchive() != nil
+	if archiving {
+		archiveReceipts(context.Background(), time.Now().Add(receiptCleanupInterval))
+	}
+	now := time.Now()
+	receiptStoreMu.Lock()
+	defer receiptStoreMu.Unlock()
+
+	count := 0
+	for id, entry := range receiptStore {
+		if now.After(entry.expiresAt) && (entry.archived || !archiving) {
+			if entry.shared != nil {
+				releaseReceiptBlobs(entry.shared)
+			}
+			delete(receiptStore, id)
+			count++
+		}
+	}
+	for hash, id := range receiptsByRequestHash {
+		if _, ok := receiptStore[id]; !ok {
+			delete(receiptsByRequestHash, hash)
+		}
+	}
+
+	if count > 0 {
+		log.Printf("Cleaned up %d expired receipts", count)
+	}
+}
+
+// storeReceipt stores a receipt with TTL. With RECEIPT_STORE_DEDUPE the
+// store keeps a copy sharing its repeated values with other receipts.
+// Returns error for future extensibility (Redis/Postgres implementations)
+func storeReceipt(receipt *SignedReceipt, ttl time.Duration) error {
+	// Validate receipt format before storage
+	if err := receipts.Validate(receipt); err != nil {
+		return fmt.Errorf("invalid receipt format: %w", err)
+	}
+	recordTransparencyLeaf(receipt)
+
+	receiptStoreMu.Lock()
+	defer receiptStoreMu.Unlock()
+
+	if old, ok := receiptStore[receipt.Receipt.ID]; ok && old.shared != nil {
+		releaseReceiptBlobs(old.shared)
+	}
+	entry := &receiptEntry{
+		receipt:   receipt,
+		expiresAt: time.Now().Add(ttl),
+	}
+	if getReceiptStoreDedupe() {
+		entry.shared = dedupeReceipt(receipt)
+		entry.receipt = entry.shared
+	}
+	receiptStore[receipt.Receipt.ID] = entry
+	receiptsByRequestHash[entry.receipt.Receipt.Service.RequestHash] = receipt.Receipt.ID
+
+	return nil
+}
+
+// getReceiptByRequestHash retrieves the latest unexpired receipt whose
+// RequestHash is hash.
+func getReceiptByRequestHash(hash string) (*SignedReceipt, bool) {
+	receiptStoreMu.RLock()
+	id, ok := receiptsByRequestHash[hash]
+	receiptStoreMu.RUnlock()
+	if !ok {
+		return nil, false
+	}
+	return getReceipt(id)
+}
+
+// getReceipt retrieves a receipt by ID
+func getReceipt(id string) (*SignedReceipt, bool) {
+	receiptStoreMu.RLock()
+	defer receiptStoreMu.RUnlock()
+
+	entry, exists := receiptStore[id]
+	if !exists {
+		return nil, false
+	}
+
+	// Check if expired
+	if time.Now().After(entry.expiresAt) {
+		return nil, false
+	}
+
+	return entry.receipt, true
+}
+
+// setReceiptCID records the IPFS CID of a stored receipt.
+func setReceiptCID(id, cid string) {
+	receiptStoreMu.Lock()
+	defer receiptStoreMu.Unlock()
+	if entry, ok := receiptStore[id]; ok {
+		entry.cid = cid
+	}
+}
+
+// getReceiptCID returns the IPFS CID of a stored receipt, or "" if it has
+// not been pinned.
+func getReceiptCID(id string) string {
+	receiptStoreMu.RLock()
+	defer receiptStoreMu.RUnlock()
+	if entry, ok := receiptStore[id]; ok {
+		return entry.cid
+	}
+	return ""
+}
+
+// setReceiptMargin records the margin of a stored receipt's request.
+func setReceiptMargin(id string, m *ReceiptMargin) {
+	receiptStoreMu.Lock()
+	defer receiptStoreMu.Unlock()
+	if entry, ok := receiptStore[id]; ok {
+		entry.margin = m
+	}
+}
+
+// getReceiptMargin returns the margin recorded for a stored receipt.
+func getReceiptMargin(id string) (*ReceiptMargin, bool) {
+	receiptStoreMu.RLock()
+	defer receiptStoreMu.RUnlock()
+	if entry, ok := receiptStore[id]; ok && entry.margin != nil {
+		return entry.margin, true
+	}
+	return nil, false
+}
+
+// getReceiptTTL returns configured TTL or default 24h
+func getReceiptTTL() time.Duration {
+	ttlSeconds := getEnvAsInt("RECEIPT_TTL", 86400)
+	return time.Duration(ttlSeconds) * time.Second
+}
+
+// handleGetReceipt handles GET /api/receipts/:id. Receipts that have left
+// the store are fetched from the receipt archive, if enabled.
+func handleGetReceipt(c *gin.Context) {
+	id := c.Param("id")
+	if receipt, exists := getReceipt(id); exists {
+		writeStoredReceipt(c, receipt)
+		return
+	}
+	archived, ok, err := loadArchivedReceipt(c.Request.Context(), id)
+	if err != nil {
+		log.Printf("[ERROR] Failed to load archived receipt %s: %v", id, err)
+		respondError(c, CodeServiceUnavailable, "Receipt archive is unavailable")
+		return
+	}
+	if !ok {
+		respondError(c, CodeReceiptNotFound, "Receipt may have expired or never existed")
+		return
+	}
+	c.Header("X-402-Receipt-Source", "archive")
+	writeReceipt(c, archived.SignedReceipt, archived.CID)
+}
+
+// handleGetReceiptByRequestHash handles GET
+// /api/receipts/by-request-hash/:hash, where hash is the receipt's
+// request_hash with or without its "sha256:" prefix.
+func handleGetReceiptByRequestHash(c *gin.Context) {
+	hash := strings.ToLower(strings.TrimPrefix(c.Param("hash"), "sha256:"))
+	if len(hash) != 64 {
+		respondError(c, CodeInvalidRequest, "hash must be a hex SHA-256 digest")
+		return
+	}
+	if _, err := hex.DecodeString(hash); err != nil {
+		respondError(c, CodeInvalidRequest, "hash must be a hex SHA-256 digest")
+		return
+	}
+
+	receipt, exists := getReceiptByRequestHash("sha256:" + hash)
+	if !exists {
+		respondError(c, CodeReceiptNotFound, "No unexpired receipt was issued for this request")
+		return
+	}
+	writeStoredReceipt(c, receipt)
+}
+
+// writeStoredReceipt renders a stored receipt with its status and CID in the
+// requested receipt_format.
+func writeStoredReceipt(c *gin.Context, receipt *SignedReceipt) {
+	writeReceipt(c, receipt, getReceiptCID(receipt.Receipt.ID))
+}
+
+// writeReceipt renders receipt with its status and IPFS cid in the requested
+// receipt_format.
+func writeReceipt(c *gin.Context, receipt *SignedReceipt, cid string) {
+	id := receipt.Receipt.ID
+	format, ok := receiptFormat(c)
+	if !ok {
+		respondError(c, CodeInvalidRequest, "receipt_format must be json, jws or cose")
+		return
+	}
+	status, rev, err := receiptStatus(c.Request.Context(), id)
+	if err != nil {
+		log.Printf("[ERROR] Failed to check revocation of %s: %v", id, err)
+		respondError(c, CodeServiceUnavailable, "Revocation list is unavailable")
+		return
+	}
+	// Encoded receipts carry no status field, so report it in a header too.
+	c.Header("X-402-Receipt-Status", status)
+	if cid != "" {
+		c.Header("X-402-Receipt-CID", cid)
+	}
+	if setReceiptCacheHeaders(c, receipt, format, rev, cid) {
+		c.Status(http.StatusNotModified)
+		return
+	}
+	if contentType, ok := receiptMediaTypes[format]; ok {
+		data, err := encodeReceipt(receipt, format)
+		if err != nil {
+			respondError(c, CodeReceiptFailed, "Failed to encode receipt")
+			return
+		}
+		c.Data(200, contentType, data)
+		return
+	}
+
+	body := gin.H{
+		"receipt":           receipt.Receipt,
+		"signature":         receipt.Signature,
+		"server_public_key": receipt.ServerPublicKey,
+		"status":            status,
+	}
+	if len(receipt.PreviousSignatures) > 0 {
+		body["previous_signatures"] = receipt.PreviousSignatures
+	}
+	if rev != nil {
+		body["revocation"] = revocationBody(rev)
+	}
+	if cid != "" {
+		body["cid"] = cid
+	}
+	c.JSON(200, body)
+}
+
+// Server private key management
+var (
+	serverPrivateKeyMu  sync.Mutex
+	serverPrivateKey    *ecdsa.PrivateKey
+	serverPrivateKeyErr error
+	// serverPrivateKeyHex is the SERVER_WALLET_PRIVATE_KEY value the cached
+	// key (or error) was parsed from.
+	serverPrivateKeyHex    string
+	serverPrivateKeyLoaded bool
+)
+
+// getServerPrivateKey returns the server's private key, parsed from
+// SERVER_WALLET_PRIVATE_KEY and cached until the variable changes, so a
+// config reload that sets a new key rotates it.
+func getServerPrivateKey() (*ecdsa.PrivateKey, error) {
+	keyHex := os.Getenv("SERVER_WALLET_PRIVATE_KEY")
+	serverPrivateKeyMu.Lock()
+	defer serverPrivateKeyMu.Unlock()
+	if serverPrivateKeyLoaded && keyHex == serverPrivateKeyHex {
+		return serverPrivateKey, serverPrivateKeyErr
+	}
+	serverPrivateKey, serverPrivateKeyErr = parseServerPrivateKey(keyHex)
+	serverPrivateKeyHex, serverPrivateKeyLoaded = keyHex, true
+	if serverPrivateKeyErr == nil {
+		log.Println("Server private key loaded successfully")
+	}
+	return serverPrivateKey, serverPrivateKeyErr
+}
+
+// parseServerPrivateKey parses a hex secp256k1 private key, with or without
+// the 0x prefix.
+func parseServerPrivateKey(keyHex string) (*ecdsa.PrivateKey, error) {
+	if keyHex == "" {
+		return nil, fmt.Errorf("SERVER_WALLET_PRIVATE_KEY not set")
+	}
+
+	// Remove 0x prefix if present
+	keyHex = strings.TrimPrefix(keyHex, "0x")
+
+	keyBytes, err := hex.DecodeString(keyHex)
+	if err != nil {
+		return nil, fmt.Errorf("invalid private key format: %w", err)
+	}
+
+	// Validate minimum key length to prevent trivially weak keys
+	// Keys shorter than 31 bytes are cryptographically insecure or malformed
+	if len(keyBytes) < 31 {
+		return nil, fmt.Errorf("private key too short: got %d bytes, expected at least 31 bytes", len(keyBytes))
+	}
+
+	// Left-pad to 32 bytes if necessary (handles keys with leading zeros like 0x0001...)
+	// Keys between 16-31 bytes are valid but need padding
+	if len(keyBytes) < 32 {
+		padded := make([]byte, 32)
+		copy(padded[32-len(keyBytes):], keyBytes)
+		keyBytes = padded
+	} else if len(keyBytes) > 32 {
+		return nil, fmt.Errorf("private key must be at most 32 bytes, got %d bytes", len(keyBytes))
+	}
+
+	privateKey, err := crypto.ToECDSA(keyBytes)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse private key: %w", err)
+	}
+
+	return privateKey, nil
+}
+
+// handleHealthz implements the liveness probe for the gateway service.
+// It returns a 200 OK status if the server is running and reachable.
+// Response format: {"status": "ok", "service": "gateway", "timestamp": <unix_time>}
+func handleHealthz(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{"status": "ok", "service": "gateway", "timestamp": time.Now().Unix()})
+}
+
+// handleReadyz implements the readiness probe for the gateway service.
+// It performs a comprehensive health check by verifying:
+// 1. Connectivity to the Verifier service
+// 2. Availability of the OpenRouter API
+// 3. Self-health metrics (goroutine count, memory usage)
+// The dependency checks run concurrently and their results are cached for
+// READYZ_CACHE_SECONDS; latency_ms reports how long each one took.
+// Returns 200 OK if all dependencies are healthy, otherwise 503 Service Unavailable.
+func handleReadyz(c *gin.Context) {
+	checks := make(map[string]interface{})
+
+	//1-2. Verifier connectivity and OpenRouter availability
+	deps, checkedAt, cached := readiness.Get(getReadyzCacheTTL())
+	latency := make(map[string]int64, len(deps))
+	for name, dep := range deps {
+		checks[name] = dep.Status
+		latency[name] = dep.Latency.Milliseconds()
+	}
+	verifierStatus := deps["verifier"].Status
+	openRouterStatus := deps["openrouter"].Status
+
+	//3. Self-health metrics
+	var memStats runtime.MemStats
+	runtime.ReadMemStats(&memStats)
+	checks["gateway"] = gin.H{
+		"goroutines":      runtime.NumGoroutine(),
+		"memory_alloc_mb": memStats.Alloc / 1024 / 1024,
+		"memory_sys_mb":   memStats.Sys / 1024 / 1024,
+		"status":          "ok",
+	}
+	// 4. Per-model latency/error stats (informational, does not affect readiness)
+	checks["models"] = modelHealth.Snapshot(getConfig())
+	// 5. Provider circuit and cached-only outage traffic
+	cfg := getConfig()
+	checks["provider_circuit"] = providerCircuit.Status(cfg)
+	// 6. Requests blocked by the IP ACL
+	checks["network_acl"] = networkACLStatus(cfg)
+	// 7. Inputs rejected by content screening
+	checks["moderation"] = moderationStatus(cfg)
+	// 8. Outbound connection reuse per dependency
+	checks["http_clients"] = httpClientStatus()
+	// 9. Overload protection level, priority lanes and shed requests
+	checks["load_shedding"] = shedder.Status(cfg.LoadShed)
+	checks["admission"] = admission.Status(cfg.Admission)
+	// 10. Maintenance mode (paid requests are refused, but the instance
+	// stays ready so clients get the maintenance response)
+	checks["maintenance"] = maintenanceStatus(c.Request.Context(), cfg)
+	// 11. Identical provider calls shared by concurrent requests
+	checks["singleflight"] = summaryFlights.Status()
+	checks["parallel_verify"] = parallelVerifyStatus(cfg)
+	// 12. Response cache backend counters
+	checks["cache"] = responseCache().Stats()
+	// 13. Temporarily banned clients
+	checks["abuse"] = abuseStatus(cfg)
+
+	// 14. Receipt transparency roots
+	checks["transparency"] = transparencyStatus(cfg)
+
+	//Overall status logic. In cached-only mode the gateway can still serve
+	// cache hits while OpenRouter is down, so it stays ready.
+	cachedOnly := cfg.ProviderCircuit.CachedOnly && responseCacheEnabled()
+	ready := verifierStatus == "ok" && (openRouterStatus == "ok" || cachedOnly)
+
+	statusCode := http.StatusOK
+	if !ready {
+		statusCode = http.StatusServiceUnavailable
+	}
+	c.JSON(statusCode, gin.H{
+		"ready":      ready,
+		"timestamp":  time.Now().Unix(),
+		"checks":     checks,
+		"latency_ms": latency,
+		"cached":     cached,
+		"checked_at": checkedAt.Unix(),
+	})
+}
+
+// checkVerifierHealth pings the Verifier service's health endpoint.
+// It uses HEALTH_CHECK_TIMEOUT_SECONDS (default 2s) to prevent hanging.
+// Returns:
+// - "ok": Verifier is healthy (200 OK)
+// - "degraded": Verifier is reachable but returned non-200 status
+// - "unreachable": Verifier could not be contacted
+var checkVerifierHealth = func() string {
+	verifierURL := getVerifierURL()
+	ctx, cancel := context.WithTimeout(context.Background(), getHealthCheckTimeout())
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, "GET", verifierURL+"/health", nil)
+	if err != nil {
+		return "unreachable"
+	}
+	resp, err := getConfig().VerifierHTTPClient().Do(req)
+
+	if err != nil {
+		return "unreachable"
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return "degraded"
+	}
+	return "ok"
+}
+
+// checkOpenRouterHealth checks the availability of the OpenRouter API.
+// It attempts to fetch the list of models with HEALTH_CHECK_TIMEOUT_SECONDS
+// (default 2s) timeout.
+// Returns:
+// - "ok": API is reachable (200 OK)
+// - "unconfigured": OPENROUTER_API_KEY is not set
+// - "degraded": API is reachable but returned non-200 status
+// - "unreachable": API could not be contacted
+var checkOpenRouterHealth = func() string {
+	apiKey := os.Getenv("OPENROUTER_API_KEY")
+	if apiKey == "" {
+		return "unconfigured"
+	}
+	baseURL := os.Getenv("OPENROUTER_URL")
+	if baseURL == "" {
+		baseURL = "https://openrouter.ai"
+	}
+	healthURL := strings.TrimSuffix(baseURL, "/") + "/api/v1/models"
+
+	ctx, cancel := context.WithTimeout(context.Background(), getHealthCheckTimeout())
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, healthURL, nil)
+	if err != nil {
+		return "unreachable"
+	}
+	req.Header.Set("Authorization", "Bearer "+apiKey)
+	resp, err := getConfig().ProviderHTTPClient().Do(req)
+
+	if err != nil {
+		return "unreachable"
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return "degraded"
+	}
+	return "ok"
+}
@@ -1,4 +1,4 @@
-package main
+package server
 
 import (
 	"bytes"
@@ -392,6 +392,7 @@ func TestHandleReadyz_Healthy(t *testing.T) {
 	// stub healthy
 	checkVerifierHealth = func() string { return "ok" }
 	checkOpenRouterHealth = func() string { return "ok" }
+	readiness.Reset()
 
 	r := gin.Default()
 	r.GET("/readyz", handleReadyz)
@@ -433,6 +434,7 @@ func TestHandleReadyz_UnHealthy(t *testing.T) {
 	// one dependency unhealthy
 	checkVerifierHealth = func() string { return "unreachable" }
 	checkOpenRouterHealth = func() string { return "ok" }
+	readiness.Reset()
 
 	r := gin.Default()
 	r.GET("/readyz", handleReadyz)
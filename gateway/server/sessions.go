@@ -0,0 +1,184 @@
+package server
+
+import (
+	"log"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"gateway/receipts"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/gin-gonic/gin"
+)
+
+// sessionIDPattern restricts X-402-Session values to URL-safe IDs.
+var sessionIDPattern = regexp.MustCompile(`^[A-Za-z0-9_-]{1,64}$`)
+
+// receiptSession collects the receipts issued in one X-402-Session. Calls
+// are pending until the next aggregation rolls them into a signed session
+// receipt. A session is bound to the payer, token and chain of its first
+// call.
+type receiptSession struct {
+	payer     string
+	recipient string
+	token     string
+	chainID   int
+
+	pendingIDs    []string
+	pendingLeaves []common.Hash
+	pendingUnits  int64
+	periodStart   time.Time
+
+	aggregates   []*receipts.SignedSessionReceipt
+	lastActivity time.Time
+}
+
+var (
+	receiptSessionsMu sync.Mutex
+	receiptSessions   = make(map[string]*receiptSession)
+)
+
+// getSessionReceiptInterval returns how often pending session calls are
+// aggregated (SESSION_RECEIPT_INTERVAL_SECONDS, default 60).
+func getSessionReceiptInterval() time.Duration {
+	return getPositiveTimeout("SESSION_RECEIPT_INTERVAL_SECONDS", 60)
+}
+
+// requestSessionID returns the request's X-402-Session, or "" without one.
+// A malformed ID is rejected with 400 before payment is taken, and ok is
+// false.
+func requestSessionID(c *gin.Context) (id string, ok bool) {
+	id = c.GetHeader("X-402-Session")
+	if id == "" || sessionIDPattern.MatchString(id) {
+		return id, true
+	}
+	abortWithError(c, CodeSessionInvalid, "X-402-Session must be 1-64 letters, digits, '-' or '_'")
+	return "", false
+}
+
+// addSessionCall adds receipt to session id and reports whether it was
+// added. Receipts for another payer, token or chain than the session's are
+// left out.
+func addSessionCall(id string, receipt *SignedReceipt) bool {
+	if !sessionIDPattern.MatchString(id) {
+		return false
+	}
+	leaf, err := receipts.Hash(receipt.Receipt)
+	if err != nil {
+		log.Printf("[WARNING] Receipt %s not added to session %s: %v", receipt.Receipt.ID, id, err)
+		return false
+	}
+	payment := receipt.Receipt.Payment
+	units, err := parseTokenAmount(payment.Amount)
+	if err != nil {
+		log.Printf("[WARNING] Receipt %s not added to session %s: %v", receipt.Receipt.ID, id, err)
+		return false
+	}
+
+	receiptSessionsMu.Lock()
+	defer receiptSessionsMu.Unlock()
+	s, ok := receiptSessions[id]
+	if !ok {
+		s = &receiptSession{
+			payer:     strings.ToLower(payment.Payer),
+			recipient: payment.Recipient,
+			token:     payment.Token,
+			chainID:   payment.ChainID,
+		}
+		receiptSessions[id] = s
+	}
+	if s.payer != strings.ToLower(payment.Payer) || s.token != payment.Token || s.chainID != payment.ChainID {
+		log.Printf("[WARNING] Receipt %s not added to session %s: payer, token or chain differs from the session's", receipt.Receipt.ID, id)
+		return false
+	}
+	if len(s.pendingIDs) == 0 {
+		s.periodStart = receipt.Receipt.Timestamp
+	}
+	s.pendingIDs = append(s.pendingIDs, receipt.Receipt.ID)
+	s.pendingLeaves = append(s.pendingLeaves, leaf)
+	s.pendingUnits += units
+	s.lastActivity = time.Now()
+	return true
+}
+
+// aggregateSessions issues a session receipt for every session with pending
+// calls and forgets sessions idle for longer than the receipt TTL.
+func aggregateSessions() {
+	signer, err := getServerSigner()
+	if err != nil {
+		log.Printf("[WARNING] Session receipts not issued: %v", err)
+		return
+	}
+	now := time.Now().UTC()
+	ttl := getReceiptTTL()
+
+	receiptSessionsMu.Lock()
+	defer receiptSessionsMu.Unlock()
+	for id, s := range receiptSessions {
+		if len(s.pendingIDs) == 0 {
+			if now.Sub(s.lastActivity) > ttl {
+				delete(receiptSessions, id)
+			}
+			continue
+		}
+		receiptID, err := receipts.NewSessionReceiptID()
+		if err != nil {
+			log.Printf("[WARNING] Session %s receipt not issued: %v", id, err)
+			continue
+		}
+		aggregate := receipts.SessionReceipt{
+			ID:          receiptID,
+			Version:     receipts.Version1,
+			SessionID:   id,
+			Sequence:    int64(len(s.aggregates) + 1),
+			Timestamp:   now,
+			PeriodStart: s.periodStart.UTC(),
+			PeriodEnd:   now,
+			Payer:       s.payer,
+			Recipient:   s.recipient,
+			Token:       s.token,
+			ChainID:     s.chainID,
+			Count:       len(s.pendingIDs),
+			TotalAmount: formatTokenAmount(s.pendingUnits),
+			MerkleRoot:  receipts.MerkleRoot(s.pendingLeaves).Hex(),
+			ReceiptIDs:  s.pendingIDs,
+		}
+		if n := len(s.aggregates); n > 0 {
+			aggregate.Previous = s.aggregates[n-1].Receipt.ID
+		}
+		signed, err := receipts.SignSession(aggregate, signer)
+		if err != nil {
+			log.Printf("[WARNING] Session %s receipt not issued: %v", id, err)
+			continue
+		}
+		s.aggregates = append(s.aggregates, signed)
+		s.pendingIDs, s.pendingLeaves, s.pendingUnits = nil, nil, 0
+	}
+}
+
+// handleGetSession handles GET /api/receipts/sessions/:sessionId with the
+// session's signed aggregate receipts, oldest first, and how many calls are
+// waiting for the next one.
+func handleGetSession(c *gin.Context) {
+	id := c.Param("sessionId")
+	receiptSessionsMu.Lock()
+	s, ok := receiptSessions[id]
+	var resp gin.H
+	if ok {
+		resp = gin.H{
+			"session_id":                   id,
+			"payer":                        s.payer,
+			"pending_calls":                len(s.pendingIDs),
+			"aggregates":                   append([]*receipts.SignedSessionReceipt{}, s.aggregates...),
+			"aggregation_interval_seconds": int(getSessionReceiptInterval().Seconds()),
+		}
+	}
+	receiptSessionsMu.Unlock()
+	if !ok {
+		respondError(c, CodeSessionNotFound, "Session may have expired or never existed")
+		return
+	}
+	c.JSON(200, resp)
+}
@@ -0,0 +1,142 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"gateway/internal/testsupport"
+	"gateway/receipts"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+// withReceiptSessions gives the test an empty session store.
+func withReceiptSessions(t *testing.T) {
+	t.Helper()
+	receiptSessionsMu.Lock()
+	prev := receiptSessions
+	receiptSessions = make(map[string]*receiptSession)
+	receiptSessionsMu.Unlock()
+	t.Cleanup(func() {
+		receiptSessionsMu.Lock()
+		receiptSessions = prev
+		receiptSessionsMu.Unlock()
+	})
+}
+
+// postInSession sends a paid summarize request with X-402-Session set.
+func postInSession(t *testing.T, h *testsupport.Harness, session, nonce string) *http.Response {
+	t.Helper()
+	req, err := http.NewRequest(http.MethodPost, h.Server.URL+"/api/ai/summarize", bytes.NewBufferString(`{"text":"session call `+nonce+`"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-402-Signature", "0xsig")
+	req.Header.Set("X-402-Nonce", nonce)
+	req.Header.Set("X-402-Session", session)
+	resp, err := h.Server.Client().Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { resp.Body.Close() })
+	return resp
+}
+
+// sessionView is the body of GET /api/receipts/sessions/:sessionId.
+type sessionView struct {
+	Payer        string                          `json:"payer"`
+	PendingCalls int                             `json:"pending_calls"`
+	Aggregates   []receipts.SignedSessionReceipt `json:"aggregates"`
+}
+
+// getSession fetches GET /api/receipts/sessions/:sessionId.
+func getSession(t *testing.T, h *testsupport.Harness, session string) (int, sessionView) {
+	t.Helper()
+	var body sessionView
+	resp := h.Get(t, "/api/receipts/sessions/"+session)
+	json.NewDecoder(resp.Body).Decode(&body)
+	return resp.StatusCode, body
+}
+
+func TestSessionReceipts_AggregateCalls(t *testing.T) {
+	withReceiptSessions(t)
+	h := testsupport.NewHarness(t, newTestRouter)
+
+	var leaves []common.Hash
+	var ids []string
+	for i := range 3 {
+		resp := postInSession(t, h, "sess-1", fmt.Sprintf("nonce-session-%d", i))
+		if resp.StatusCode != http.StatusOK || resp.Header.Get("X-402-Session") != "sess-1" {
+			t.Fatalf("expected the call to join the session, got %d %q", resp.StatusCode, resp.Header.Get("X-402-Session"))
+		}
+		receipt := decodeReceiptHeader(t, resp.Header.Get("X-402-Receipt"))
+		leaf, _ := receipts.Hash(receipt.Receipt)
+		leaves = append(leaves, leaf)
+		ids = append(ids, receipt.Receipt.ID)
+	}
+
+	status, session := getSession(t, h, "sess-1")
+	if status != http.StatusOK || session.PendingCalls != 3 || len(session.Aggregates) != 0 {
+		t.Fatalf("expected three pending calls, got %d %+v", status, session)
+	}
+
+	aggregateSessions()
+	_, session = getSession(t, h, "sess-1")
+	if session.PendingCalls != 0 || len(session.Aggregates) != 1 {
+		t.Fatalf("expected one aggregate, got %+v", session)
+	}
+	first := session.Aggregates[0]
+	if err := receipts.VerifySession(&first, nil); err != nil {
+		t.Fatalf("aggregate does not verify: %v", err)
+	}
+	amount, _ := parseTokenAmount(getConfig().PaymentAmount)
+	if first.Receipt.Count != 3 || first.Receipt.TotalAmount != formatTokenAmount(3*amount) || first.Receipt.Sequence != 1 {
+		t.Errorf("unexpected aggregate %+v", first.Receipt)
+	}
+	if first.Receipt.MerkleRoot != receipts.MerkleRoot(leaves).Hex() {
+		t.Errorf("merkle root %s does not cover the call receipts", first.Receipt.MerkleRoot)
+	}
+	if fmt.Sprint(first.Receipt.ReceiptIDs) != fmt.Sprint(ids) {
+		t.Errorf("expected receipt IDs %v, got %v", ids, first.Receipt.ReceiptIDs)
+	}
+
+	// The next period is chained to the first and nothing is issued for an
+	// idle period.
+	postInSession(t, h, "sess-1", "nonce-session-next")
+	aggregateSessions()
+	aggregateSessions()
+	_, session = getSession(t, h, "sess-1")
+	if len(session.Aggregates) != 2 || session.Aggregates[1].Receipt.Previous != first.Receipt.ID || session.Aggregates[1].Receipt.Count != 1 {
+		t.Errorf("expected a second aggregate chained to the first, got %+v", session.Aggregates)
+	}
+}
+
+func TestSessionReceipts_Isolation(t *testing.T) {
+	withReceiptSessions(t)
+	h := testsupport.NewHarness(t, newTestRouter)
+
+	if resp := postInSession(t, h, "not a valid id!", "nonce-session-bad"); resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("expected 400 for a malformed session ID, got %d", resp.StatusCode)
+	}
+	if h.Verifier.Calls() != 0 {
+		t.Error("a malformed session ID should be rejected before payment")
+	}
+
+	postInSession(t, h, "sess-owned", "nonce-session-owner")
+	h.Verifier.SetValid("0x00000000000000000000000000000000000000aa")
+	resp := postInSession(t, h, "sess-owned", "nonce-session-other")
+	if resp.StatusCode != http.StatusOK || resp.Header.Get("X-402-Session") != "" {
+		t.Errorf("another payer's call should succeed outside the session, got %d %q", resp.StatusCode, resp.Header.Get("X-402-Session"))
+	}
+	if _, session := getSession(t, h, "sess-owned"); session.PendingCalls != 1 {
+		t.Errorf("expected only the owner's call in the session, got %+v", session)
+	}
+
+	if status, _ := getSession(t, h, "sess-unknown"); status != http.StatusNotFound {
+		t.Errorf("expected 404 for an unknown session, got %d", status)
+	}
+}
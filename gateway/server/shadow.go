@@ -0,0 +1,343 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"math/rand/v2"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+
+	"github.com/gin-gonic/gin"
+)
+
+// shadowComparisonsKey is the Redis list of stored shadow comparisons,
+// newest first.
+const shadowComparisonsKey = "shadow:comparisons"
+
+// ShadowConfig mirrors a share of paid summaries to a canary model for
+// offline comparison. An empty Model disables shadowing. Percent is the
+// share of summaries mirrored (0-100), Timeout bounds each shadow call, and
+// up to MaxStored comparisons are kept for Retention.
+type ShadowConfig struct {
+	Model     string
+	Percent   float64
+	Timeout   time.Duration
+	Retention time.Duration
+	MaxStored int
+}
+
+// Enabled reports whether any summaries are mirrored.
+func (sc ShadowConfig) Enabled() bool {
+	return sc.Model != "" && sc.Percent > 0
+}
+
+// validate reports a share outside 0-100 or nothing to keep.
+func (sc ShadowConfig) validate() error {
+	if sc.Model == "" {
+		return nil
+	}
+	if sc.Percent < 0 || sc.Percent > 100 {
+		return fmt.Errorf("SHADOW_PERCENT must be between 0 and 100, got %v", sc.Percent)
+	}
+	if sc.Timeout <= 0 || sc.Retention <= 0 || sc.MaxStored <= 0 {
+		return fmt.Errorf("shadow timeout, retention and max comparisons must be positive")
+	}
+	return nil
+}
+
+// ShadowComparison is one paid summary and the shadow model's answer to the
+// same input. Similarity is the Jaccard similarity of their word sets and
+// LengthRatio the shadow's word count over the primary's; both are zero
+// when the shadow call failed.
+type ShadowComparison struct {
+	Timestamp      time.Time `json:"timestamp"`
+	ReceiptID      string    `json:"receipt_id"`
+	RequestHash    string    `json:"request_hash"`
+	PrimaryModel   string    `json:"primary_model"`
+	ShadowModel    string    `json:"shadow_model"`
+	Primary        string    `json:"primary"`
+	Shadow         string    `json:"shadow,omitempty"`
+	Error          string    `json:"error,omitempty"`
+	PrimaryLatency int64     `json:"primary_latency_ms"`
+	ShadowLatency  int64     `json:"shadow_latency_ms"`
+	Similarity     float64   `json:"similarity"`
+	LengthRatio    float64   `json:"length_ratio"`
+	ExactMatch     bool      `json:"exact_match"`
+	ShadowCostUSD  float64   `json:"shadow_cost_usd"`
+}
+
+// shadowMetrics aggregates the comparisons recorded by this instance.
+type shadowMetrics struct {
+	mu             sync.Mutex
+	sampled        int64
+	compared       int64
+	shadowFailed   int64
+	discarded      int64
+	exactMatches   int64
+	similarity     float64
+	lengthRatio    float64
+	primaryLatency int64
+	shadowLatency  int64
+	costUSD        float64
+}
+
+var (
+	shadowStats = &shadowMetrics{}
+
+	// shadowStore holds comparisons newest first when Redis is not
+	// connected.
+	shadowStoreMu sync.Mutex
+	shadowStore   []ShadowComparison
+)
+
+// record adds a finished comparison.
+func (m *shadowMetrics) record(cmp ShadowComparison) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.costUSD += cmp.ShadowCostUSD
+	if cmp.Error != "" {
+		m.shadowFailed++
+		return
+	}
+	m.compared++
+	if cmp.ExactMatch {
+		m.exactMatches++
+	}
+	m.similarity += cmp.Similarity
+	m.lengthRatio += cmp.LengthRatio
+	m.primaryLatency += cmp.PrimaryLatency
+	m.shadowLatency += cmp.ShadowLatency
+}
+
+// Report returns the divergence metrics: how often the models agree
+// exactly, their mean word similarity and length ratio, and latencies.
+func (m *shadowMetrics) Report() gin.H {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	report := gin.H{
+		"sampled":         m.sampled,
+		"compared":        m.compared,
+		"shadow_failed":   m.shadowFailed,
+		"discarded":       m.discarded,
+		"shadow_cost_usd": m.costUSD,
+	}
+	if m.compared > 0 {
+		n := float64(m.compared)
+		report["exact_match_rate"] = float64(m.exactMatches) / n
+		report["mean_similarity"] = m.similarity / n
+		report["mean_divergence"] = 1 - m.similarity/n
+		report["mean_length_ratio"] = m.lengthRatio / n
+		report["mean_primary_latency_ms"] = float64(m.primaryLatency) / n
+		report["mean_shadow_latency_ms"] = float64(m.shadowLatency) / n
+	}
+	return report
+}
+
+// shadowRun is a shadow call started alongside a paid summary. The handler
+// reports the primary answer with Primary and hands the run off with Done;
+// the comparison is made and stored once both answers are in.
+type shadowRun struct {
+	cfg     ShadowConfig
+	start   time.Time
+	result  chan shadowOutcome
+	primary *ShadowComparison
+}
+
+type shadowOutcome struct {
+	res providerResult
+	err error
+	at  time.Time
+}
+
+// startShadow samples the request for shadowing and, if chosen, starts the
+// shadow model on text in the background. It returns nil otherwise. The
+// shadow call is not charged, cached or retried, and does not count towards
+// the provider circuit.
+func startShadow(c *gin.Context, text string, params GenerationParams) *shadowRun {
+	gatewayCfg := getConfig()
+	cfg := gatewayCfg.Shadow
+	if !cfg.Enabled() || rand.Float64()*100 >= cfg.Percent {
+		return nil
+	}
+	shadowStats.mu.Lock()
+	shadowStats.sampled++
+	shadowStats.mu.Unlock()
+
+	run := &shadowRun{cfg: cfg, start: time.Now(), result: make(chan shadowOutcome, 1)}
+	provider := gatewayCfg.AIProviders[0]
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(c.Request.Context()), cfg.Timeout)
+	go func() {
+		defer cancel()
+		summary, usage, err := callProvider(ctx, provider, cfg.Model, text, params)
+		run.result <- shadowOutcome{res: providerResult{Summary: summary, Usage: usage, Model: cfg.Model}, err: err, at: time.Now()}
+	}()
+	return run
+}
+
+// Primary records the paid answer and how long it took.
+func (r *shadowRun) Primary(res providerResult, latency time.Duration) {
+	if r == nil {
+		return
+	}
+	r.primary = &ShadowComparison{
+		PrimaryModel:   res.Model,
+		Primary:        res.Summary,
+		PrimaryLatency: latency.Milliseconds(),
+	}
+}
+
+// Done hands the run off once the response has been sent. Runs whose
+// summary failed or was not receipted are discarded.
+func (r *shadowRun) Done(c *gin.Context) {
+	if r == nil {
+		return
+	}
+	v, ok := c.Get("issued_receipt")
+	if r.primary == nil || !ok {
+		shadowStats.mu.Lock()
+		shadowStats.discarded++
+		shadowStats.mu.Unlock()
+		return
+	}
+	cmp := *r.primary
+	receipt := v.(*SignedReceipt).Receipt
+	cmp.ReceiptID, cmp.RequestHash = receipt.ID, receipt.Service.RequestHash
+	go func() {
+		out := <-r.result
+		cmp.Timestamp = time.Now().UTC()
+		cmp.ShadowModel = r.cfg.Model
+		cmp.ShadowLatency = out.at.Sub(r.start).Milliseconds()
+		cmp.ShadowCostUSD = out.res.Usage.Cost
+		if out.err != nil {
+			cmp.Error = out.err.Error()
+		} else {
+			cmp.Shadow = out.res.Summary
+			cmp.ExactMatch = cmp.Shadow == cmp.Primary
+			cmp.Similarity, cmp.LengthRatio = compareSummaries(cmp.Primary, cmp.Shadow)
+		}
+		shadowStats.record(cmp)
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := storeShadowComparison(ctx, r.cfg, cmp); err != nil {
+			log.Printf("[WARNING] Failed to store shadow comparison: %v", err)
+		}
+	}()
+}
+
+// summaryWords splits s into lowercased words.
+func summaryWords(s string) []string {
+	return strings.FieldsFunc(strings.ToLower(s), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsNumber(r)
+	})
+}
+
+// compareSummaries returns the Jaccard similarity of the word sets of
+// primary and shadow and the ratio of their word counts.
+func compareSummaries(primary, shadow string) (similarity, lengthRatio float64) {
+	a, b := summaryWords(primary), summaryWords(shadow)
+	if len(a) == 0 && len(b) == 0 {
+		return 1, 1
+	}
+	set := make(map[string]uint8, len(a)+len(b))
+	for _, w := range a {
+		set[w] |= 1
+	}
+	for _, w := range b {
+		set[w] |= 2
+	}
+	both := 0
+	for _, in := range set {
+		if in == 3 {
+			both++
+		}
+	}
+	similarity = float64(both) / float64(len(set))
+	if len(a) > 0 {
+		lengthRatio = float64(len(b)) / float64(len(a))
+	}
+	return similarity, lengthRatio
+}
+
+// storeShadowComparison keeps cmp for offline comparison: in a capped Redis
+// list expiring Retention after the last write when connected, else in
+// memory.
+func storeShadowComparison(ctx context.Context, cfg ShadowConfig, cmp ShadowComparison) error {
+	if redisClient != nil {
+		data, err := json.Marshal(cmp)
+		if err != nil {
+			return err
+		}
+		pipe := redisClient.TxPipeline()
+		pipe.LPush(ctx, shadowComparisonsKey, data)
+		pipe.LTrim(ctx, shadowComparisonsKey, 0, int64(cfg.MaxStored-1))
+		pipe.Expire(ctx, shadowComparisonsKey, cfg.Retention)
+		_, err = pipe.Exec(ctx)
+		return err
+	}
+	shadowStoreMu.Lock()
+	defer shadowStoreMu.Unlock()
+	shadowStore = append([]ShadowComparison{cmp}, shadowStore...)
+	if len(shadowStore) > cfg.MaxStored {
+		shadowStore = shadowStore[:cfg.MaxStored]
+	}
+	return nil
+}
+
+// listShadowComparisons returns up to limit stored comparisons within
+// Retention, newest first.
+func listShadowComparisons(ctx context.Context, cfg ShadowConfig, limit int) ([]ShadowComparison, error) {
+	list := []ShadowComparison{}
+	cutoff := time.Now().Add(-cfg.Retention)
+	if redisClient != nil {
+		raw, err := redisClient.LRange(ctx, shadowComparisonsKey, 0, int64(limit-1)).Result()
+		if err != nil {
+			return nil, err
+		}
+		for _, data := range raw {
+			var cmp ShadowComparison
+			if json.Unmarshal([]byte(data), &cmp) == nil && cmp.Timestamp.After(cutoff) {
+				list = append(list, cmp)
+			}
+		}
+		return list, nil
+	}
+	shadowStoreMu.Lock()
+	defer shadowStoreMu.Unlock()
+	for _, cmp := range shadowStore {
+		if len(list) == limit || !cmp.Timestamp.After(cutoff) {
+			break
+		}
+		list = append(list, cmp)
+	}
+	return list, nil
+}
+
+// handleShadowReport handles GET /api/admin/shadow: the shadow settings,
+// this instance's divergence metrics and the stored comparisons (limit
+// 1-1000, default 50), newest first.
+func handleShadowReport(c *gin.Context) {
+	cfg := getConfig().Shadow
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", "50"))
+	if err != nil || limit < 1 || limit > 1000 {
+		respondError(c, CodeInvalidRequest, "limit must be between 1 and 1000")
+		return
+	}
+	comparisons, err := listShadowComparisons(c.Request.Context(), cfg, limit)
+	if err != nil {
+		log.Printf("[ERROR] Failed to list shadow comparisons: %v", err)
+		respondError(c, CodeServiceUnavailable, "Shadow comparisons are unavailable")
+		return
+	}
+	c.JSON(200, gin.H{
+		"enabled":     cfg.Enabled(),
+		"model":       cfg.Model,
+		"percent":     cfg.Percent,
+		"metrics":     shadowStats.Report(),
+		"comparisons": comparisons,
+	})
+}
@@ -0,0 +1,126 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	"gateway/internal/testsupport"
+)
+
+// withShadowState resets the shadow metrics and in-memory store for the
+// duration of the test.
+func withShadowState(t *testing.T) {
+	t.Helper()
+	prevStats := shadowStats
+	shadowStoreMu.Lock()
+	prevStore := shadowStore
+	shadowStore = nil
+	shadowStoreMu.Unlock()
+	shadowStats = &shadowMetrics{}
+	t.Cleanup(func() {
+		shadowStats = prevStats
+		shadowStoreMu.Lock()
+		shadowStore = prevStore
+		shadowStoreMu.Unlock()
+	})
+}
+
+// waitForShadowComparisons polls the store until n comparisons are in.
+func waitForShadowComparisons(t *testing.T, n int) []ShadowComparison {
+	t.Helper()
+	cfg := getConfig().Shadow
+	for deadline := time.Now().Add(2 * time.Second); ; time.Sleep(10 * time.Millisecond) {
+		list, _ := listShadowComparisons(context.Background(), cfg, 100)
+		if len(list) >= n || time.Now().After(deadline) {
+			return list
+		}
+	}
+}
+
+func TestCompareSummaries(t *testing.T) {
+	cases := []struct {
+		primary, shadow string
+		similarity      float64
+		ratio           float64
+	}{
+		{"The cat sat.", "the cat sat", 1, 1},
+		{"a b c d", "a b", 0.5, 0.5},
+		{"alpha beta", "gamma delta", 0, 1},
+		{"", "", 1, 1},
+		{"", "words", 0, 0},
+	}
+	for _, tc := range cases {
+		similarity, ratio := compareSummaries(tc.primary, tc.shadow)
+		if similarity != tc.similarity || ratio != tc.ratio {
+			t.Errorf("compareSummaries(%q, %q) = %v, %v; want %v, %v", tc.primary, tc.shadow, similarity, ratio, tc.similarity, tc.ratio)
+		}
+	}
+}
+
+func TestShadowConfig_Validate(t *testing.T) {
+	ok := ShadowConfig{Model: "canary/model", Percent: 10, Timeout: time.Second, Retention: time.Hour, MaxStored: 10}
+	if err := ok.validate(); err != nil {
+		t.Errorf("expected a valid config, got %v", err)
+	}
+	if err := (ShadowConfig{}).validate(); err != nil {
+		t.Errorf("expected shadowing to be optional, got %v", err)
+	}
+	bad := ok
+	bad.Percent = 101
+	if bad.validate() == nil {
+		t.Error("expected a share above 100 to be refused")
+	}
+	bad = ok
+	bad.MaxStored = 0
+	if bad.validate() == nil {
+		t.Error("expected nothing to keep to be refused")
+	}
+}
+
+func TestShadow_MirrorsPaidSummaries(t *testing.T) {
+	withShadowState(t)
+	h := testsupport.NewHarness(t, newTestRouter)
+	t.Setenv("SHADOW_MODEL", "canary/model")
+	t.Setenv("SHADOW_PERCENT", "100")
+	t.Setenv("ADMIN_API_KEY", "s3cret")
+	h.AI.SetUsage(100, 20)
+
+	receipt := decodeReceiptHeader(t, paidReceipt(t, h, "nonce-shadow-1"))
+	list := waitForShadowComparisons(t, 1)
+	if len(list) != 1 {
+		t.Fatalf("expected one comparison, got %d", len(list))
+	}
+	cmp := list[0]
+	if cmp.ReceiptID != receipt.Receipt.ID || cmp.ShadowModel != "canary/model" || !cmp.ExactMatch || cmp.Similarity != 1 {
+		t.Errorf("expected a matching comparison for the receipt, got %+v", cmp)
+	}
+	if models := h.AI.Models(); len(models) != 2 || (models[0] != "canary/model" && models[1] != "canary/model") {
+		t.Errorf("expected one primary and one shadow call, got %v", models)
+	}
+
+	// A failed summary is not compared.
+	h.AI.SetStatus(http.StatusInternalServerError)
+	h.Post(t, "/api/ai/summarize", `{"text":"hello"}`, "0xsig", "nonce-shadow-2")
+	h.AI.SetStatus(http.StatusOK)
+
+	w := adminGet(t, newTestRouter(), "/api/admin/shadow?limit=10", "s3cret")
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", w.Code)
+	}
+	var out struct {
+		Enabled     bool               `json:"enabled"`
+		Metrics     map[string]float64 `json:"metrics"`
+		Comparisons []ShadowComparison `json:"comparisons"`
+	}
+	json.Unmarshal(w.Body.Bytes(), &out)
+	if !out.Enabled || len(out.Comparisons) != 1 || out.Metrics["compared"] != 1 || out.Metrics["exact_match_rate"] != 1 || out.Metrics["discarded"] != 1 {
+		t.Errorf("unexpected shadow report: %s", w.Body.String())
+	}
+
+	if w := adminGet(t, newTestRouter(), "/api/admin/shadow?limit=0", "s3cret"); w.Code != http.StatusBadRequest {
+		t.Errorf("expected 400 for a bad limit, got %d", w.Code)
+	}
+}
@@ -0,0 +1,190 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+
+	"gateway/payments"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/gin-gonic/gin"
+)
+
+// SignatureConfig lists the X-402-Signature-Type values clients may use,
+// the JSON-RPC endpoint of each chain EIP-1271 wallets are called on, and
+// the ERC-3009 token of each chain.
+type SignatureConfig struct {
+	Types   []string
+	RPCURLs map[int]string
+	Tokens  map[int]payments.TokenDomain
+	// Relay submits verified ERC-3009 authorizations on-chain through the
+	// chain's RelayRPCURLs entry.
+	Relay        bool
+	RelayRPCURLs map[int]string
+
+	// tokensErr holds an ERC3009_TOKENS parse error and relayRPCErr an
+	// ERC3009_RELAY_RPC_URLS one.
+	tokensErr   error
+	relayRPCErr error
+}
+
+// SignatureScheme verifies payment signatures of one X-402-Signature-Type.
+// payer is the X-402-Payer address the client claims, possibly empty. A
+// signature that does not check out is an invalid response, not an error;
+// errors are reserved for failures to reach whatever does the checking.
+type SignatureScheme interface {
+	Verify(ctx context.Context, payment PaymentContext, signature, payer string) (*VerifyResponse, error)
+}
+
+// signatureSchemes maps each signature type to its verifier.
+var signatureSchemes = map[string]SignatureScheme{
+	payments.SignatureTypeEIP712:       verifierScheme{},
+	payments.SignatureTypePersonalSign: personalSignScheme{},
+	payments.SignatureTypeEIP1271:      eip1271Scheme{},
+	payments.SignatureTypeERC3009:      erc3009Scheme{},
+}
+
+// verifierScheme checks EIP-712 signatures with the verifier service.
+type verifierScheme struct{}
+
+func (verifierScheme) Verify(ctx context.Context, payment PaymentContext, signature, _ string) (*VerifyResponse, error) {
+	return newVerifierClient().Verify(ctx, payment, signature)
+}
+
+// personalSignScheme recovers personal_sign signatures locally; the verifier
+// service only knows EIP-712.
+type personalSignScheme struct{}
+
+func (personalSignScheme) Verify(_ context.Context, payment PaymentContext, signature, _ string) (*VerifyResponse, error) {
+	signer, err := payments.RecoverPersonalSigner(payment, signature)
+	if err != nil {
+		return &VerifyResponse{Error: err.Error()}, nil
+	}
+	return &VerifyResponse{IsValid: true, RecoveredAddress: signer.Hex()}, nil
+}
+
+// eip1271Scheme asks the payer's wallet contract, through isValidSignature,
+// whether signature authorizes the EIP-712 digest of payment. Contract
+// wallets cannot be recovered from, so the payer must be named.
+type eip1271Scheme struct{}
+
+func (eip1271Scheme) Verify(ctx context.Context, payment PaymentContext, signature, payer string) (*VerifyResponse, error) {
+	if !common.IsHexAddress(payer) {
+		return &VerifyResponse{Error: "X-402-Payer must name the contract wallet for eip1271 signatures"}, nil
+	}
+	rpcURL, ok := getConfig().Signatures.RPCURLs[payment.ChainID]
+	if !ok {
+		return &VerifyResponse{Error: fmt.Sprintf("eip1271 signatures are not accepted on chain %d", payment.ChainID)}, nil
+	}
+	hash, err := payments.Hash(payment)
+	if err != nil {
+		return &VerifyResponse{Error: err.Error()}, nil
+	}
+	data, err := payments.IsValidSignatureCalldata(hash, signature)
+	if err != nil {
+		return &VerifyResponse{Error: err.Error()}, nil
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, getVerifierTimeout())
+	defer cancel()
+	result, err := ethCall(ctx, rpcURL, payer, data)
+	var rpcErr *rpcError
+	if errors.As(err, &rpcErr) {
+		// A revert: not a contract, or one that rejects the signature.
+		return &VerifyResponse{Error: "isValidSignature reverted: " + rpcErr.Message}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("eip1271 call failed: %w", err)
+	}
+	if out := common.FromHex(result); len(out) < 4 || !bytes.Equal(out[:4], payments.EIP1271MagicValue[:]) {
+		return &VerifyResponse{Error: "the wallet contract rejected the signature"}, nil
+	}
+	return &VerifyResponse{IsValid: true, RecoveredAddress: common.HexToAddress(payer).Hex()}, nil
+}
+
+// parseRPCURLs parses EIP1271_RPC_URLS or ERC3009_RELAY_RPC_URLS, a
+// comma-separated list of "<chain name or id>=<url>" entries.
+func parseRPCURLs(raw []string) (map[int]string, error) {
+	urls := make(map[int]string)
+	for _, entry := range raw {
+		ref, url, ok := strings.Cut(entry, "=")
+		url = strings.TrimSpace(url)
+		if !ok || url == "" {
+			return nil, fmt.Errorf("RPC URL %q must be chain=url", entry)
+		}
+		id, err := parseChainRef(strings.TrimSpace(ref))
+		if err != nil {
+			return nil, err
+		}
+		urls[id] = url
+	}
+	return urls, nil
+}
+
+// validate rejects unknown signature types, EIP-1271 without any RPC URL
+// and an ERC-3009 relay without a relayer key or RPC URL.
+func (sc SignatureConfig) validate() error {
+	if sc.tokensErr != nil {
+		return fmt.Errorf("invalid ERC3009_TOKENS: %w", sc.tokensErr)
+	}
+	if sc.relayRPCErr != nil {
+		return fmt.Errorf("invalid ERC3009_RELAY_RPC_URLS: %w", sc.relayRPCErr)
+	}
+	if len(sc.Types) == 0 {
+		return fmt.Errorf("SIGNATURE_TYPES must list at least one type")
+	}
+	for _, t := range sc.Types {
+		if _, ok := signatureSchemes[t]; !ok {
+			return fmt.Errorf("unknown signature type %q in SIGNATURE_TYPES", t)
+		}
+	}
+	if slices.Contains(sc.Types, payments.SignatureTypeEIP1271) && len(sc.RPCURLs) == 0 {
+		return fmt.Errorf("eip1271 signatures need EIP1271_RPC_URLS")
+	}
+	if sc.Relay {
+		if !slices.Contains(sc.Types, payments.SignatureTypeERC3009) {
+			return fmt.Errorf("ERC3009_RELAY_ENABLED needs erc3009 in SIGNATURE_TYPES")
+		}
+		if len(sc.RelayRPCURLs) == 0 {
+			return fmt.Errorf("ERC3009_RELAY_ENABLED needs ERC3009_RELAY_RPC_URLS")
+		}
+		if _, err := erc3009RelayerKey(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// requestSignatureType returns the X-402-Signature-Type of the request,
+// defaulting to EIP-712. An unaccepted type aborts with 400 listing the
+// accepted ones and returns false.
+func requestSignatureType(c *gin.Context) (string, bool) {
+	sigType := strings.ToLower(strings.TrimSpace(c.GetHeader("X-402-Signature-Type")))
+	if sigType == "" {
+		sigType = payments.SignatureTypeEIP712
+	}
+	accepted := getConfig().Signatures.Types
+	if !slices.Contains(accepted, sigType) {
+		abortWithAPIError(c, newAPIError(CodeSignatureTypeUnsupported, fmt.Sprintf("Signature type %q is not accepted", sigType)).
+			with(gin.H{"accepted": accepted}))
+		return "", false
+	}
+	return sigType, true
+}
+
+// verifySignature checks signature with the scheme for sigType. When the
+// client named a payer, the verified signer must be that address.
+func verifySignature(ctx context.Context, sigType string, payment PaymentContext, signature, payer string) (*VerifyResponse, error) {
+	resp, err := signatureSchemes[sigType].Verify(ctx, payment, signature, payer)
+	if err != nil {
+		return nil, err
+	}
+	if resp.IsValid && payer != "" && !strings.EqualFold(resp.RecoveredAddress, payer) {
+		return &VerifyResponse{Error: "the signer does not match X-402-Payer", Reason: payments.ReasonSignerMismatch}, nil
+	}
+	return resp, nil
+}
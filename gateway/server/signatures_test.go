@@ -0,0 +1,223 @@
+package server
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"gateway/internal/testsupport"
+	"gateway/payments"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+// offeredPayment returns the payment context offered in a 402.
+func offeredPayment(t *testing.T, h *testsupport.Harness) PaymentContext {
+	t.Helper()
+	var challenge struct {
+		PaymentContext PaymentContext `json:"paymentContext"`
+	}
+	resp := h.Post(t, "/api/ai/summarize", `{"text":"hello"}`, "", "")
+	if err := json.NewDecoder(resp.Body).Decode(&challenge); err != nil {
+		t.Fatal(err)
+	}
+	payment := challenge.PaymentContext
+	payment.Expiry, payment.QuoteSignature = 0, ""
+	return payment
+}
+
+// postPayment sends a v2 summarize request paid with payment.
+func postPayment(t *testing.T, h *testsupport.Harness, payment paymentHeaderV2) *http.Response {
+	t.Helper()
+	req, err := http.NewRequest(http.MethodPost, h.Server.URL+"/api/v2/ai/summarize", bytes.NewBufferString(`{"input":"hello"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	data, _ := json.Marshal(payment)
+	req.Header.Set("X-PAYMENT", base64.StdEncoding.EncodeToString(data))
+	resp, err := h.Server.Client().Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { resp.Body.Close() })
+	return resp
+}
+
+func TestPersonalSign_VerifiedLocally(t *testing.T) {
+	h := testsupport.NewHarness(t, newTestRouter)
+	payment := offeredPayment(t, h)
+	key, err := crypto.GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	sig, err := payments.SignPersonal(payment, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	resp := postPayment(t, h, paymentHeaderV2{Signature: sig, Nonce: payment.Nonce, SignatureType: "personal_sign"})
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected 200, got %d", resp.StatusCode)
+	}
+	if h.Verifier.Calls() != 0 {
+		t.Error("personal_sign signatures should not be sent to the EIP-712 verifier")
+	}
+
+	other := crypto.PubkeyToAddress(key.PublicKey)
+	other[0] ^= 0xff
+	resp = postPayment(t, h, paymentHeaderV2{Signature: sig, Nonce: payment.Nonce, SignatureType: "personal_sign", Payer: other.Hex()})
+	if resp.StatusCode != http.StatusForbidden {
+		t.Errorf("payer mismatch: expected 403, got %d", resp.StatusCode)
+	}
+	// The same signature is not a valid EIP-712 signature.
+	h.Verifier.SetInvalid("bad signature")
+	if resp := postPayment(t, h, paymentHeaderV2{Signature: sig, Nonce: payment.Nonce}); resp.StatusCode != http.StatusForbidden {
+		t.Errorf("as eip712: expected 403, got %d", resp.StatusCode)
+	}
+}
+
+func TestSignatureType_Unsupported(t *testing.T) {
+	h := testsupport.NewHarness(t, newTestRouter)
+	for _, sigType := range []string{"eip1271", "bogus"} {
+		req, _ := http.NewRequest(http.MethodPost, h.Server.URL+"/api/ai/summarize", strings.NewReader(`{"text":"hello"}`))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("X-402-Signature", "0xsig")
+		req.Header.Set("X-402-Nonce", "nonce-"+sigType)
+		req.Header.Set("X-402-Signature-Type", sigType)
+		resp, err := h.Server.Client().Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var body struct {
+			Error    string   `json:"error"`
+			Accepted []string `json:"accepted"`
+		}
+		json.NewDecoder(resp.Body).Decode(&body)
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusBadRequest || body.Error != "Unsupported Signature Type" || len(body.Accepted) != 2 {
+			t.Errorf("%s: expected 400 listing the accepted types, got %d %+v", sigType, resp.StatusCode, body)
+		}
+	}
+	if h.Verifier.Calls() != 0 {
+		t.Error("expected unsupported signature types to be rejected before verification")
+	}
+}
+
+// fakeWallet serves eth_call for an EIP-1271 wallet at address that accepts
+// exactly signature.
+func fakeWallet(t *testing.T, address common.Address, signature string) *httptest.Server {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			Params []json.RawMessage `json:"params"`
+		}
+		var call struct {
+			To   string `json:"to"`
+			Data string `json:"data"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || len(req.Params) == 0 || json.Unmarshal(req.Params[0], &call) != nil {
+			http.Error(w, "bad request", http.StatusBadRequest)
+			return
+		}
+		data := common.FromHex(call.Data)
+		if !strings.EqualFold(call.To, address.Hex()) || len(data) < 100 || !bytes.Contains(data[100:], common.FromHex(signature)) {
+			json.NewEncoder(w).Encode(map[string]any{"jsonrpc": "2.0", "id": 1, "error": map[string]any{"code": 3, "message": "execution reverted"}})
+			return
+		}
+		result := common.RightPadBytes(payments.EIP1271MagicValue[:], 32)
+		json.NewEncoder(w).Encode(map[string]any{"jsonrpc": "2.0", "id": 1, "result": "0x" + common.Bytes2Hex(result)})
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestEIP1271_ContractWallet(t *testing.T) {
+	wallet := common.HexToAddress("0x00000000000000000000000000000000000c0de1")
+	const walletSig = "0x0102030405"
+	rpc := fakeWallet(t, wallet, walletSig)
+	t.Setenv("SIGNATURE_TYPES", "eip712,eip1271")
+	t.Setenv("EIP1271_RPC_URLS", "base="+rpc.URL)
+	h := testsupport.NewHarness(t, newTestRouter)
+	payment := offeredPayment(t, h)
+
+	resp := postPayment(t, h, paymentHeaderV2{Signature: walletSig, Nonce: payment.Nonce, SignatureType: "eip1271", Payer: wallet.Hex()})
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected 200, got %d", resp.StatusCode)
+	}
+	if h.Verifier.Calls() != 0 {
+		t.Error("eip1271 signatures should not be sent to the EIP-712 verifier")
+	}
+
+	for name, p := range map[string]paymentHeaderV2{
+		"missing payer":    {Signature: walletSig, Nonce: payment.Nonce, SignatureType: "eip1271"},
+		"rejected sig":     {Signature: "0x0909", Nonce: payment.Nonce, SignatureType: "eip1271", Payer: wallet.Hex()},
+		"not the contract": {Signature: walletSig, Nonce: payment.Nonce, SignatureType: "eip1271", Payer: common.HexToAddress("0x01").Hex()},
+	} {
+		if resp := postPayment(t, h, p); resp.StatusCode != http.StatusForbidden {
+			t.Errorf("%s: expected 403, got %d", name, resp.StatusCode)
+		}
+	}
+}
+
+func TestSignatureConfig_Validate(t *testing.T) {
+	for name, env := range map[string]map[string]string{
+		"unknown type":      {"SIGNATURE_TYPES": "eip712,schnorr"},
+		"no types":          {"SIGNATURE_TYPES": ""},
+		"eip1271 no rpc":    {"SIGNATURE_TYPES": "eip1271"},
+		"bad rpc entry":     {"EIP1271_RPC_URLS": "base"},
+		"unknown rpc chain": {"EIP1271_RPC_URLS": "mars=http://rpc"},
+		"bad relay rpc":     {"ERC3009_RELAY_RPC_URLS": "mars=http://rpc"},
+		"bad erc3009 token": {"ERC3009_TOKENS": "base=0x12"},
+		"relay without erc3009": {
+			"ERC3009_RELAY_ENABLED": "true", "ERC3009_RELAY_RPC_URLS": "base=http://rpc", "ERC3009_RELAYER_PRIVATE_KEY": testsupport.TestPrivateKey,
+		},
+		"relay without key": {
+			"SIGNATURE_TYPES": "erc3009", "ERC3009_RELAY_ENABLED": "true", "ERC3009_RELAY_RPC_URLS": "base=http://rpc", "ERC3009_RELAYER_PRIVATE_KEY": "",
+		},
+		"relay without rpc": {
+			"SIGNATURE_TYPES": "erc3009", "ERC3009_RELAY_ENABLED": "true", "ERC3009_RELAYER_PRIVATE_KEY": testsupport.TestPrivateKey,
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			for k, v := range env {
+				t.Setenv(k, v)
+			}
+			if err := loadConfig().Validate(); err == nil {
+				t.Error("expected a validation error")
+			}
+		})
+	}
+
+	t.Setenv("SIGNATURE_TYPES", "eip1271")
+	t.Setenv("EIP1271_RPC_URLS", "base=http://rpc.example, 10=http://op.example")
+	cfg := loadConfig()
+	if err := cfg.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Signatures.RPCURLs[8453] != "http://rpc.example" || cfg.Signatures.RPCURLs[10] != "http://op.example" {
+		t.Errorf("unexpected RPC URLs %v", cfg.Signatures.RPCURLs)
+	}
+	if cfg.Signatures.RelayRPCURLs[8453] != "http://rpc.example" {
+		t.Errorf("expected the relay to fall back to the EIP-1271 RPC URLs, got %v", cfg.Signatures.RelayRPCURLs)
+	}
+
+	// The relay needs no EIP-1271 setting of its own.
+	t.Setenv("SIGNATURE_TYPES", "erc3009")
+	t.Setenv("EIP1271_RPC_URLS", "")
+	t.Setenv("ERC3009_RELAY_ENABLED", "true")
+	t.Setenv("ERC3009_RELAY_RPC_URLS", "base=http://relay.example")
+	t.Setenv("ERC3009_RELAYER_PRIVATE_KEY", testsupport.TestPrivateKey)
+	cfg = loadConfig()
+	if err := cfg.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Signatures.RelayRPCURLs[8453] != "http://relay.example" || len(cfg.Signatures.RPCURLs) != 0 {
+		t.Errorf("unexpected relay RPC URLs %v (EIP-1271: %v)", cfg.Signatures.RelayRPCURLs, cfg.Signatures.RPCURLs)
+	}
+}
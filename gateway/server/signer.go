@@ -0,0 +1,135 @@
+package server
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"sync"
+
+	"gateway/signing"
+
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+// Backends the server key can be held in, selected by SIGNER_BACKEND.
+const (
+	signerBackendEnv      = "env"
+	signerBackendKeystore = "keystore"
+	signerBackendAWSKMS   = "aws-kms"
+	signerBackendGCPKMS   = "gcp-kms"
+)
+
+// SignerConfig selects where the key that signs receipts, quotes, refund
+// vouchers and responses is held. The env backend reads
+// SERVER_WALLET_PRIVATE_KEY; the others never put a raw key in the
+// environment.
+type SignerConfig struct {
+	Backend string
+	// KeystorePath is an encrypted Web3 Secret Storage (v3) key file,
+	// unlocked with KeystorePassword.
+	KeystorePath     string
+	KeystorePassword string
+	// KMSKeyID is an AWS KMS key ID, ARN or alias, or a GCP KMS
+	// projects/.../cryptoKeyVersions/N resource name.
+	KMSKeyID string
+	// KMSRegion is the AWS region of the key.
+	KMSRegion string
+	// KMSEndpoint overrides the KMS API base URL.
+	KMSEndpoint string
+}
+
+// loadSignerConfig reads SIGNER_BACKEND (default: env) and the settings of
+// the selected backend. SIGNER_KEYSTORE_PASSWORD_FILE may hold the keystore
+// password instead of SIGNER_KEYSTORE_PASSWORD.
+func loadSignerConfig() SignerConfig {
+	sc := SignerConfig{
+		Backend:          strings.ToLower(getEnv("SIGNER_BACKEND", signerBackendEnv)),
+		KeystorePath:     os.Getenv("SIGNER_KEYSTORE_PATH"),
+		KeystorePassword: os.Getenv("SIGNER_KEYSTORE_PASSWORD"),
+		KMSKeyID:         os.Getenv("SIGNER_KMS_KEY_ID"),
+		KMSRegion:        getEnv("SIGNER_KMS_REGION", os.Getenv("AWS_REGION")),
+		KMSEndpoint:      strings.TrimSuffix(os.Getenv("SIGNER_KMS_ENDPOINT"), "/"),
+	}
+	if file := os.Getenv("SIGNER_KEYSTORE_PASSWORD_FILE"); file != "" && sc.KeystorePassword == "" {
+		if data, err := os.ReadFile(file); err == nil {
+			sc.KeystorePassword = strings.TrimRight(string(data), "\r\n")
+		} else {
+			log.Printf("[WARNING] Cannot read SIGNER_KEYSTORE_PASSWORD_FILE: %v", err)
+		}
+	}
+	return sc
+}
+
+// validate reports settings the selected backend is missing.
+func (sc SignerConfig) validate() error {
+	switch sc.Backend {
+	case signerBackendEnv:
+	case signerBackendKeystore:
+		if sc.KeystorePath == "" {
+			return fmt.Errorf("SIGNER_BACKEND=keystore requires SIGNER_KEYSTORE_PATH")
+		}
+	case signerBackendAWSKMS:
+		if sc.KMSKeyID == "" || sc.KMSRegion == "" {
+			return fmt.Errorf("SIGNER_BACKEND=aws-kms requires SIGNER_KMS_KEY_ID and SIGNER_KMS_REGION (or AWS_REGION)")
+		}
+	case signerBackendGCPKMS:
+		if !strings.HasPrefix(sc.KMSKeyID, "projects/") || !strings.Contains(sc.KMSKeyID, "/cryptoKeyVersions/") {
+			return fmt.Errorf("SIGNER_BACKEND=gcp-kms requires SIGNER_KMS_KEY_ID to be a projects/.../cryptoKeyVersions/N name")
+		}
+	default:
+		return fmt.Errorf("SIGNER_BACKEND must be %s, %s, %s or %s, got %q", signerBackendEnv, signerBackendKeystore, signerBackendAWSKMS, signerBackendGCPKMS, sc.Backend)
+	}
+	return nil
+}
+
+var (
+	serverSignerMu  sync.Mutex
+	serverSigner    signing.Signer
+	serverSignerCfg SignerConfig
+)
+
+// getServerSigner returns the signer of the configured backend. The env
+// backend follows SERVER_WALLET_PRIVATE_KEY like getServerPrivateKey; the
+// others are opened once per configuration, so a reload that changes the
+// key or backend rotates it, and failures are retried on the next call.
+func getServerSigner() (signing.Signer, error) {
+	sc := getConfig().Signer
+	if sc.Backend == signerBackendEnv || sc.Backend == "" {
+		key, err := getServerPrivateKey()
+		if err != nil {
+			return nil, err
+		}
+		return signing.FromKey(key), nil
+	}
+
+	serverSignerMu.Lock()
+	defer serverSignerMu.Unlock()
+	if serverSigner != nil && serverSignerCfg == sc {
+		return serverSigner, nil
+	}
+	s, err := openSigner(sc)
+	if err != nil {
+		return nil, fmt.Errorf("%s signer: %w", sc.Backend, err)
+	}
+	serverSigner, serverSignerCfg = s, sc
+	log.Printf("Server signer loaded from %s: %s", sc.Backend, crypto.PubkeyToAddress(*s.Public()).Hex())
+	return s, nil
+}
+
+// openSigner opens the signer of a non-env backend.
+func openSigner(sc SignerConfig) (signing.Signer, error) {
+	switch sc.Backend {
+	case signerBackendKeystore:
+		key, err := loadKeystoreKey(sc.KeystorePath, sc.KeystorePassword)
+		if err != nil {
+			return nil, err
+		}
+		return signing.FromKey(key), nil
+	case signerBackendAWSKMS:
+		return newAWSKMSSigner(sc)
+	case signerBackendGCPKMS:
+		return newGCPKMSSigner(sc)
+	}
+	return nil, fmt.Errorf("unknown backend")
+}
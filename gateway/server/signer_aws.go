@@ -0,0 +1,126 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"gateway/signing"
+)
+
+// kmsTimeout bounds each KMS API call.
+const kmsTimeout = 5 * time.Second
+
+// awsKMSSigner signs with an ECC_SECG_P256K1 key in AWS KMS. Credentials
+// come from AWS_ACCESS_KEY_ID, AWS_SECRET_ACCESS_KEY and, for temporary
+// credentials, AWS_SESSION_TOKEN, read on every call so rotated credentials
+// are picked up.
+type awsKMSSigner struct {
+	keyID    string
+	region   string
+	endpoint string
+	pub      *ecdsa.PublicKey
+}
+
+// newAWSKMSSigner fetches the public key of sc's key, checking it is a
+// secp256k1 signing key.
+func newAWSKMSSigner(sc SignerConfig) (*awsKMSSigner, error) {
+	s := &awsKMSSigner{keyID: sc.KMSKeyID, region: sc.KMSRegion, endpoint: sc.KMSEndpoint}
+	if s.endpoint == "" {
+		s.endpoint = "https://kms." + s.region + ".amazonaws.com"
+	}
+	var out struct {
+		PublicKey []byte
+		KeySpec   string
+		KeyUsage  string
+	}
+	if err := s.call("GetPublicKey", map[string]string{"KeyId": s.keyID}, &out); err != nil {
+		return nil, err
+	}
+	if out.KeySpec != "ECC_SECG_P256K1" || out.KeyUsage != "SIGN_VERIFY" {
+		return nil, fmt.Errorf("key %s is %s/%s, want an ECC_SECG_P256K1 SIGN_VERIFY key", s.keyID, out.KeySpec, out.KeyUsage)
+	}
+	pub, err := signing.ParsePublicKey(out.PublicKey)
+	if err != nil {
+		return nil, err
+	}
+	s.pub = pub
+	return s, nil
+}
+
+func (s *awsKMSSigner) Public() *ecdsa.PublicKey { return s.pub }
+
+func (s *awsKMSSigner) SignHash(digest []byte) ([]byte, error) {
+	var out struct{ Signature []byte }
+	err := s.call("Sign", map[string]interface{}{
+		"KeyId":            s.keyID,
+		"Message":          digest,
+		"MessageType":      "DIGEST",
+		"SigningAlgorithm": "ECDSA_SHA_256",
+	}, &out)
+	if err != nil {
+		return nil, err
+	}
+	return signing.FromDER(out.Signature, digest, s.pub)
+}
+
+// call invokes a KMS JSON API action, decoding the response into out.
+func (s *awsKMSSigner) call(action string, in, out interface{}) error {
+	payload, err := json.Marshal(in)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), kmsTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.endpoint+"/", bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-amz-json-1.1")
+	req.Header.Set("X-Amz-Target", "TrentService."+action)
+	signAWSJSONRequest(req, payload, s.region, "kms", time.Now().UTC())
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("kms %s: %w", action, err)
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("kms %s returned status %d: %s", action, resp.StatusCode, strings.TrimSpace(string(body)))
+	}
+	return json.Unmarshal(body, out)
+}
+
+// signAWSJSONRequest adds the SigV4 headers for a POST to "/" of an AWS JSON
+// API, signing the content type and X-Amz-Target with the credentials in
+// the environment.
+func signAWSJSONRequest(req *http.Request, payload []byte, region, service string, now time.Time) {
+	amzDate := now.Format("20060102T150405Z")
+	date := now.Format("20060102")
+	req.Header.Set("X-Amz-Date", amzDate)
+	signedHeaders := "content-type;host;x-amz-date"
+	canonicalHeaders := "content-type:" + req.Header.Get("Content-Type") + "\nhost:" + req.URL.Host + "\nx-amz-date:" + amzDate + "\n"
+	if token := os.Getenv("AWS_SESSION_TOKEN"); token != "" {
+		req.Header.Set("X-Amz-Security-Token", token)
+		signedHeaders += ";x-amz-security-token"
+		canonicalHeaders += "x-amz-security-token:" + token + "\n"
+	}
+	signedHeaders += ";x-amz-target"
+	canonicalHeaders += "x-amz-target:" + req.Header.Get("X-Amz-Target") + "\n"
+
+	canonicalRequest := strings.Join([]string{req.Method, "/", "", canonicalHeaders, signedHeaders, sha256Hex(payload)}, "\n")
+	scope := date + "/" + region + "/" + service + "/aws4_request"
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + sha256Hex([]byte(canonicalRequest))
+	signature := hex.EncodeToString(hmacSHA256(sigV4Key(os.Getenv("AWS_SECRET_ACCESS_KEY"), date, region, service), stringToSign))
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		os.Getenv("AWS_ACCESS_KEY_ID"), scope, signedHeaders, signature))
+}
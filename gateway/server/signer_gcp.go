@@ -0,0 +1,219 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"gateway/signing"
+)
+
+// gcpKMSScope is the OAuth scope KMS calls are authorized with.
+const gcpKMSScope = "https://www.googleapis.com/auth/cloudkms"
+
+// gcpKMSSigner signs with an EC_SIGN_SECP256K1_SHA256 key version in Cloud
+// KMS. Access tokens come from GOOGLE_OAUTH_ACCESS_TOKEN, else the service
+// account key file in GOOGLE_APPLICATION_CREDENTIALS, else the metadata
+// server of the instance the gateway runs on.
+type gcpKMSSigner struct {
+	name     string
+	endpoint string
+	pub      *ecdsa.PublicKey
+
+	tokenMu     sync.Mutex
+	token       string
+	tokenExpiry time.Time
+}
+
+// newGCPKMSSigner fetches the public key of sc's key version, checking it is
+// a secp256k1 signing key.
+func newGCPKMSSigner(sc SignerConfig) (*gcpKMSSigner, error) {
+	s := &gcpKMSSigner{name: sc.KMSKeyID, endpoint: sc.KMSEndpoint}
+	if s.endpoint == "" {
+		s.endpoint = "https://cloudkms.googleapis.com"
+	}
+	var out struct {
+		PEM       string `json:"pem"`
+		Algorithm string `json:"algorithm"`
+	}
+	if err := s.call(http.MethodGet, "/publicKey", nil, &out); err != nil {
+		return nil, err
+	}
+	if out.Algorithm != "EC_SIGN_SECP256K1_SHA256" {
+		return nil, fmt.Errorf("key %s is %s, want EC_SIGN_SECP256K1_SHA256", s.name, out.Algorithm)
+	}
+	block, _ := pem.Decode([]byte(out.PEM))
+	if block == nil {
+		return nil, fmt.Errorf("key %s: public key is not PEM", s.name)
+	}
+	pub, err := signing.ParsePublicKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	s.pub = pub
+	return s, nil
+}
+
+func (s *gcpKMSSigner) Public() *ecdsa.PublicKey { return s.pub }
+
+// SignHash passes digest to asymmetricSign as the SHA-256 digest the key
+// expects; KMS signs any 32 bytes given there, so Keccak-256 digests work.
+func (s *gcpKMSSigner) SignHash(digest []byte) ([]byte, error) {
+	var out struct {
+		Signature []byte `json:"signature"`
+	}
+	in := map[string]interface{}{"digest": map[string][]byte{"sha256": digest}}
+	if err := s.call(http.MethodPost, ":asymmetricSign", in, &out); err != nil {
+		return nil, err
+	}
+	return signing.FromDER(out.Signature, digest, s.pub)
+}
+
+// call sends a request to the key version's resource URL plus suffix.
+func (s *gcpKMSSigner) call(method, suffix string, in, out interface{}) error {
+	ctx, cancel := context.WithTimeout(context.Background(), kmsTimeout)
+	defer cancel()
+	token, err := s.accessToken(ctx)
+	if err != nil {
+		return fmt.Errorf("cloud kms credentials: %w", err)
+	}
+	var body io.Reader
+	if in != nil {
+		payload, err := json.Marshal(in)
+		if err != nil {
+			return err
+		}
+		body = bytes.NewReader(payload)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, s.endpoint+"/v1/"+s.name+suffix, body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	if in != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("cloud kms: %w", err)
+	}
+	defer resp.Body.Close()
+	data, _ := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("cloud kms returned status %d: %s", resp.StatusCode, strings.TrimSpace(string(data)))
+	}
+	return json.Unmarshal(data, out)
+}
+
+// accessToken returns a cached OAuth token, fetching a new one a minute
+// before the old one expires.
+func (s *gcpKMSSigner) accessToken(ctx context.Context) (string, error) {
+	if token := os.Getenv("GOOGLE_OAUTH_ACCESS_TOKEN"); token != "" {
+		return token, nil
+	}
+	s.tokenMu.Lock()
+	defer s.tokenMu.Unlock()
+	if s.token != "" && time.Now().Before(s.tokenExpiry.Add(-time.Minute)) {
+		return s.token, nil
+	}
+
+	var req *http.Request
+	var err error
+	if file := os.Getenv("GOOGLE_APPLICATION_CREDENTIALS"); file != "" {
+		req, err = serviceAccountTokenRequest(ctx, file)
+	} else {
+		host := getEnv("GCE_METADATA_HOST", "metadata.google.internal")
+		req, err = http.NewRequestWithContext(ctx, http.MethodGet, "http://"+host+"/computeMetadata/v1/instance/service-accounts/default/token", nil)
+		if req != nil {
+			req.Header.Set("Metadata-Flavor", "Google")
+		}
+	}
+	if err != nil {
+		return "", err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	var out struct {
+		AccessToken string `json:"access_token"`
+		ExpiresIn   int    `json:"expires_in"`
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("token endpoint returned status %d", resp.StatusCode)
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&out); err != nil || out.AccessToken == "" {
+		return "", fmt.Errorf("token endpoint returned no access token")
+	}
+	s.token, s.tokenExpiry = out.AccessToken, time.Now().Add(time.Duration(out.ExpiresIn)*time.Second)
+	return s.token, nil
+}
+
+// serviceAccountTokenRequest builds the OAuth JWT bearer grant for the
+// service account key file at path.
+func serviceAccountTokenRequest(ctx context.Context, path string) (*http.Request, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var sa struct {
+		ClientEmail string `json:"client_email"`
+		PrivateKey  string `json:"private_key"`
+		TokenURI    string `json:"token_uri"`
+	}
+	if err := json.Unmarshal(data, &sa); err != nil || sa.ClientEmail == "" || sa.TokenURI == "" {
+		return nil, fmt.Errorf("GOOGLE_APPLICATION_CREDENTIALS is not a service account key file")
+	}
+	block, _ := pem.Decode([]byte(sa.PrivateKey))
+	if block == nil {
+		return nil, fmt.Errorf("service account private key is not PEM")
+	}
+	parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	key, ok := parsed.(*rsa.PrivateKey)
+	if err != nil || !ok {
+		return nil, fmt.Errorf("service account private key is not an RSA key")
+	}
+
+	now := time.Now()
+	header, _ := json.Marshal(map[string]string{"alg": "RS256", "typ": "JWT"})
+	claims, _ := json.Marshal(map[string]interface{}{
+		"iss":   sa.ClientEmail,
+		"scope": gcpKMSScope,
+		"aud":   sa.TokenURI,
+		"iat":   now.Unix(),
+		"exp":   now.Add(time.Hour).Unix(),
+	})
+	signingInput := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(claims)
+	digest := sha256.Sum256([]byte(signingInput))
+	sig, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, digest[:])
+	if err != nil {
+		return nil, err
+	}
+	form := url.Values{
+		"grant_type": {"urn:ietf:params:oauth:grant-type:jwt-bearer"},
+		"assertion":  {signingInput + "." + base64.RawURLEncoding.EncodeToString(sig)},
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, sa.TokenURI, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	return req, nil
+}
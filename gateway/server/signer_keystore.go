@@ -0,0 +1,113 @@
+package server
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdsa"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+	"golang.org/x/crypto/pbkdf2"
+	"golang.org/x/crypto/scrypt"
+)
+
+// keystoreFile is a Web3 Secret Storage (v3) key file as geth, clef and
+// most wallets write it.
+type keystoreFile struct {
+	Address string `json:"address"`
+	Version int    `json:"version"`
+	Crypto  struct {
+		Cipher       string `json:"cipher"`
+		CipherText   string `json:"ciphertext"`
+		CipherParams struct {
+			IV string `json:"iv"`
+		} `json:"cipherparams"`
+		KDF       string          `json:"kdf"`
+		KDFParams json.RawMessage `json:"kdfparams"`
+		MAC       string          `json:"mac"`
+	} `json:"crypto"`
+}
+
+// loadKeystoreKey decrypts the private key in the v3 keystore file at path.
+func loadKeystoreKey(path, password string) (*ecdsa.PrivateKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var ks keystoreFile
+	if err := json.Unmarshal(data, &ks); err != nil {
+		return nil, fmt.Errorf("invalid keystore file: %w", err)
+	}
+	if ks.Version != 3 {
+		return nil, fmt.Errorf("unsupported keystore version %d", ks.Version)
+	}
+	if ks.Crypto.Cipher != "aes-128-ctr" {
+		return nil, fmt.Errorf("unsupported keystore cipher %q", ks.Crypto.Cipher)
+	}
+	derived, err := keystoreDerivedKey(ks.Crypto.KDF, ks.Crypto.KDFParams, password)
+	if err != nil {
+		return nil, err
+	}
+	cipherText, err1 := hex.DecodeString(ks.Crypto.CipherText)
+	iv, err2 := hex.DecodeString(ks.Crypto.CipherParams.IV)
+	mac, err3 := hex.DecodeString(ks.Crypto.MAC)
+	if err1 != nil || err2 != nil || err3 != nil || len(iv) != aes.BlockSize {
+		return nil, fmt.Errorf("invalid keystore file: malformed hex fields")
+	}
+	if subtle.ConstantTimeCompare(crypto.Keccak256(derived[16:32], cipherText), mac) != 1 {
+		return nil, fmt.Errorf("wrong keystore password")
+	}
+
+	block, err := aes.NewCipher(derived[:16])
+	if err != nil {
+		return nil, err
+	}
+	plain := make([]byte, len(cipherText))
+	cipher.NewCTR(block, iv).XORKeyStream(plain, cipherText)
+	key, err := crypto.ToECDSA(plain)
+	if err != nil {
+		return nil, fmt.Errorf("invalid keystore key: %w", err)
+	}
+	if ks.Address != "" && common.HexToAddress(ks.Address) != crypto.PubkeyToAddress(key.PublicKey) {
+		return nil, fmt.Errorf("keystore key does not match its address %s", ks.Address)
+	}
+	return key, nil
+}
+
+// keystoreDerivedKey derives the 32-byte key encryption key from password
+// with the file's scrypt or PBKDF2 parameters.
+func keystoreDerivedKey(kdf string, raw json.RawMessage, password string) ([]byte, error) {
+	var params struct {
+		DKLen int    `json:"dklen"`
+		Salt  string `json:"salt"`
+		N     int    `json:"n"`
+		R     int    `json:"r"`
+		P     int    `json:"p"`
+		C     int    `json:"c"`
+		PRF   string `json:"prf"`
+	}
+	if err := json.Unmarshal(raw, &params); err != nil {
+		return nil, fmt.Errorf("invalid keystore kdfparams: %w", err)
+	}
+	salt, err := hex.DecodeString(params.Salt)
+	if err != nil || params.DKLen < 32 {
+		return nil, fmt.Errorf("invalid keystore kdfparams")
+	}
+	switch strings.ToLower(kdf) {
+	case "scrypt":
+		return scrypt.Key([]byte(password), salt, params.N, params.R, params.P, params.DKLen)
+	case "pbkdf2":
+		if params.PRF != "hmac-sha256" || params.C <= 0 {
+			return nil, fmt.Errorf("unsupported keystore pbkdf2 parameters")
+		}
+		return pbkdf2.Key([]byte(password), salt, params.C, params.DKLen, sha256.New), nil
+	}
+	return nil, fmt.Errorf("unsupported keystore kdf %q", kdf)
+}
@@ -0,0 +1,270 @@
+package server
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdsa"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"gateway/receipts"
+
+	"github.com/ethereum/go-ethereum/crypto"
+	"golang.org/x/crypto/scrypt"
+)
+
+// withSignerEnv sets env and reloads the config, dropping the cached signer
+// before and after the test.
+func withSignerEnv(t *testing.T, env map[string]string) {
+	t.Helper()
+	resetConfigSnapshot(t)
+	for k, v := range env {
+		t.Setenv(k, v)
+	}
+	clear := func() {
+		serverSignerMu.Lock()
+		serverSigner, serverSignerCfg = nil, SignerConfig{}
+		serverSignerMu.Unlock()
+	}
+	clear()
+	t.Cleanup(clear)
+	currentConfig.Store(loadConfig())
+}
+
+// checkSignerSignsReceipts signs a receipt with the configured signer and
+// verifies it against want's public key.
+func checkSignerSignsReceipts(t *testing.T, want *ecdsa.PublicKey) {
+	t.Helper()
+	signer, err := getServerSigner()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if crypto.PubkeyToAddress(*signer.Public()) != crypto.PubkeyToAddress(*want) {
+		t.Fatal("signer reports another public key")
+	}
+	signed, err := GenerateReceipt(PaymentContext{Amount: "0.001", Token: "USDC", Nonce: "signer-nonce"}, "0xpayer", "/api/ai/summarize", nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := receipts.Verify(signed, want); err != nil {
+		t.Errorf("receipt does not verify against the backend's key: %v", err)
+	}
+}
+
+func TestSignerConfig_Validate(t *testing.T) {
+	bad := map[string]SignerConfig{
+		"unknown backend":  {Backend: "vault"},
+		"keystore path":    {Backend: signerBackendKeystore},
+		"aws key":          {Backend: signerBackendAWSKMS, KMSRegion: "eu-west-1"},
+		"aws region":       {Backend: signerBackendAWSKMS, KMSKeyID: "alias/paygate"},
+		"gcp resource":     {Backend: signerBackendGCPKMS, KMSKeyID: "alias/paygate"},
+		"gcp key versions": {Backend: signerBackendGCPKMS, KMSKeyID: "projects/p/locations/l/keyRings/r/cryptoKeys/k"},
+	}
+	for name, sc := range bad {
+		if err := sc.validate(); err == nil {
+			t.Errorf("%s: expected a validation error", name)
+		}
+	}
+	good := []SignerConfig{
+		{Backend: signerBackendEnv},
+		{Backend: signerBackendKeystore, KeystorePath: "/keys/server.json"},
+		{Backend: signerBackendAWSKMS, KMSKeyID: "alias/paygate", KMSRegion: "eu-west-1"},
+		{Backend: signerBackendGCPKMS, KMSKeyID: "projects/p/locations/l/keyRings/r/cryptoKeys/k/cryptoKeyVersions/1"},
+	}
+	for _, sc := range good {
+		if err := sc.validate(); err != nil {
+			t.Errorf("%+v: %v", sc, err)
+		}
+	}
+}
+
+// writeKeystore encrypts key into a v3 keystore file with cheap scrypt
+// parameters.
+func writeKeystore(t *testing.T, key *ecdsa.PrivateKey, password string) string {
+	t.Helper()
+	salt := []byte("0123456789abcdef0123456789abcdef")
+	iv := []byte("fedcba9876543210")
+	derived, err := scrypt.Key([]byte(password), salt, 1024, 8, 1, 32)
+	if err != nil {
+		t.Fatal(err)
+	}
+	block, _ := aes.NewCipher(derived[:16])
+	cipherText := make([]byte, 32)
+	cipher.NewCTR(block, iv).XORKeyStream(cipherText, crypto.FromECDSA(key))
+
+	file := map[string]interface{}{
+		"address": strings.TrimPrefix(crypto.PubkeyToAddress(key.PublicKey).Hex(), "0x"),
+		"version": 3,
+		"crypto": map[string]interface{}{
+			"cipher":       "aes-128-ctr",
+			"ciphertext":   hex.EncodeToString(cipherText),
+			"cipherparams": map[string]string{"iv": hex.EncodeToString(iv)},
+			"kdf":          "scrypt",
+			"kdfparams":    map[string]interface{}{"dklen": 32, "n": 1024, "r": 8, "p": 1, "salt": hex.EncodeToString(salt)},
+			"mac":          hex.EncodeToString(crypto.Keccak256(derived[16:32], cipherText)),
+		},
+	}
+	data, _ := json.Marshal(file)
+	path := filepath.Join(t.TempDir(), "server.json")
+	if err := os.WriteFile(path, data, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestSigner_Keystore(t *testing.T) {
+	key, _ := crypto.GenerateKey()
+	path := writeKeystore(t, key, "correct horse")
+	passwordFile := filepath.Join(t.TempDir(), "password")
+	os.WriteFile(passwordFile, []byte("correct horse\n"), 0o600)
+
+	withSignerEnv(t, map[string]string{
+		"SIGNER_BACKEND":                "keystore",
+		"SIGNER_KEYSTORE_PATH":          path,
+		"SIGNER_KEYSTORE_PASSWORD_FILE": passwordFile,
+	})
+	checkSignerSignsReceipts(t, &key.PublicKey)
+
+	if _, err := loadKeystoreKey(path, "wrong"); err == nil || !strings.Contains(err.Error(), "password") {
+		t.Errorf("expected a wrong password error, got %v", err)
+	}
+}
+
+// secp256k1SPKI encodes pub as a DER SubjectPublicKeyInfo, as KMS services
+// publish it.
+func secp256k1SPKI(pub *ecdsa.PublicKey) []byte {
+	params, _ := asn1.Marshal(asn1.ObjectIdentifier{1, 3, 132, 0, 10})
+	der, _ := asn1.Marshal(struct {
+		Algorithm pkix.AlgorithmIdentifier
+		PublicKey asn1.BitString
+	}{
+		Algorithm: pkix.AlgorithmIdentifier{Algorithm: asn1.ObjectIdentifier{1, 2, 840, 10045, 2, 1}, Parameters: asn1.RawValue{FullBytes: params}},
+		PublicKey: asn1.BitString{Bytes: crypto.FromECDSAPub(pub), BitLength: 65 * 8},
+	})
+	return der
+}
+
+// derSign signs digest with key and returns the DER signature a KMS would,
+// with the high S form so the signer has to normalize it.
+func derSign(t *testing.T, key *ecdsa.PrivateKey, digest []byte) []byte {
+	t.Helper()
+	sig, err := crypto.Sign(digest, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := new(big.Int).Sub(crypto.S256().Params().N, new(big.Int).SetBytes(sig[32:64]))
+	der, _ := asn1.Marshal(struct{ R, S *big.Int }{new(big.Int).SetBytes(sig[:32]), s})
+	return der
+}
+
+func TestSigner_AWSKMS(t *testing.T) {
+	key, _ := crypto.GenerateKey()
+	signs := 0
+	kms := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !strings.HasPrefix(r.Header.Get("Authorization"), "AWS4-HMAC-SHA256 Credential=AKIDTEST/") ||
+			!strings.Contains(r.Header.Get("Authorization"), "/eu-west-1/kms/aws4_request") ||
+			r.Header.Get("X-Amz-Security-Token") != "session" {
+			http.Error(w, "unsigned", http.StatusForbidden)
+			return
+		}
+		var in struct {
+			KeyId, MessageType, SigningAlgorithm string
+			Message                              []byte
+		}
+		json.NewDecoder(r.Body).Decode(&in)
+		if in.KeyId != "alias/paygate" {
+			http.Error(w, "no such key", http.StatusBadRequest)
+			return
+		}
+		switch r.Header.Get("X-Amz-Target") {
+		case "TrentService.GetPublicKey":
+			json.NewEncoder(w).Encode(map[string]interface{}{"PublicKey": secp256k1SPKI(&key.PublicKey), "KeySpec": "ECC_SECG_P256K1", "KeyUsage": "SIGN_VERIFY"})
+		case "TrentService.Sign":
+			if in.MessageType != "DIGEST" || in.SigningAlgorithm != "ECDSA_SHA_256" || len(in.Message) != 32 {
+				http.Error(w, "bad sign request", http.StatusBadRequest)
+				return
+			}
+			signs++
+			json.NewEncoder(w).Encode(map[string]interface{}{"Signature": derSign(t, key, in.Message)})
+		default:
+			http.Error(w, "unknown action", http.StatusBadRequest)
+		}
+	}))
+	defer kms.Close()
+
+	withSignerEnv(t, map[string]string{
+		"SIGNER_BACKEND":        "aws-kms",
+		"SIGNER_KMS_KEY_ID":     "alias/paygate",
+		"SIGNER_KMS_REGION":     "eu-west-1",
+		"SIGNER_KMS_ENDPOINT":   kms.URL,
+		"AWS_ACCESS_KEY_ID":     "AKIDTEST",
+		"AWS_SECRET_ACCESS_KEY": "secret",
+		"AWS_SESSION_TOKEN":     "session",
+	})
+	checkSignerSignsReceipts(t, &key.PublicKey)
+	if signs != 1 {
+		t.Errorf("expected one KMS Sign call, got %d", signs)
+	}
+}
+
+func TestSigner_GCPKMS(t *testing.T) {
+	key, _ := crypto.GenerateKey()
+	const name = "projects/p/locations/global/keyRings/r/cryptoKeys/k/cryptoKeyVersions/1"
+	tokens := 0
+	kms := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/computeMetadata/v1/instance/service-accounts/default/token" {
+			if r.Header.Get("Metadata-Flavor") != "Google" {
+				http.Error(w, "missing header", http.StatusForbidden)
+				return
+			}
+			tokens++
+			json.NewEncoder(w).Encode(map[string]interface{}{"access_token": "ya29.test", "expires_in": 3600})
+			return
+		}
+		if r.Header.Get("Authorization") != "Bearer ya29.test" {
+			http.Error(w, "unauthenticated", http.StatusUnauthorized)
+			return
+		}
+		switch r.URL.Path {
+		case "/v1/" + name + "/publicKey":
+			pemKey := pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: secp256k1SPKI(&key.PublicKey)})
+			json.NewEncoder(w).Encode(map[string]string{"pem": string(pemKey), "algorithm": "EC_SIGN_SECP256K1_SHA256"})
+		case "/v1/" + name + ":asymmetricSign":
+			var in struct {
+				Digest struct {
+					SHA256 []byte `json:"sha256"`
+				} `json:"digest"`
+			}
+			json.NewDecoder(r.Body).Decode(&in)
+			json.NewEncoder(w).Encode(map[string]string{"signature": base64.StdEncoding.EncodeToString(derSign(t, key, in.Digest.SHA256))})
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer kms.Close()
+
+	withSignerEnv(t, map[string]string{
+		"SIGNER_BACKEND":                 "gcp-kms",
+		"SIGNER_KMS_KEY_ID":              name,
+		"SIGNER_KMS_ENDPOINT":            kms.URL,
+		"GCE_METADATA_HOST":              strings.TrimPrefix(kms.URL, "http://"),
+		"GOOGLE_APPLICATION_CREDENTIALS": "",
+		"GOOGLE_OAUTH_ACCESS_TOKEN":      "",
+	})
+	checkSignerSignsReceipts(t, &key.PublicKey)
+	checkSignerSignsReceipts(t, &key.PublicKey)
+	if tokens != 1 {
+		t.Errorf("expected the metadata token to be cached, fetched %d times", tokens)
+	}
+}
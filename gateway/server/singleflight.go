@@ -0,0 +1,99 @@
+package server
+
+import (
+	"context"
+	"log"
+	"sync"
+	"sync/atomic"
+
+	"github.com/gin-gonic/gin"
+)
+
+// summaryFlights deduplicates concurrent identical provider calls.
+var summaryFlights = &flightGroup{}
+
+// flightGroup collapses concurrent summary calls with the same key into one
+// provider call whose result every caller receives.
+type flightGroup struct {
+	mu    sync.Mutex
+	calls map[string]*flightCall
+	// joined counts callers that shared another caller's provider call.
+	joined atomic.Int64
+}
+
+// flightCall is a provider call in progress; done is closed once its result
+// is set.
+type flightCall struct {
+	done   chan struct{}
+	result providerResult
+	err    error
+}
+
+// Do runs fn for key unless a call for key is already in flight, in which
+// case it waits for that call's result; shared reports the latter. The call
+// runs detached from the first caller's cancellation, keeping its deadline,
+// so one client disconnecting does not fail the others. Waiting callers
+// give up when their own ctx ends.
+func (g *flightGroup) Do(ctx context.Context, key string, fn func(context.Context) (providerResult, error)) (res providerResult, shared bool, err error) {
+	g.mu.Lock()
+	if g.calls == nil {
+		g.calls = make(map[string]*flightCall)
+	}
+	if call, ok := g.calls[key]; ok {
+		g.mu.Unlock()
+		g.joined.Add(1)
+		select {
+		case <-call.done:
+			return call.result, true, call.err
+		case <-ctx.Done():
+			return providerResult{}, true, ctx.Err()
+		}
+	}
+	call := &flightCall{done: make(chan struct{})}
+	g.calls[key] = call
+	g.mu.Unlock()
+
+	defer func() {
+		g.mu.Lock()
+		delete(g.calls, key)
+		g.mu.Unlock()
+		close(call.done)
+	}()
+
+	callCtx := context.WithoutCancel(ctx)
+	if deadline, ok := ctx.Deadline(); ok {
+		var cancel context.CancelFunc
+		callCtx, cancel = context.WithDeadline(callCtx, deadline)
+		defer cancel()
+	}
+	call.result, call.err = fn(callCtx)
+	return call.result, false, call.err
+}
+
+// Status is the singleflight entry of /readyz.
+func (g *flightGroup) Status() gin.H {
+	g.mu.Lock()
+	inFlight := len(g.calls)
+	g.mu.Unlock()
+	return gin.H{
+		"in_flight":    inFlight,
+		"joined_total": g.joined.Load(),
+	}
+}
+
+// summarizeShared summarizes text with model, sharing the provider call with
+// an identical request (same cache key) already in flight. Every caller is
+// still charged and receipted separately, but only the caller that made the
+// call reports its provider cost; the others get zero, like a cache hit.
+func summarizeShared(ctx context.Context, model, text string, params GenerationParams) (providerResult, error) {
+	res, shared, err := summaryFlights.Do(ctx, getCacheKey(text, model, params), func(ctx context.Context) (providerResult, error) {
+		return callAIProviders(ctx, model, text, params)
+	})
+	if shared {
+		if err == nil {
+			log.Printf("Shared in-flight provider call for model %s", model)
+		}
+		res.Usage = ProviderUsage{}
+	}
+	return res, err
+}
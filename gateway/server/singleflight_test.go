@@ -0,0 +1,130 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"gateway/internal/testsupport"
+)
+
+func TestFlightGroup_SharesConcurrentCalls(t *testing.T) {
+	g := &flightGroup{}
+	release := make(chan struct{})
+	var calls atomic.Int32
+	fn := func(context.Context) (providerResult, error) {
+		calls.Add(1)
+		<-release
+		return providerResult{Summary: "summary", Usage: ProviderUsage{Cost: 0.5}}, nil
+	}
+
+	const n = 10
+	var wg sync.WaitGroup
+	var shared atomic.Int32
+	for range n {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			res, s, err := g.Do(context.Background(), "key", fn)
+			if err != nil || res.Summary != "summary" || res.Usage.Cost != 0.5 {
+				t.Errorf("unexpected result %+v %v", res, err)
+			}
+			if s {
+				shared.Add(1)
+			}
+		}()
+	}
+	for g.joined.Load() < n-1 {
+		time.Sleep(time.Millisecond)
+	}
+	close(release)
+	wg.Wait()
+
+	if calls.Load() != 1 || shared.Load() != n-1 {
+		t.Errorf("expected 1 call shared by %d callers, got %d calls and %d shared", n-1, calls.Load(), shared.Load())
+	}
+	if _, s, _ := g.Do(context.Background(), "key", func(context.Context) (providerResult, error) { return providerResult{Summary: "again"}, nil }); s {
+		t.Error("a call after the flight finished should not be shared")
+	}
+}
+
+func TestFlightGroup_Cancellation(t *testing.T) {
+	g := &flightGroup{}
+	release := make(chan struct{})
+	leaderCtx, cancelLeader := context.WithCancel(context.Background())
+	result := make(chan error, 1)
+	go func() {
+		_, _, err := g.Do(leaderCtx, "key", func(ctx context.Context) (providerResult, error) {
+			<-release
+			return providerResult{Summary: "summary"}, ctx.Err()
+		})
+		result <- err
+	}()
+	for g.Status()["in_flight"] != 1 {
+		time.Sleep(time.Millisecond)
+	}
+
+	// A waiting caller gives up on its own context...
+	waitCtx, cancelWait := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancelWait()
+	if _, shared, err := g.Do(waitCtx, "key", nil); !shared || !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected the waiter to time out, got shared=%v err=%v", shared, err)
+	}
+	// ...and the first caller going away does not cancel the shared call.
+	cancelLeader()
+	close(release)
+	if err := <-result; err != nil {
+		t.Errorf("expected the call to outlive its first caller, got %v", err)
+	}
+}
+
+func TestSummarize_IdenticalConcurrentRequestsShareOneProviderCall(t *testing.T) {
+	// Every request is paid by the harness wallet.
+	t.Setenv("WALLET_MAX_CONCURRENT", "0")
+	h := testsupport.NewHarness(t, newTestRouter)
+	h.AI.SetDelay(300 * time.Millisecond)
+
+	const n = 5
+	var wg sync.WaitGroup
+	receiptIDs := make(chan string, n)
+	for i := range n {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			resp := h.Post(t, "/api/ai/summarize", `{"text":"the same article"}`, "0xsig", fmt.Sprintf("nonce-flight-%d", i))
+			if resp.StatusCode != http.StatusOK {
+				t.Errorf("expected 200, got %d", resp.StatusCode)
+				return
+			}
+			receiptIDs <- decodeReceiptHeader(t, resp.Header.Get("X-402-Receipt")).Receipt.ID
+		}()
+	}
+	wg.Wait()
+	close(receiptIDs)
+
+	if h.AI.Calls() != 1 {
+		t.Errorf("expected one provider call for %d identical requests, got %d", n, h.AI.Calls())
+	}
+	if h.Verifier.Calls() != n {
+		t.Errorf("expected every request to be verified, got %d verifications", h.Verifier.Calls())
+	}
+	seen := make(map[string]bool)
+	for id := range receiptIDs {
+		seen[id] = true
+	}
+	if len(seen) != n {
+		t.Errorf("expected %d distinct receipts, got %d", n, len(seen))
+	}
+
+	// Different input is not shared.
+	h.AI.SetDelay(0)
+	h.Post(t, "/api/ai/summarize", `{"text":"another article"}`, "0xsig", "nonce-flight-other")
+	if h.AI.Calls() != 2 {
+		t.Errorf("expected a separate call for different input, got %d calls", h.AI.Calls())
+	}
+}
@@ -0,0 +1,172 @@
+package server
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"gateway/payments"
+	"gateway/receipts"
+
+	"github.com/gin-gonic/gin"
+)
+
+// sponsorGrantKeyPrefix namespaces the total spent under each sponsor grant
+// in the spend store.
+const sponsorGrantKeyPrefix = "sponsor:spent:"
+
+// paymentSponsor is the sponsor a request was paid for by.
+type paymentSponsor struct {
+	Sponsor string
+	GrantID string
+
+	// releaseCap returns the payment to the grant's TotalCap; nil when the
+	// grant has none.
+	releaseCap  func()
+	releaseOnce sync.Once
+}
+
+// release returns the payment to the grant's TotalCap when the request is
+// refunded. It is safe on a nil sponsor and to call more than once.
+func (s *paymentSponsor) release() {
+	if s == nil || s.releaseCap == nil {
+		return
+	}
+	s.releaseOnce.Do(s.releaseCap)
+}
+
+// receiptOptions records the sponsor in a receipt. A nil sponsor records
+// nothing.
+func (s *paymentSponsor) receiptOptions() []receipts.Option {
+	if s == nil {
+		return nil
+	}
+	return []receipts.Option{receipts.WithSponsor(s.Sponsor, s.GrantID)}
+}
+
+// requestSponsor returns the sponsor authorizePayment accepted for the
+// request, or nil when the payer pays alone.
+func requestSponsor(c *gin.Context) *paymentSponsor {
+	if v, ok := c.Get("payment_sponsor"); ok {
+		return v.(*paymentSponsor)
+	}
+	return nil
+}
+
+// decodeSponsorGrant parses an X-402-Sponsor-Grant header value:
+// base64-encoded JSON of a payments.SponsorGrant.
+func decodeSponsorGrant(raw string) (payments.SponsorGrant, error) {
+	var g payments.SponsorGrant
+	data, err := base64.StdEncoding.DecodeString(raw)
+	if err != nil {
+		return g, err
+	}
+	err = json.Unmarshal(data, &g)
+	return g, err
+}
+
+// sponsorGrantWindow is the spend window a grant's TotalCap is reserved in.
+// It lasts until the grant expires.
+func sponsorGrantWindow(g payments.SponsorGrant, totalCap int64) budgetWindow {
+	return budgetWindow{
+		Name:    "sponsor",
+		Key:     sponsorGrantKeyPrefix + strings.ToLower(g.Sponsor) + ":" + g.ID,
+		Cap:     totalCap,
+		ResetAt: time.Unix(g.Expiry, 0),
+	}
+}
+
+// authorizeSponsor checks the X-402-Sponsor-Grant and X-402-Sponsor-Signature
+// headers of a request whose payment by payer already verified. Without
+// them it does nothing. Otherwise the grant must be signed by its sponsor,
+// unexpired, for the payment's chain, name payer among its users and allow
+// price; price is then reserved against the grant's TotalCap. On failure it
+// aborts and returns ok=false. On success it returns a release func the
+// caller invokes if the payment is refused after all; a refund after that
+// releases it through the request's paymentSponsor.
+func authorizeSponsor(c *gin.Context, payment PaymentContext, payer, price string) (release func(), ok bool) {
+	noop := func() {}
+	raw, signature := c.GetHeader("X-402-Sponsor-Grant"), c.GetHeader("X-402-Sponsor-Signature")
+	if raw == "" && signature == "" {
+		return noop, true
+	}
+	reject := func(message string) (func(), bool) {
+		abortWithError(c, CodeSponsorGrantInvalid, message)
+		return noop, false
+	}
+	if raw == "" || signature == "" {
+		return reject("X-402-Sponsor-Grant and X-402-Sponsor-Signature must be sent together")
+	}
+	g, err := decodeSponsorGrant(raw)
+	if err != nil {
+		return reject("X-402-Sponsor-Grant must be base64-encoded JSON of a sponsor grant")
+	}
+	signer, err := payments.RecoverSponsorGrantSigner(g, signature)
+	if err != nil || !strings.EqualFold(signer.Hex(), g.Sponsor) {
+		return reject("The sponsor grant is not signed by its sponsor")
+	}
+	if time.Now().Unix() > g.Expiry {
+		return reject("The sponsor grant has expired")
+	}
+	if g.ChainID != payment.ChainID {
+		return reject("The sponsor grant is for another chain than the payment")
+	}
+	if !g.Covers(payer) {
+		return reject("The sponsor grant does not cover the payer")
+	}
+	maxAmount, err := parseTokenAmount(g.MaxAmount)
+	if err != nil {
+		return reject("The sponsor grant's maxAmount is not a valid amount")
+	}
+	amount, err := parseTokenAmount(price)
+	if err != nil {
+		log.Printf("[WARNING] Sponsor grant check failed, invalid payment amount: %v", err)
+		abortWithError(c, CodeInternal, "An internal error occurred")
+		return noop, false
+	}
+	if amount > maxAmount {
+		abortWithAPIError(c, newAPIError(CodeSponsorCapExceeded, "The request costs more than the sponsor grant allows per payment").
+			with(gin.H{"max_amount": g.MaxAmount, "price": price}))
+		return noop, false
+	}
+
+	sponsor := &paymentSponsor{Sponsor: signer.Hex(), GrantID: g.ID}
+	c.Set("payment_sponsor", sponsor)
+	if g.TotalCap == "" {
+		return noop, true
+	}
+	totalCap, err := parseTokenAmount(g.TotalCap)
+	if err != nil {
+		return reject("The sponsor grant's totalCap is not a valid amount")
+	}
+	window := sponsorGrantWindow(g, totalCap)
+	store := getSpendStore()
+	_, err = store.Reserve(c.Request.Context(), amount, []budgetWindow{window})
+	var exceeded *BudgetExceededError
+	if errors.As(err, &exceeded) {
+		abortWithAPIError(c, newAPIError(CodeSponsorCapExceeded, "The sponsor grant's total cap has been reached").
+			with(gin.H{"total_cap": g.TotalCap, "spent": formatTokenAmount(exceeded.Spent)}))
+		return noop, false
+	}
+	if err != nil {
+		// Unlike a payer's own spending caps, the sponsor's cap is what
+		// authorizes the payment, so it fails closed.
+		log.Printf("[WARNING] Sponsor grant store error: %v", err)
+		abortWithError(c, CodeServiceUnavailable, "Sponsor grants cannot be checked right now")
+		return noop, false
+	}
+	// Refunds can run after the request has finished, as for failed jobs.
+	sponsor.releaseCap = func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+		defer cancel()
+		if err := store.Release(ctx, amount, []budgetWindow{window}); err != nil {
+			log.Printf("[WARNING] Failed to release sponsor grant spend: %v", err)
+		}
+	}
+	return sponsor.release, true
+}
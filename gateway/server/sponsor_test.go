@@ -0,0 +1,178 @@
+package server
+
+import (
+	"crypto/ecdsa"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	"gateway/internal/testsupport"
+	"gateway/payments"
+
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+const sponsoredUser = "0x1111111111111111111111111111111111111111"
+
+// signedGrant returns g for sponsor key, encoded for X-402-Sponsor-Grant,
+// and the sponsor's signature of it.
+func signedGrant(t *testing.T, key *ecdsa.PrivateKey, g payments.SponsorGrant) (string, string) {
+	t.Helper()
+	g.Sponsor = crypto.PubkeyToAddress(key.PublicKey).Hex()
+	sig, err := payments.SignSponsorGrant(g, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := json.Marshal(g)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return base64.StdEncoding.EncodeToString(data), sig
+}
+
+func TestSponsoredPayment_RecordsSponsorInReceipt(t *testing.T) {
+	h := testsupport.NewHarness(t, newTestRouter)
+	h.Verifier.SetValid(sponsoredUser)
+	key, err := crypto.GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	payment := offeredPayment(t, h)
+	grant, sig := signedGrant(t, key, payments.SponsorGrant{
+		ID: "grant-1", Users: []string{sponsoredUser}, MaxAmount: "0.01", ChainID: payment.ChainID,
+		Expiry: time.Now().Add(time.Hour).Unix(),
+	})
+
+	resp := postPayment(t, h, paymentHeaderV2{Signature: "0xsig", Nonce: payment.Nonce, SponsorGrant: grant, SponsorSignature: sig})
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected 200, got %d %+v", resp.StatusCode, decodeErrorBody(t, resp))
+	}
+	p := decodeReceiptHeader(t, resp.Header.Get("X-402-Receipt")).Receipt.Payment
+	if p.Payer != sponsoredUser || p.Sponsor != crypto.PubkeyToAddress(key.PublicKey).Hex() || p.SponsorGrant != "grant-1" {
+		t.Errorf("expected payer and sponsor in the receipt, got %+v", p)
+	}
+}
+
+func TestSponsoredPayment_RejectsInvalidGrants(t *testing.T) {
+	h := testsupport.NewHarness(t, newTestRouter)
+	h.Verifier.SetValid(sponsoredUser)
+	key, err := crypto.GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, err := crypto.GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	payment := offeredPayment(t, h)
+	valid := payments.SponsorGrant{
+		ID: "grant-1", Users: []string{sponsoredUser}, MaxAmount: "0.01", ChainID: payment.ChainID,
+		Expiry: time.Now().Add(time.Hour).Unix(),
+	}
+
+	forged, _ := signedGrant(t, key, valid)
+	_, otherSig := signedGrant(t, other, valid)
+	expired := valid
+	expired.Expiry = time.Now().Add(-time.Minute).Unix()
+	otherChain := valid
+	otherChain.ChainID = payment.ChainID + 1
+	otherUser := valid
+	otherUser.Users = []string{"0x2222222222222222222222222222222222222222"}
+	tooSmall := valid
+	tooSmall.MaxAmount = "0.0001"
+
+	type grantCase struct {
+		name       string
+		grant, sig string
+		status     int
+		code       ErrorCode
+	}
+	cases := []grantCase{
+		{"signed by someone else", forged, otherSig, http.StatusForbidden, CodeSponsorGrantInvalid},
+		{"missing signature", forged, "", http.StatusForbidden, CodeSponsorGrantInvalid},
+		{"not base64", "%%%", otherSig, http.StatusForbidden, CodeSponsorGrantInvalid},
+	}
+	for name, g := range map[string]payments.SponsorGrant{"expired": expired, "other chain": otherChain, "other user": otherUser} {
+		grant, sig := signedGrant(t, key, g)
+		cases = append(cases, grantCase{name, grant, sig, http.StatusForbidden, CodeSponsorGrantInvalid})
+	}
+	grant, sig := signedGrant(t, key, tooSmall)
+	cases = append(cases, grantCase{"over max amount", grant, sig, http.StatusPaymentRequired, CodeSponsorCapExceeded})
+
+	for _, tc := range cases {
+		resp := postPayment(t, h, paymentHeaderV2{Signature: "0xsig", Nonce: payment.Nonce, SponsorGrant: tc.grant, SponsorSignature: tc.sig})
+		if body := decodeErrorBody(t, resp); resp.StatusCode != tc.status || body.Code != tc.code {
+			t.Errorf("%s: expected %d %s, got %d %+v", tc.name, tc.status, tc.code, resp.StatusCode, body)
+		}
+	}
+	if h.AI.Calls() != 0 {
+		t.Errorf("expected no AI calls for refused grants, got %d", h.AI.Calls())
+	}
+}
+
+func TestSponsoredPayment_EnforcesTotalCap(t *testing.T) {
+	h := testsupport.NewHarness(t, newTestRouter)
+	h.Verifier.SetValid(sponsoredUser)
+	key, err := crypto.GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	payment := offeredPayment(t, h)
+	price, err := parseTokenAmount(payment.Amount)
+	if err != nil {
+		t.Fatal(err)
+	}
+	grant, sig := signedGrant(t, key, payments.SponsorGrant{
+		ID: "grant-cap", Users: []string{sponsoredUser}, MaxAmount: payment.Amount, TotalCap: formatTokenAmount(2 * price),
+		ChainID: payment.ChainID, Expiry: time.Now().Add(time.Hour).Unix(),
+	})
+	pay := func(nonce string) *http.Response {
+		return postPayment(t, h, paymentHeaderV2{Signature: "0xsig", Nonce: nonce, SponsorGrant: grant, SponsorSignature: sig})
+	}
+
+	if resp := pay(payment.Nonce); resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected the first payment within the cap, got %d", resp.StatusCode)
+	}
+	// A replayed nonce is refused without using up the grant.
+	if body := decodeErrorBody(t, pay(payment.Nonce)); body.Code != CodeNonceReplayed {
+		t.Fatalf("expected %s, got %+v", CodeNonceReplayed, body)
+	}
+	if resp := pay(offeredPayment(t, h).Nonce); resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected the second payment within the cap, got %d", resp.StatusCode)
+	}
+	resp := pay(offeredPayment(t, h).Nonce)
+	if body := decodeErrorBody(t, resp); resp.StatusCode != http.StatusPaymentRequired || body.Code != CodeSponsorCapExceeded {
+		t.Errorf("expected 402 %s, got %d %+v", CodeSponsorCapExceeded, resp.StatusCode, body)
+	}
+}
+
+func TestSponsoredPayment_FailedCallReleasesTotalCap(t *testing.T) {
+	h := testsupport.NewHarness(t, newTestRouter)
+	h.Verifier.SetValid(sponsoredUser)
+	key, err := crypto.GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	payment := offeredPayment(t, h)
+	grant, sig := signedGrant(t, key, payments.SponsorGrant{
+		ID: "grant-refund", Users: []string{sponsoredUser}, MaxAmount: payment.Amount, TotalCap: payment.Amount,
+		ChainID: payment.ChainID, Expiry: time.Now().Add(time.Hour).Unix(),
+	})
+	pay := func(nonce string) *http.Response {
+		return postPayment(t, h, paymentHeaderV2{Signature: "0xsig", Nonce: nonce, SponsorGrant: grant, SponsorSignature: sig})
+	}
+
+	h.AI.SetStatus(http.StatusInternalServerError)
+	resp := pay(payment.Nonce)
+	if body := decodeErrorBody(t, resp); body.Code != CodeAIFailed {
+		t.Fatalf("expected %s, got %d %+v", CodeAIFailed, resp.StatusCode, body)
+	}
+
+	// The grant covers a single payment, which the failed call gave back.
+	h.AI.SetStatus(http.StatusOK)
+	if resp := pay(offeredPayment(t, h).Nonce); resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected the grant's cap to be unchanged, got %d %+v", resp.StatusCode, decodeErrorBody(t, resp))
+	}
+}
@@ -0,0 +1,363 @@
+package server
+
+import (
+	"math"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// statsBucketWidth is the granularity of the rolling stats; statsBuckets of
+// them cover the longest window reported.
+const (
+	statsBucketWidth = time.Minute
+	statsBuckets     = 60
+)
+
+// statsTiers are the rate limit tiers requests are counted under.
+var statsTiers = [...]string{"anonymous", "standard", "verified"}
+
+// statsWindows are the rolling windows GET /api/admin/stats reports.
+var statsWindows = []struct {
+	name string
+	span time.Duration
+}{
+	{"1m", time.Minute},
+	{"5m", 5 * time.Minute},
+	{"1h", time.Hour},
+}
+
+// statsCounters are the counters kept per bucket and since start.
+type statsCounters struct {
+	requests    [len(statsTiers)]atomic.Int64
+	revenue     atomic.Int64 // token base units
+	cacheHits   atomic.Int64
+	cacheMisses atomic.Int64
+	aiCalls     atomic.Int64
+	aiLatencyUs atomic.Int64
+	verifyFails [len(verifyFailureReasons)]atomic.Int64
+	// admissionShed counts requests shed by the priority lanes per tier.
+	admissionShed [len(statsTiers)]atomic.Int64
+	// panics counts handler panics recovered with a 500.
+	panics atomic.Int64
+	// Delivered requests: what they were charged and what the provider
+	// calls behind them cost, in token base units, and their tokens.
+	deliveredRevenue atomic.Int64
+	providerCost     atomic.Int64
+	promptTokens     atomic.Int64
+	completionTokens atomic.Int64
+}
+
+func (s *statsCounters) reset() {
+	for i := range s.requests {
+		s.requests[i].Store(0)
+	}
+	s.revenue.Store(0)
+	s.cacheHits.Store(0)
+	s.cacheMisses.Store(0)
+	s.aiCalls.Store(0)
+	s.aiLatencyUs.Store(0)
+	for i := range s.verifyFails {
+		s.verifyFails[i].Store(0)
+	}
+	for i := range s.admissionShed {
+		s.admissionShed[i].Store(0)
+	}
+	s.panics.Store(0)
+	s.deliveredRevenue.Store(0)
+	s.providerCost.Store(0)
+	s.promptTokens.Store(0)
+	s.completionTokens.Store(0)
+}
+
+// statsBucket holds the counters of one bucket-wide period, identified by
+// its index since the Unix epoch.
+type statsBucket struct {
+	period atomic.Int64
+	statsCounters
+}
+
+// statsAggregator keeps live operational counters in memory: a ring of
+// per-minute buckets for the rolling windows and running totals since the
+// process started. Recording is lock-free except when a bucket is reused
+// for a new minute.
+type statsAggregator struct {
+	started time.Time
+	total   statsCounters
+	buckets [statsBuckets]statsBucket
+	rotate  sync.Mutex
+}
+
+func newStatsAggregator() *statsAggregator {
+	return &statsAggregator{started: time.Now()}
+}
+
+var gatewayStats = newStatsAggregator()
+
+// bucket returns the bucket for at, clearing it first if it last held an
+// older period.
+func (a *statsAggregator) bucket(at time.Time) *statsCounters {
+	period := at.UnixNano() / int64(statsBucketWidth)
+	b := &a.buckets[period%statsBuckets]
+	if b.period.Load() != period {
+		a.rotate.Lock()
+		if b.period.Load() != period {
+			b.reset()
+			b.period.Store(period)
+		}
+		a.rotate.Unlock()
+	}
+	return &b.statsCounters
+}
+
+// add applies update to the bucket for at and to the totals.
+func (a *statsAggregator) add(at time.Time, update func(*statsCounters)) {
+	update(a.bucket(at))
+	update(&a.total)
+}
+
+// RecordRequest counts a request in tier.
+func (a *statsAggregator) RecordRequest(at time.Time, tier string) {
+	i := statsTierIndex(tier)
+	a.add(at, func(s *statsCounters) { s.requests[i].Add(1) })
+}
+
+// RecordRevenue adds a verified payment of units token base units.
+func (a *statsAggregator) RecordRevenue(at time.Time, units int64) {
+	a.add(at, func(s *statsCounters) { s.revenue.Add(units) })
+}
+
+// RecordCache counts a response cache lookup.
+func (a *statsAggregator) RecordCache(at time.Time, hit bool) {
+	a.add(at, func(s *statsCounters) {
+		if hit {
+			s.cacheHits.Add(1)
+		} else {
+			s.cacheMisses.Add(1)
+		}
+	})
+}
+
+// RecordAICall adds the latency of one AI provider call.
+func (a *statsAggregator) RecordAICall(at time.Time, latency time.Duration) {
+	a.add(at, func(s *statsCounters) {
+		s.aiCalls.Add(1)
+		s.aiLatencyUs.Add(latency.Microseconds())
+	})
+}
+
+// RecordVerifyFailure counts a refused payment signature under reason.
+func (a *statsAggregator) RecordVerifyFailure(at time.Time, reason string) {
+	i := statsVerifyReasonIndex(reason)
+	a.add(at, func(s *statsCounters) { s.verifyFails[i].Add(1) })
+}
+
+// RecordAdmissionShed counts a request of tier shed by the priority lanes.
+func (a *statsAggregator) RecordAdmissionShed(at time.Time, tier string) {
+	i := statsTierIndex(tier)
+	a.add(at, func(s *statsCounters) { s.admissionShed[i].Add(1) })
+}
+
+// RecordPanic counts a recovered handler panic.
+func (a *statsAggregator) RecordPanic(at time.Time) {
+	a.add(at, func(s *statsCounters) { s.panics.Add(1) })
+}
+
+// RecordMargin adds a delivered request charged revenue whose provider calls
+// cost cost, both in token base units, and used usage's tokens.
+func (a *statsAggregator) RecordMargin(at time.Time, revenue, cost int64, usage ProviderUsage) {
+	a.add(at, func(s *statsCounters) {
+		s.deliveredRevenue.Add(revenue)
+		s.providerCost.Add(cost)
+		s.promptTokens.Add(usage.PromptTokens)
+		s.completionTokens.Add(usage.CompletionTokens)
+	})
+}
+
+// StatsWindow summarizes the counters of one window.
+type StatsWindow struct {
+	Requests       map[string]int64 `json:"requests"`
+	RequestsTotal  int64            `json:"requests_total"`
+	Revenue        string           `json:"revenue"`
+	CacheHits      int64            `json:"cache_hits"`
+	CacheMisses    int64            `json:"cache_misses"`
+	CacheHitRate   float64          `json:"cache_hit_rate"`
+	AICalls        int64            `json:"ai_calls"`
+	AvgAILatencyMs float64          `json:"avg_ai_latency_ms"`
+	VerifyFailures map[string]int64 `json:"verify_failures"`
+	AdmissionShed  map[string]int64 `json:"admission_shed"`
+	Panics         int64            `json:"panics"`
+	// ProviderCost and Margin cover delivered requests only; Revenue also
+	// counts payments that were refunded.
+	ProviderCost     string  `json:"provider_cost"`
+	Margin           string  `json:"margin"`
+	MarginPct        float64 `json:"margin_pct"`
+	PromptTokens     int64   `json:"prompt_tokens"`
+	CompletionTokens int64   `json:"completion_tokens"`
+}
+
+// summarize sums counters into a window summary.
+func summarize(counters ...*statsCounters) StatsWindow {
+	w := StatsWindow{
+		Requests:       make(map[string]int64, len(statsTiers)),
+		VerifyFailures: make(map[string]int64, len(verifyFailureReasons)),
+		AdmissionShed:  make(map[string]int64, len(statsTiers)),
+	}
+	var revenue, latencyUs, delivered, cost int64
+	for _, tier := range statsTiers {
+		w.Requests[tier] = 0
+		w.AdmissionShed[tier] = 0
+	}
+	for _, reason := range verifyFailureReasons {
+		w.VerifyFailures[reason] = 0
+	}
+	for _, s := range counters {
+		for i, tier := range statsTiers {
+			n := s.requests[i].Load()
+			w.Requests[tier] += n
+			w.RequestsTotal += n
+			w.AdmissionShed[tier] += s.admissionShed[i].Load()
+		}
+		revenue += s.revenue.Load()
+		w.CacheHits += s.cacheHits.Load()
+		w.CacheMisses += s.cacheMisses.Load()
+		w.AICalls += s.aiCalls.Load()
+		w.Panics += s.panics.Load()
+		delivered += s.deliveredRevenue.Load()
+		cost += s.providerCost.Load()
+		w.PromptTokens += s.promptTokens.Load()
+		w.CompletionTokens += s.completionTokens.Load()
+		latencyUs += s.aiLatencyUs.Load()
+		for i, reason := range verifyFailureReasons {
+			w.VerifyFailures[reason] += s.verifyFails[i].Load()
+		}
+	}
+	w.Revenue = formatTokenAmount(revenue)
+	w.ProviderCost = formatTokenAmount(cost)
+	w.Margin = formatTokenAmount(delivered - cost)
+	if delivered > 0 {
+		w.MarginPct = math.Round(float64(delivered-cost)/float64(delivered)*10000) / 100
+	}
+	if lookups := w.CacheHits + w.CacheMisses; lookups > 0 {
+		w.CacheHitRate = math.Round(float64(w.CacheHits)/float64(lookups)*10000) / 10000
+	}
+	if w.AICalls > 0 {
+		w.AvgAILatencyMs = math.Round(float64(latencyUs)/float64(w.AICalls)) / 1000
+	}
+	return w
+}
+
+// Window summarizes the span before now, in whole buckets including the
+// current one.
+func (a *statsAggregator) Window(now time.Time, span time.Duration) StatsWindow {
+	current := now.UnixNano() / int64(statsBucketWidth)
+	n := min(int64(span/statsBucketWidth), statsBuckets)
+	var counters []*statsCounters
+	for i := range a.buckets {
+		b := &a.buckets[i]
+		if p := b.period.Load(); p <= current && p > current-n {
+			counters = append(counters, &b.statsCounters)
+		}
+	}
+	return summarize(counters...)
+}
+
+// Total summarizes everything recorded since start.
+func (a *statsAggregator) Total() StatsWindow {
+	return summarize(&a.total)
+}
+
+// statsTierIndex maps tier to its statsTiers index; unknown tiers count as
+// anonymous.
+func statsTierIndex(tier string) int {
+	for i, t := range statsTiers {
+		if t == tier {
+			return i
+		}
+	}
+	return 0
+}
+
+// statsMiddleware counts every request by the rate limit tier it was
+// assigned, or by whether it is signed when rate limiting is off. Health
+// checks and the admin API are not counted.
+func statsMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Next()
+		path := c.Request.URL.Path
+		if path == "/healthz" || path == "/readyz" || strings.HasPrefix(path, "/api/admin/") {
+			return
+		}
+		tier := c.GetString("rate_limit_tier")
+		if tier == "" {
+			tier = "anonymous"
+			if c.GetHeader("X-402-Signature") != "" && c.GetHeader("X-402-Nonce") != "" {
+				tier = "standard"
+			}
+		}
+		gatewayStats.RecordRequest(time.Now(), tier)
+	}
+}
+
+// recordVerifiedRevenue adds a verified payment of amount to the stats.
+func recordVerifiedRevenue(amount string) {
+	if units, err := parseTokenAmount(amount); err == nil {
+		gatewayStats.RecordRevenue(time.Now(), units)
+	}
+}
+
+// rateLimitBuckets counts the active token buckets per tier.
+func rateLimitBuckets() map[string]int {
+	counts := make(map[string]int)
+	for tier, limiter := range getActiveRateLimiters() {
+		if l, ok := limiter.(interface{ Len() int }); ok {
+			counts[tier] = l.Len()
+		}
+	}
+	return counts
+}
+
+// receiptStoreSize returns the number of receipts held in memory, including
+// expired ones not yet cleaned up.
+func receiptStoreSize() int {
+	receiptStoreMu.RLock()
+	defer receiptStoreMu.RUnlock()
+	return len(receiptStore)
+}
+
+// handleStats handles GET /api/admin/stats: rolling request, revenue, cache
+// and AI latency counters for the last minute, five minutes and hour and
+// since start, provider cost and margin of delivered requests, plus the
+// current rate limit bucket and receipt store sizes.
+// Counters are per instance.
+func handleStats(c *gin.Context) {
+	now := time.Now()
+	windows := make(gin.H, len(statsWindows)+1)
+	for _, w := range statsWindows {
+		windows[w.name] = gatewayStats.Window(now, w.span)
+	}
+	windows["total"] = gatewayStats.Total()
+
+	buckets := rateLimitBuckets()
+	active := 0
+	for _, n := range buckets {
+		active += n
+	}
+
+	c.JSON(200, gin.H{
+		"started_at":     gatewayStats.started.UTC(),
+		"uptime_seconds": int64(now.Sub(gatewayStats.started).Seconds()),
+		"currency":       "USDC",
+		"windows":        windows,
+		"rate_limit": gin.H{
+			"enabled":        getRateLimitEnabled(),
+			"active_buckets": active,
+			"buckets":        buckets,
+		},
+		"receipt_store_size": receiptStoreSize(),
+		"receipt_dedupe":     receiptDedupeReport(),
+	})
+}
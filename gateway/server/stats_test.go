@@ -0,0 +1,110 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+)
+
+// withStats gives the test an empty stats aggregator.
+func withStats(t *testing.T) {
+	t.Helper()
+	prev := gatewayStats
+	gatewayStats = newStatsAggregator()
+	t.Cleanup(func() { gatewayStats = prev })
+}
+
+func TestStatsAggregator_RollingWindows(t *testing.T) {
+	a := newStatsAggregator()
+	now := time.Date(2026, 3, 1, 12, 30, 15, 0, time.UTC)
+
+	a.RecordRequest(now.Add(-2*time.Hour), "standard")
+	a.RecordRequest(now.Add(-10*time.Minute), "verified")
+	a.RecordRequest(now, "standard")
+	a.RecordRequest(now, "unknown")
+	a.RecordRevenue(now, 1500)
+	a.RecordCache(now, true)
+	a.RecordCache(now, true)
+	a.RecordCache(now, false)
+	a.RecordAICall(now, 100*time.Millisecond)
+	a.RecordAICall(now.Add(-10*time.Minute), 300*time.Millisecond)
+
+	last := a.Window(now, time.Minute)
+	if last.RequestsTotal != 2 || last.Requests["standard"] != 1 || last.Requests["anonymous"] != 1 {
+		t.Errorf("unexpected last minute requests %+v", last.Requests)
+	}
+	if last.Revenue != "0.0015" || last.CacheHitRate != 0.6667 || last.AvgAILatencyMs != 100 {
+		t.Errorf("unexpected last minute %+v", last)
+	}
+
+	hour := a.Window(now, time.Hour)
+	if hour.RequestsTotal != 3 || hour.Requests["verified"] != 1 || hour.AICalls != 2 || hour.AvgAILatencyMs != 200 {
+		t.Errorf("unexpected last hour %+v", hour)
+	}
+	// The bucket from two hours ago was reused by a later minute.
+	if total := a.Total(); total.RequestsTotal != 4 {
+		t.Errorf("expected 4 requests since start, got %d", total.RequestsTotal)
+	}
+
+	// A bucket is cleared when its slot comes round again.
+	a.RecordRequest(now.Add(time.Hour), "standard")
+	if w := a.Window(now.Add(time.Hour), time.Minute); w.RequestsTotal != 1 {
+		t.Errorf("expected a reused bucket to start empty, got %d", w.RequestsTotal)
+	}
+}
+
+// statsView is the body of GET /api/admin/stats.
+type statsView struct {
+	Windows   map[string]StatsWindow `json:"windows"`
+	RateLimit struct {
+		Enabled       bool           `json:"enabled"`
+		ActiveBuckets int            `json:"active_buckets"`
+		Buckets       map[string]int `json:"buckets"`
+	} `json:"rate_limit"`
+	ReceiptStoreSize int `json:"receipt_store_size"`
+}
+
+func TestHandleStats(t *testing.T) {
+	withStats(t)
+	withReceiptStore(t)
+	t.Setenv("ADMIN_API_KEY", "s3cret")
+	gw := startGateway(t, map[string]string{
+		"RATE_LIMIT_ENABLED": "true",
+		"PAYMENT_AMOUNT":     "0.002",
+	})
+
+	gw.Post(t, "/api/ai/summarize", `{"text":"stats"}`, "", "")
+	if resp := gw.Post(t, "/api/ai/summarize", `{"text":"stats"}`, "0xsig", "nonce-stats-1"); resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected 200, got %d", resp.StatusCode)
+	}
+	deadline := time.Now().Add(2 * time.Second)
+	for len(gw.redisKeys("ai:summary:")) == 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	gw.Post(t, "/api/ai/summarize", `{"text":"stats"}`, "0xsig", "nonce-stats-2")
+
+	w := adminGet(t, gw.Server.Config.Handler, "/api/admin/stats", "s3cret")
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+	var stats statsView
+	if err := json.Unmarshal(w.Body.Bytes(), &stats); err != nil {
+		t.Fatal(err)
+	}
+	last := stats.Windows["1m"]
+	if last.Requests["anonymous"] != 1 || last.Requests["standard"] != 2 {
+		t.Errorf("unexpected requests per tier %+v", last.Requests)
+	}
+	if last.Revenue != "0.004" || last.CacheHits != 1 || last.CacheMisses != 1 || last.CacheHitRate != 0.5 || last.AICalls != 1 {
+		t.Errorf("unexpected window %+v", last)
+	}
+	if stats.Windows["total"].RequestsTotal != 3 {
+		t.Errorf("expected the admin request not to be counted, got %+v", stats.Windows["total"])
+	}
+	// One bucket per paid nonce, one for the client IP and one for the
+	// admin request, which is rate limited but not counted.
+	if !stats.RateLimit.Enabled || stats.RateLimit.ActiveBuckets != 4 || stats.ReceiptStoreSize != 2 {
+		t.Errorf("unexpected gauges %+v, receipts %d", stats.RateLimit, stats.ReceiptStoreSize)
+	}
+}
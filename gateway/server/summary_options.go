@@ -0,0 +1,130 @@
+package server
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Summary styles accepted in the style field.
+const (
+	summaryStyleBullet   = "bullet"
+	summaryStyleAbstract = "abstract"
+	summaryStyleTLDR     = "tldr"
+)
+
+// Ranges summary shape parameters are clamped to, and the length a summary
+// has when sentences is not set.
+const (
+	defaultSummarySentences = 2
+	maxSummarySentences     = 10
+	minSummaryWords         = 10
+	maxSummaryWords         = 500
+)
+
+// summaryStyleAliases maps accepted spellings of a style to its name.
+var summaryStyleAliases = map[string]string{
+	"bullet":   summaryStyleBullet,
+	"bullets":  summaryStyleBullet,
+	"abstract": summaryStyleAbstract,
+	"tldr":     summaryStyleTLDR,
+	"tl;dr":    summaryStyleTLDR,
+	"tl-dr":    summaryStyleTLDR,
+}
+
+// normalizeSummaryStyle returns the style name for s, case-insensitively.
+// An empty style is the default prose summary.
+func normalizeSummaryStyle(s string) (string, bool) {
+	s = strings.ToLower(strings.TrimSpace(s))
+	if s == "" {
+		return "", true
+	}
+	style, ok := summaryStyleAliases[s]
+	return style, ok
+}
+
+// summarySentences is the number of sentences, or bullet points, p asks for.
+func summarySentences(p GenerationParams) int {
+	switch {
+	case p.Style == summaryStyleTLDR:
+		return 1
+	case p.Sentences != nil:
+		return *p.Sentences
+	}
+	return defaultSummarySentences
+}
+
+// summaryPriceUnits scales a route price in token units for the length p
+// asks for: summaries longer than the default cost proportionally more,
+// rounded up; shorter ones cost the same.
+func summaryPriceUnits(units int64, p GenerationParams) int64 {
+	n := int64(summarySentences(p))
+	if n <= defaultSummarySentences {
+		return units
+	}
+	return (units*n + defaultSummarySentences - 1) / defaultSummarySentences
+}
+
+// summaryPrice applies summaryPriceUnits to a decimal price. Prices that do
+// not parse are returned unchanged; route prices are validated at load.
+func summaryPrice(price string, p GenerationParams) string {
+	units, err := parseTokenAmount(price)
+	if err != nil {
+		return price
+	}
+	return formatTokenAmount(summaryPriceUnits(units, p))
+}
+
+// shapeSummary validates the style of the stored generation parameters and
+// prices the model selection for the requested length. It must run after
+// setGenerationParams and selectModelForText, and is a no-op when the cache
+// middleware already ran it. On an unknown style it aborts with 400.
+func shapeSummary(c *gin.Context) (ModelSelection, bool) {
+	if c.GetBool("summary_shaped") {
+		return getModelSelection(c), true
+	}
+	params := getGenerationParams(c)
+	style, ok := normalizeSummaryStyle(params.Style)
+	if !ok {
+		abortWithError(c, CodeInvalidRequest, "style must be one of bullet, abstract or tl;dr")
+		return ModelSelection{}, false
+	}
+	params.Style = style
+	c.Set("generation_params", params)
+
+	sel := getModelSelection(c)
+	sel.Price = summaryPrice(sel.Price, params)
+	c.Set("model_selection", sel)
+	c.Set("summary_shaped", true)
+	return sel, true
+}
+
+// summaryInstruction is the instruction part of the summarize prompt for p,
+// e.g. "Summarize this text in 2 sentences".
+func summaryInstruction(p GenerationParams) string {
+	n := summarySentences(p)
+	var s string
+	switch p.Style {
+	case summaryStyleBullet:
+		s = fmt.Sprintf("Summarize this text as %d bullet %s", n, plural(n, "point", "points"))
+	case summaryStyleAbstract:
+		s = fmt.Sprintf("Write an abstract of this text in %d %s", n, plural(n, "sentence", "sentences"))
+	case summaryStyleTLDR:
+		s = "Write a one-sentence TL;DR of this text"
+	default:
+		s = fmt.Sprintf("Summarize this text in %d %s", n, plural(n, "sentence", "sentences"))
+	}
+	if p.MaxWords != nil {
+		s += fmt.Sprintf(" using at most %d words", *p.MaxWords)
+	}
+	return s
+}
+
+// plural returns one when n is 1 and many otherwise.
+func plural(n int, one, many string) string {
+	if n == 1 {
+		return one
+	}
+	return many
+}
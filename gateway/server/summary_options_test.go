@@ -0,0 +1,81 @@
+package server
+
+import (
+	"net/http"
+	"testing"
+
+	"gateway/internal/testsupport"
+)
+
+func intPtr(v int) *int { return &v }
+
+func TestSummarizePrompt_Shape(t *testing.T) {
+	cases := []struct {
+		params GenerationParams
+		want   string
+	}{
+		{GenerationParams{}, "Summarize this text in 2 sentences: x"},
+		{GenerationParams{Sentences: intPtr(1)}, "Summarize this text in 1 sentence: x"},
+		{GenerationParams{Style: summaryStyleBullet, Sentences: intPtr(4)}, "Summarize this text as 4 bullet points: x"},
+		{GenerationParams{Style: summaryStyleAbstract, MaxWords: intPtr(50)}, "Write an abstract of this text in 2 sentences using at most 50 words: x"},
+		{GenerationParams{Style: summaryStyleTLDR, Sentences: intPtr(5), OutputLanguage: "es"}, "Write a one-sentence TL;DR of this text, writing the summary in Spanish: x"},
+	}
+	for _, tc := range cases {
+		if got := summarizePrompt("x", tc.params); got != tc.want {
+			t.Errorf("summarizePrompt(%+v) = %q, want %q", tc.params, got, tc.want)
+		}
+	}
+}
+
+func TestClampGenerationParams_SummaryShape(t *testing.T) {
+	p := clampGenerationParams(GenerationParams{Sentences: intPtr(50), MaxWords: intPtr(1)}, 1000)
+	if *p.Sentences != maxSummarySentences || *p.MaxWords != minSummaryWords {
+		t.Errorf("expected sentences and max_words to be clamped, got %d and %d", *p.Sentences, *p.MaxWords)
+	}
+	if key := generationCacheKey(GenerationParams{Sentences: intPtr(3), Style: summaryStyleBullet}); key != "sentences=3;style=bullet" {
+		t.Errorf("unexpected cache key %q", key)
+	}
+}
+
+func TestSummaryPrice(t *testing.T) {
+	for _, tc := range []struct {
+		params GenerationParams
+		want   string
+	}{
+		{GenerationParams{}, "0.001"},
+		{GenerationParams{Sentences: intPtr(1)}, "0.001"},
+		{GenerationParams{Sentences: intPtr(5)}, "0.0025"},
+		{GenerationParams{Sentences: intPtr(8), Style: summaryStyleTLDR}, "0.001"},
+	} {
+		if got := summaryPrice("0.001", tc.params); got != tc.want {
+			t.Errorf("summaryPrice(%+v) = %s, want %s", tc.params, got, tc.want)
+		}
+	}
+}
+
+func TestSummarize_ShapedSummary(t *testing.T) {
+	h := testsupport.NewHarness(t, newTestRouter)
+	body := `{"text":"hello","sentences":6,"style":"TL;DR","max_words":40}`
+
+	if got := requestQuote(t, h, `{"text":"hello","sentences":6}`).Amount; got != summaryPrice(getConfig().PaymentAmount, GenerationParams{Sentences: intPtr(6)}) {
+		t.Errorf("expected the quote to price six sentences, got %s", got)
+	}
+	resp := h.Post(t, "/api/ai/summarize", body, "0xsig", "nonce-shape-1")
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected 200, got %d", resp.StatusCode)
+	}
+	if prompt := lastPrompt(t, h); prompt != "Write a one-sentence TL;DR of this text using at most 40 words: hello" {
+		t.Errorf("unexpected prompt %q", prompt)
+	}
+	params := decodeReceiptHeader(t, resp.Header.Get("X-402-Receipt")).Receipt.Service.Parameters
+	if params == nil || params.Style != summaryStyleTLDR || params.MaxWords == nil || *params.MaxWords != 40 {
+		t.Errorf("expected the summary shape in the receipt, got %+v", params)
+	}
+
+	if resp := h.Post(t, "/api/ai/summarize", `{"text":"hello","style":"haiku"}`, "0xsig", "nonce-shape-2"); resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("expected an unknown style to be rejected, got %d", resp.StatusCode)
+	}
+	if h.AI.Calls() != 1 {
+		t.Errorf("expected one provider call, got %d", h.AI.Calls())
+	}
+}
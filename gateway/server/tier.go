@@ -0,0 +1,301 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"math/big"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"gateway/payments"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/gin-gonic/gin"
+)
+
+// maxTierCacheEntries bounds the resolver cache; it is cleared when full.
+const maxTierCacheEntries = 10000
+
+// tierCacheEntry is a cached premium-wallet decision.
+type tierCacheEntry struct {
+	verified bool
+	expires  time.Time
+}
+
+// walletTierResolver decides whether a payer wallet is premium and so gets the
+// "verified" rate limit tier. A wallet is premium if it is listed in the
+// allowlist file, is a member of the Redis set, or holds at least MinBalance
+// of an ERC-20 or ERC-721 token. Decisions are cached per address; lookup
+// errors count as "not premium" and are retried sooner.
+type walletTierResolver struct {
+	AllowlistFile string
+	RedisSet      string
+	RPCURL        string
+	TokenAddress  string
+	MinBalance    *big.Int
+	CacheTTL      time.Duration
+
+	mu         sync.Mutex
+	cache      map[string]tierCacheEntry
+	allowlist  map[string]bool
+	allowMtime time.Time
+}
+
+// walletTiers is the active resolver, nil when no premium source is set.
+var walletTiers atomic.Pointer[walletTierResolver]
+
+// newWalletTierResolver builds a resolver from the environment, or returns
+// nil when no allowlist, Redis set or token contract is configured.
+func newWalletTierResolver() *walletTierResolver {
+	r := &walletTierResolver{
+		AllowlistFile: os.Getenv("RATE_LIMIT_VERIFIED_ALLOWLIST_FILE"),
+		RedisSet:      os.Getenv("RATE_LIMIT_VERIFIED_REDIS_SET"),
+		RPCURL:        os.Getenv("RATE_LIMIT_VERIFIED_RPC_URL"),
+		TokenAddress:  os.Getenv("RATE_LIMIT_VERIFIED_TOKEN_ADDRESS"),
+		MinBalance:    big.NewInt(1),
+		CacheTTL:      getPositiveTimeout("RATE_LIMIT_TIER_CACHE_SECONDS", 300),
+		cache:         make(map[string]tierCacheEntry),
+	}
+	if v := os.Getenv("RATE_LIMIT_VERIFIED_MIN_BALANCE"); v != "" {
+		if n, ok := new(big.Int).SetString(v, 10); ok && n.Sign() > 0 {
+			r.MinBalance = n
+		} else {
+			log.Printf("Warning: Invalid value for RATE_LIMIT_VERIFIED_MIN_BALANCE: %s, using default 1", v)
+		}
+	}
+	if (r.RPCURL == "") != (r.TokenAddress == "") {
+		log.Println("Warning: RATE_LIMIT_VERIFIED_RPC_URL and RATE_LIMIT_VERIFIED_TOKEN_ADDRESS must be set together, ignoring balance check")
+		r.RPCURL, r.TokenAddress = "", ""
+	}
+	if r.TokenAddress != "" && !common.IsHexAddress(r.TokenAddress) {
+		log.Printf("Warning: Invalid RATE_LIMIT_VERIFIED_TOKEN_ADDRESS %q, ignoring balance check", r.TokenAddress)
+		r.RPCURL, r.TokenAddress = "", ""
+	}
+	if r.AllowlistFile == "" && r.RedisSet == "" && r.RPCURL == "" {
+		return nil
+	}
+	return r
+}
+
+// IsVerified reports whether addr is a premium wallet.
+func (r *walletTierResolver) IsVerified(ctx context.Context, addr common.Address) bool {
+	key := strings.ToLower(addr.Hex())
+	now := time.Now()
+
+	r.mu.Lock()
+	if e, ok := r.cache[key]; ok && now.Before(e.expires) {
+		r.mu.Unlock()
+		return e.verified
+	}
+	r.mu.Unlock()
+
+	verified, err := r.lookup(ctx, key)
+	ttl := r.CacheTTL
+	if err != nil {
+		log.Printf("[WARNING] Premium wallet lookup failed for %s: %v", key, err)
+		ttl = min(ttl, 30*time.Second)
+	}
+
+	r.mu.Lock()
+	if len(r.cache) >= maxTierCacheEntries {
+		clear(r.cache)
+	}
+	r.cache[key] = tierCacheEntry{verified: verified, expires: now.Add(ttl)}
+	r.mu.Unlock()
+	return verified
+}
+
+// lookup checks each configured source in turn, cheapest first.
+func (r *walletTierResolver) lookup(ctx context.Context, addr string) (bool, error) {
+	if r.AllowlistFile != "" {
+		listed, err := r.inAllowlist(addr)
+		if err != nil || listed {
+			return listed, err
+		}
+	}
+	if r.RedisSet != "" && redisClient != nil {
+		member, err := redisClient.SIsMember(ctx, r.RedisSet, addr).Result()
+		if err != nil || member {
+			return member, err
+		}
+	}
+	if r.RPCURL != "" {
+		balance, err := r.tokenBalance(ctx, addr)
+		if err != nil {
+			return false, err
+		}
+		return balance.Cmp(r.MinBalance) >= 0, nil
+	}
+	return false, nil
+}
+
+// inAllowlist checks the allowlist file, re-reading it when it changes. The
+// file holds one address per line; blank lines and # comments are ignored.
+func (r *walletTierResolver) inAllowlist(addr string) (bool, error) {
+	info, err := os.Stat(r.AllowlistFile)
+	if err != nil {
+		return false, err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.allowlist == nil || !info.ModTime().Equal(r.allowMtime) {
+		data, err := os.ReadFile(r.AllowlistFile)
+		if err != nil {
+			return false, err
+		}
+		list := make(map[string]bool)
+		scanner := bufio.NewScanner(bytes.NewReader(data))
+		for scanner.Scan() {
+			line, _, _ := strings.Cut(scanner.Text(), "#")
+			if line = strings.TrimSpace(line); line != "" {
+				list[strings.ToLower(line)] = true
+			}
+		}
+		r.allowlist = list
+		r.allowMtime = info.ModTime()
+		// Cached decisions may be stale now.
+		clear(r.cache)
+	}
+	return r.allowlist[addr], nil
+}
+
+// tokenBalance calls balanceOf(addr) on the token contract. The selector is
+// the same for ERC-20 and ERC-721.
+func (r *walletTierResolver) tokenBalance(ctx context.Context, addr string) (*big.Int, error) {
+	ctx, cancel := context.WithTimeout(ctx, 2*time.Second)
+	defer cancel()
+
+	data := append(common.FromHex("0x70a08231"), common.LeftPadBytes(common.HexToAddress(addr).Bytes(), 32)...)
+	result, err := ethCall(ctx, r.RPCURL, r.TokenAddress, data)
+	if err != nil {
+		return nil, err
+	}
+	if result == "0x" || result == "" {
+		return nil, fmt.Errorf("eth_call returned no data")
+	}
+	balance, ok := new(big.Int).SetString(strings.TrimPrefix(result, "0x"), 16)
+	if !ok {
+		return nil, fmt.Errorf("invalid balance %q", result)
+	}
+	return balance, nil
+}
+
+// rpcError is an error object returned by a JSON-RPC node, as opposed to a
+// failure to reach it. eth_call reports reverts this way.
+type rpcError struct {
+	Method  string
+	Message string
+}
+
+func (e *rpcError) Error() string {
+	return e.Method + ": " + e.Message
+}
+
+// rpcCall calls method with params on the JSON-RPC node at rpcURL and
+// decodes its result into result.
+func rpcCall(ctx context.Context, rpcURL, method string, params []any, result any) error {
+	reqBody, _ := json.Marshal(map[string]any{
+		"jsonrpc": "2.0",
+		"id":      1,
+		"method":  method,
+		"params":  params,
+	})
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, rpcURL, bytes.NewReader(reqBody))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	var out struct {
+		Result json.RawMessage `json:"result"`
+		Error  *struct {
+			Message string `json:"message"`
+		} `json:"error"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&out); err != nil {
+		return fmt.Errorf("decode %s response: %w", method, err)
+	}
+	if out.Error != nil {
+		return &rpcError{Method: method, Message: out.Error.Message}
+	}
+	if err := json.Unmarshal(out.Result, result); err != nil {
+		return fmt.Errorf("decode %s result: %w", method, err)
+	}
+	return nil
+}
+
+// ethCall runs eth_call of data against contract to at the latest block and
+// returns the hex result.
+func ethCall(ctx context.Context, rpcURL, to string, data []byte) (string, error) {
+	var result string
+	params := []any{map[string]string{"to": to, "data": "0x" + common.Bytes2Hex(data)}, "latest"}
+	if err := rpcCall(ctx, rpcURL, "eth_call", params, &result); err != nil {
+		return "", err
+	}
+	return result, nil
+}
+
+// isPremiumPayer recovers the payer from the payment headers, before the
+// verifier is called, and checks it with isPremium. The routed price depends
+// on the body, which the rate limiter has not read, so every configured price
+// is tried; a signature by a different key never recovers to a premium
+// wallet, whatever price it is checked against. The chain is the one named by
+// X-402-Chain-Id, as the handler will verify it. EIP-1271 payers cannot be
+// checked without a contract call and are never premium here.
+func isPremiumPayer(c *gin.Context, cfg *Config, isPremium func(common.Address) bool) bool {
+	signature := c.GetHeader("X-402-Signature")
+	nonce := c.GetHeader("X-402-Nonce")
+	recoverSigner := payments.RecoverSigner
+	switch strings.ToLower(c.GetHeader("X-402-Signature-Type")) {
+	case "", payments.SignatureTypeEIP712:
+	case payments.SignatureTypePersonalSign:
+		recoverSigner = payments.RecoverPersonalSigner
+	default:
+		return false
+	}
+	chain := cfg.PrimaryChain()
+	if raw := c.GetHeader("X-402-Chain-Id"); raw != "" {
+		id, err := strconv.Atoi(raw)
+		accepted, ok := cfg.Chain(id)
+		if err != nil || !ok {
+			return false
+		}
+		chain = accepted
+	}
+
+	prices := []string{cfg.PaymentAmount}
+	for _, route := range cfg.ModelRoutes {
+		prices = append(prices, route.Price)
+	}
+	seen := make(map[string]bool)
+	for _, price := range prices {
+		if seen[price] {
+			continue
+		}
+		seen[price] = true
+		addr, err := recoverSigner(paymentContextFor(chain, price, nonce), signature)
+		if err != nil {
+			// Malformed signatures fail for every price.
+			return false
+		}
+		if isPremium(addr) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,155 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"gateway/payments"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/gin-gonic/gin"
+)
+
+// withWalletTiers installs r as the active resolver for the test.
+func withWalletTiers(t *testing.T, r *walletTierResolver) {
+	t.Helper()
+	prev := walletTiers.Load()
+	walletTiers.Store(r)
+	t.Cleanup(func() { walletTiers.Store(prev) })
+}
+
+// fakeBalanceRPC answers eth_call with balance for every address.
+func fakeBalanceRPC(t *testing.T, balance int64, calls *atomic.Int32) *httptest.Server {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		var req struct {
+			Method string `json:"method"`
+		}
+		_ = json.NewDecoder(r.Body).Decode(&req)
+		if req.Method != "eth_call" {
+			http.Error(w, "unexpected method", http.StatusBadRequest)
+			return
+		}
+		fmt.Fprintf(w, `{"jsonrpc":"2.0","id":1,"result":"0x%064x"}`, balance)
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestWalletTierResolver_Allowlist(t *testing.T) {
+	premium := common.HexToAddress("0x742d35Cc6634C0532925a3b844Bc9e7595f8fE21")
+	path := filepath.Join(t.TempDir(), "premium.txt")
+	if err := os.WriteFile(path, []byte("# premium wallets\n"+premium.Hex()+"  # partner\n\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("RATE_LIMIT_VERIFIED_ALLOWLIST_FILE", path)
+	r := newWalletTierResolver()
+
+	ctx := context.Background()
+	if !r.IsVerified(ctx, premium) {
+		t.Error("listed wallet should be verified")
+	}
+	other := common.HexToAddress("0x0000000000000000000000000000000000000001")
+	if r.IsVerified(ctx, other) {
+		t.Error("unlisted wallet should not be verified")
+	}
+
+	// Editing the file takes effect once cached entries are re-checked.
+	if err := os.WriteFile(path, []byte(other.Hex()+"\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	future := time.Now().Add(time.Hour)
+	if err := os.Chtimes(path, future, future); err != nil {
+		t.Fatal(err)
+	}
+	r.mu.Lock()
+	clear(r.cache)
+	r.mu.Unlock()
+	if !r.IsVerified(ctx, other) || r.IsVerified(ctx, premium) {
+		t.Error("allowlist should be re-read after it changes")
+	}
+}
+
+func TestWalletTierResolver_TokenBalance(t *testing.T) {
+	var calls atomic.Int32
+	rpc := fakeBalanceRPC(t, 5, &calls)
+	t.Setenv("RATE_LIMIT_VERIFIED_RPC_URL", rpc.URL)
+	t.Setenv("RATE_LIMIT_VERIFIED_TOKEN_ADDRESS", "0x833589fCD6eDb6E08f4c7C32D4f71b54bdA02913")
+
+	addr := common.HexToAddress("0x742d35Cc6634C0532925a3b844Bc9e7595f8fE21")
+	t.Setenv("RATE_LIMIT_VERIFIED_MIN_BALANCE", "5")
+	if !newWalletTierResolver().IsVerified(context.Background(), addr) {
+		t.Error("balance at the minimum should be verified")
+	}
+
+	t.Setenv("RATE_LIMIT_VERIFIED_MIN_BALANCE", "6")
+	r := newWalletTierResolver()
+	if r.IsVerified(context.Background(), addr) || r.IsVerified(context.Background(), addr) {
+		t.Error("balance below the minimum should not be verified")
+	}
+	if got := calls.Load(); got != 2 {
+		t.Errorf("expected cached second lookup (2 RPC calls total), got %d", got)
+	}
+}
+
+func TestNewWalletTierResolver_NoSources(t *testing.T) {
+	t.Setenv("RATE_LIMIT_VERIFIED_RPC_URL", "http://localhost:8545")
+	if newWalletTierResolver() != nil {
+		t.Error("RPC URL without a token address should not enable the resolver")
+	}
+}
+
+func TestSelectRateLimitTier_PremiumWallet(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	t.Setenv("PAYMENT_AMOUNT", "0.001")
+	t.Setenv("MODEL_ROUTES", "100|small-model|0.0005,*|large-model|0.002")
+
+	key, err := crypto.GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	premium := crypto.PubkeyToAddress(key.PublicKey)
+	path := filepath.Join(t.TempDir(), "premium.txt")
+	if err := os.WriteFile(path, []byte(premium.Hex()), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("RATE_LIMIT_VERIFIED_ALLOWLIST_FILE", path)
+	withWalletTiers(t, newWalletTierResolver())
+
+	cfg := getConfig()
+	tierFor := func(signingKey string, price string) string {
+		k, _ := crypto.HexToECDSA(signingKey)
+		payment := payments.Context{Recipient: cfg.RecipientAddress, Token: "USDC", Amount: price, Nonce: "n-1", ChainID: cfg.ChainID}
+		sig, err := payments.Sign(payment, k)
+		if err != nil {
+			t.Fatal(err)
+		}
+		c, _ := gin.CreateTestContext(httptest.NewRecorder())
+		c.Request = httptest.NewRequest(http.MethodPost, "/api/ai/summarize", strings.NewReader(""))
+		c.Request.Header.Set("X-402-Signature", sig)
+		c.Request.Header.Set("X-402-Nonce", "n-1")
+		return selectRateLimitTier(c)
+	}
+
+	premiumKey := common.Bytes2Hex(crypto.FromECDSA(key))
+	for _, price := range []string{"0.0005", "0.002"} {
+		if got := tierFor(premiumKey, price); got != "verified" {
+			t.Errorf("premium wallet paying %s: expected verified, got %s", price, got)
+		}
+	}
+	otherKey, _ := crypto.GenerateKey()
+	if got := tierFor(common.Bytes2Hex(crypto.FromECDSA(otherKey)), "0.0005"); got != "standard" {
+		t.Errorf("other wallet: expected standard, got %s", got)
+	}
+}
@@ -1,4 +1,4 @@
-package main
+package server
 
 import (
 	"context"
@@ -33,6 +33,9 @@ func TestRequestTimeoutMiddleware_AbortsOnTimeout(t *testing.T) {
 	if !strings.Contains(w.Body.String(), "Gateway Timeout") {
 		t.Fatalf("Expected Gateway Timeout message, got body: %s", w.Body.String())
 	}
+	if !strings.Contains(w.Body.String(), `"code":"REQUEST_TIMEOUT"`) {
+		t.Fatalf("Expected the REQUEST_TIMEOUT code, got body: %s", w.Body.String())
+	}
 
 	// Ensure handler's response didn't slip through
 	if strings.Contains(w.Body.String(), `"ok": true`) {
@@ -55,9 +58,9 @@ func TestCallOpenRouter_RespectsContextTimeout(t *testing.T) {
 	ctx, cancel := context.WithTimeout(context.Background(), 1*time.Second)
 	defer cancel()
 
-	_, err := callOpenRouter(ctx, "hello")
+	_, err := callAIProviders(ctx, "test-model", "hello", GenerationParams{})
 	if err == nil {
-		t.Fatalf("Expected timeout error from callOpenRouter, got nil")
+		t.Fatalf("Expected timeout error from callAIProviders, got nil")
 	}
 
 	if !errors.Is(err, context.DeadlineExceeded) {
@@ -96,7 +99,7 @@ func TestHandleSummarize_AIRequestTimeoutReturns504(t *testing.T) {
 	reqBody := strings.NewReader(`{"text":"hello"}`)
 	req, _ := http.NewRequest("POST", "/api/ai/summarize", reqBody)
 	req.Header.Set("X-402-Signature", "sig")
-	req.Header.Set("X-402-Nonce", "nonce")
+	req.Header.Set("X-402-Nonce", "nonce-ai-timeout")
 	req.Header.Set("Content-Type", "application/json")
 
 	w := httptest.NewRecorder()
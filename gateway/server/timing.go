@@ -0,0 +1,159 @@
+package server
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Server-Timing metric names for the stages of a paid request.
+const (
+	timingVerify  = "verify"
+	timingCache   = "cache"
+	timingAI      = "ai"
+	timingReceipt = "receipt"
+	timingTotal   = "total"
+)
+
+// requestTimingKey is the context key of a request's *requestTiming.
+const requestTimingKey = "request_timing"
+
+// timingStage is one measured stage of a request.
+type timingStage struct {
+	name string
+	desc string
+	dur  time.Duration
+}
+
+// requestTiming collects the stages of one request. Stages may be recorded
+// more than once, e.g. a failover retry, and are reported in order.
+type requestTiming struct {
+	mu     sync.Mutex
+	start  time.Time
+	stages []timingStage
+}
+
+func (t *requestTiming) record(name, desc string, d time.Duration) {
+	t.mu.Lock()
+	t.stages = append(t.stages, timingStage{name: name, desc: desc, dur: d})
+	t.mu.Unlock()
+}
+
+// header formats the stages and the total so far as a Server-Timing value,
+// e.g. `verify;dur=12.4, cache;desc="miss";dur=0.3, total;dur=840.1`.
+func (t *requestTiming) header(now time.Time) string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	parts := make([]string, 0, len(t.stages)+1)
+	for _, s := range t.stages {
+		part := s.name
+		if s.desc != "" {
+			part += fmt.Sprintf(";desc=%q", s.desc)
+		}
+		parts = append(parts, part+";dur="+formatTimingMillis(s.dur))
+	}
+	parts = append(parts, timingTotal+";dur="+formatTimingMillis(now.Sub(t.start)))
+	return strings.Join(parts, ", ")
+}
+
+// millis returns the summed duration of each stage in milliseconds, for the
+// request log.
+func (t *requestTiming) millis() map[string]float64 {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	out := make(map[string]float64, len(t.stages))
+	for _, s := range t.stages {
+		out[s.name] += float64(s.dur.Microseconds()) / 1000
+	}
+	return out
+}
+
+func formatTimingMillis(d time.Duration) string {
+	return fmt.Sprintf("%.1f", float64(d.Microseconds())/1000)
+}
+
+// getRequestTiming returns the request's timing, or nil when it is not
+// being timed.
+func getRequestTiming(c *gin.Context) *requestTiming {
+	if v, ok := c.Get(requestTimingKey); ok {
+		return v.(*requestTiming)
+	}
+	return nil
+}
+
+// recordTiming adds a stage of duration d to the request's timing.
+func recordTiming(c *gin.Context, name, desc string, d time.Duration) {
+	if t := getRequestTiming(c); t != nil {
+		t.record(name, desc, d)
+	}
+}
+
+// recordCacheTiming records a cache lookup that started at start, described
+// as a hit or a miss.
+func recordCacheTiming(c *gin.Context, start time.Time, hit bool) {
+	desc := "miss"
+	if hit {
+		desc = "hit"
+	}
+	recordTiming(c, timingCache, desc, time.Since(start))
+}
+
+// startTiming starts timing stage name and returns the function that ends
+// it: `defer startTiming(c, timingVerify)()`.
+func startTiming(c *gin.Context, name string) func() {
+	start := time.Now()
+	return func() { recordTiming(c, name, "", time.Since(start)) }
+}
+
+// serverTimingMiddleware times the stages of the request and reports them
+// in a Server-Timing header, set when the response status is written so it
+// also reaches error responses (SERVER_TIMING, default: true).
+func serverTimingMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !getEnvAsBool("SERVER_TIMING", true) {
+			c.Next()
+			return
+		}
+		t := &requestTiming{start: time.Now()}
+		c.Set(requestTimingKey, t)
+		c.Writer = &timingWriter{ResponseWriter: c.Writer, timing: t}
+		c.Next()
+	}
+}
+
+// timingWriter sets the Server-Timing header just before the response
+// headers are written.
+type timingWriter struct {
+	gin.ResponseWriter
+	timing *requestTiming
+	once   sync.Once
+}
+
+func (w *timingWriter) setHeader() {
+	w.once.Do(func() {
+		w.ResponseWriter.Header().Set("Server-Timing", w.timing.header(time.Now()))
+	})
+}
+
+func (w *timingWriter) WriteHeader(code int) {
+	w.setHeader()
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *timingWriter) WriteHeaderNow() {
+	w.setHeader()
+	w.ResponseWriter.WriteHeaderNow()
+}
+
+func (w *timingWriter) Write(data []byte) (int, error) {
+	w.setHeader()
+	return w.ResponseWriter.Write(data)
+}
+
+func (w *timingWriter) WriteString(s string) (int, error) {
+	w.setHeader()
+	return w.ResponseWriter.WriteString(s)
+}
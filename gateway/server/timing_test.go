@@ -0,0 +1,90 @@
+package server
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"gateway/internal/testsupport"
+)
+
+// timingMetrics returns the metric names and descriptions of a Server-Timing
+// header.
+func timingMetrics(t *testing.T, header string) map[string]string {
+	t.Helper()
+	out := map[string]string{}
+	for _, metric := range strings.Split(header, ", ") {
+		params := strings.Split(metric, ";")
+		desc := ""
+		for _, p := range params[1:] {
+			if strings.HasPrefix(p, "desc=") {
+				desc = strings.Trim(strings.TrimPrefix(p, "desc="), `"`)
+			} else if !strings.HasPrefix(p, "dur=") {
+				t.Errorf("unexpected parameter %q in %q", p, header)
+			}
+		}
+		out[params[0]] = desc
+	}
+	return out
+}
+
+func TestServerTiming_PaidSummary(t *testing.T) {
+	withMemoryCache(t)
+	h := testsupport.NewHarness(t, newTestRouter)
+
+	resp := h.Post(t, "/api/ai/summarize", `{"text":"hello"}`, "0xsig", "timing-1")
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected 200, got %d", resp.StatusCode)
+	}
+	metrics := timingMetrics(t, resp.Header.Get("Server-Timing"))
+	for _, name := range []string{timingVerify, timingAI, timingReceipt, timingTotal} {
+		if _, ok := metrics[name]; !ok {
+			t.Errorf("expected %s in %q", name, resp.Header.Get("Server-Timing"))
+		}
+	}
+	if metrics[timingCache] != "miss" {
+		t.Errorf("expected a cache miss, got %q", resp.Header.Get("Server-Timing"))
+	}
+
+	// The cache is filled in the background.
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		resp = h.Post(t, "/api/ai/summarize", `{"text":"hello"}`, "0xsig", "timing-2-"+time.Now().String())
+		metrics = timingMetrics(t, resp.Header.Get("Server-Timing"))
+		if metrics[timingCache] == "hit" || time.Now().After(deadline) {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if _, called := metrics[timingAI]; metrics[timingCache] != "hit" || called {
+		t.Errorf("expected a cache hit without an AI call, got %q", resp.Header.Get("Server-Timing"))
+	}
+}
+
+func TestServerTiming_ErrorsAndOff(t *testing.T) {
+	h := testsupport.NewHarness(t, newTestRouter)
+	resp := h.Post(t, "/api/ai/summarize", `{"text":"hello"}`, "", "")
+	if got := resp.Header.Get("Server-Timing"); resp.StatusCode != http.StatusPaymentRequired || !strings.HasPrefix(got, timingTotal+";dur=") {
+		t.Errorf("expected the 402 to report the total, got %d %q", resp.StatusCode, got)
+	}
+
+	t.Setenv("SERVER_TIMING", "false")
+	if got := h.Post(t, "/api/ai/summarize", `{"text":"hello"}`, "", "").Header.Get("Server-Timing"); got != "" {
+		t.Errorf("expected no Server-Timing when off, got %q", got)
+	}
+}
+
+func TestRequestTiming_Header(t *testing.T) {
+	start := time.Now()
+	rt := &requestTiming{start: start}
+	rt.record(timingVerify, "", 12340*time.Microsecond)
+	rt.record(timingCache, "miss", 300*time.Microsecond)
+	want := `verify;dur=12.3, cache;desc="miss";dur=0.3, total;dur=40.0`
+	if got := rt.header(start.Add(40 * time.Millisecond)); got != want {
+		t.Errorf("expected %q, got %q", want, got)
+	}
+	if ms := rt.millis(); ms[timingVerify] != 12.34 || ms[timingCache] != 0.3 {
+		t.Errorf("unexpected millis %v", ms)
+	}
+}
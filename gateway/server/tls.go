@@ -0,0 +1,89 @@
+package server
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net/http"
+	"os"
+
+	"golang.org/x/crypto/acme/autocert"
+)
+
+// tlsSettings describes how the gateway terminates TLS. Static certificates
+// and Let's Encrypt (autocert) are mutually exclusive; with neither set the
+// gateway serves plain HTTP and expects a reverse proxy in front of it.
+type tlsSettings struct {
+	CertFile         string
+	KeyFile          string
+	AutocertDomains  []string
+	AutocertCacheDir string
+	AutocertEmail    string
+	ChallengeAddr    string
+	CleartextHTTP2   bool
+}
+
+// loadTLSSettings reads TLS configuration from the environment.
+func loadTLSSettings() tlsSettings {
+	return tlsSettings{
+		CertFile:         os.Getenv("TLS_CERT_FILE"),
+		KeyFile:          os.Getenv("TLS_KEY_FILE"),
+		AutocertDomains:  getEnvAsList("TLS_AUTOCERT_DOMAINS", nil),
+		AutocertCacheDir: getEnv("TLS_AUTOCERT_CACHE_DIR", "certs"),
+		AutocertEmail:    os.Getenv("TLS_AUTOCERT_EMAIL"),
+		ChallengeAddr:    ":" + getEnv("TLS_AUTOCERT_HTTP_PORT", "80"),
+		CleartextHTTP2:   getEnvAsBool("HTTP2_CLEARTEXT_ENABLED", false),
+	}
+}
+
+// validate rejects ambiguous or incomplete TLS settings.
+func (s tlsSettings) validate() error {
+	if (s.CertFile == "") != (s.KeyFile == "") {
+		return fmt.Errorf("TLS_CERT_FILE and TLS_KEY_FILE must be set together")
+	}
+	if s.CertFile != "" && len(s.AutocertDomains) > 0 {
+		return fmt.Errorf("TLS_CERT_FILE and TLS_AUTOCERT_DOMAINS cannot both be set")
+	}
+	return nil
+}
+
+// configureServer prepares srv for the configured transport and returns the
+// function that starts serving. When autocert is enabled it also returns an
+// HTTP server that answers ACME HTTP-01 challenges and redirects everything
+// else to HTTPS; the caller is responsible for running it.
+//
+// HTTP/2 is negotiated automatically over TLS via ALPN. Without TLS it is
+// only offered (as h2c) when HTTP2_CLEARTEXT_ENABLED is set, which is useful
+// behind load balancers that speak h2c to their backends.
+func configureServer(srv *http.Server, s tlsSettings) (serve func() error, challenge *http.Server, err error) {
+	if err := s.validate(); err != nil {
+		return nil, nil, err
+	}
+
+	switch {
+	case s.CertFile != "":
+		srv.TLSConfig = &tls.Config{MinVersion: tls.VersionTLS12}
+		return func() error { return srv.ListenAndServeTLS(s.CertFile, s.KeyFile) }, nil, nil
+
+	case len(s.AutocertDomains) > 0:
+		m := &autocert.Manager{
+			Prompt:     autocert.AcceptTOS,
+			HostPolicy: autocert.HostWhitelist(s.AutocertDomains...),
+			Cache:      autocert.DirCache(s.AutocertCacheDir),
+			Email:      s.AutocertEmail,
+		}
+		// m.TLSConfig advertises h2 and http/1.1 (plus the ACME ALPN protocol).
+		srv.TLSConfig = m.TLSConfig()
+		srv.TLSConfig.MinVersion = tls.VersionTLS12
+		challenge = &http.Server{Addr: s.ChallengeAddr, Handler: m.HTTPHandler(nil)}
+		// Certificates come from the manager, so no files are passed here.
+		return func() error { return srv.ListenAndServeTLS("", "") }, challenge, nil
+
+	default:
+		if s.CleartextHTTP2 {
+			srv.Protocols = new(http.Protocols)
+			srv.Protocols.SetHTTP1(true)
+			srv.Protocols.SetUnencryptedHTTP2(true)
+		}
+		return srv.ListenAndServe, nil, nil
+	}
+}
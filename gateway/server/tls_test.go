@@ -0,0 +1,166 @@
+package server
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// writeSelfSignedCert writes a localhost certificate and key to dir.
+func writeSelfSignedCert(t *testing.T, dir string) (certFile, keyFile string) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("generate key: %v", err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "localhost"},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("create certificate: %v", err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatalf("marshal key: %v", err)
+	}
+
+	certFile = filepath.Join(dir, "cert.pem")
+	keyFile = filepath.Join(dir, "key.pem")
+	if err := os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o600); err != nil {
+		t.Fatalf("write cert: %v", err)
+	}
+	if err := os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0o600); err != nil {
+		t.Fatalf("write key: %v", err)
+	}
+	return certFile, keyFile
+}
+
+func TestTLSSettings_Validate(t *testing.T) {
+	cases := []struct {
+		name    string
+		s       tlsSettings
+		wantErr bool
+	}{
+		{"plain http", tlsSettings{}, false},
+		{"static cert", tlsSettings{CertFile: "c.pem", KeyFile: "k.pem"}, false},
+		{"autocert", tlsSettings{AutocertDomains: []string{"api.example.com"}}, false},
+		{"cert without key", tlsSettings{CertFile: "c.pem"}, true},
+		{"cert and autocert", tlsSettings{CertFile: "c.pem", KeyFile: "k.pem", AutocertDomains: []string{"a"}}, true},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			if err := tc.s.validate(); (err != nil) != tc.wantErr {
+				t.Errorf("validate() error = %v, wantErr %v", err, tc.wantErr)
+			}
+		})
+	}
+}
+
+func TestConfigureServer_AutocertProvidesChallengeServer(t *testing.T) {
+	srv := &http.Server{Addr: ":0"}
+	_, challenge, err := configureServer(srv, tlsSettings{
+		AutocertDomains:  []string{"api.example.com"},
+		AutocertCacheDir: t.TempDir(),
+		ChallengeAddr:    ":8080",
+	})
+	if err != nil {
+		t.Fatalf("configureServer failed: %v", err)
+	}
+	if challenge == nil || challenge.Addr != ":8080" {
+		t.Fatalf("expected ACME challenge server on :8080, got %+v", challenge)
+	}
+	if srv.TLSConfig == nil || srv.TLSConfig.NextProtos[0] != "h2" {
+		t.Errorf("expected autocert TLS config to prefer h2, got %+v", srv.TLSConfig)
+	}
+}
+
+func TestConfigureServer_StaticCertServesHTTP2ThroughTimeoutMiddleware(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.Use(RequestTimeoutMiddleware(time.Second))
+	r.GET("/flush", func(c *gin.Context) {
+		// Flushing mid-handler must not commit a 200 before the buffered
+		// status is written.
+		c.Writer.Flush()
+		c.JSON(http.StatusCreated, gin.H{"proto": c.Request.Proto})
+	})
+
+	certFile, keyFile := writeSelfSignedCert(t, t.TempDir())
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	srv := &http.Server{Handler: r}
+	if _, _, err := configureServer(srv, tlsSettings{CertFile: certFile, KeyFile: keyFile}); err != nil {
+		t.Fatalf("configureServer failed: %v", err)
+	}
+	go srv.ServeTLS(ln, certFile, keyFile)
+	defer srv.Close()
+
+	client := &http.Client{Transport: &http.Transport{
+		TLSClientConfig:   &tls.Config{InsecureSkipVerify: true},
+		ForceAttemptHTTP2: true,
+	}}
+	resp, err := client.Get("https://" + ln.Addr().String() + "/flush")
+	if err != nil {
+		t.Fatalf("GET failed: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.ProtoMajor != 2 {
+		t.Errorf("expected HTTP/2, got %s", resp.Proto)
+	}
+	if resp.StatusCode != http.StatusCreated {
+		t.Errorf("expected 201 to survive Flush, got %d", resp.StatusCode)
+	}
+}
+
+func TestConfigureServer_CleartextHTTP2(t *testing.T) {
+	srv := &http.Server{}
+	if _, _, err := configureServer(srv, tlsSettings{CleartextHTTP2: true}); err != nil {
+		t.Fatalf("configureServer failed: %v", err)
+	}
+	if srv.Protocols == nil || !srv.Protocols.UnencryptedHTTP2() || !srv.Protocols.HTTP1() {
+		t.Fatalf("expected HTTP/1 and h2c to be enabled, got %v", srv.Protocols)
+	}
+
+	ts := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(r.Proto))
+	}))
+	ts.Config.Protocols = srv.Protocols
+	ts.Start()
+	defer ts.Close()
+
+	protocols := new(http.Protocols)
+	protocols.SetUnencryptedHTTP2(true)
+	client := &http.Client{Transport: &http.Transport{Protocols: protocols}}
+	resp, err := client.Get(ts.URL)
+	if err != nil {
+		t.Fatalf("GET failed: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.ProtoMajor != 2 {
+		t.Errorf("expected h2c response, got %s", resp.Proto)
+	}
+}
@@ -0,0 +1,196 @@
+package server
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ContextWindowConfig holds the context window, in tokens, of each model.
+// Models without an entry use Default; a window of 0 is not checked.
+type ContextWindowConfig struct {
+	Windows map[string]int
+	Default int
+}
+
+// For returns model's context window, or 0 if it is not checked.
+func (cw ContextWindowConfig) For(model string) int {
+	if n, ok := cw.Windows[model]; ok {
+		return n
+	}
+	return cw.Default
+}
+
+// parseContextWindows parses MODEL_CONTEXT_WINDOWS, a comma-separated list of
+// "model=tokens" entries. Model IDs may contain ':' and '/', so '=' separates
+// the window.
+func parseContextWindows(s string) (map[string]int, error) {
+	windows := make(map[string]int)
+	for _, entry := range strings.Split(s, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		i := strings.LastIndex(entry, "=")
+		if i <= 0 {
+			return nil, fmt.Errorf("context window %q must be model=tokens", entry)
+		}
+		n, err := strconv.Atoi(strings.TrimSpace(entry[i+1:]))
+		if err != nil || n <= 0 {
+			return nil, fmt.Errorf("context window %q: tokens must be a positive integer", entry)
+		}
+		windows[strings.TrimSpace(entry[:i])] = n
+	}
+	return windows, nil
+}
+
+// messageOverheadTokens covers the chat format's role and separator tokens
+// around the single user message, plus the reply primer.
+const messageOverheadTokens = 7
+
+// countTokens approximates the token count of text under a GPT-style BPE
+// tokenizer (cl100k). It splits text the way the tokenizer's pre-tokenizer
+// does and prices each piece by its shape instead of looking it up in a
+// vocabulary, so it errs on the high side for long or rare words.
+//
+//   - a letter run, with an optional leading space or symbol: one token per
+//     four ASCII letters, per two letters of 2-byte scripts (Latin accents,
+//     Cyrillic, Greek...) and per letter of wider scripts (CJK...)
+//   - a digit run: one token per three digits
+//   - an English contraction suffix ('s, 't, 're, 've, 'm, 'll, 'd): one
+//   - a punctuation run: one token per two symbols
+//   - a whitespace run: one
+func countTokens(text string) int {
+	tokens := 0
+	for i := 0; i < len(text); {
+		r, size := utf8.DecodeRuneInString(text[i:])
+		switch {
+		case r == '\'' && contractionLen(text[i:]) > 0:
+			i += contractionLen(text[i:])
+			tokens++
+		case unicode.IsLetter(r):
+			var n int
+			i, n = scanLetters(text, i)
+			tokens += n
+		case !unicode.IsNumber(r) && r != '\r' && r != '\n' && nextIsLetter(text[i+size:]):
+			// A leading space or symbol joins the word that follows.
+			var n int
+			i, n = scanLetters(text, i+size)
+			tokens += n
+		case unicode.IsNumber(r):
+			digits := 0
+			for i < len(text) {
+				r, size := utf8.DecodeRuneInString(text[i:])
+				if !unicode.IsNumber(r) {
+					break
+				}
+				digits++
+				i += size
+			}
+			tokens += (digits + 2) / 3
+		case unicode.IsSpace(r):
+			for i < len(text) {
+				r, size := utf8.DecodeRuneInString(text[i:])
+				if !unicode.IsSpace(r) {
+					break
+				}
+				i += size
+			}
+			tokens++
+		default:
+			symbols := 0
+			for i < len(text) {
+				r, size := utf8.DecodeRuneInString(text[i:])
+				if unicode.IsLetter(r) || unicode.IsNumber(r) || unicode.IsSpace(r) {
+					break
+				}
+				symbols++
+				i += size
+			}
+			tokens += (symbols + 1) / 2
+		}
+	}
+	return tokens
+}
+
+// contractionLen returns the byte length of an English contraction suffix at
+// the start of s, or 0.
+func contractionLen(s string) int {
+	lower := strings.ToLower(s[:min(len(s), 3)])
+	for _, suffix := range []string{"'ll", "'re", "'ve", "'s", "'t", "'m", "'d"} {
+		if strings.HasPrefix(lower, suffix) {
+			return len(suffix)
+		}
+	}
+	return 0
+}
+
+// nextIsLetter reports whether s starts with a letter.
+func nextIsLetter(s string) bool {
+	r, _ := utf8.DecodeRuneInString(s)
+	return unicode.IsLetter(r)
+}
+
+// scanLetters consumes the letter run of text starting at i and returns the
+// index after it and its estimated token count.
+func scanLetters(text string, i int) (int, int) {
+	var ascii, narrow, wide int
+	for i < len(text) {
+		r, size := utf8.DecodeRuneInString(text[i:])
+		if !unicode.IsLetter(r) {
+			break
+		}
+		switch {
+		case size == 1:
+			ascii++
+		case size == 2:
+			narrow++
+		default:
+			wide++
+		}
+		i += size
+	}
+	return i, (ascii+3)/4 + (narrow+1)/2 + wide
+}
+
+// summarizePromptTokens is the estimated size of the summarize request sent
+// to the provider for text, excluding the reply.
+func summarizePromptTokens(text string, p GenerationParams) int {
+	return countTokens(summarizePrompt(text, p)) + messageOverheadTokens
+}
+
+// checkContextWindow rejects text with 413 when its prompt plus the reply
+// budget (the request's max_tokens, if set) does not fit the context window
+// of model. It runs before payment is verified, so rejected requests are
+// never charged.
+func checkContextWindow(c *gin.Context, model, text string, params GenerationParams) bool {
+	window := modelContextWindow(getConfig(), model)
+	if window == 0 || c.GetBool("context_checked") {
+		return true
+	}
+	inputTokens := summarizePromptTokens(text, params)
+	needed := inputTokens
+	if params.MaxTokens != nil {
+		needed += *params.MaxTokens
+	}
+	if needed > window {
+		apiErr := newAPIError(CodeContextLengthExceeded,
+			fmt.Sprintf("The input needs about %d tokens but %s accepts %d; no payment was taken", needed, model, window),
+		).with(gin.H{
+			"model":          model,
+			"input_tokens":   inputTokens,
+			"context_window": window,
+		})
+		if params.MaxTokens != nil {
+			apiErr.with(gin.H{"max_tokens": *params.MaxTokens})
+		}
+		abortWithAPIError(c, apiErr)
+		return false
+	}
+	c.Set("context_checked", true)
+	return true
+}
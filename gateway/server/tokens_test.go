@@ -0,0 +1,94 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+
+	"gateway/internal/testsupport"
+)
+
+func TestCountTokens(t *testing.T) {
+	for text, want := range map[string]int{
+		"":                     0,
+		"hello":                2,
+		"hello world":          4,
+		"it's":                 2,
+		"1234567":              3,
+		"end.":                 2,
+		"...!!":                3,
+		"naïve café":           4,
+		"日本語":                  3,
+		"line one\n\nline two": 5,
+	} {
+		if got := countTokens(text); got != want {
+			t.Errorf("countTokens(%q) = %d, want %d", text, got, want)
+		}
+	}
+}
+
+func TestParseContextWindows(t *testing.T) {
+	windows, err := parseContextWindows("google/gemma-3-1b-it:free=32768, openai/gpt-4o=128000")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if windows["google/gemma-3-1b-it:free"] != 32768 || windows["openai/gpt-4o"] != 128000 {
+		t.Errorf("unexpected windows %v", windows)
+	}
+	for _, bad := range []string{"gpt-4o", "=100", "gpt-4o=0", "gpt-4o=big"} {
+		if _, err := parseContextWindows(bad); err == nil {
+			t.Errorf("%q: expected an error", bad)
+		}
+	}
+
+	t.Setenv("MODEL_CONTEXT_WINDOWS", "gpt-4o")
+	if err := loadConfig().Validate(); err == nil {
+		t.Error("invalid MODEL_CONTEXT_WINDOWS should fail validation")
+	}
+}
+
+func TestContextWindow_RejectsOversizedInputBeforePayment(t *testing.T) {
+	t.Setenv("OPENROUTER_MODEL", "small-model")
+	t.Setenv("MODEL_CONTEXT_WINDOWS", "small-model=100")
+	withJobQueue(t)
+	h := testsupport.NewHarness(t, newTestRouter)
+	long := strings.Repeat("word ", 200)
+
+	for _, path := range []string{"/api/ai/summarize", "/api/ai/jobs"} {
+		resp := h.Post(t, path, `{"text":"`+long+`"}`, "0xsig", "nonce-"+path)
+		if resp.StatusCode != http.StatusRequestEntityTooLarge {
+			t.Fatalf("%s: expected 413, got %d", path, resp.StatusCode)
+		}
+		var body struct {
+			Model         string `json:"model"`
+			InputTokens   int    `json:"input_tokens"`
+			ContextWindow int    `json:"context_window"`
+		}
+		if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+			t.Fatal(err)
+		}
+		if body.Model != "small-model" || body.ContextWindow != 100 || body.InputTokens <= 100 {
+			t.Errorf("%s: unexpected error body %+v", path, body)
+		}
+	}
+	if h.Verifier.Calls() != 0 || h.AI.Calls() != 0 {
+		t.Error("expected oversized inputs to be rejected before verification and dispatch")
+	}
+
+	// The reply budget counts against the window too.
+	if resp := h.Post(t, "/api/ai/summarize", `{"text":"short","max_tokens":95}`, "0xsig", "nonce-budget"); resp.StatusCode != http.StatusRequestEntityTooLarge {
+		t.Errorf("max_tokens over the window: expected 413, got %d", resp.StatusCode)
+	}
+	if resp := h.Post(t, "/api/ai/summarize", `{"text":"short","max_tokens":50}`, "0xsig", "nonce-fits"); resp.StatusCode != http.StatusOK {
+		t.Errorf("fitting request: expected 200, got %d", resp.StatusCode)
+	}
+}
+
+func TestContextWindow_UncheckedByDefault(t *testing.T) {
+	h := testsupport.NewHarness(t, newTestRouter)
+	resp := h.Post(t, "/api/ai/summarize", `{"text":"`+strings.Repeat("word ", 5000)+`"}`, "0xsig", "nonce-unchecked")
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("expected no context check without configured windows, got %d", resp.StatusCode)
+	}
+}
@@ -0,0 +1,479 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math/big"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"gateway/receipts"
+	"gateway/signing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/gin-gonic/gin"
+	"github.com/redis/go-redis/v9"
+)
+
+// Redis keys of the transparency log: the last root sequence, the hash of
+// signed roots by sequence, the leaves of each root and the hash locating
+// each receipt as "<sequence>:<index>".
+const (
+	transparencySeqKey         = "transparency:seq"
+	transparencyRootsKey       = "transparency:roots"
+	transparencyLeavesPrefix   = "transparency:leaves:"
+	transparencyReceiptsKey    = "transparency:receipts"
+	transparencyMaxRootsListed = 1000
+)
+
+// anchorGasLimit covers a transaction carrying a 32-byte root as calldata,
+// with room for an anchoring contract that logs it.
+const anchorGasLimit = 60000
+
+// TransparencyConfig controls periodic publication of signed Merkle roots
+// over the receipts issued in each Interval. With AnchorRPCURL set, every
+// root is also sent on chain as the calldata of a zero-value transaction
+// from the server wallet to AnchorAddress, the wallet itself by default.
+type TransparencyConfig struct {
+	Enabled       bool
+	Interval      time.Duration
+	AnchorRPCURL  string
+	AnchorAddress string
+}
+
+func (tc TransparencyConfig) validate() error {
+	if !tc.Enabled {
+		return nil
+	}
+	if tc.Interval <= 0 {
+		return fmt.Errorf("TRANSPARENCY_INTERVAL_MINUTES must be positive")
+	}
+	if tc.AnchorAddress != "" && !common.IsHexAddress(tc.AnchorAddress) {
+		return fmt.Errorf("TRANSPARENCY_ANCHOR_ADDRESS must be an address, got %q", tc.AnchorAddress)
+	}
+	return nil
+}
+
+// transparencyLeaf is a receipt waiting for the next root.
+type transparencyLeaf struct {
+	id   string
+	hash common.Hash
+}
+
+var (
+	transparencyMu          sync.Mutex
+	transparencyPending     []transparencyLeaf
+	transparencyPeriodStart time.Time
+
+	// The published log when Redis is not connected. Guarded by
+	// transparencyMu.
+	transparencyRoots     []*receipts.SignedTransparencyRoot
+	transparencyLeaves    = make(map[int64][]common.Hash)
+	transparencyPositions = make(map[string]string)
+
+	transparencyPublished      atomic.Int64
+	transparencyAnchorFailures atomic.Int64
+	transparencyLastRoot       atomic.Pointer[receipts.SignedTransparencyRoot]
+)
+
+// recordTransparencyLeaf adds receipt to the next root, when transparency is
+// enabled.
+func recordTransparencyLeaf(receipt *SignedReceipt) {
+	if !getConfig().Transparency.Enabled {
+		return
+	}
+	leaf, err := receipts.Hash(receipt.Receipt)
+	if err != nil {
+		log.Printf("[WARNING] Receipt %s left out of the transparency log: %v", receipt.Receipt.ID, err)
+		return
+	}
+	transparencyMu.Lock()
+	defer transparencyMu.Unlock()
+	if len(transparencyPending) == 0 {
+		transparencyPeriodStart = time.Now().UTC()
+	}
+	transparencyPending = append(transparencyPending, transparencyLeaf{id: receipt.Receipt.ID, hash: leaf})
+}
+
+// publishTransparencyRoot signs a root over the pending receipts, anchors
+// it when configured and stores it with its leaves. Each replica publishes
+// the receipts it issued; sequences are shared through Redis. On failure
+// the receipts stay pending for the next run.
+func publishTransparencyRoot(ctx context.Context) error {
+	transparencyMu.Lock()
+	pending, start := transparencyPending, transparencyPeriodStart
+	transparencyPending = nil
+	transparencyMu.Unlock()
+	if len(pending) == 0 {
+		return nil
+	}
+	restore := func() {
+		transparencyMu.Lock()
+		transparencyPending = append(pending, transparencyPending...)
+		transparencyPeriodStart = start
+		transparencyMu.Unlock()
+	}
+
+	signer, err := getServerSigner()
+	if err != nil {
+		restore()
+		return fmt.Errorf("failed to load server signer: %w", err)
+	}
+	seq, err := nextTransparencySequence(ctx)
+	if err != nil {
+		restore()
+		return err
+	}
+	leaves := make([]common.Hash, len(pending))
+	ids := make([]string, len(pending))
+	for i, l := range pending {
+		leaves[i], ids[i] = l.hash, l.id
+	}
+	now := time.Now().UTC()
+	signed, err := receipts.SignRoot(receipts.TransparencyRoot{
+		Sequence:    seq,
+		Version:     receipts.Version1,
+		MerkleRoot:  receipts.MerkleRoot(leaves).Hex(),
+		Count:       len(leaves),
+		PeriodStart: start,
+		PeriodEnd:   now,
+		Timestamp:   now,
+	}, signer)
+	if err != nil {
+		restore()
+		return err
+	}
+
+	cfg := getConfig().Transparency
+	if cfg.AnchorRPCURL != "" {
+		anchorCtx, cancel := context.WithTimeout(ctx, 30*time.Second)
+		chainID, tx, err := anchorRoot(anchorCtx, cfg, signer, common.HexToHash(signed.Root.MerkleRoot))
+		cancel()
+		if err != nil {
+			// The signed root is still published; only the anchor is missing.
+			transparencyAnchorFailures.Add(1)
+			log.Printf("[WARNING] Transparency root %d not anchored: %v", seq, err)
+		} else {
+			signed.AnchorChainID, signed.AnchorTx = chainID, tx
+		}
+	}
+
+	if err := saveTransparencyRoot(ctx, signed, leaves, ids); err != nil {
+		restore()
+		return err
+	}
+	transparencyPublished.Add(1)
+	transparencyLastRoot.Store(signed)
+	log.Printf("Published transparency root %d over %d receipts: %s", seq, len(leaves), signed.Root.MerkleRoot)
+	return nil
+}
+
+// nextTransparencySequence returns the sequence of the next root, shared
+// across replicas through Redis when it is connected.
+func nextTransparencySequence(ctx context.Context) (int64, error) {
+	if redisClient != nil {
+		seq, err := redisClient.Incr(ctx, transparencySeqKey).Result()
+		if err != nil {
+			return 0, fmt.Errorf("failed to increment transparency sequence: %w", err)
+		}
+		return seq, nil
+	}
+	transparencyMu.Lock()
+	defer transparencyMu.Unlock()
+	return int64(len(transparencyRoots)) + 1, nil
+}
+
+// saveTransparencyRoot stores signed with the leaves it covers and indexes
+// each receipt's position. Roots and leaves are kept without expiry, so
+// proofs outlive the receipts in the store.
+func saveTransparencyRoot(ctx context.Context, signed *receipts.SignedTransparencyRoot, leaves []common.Hash, ids []string) error {
+	seq := signed.Root.Sequence
+	if redisClient != nil {
+		rootData, err := json.Marshal(signed)
+		if err != nil {
+			return err
+		}
+		hexLeaves := make([]string, len(leaves))
+		for i, leaf := range leaves {
+			hexLeaves[i] = leaf.Hex()
+		}
+		leafData, err := json.Marshal(hexLeaves)
+		if err != nil {
+			return err
+		}
+		positions := make([]any, 0, 2*len(ids))
+		for i, id := range ids {
+			positions = append(positions, id, fmt.Sprintf("%d:%d", seq, i))
+		}
+		pipe := redisClient.Pipeline()
+		pipe.Set(ctx, transparencyLeavesPrefix+strconv.FormatInt(seq, 10), leafData, 0)
+		pipe.HSet(ctx, transparencyReceiptsKey, positions...)
+		pipe.HSet(ctx, transparencyRootsKey, strconv.FormatInt(seq, 10), rootData)
+		if _, err := pipe.Exec(ctx); err != nil {
+			return fmt.Errorf("failed to store transparency root: %w", err)
+		}
+		return nil
+	}
+
+	transparencyMu.Lock()
+	defer transparencyMu.Unlock()
+	transparencyRoots = append(transparencyRoots, signed)
+	transparencyLeaves[seq] = leaves
+	for i, id := range ids {
+		transparencyPositions[id] = fmt.Sprintf("%d:%d", seq, i)
+	}
+	return nil
+}
+
+// listTransparencyRoots returns up to limit roots with a sequence above
+// after, in sequence order.
+func listTransparencyRoots(ctx context.Context, after int64, limit int) ([]*receipts.SignedTransparencyRoot, error) {
+	list := []*receipts.SignedTransparencyRoot{}
+	if redisClient != nil {
+		last, err := redisClient.Get(ctx, transparencySeqKey).Int64()
+		if errors.Is(err, redis.Nil) {
+			return list, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to load transparency sequence: %w", err)
+		}
+		pipe := redisClient.Pipeline()
+		var gets []*redis.StringCmd
+		for seq := after + 1; seq <= last && len(gets) < limit; seq++ {
+			gets = append(gets, pipe.HGet(ctx, transparencyRootsKey, strconv.FormatInt(seq, 10)))
+		}
+		if len(gets) == 0 {
+			return list, nil
+		}
+		if _, err := pipe.Exec(ctx); err != nil && !errors.Is(err, redis.Nil) {
+			return nil, fmt.Errorf("failed to load transparency roots: %w", err)
+		}
+		for _, get := range gets {
+			// Sequences whose root failed to store are skipped.
+			data, err := get.Bytes()
+			if err != nil {
+				continue
+			}
+			var signed receipts.SignedTransparencyRoot
+			if err := json.Unmarshal(data, &signed); err != nil {
+				log.Printf("[WARNING] Skipping invalid transparency root: %v", err)
+				continue
+			}
+			list = append(list, &signed)
+		}
+		return list, nil
+	}
+
+	transparencyMu.Lock()
+	defer transparencyMu.Unlock()
+	for _, signed := range transparencyRoots {
+		if signed.Root.Sequence > after && len(list) < limit {
+			list = append(list, signed)
+		}
+	}
+	return list, nil
+}
+
+// transparencyProof returns the inclusion proof of receipt id, or nil while
+// it is not in a published root.
+func transparencyProof(ctx context.Context, id string) (*receipts.InclusionProof, error) {
+	var (
+		position string
+		signed   *receipts.SignedTransparencyRoot
+		leaves   []common.Hash
+	)
+	if redisClient != nil {
+		pos, err := redisClient.HGet(ctx, transparencyReceiptsKey, id).Result()
+		if errors.Is(err, redis.Nil) {
+			return nil, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to locate receipt: %w", err)
+		}
+		position = pos
+	} else {
+		transparencyMu.Lock()
+		position = transparencyPositions[id]
+		transparencyMu.Unlock()
+		if position == "" {
+			return nil, nil
+		}
+	}
+	seqStr, indexStr, _ := strings.Cut(position, ":")
+	seq, err := strconv.ParseInt(seqStr, 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("invalid position %q of receipt %s", position, id)
+	}
+	index, err := strconv.Atoi(indexStr)
+	if err != nil {
+		return nil, fmt.Errorf("invalid position %q of receipt %s", position, id)
+	}
+
+	if redisClient != nil {
+		rootData, err := redisClient.HGet(ctx, transparencyRootsKey, seqStr).Bytes()
+		if err != nil {
+			return nil, fmt.Errorf("failed to load transparency root %d: %w", seq, err)
+		}
+		if err := json.Unmarshal(rootData, &signed); err != nil {
+			return nil, fmt.Errorf("invalid transparency root %d: %w", seq, err)
+		}
+		leafData, err := redisClient.Get(ctx, transparencyLeavesPrefix+seqStr).Bytes()
+		if err != nil {
+			return nil, fmt.Errorf("failed to load leaves of root %d: %w", seq, err)
+		}
+		var hexLeaves []string
+		if err := json.Unmarshal(leafData, &hexLeaves); err != nil {
+			return nil, fmt.Errorf("invalid leaves of root %d: %w", seq, err)
+		}
+		for _, leaf := range hexLeaves {
+			leaves = append(leaves, common.HexToHash(leaf))
+		}
+	} else {
+		transparencyMu.Lock()
+		leaves = transparencyLeaves[seq]
+		for _, r := range transparencyRoots {
+			if r.Root.Sequence == seq {
+				signed = r
+			}
+		}
+		transparencyMu.Unlock()
+		if signed == nil {
+			return nil, fmt.Errorf("transparency root %d not found", seq)
+		}
+	}
+
+	proof, err := receipts.MerkleProof(leaves, index)
+	if err != nil {
+		return nil, err
+	}
+	return &receipts.InclusionProof{
+		ReceiptID: id,
+		Leaf:      leaves[index].Hex(),
+		Index:     index,
+		Proof:     proof,
+		Root:      signed,
+	}, nil
+}
+
+// anchorRoot sends root as the calldata of a zero-value legacy transaction
+// from the server wallet and returns the chain ID and transaction hash. It
+// does not wait for the transaction to be mined.
+func anchorRoot(ctx context.Context, cfg TransparencyConfig, signer signing.Signer, root common.Hash) (int, string, error) {
+	from := crypto.PubkeyToAddress(*signer.Public())
+	to := from
+	if cfg.AnchorAddress != "" {
+		to = common.HexToAddress(cfg.AnchorAddress)
+	}
+
+	var chainID, gasPrice hexutil.Big
+	var nonce hexutil.Uint64
+	if err := rpcCall(ctx, cfg.AnchorRPCURL, "eth_chainId", []any{}, &chainID); err != nil {
+		return 0, "", err
+	}
+	if err := rpcCall(ctx, cfg.AnchorRPCURL, "eth_getTransactionCount", []any{from.Hex(), "pending"}, &nonce); err != nil {
+		return 0, "", err
+	}
+	if err := rpcCall(ctx, cfg.AnchorRPCURL, "eth_gasPrice", []any{}, &gasPrice); err != nil {
+		return 0, "", err
+	}
+
+	tx := types.NewTx(&types.LegacyTx{
+		Nonce:    uint64(nonce),
+		To:       &to,
+		Value:    new(big.Int),
+		Gas:      anchorGasLimit,
+		GasPrice: gasPrice.ToInt(),
+		Data:     root.Bytes(),
+	})
+	txSigner := types.LatestSignerForChainID(chainID.ToInt())
+	sig, err := signer.SignHash(txSigner.Hash(tx).Bytes())
+	if err != nil {
+		return 0, "", fmt.Errorf("failed to sign anchor transaction: %w", err)
+	}
+	signedTx, err := tx.WithSignature(txSigner, sig)
+	if err != nil {
+		return 0, "", fmt.Errorf("failed to sign anchor transaction: %w", err)
+	}
+	raw, err := signedTx.MarshalBinary()
+	if err != nil {
+		return 0, "", err
+	}
+	var txHash string
+	if err := rpcCall(ctx, cfg.AnchorRPCURL, "eth_sendRawTransaction", []any{hexutil.Encode(raw)}, &txHash); err != nil {
+		return 0, "", err
+	}
+	return int(chainID.ToInt().Int64()), txHash, nil
+}
+
+// transparencyStatus is the transparency entry of /readyz.
+func transparencyStatus(cfg *Config) gin.H {
+	transparencyMu.Lock()
+	pending := len(transparencyPending)
+	transparencyMu.Unlock()
+	status := gin.H{
+		"enabled":          cfg.Transparency.Enabled,
+		"pending_receipts": pending,
+		"published_total":  transparencyPublished.Load(),
+		"anchored":         cfg.Transparency.AnchorRPCURL != "",
+		"anchor_failures":  transparencyAnchorFailures.Load(),
+	}
+	if last := transparencyLastRoot.Load(); last != nil {
+		status["last_sequence"] = last.Root.Sequence
+		status["last_published_at"] = last.Root.Timestamp
+	}
+	return status
+}
+
+// handleListTransparencyRoots handles GET /api/transparency/roots. Roots
+// are listed in sequence order from after (exclusive, default 0), at most
+// limit (default 100) at a time.
+func handleListTransparencyRoots(c *gin.Context) {
+	after, err := strconv.ParseInt(c.DefaultQuery("after", "0"), 10, 64)
+	if err != nil || after < 0 {
+		respondError(c, CodeInvalidRequest, "after must be a non-negative root sequence")
+		return
+	}
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", "100"))
+	if err != nil || limit < 1 || limit > transparencyMaxRootsListed {
+		respondError(c, CodeInvalidRequest, fmt.Sprintf("limit must be between 1 and %d", transparencyMaxRootsListed))
+		return
+	}
+	list, err := listTransparencyRoots(c.Request.Context(), after, limit)
+	if err != nil {
+		log.Printf("[ERROR] %v", err)
+		respondError(c, CodeServiceUnavailable, "The transparency log is unavailable")
+		return
+	}
+	cfg := getConfig().Transparency
+	c.JSON(200, gin.H{
+		"roots":            list,
+		"enabled":          cfg.Enabled,
+		"interval_minutes": int(cfg.Interval.Minutes()),
+	})
+}
+
+// handleGetReceiptProof handles GET /api/receipts/:id/proof with the
+// receipt's inclusion proof in a published root.
+func handleGetReceiptProof(c *gin.Context) {
+	id := c.Param("id")
+	proof, err := transparencyProof(c.Request.Context(), id)
+	if err != nil {
+		log.Printf("[ERROR] %v", err)
+		respondError(c, CodeServiceUnavailable, "The transparency log is unavailable")
+		return
+	}
+	if proof == nil {
+		respondError(c, CodeProofNotAvailable, "The receipt is not in a published transparency root")
+		return
+	}
+	c.JSON(200, proof)
+}
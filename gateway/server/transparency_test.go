@@ -0,0 +1,182 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"gateway/internal/testsupport"
+	"gateway/receipts"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/types"
+)
+
+// withTransparency enables transparency roots with env and clears the log
+// for one test.
+func withTransparency(t *testing.T, env map[string]string) {
+	t.Helper()
+	t.Setenv("TRANSPARENCY_ENABLED", "true")
+	for k, v := range env {
+		t.Setenv(k, v)
+	}
+	reset := func() {
+		transparencyMu.Lock()
+		transparencyPending = nil
+		transparencyRoots = nil
+		clear(transparencyLeaves)
+		clear(transparencyPositions)
+		transparencyMu.Unlock()
+		transparencyLastRoot.Store(nil)
+	}
+	reset()
+	t.Cleanup(reset)
+}
+
+// getReceiptProof fetches the inclusion proof of receipt id.
+func getReceiptProof(t *testing.T, h *testsupport.Harness, id string) (int, *receipts.InclusionProof) {
+	t.Helper()
+	resp := h.Get(t, "/api/receipts/"+id+"/proof")
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return resp.StatusCode, nil
+	}
+	var proof receipts.InclusionProof
+	if err := json.NewDecoder(resp.Body).Decode(&proof); err != nil {
+		t.Fatal(err)
+	}
+	return resp.StatusCode, &proof
+}
+
+func TestTransparencyConfig_Validate(t *testing.T) {
+	withTransparency(t, map[string]string{"TRANSPARENCY_INTERVAL_MINUTES": "0"})
+	if err := loadConfig().Validate(); err == nil {
+		t.Error("expected a zero interval to be rejected")
+	}
+	t.Setenv("TRANSPARENCY_INTERVAL_MINUTES", "5")
+	t.Setenv("TRANSPARENCY_ANCHOR_ADDRESS", "not-an-address")
+	if err := loadConfig().Validate(); err == nil {
+		t.Error("expected an invalid anchor address to be rejected")
+	}
+}
+
+func TestTransparency_PublishesProvableRoots(t *testing.T) {
+	withTransparency(t, nil)
+	h := testsupport.NewHarness(t, newTestRouter)
+
+	first := decodeReceiptHeader(t, paidReceipt(t, h, "nonce-tl-1"))
+	second := decodeReceiptHeader(t, paidReceipt(t, h, "nonce-tl-2"))
+	if status, _ := getReceiptProof(t, h, second.Receipt.ID); status != http.StatusNotFound {
+		t.Fatalf("expected no proof before the root is published, got %d", status)
+	}
+
+	if err := publishTransparencyRoot(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	resp := h.Get(t, "/api/transparency/roots")
+	var list struct {
+		Roots []receipts.SignedTransparencyRoot `json:"roots"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&list); err != nil || len(list.Roots) != 1 {
+		t.Fatalf("expected one root, got %+v (%v)", list, err)
+	}
+	if root := list.Roots[0].Root; root.Sequence != 1 || root.Count != 2 {
+		t.Errorf("unexpected root %+v", root)
+	}
+
+	for _, r := range []*SignedReceipt{first, second} {
+		status, proof := getReceiptProof(t, h, r.Receipt.ID)
+		if status != http.StatusOK {
+			t.Fatalf("expected a proof of %s, got %d", r.Receipt.ID, status)
+		}
+		if err := receipts.VerifyInclusion(r.Receipt, proof, nil); err != nil {
+			t.Errorf("expected %s to be proven, got %v", r.Receipt.ID, err)
+		}
+	}
+
+	// Nothing new is pending, so no empty root is published.
+	if err := publishTransparencyRoot(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if roots, _ := listTransparencyRoots(context.Background(), 0, 10); len(roots) != 1 {
+		t.Errorf("expected no root without new receipts, got %d", len(roots))
+	}
+	if resp := h.Get(t, "/api/transparency/roots?limit=0"); resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("expected an invalid limit to be rejected, got %d", resp.StatusCode)
+	}
+}
+
+func TestTransparency_SharedThroughRedis(t *testing.T) {
+	withTransparency(t, nil)
+	gw := startGateway(t, nil)
+
+	id := decodeReceiptHeader(t, paidReceipt(t, gw.Harness, "nonce-tl-redis-1")).Receipt.ID
+	if err := publishTransparencyRoot(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	paidReceipt(t, gw.Harness, "nonce-tl-redis-2")
+	if err := publishTransparencyRoot(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	transparencyMu.Lock()
+	local := len(transparencyRoots)
+	transparencyMu.Unlock()
+	if local != 0 {
+		t.Errorf("expected no in-memory roots while Redis is connected, got %d", local)
+	}
+	if roots, err := listTransparencyRoots(context.Background(), 1, 10); err != nil || len(roots) != 1 || roots[0].Root.Sequence != 2 {
+		t.Errorf("expected only root 2 after sequence 1, got %+v, %v", roots, err)
+	}
+	if status, proof := getReceiptProof(t, gw.Harness, id); status != http.StatusOK || proof.Root.Root.Sequence != 1 {
+		t.Errorf("expected a proof in root 1, got %d %+v", status, proof)
+	}
+}
+
+func TestTransparency_AnchorsRoot(t *testing.T) {
+	var sent *types.Transaction
+	rpc := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			Method string   `json:"method"`
+			Params []string `json:"params"`
+		}
+		json.NewDecoder(r.Body).Decode(&req)
+		var result any
+		switch req.Method {
+		case "eth_chainId":
+			result = "0x2105"
+		case "eth_getTransactionCount":
+			result = "0x5"
+		case "eth_gasPrice":
+			result = "0x3b9aca00"
+		case "eth_sendRawTransaction":
+			sent = new(types.Transaction)
+			if err := sent.UnmarshalBinary(hexutil.MustDecode(req.Params[0])); err != nil {
+				t.Errorf("invalid raw transaction: %v", err)
+			}
+			result = sent.Hash().Hex()
+		}
+		json.NewEncoder(w).Encode(map[string]any{"jsonrpc": "2.0", "id": 1, "result": result})
+	}))
+	defer rpc.Close()
+	withTransparency(t, map[string]string{"TRANSPARENCY_ANCHOR_RPC_URL": rpc.URL})
+	h := testsupport.NewHarness(t, newTestRouter)
+	paidReceipt(t, h, "nonce-tl-anchor")
+
+	if err := publishTransparencyRoot(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	root := transparencyLastRoot.Load()
+	if sent == nil || root == nil {
+		t.Fatal("expected the root to be anchored")
+	}
+	if common.BytesToHash(sent.Data()) != common.HexToHash(root.Root.MerkleRoot) || sent.Nonce() != 5 || sent.ChainId().Int64() != 0x2105 {
+		t.Errorf("unexpected anchor transaction: data %x, nonce %d, chain %d", sent.Data(), sent.Nonce(), sent.ChainId())
+	}
+	if root.AnchorTx != sent.Hash().Hex() || root.AnchorChainID != 0x2105 {
+		t.Errorf("expected the anchor to be recorded on the root, got %+v", root)
+	}
+}
@@ -0,0 +1,287 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"gateway/payments"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/redis/go-redis/v9"
+)
+
+// usageChallengeKeyPrefix prefixes the Redis keys of issued usage
+// challenges, by nonce.
+const usageChallengeKeyPrefix = "usage:challenge:"
+
+// Receipts listed by GET /api/me/usage unless ?limit= asks for more, up to
+// maxUsageReceipts.
+const (
+	defaultUsageReceipts = 20
+	maxUsageReceipts     = 100
+)
+
+var (
+	usageChallengesMu sync.Mutex
+	usageChallenges   = make(map[string]time.Time) // nonce -> expiry
+)
+
+// UsageChallenge is a single-use nonce a wallet personal_signs, as Message,
+// to read its own usage.
+type UsageChallenge struct {
+	Nonce     string    `json:"nonce"`
+	Message   string    `json:"message"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// usageChallengeMessage is the text signed for a usage challenge. It cannot
+// be mistaken for a payment, whose message starts "<domain> payment".
+func usageChallengeMessage(nonce string, expiresAt time.Time) string {
+	return fmt.Sprintf("%s usage request\nNonce: %s\nExpires: %s", payments.DomainName, nonce, expiresAt.UTC().Format(time.RFC3339))
+}
+
+// getUsageChallengeTTL returns how long a usage challenge can be signed,
+// USAGE_CHALLENGE_TTL_SECONDS, default 5 minutes.
+func getUsageChallengeTTL() time.Duration {
+	return time.Duration(getEnvAsInt("USAGE_CHALLENGE_TTL_SECONDS", 300)) * time.Second
+}
+
+// storeUsageChallenge keeps nonce until expiresAt, in Redis when it is
+// connected so any replica accepts the signed challenge.
+func storeUsageChallenge(ctx context.Context, nonce string, expiresAt time.Time) error {
+	if redisClient != nil {
+		return redisClient.Set(ctx, usageChallengeKeyPrefix+nonce, expiresAt.Unix(), time.Until(expiresAt)).Err()
+	}
+	usageChallengesMu.Lock()
+	defer usageChallengesMu.Unlock()
+	now := time.Now()
+	for old, exp := range usageChallenges {
+		if now.After(exp) {
+			delete(usageChallenges, old)
+		}
+	}
+	usageChallenges[nonce] = expiresAt
+	return nil
+}
+
+// loadUsageChallenge returns the expiry of the unexpired, unused challenge
+// nonce; ok is false when there is none.
+func loadUsageChallenge(ctx context.Context, nonce string) (expiresAt time.Time, ok bool, err error) {
+	if redisClient != nil {
+		unix, err := redisClient.Get(ctx, usageChallengeKeyPrefix+nonce).Int64()
+		if errors.Is(err, redis.Nil) {
+			return time.Time{}, false, nil
+		}
+		if err != nil {
+			return time.Time{}, false, fmt.Errorf("failed to load usage challenge: %w", err)
+		}
+		expiresAt = time.Unix(unix, 0).UTC()
+	} else {
+		usageChallengesMu.Lock()
+		expiresAt, ok = usageChallenges[nonce]
+		usageChallengesMu.Unlock()
+		if !ok {
+			return time.Time{}, false, nil
+		}
+	}
+	return expiresAt, time.Now().Before(expiresAt), nil
+}
+
+// consumeUsageChallenge deletes nonce, reporting whether this call was the
+// one to use it, so a signed challenge is answered once.
+func consumeUsageChallenge(ctx context.Context, nonce string) (bool, error) {
+	if redisClient != nil {
+		n, err := redisClient.Del(ctx, usageChallengeKeyPrefix+nonce).Result()
+		return n == 1, err
+	}
+	usageChallengesMu.Lock()
+	defer usageChallengesMu.Unlock()
+	_, ok := usageChallenges[nonce]
+	delete(usageChallenges, nonce)
+	return ok, nil
+}
+
+// handleCreateUsageChallenge handles POST /api/me/challenge, issuing a
+// challenge for GET /api/me/usage.
+func handleCreateUsageChallenge(c *gin.Context) {
+	nonce := uuid.New().String()
+	expiresAt := time.Now().Add(getUsageChallengeTTL()).UTC().Truncate(time.Second)
+	if err := storeUsageChallenge(c.Request.Context(), nonce, expiresAt); err != nil {
+		log.Printf("[WARNING] Failed to store usage challenge: %v", err)
+		respondError(c, CodeServiceUnavailable, "Usage challenge could not be stored")
+		return
+	}
+	c.JSON(201, UsageChallenge{Nonce: nonce, Message: usageChallengeMessage(nonce, expiresAt), ExpiresAt: expiresAt})
+}
+
+// authenticateUsage recovers the wallet that personal_signed the usage
+// challenge named by X-402-Nonce. The challenge is used up on success. On
+// failure it responds 401 and returns false.
+func authenticateUsage(c *gin.Context) (common.Address, bool) {
+	signature := c.GetHeader("X-402-Signature")
+	nonce := c.GetHeader("X-402-Nonce")
+	if signature == "" || nonce == "" {
+		respondError(c, CodeUnauthorized, "Sign the message from POST /api/me/challenge and send it in X-402-Signature with its nonce in X-402-Nonce")
+		return common.Address{}, false
+	}
+	ctx := c.Request.Context()
+	expiresAt, ok, err := loadUsageChallenge(ctx, nonce)
+	if err != nil {
+		log.Printf("[WARNING] Usage challenge lookup failed: %v", err)
+		respondError(c, CodeServiceUnavailable, "Usage challenge could not be checked")
+		return common.Address{}, false
+	}
+	if !ok {
+		respondError(c, CodeUnauthorized, "The usage challenge is unknown, expired or already used")
+		return common.Address{}, false
+	}
+	signer, err := payments.RecoverMessageSigner(usageChallengeMessage(nonce, expiresAt), signature)
+	if err != nil {
+		respondError(c, CodeUnauthorized, "Invalid signature: "+err.Error())
+		return common.Address{}, false
+	}
+	if payer := c.GetHeader("X-402-Payer"); payer != "" && !strings.EqualFold(payer, signer.Hex()) {
+		respondError(c, CodeUnauthorized, "The challenge was not signed by the X-402-Payer wallet")
+		return common.Address{}, false
+	}
+	if used, err := consumeUsageChallenge(ctx, nonce); err != nil || !used {
+		if err != nil {
+			log.Printf("[WARNING] Failed to consume usage challenge: %v", err)
+		}
+		respondError(c, CodeUnauthorized, "The usage challenge is unknown, expired or already used")
+		return common.Address{}, false
+	}
+	return signer, true
+}
+
+// UsageReceipt summarizes one receipt issued to the caller; the full receipt
+// is at GET /api/receipts/:id.
+type UsageReceipt struct {
+	ID        string    `json:"id"`
+	Timestamp time.Time `json:"timestamp"`
+	Endpoint  string    `json:"endpoint"`
+	Model     string    `json:"model,omitempty"`
+	Amount    string    `json:"amount"`
+	Token     string    `json:"token"`
+	ChainID   int       `json:"chain_id"`
+	Sequence  int64     `json:"sequence,omitempty"`
+}
+
+// payerReceipts returns the stored receipts issued to payer, newest first,
+// and the total amount paid for them. Only receipts within RECEIPT_TTL are
+// seen.
+func payerReceipts(payer string) ([]UsageReceipt, int64) {
+	now := time.Now()
+	list := []UsageReceipt{}
+	var total int64
+	receiptStoreMu.RLock()
+	for _, entry := range receiptStore {
+		r := entry.receipt.Receipt
+		if now.After(entry.expiresAt) || !strings.EqualFold(r.Payment.Payer, payer) {
+			continue
+		}
+		if units, err := parseTokenAmount(r.Payment.Amount); err == nil {
+			total += units
+		}
+		list = append(list, UsageReceipt{
+			ID:        r.ID,
+			Timestamp: r.Timestamp,
+			Endpoint:  r.Service.Endpoint,
+			Model:     r.Service.Model,
+			Amount:    r.Payment.Amount,
+			Token:     r.Payment.Token,
+			ChainID:   r.Payment.ChainID,
+			Sequence:  r.Payment.Sequence,
+		})
+	}
+	receiptStoreMu.RUnlock()
+
+	sort.Slice(list, func(i, j int) bool {
+		if !list[i].Timestamp.Equal(list[j].Timestamp) {
+			return list[i].Timestamp.After(list[j].Timestamp)
+		}
+		return list[i].ID > list[j].ID
+	})
+	return list, total
+}
+
+// payerTier returns the rate limit tier paid requests signed by payer get.
+func payerTier(ctx context.Context, payer common.Address) string {
+	if resolver := walletTiers.Load(); resolver != nil && resolver.IsVerified(ctx, payer) {
+		return "verified"
+	}
+	return "standard"
+}
+
+// handleGetUsage handles GET /api/me/usage for the wallet that signed a
+// usage challenge: its recent receipts, what it has spent, what is left
+// of its spending caps and its rate limit tier.
+func handleGetUsage(c *gin.Context) {
+	limit := defaultUsageReceipts
+	if raw := c.Query("limit"); raw != "" {
+		n, err := strconv.Atoi(raw)
+		if err != nil || n < 1 || n > maxUsageReceipts {
+			respondError(c, CodeInvalidRequest, fmt.Sprintf("limit must be between 1 and %d", maxUsageReceipts))
+			return
+		}
+		limit = n
+	}
+	payer, ok := authenticateUsage(c)
+	if !ok {
+		return
+	}
+	ctx := c.Request.Context()
+	cfg := getConfig()
+
+	windows := budgetWindows(cfg, payer.Hex(), time.Now())
+	budget := gin.H{}
+	if len(windows) > 0 {
+		spent, err := getSpendStore().Spent(ctx, windows)
+		if err != nil {
+			log.Printf("[WARNING] Usage spend lookup failed: %v", err)
+			respondError(c, CodeServiceUnavailable, "Spending totals are unavailable")
+			return
+		}
+		for i, w := range windows {
+			budget[w.Name] = gin.H{
+				"limit":     formatTokenAmount(w.Cap),
+				"spent":     formatTokenAmount(spent[i]),
+				"remaining": formatTokenAmount(max(w.Cap-spent[i], 0)),
+				"reset_at":  w.ResetAt.Unix(),
+			}
+		}
+	}
+
+	list, total := payerReceipts(payer.Hex())
+	count := len(list)
+	if len(list) > limit {
+		list = list[:limit]
+	}
+	tier := payerTier(ctx, payer)
+	limits := cfg.RateLimits[tier]
+
+	c.JSON(200, gin.H{
+		"address": payer.Hex(),
+		"spend": gin.H{
+			"receipts": count,
+			"total":    formatTokenAmount(total),
+		},
+		"budget": budget,
+		"rate_limit": gin.H{
+			"enabled":             getRateLimitEnabled(),
+			"tier":                tier,
+			"requests_per_minute": limits.RPM,
+			"burst":               limits.Burst,
+		},
+		"receipts": list,
+	})
+}
@@ -0,0 +1,185 @@
+package server
+
+import (
+	"crypto/ecdsa"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"gateway/internal/testsupport"
+	"gateway/payments"
+
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+// usageResponse is the body of GET /api/me/usage.
+type usageResponse struct {
+	Address string `json:"address"`
+	Spend   struct {
+		Receipts int    `json:"receipts"`
+		Total    string `json:"total"`
+	} `json:"spend"`
+	Budget map[string]struct {
+		Limit     string `json:"limit"`
+		Spent     string `json:"spent"`
+		Remaining string `json:"remaining"`
+	} `json:"budget"`
+	RateLimit struct {
+		Tier string `json:"tier"`
+		RPM  int    `json:"requests_per_minute"`
+	} `json:"rate_limit"`
+	Receipts []UsageReceipt `json:"receipts"`
+}
+
+// usageChallenge asks the gateway for a usage challenge.
+func usageChallenge(t *testing.T, h *testsupport.Harness) UsageChallenge {
+	t.Helper()
+	resp := h.Post(t, "/api/me/challenge", "", "", "")
+	var ch UsageChallenge
+	if resp.StatusCode != http.StatusCreated || json.NewDecoder(resp.Body).Decode(&ch) != nil {
+		t.Fatalf("expected a usage challenge, got %d", resp.StatusCode)
+	}
+	return ch
+}
+
+// getUsage sends GET /api/me/usage with the given signature headers.
+func getUsage(t *testing.T, h *testsupport.Harness, query, signature, nonce, payer string) *http.Response {
+	t.Helper()
+	req, err := http.NewRequest(http.MethodGet, h.Server.URL+"/api/me/usage"+query, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("X-402-Signature", signature)
+	req.Header.Set("X-402-Nonce", nonce)
+	if payer != "" {
+		req.Header.Set("X-402-Payer", payer)
+	}
+	resp, err := h.Server.Client().Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { resp.Body.Close() })
+	return resp
+}
+
+// signedUsage signs a fresh challenge with key and fetches its usage.
+func signedUsage(t *testing.T, h *testsupport.Harness, key *ecdsa.PrivateKey, query string) (*http.Response, UsageChallenge, string) {
+	t.Helper()
+	ch := usageChallenge(t, h)
+	sig, err := payments.SignMessage(ch.Message, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return getUsage(t, h, query, sig, ch.Nonce, ""), ch, sig
+}
+
+func TestUsage_ReportsPayerActivity(t *testing.T) {
+	t.Setenv("PAYMENT_AMOUNT", "0.001")
+	t.Setenv("SPEND_CAP_DAILY", "0.01")
+	h := testsupport.NewHarness(t, newTestRouter)
+	key, _ := crypto.GenerateKey()
+	payer := crypto.PubkeyToAddress(key.PublicKey)
+
+	h.Verifier.SetValid(payer.Hex())
+	for i := range 2 {
+		if resp := h.Post(t, "/api/ai/summarize", fmt.Sprintf(`{"text":"usage %d"}`, i), "0xsig", fmt.Sprintf("nonce-usage-%d", i)); resp.StatusCode != http.StatusOK {
+			t.Fatalf("expected 200, got %d", resp.StatusCode)
+		}
+	}
+	other, _ := crypto.GenerateKey()
+	h.Verifier.SetValid(crypto.PubkeyToAddress(other.PublicKey).Hex())
+	h.Post(t, "/api/ai/summarize", `{"text":"someone else"}`, "0xsig", "nonce-usage-other")
+
+	resp, ch, sig := signedUsage(t, h, key, "?limit=1")
+	var usage usageResponse
+	if resp.StatusCode != http.StatusOK || json.NewDecoder(resp.Body).Decode(&usage) != nil {
+		t.Fatalf("expected 200, got %d", resp.StatusCode)
+	}
+	if usage.Address != payer.Hex() || usage.Spend.Receipts != 2 || usage.Spend.Total != "0.002" {
+		t.Errorf("unexpected usage %+v", usage)
+	}
+	if len(usage.Receipts) != 1 || usage.Receipts[0].Amount != "0.001" || usage.Receipts[0].Sequence != 2 {
+		t.Errorf("expected only the latest receipt, got %+v", usage.Receipts)
+	}
+	if daily := usage.Budget["daily"]; daily.Limit != "0.01" || daily.Spent != "0.002" || daily.Remaining != "0.008" {
+		t.Errorf("unexpected daily budget %+v", daily)
+	}
+	if usage.RateLimit.Tier != "standard" || usage.RateLimit.RPM != 60 {
+		t.Errorf("unexpected rate limit %+v", usage.RateLimit)
+	}
+
+	if resp := getUsage(t, h, "", sig, ch.Nonce, ""); resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("expected a used challenge to be refused, got %d", resp.StatusCode)
+	}
+}
+
+func TestUsage_Rejected(t *testing.T) {
+	h := testsupport.NewHarness(t, newTestRouter)
+	key, _ := crypto.GenerateKey()
+	other, _ := crypto.GenerateKey()
+
+	ch := usageChallenge(t, h)
+	if !strings.Contains(ch.Message, "Nonce: "+ch.Nonce) {
+		t.Errorf("expected the nonce in the message, got %q", ch.Message)
+	}
+	wrongMessage, _ := payments.SignMessage(ch.Message+" ", key)
+	valid, _ := payments.SignMessage(ch.Message, key)
+
+	cases := []struct {
+		name                     string
+		query, sig, nonce, payer string
+		status                   int
+	}{
+		{"no headers", "", "", "", "", http.StatusUnauthorized},
+		{"unknown nonce", "", valid, "not-issued", "", http.StatusUnauthorized},
+		{"malformed signature", "", "0x1234", ch.Nonce, "", http.StatusUnauthorized},
+		{"other payer", "", valid, ch.Nonce, crypto.PubkeyToAddress(other.PublicKey).Hex(), http.StatusUnauthorized},
+		{"bad limit", "?limit=0", valid, ch.Nonce, "", http.StatusBadRequest},
+	}
+	for _, tc := range cases {
+		if resp := getUsage(t, h, tc.query, tc.sig, tc.nonce, tc.payer); resp.StatusCode != tc.status {
+			t.Errorf("%s: expected %d, got %d", tc.name, tc.status, resp.StatusCode)
+		}
+	}
+
+	// Signing anything else recovers to a different, empty wallet.
+	resp := getUsage(t, h, "", wrongMessage, ch.Nonce, "")
+	var usage usageResponse
+	if resp.StatusCode != http.StatusOK || json.NewDecoder(resp.Body).Decode(&usage) != nil {
+		t.Fatalf("expected 200, got %d", resp.StatusCode)
+	}
+	if usage.Address == crypto.PubkeyToAddress(key.PublicKey).Hex() || usage.Spend.Receipts != 0 || usage.Receipts == nil {
+		t.Errorf("expected an unrelated wallet with no receipts, got %+v", usage)
+	}
+}
+
+func TestUsage_SharedThroughRedis(t *testing.T) {
+	gw := startGateway(t, map[string]string{"PAYMENT_AMOUNT": "0.001", "SPEND_CAP_DAILY": "0.005"})
+	key, _ := crypto.GenerateKey()
+	payer := crypto.PubkeyToAddress(key.PublicKey).Hex()
+	// Spend reserved by another replica.
+	spent, _ := parseTokenAmount("0.001")
+	if err := redisClient.Set(t.Context(), budgetWindows(getConfig(), payer, time.Now())[0].Key, spent, time.Hour).Err(); err != nil {
+		t.Fatal(err)
+	}
+
+	ch := usageChallenge(t, gw.Harness)
+	if keys := gw.redisKeys(usageChallengeKeyPrefix); len(keys) != 1 {
+		t.Fatalf("expected the challenge in Redis, got %v", keys)
+	}
+	sig, _ := payments.SignMessage(ch.Message, key)
+	resp := getUsage(t, gw.Harness, "", sig, ch.Nonce, payer)
+	var usage usageResponse
+	if resp.StatusCode != http.StatusOK || json.NewDecoder(resp.Body).Decode(&usage) != nil {
+		t.Fatalf("expected 200, got %d", resp.StatusCode)
+	}
+	if daily := usage.Budget["daily"]; daily.Spent != "0.001" || daily.Remaining != "0.004" {
+		t.Errorf("expected the spend counted in Redis, got %+v", daily)
+	}
+	if keys := gw.redisKeys(usageChallengeKeyPrefix); len(keys) != 0 {
+		t.Errorf("expected the challenge to be used up, got %v", keys)
+	}
+}
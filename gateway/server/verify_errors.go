@@ -0,0 +1,160 @@
+package server
+
+import (
+	"strings"
+	"time"
+
+	"gateway/payments"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/gin-gonic/gin"
+)
+
+// verifyFailureReasons are the reasons refused payment signatures are
+// counted under in GET /api/admin/stats.
+var verifyFailureReasons = [...]string{
+	payments.ReasonInvalidSignature,
+	payments.ReasonMalformedSignature,
+	payments.ReasonWrongRecipient,
+	payments.ReasonWrongAmount,
+	payments.ReasonWrongChain,
+	payments.ReasonExpiredContext,
+	payments.ReasonSignerMismatch,
+}
+
+// verifyFailureCodes maps each reason to the error code clients get.
+var verifyFailureCodes = map[string]ErrorCode{
+	payments.ReasonInvalidSignature:   CodeSignatureInvalid,
+	payments.ReasonMalformedSignature: CodeSignatureMalformed,
+	payments.ReasonWrongRecipient:     CodePaymentWrongRecipient,
+	payments.ReasonWrongAmount:        CodePaymentWrongAmount,
+	payments.ReasonWrongChain:         CodePaymentWrongChain,
+	payments.ReasonExpiredContext:     CodePaymentContextExpired,
+	payments.ReasonSignerMismatch:     CodeSignerMismatch,
+}
+
+// verifyFailureMessages explain each reason to the client.
+var verifyFailureMessages = map[string]string{
+	payments.ReasonInvalidSignature:   "The payment signature does not verify",
+	payments.ReasonMalformedSignature: "The payment signature is not a well-formed signature",
+	payments.ReasonWrongRecipient:     "The payment was signed for another recipient; sign the paymentContext recipient",
+	payments.ReasonWrongAmount:        "The payment was signed for another amount; sign the price of this request",
+	payments.ReasonWrongChain:         "The payment was signed for another chain; sign the paymentContext chainId or send X-402-Chain-Id",
+	payments.ReasonExpiredContext:     "The payment context has expired; sign a new one",
+	payments.ReasonSignerMismatch:     "The payment was not signed by X-402-Payer",
+}
+
+// isSignatureFailure reports whether code refuses a payment signature.
+func isSignatureFailure(code ErrorCode) bool {
+	for _, c := range verifyFailureCodes {
+		if c == code {
+			return true
+		}
+	}
+	return false
+}
+
+// statsVerifyReasonIndex maps reason to its verifyFailureReasons index;
+// unknown reasons count as invalid signatures.
+func statsVerifyReasonIndex(reason string) int {
+	for i, r := range verifyFailureReasons {
+		if r == reason {
+			return i
+		}
+	}
+	return 0
+}
+
+// recoverers recover the signer of a payment context for the signature
+// types whose signer can be recovered locally.
+var recoverers = map[string]func(PaymentContext, string) (common.Address, error){
+	payments.SignatureTypeEIP712:       payments.RecoverSigner,
+	payments.SignatureTypePersonalSign: payments.RecoverPersonalSigner,
+}
+
+// diagnoseSignature looks for what the client signed instead of payment,
+// when a signature from payer does not verify: the same payment on another
+// accepted chain, to another accepted recipient or for another configured
+// price. It returns the matching reason, or "" when none recovers to payer.
+// Without a payer every signature recovers to someone, so nothing can be
+// told.
+func diagnoseSignature(cfg *Config, sigType string, payment PaymentContext, signature, payer string) string {
+	recoverSigner, ok := recoverers[sigType]
+	if !ok || !common.IsHexAddress(payer) {
+		return ""
+	}
+	want := common.HexToAddress(payer)
+	signedBy := func(p PaymentContext) bool {
+		signer, err := recoverSigner(p, signature)
+		return err == nil && signer == want
+	}
+
+	chains := cfg.Chains
+	if len(chains) == 0 {
+		chains = []ChainOption{cfg.PrimaryChain()}
+	}
+	for _, chain := range chains {
+		if chain.ChainID == payment.ChainID {
+			continue
+		}
+		p := payment
+		p.ChainID = chain.ChainID
+		if signedBy(p) {
+			return payments.ReasonWrongChain
+		}
+		p.Recipient = chain.Recipient
+		if signedBy(p) {
+			return payments.ReasonWrongChain
+		}
+	}
+	for _, chain := range chains {
+		if strings.EqualFold(chain.Recipient, payment.Recipient) {
+			continue
+		}
+		p := payment
+		p.Recipient = chain.Recipient
+		if signedBy(p) {
+			return payments.ReasonWrongRecipient
+		}
+	}
+	prices := []string{cfg.PaymentAmount}
+	for _, route := range cfg.ModelRoutes {
+		prices = append(prices, route.Price)
+	}
+	for _, price := range prices {
+		p := payment
+		p.Amount = payments.CanonicalAmount(price, p.Token)
+		if p.Amount != payment.Amount && signedBy(p) {
+			return payments.ReasonWrongAmount
+		}
+	}
+	return ""
+}
+
+// signatureFailureReason classifies an invalid verification of payment.
+// When the reason is not specific and the client named a payer,
+// diagnoseSignature may find a better one.
+func signatureFailureReason(resp *VerifyResponse, sigType string, payment PaymentContext, signature, payer string) string {
+	reason := resp.Reason
+	if reason == "" {
+		reason = payments.ClassifyVerifyError(resp.Error)
+	}
+	if reason == payments.ReasonInvalidSignature || reason == payments.ReasonSignerMismatch {
+		if diagnosed := diagnoseSignature(getConfig(), sigType, payment, signature, payer); diagnosed != "" {
+			reason = diagnosed
+		}
+	}
+	if _, ok := verifyFailureCodes[reason]; !ok {
+		reason = payments.ReasonInvalidSignature
+	}
+	return reason
+}
+
+// rejectSignature aborts with the error for an invalid verification of
+// payment and counts it by reason.
+func rejectSignature(c *gin.Context, resp *VerifyResponse, sigType string, payment PaymentContext, signature, payer string) {
+	reason := signatureFailureReason(resp, sigType, payment, signature, payer)
+	gatewayStats.RecordVerifyFailure(time.Now(), reason)
+	abortWithAPIError(c, newAPIError(verifyFailureCodes[reason], verifyFailureMessages[reason]).
+		withDetails(resp.Error).with(gin.H{"reason": reason}))
+}
@@ -0,0 +1,98 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"gateway/internal/testsupport"
+	"gateway/payments"
+
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+func TestDiagnoseSignature(t *testing.T) {
+	key, err := crypto.GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	payer := crypto.PubkeyToAddress(key.PublicKey).Hex()
+	cfg := &Config{
+		PaymentAmount: "0.001",
+		Chains: []ChainOption{
+			{ChainID: 8453, Recipient: "0x1111111111111111111111111111111111111111"},
+			{ChainID: 10, Recipient: "0x2222222222222222222222222222222222222222"},
+		},
+	}
+	payment := paymentContextFor(cfg.Chains[0], "0.002", "nonce-diag")
+
+	signed := func(mutate func(*PaymentContext)) string {
+		p := payment
+		mutate(&p)
+		sig, err := payments.Sign(p, key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return sig
+	}
+	cases := map[string]string{
+		payments.ReasonWrongChain:     signed(func(p *PaymentContext) { p.ChainID = 10 }),
+		payments.ReasonWrongRecipient: signed(func(p *PaymentContext) { p.Recipient = cfg.Chains[1].Recipient }),
+		payments.ReasonWrongAmount:    signed(func(p *PaymentContext) { p.Amount = "0.001" }),
+		"":                            signed(func(p *PaymentContext) { p.Nonce = "other" }),
+	}
+	for want, sig := range cases {
+		if got := diagnoseSignature(cfg, payments.SignatureTypeEIP712, payment, sig, payer); got != want {
+			t.Errorf("expected %q, got %q", want, got)
+		}
+	}
+	if got := diagnoseSignature(cfg, payments.SignatureTypeEIP712, payment, cases[payments.ReasonWrongChain], ""); got != "" {
+		t.Errorf("expected no diagnosis without a payer, got %q", got)
+	}
+}
+
+func TestVerifyFailure_CategorizedCodes(t *testing.T) {
+	withChains(t)
+	h := testsupport.NewHarness(t, newTestRouter)
+	payment := offeredPayment(t, h)
+	key, err := crypto.GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Signed for Optimism, but paid without X-402-Chain-Id, on Base.
+	wrong := payment
+	wrong.ChainID, wrong.Recipient = 10, testOptimismRecipient
+	sig, err := payments.SignPersonal(wrong, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	before := gatewayStats.Total().VerifyFailures[payments.ReasonWrongChain]
+
+	payer := crypto.PubkeyToAddress(key.PublicKey).Hex()
+	resp := postPayment(t, h, paymentHeaderV2{Signature: sig, Nonce: payment.Nonce, SignatureType: "personal_sign", Payer: payer})
+	var body struct {
+		Code   ErrorCode `json:"code"`
+		Reason string    `json:"reason"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusForbidden || body.Code != CodePaymentWrongChain || body.Reason != payments.ReasonWrongChain {
+		t.Errorf("expected 403 %s, got %d %+v", CodePaymentWrongChain, resp.StatusCode, body)
+	}
+	if got := gatewayStats.Total().VerifyFailures[payments.ReasonWrongChain]; got != before+1 {
+		t.Errorf("expected the failure to be counted, got %d after %d", got, before)
+	}
+
+	h.Verifier.SetInvalid("Invalid signature format: odd length")
+	resp = postPayment(t, h, paymentHeaderV2{Signature: "0xabc", Nonce: payment.Nonce})
+	if body := decodeErrorBody(t, resp); resp.StatusCode != http.StatusBadRequest || body.Code != CodeSignatureMalformed {
+		t.Errorf("expected 400 %s, got %d %+v", CodeSignatureMalformed, resp.StatusCode, body)
+	}
+
+	h.Verifier.SetInvalid("Verification failed")
+	resp = postPayment(t, h, paymentHeaderV2{Signature: "0xsig", Nonce: payment.Nonce})
+	if body := decodeErrorBody(t, resp); resp.StatusCode != http.StatusForbidden || body.Code != CodeSignatureInvalid {
+		t.Errorf("expected 403 %s, got %d %+v", CodeSignatureInvalid, resp.StatusCode, body)
+	}
+}
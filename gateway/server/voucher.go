@@ -0,0 +1,250 @@
+package server
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"gateway/payments"
+
+	"github.com/gin-gonic/gin"
+	"github.com/redis/go-redis/v9"
+)
+
+// voucherKeyPrefix namespaces refund vouchers in Redis.
+const voucherKeyPrefix = "voucher:"
+
+// voucherStoreTimeout bounds storing a voucher, which happens after the
+// request's own deadline may already have passed.
+const voucherStoreTimeout = 2 * time.Second
+
+var (
+	vouchersMu sync.Mutex
+	vouchers   = make(map[string]payments.Voucher)
+)
+
+// issueRefundVoucher signs and stores a voucher entitling payer to one retry
+// of payment. It returns nil when vouchers are disabled (REFUND_VOUCHER_TTL_SECONDS=0)
+// or cannot be issued; failures are only logged since the request has
+// already failed.
+func issueRefundVoucher(ctx context.Context, payment PaymentContext, payer string) *payments.Voucher {
+	ttl := getConfig().RefundVoucherTTL
+	if ttl <= 0 {
+		return nil
+	}
+	signer, err := getServerSigner()
+	if err != nil {
+		log.Printf("[WARNING] Cannot issue refund voucher: %v", err)
+		return nil
+	}
+	id, err := newVoucherID()
+	if err != nil {
+		log.Printf("[WARNING] Cannot issue refund voucher: %v", err)
+		return nil
+	}
+
+	v := payments.Voucher{
+		ID:      id,
+		Payer:   payer,
+		Amount:  payment.Amount,
+		Nonce:   payment.Nonce,
+		ChainID: payment.ChainID,
+		Expiry:  time.Now().Add(ttl).Unix(),
+	}
+	if v.Signature, err = payments.SignVoucher(v, signer); err != nil {
+		log.Printf("[WARNING] Failed to sign refund voucher: %v", err)
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), voucherStoreTimeout)
+	defer cancel()
+	if err := storeVoucher(ctx, v, ttl); err != nil {
+		log.Printf("[WARNING] Failed to store refund voucher: %v", err)
+		return nil
+	}
+	return &v
+}
+
+// newVoucherID returns a random voucher ID with a "vch_" prefix.
+func newVoucherID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("failed to generate voucher ID: %w", err)
+	}
+	return "vch_" + hex.EncodeToString(b), nil
+}
+
+// storeVoucher keeps v until it is redeemed or ttl passes. Vouchers are
+// stored in Redis when it is connected so any replica can redeem them.
+func storeVoucher(ctx context.Context, v payments.Voucher, ttl time.Duration) error {
+	if redisClient != nil {
+		data, err := json.Marshal(v)
+		if err != nil {
+			return err
+		}
+		return redisClient.Set(ctx, voucherKeyPrefix+v.ID, data, ttl).Err()
+	}
+
+	vouchersMu.Lock()
+	defer vouchersMu.Unlock()
+	now := time.Now().Unix()
+	for id, old := range vouchers {
+		if now > old.Expiry {
+			delete(vouchers, id)
+		}
+	}
+	vouchers[v.ID] = v
+	return nil
+}
+
+// loadVoucher returns the unexpired, unredeemed voucher id, or nil.
+func loadVoucher(ctx context.Context, id string) (*payments.Voucher, error) {
+	var v payments.Voucher
+	if redisClient != nil {
+		data, err := redisClient.Get(ctx, voucherKeyPrefix+id).Result()
+		if errors.Is(err, redis.Nil) {
+			return nil, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to load voucher: %w", err)
+		}
+		if err := json.Unmarshal([]byte(data), &v); err != nil {
+			return nil, fmt.Errorf("invalid voucher %s: %w", id, err)
+		}
+	} else {
+		vouchersMu.Lock()
+		stored, ok := vouchers[id]
+		vouchersMu.Unlock()
+		if !ok {
+			return nil, nil
+		}
+		v = stored
+	}
+	if time.Now().Unix() > v.Expiry {
+		return nil, nil
+	}
+	return &v, nil
+}
+
+// consumeVoucher deletes voucher id and reports whether this call removed
+// it, so concurrent redemptions of the same voucher cannot both succeed.
+func consumeVoucher(ctx context.Context, id string) (bool, error) {
+	if redisClient != nil {
+		n, err := redisClient.Del(ctx, voucherKeyPrefix+id).Result()
+		if err != nil {
+			return false, fmt.Errorf("failed to redeem voucher: %w", err)
+		}
+		return n == 1, nil
+	}
+
+	vouchersMu.Lock()
+	defer vouchersMu.Unlock()
+	if _, ok := vouchers[id]; !ok {
+		return false, nil
+	}
+	delete(vouchers, id)
+	return true, nil
+}
+
+// redeemVoucher settles a request with refund voucher id instead of a fresh
+// payment. The request must carry the refunded payment's nonce and
+// signature, proving it comes from the payer, and cost no more than the
+// voucher's amount. The returned payment context is the refunded payment.
+// On failure it aborts with 402, 403 or 500 and returns false.
+func redeemVoucher(c *gin.Context, id, sigType, signature, nonce, price string) (*VerifyResponse, *PaymentContext, bool) {
+	ctx := c.Request.Context()
+	v, err := loadVoucher(ctx, id)
+	if err != nil {
+		log.Printf("Voucher lookup error: %v", err)
+		abortWithError(c, CodeVoucherLookupFailed, "An internal error occurred")
+		return nil, nil, false
+	}
+	if v == nil {
+		rejectVoucher(c, "Voucher not found, expired or already redeemed")
+		return nil, nil, false
+	}
+	if v.Nonce != nonce {
+		rejectVoucher(c, "X-402-Nonce must be the nonce of the refunded payment")
+		return nil, nil, false
+	}
+	chain, ok := getConfig().Chain(v.ChainID)
+	if !ok {
+		rejectVoucher(c, "The voucher's chain is no longer accepted")
+		return nil, nil, false
+	}
+
+	covered, err := parseTokenAmount(v.Amount)
+	if err != nil {
+		rejectVoucher(c, "The voucher amount is invalid")
+		return nil, nil, false
+	}
+	if units, err := parseTokenAmount(price); err != nil || units > covered {
+		respondPaymentRequired(c, price, newAPIErrorf(CodeVoucherInsufficient,
+			"The voucher covers %s but this request costs %s", v.Amount, price))
+		return nil, nil, false
+	}
+
+	payment := paymentContextFor(chain, v.Amount, v.Nonce)
+	valid, err := voucherSignatureValid(ctx, sigType, payment, signature, v.Payer)
+	if err != nil {
+		log.Printf("Voucher signature check error: %v", err)
+		abortWithError(c, CodeVerifierFailed, "An internal error occurred")
+		return nil, nil, false
+	}
+	if !valid {
+		rejectVoucher(c, "X-402-Signature must be the payer's signature of the refunded payment")
+		return nil, nil, false
+	}
+	if !acquireWalletSlot(c, v.Payer) {
+		return nil, nil, false
+	}
+
+	consumed, err := consumeVoucher(ctx, v.ID)
+	if err != nil {
+		log.Printf("Voucher redemption error: %v", err)
+		abortWithError(c, CodeVoucherLookupFailed, "An internal error occurred")
+		return nil, nil, false
+	}
+	if !consumed {
+		rejectVoucher(c, "Voucher not found, expired or already redeemed")
+		return nil, nil, false
+	}
+	log.Printf("Redeemed refund voucher %s for %s", v.ID, v.Payer)
+	return &VerifyResponse{IsValid: true, RecoveredAddress: v.Payer}, &payment, true
+}
+
+// voucherSignatureValid reports whether signature, of type sigType, is
+// payer's signature of payment. EIP-712 is recovered locally rather than
+// sent to the verifier again.
+func voucherSignatureValid(ctx context.Context, sigType string, payment PaymentContext, signature, payer string) (bool, error) {
+	if sigType == payments.SignatureTypeEIP712 {
+		signer, err := payments.RecoverSigner(payment, signature)
+		return err == nil && strings.EqualFold(signer.Hex(), payer), nil
+	}
+	resp, err := verifySignature(ctx, sigType, payment, signature, payer)
+	if err != nil {
+		return false, err
+	}
+	return resp.IsValid, nil
+}
+
+// rejectVoucher aborts with 403 for an unusable voucher.
+func rejectVoucher(c *gin.Context, message string) {
+	abortWithError(c, CodeVoucherInvalid, message)
+}
+
+// respondWithRefund writes an upstream failure for a verified payment,
+// attaching a refund voucher for it to e when one can be issued.
+func respondWithRefund(c *gin.Context, e *APIError, payment PaymentContext, payer string) {
+	if v := issueRefundVoucher(c.Request.Context(), payment, payer); v != nil {
+		e.with(gin.H{"refund_voucher": v})
+	}
+	respondAPIError(c, e)
+}
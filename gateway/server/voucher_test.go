@@ -0,0 +1,187 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"gateway/internal/testsupport"
+	"gateway/payments"
+
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+// withVouchers installs an empty in-memory voucher store for the test.
+func withVouchers(t *testing.T) {
+	t.Helper()
+	vouchersMu.Lock()
+	prev := vouchers
+	vouchers = make(map[string]payments.Voucher)
+	vouchersMu.Unlock()
+	t.Cleanup(func() {
+		vouchersMu.Lock()
+		vouchers = prev
+		vouchersMu.Unlock()
+	})
+}
+
+// signedPayment fetches the summarize payment challenge and signs it with a
+// fresh payer key the mock verifier accepts. It returns the signature and
+// nonce.
+func signedPayment(t *testing.T, h *testsupport.Harness) (string, string) {
+	t.Helper()
+	var challenge struct {
+		PaymentContext PaymentContext `json:"paymentContext"`
+	}
+	resp := h.Post(t, "/api/ai/summarize", `{"text":"hello"}`, "", "")
+	if err := json.NewDecoder(resp.Body).Decode(&challenge); err != nil {
+		t.Fatal(err)
+	}
+	key, err := crypto.GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	h.Verifier.SetValid(crypto.PubkeyToAddress(key.PublicKey).Hex())
+	payment := challenge.PaymentContext
+	payment.Expiry, payment.QuoteSignature = 0, ""
+	sig, err := payments.Sign(payment, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return sig, payment.Nonce
+}
+
+// postWithVoucher retries a summarize request with a refund voucher.
+func postWithVoucher(t *testing.T, h *testsupport.Harness, signature, nonce, voucherID string) *http.Response {
+	t.Helper()
+	req, err := http.NewRequest(http.MethodPost, h.Server.URL+"/api/ai/summarize", bytes.NewBufferString(`{"text":"hello"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-402-Signature", signature)
+	req.Header.Set("X-402-Nonce", nonce)
+	req.Header.Set("X-402-Voucher", voucherID)
+	resp, err := h.Server.Client().Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { resp.Body.Close() })
+	return resp
+}
+
+// failedSummarize makes a paid summarize request the provider fails and
+// returns the refund voucher from the error body.
+func failedSummarize(t *testing.T, h *testsupport.Harness, signature, nonce string) *payments.Voucher {
+	t.Helper()
+	h.AI.SetStatus(http.StatusInternalServerError)
+	defer h.AI.SetStatus(http.StatusOK)
+	resp := h.Post(t, "/api/ai/summarize", `{"text":"hello"}`, signature, nonce)
+	if resp.StatusCode != http.StatusInternalServerError {
+		t.Fatalf("expected 500, got %d", resp.StatusCode)
+	}
+	var body struct {
+		RefundVoucher *payments.Voucher `json:"refund_voucher"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		t.Fatal(err)
+	}
+	return body.RefundVoucher
+}
+
+func TestVoucher_IssuedOnFailureAndRedeemedOnce(t *testing.T) {
+	withVouchers(t)
+	withCircuit(t)
+	h := testsupport.NewHarness(t, newTestRouter)
+	signature, nonce := signedPayment(t, h)
+
+	voucher := failedSummarize(t, h, signature, nonce)
+	if voucher == nil {
+		t.Fatal("expected a refund voucher in the error response")
+	}
+	if voucher.Nonce != nonce || voucher.Payer == "" {
+		t.Errorf("expected the voucher bound to the payment nonce, got %+v", voucher)
+	}
+	serverKey, _ := getServerPrivateKey()
+	if signer, err := payments.RecoverVoucherSigner(*voucher); err != nil || signer != crypto.PubkeyToAddress(serverKey.PublicKey) {
+		t.Errorf("expected the voucher signed by the server key, got %s (%v)", signer.Hex(), err)
+	}
+
+	verifierCalls := h.Verifier.Calls()
+	resp := postWithVoucher(t, h, signature, nonce, voucher.ID)
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("redeem: expected 200, got %d", resp.StatusCode)
+	}
+	if h.Verifier.Calls() != verifierCalls {
+		t.Error("expected a voucher redemption not to call the verifier")
+	}
+	receipt := decodeReceiptHeader(t, resp.Header.Get("X-402-Receipt"))
+	if receipt.Receipt.Payment.Nonce != nonce || receipt.Receipt.Payment.Payer != voucher.Payer {
+		t.Errorf("expected the receipt to cover the refunded payment, got %+v", receipt.Receipt.Payment)
+	}
+
+	if resp := postWithVoucher(t, h, signature, nonce, voucher.ID); resp.StatusCode != http.StatusForbidden {
+		t.Errorf("second redemption: expected 403, got %d", resp.StatusCode)
+	}
+}
+
+func TestVoucher_RejectsForeignSignatureAndNonce(t *testing.T) {
+	withVouchers(t)
+	withCircuit(t)
+	h := testsupport.NewHarness(t, newTestRouter)
+	signature, nonce := signedPayment(t, h)
+	voucher := failedSummarize(t, h, signature, nonce)
+	if voucher == nil {
+		t.Fatal("expected a refund voucher")
+	}
+
+	otherSig, otherNonce := signedPayment(t, h)
+	if resp := postWithVoucher(t, h, otherSig, nonce, voucher.ID); resp.StatusCode != http.StatusForbidden {
+		t.Errorf("foreign signature: expected 403, got %d", resp.StatusCode)
+	}
+	if resp := postWithVoucher(t, h, otherSig, otherNonce, voucher.ID); resp.StatusCode != http.StatusForbidden {
+		t.Errorf("other nonce: expected 403, got %d", resp.StatusCode)
+	}
+	if resp := postWithVoucher(t, h, signature, nonce, "vch_unknown"); resp.StatusCode != http.StatusForbidden {
+		t.Errorf("unknown voucher: expected 403, got %d", resp.StatusCode)
+	}
+
+	// Rejected attempts must not use the voucher up.
+	if resp := postWithVoucher(t, h, signature, nonce, voucher.ID); resp.StatusCode != http.StatusOK {
+		t.Errorf("expected the payer to still redeem the voucher, got %d", resp.StatusCode)
+	}
+}
+
+func TestVoucher_DisabledWithZeroTTL(t *testing.T) {
+	withVouchers(t)
+	withCircuit(t)
+	t.Setenv("REFUND_VOUCHER_TTL_SECONDS", "0")
+	h := testsupport.NewHarness(t, newTestRouter)
+
+	if voucher := failedSummarize(t, h, "0xsig", "nonce-novoucher"); voucher != nil {
+		t.Errorf("expected no voucher when disabled, got %+v", voucher)
+	}
+}
+
+func TestVoucher_StoredInRedis(t *testing.T) {
+	withVouchers(t)
+	startGateway(t, nil)
+
+	v := issueRefundVoucher(t.Context(), paymentContextFor(getConfig().PrimaryChain(), "0.001", "nonce-redis"), testsupport.DefaultPayer)
+	if v == nil {
+		t.Fatal("expected a voucher")
+	}
+	if len(vouchers) != 0 {
+		t.Error("expected the voucher to be stored in Redis, not in memory")
+	}
+	if stored, err := loadVoucher(t.Context(), v.ID); err != nil || stored == nil || stored.Nonce != "nonce-redis" {
+		t.Fatalf("expected the voucher in Redis, got %+v (%v)", stored, err)
+	}
+	if ok, _ := consumeVoucher(t.Context(), v.ID); !ok {
+		t.Error("expected the first redemption to consume the voucher")
+	}
+	if ok, _ := consumeVoucher(t.Context(), v.ID); ok {
+		t.Error("expected a voucher to be consumed only once")
+	}
+}
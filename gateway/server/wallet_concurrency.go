@@ -0,0 +1,89 @@
+package server
+
+import (
+	"strings"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+)
+
+// walletSlotKey is the context key of the release function of the
+// request's wallet slot.
+const walletSlotKey = "wallet_slot_release"
+
+// walletSlots counts the paid requests each wallet has in flight.
+type walletSlots struct {
+	mu       sync.Mutex
+	inFlight map[string]int
+}
+
+// walletConcurrency is the process-wide in-flight count. It is per
+// instance, like the rate limiter's memory buckets.
+var walletConcurrency = &walletSlots{inFlight: make(map[string]int)}
+
+// acquire takes one of wallet's limit slots and returns the function that
+// gives it back, which may be called more than once. ok is false when all
+// slots are taken.
+func (s *walletSlots) acquire(wallet string, limit int) (release func(), ok bool) {
+	key := strings.ToLower(wallet)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.inFlight[key] >= limit {
+		return nil, false
+	}
+	s.inFlight[key]++
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			s.mu.Lock()
+			defer s.mu.Unlock()
+			if s.inFlight[key]--; s.inFlight[key] <= 0 {
+				delete(s.inFlight, key)
+			}
+		})
+	}, true
+}
+
+// count returns the requests wallet has in flight.
+func (s *walletSlots) count(wallet string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.inFlight[strings.ToLower(wallet)]
+}
+
+// acquireWalletSlot admits a paid request of wallet under
+// WALLET_MAX_CONCURRENT, once its signature has verified but before the
+// nonce is spent, so a rejected payment can be retried as is. The slot is
+// held until walletConcurrencyMiddleware sees the request finish. On
+// rejection it has aborted with 429 CONCURRENCY_LIMITED and returns false.
+func acquireWalletSlot(c *gin.Context, wallet string) bool {
+	limit := getConfig().WalletMaxConcurrent
+	if limit <= 0 || wallet == "" {
+		return true
+	}
+	release, ok := walletConcurrency.acquire(wallet, limit)
+	if !ok {
+		c.Header("Retry-After", "1")
+		abortWithAPIError(c, newAPIErrorf(CodeConcurrencyLimited, "This wallet already has %d requests in progress", limit).
+			with(gin.H{"limit": limit, "retry_after": 1}))
+		return false
+	}
+	c.Set(walletSlotKey, release)
+	return true
+}
+
+// releaseWalletSlot gives back the request's wallet slot, if it holds one.
+func releaseWalletSlot(c *gin.Context) {
+	if v, ok := c.Get(walletSlotKey); ok {
+		v.(func())()
+	}
+}
+
+// walletConcurrencyMiddleware releases the wallet slot taken by a paid
+// request once the handler returns, including when it panics.
+func walletConcurrencyMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		defer releaseWalletSlot(c)
+		c.Next()
+	}
+}
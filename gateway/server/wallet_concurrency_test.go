@@ -0,0 +1,72 @@
+package server
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"gateway/internal/testsupport"
+)
+
+func TestWalletSlots(t *testing.T) {
+	s := &walletSlots{inFlight: make(map[string]int)}
+	first, ok := s.acquire("0xAbC", 2)
+	if !ok {
+		t.Fatal("expected the first slot")
+	}
+	if _, ok := s.acquire("0xabc", 2); !ok {
+		t.Fatal("expected the second slot")
+	}
+	if _, ok := s.acquire("0xABC", 2); ok {
+		t.Error("expected the wallet to be at its limit, whatever the address case")
+	}
+	if _, ok := s.acquire("0xdef", 2); !ok {
+		t.Error("expected other wallets to be unaffected")
+	}
+	first()
+	first()
+	if got := s.count("0xabc"); got != 1 {
+		t.Errorf("expected a release to count once, got %d in flight", got)
+	}
+	if _, ok := s.acquire("0xabc", 2); !ok {
+		t.Error("expected a released slot to be reusable")
+	}
+}
+
+func TestWalletConcurrency_RejectsExtraRequests(t *testing.T) {
+	// Handlers of earlier tests that timed out may still hold slots.
+	prev := walletConcurrency
+	walletConcurrency = &walletSlots{inFlight: make(map[string]int)}
+	t.Cleanup(func() { walletConcurrency = prev })
+	t.Setenv("WALLET_MAX_CONCURRENT", "1")
+	h := testsupport.NewHarness(t, newTestRouter)
+	h.AI.SetDelay(300 * time.Millisecond)
+
+	done := make(chan int, 1)
+	go func() {
+		done <- h.Post(t, "/api/ai/summarize", `{"text":"first"}`, "0xsig", "wallet-slot-1").StatusCode
+	}()
+	deadline := time.Now().Add(2 * time.Second)
+	for h.AI.Calls() == 0 && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	resp := h.Post(t, "/api/ai/summarize", `{"text":"second"}`, "0xsig", "wallet-slot-2")
+	body := decodeErrorBody(t, resp)
+	if resp.StatusCode != http.StatusTooManyRequests || body.Code != CodeConcurrencyLimited || resp.Header.Get("Retry-After") != "1" {
+		t.Fatalf("expected 429 CONCURRENCY_LIMITED, got %d %+v", resp.StatusCode, body)
+	}
+	if status := <-done; status != http.StatusOK {
+		t.Fatalf("expected the first request to succeed, got %d", status)
+	}
+
+	// The rejected payment's nonce was not spent, so it can be retried.
+	if resp := h.Post(t, "/api/ai/summarize", `{"text":"second"}`, "0xsig", "wallet-slot-2"); resp.StatusCode != http.StatusOK {
+		t.Errorf("expected the retry to succeed once the slot was free, got %d", resp.StatusCode)
+	}
+	walletConcurrency.mu.Lock()
+	defer walletConcurrency.mu.Unlock()
+	if len(walletConcurrency.inFlight) != 0 {
+		t.Errorf("expected every slot to be released, got %v", walletConcurrency.inFlight)
+	}
+}
@@ -0,0 +1,177 @@
+package server
+
+import (
+	"encoding/hex"
+	"os"
+	"strings"
+
+	"gateway/receipts"
+
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/gin-gonic/gin"
+)
+
+// paygateConfigVersion is the version of the discovery document itself.
+const paygateConfigVersion = "1"
+
+// pricedEndpoint describes one route that requires an x402 payment. When
+// model routing is configured Price is the shortest-input price and Routes
+// lists every length tier. Token-priced endpoints give the minimum Price and
+// their PricePer1KTokens.
+type pricedEndpoint struct {
+	Method           string       `json:"method"`
+	Path             string       `json:"path"`
+	Price            string       `json:"price"`
+	Token            string       `json:"token"`
+	Routes           []ModelRoute `json:"routes,omitempty"`
+	Model            string       `json:"model,omitempty"`
+	PricePer1KTokens string       `json:"price_per_1k_tokens,omitempty"`
+}
+
+// pricedEndpoints lists the paid routes and their current prices, including
+// endpoints from the registry.
+func pricedEndpoints(cfg *Config) []pricedEndpoint {
+	_, price := routeModel(cfg, 0)
+	endpoints := []pricedEndpoint{
+		{Method: "POST", Path: "/api/ai/summarize", Price: price, Token: "USDC", Routes: cfg.ModelRoutes},
+		{
+			Method: "POST", Path: "/api/ai/embed", Price: embeddingPrice(cfg, 1), Token: "USDC",
+			Model: cfg.Embeddings.Model, PricePer1KTokens: formatTokenAmount(cfg.Embeddings.PricePer1KTokens),
+		},
+	}
+	for _, ep := range paidEndpoints.Endpoints() {
+		endpoints = append(endpoints, pricedEndpoint{Method: "POST", Path: "/api/ai/" + ep.Name, Price: ep.price(cfg), Token: "USDC"})
+	}
+	return endpoints
+}
+
+// publicBaseURL returns PUBLIC_BASE_URL when set, otherwise the scheme and
+// host the request arrived on (honouring X-Forwarded-Proto from a proxy).
+func publicBaseURL(c *gin.Context) string {
+	if base := os.Getenv("PUBLIC_BASE_URL"); base != "" {
+		return strings.TrimSuffix(base, "/")
+	}
+	scheme := "http"
+	if c.Request.TLS != nil || strings.EqualFold(c.GetHeader("X-Forwarded-Proto"), "https") {
+		scheme = "https"
+	}
+	return scheme + "://" + c.Request.Host
+}
+
+// handlePaygateConfiguration handles GET /.well-known/paygate-configuration.
+// It describes everything a client SDK needs to pay this deployment: the
+// payment scheme and signature types it accepts, chain, token, prices and the
+// receipt formats and signing keys it can expect back.
+func handlePaygateConfiguration(c *gin.Context) {
+	cfg := getConfig()
+	base := publicBaseURL(c)
+
+	c.JSON(200, gin.H{
+		"version": paygateConfigVersion,
+		"issuer":  base,
+		"payment_schemes": []gin.H{{
+			"scheme":                "x402-eip712",
+			"challenge_status":      402,
+			"signature_header":      "X-402-Signature",
+			"nonce_header":          "X-402-Nonce",
+			"chain_header":          "X-402-Chain-Id",
+			"signature_format":      "eip712",
+			"signature_types":       cfg.Signatures.Types,
+			"signature_type_header": "X-402-Signature-Type",
+			"payer_header":          "X-402-Payer",
+			"eip712": gin.H{
+				"domain": gin.H{
+					"name":              "MicroAI Paygate",
+					"version":           "1",
+					"chainId":           cfg.ChainID,
+					"verifyingContract": "0x0000000000000000000000000000000000000000",
+				},
+				"primaryType": "Payment",
+				"types": gin.H{
+					"Payment": []gin.H{
+						{"name": "recipient", "type": "address"},
+						{"name": "token", "type": "string"},
+						{"name": "amount", "type": "string"},
+						{"name": "nonce", "type": "string"},
+					},
+				},
+			},
+			"quotes": gin.H{
+				"signature_header": "X-402-Quote-Signature",
+				"expiry_header":    "X-402-Quote-Expiry",
+				"required":         cfg.Quotes.Required,
+				"ttl_seconds":      int(cfg.Quotes.TTL.Seconds()),
+				"primaryType":      "Quote",
+				"types": gin.H{
+					"Quote": []gin.H{
+						{"name": "recipient", "type": "address"},
+						{"name": "token", "type": "string"},
+						{"name": "amount", "type": "string"},
+						{"name": "nonce", "type": "string"},
+						{"name": "expiry", "type": "uint256"},
+					},
+				},
+			},
+			"challenges": gin.H{
+				"url":         base + "/api/payment/challenge",
+				"ttl_seconds": int(cfg.Challenges.TTL.Seconds()),
+				"required":    cfg.Challenges.Required,
+			},
+			"verify_url": base + "/api/payment/verify",
+		}},
+		"chains":            acceptedChains(cfg),
+		"tokens":            []gin.H{{"symbol": "USDC", "decimals": tokenDecimals}},
+		"recipient":         cfg.RecipientAddress,
+		"endpoints":         pricedEndpoints(cfg),
+		"signature_formats": cfg.Signatures.Types,
+		"receipts": gin.H{
+			"version":    receipts.Version,
+			"versions":   receipts.SupportedVersions,
+			"header":     "X-402-Receipt",
+			"formats":    []string{receiptFormatJSON, receiptFormatJWS, receiptFormatCOSE},
+			"lookup_url": base + "/api/receipts/{id}",
+			"sessions": gin.H{
+				"header":           "X-402-Session",
+				"lookup_url":       base + "/api/receipts/sessions/{sessionId}",
+				"interval_seconds": int(getSessionReceiptInterval().Seconds()),
+			},
+			"response_signature_header": receipts.ResponseSignatureHeader,
+		},
+		"keys_url":   base + "/.well-known/paygate-keys",
+		"errors_url": base + "/api/errors",
+	})
+}
+
+// handlePaygateKeys handles GET /.well-known/paygate-keys, publishing the key
+// receipts are signed with. The kid matches the one in JWS and COSE receipts.
+func handlePaygateKeys(c *gin.Context) {
+	signer, err := getServerSigner()
+	if err != nil {
+		respondError(c, CodeServiceUnavailable, "Receipt signing key is not configured")
+		return
+	}
+	pub := signer.Public()
+	c.JSON(200, gin.H{
+		"keys": []gin.H{{
+			"kid":        crypto.PubkeyToAddress(*pub).Hex(),
+			"alg":        "ES256K",
+			"curve":      "secp256k1",
+			"public_key": "0x" + hex.EncodeToString(crypto.FromECDSAPub(pub)),
+			"use":        "receipt-signing",
+		}},
+	})
+}
+
+// acceptedChains lists the chains payment is accepted on, primary first,
+// each with the address that receives payment there.
+func acceptedChains(cfg *Config) []gin.H {
+	chains := make([]gin.H, 0, len(cfg.Chains))
+	for _, chain := range cfg.Chains {
+		entry := gin.H{"chain_id": chain.ChainID, "recipient": chain.Recipient}
+		if chain.Name != "" {
+			entry["name"] = chain.Name
+		}
+		chains = append(chains, entry)
+	}
+	return chains
+}
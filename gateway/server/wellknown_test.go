@@ -0,0 +1,83 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"gateway/internal/testsupport"
+
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+func TestPaygateConfiguration(t *testing.T) {
+	t.Setenv("PAYMENT_AMOUNT", "0.002")
+	t.Setenv("CHAIN_ID", "84532")
+	t.Setenv("PUBLIC_BASE_URL", "")
+
+	req := httptest.NewRequest(http.MethodGet, "/.well-known/paygate-configuration", nil)
+	req.Host = "pay.example.com"
+	req.Header.Set("X-Forwarded-Proto", "https")
+	w := httptest.NewRecorder()
+	newTestRouter().ServeHTTP(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", w.Code)
+	}
+	var doc struct {
+		Issuer string `json:"issuer"`
+		Chains []struct {
+			ChainID   int    `json:"chain_id"`
+			Name      string `json:"name"`
+			Recipient string `json:"recipient"`
+		} `json:"chains"`
+		Endpoints []pricedEndpoint `json:"endpoints"`
+		Receipts  struct {
+			Version  string   `json:"version"`
+			Versions []string `json:"versions"`
+			Formats  []string `json:"formats"`
+		} `json:"receipts"`
+		KeysURL string `json:"keys_url"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &doc); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if doc.Issuer != "https://pay.example.com" || doc.KeysURL != "https://pay.example.com/.well-known/paygate-keys" {
+		t.Errorf("unexpected issuer/keys_url: %s %s", doc.Issuer, doc.KeysURL)
+	}
+	if len(doc.Chains) != 1 || doc.Chains[0].ChainID != 84532 || doc.Chains[0].Name != "base-sepolia" {
+		t.Errorf("unexpected chains: %v", doc.Chains)
+	}
+	if len(doc.Endpoints) == 0 || doc.Endpoints[0].Price != "0.002" {
+		t.Errorf("expected summarize endpoint priced at 0.002, got %+v", doc.Endpoints)
+	}
+	if len(doc.Endpoints) != 2 || doc.Endpoints[1].Path != "/api/ai/embed" || doc.Endpoints[1].PricePer1KTokens != "0.00002" {
+		t.Errorf("expected embed endpoint priced per 1K tokens, got %+v", doc.Endpoints)
+	}
+	if doc.Receipts.Version != "2.0" || len(doc.Receipts.Versions) != 2 || len(doc.Receipts.Formats) != 3 {
+		t.Errorf("unexpected receipts section: %+v", doc.Receipts)
+	}
+}
+
+func TestPaygateKeys(t *testing.T) {
+	h := testsupport.NewHarness(t, newTestRouter)
+	key, err := crypto.HexToECDSA(testsupport.TestPrivateKey)
+	if err != nil {
+		t.Fatalf("parse test key: %v", err)
+	}
+
+	resp := h.Get(t, "/.well-known/paygate-keys")
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected 200, got %d", resp.StatusCode)
+	}
+	var body struct {
+		Keys []map[string]string `json:"keys"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if len(body.Keys) != 1 || body.Keys[0]["kid"] != crypto.PubkeyToAddress(key.PublicKey).Hex() {
+		t.Errorf("expected server key address as kid, got %v", body.Keys)
+	}
+}
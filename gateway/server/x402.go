@@ -0,0 +1,170 @@
+package server
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"log"
+	"slices"
+	"strconv"
+	"time"
+
+	"gateway/payments"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Standard x402 support. Clients built on the x402 reference libraries pay
+// with the "exact" scheme: an ERC-3009 transferWithAuthorization of the
+// price, sent base64-encoded in X-PAYMENT with the scheme and network picked
+// from the 402 "accepts" list, and read the settlement from
+// X-PAYMENT-RESPONSE. They choose their own authorization nonce, which is
+// used as the payment nonce, so each authorization still pays once.
+const (
+	x402Version     = 1
+	x402SchemeExact = "exact"
+)
+
+// X402Requirements are the x402 PaymentRequirements of an offer.
+type X402Requirements struct {
+	Scheme            string    `json:"scheme"`
+	Network           string    `json:"network"`
+	MaxAmountRequired string    `json:"maxAmountRequired"`
+	Resource          string    `json:"resource"`
+	Description       string    `json:"description"`
+	MimeType          string    `json:"mimeType"`
+	PayTo             string    `json:"payTo"`
+	MaxTimeoutSeconds int       `json:"maxTimeoutSeconds"`
+	Asset             string    `json:"asset"`
+	Extra             x402Extra `json:"extra"`
+}
+
+// x402Extra names the token's EIP-712 domain for the exact scheme.
+type x402Extra struct {
+	Name    string `json:"name"`
+	Version string `json:"version"`
+}
+
+// paymentOffer is one entry of a 402 "accepts" list: the payment context to
+// sign and, on chains where erc3009 payments are accepted, the same offer as
+// x402 payment requirements.
+type paymentOffer struct {
+	PaymentContext
+	*X402Requirements
+}
+
+// x402PaymentPayload is a standard X-PAYMENT header value.
+type x402PaymentPayload struct {
+	X402Version int                     `json:"x402Version"`
+	Scheme      string                  `json:"scheme"`
+	Network     string                  `json:"network"`
+	Payload     payments.ERC3009Payload `json:"payload"`
+}
+
+// paymentOffers pairs each of contexts with its x402 requirements.
+func paymentOffers(c *gin.Context, contexts []PaymentContext) []paymentOffer {
+	cfg := getConfig()
+	offers := make([]paymentOffer, len(contexts))
+	for i, payment := range contexts {
+		offers[i] = paymentOffer{PaymentContext: payment, X402Requirements: x402RequirementsFor(c, cfg, payment)}
+	}
+	return offers
+}
+
+// x402RequirementsFor describes payment in the exact scheme, or returns nil
+// when erc3009 payments are not accepted on its chain or the chain has no
+// x402 network name.
+func x402RequirementsFor(c *gin.Context, cfg *Config, payment PaymentContext) *X402Requirements {
+	if !slices.Contains(cfg.Signatures.Types, payments.SignatureTypeERC3009) {
+		return nil
+	}
+	token, ok := cfg.Signatures.Tokens[payment.ChainID]
+	network := chainName(payment.ChainID)
+	if !ok || network == "" {
+		return nil
+	}
+	amount, err := payment.ParseAmount()
+	if err != nil {
+		return nil
+	}
+	return &X402Requirements{
+		Scheme:            x402SchemeExact,
+		Network:           network,
+		MaxAmountRequired: strconv.FormatInt(amount.Units, 10),
+		Resource:          publicBaseURL(c) + c.Request.URL.Path,
+		Description:       "Paid request to " + c.Request.URL.Path,
+		MimeType:          "application/json",
+		PayTo:             payment.Recipient,
+		MaxTimeoutSeconds: int(getAITimeout().Seconds()),
+		Asset:             token.VerifyingContract,
+		Extra:             x402Extra{Name: token.Name, Version: token.Version},
+	}
+}
+
+// decodeX402Payment parses raw as a standard X-PAYMENT value. ok is false
+// for anything else, such as the gateway's own v2 header.
+func decodeX402Payment(raw string) (p x402PaymentPayload, ok bool) {
+	data, err := base64.StdEncoding.DecodeString(raw)
+	if err != nil || json.Unmarshal(data, &p) != nil {
+		return p, false
+	}
+	return p, p.X402Version != 0
+}
+
+// applyX402Payment translates a standard payment into the X-402 headers: an
+// erc3009 signature from the authorization's signer, paying the
+// authorization nonce on the chain named by the network. An unknown network
+// is passed on as the chain for the payment step to refuse with fresh
+// offers. It aborts with 400 and returns false when p cannot be used.
+func applyX402Payment(c *gin.Context, p x402PaymentPayload) bool {
+	if p.X402Version != x402Version {
+		abortWithAPIError(c, newAPIErrorf(CodeInvalidRequest, "x402Version %d is not supported; use %d", p.X402Version, x402Version))
+		return false
+	}
+	if p.Scheme != x402SchemeExact {
+		abortWithAPIError(c, newAPIErrorf(CodeInvalidRequest, "X-PAYMENT scheme %q is not supported; pick one of the offered payment requirements", p.Scheme))
+		return false
+	}
+	a := p.Payload.Authorization
+	if p.Payload.Signature == "" || a.Nonce == "" {
+		abortWithError(c, CodeInvalidRequest, "X-PAYMENT must carry a signed authorization with a nonce")
+		return false
+	}
+	// The authorization nonce becomes the payment nonce, which is only
+	// remembered for NONCE_TTL_SECONDS.
+	if err := checkAuthorizationWindow(a, time.Now()); err != nil {
+		abortWithError(c, CodeInvalidRequest, err.Error())
+		return false
+	}
+	signature, err := payments.EncodeERC3009Payload(p.Payload)
+	if err != nil {
+		abortWithError(c, CodeInvalidRequest, "X-PAYMENT payload could not be read")
+		return false
+	}
+	chain := p.Network
+	if id, err := parseChainRef(p.Network); err == nil {
+		chain = strconv.Itoa(id)
+	}
+	c.Request.Header.Set("X-402-Signature", signature)
+	c.Request.Header.Set("X-402-Signature-Type", payments.SignatureTypeERC3009)
+	c.Request.Header.Set("X-402-Nonce", a.Nonce)
+	c.Request.Header.Set("X-402-Payer", a.From)
+	c.Request.Header.Set("X-402-Chain-Id", chain)
+	c.Set("x402_network", p.Network)
+	return true
+}
+
+// setX402PaymentResponse sets X-PAYMENT-RESPONSE on responses to standard
+// x402 payments. Relaying is asynchronous, so the transaction is empty: the
+// receipt is the gateway's proof of payment.
+func setX402PaymentResponse(c *gin.Context, payer string) {
+	network := c.GetString("x402_network")
+	if network == "" {
+		return
+	}
+	data, err := json.Marshal(gin.H{"success": true, "transaction": "", "network": network, "payer": payer})
+	if err != nil {
+		log.Printf("[WARNING] Failed to encode X-PAYMENT-RESPONSE: %v", err)
+		return
+	}
+	c.Header("X-PAYMENT-RESPONSE", base64.StdEncoding.EncodeToString(data))
+}
@@ -0,0 +1,135 @@
+package server
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	"gateway/internal/testsupport"
+	"gateway/payments"
+
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+// x402Challenge fetches the 402 for a summary as a standard client reads it.
+func x402Challenge(t *testing.T, h *testsupport.Harness) (version int, accepts []X402Requirements) {
+	t.Helper()
+	var body struct {
+		X402Version int                `json:"x402Version"`
+		Accepts     []X402Requirements `json:"accepts"`
+	}
+	resp := h.Post(t, "/api/ai/summarize", `{"text":"hello"}`, "", "")
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		t.Fatal(err)
+	}
+	return body.X402Version, body.Accepts
+}
+
+// postX402 sends a summary paid with a standard X-PAYMENT header.
+func postX402(t *testing.T, h *testsupport.Harness, payment x402PaymentPayload) *http.Response {
+	t.Helper()
+	req, _ := http.NewRequest(http.MethodPost, h.Server.URL+"/api/ai/summarize", bytes.NewBufferString(`{"text":"hello"}`))
+	req.Header.Set("Content-Type", "application/json")
+	data, _ := json.Marshal(payment)
+	req.Header.Set("X-PAYMENT", base64.StdEncoding.EncodeToString(data))
+	resp, err := h.Server.Client().Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { resp.Body.Close() })
+	return resp
+}
+
+// exactPayment signs the requirements as the x402 reference client does,
+// with a random authorization nonce.
+func exactPayment(t *testing.T, req X402Requirements) x402PaymentPayload {
+	t.Helper()
+	key, _ := crypto.GenerateKey()
+	a := payments.TransferAuthorization{
+		From:        crypto.PubkeyToAddress(key.PublicKey).Hex(),
+		To:          req.PayTo,
+		Value:       req.MaxAmountRequired,
+		ValidAfter:  time.Now().Add(-time.Minute).Unix(),
+		ValidBefore: time.Now().Add(time.Hour).Unix(),
+		Nonce:       hexutil.Encode(crypto.Keccak256([]byte(t.Name() + time.Now().String()))),
+	}
+	id, _ := parseChainRef(req.Network)
+	domain := payments.TokenDomain{Name: req.Extra.Name, Version: req.Extra.Version, ChainID: id, VerifyingContract: req.Asset}
+	sig, err := payments.SignTransferAuthorization(domain, a, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return x402PaymentPayload{X402Version: x402Version, Scheme: req.Scheme, Network: req.Network,
+		Payload: payments.ERC3009Payload{Signature: sig, Authorization: a}}
+}
+
+func TestX402_StandardClientPays(t *testing.T) {
+	t.Setenv("SIGNATURE_TYPES", "eip712,erc3009")
+	h := testsupport.NewHarness(t, newTestRouter)
+	version, accepts := x402Challenge(t, h)
+	if version != x402Version || len(accepts) == 0 || accepts[0].Scheme != x402SchemeExact || accepts[0].Network == "" || accepts[0].MaxAmountRequired == "" {
+		t.Fatalf("expected exact-scheme payment requirements, got %d %+v", version, accepts)
+	}
+
+	payment := exactPayment(t, accepts[0])
+	resp := postX402(t, h, payment)
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected 200, got %d %+v", resp.StatusCode, decodeErrorBody(t, resp))
+	}
+	raw, _ := base64.StdEncoding.DecodeString(resp.Header.Get("X-PAYMENT-RESPONSE"))
+	var settled struct {
+		Success bool   `json:"success"`
+		Network string `json:"network"`
+		Payer   string `json:"payer"`
+	}
+	json.Unmarshal(raw, &settled)
+	from := payment.Payload.Authorization.From
+	if !settled.Success || settled.Network != accepts[0].Network || settled.Payer != from {
+		t.Errorf("unexpected X-PAYMENT-RESPONSE: %s", raw)
+	}
+	receipt := decodeReceiptHeader(t, resp.Header.Get("X-402-Receipt")).Receipt
+	if receipt.Payment.Payer != from || receipt.Payment.Nonce != payment.Payload.Authorization.Nonce {
+		t.Errorf("expected the authorization's signer and nonce in the receipt, got %+v", receipt.Payment)
+	}
+
+	if body := decodeErrorBody(t, postX402(t, h, payment)); body.Code != CodeNonceReplayed {
+		t.Errorf("expected the authorization to pay once, got %+v", body)
+	}
+}
+
+func TestX402_RejectsUnofferedPayments(t *testing.T) {
+	t.Setenv("SIGNATURE_TYPES", "eip712,erc3009")
+	h := testsupport.NewHarness(t, newTestRouter)
+	_, accepts := x402Challenge(t, h)
+
+	other := exactPayment(t, accepts[0])
+	other.Network = "solana"
+	resp := postX402(t, h, other)
+	if body := decodeErrorBody(t, resp); resp.StatusCode != http.StatusPaymentRequired || body.Code != CodeChainUnsupported {
+		t.Errorf("expected 402 for an unaccepted network, got %d %+v", resp.StatusCode, body)
+	}
+
+	t.Setenv("NONCE_TTL_SECONDS", "600")
+	if resp := postX402(t, h, exactPayment(t, accepts[0])); resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("expected 400 for an authorization valid longer than the nonce TTL, got %d", resp.StatusCode)
+	}
+	t.Setenv("NONCE_TTL_SECONDS", "86400")
+
+	upto := exactPayment(t, accepts[0])
+	upto.Scheme = "upto"
+	if resp := postX402(t, h, upto); resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("expected 400 for an unsupported scheme, got %d", resp.StatusCode)
+	}
+	if h.AI.Calls() != 0 {
+		t.Errorf("expected no AI calls, got %d", h.AI.Calls())
+	}
+
+	t.Setenv("SIGNATURE_TYPES", "eip712")
+	if _, accepts := x402Challenge(t, h); len(accepts) == 0 || accepts[0].Scheme != "" {
+		t.Errorf("expected no x402 requirements without erc3009, got %+v", accepts)
+	}
+}
@@ -0,0 +1,100 @@
+// Package signing abstracts the server's secp256k1 key, so receipts, price
+// quotes, refund vouchers and response signatures can be made by a key held
+// in a KMS or HSM as well as by one loaded into memory.
+package signing
+
+import (
+	"crypto/ecdsa"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"fmt"
+	"math/big"
+
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+// Signer signs with the server key.
+type Signer interface {
+	// Public returns the public key signatures verify against.
+	Public() *ecdsa.PublicKey
+	// SignHash signs a 32-byte digest and returns the 65-byte [R || S || V]
+	// signature, with V 0 or 1 and S in the lower half of the curve order,
+	// as crypto.Sign does.
+	SignHash(digest []byte) ([]byte, error)
+}
+
+// keySigner signs with an in-memory private key.
+type keySigner struct {
+	key *ecdsa.PrivateKey
+}
+
+// FromKey returns a Signer for key, or nil when key is nil.
+func FromKey(key *ecdsa.PrivateKey) Signer {
+	if key == nil {
+		return nil
+	}
+	return keySigner{key: key}
+}
+
+func (s keySigner) Public() *ecdsa.PublicKey { return &s.key.PublicKey }
+
+func (s keySigner) SignHash(digest []byte) ([]byte, error) {
+	// crypto.Sign uses go-ethereum's constant-time secp256k1 implementation.
+	return crypto.Sign(digest, s.key)
+}
+
+// secp256k1N is the order of the secp256k1 curve.
+var (
+	secp256k1N     = crypto.S256().Params().N
+	secp256k1HalfN = new(big.Int).Rsh(secp256k1N, 1)
+)
+
+// FromDER converts an ASN.1 DER ECDSA signature over digest by pub, as KMS
+// services return them, into the [R || S || V] form SignHash returns: S is
+// normalized to the lower half of the curve order and V is found by
+// recovering pub.
+func FromDER(der, digest []byte, pub *ecdsa.PublicKey) ([]byte, error) {
+	var rs struct{ R, S *big.Int }
+	rest, err := asn1.Unmarshal(der, &rs)
+	if err != nil {
+		return nil, fmt.Errorf("invalid DER signature: %w", err)
+	}
+	if len(rest) > 0 || rs.R.Sign() <= 0 || rs.S.Sign() <= 0 || rs.R.Cmp(secp256k1N) >= 0 || rs.S.Cmp(secp256k1N) >= 0 {
+		return nil, fmt.Errorf("invalid DER signature")
+	}
+	if rs.S.Cmp(secp256k1HalfN) > 0 {
+		rs.S.Sub(secp256k1N, rs.S)
+	}
+	sig := make([]byte, crypto.SignatureLength)
+	rs.R.FillBytes(sig[:32])
+	rs.S.FillBytes(sig[32:64])
+	want := crypto.FromECDSAPub(pub)
+	for v := byte(0); v < 2; v++ {
+		sig[crypto.RecoveryIDOffset] = v
+		if got, err := crypto.Ecrecover(digest, sig); err == nil && string(got) == string(want) {
+			return sig, nil
+		}
+	}
+	return nil, fmt.Errorf("signature does not recover to the signing key")
+}
+
+// ParsePublicKey parses a DER SubjectPublicKeyInfo holding a secp256k1 key,
+// the form KMS services publish public keys in. crypto/x509 does not know
+// the curve, so the structure is decoded by hand.
+func ParsePublicKey(spki []byte) (*ecdsa.PublicKey, error) {
+	var info struct {
+		Algorithm pkix.AlgorithmIdentifier
+		PublicKey asn1.BitString
+	}
+	if _, err := asn1.Unmarshal(spki, &info); err != nil {
+		return nil, fmt.Errorf("invalid public key: %w", err)
+	}
+	var curve asn1.ObjectIdentifier
+	if _, err := asn1.Unmarshal(info.Algorithm.Parameters.FullBytes, &curve); err != nil || !curve.Equal(oidSecp256k1) {
+		return nil, fmt.Errorf("public key is not on secp256k1")
+	}
+	return crypto.UnmarshalPubkey(info.PublicKey.Bytes)
+}
+
+// oidSecp256k1 identifies the secp256k1 curve in a SubjectPublicKeyInfo.
+var oidSecp256k1 = asn1.ObjectIdentifier{1, 3, 132, 0, 10}
@@ -0,0 +1,73 @@
+package signing
+
+import (
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"math/big"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+func TestFromDER_NormalizesAndRecovers(t *testing.T) {
+	key, _ := crypto.GenerateKey()
+	digest := crypto.Keccak256([]byte("receipt"))
+	want, err := FromKey(key).SignHash(digest)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r := new(big.Int).SetBytes(want[:32])
+	s := new(big.Int).SetBytes(want[32:64])
+	// KMS services may return either S; both must give the canonical form.
+	for _, candidate := range []*big.Int{s, new(big.Int).Sub(secp256k1N, s)} {
+		der, _ := asn1.Marshal(struct{ R, S *big.Int }{r, candidate})
+		got, err := FromDER(der, digest, &key.PublicKey)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(got) != string(want) {
+			t.Errorf("got %x, want %x", got, want)
+		}
+	}
+
+	other, _ := crypto.GenerateKey()
+	der, _ := asn1.Marshal(struct{ R, S *big.Int }{r, s})
+	if _, err := FromDER(der, digest, &other.PublicKey); err == nil {
+		t.Error("expected a signature by another key to be refused")
+	}
+	if _, err := FromDER([]byte("junk"), digest, &key.PublicKey); err == nil {
+		t.Error("expected malformed DER to be refused")
+	}
+}
+
+func TestParsePublicKey(t *testing.T) {
+	key, _ := crypto.GenerateKey()
+	params, _ := asn1.Marshal(oidSecp256k1)
+	spki, _ := asn1.Marshal(struct {
+		Algorithm pkix.AlgorithmIdentifier
+		PublicKey asn1.BitString
+	}{
+		Algorithm: pkix.AlgorithmIdentifier{Algorithm: asn1.ObjectIdentifier{1, 2, 840, 10045, 2, 1}, Parameters: asn1.RawValue{FullBytes: params}},
+		PublicKey: asn1.BitString{Bytes: crypto.FromECDSAPub(&key.PublicKey), BitLength: 65 * 8},
+	})
+	pub, err := ParsePublicKey(spki)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if crypto.PubkeyToAddress(*pub) != crypto.PubkeyToAddress(key.PublicKey) {
+		t.Error("parsed a different key")
+	}
+
+	p256, _ := asn1.Marshal(asn1.ObjectIdentifier{1, 2, 840, 10045, 3, 1, 7})
+	spki, _ = asn1.Marshal(struct {
+		Algorithm pkix.AlgorithmIdentifier
+		PublicKey asn1.BitString
+	}{
+		Algorithm: pkix.AlgorithmIdentifier{Algorithm: asn1.ObjectIdentifier{1, 2, 840, 10045, 2, 1}, Parameters: asn1.RawValue{FullBytes: p256}},
+		PublicKey: asn1.BitString{Bytes: crypto.FromECDSAPub(&key.PublicKey), BitLength: 65 * 8},
+	})
+	if _, err := ParsePublicKey(spki); err == nil {
+		t.Error("expected a P-256 key to be refused")
+	}
+}
@@ -27,7 +27,7 @@ VERIFIER_PID=$!
 
 echo "Starting Gateway..."
 cd "$SCRIPT_DIR/gateway"
-go run . &
+go run ./cmd/gateway &
 GATEWAY_PID=$!
 
 # Wait for services to be ready
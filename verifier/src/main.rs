@@ -1,21 +1,41 @@
 use axum::{
-    extract::Json,
+    body::{to_bytes, Body},
+    extract::{Json, Request, State},
     http::{HeaderMap, StatusCode}, // VIBE FIX: Added HeaderMap to read headers
+    middleware::{self, Next},
+    response::{IntoResponse, Response},
     routing::{get, post},
     Router,
 };
 use ethers::types::transaction::eip712::TypedData;
 use ethers::types::Signature;
+use hmac::{Hmac, Mac};
 use serde::{Deserialize, Serialize};
+use sha2::Sha256;
+use std::collections::HashMap;
 use std::net::SocketAddr;
 use std::str::FromStr;
+use std::sync::{Arc, Mutex};
+use std::time::{SystemTime, UNIX_EPOCH};
 
 #[tokio::main]
 async fn main() {
+    // Require gateway request signatures when a shared secret is configured
+    let verify = match GatewayAuth::from_env() {
+        Some(auth) => post(verify_signature).layer(middleware::from_fn_with_state(
+            auth,
+            require_gateway_signature,
+        )),
+        None => {
+            println!("WARNING: VERIFIER_SHARED_SECRET is not set; /verify accepts unauthenticated requests");
+            post(verify_signature)
+        }
+    };
+
     // build our application with a route
     let app = Router::new()
         .route("/health", get(health))
-        .route("/verify", post(verify_signature));
+        .route("/verify", verify);
 
     // run it
     let addr = SocketAddr::from(([0, 0, 0, 0], 3002));
@@ -28,6 +48,147 @@ async fn health() -> &'static str {
     "Rust Verifier OK"
 }
 
+type HmacSha256 = Hmac<Sha256>;
+
+// Headers the gateway authenticates verify requests with; see
+// gateway/payments/auth.go for the signing side.
+const HEADER_TIMESTAMP: &str = "X-Gateway-Timestamp";
+const HEADER_REQUEST_ID: &str = "X-Gateway-Request-Id";
+const HEADER_SIGNATURE: &str = "X-Gateway-Signature";
+
+// Largest verify request body read while authenticating.
+const MAX_BODY_BYTES: usize = 64 * 1024;
+
+/// Authenticates gateway requests signed with a shared secret: the HMAC-SHA256
+/// of "v1\n{timestamp}\n{request id}\n{method}\n{path}\n{body}". Requests
+/// outside the allowed clock skew are rejected, and request IDs are
+/// remembered for that window so a captured request cannot be replayed.
+struct GatewayAuth {
+    secret: Vec<u8>,
+    max_skew_secs: u64,
+    seen: Mutex<HashMap<String, u64>>,
+}
+
+impl GatewayAuth {
+    /// Reads VERIFIER_SHARED_SECRET and VERIFIER_AUTH_MAX_SKEW_SECONDS
+    /// (default 30). Returns None when no secret is set.
+    fn from_env() -> Option<Arc<GatewayAuth>> {
+        let secret = std::env::var("VERIFIER_SHARED_SECRET")
+            .ok()
+            .filter(|s| !s.is_empty())?;
+        let max_skew_secs = std::env::var("VERIFIER_AUTH_MAX_SKEW_SECONDS")
+            .ok()
+            .and_then(|v| v.parse().ok())
+            .filter(|v| *v > 0)
+            .unwrap_or(30);
+        Some(Arc::new(GatewayAuth::new(
+            secret.into_bytes(),
+            max_skew_secs,
+        )))
+    }
+
+    fn new(secret: Vec<u8>, max_skew_secs: u64) -> Self {
+        GatewayAuth {
+            secret,
+            max_skew_secs,
+            seen: Mutex::new(HashMap::new()),
+        }
+    }
+
+    /// Checks the signature headers of one request at unix time `now`.
+    fn check(
+        &self,
+        headers: &HeaderMap,
+        method: &str,
+        path: &str,
+        body: &[u8],
+        now: u64,
+    ) -> Result<(), &'static str> {
+        let header = |name: &str| {
+            headers
+                .get(name)
+                .and_then(|v| v.to_str().ok())
+                .unwrap_or("")
+        };
+        let timestamp = header(HEADER_TIMESTAMP);
+        let request_id = header(HEADER_REQUEST_ID);
+        let signature = header(HEADER_SIGNATURE);
+        if timestamp.is_empty() || request_id.is_empty() || signature.is_empty() {
+            return Err("missing gateway signature headers");
+        }
+
+        let ts: u64 = timestamp.parse().map_err(|_| "invalid gateway timestamp")?;
+        if ts.abs_diff(now) > self.max_skew_secs {
+            return Err("gateway timestamp outside the allowed window");
+        }
+        let signature = hex::decode(signature).map_err(|_| "invalid gateway signature")?;
+        request_mac(&self.secret, timestamp, request_id, method, path, body)
+            .verify_slice(&signature)
+            .map_err(|_| "invalid gateway signature")?;
+
+        // Only IDs that can still pass the timestamp check need remembering
+        let mut seen = self.seen.lock().unwrap();
+        seen.retain(|_, seen_ts| seen_ts.abs_diff(now) <= self.max_skew_secs);
+        if seen.insert(request_id.to_string(), ts).is_some() {
+            return Err("replayed gateway request");
+        }
+        Ok(())
+    }
+}
+
+/// HMAC of a request's signing payload.
+fn request_mac(
+    secret: &[u8],
+    timestamp: &str,
+    request_id: &str,
+    method: &str,
+    path: &str,
+    body: &[u8],
+) -> HmacSha256 {
+    let mut mac = HmacSha256::new_from_slice(secret).expect("HMAC accepts keys of any length");
+    mac.update(format!("v1\n{}\n{}\n{}\n{}\n", timestamp, request_id, method, path).as_bytes());
+    mac.update(body);
+    mac
+}
+
+/// Middleware answering 401 to verify requests the gateway did not sign.
+async fn require_gateway_signature(
+    State(auth): State<Arc<GatewayAuth>>,
+    request: Request,
+    next: Next,
+) -> Response {
+    let (parts, body) = request.into_parts();
+    let bytes = match to_bytes(body, MAX_BODY_BYTES).await {
+        Ok(bytes) => bytes,
+        Err(_) => return StatusCode::PAYLOAD_TOO_LARGE.into_response(),
+    };
+    let now = SystemTime::now()
+        .duration_since(UNIX_EPOCH)
+        .map(|d| d.as_secs())
+        .unwrap_or(0);
+
+    if let Err(reason) = auth.check(
+        &parts.headers,
+        parts.method.as_str(),
+        parts.uri.path(),
+        &bytes,
+        now,
+    ) {
+        println!("Rejected unauthenticated verify request: {}", reason);
+        return (
+            StatusCode::UNAUTHORIZED,
+            Json(VerifyResponse {
+                is_valid: false,
+                recovered_address: None,
+                error: Some(reason.to_string()),
+            }),
+        )
+            .into_response();
+    }
+    next.run(Request::from_parts(parts, Body::from(bytes)))
+        .await
+}
+
 #[derive(Deserialize, Debug)]
 struct VerifyRequest {
     context: PaymentContext,
@@ -410,6 +571,72 @@ mod tests {
         );
     }
 
+    // ============================================================
+    // Gateway Authentication Tests
+    // ============================================================
+
+    fn signed_headers(secret: &[u8], timestamp: u64, request_id: &str, body: &[u8]) -> HeaderMap {
+        let timestamp = timestamp.to_string();
+        let mac = request_mac(secret, &timestamp, request_id, "POST", "/verify", body);
+        let mut headers = HeaderMap::new();
+        headers.insert(HEADER_TIMESTAMP, timestamp.parse().unwrap());
+        headers.insert(HEADER_REQUEST_ID, request_id.parse().unwrap());
+        headers.insert(
+            HEADER_SIGNATURE,
+            hex::encode(mac.finalize().into_bytes()).parse().unwrap(),
+        );
+        headers
+    }
+
+    #[test]
+    fn test_gateway_auth_accepts_signed_request_once() {
+        let auth = GatewayAuth::new(b"shared-secret".to_vec(), 30);
+        let body = br#"{"signature":"0xsig"}"#;
+        let headers = signed_headers(b"shared-secret", 1_900_000_000, "req-1", body);
+
+        assert_eq!(
+            auth.check(&headers, "POST", "/verify", body, 1_900_000_010),
+            Ok(())
+        );
+        assert_eq!(
+            auth.check(&headers, "POST", "/verify", body, 1_900_000_011),
+            Err("replayed gateway request")
+        );
+    }
+
+    #[test]
+    fn test_gateway_auth_rejects_forged_stale_and_unsigned_requests() {
+        let auth = GatewayAuth::new(b"shared-secret".to_vec(), 30);
+        let body = br#"{"signature":"0xsig"}"#;
+        let now = 1_900_000_000;
+
+        let forged = signed_headers(b"other-secret", now, "req-1", body);
+        assert_eq!(
+            auth.check(&forged, "POST", "/verify", body, now),
+            Err("invalid gateway signature")
+        );
+
+        let signed = signed_headers(b"shared-secret", now, "req-2", body);
+        assert_eq!(
+            auth.check(
+                &signed,
+                "POST",
+                "/verify",
+                br#"{"signature":"0xother"}"#,
+                now
+            ),
+            Err("invalid gateway signature")
+        );
+        assert_eq!(
+            auth.check(&signed, "POST", "/verify", body, now + 31),
+            Err("gateway timestamp outside the allowed window")
+        );
+        assert_eq!(
+            auth.check(&HeaderMap::new(), "POST", "/verify", body, now),
+            Err("missing gateway signature headers")
+        );
+    }
+
     #[tokio::test]
     async fn test_correlation_id_uuid_format() {
         // Test that UUID-formatted correlation IDs are properly handled